@@ -9,9 +9,11 @@ import (
 	"github.com/buraksezer/olric"
 	olricConfig "github.com/buraksezer/olric/config"
 	"github.com/daptin/daptin/server/auth"
+	"github.com/daptin/daptin/server/database"
 	"github.com/daptin/daptin/server/hostswitch"
 	daptinid "github.com/daptin/daptin/server/id"
 	"github.com/daptin/daptin/server/task_scheduler"
+	"github.com/daptin/daptin/server/telemetry"
 	server2 "github.com/fclairamb/ftpserver/server"
 	"github.com/go-redis/redis/v8"
 	jsoniter "github.com/json-iterator/go"
@@ -218,12 +220,20 @@ func main() {
 	var olricPort = flag.Int("olric_port", 0, "base port for olric cache (membership = olric_port+1). Default: auto-derived from HTTP port")
 	var olricSeed = flag.String("olric_seed", "", "hostname to resolve for cluster peer discovery (e.g., daptin-headless.default.svc.cluster.local)")
 	var olricConfigEnv = flag.String("olric_env", "local", "env value for olric: local/lan/wan, default: lan")
+	var replicaConnectionStrings = flag.String("db_replica_connection_strings", "", "read replica connection strings, separated by ;")
+	var replicaMaxLag = flag.Duration("db_replica_max_lag", 10*time.Second, "replicas further behind the primary are not read from")
+	var replicaCheckInterval = flag.Duration("db_replica_check_interval", 5*time.Second, "time between two health checks of the read replicas")
+	var backupIncludeFiles = flag.Bool("backup_include_files", true, "backup mode: add the files of local cloud stores to the backup")
+	var backupEncryptionKey = flag.String("backup_encryption_key", "", "backup and restore modes: key the backup is encrypted with, backup mode falls back to the backup.encryption.key config")
 
 	envy.Parse("DAPTIN") // looks for DAPTIN_PORT, DAPTIN_DASHBOARD, DAPTIN_DB_TYPE, DAPTIN_RUNTIME
 	flag.Parse()
 
 	printVersion()
 
+	shutdownTracing := telemetry.InitTracing(context.Background(), Version)
+	defer shutdownTracing(context.Background())
+
 	logLevelParsed, err := log.ParseLevel(*logLevel)
 	if err != nil {
 		log.Errorf("invalid log level: %s, setting to info", *logLevel)
@@ -327,6 +337,34 @@ func main() {
 	_ = transaction.Rollback()
 	log.Printf("connection acquired from database [%s]", *dbType)
 
+	// daptin backup [file] and daptin restore <file> run against the database and exit
+	switch flag.Arg(0) {
+	case "backup":
+		if err = server.RunBackupCommand(db, flag.Arg(1), *backupIncludeFiles, *backupEncryptionKey); err != nil {
+			log.Fatalf("Backup failed: %v", err)
+		}
+		return
+	case "restore":
+		if flag.Arg(1) == "" {
+			log.Fatalf("Usage: daptin [flags] restore <backup file>")
+		}
+		if err = server.RunRestoreCommand(db, flag.Arg(1), *backupEncryptionKey); err != nil {
+			log.Fatalf("Restore failed: %v", err)
+		}
+		return
+	}
+
+	// reads of api requests go to the replicas, everything else uses the primary
+	var connection database.DatabaseConnection = db
+	if *replicaConnectionStrings != "" {
+		replicaSet, err := server.GetReplicaSet(*dbType, db, *replicaConnectionStrings, *replicaMaxLag)
+		if err != nil {
+			panic(err)
+		}
+		replicaSet.StartHealthChecks(*replicaCheckInterval)
+		connection = replicaSet
+	}
+
 	portValue := *port
 	portInt := int64(6336)
 	if strings.Index(portValue, ".") > -1 {
@@ -348,6 +386,7 @@ func main() {
 	var certManager *resource.CertificateManager
 	var configStore *resource.ConfigStore
 	var ftpServer *server2.FtpServer
+	var sftpServer *server.DaptinSftpServer
 	var imapServerInstance *imapServer.Server
 	var olricDb *olric.EmbeddedClient
 
@@ -476,7 +515,7 @@ func main() {
 	}()
 
 	hostSwitch, mailDaemon, taskScheduler, configStore, certManager,
-		ftpServer, imapServerInstance, olricDb = server.Main(boxRoot, db, *localStoragePath, olricDb, localOlricAddr)
+		ftpServer, sftpServer, imapServerInstance, olricDb = server.Main(boxRoot, connection, *localStoragePath, olricDb, localOlricAddr)
 	rhs := RestartHandlerServer{
 		HostSwitch: &hostSwitch,
 	}
@@ -528,6 +567,9 @@ func main() {
 		if ftpServer != nil {
 			ftpServer.Stop()
 		}
+		if sftpServer != nil {
+			sftpServer.Stop()
+		}
 
 		if mailDaemon != nil {
 			mailDaemon.Shutdown()
@@ -556,7 +598,7 @@ func main() {
 		log.Printf("connection acquired from database [%s]", *dbType)
 
 		hostSwitch, mailDaemon, taskScheduler, configStore, certManager,
-			ftpServer, imapServerInstance, olricDb = server.Main(boxRoot, db, *localStoragePath, olricDb, localOlricAddr)
+			ftpServer, sftpServer, imapServerInstance, olricDb = server.Main(boxRoot, connection, *localStoragePath, olricDb, localOlricAddr)
 		rhs.HostSwitch = &hostSwitch
 
 		secondsToRestart := float64(time.Now().UnixNano()-startTime.UnixNano()) / float64(1000000000)
@@ -12,7 +12,7 @@ export GOPATH=/media/artpar/ddrive/workspace/newgocode
 rm -rf rice-box.go
 rice embed-go
 CGO_ENABLED=1
-go build  -ldflags '-linkmode external -extldflags -static -w' main.go
+go build -tags sqlite_fts5 -ldflags '-linkmode external -extldflags -static -w' main.go
 rice append --exec main
 
 rm -rf docker_dir
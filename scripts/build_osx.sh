@@ -13,7 +13,7 @@ rm -rf rice-box.go
 
 docker run --rm -v "$PWD":/usr/src/myapp -w /usr/src/myapp golang:1.8 go get
 docker run --rm -v "$PWD":/usr/src/myapp -w /usr/src/myapp golang:1.8 rice embed-go
-docker run --rm -v "$PWD":/usr/src/myapp -w /usr/src/myapp golang:1.8 go build -v -ldflags '-linkmode external -extldflags -static -w'
+docker run --rm -v "$PWD":/usr/src/myapp -w /usr/src/myapp golang:1.8 go build -v -tags sqlite_fts5 -ldflags '-linkmode external -extldflags -static -w'
 
 rice append --exec main
 
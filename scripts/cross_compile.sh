@@ -1,3 +1,3 @@
 #!/usr/bin/env bash
 
-xgo -ldflags='-extldflags "-static"' --targets=linux/*,darwin/*,windows-6.0/* .
+xgo --tags sqlite_fts5 -ldflags='-extldflags "-static"' --targets=linux/*,darwin/*,windows-6.0/* .
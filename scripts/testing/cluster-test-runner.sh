@@ -140,9 +140,9 @@ kill_cluster() {
     done
 
     # Kill by process name (cluster nodes use specific port flags)
-    pkill -9 -f "go run main.go.*-port :$NODE1_HTTP" 2>/dev/null || true
-    pkill -9 -f "go run main.go.*-port :$NODE2_HTTP" 2>/dev/null || true
-    pkill -9 -f "go run main.go.*-port :$NODE3_HTTP" 2>/dev/null || true
+    pkill -9 -f "go run.*main.go.*-port :$NODE1_HTTP" 2>/dev/null || true
+    pkill -9 -f "go run.*main.go.*-port :$NODE2_HTTP" 2>/dev/null || true
+    pkill -9 -f "go run.*main.go.*-port :$NODE3_HTTP" 2>/dev/null || true
 
     sleep 2
 }
@@ -187,7 +187,7 @@ start_node() {
     log "Starting Node $node_num (HTTP=$http_port, Olric=$olric_port, Member=$member_port)..."
 
     cd "$DAPTIN_DIR"
-    nohup go run main.go \
+    nohup go run -tags sqlite_fts5 main.go \
         -port ":$http_port" \
         -db_type postgres \
         -db_connection_string "$PG_CONN" \
@@ -63,6 +63,18 @@ func GetActionPerformers(initConfig *resource.CmsConfig, configStore *resource.C
 	resource.CheckErr(err, "Failed to create data import performer")
 	performers = append(performers, importDataPerformer)
 
+	systemBackupPerformer, err := actions.NewSystemBackupPerformer(cruds)
+	resource.CheckErr(err, "Failed to create system backup performer")
+	performers = append(performers, systemBackupPerformer)
+
+	systemRestorePerformer, err := actions.NewSystemRestorePerformer(cruds)
+	resource.CheckErr(err, "Failed to create system restore performer")
+	performers = append(performers, systemRestorePerformer)
+
+	downloadImportErrorsPerformer, err := actions.NewDownloadImportErrorsPerformer(cruds)
+	resource.CheckErr(err, "Failed to create import errors download performer")
+	performers = append(performers, downloadImportErrorsPerformer)
+
 	oauth2redirect, err := actions.NewOauthLoginBeginActionPerformer(initConfig, cruds, configStore, transaction)
 	resource.CheckErr(err, "Failed to create oauth2 request performer")
 	performers = append(performers, oauth2redirect)
@@ -260,6 +272,30 @@ func GetActionPerformers(initConfig *resource.CmsConfig, configStore *resource.C
 	resource.CheckErr(err, "Failed to create publish to topic performer")
 	performers = append(performers, publishToTopicPerformer)
 
+	rowRestorePerformer, err := actions.NewRowRestorePerformer(cruds)
+	resource.CheckErr(err, "Failed to create row restore performer")
+	performers = append(performers, rowRestorePerformer)
+
+	softDeletePurgePerformer, err := actions.NewSoftDeletePurgePerformer(cruds)
+	resource.CheckErr(err, "Failed to create soft delete purge performer")
+	performers = append(performers, softDeletePurgePerformer)
+
+	stateTimersPerformer, err := actions.NewStateTimersFirePerformer(cruds)
+	resource.CheckErr(err, "Failed to create state timers performer")
+	performers = append(performers, stateTimersPerformer)
+
+	rollupRefreshPerformer, err := actions.NewRollupRefreshPerformer(cruds)
+	resource.CheckErr(err, "Failed to create rollup refresh performer")
+	performers = append(performers, rollupRefreshPerformer)
+
+	rowRevertPerformer, err := actions.NewRowRevertPerformer(cruds)
+	resource.CheckErr(err, "Failed to create row revert performer")
+	performers = append(performers, rowRevertPerformer)
+
+	rollupColumnsRecomputePerformer, err := actions.NewRollupColumnsRecomputePerformer(cruds)
+	resource.CheckErr(err, "Failed to create rollup columns recompute performer")
+	performers = append(performers, rollupColumnsRecomputePerformer)
+
 	log.Tracef("Completed GetActionPerformers")
 
 	for _, performer := range performers {
@@ -81,6 +81,22 @@ func unzip(archive, target string) error {
 	return nil
 }
 
+// copyToFile writes what is read from reader to a new file at filePath
+func copyToFile(filePath string, reader io.Reader) error {
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		return err
+	}
+	targetFile, err := os.OpenFile(filePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(targetFile, reader); err != nil {
+		targetFile.Close()
+		return err
+	}
+	return targetFile.Close()
+}
+
 var cleanupmux = sync2.Mutex{}
 var cleanuppath = make(map[string]bool)
 
@@ -116,6 +132,15 @@ func (actionPerformer *fileUploadActionPerformer) DoAction(request actionrespons
 			}
 			temproryFilePath := filepath.Join(tempDirectoryPath, fileName)
 
+			// files handed over by the server, like the writes over webdav and sftp, are streamed to
+			// the temp folder and stored as they are. A reader cannot come from a request body.
+			if reader, ok := file["reader"].(io.Reader); ok {
+				log.Infof("[116] Write file [%v] for upload", temproryFilePath)
+				err = copyToFile(temproryFilePath, reader)
+				resource.CheckErr(err, "[122] Failed to write file to temp file for rclone upload")
+				continue
+			}
+
 			fileContentsBase64, ok := file["file"].(string)
 			if !ok {
 				fileContentsBase64, ok = file["contents"].(string)
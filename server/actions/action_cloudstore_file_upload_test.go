@@ -0,0 +1,46 @@
+package actions
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/daptin/daptin/server/actionresponse"
+)
+
+func TestFileUploadStoresAReaderAsItIs(t *testing.T) {
+	t.Setenv("DAPTIN_CACHE_FOLDER", t.TempDir())
+	storeRoot := t.TempDir()
+	contents := strings.Repeat("daptin", 100000)
+
+	performer, err := NewFileUploadActionPerformer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, errs := performer.DoAction(actionresponse.Outcome{}, map[string]interface{}{
+		"root_path": storeRoot,
+		"path":      "reports/2026",
+		"file": []interface{}{
+			map[string]interface{}{"name": "summary.zip", "reader": strings.NewReader(contents)},
+		},
+	}, nil)
+	if len(errs) > 0 {
+		t.Fatalf("upload: %v", errs)
+	}
+
+	// the copy to the store runs in the background
+	uploadedPath := filepath.Join(storeRoot, "reports", "2026", "summary.zip")
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		uploaded, err := os.ReadFile(uploadedPath)
+		if err == nil && string(uploaded) == contents {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the file in the store as it was written, got %d bytes: %v", len(uploaded), err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
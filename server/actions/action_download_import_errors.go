@@ -0,0 +1,55 @@
+package actions
+
+import (
+	"encoding/base64"
+	"fmt"
+
+	"github.com/artpar/api2go/v2"
+	"github.com/daptin/daptin/server/actionresponse"
+	"github.com/daptin/daptin/server/resource"
+	"github.com/jmoiron/sqlx"
+)
+
+// downloadImportErrorsPerformer downloads the rows an import could not write as csv
+type downloadImportErrorsPerformer struct {
+	cruds map[string]*resource.DbResource
+}
+
+// Name returns the name of this action
+func (d *downloadImportErrorsPerformer) Name() string {
+	return "__import_errors_download"
+}
+
+// DoAction returns the error report of the import job as a file download
+func (d *downloadImportErrorsPerformer) DoAction(request actionresponse.Outcome, inFields map[string]interface{}, transaction *sqlx.Tx) (api2go.Responder, []actionresponse.ActionResponse, []error) {
+	responses := make([]actionresponse.ActionResponse, 0)
+
+	errorReport, _ := inFields["error_report"].(string)
+	if errorReport == "" {
+		actionResponse := resource.NewActionResponse("client.notify", resource.NewClientNotification("message", "No rows failed in this import", "Nothing to download"))
+		return nil, append(responses, actionResponse), nil
+	}
+
+	name := "import"
+	if tableName, ok := inFields["table_name"].(string); ok && tableName != "" {
+		name = tableName
+	}
+
+	responseAttrs := make(map[string]interface{})
+	responseAttrs["content"] = base64.StdEncoding.EncodeToString([]byte(errorReport))
+	responseAttrs["name"] = fmt.Sprintf("daptin_%v_import_errors.csv", name)
+	responseAttrs["contentType"] = "text/csv"
+	responseAttrs["message"] = "Downloading import errors"
+
+	responses = append(responses, resource.NewActionResponse("client.file.download", responseAttrs))
+	return nil, responses, nil
+}
+
+// NewDownloadImportErrorsPerformer creates the performer of download_import_errors
+func NewDownloadImportErrorsPerformer(cruds map[string]*resource.DbResource) (actionresponse.ActionPerformerInterface, error) {
+	handler := downloadImportErrorsPerformer{
+		cruds: cruds,
+	}
+
+	return &handler, nil
+}
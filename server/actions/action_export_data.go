@@ -1,13 +1,28 @@
 package actions
 
 import (
+	"bytes"
+	"context"
 	"encoding/base64"
 	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/artpar/api2go/v2"
 	"github.com/daptin/daptin/server/actionresponse"
+	"github.com/daptin/daptin/server/auth"
+	"github.com/daptin/daptin/server/database"
+	daptinid "github.com/daptin/daptin/server/id"
 	"github.com/daptin/daptin/server/resource"
+	"github.com/daptin/daptin/server/rootpojo"
+	"github.com/daptin/daptin/server/statementbuilder"
+	"github.com/daptin/daptin/server/tenant"
+	"github.com/doug-martin/goqu/v9"
+	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
 	log "github.com/sirupsen/logrus"
 )
@@ -26,14 +41,35 @@ const (
 	FormatPDF ExportFormat = "pdf"
 	// FormatHTML exports data as HTML (HTML document)
 	FormatHTML ExportFormat = "html"
+	// FormatNDJSON exports a table as newline delimited JSON, one object per line
+	FormatNDJSON ExportFormat = "ndjson"
+	// FormatParquet exports a table as an Apache Parquet file
+	FormatParquet ExportFormat = "parquet"
 )
 
+// the export_job table tracks exports written to a cloud store
+const exportJobTable = "export_job"
+
 // exportDataPerformer handles data export in various formats
 type exportDataPerformer struct {
 	cmsConfig *resource.CmsConfig
 	cruds     map[string]*resource.DbResource
 }
 
+// exportRequest is what to export, read page by page by runExport
+type exportRequest struct {
+	format          ExportFormat
+	tables          []string
+	stream          *resource.StreamProcessor
+	includeHeaders  bool
+	pageSize        int
+	selectedColumns map[string][]string
+	// query, filter and sort as accepted by PaginatedFindAll, rows are then read with the
+	// permissions of the user
+	queryParams  map[string][]string
+	plainRequest *http.Request
+}
+
 // Name returns the name of this action
 func (d *exportDataPerformer) Name() string {
 	return "__data_export"
@@ -56,6 +92,20 @@ func (d *exportDataPerformer) DoAction(request actionresponse.Outcome, inFields
 	tableName, tableOk := inFields["table_name"]
 	finalName := "complete"
 
+	// A stream is exported in place of a table, the stream transformations are applied to the rows
+	var streamProcessor *resource.StreamProcessor
+	if streamName, ok := inFields["stream_name"].(string); ok && streamName != "" {
+		for _, processor := range resource.NewStreamProcessors(d.cmsConfig.Streams, d.cruds) {
+			if processor.GetName() == streamName {
+				streamProcessor = processor
+			}
+		}
+		if streamProcessor == nil {
+			return nil, nil, []error{fmt.Errorf("unknown stream [%v]", streamName)}
+		}
+		tableName, tableOk = streamName, true
+	}
+
 	// Get additional export options
 	includeHeaders := true
 	if includeHeadersVal, ok := inFields["include_headers"]; ok && includeHeadersVal != nil {
@@ -64,10 +114,8 @@ func (d *exportDataPerformer) DoAction(request actionresponse.Outcome, inFields
 
 	// Get page size for pagination
 	pageSize := 1000 // Default page size
-	if pageSizeVal, ok := inFields["page_size"]; ok && pageSizeVal != nil {
-		if pageSizeInt, ok := pageSizeVal.(int); ok && pageSizeInt > 0 {
-			pageSize = pageSizeInt
-		}
+	if pageSizeInt, ok := toInt(inFields["page_size"]); ok && pageSizeInt > 0 {
+		pageSize = pageSizeInt
 	}
 
 	// Get specific columns to export if specified
@@ -106,13 +154,6 @@ func (d *exportDataPerformer) DoAction(request actionresponse.Outcome, inFields
 		}
 	}
 
-	// Create streaming writer for the selected format
-	writer, err := CreateStreamingExportWriter(format)
-	if err != nil {
-		log.Errorf("Failed to create streaming writer: %v", err)
-		return nil, nil, []error{err}
-	}
-
 	// Determine tables to export
 	var tablesToExport []string
 	if tableOk && tableName != nil {
@@ -125,16 +166,144 @@ func (d *exportDataPerformer) DoAction(request actionresponse.Outcome, inFields
 		}
 	}
 
+	// Rows are read as the user calling the action, on the tenant of the request, also by an
+	// export running after the request has completed
+	sessionUser, _ := inFields["sessionUser"].(*auth.SessionUser)
+	exportContext := context.WithValue(context.Background(), "user", sessionUser)
+	if httpRequest, ok := inFields["httpRequest"].(*http.Request); ok && httpRequest != nil {
+		exportContext = tenant.WithTenant(exportContext, tenant.FromContext(httpRequest.Context()))
+	}
+	plainRequest, _ := http.NewRequest("GET", "/api/"+finalName, nil)
+	plainRequest = plainRequest.WithContext(exportContext)
+
+	export := exportRequest{
+		format:          format,
+		tables:          tablesToExport,
+		stream:          streamProcessor,
+		includeHeaders:  includeHeaders,
+		pageSize:        pageSize,
+		selectedColumns: selectedColumnsMap,
+		queryParams:     exportQueryParams(inFields),
+		plainRequest:    plainRequest,
+	}
+
+	contentType, fileExtension := exportContentType(format)
+	fileName := fmt.Sprintf("daptin_export_%v.%s", finalName, fileExtension)
+
+	// Write the export to a cloud store, in the background if asked
+	cloudStoreId, _ := inFields["cloud_store_id"].(string)
+	background, _ := inFields["background"].(bool)
+	if background && cloudStoreId == "" {
+		return nil, nil, []error{fmt.Errorf("a background export needs a cloud_store_id to write the export to")}
+	}
+	if cloudStoreId != "" {
+		return d.exportToCloudStore(export, daptinid.InterfaceToDIR(cloudStoreId), fileName, finalName, inFields, sessionUser, transaction)
+	}
+
+	var content bytes.Buffer
+	_, err := d.runExport(&content, export, func(fn func(tx *sqlx.Tx) error) error {
+		return fn(transaction)
+	})
+	if err != nil {
+		log.Errorf("Failed to export [%v]: %v", finalName, err)
+		return nil, nil, []error{err}
+	}
+
+	// Create response with the exported data
+	responseAttrs := make(map[string]interface{})
+	responseAttrs["content"] = base64.StdEncoding.EncodeToString(content.Bytes())
+	responseAttrs["name"] = fileName
+	responseAttrs["contentType"] = contentType
+	responseAttrs["message"] = fmt.Sprintf("Downloading data as %s", format)
+
+	actionResponse := resource.NewActionResponse("client.file.download", responseAttrs)
+	responses = append(responses, actionResponse)
+
+	return nil, responses, nil
+}
+
+// exportContentType returns the content type and file extension of the format
+func exportContentType(format ExportFormat) (string, string) {
+	switch format {
+	case FormatCSV:
+		return "text/csv", "csv"
+	case FormatXLSX:
+		return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", "xlsx"
+	case FormatPDF:
+		return "application/pdf", "pdf"
+	case FormatHTML:
+		return "text/html", "html"
+	case FormatNDJSON:
+		return "application/x-ndjson", "ndjson"
+	case FormatParquet:
+		return "application/vnd.apache.parquet", "parquet"
+	default:
+		return "application/json", "json"
+	}
+}
+
+// exportQueryParams reads query, filter and sort in the form the api accepts them in the url
+func exportQueryParams(inFields map[string]interface{}) map[string][]string {
+	queryParams := make(map[string][]string)
+	switch query := inFields["query"].(type) {
+	case string:
+		if query != "" {
+			queryParams["query"] = []string{query}
+		}
+	case []interface{}:
+		if len(query) > 0 {
+			queryParams["query"] = []string{resource.ToJson(query)}
+		}
+	}
+	if filter, ok := inFields["filter"].(string); ok && filter != "" {
+		queryParams["filter"] = []string{filter}
+	}
+	switch sortColumns := inFields["sort"].(type) {
+	case string:
+		if sortColumns != "" {
+			queryParams["sort"] = strings.Split(sortColumns, ",")
+		}
+	case []interface{}:
+		for _, column := range sortColumns {
+			if columnName, ok := column.(string); ok {
+				queryParams["sort"] = append(queryParams["sort"], columnName)
+			}
+		}
+	}
+	return queryParams
+}
+
+// runExport writes the export to output. Each page is read in the transaction handed out by
+// withTx, and written to output before the next page is read. Returns the number of rows written
+func (d *exportDataPerformer) runExport(output io.Writer, export exportRequest, withTx func(fn func(tx *sqlx.Tx) error) error) (int, error) {
+	// Create streaming writer for the selected format
+	writer, err := CreateStreamingExportWriter(export.format)
+	if err != nil {
+		log.Errorf("Failed to create streaming writer: %v", err)
+		return 0, err
+	}
+
 	// Initialize the writer
-	log.Infof("Exporting [%v] columns [%v]", tablesToExport, selectedColumnsMap)
-	err = writer.Initialize(tablesToExport, includeHeaders, selectedColumnsMap)
+	log.Infof("Exporting [%v] columns [%v]", export.tables, export.selectedColumns)
+	err = writer.Initialize(output, export.tables, export.includeHeaders, export.selectedColumns)
 	if err != nil {
 		log.Errorf("Failed to initialize streaming writer: %v", err)
-		return nil, nil, []error{err}
+		return 0, err
 	}
 
+	rowCount := 0
 	// Process each table
-	for _, currentTable := range tablesToExport {
+	for _, currentTable := range export.tables {
+		if export.stream != nil {
+			rows, err := d.exportStream(writer, export.stream, export.selectedColumns[currentTable], export.pageSize, export.plainRequest, withTx)
+			if err != nil {
+				log.Errorf("Error streaming data for stream [%s]: %v", currentTable, err)
+				return rowCount, err
+			}
+			rowCount += rows
+			continue
+		}
+
 		// Skip if we don't have access to this table
 		if _, ok := d.cruds[currentTable]; !ok {
 			log.Warnf("Skipping table [%s]: not accessible", currentTable)
@@ -148,110 +317,439 @@ func (d *exportDataPerformer) DoAction(request actionresponse.Outcome, inFields
 			continue
 		}
 
-		// Determine columns for this table
-		var columns []string
-		if tableColumns, ok := selectedColumnsMap[currentTable]; ok && len(tableColumns) > 0 {
-			columns = tableColumns
-		} else {
-			// Get first row to determine columns
-			firstRowResult := make([]map[string]interface{}, 0)
-			err = d.cruds[currentTable].GetAllRawObjectsWithPaginationAndTransaction(
-				currentTable,
-				1, // Just get one row to determine columns
-				transaction,
-				func(rows []map[string]interface{}) error {
-					firstRowResult = rows
-					return nil
-				}, 1,
-			)
-
+		// Stream data in pages, the columns come from the first row unless selected
+		columns := export.selectedColumns[currentTable]
+		for page := 0; ; page++ {
+			var rows []map[string]interface{}
+			err = withTx(func(tx *sqlx.Tx) error {
+				rows, err = d.readExportPage(export, currentTable, page, tx)
+				return err
+			})
 			if err != nil {
-				log.Errorf("Failed to get column names for [%s]: %v", currentTable, err)
-				continue
+				log.Errorf("Error streaming data for table [%s]: %v", currentTable, err)
+				return rowCount, err
 			}
 
-			if len(firstRowResult) > 0 {
-				for key := range firstRowResult[0] {
-					columns = append(columns, key)
+			if page == 0 {
+				if len(columns) == 0 && len(rows) > 0 {
+					for key := range rows[0] {
+						columns = append(columns, key)
+					}
+					sort.Strings(columns)
+					// Store columns for later use
+					export.selectedColumns[currentTable] = columns
+				}
+				if len(columns) == 0 {
+					log.Warnf("No columns found for table [%s], skipping", currentTable)
+					break
+				}
+				// Write headers if we have columns
+				if err = writer.WriteHeaders(currentTable, columns); err != nil {
+					return rowCount, err
 				}
 			}
 
-			// Store columns for later use
-			selectedColumnsMap[currentTable] = columns
+			if len(rows) > 0 {
+				if err = writer.WriteRows(currentTable, rows); err != nil {
+					return rowCount, err
+				}
+				rowCount += len(rows)
+			}
+			if len(rows) < export.pageSize {
+				break
+			}
 		}
+	}
+
+	// Finalize the export
+	if err = writer.Finalize(); err != nil {
+		log.Errorf("Failed to finalize export: %v", err)
+		return rowCount, err
+	}
+	return rowCount, nil
+}
+
+// readExportPage reads a page of the table. With query, filter or sort the page is read like the
+// api reads it, with the permissions of the user, otherwise the raw rows of the table are read
+func (d *exportDataPerformer) readExportPage(export exportRequest, tableName string, page int, tx *sqlx.Tx) ([]map[string]interface{}, error) {
+	if len(export.queryParams) == 0 {
+		return d.cruds[tableName].GetRawObjectsPage(tableName, export.pageSize, page*export.pageSize, api2go.Request{PlainRequest: export.plainRequest}, tx)
+	}
 
-		// Write headers if we have columns
-		if len(columns) > 0 {
-			err = writer.WriteHeaders(currentTable, columns)
+	queryParams := map[string][]string{
+		"page[size]":   {fmt.Sprintf("%d", export.pageSize)},
+		"page[number]": {fmt.Sprintf("%d", page+1)},
+	}
+	for key, values := range export.queryParams {
+		queryParams[key] = append([]string{}, values...)
+	}
+	_, response, err := d.cruds[tableName].PaginatedFindAllWithTransaction(api2go.Request{
+		PlainRequest: export.plainRequest,
+		QueryParams:  queryParams,
+	}, tx)
+	if err != nil {
+		return nil, err
+	}
+	results, _ := response.Result().([]api2go.Api2GoModel)
+	rows := make([]map[string]interface{}, 0, len(results))
+	for _, result := range results {
+		rows = append(rows, result.GetAttributes())
+	}
+	return rows, nil
+}
+
+// exportStream writes every page of the stream, read as the user calling the action. The stream reads
+// a page of its root entity and transforms it, each page is read in a transaction of its own
+func (d *exportDataPerformer) exportStream(writer resource.StreamingExportWriter, streamProcessor *resource.StreamProcessor,
+	columns []string, pageSize int, httpRequest *http.Request, withTx func(fn func(tx *sqlx.Tx) error) error) (int, error) {
+
+	streamName := streamProcessor.GetName()
+	if err := writer.WriteTable(streamName); err != nil {
+		return 0, err
+	}
+
+	rowCount := 0
+	for pageNumber := 1; ; pageNumber++ {
+		var totalCount uint
+		var results []api2go.Api2GoModel
+		err := withTx(func(tx *sqlx.Tx) error {
+			req := api2go.Request{
+				PlainRequest: httpRequest,
+				QueryParams: map[string][]string{
+					"page[size]":   {fmt.Sprintf("%d", pageSize)},
+					"page[number]": {fmt.Sprintf("%d", pageNumber)},
+				},
+			}
+			count, response, err := streamProcessor.PaginatedFindAllWithTransaction(req, tx)
 			if err != nil {
-				log.Errorf("Failed to write headers for [%s]: %v", currentTable, err)
-				continue
+				return err
 			}
-		} else {
-			log.Warnf("No columns found for table [%s], skipping", currentTable)
-			continue
+			totalCount = count
+			results, _ = response.Result().([]api2go.Api2GoModel)
+			return nil
+		})
+		if err != nil {
+			return rowCount, err
 		}
 
-		// Stream data in batches
-		err = d.cruds[currentTable].GetAllRawObjectsWithPaginationAndTransaction(
-			currentTable,
-			pageSize,
-			transaction,
-			func(rows []map[string]interface{}) error {
-				return writer.WriteRows(currentTable, rows)
-			}, -1,
-		)
+		rows := make([]map[string]interface{}, 0, len(results))
+		for _, result := range results {
+			rows = append(rows, result.GetAttributes())
+		}
 
-		if err != nil {
-			log.Errorf("Error streaming data for table [%s]: %v", currentTable, err)
-			continue
+		if pageNumber == 1 {
+			if len(columns) == 0 && len(rows) > 0 {
+				for key := range rows[0] {
+					columns = append(columns, key)
+				}
+				sort.Strings(columns)
+			}
+			if len(columns) == 0 {
+				log.Warnf("No columns found for stream [%s], skipping", streamName)
+				return 0, nil
+			}
+			if err = writer.WriteHeaders(streamName, columns); err != nil {
+				return 0, err
+			}
+		}
+
+		if len(rows) > 0 {
+			if err = writer.WriteRows(streamName, rows); err != nil {
+				return rowCount, err
+			}
+			rowCount += len(rows)
+		}
+		if uint(pageNumber*pageSize) >= totalCount {
+			return rowCount, nil
 		}
 	}
+}
 
-	// Finalize the export
-	content, err := writer.Finalize()
+// exportToCloudStore writes the export to a file in the cloud store and tracks it in export_job.
+// A background export returns at once, reads every page in a transaction of its own and notifies
+// the user with a link to the file when it is done
+func (d *exportDataPerformer) exportToCloudStore(export exportRequest, cloudStoreId daptinid.DaptinReferenceId, fileName string, finalName string,
+	inFields map[string]interface{}, sessionUser *auth.SessionUser, transaction *sqlx.Tx) (api2go.Responder, []actionresponse.ActionResponse, []error) {
+
+	if sessionUser != nil {
+		storePermission := d.cruds["cloud_store"].GetObjectPermissionByReferenceId("cloud_store", cloudStoreId, transaction)
+		if !storePermission.CanCreate(sessionUser.UserReferenceId, sessionUser.Groups, d.cruds["cloud_store"].AdministratorGroupId) {
+			return nil, nil, []error{api2go.NewHTTPError(nil, "cannot write to cloud store", 403)}
+		}
+	}
+	cloudStore, err := loadCloudStore(d.cruds, cloudStoreId, transaction)
 	if err != nil {
-		log.Errorf("Failed to finalize export: %v", err)
-		// Fallback to empty content
-		content = []byte{}
+		return nil, nil, []error{err}
 	}
 
-	// Determine content type and file extension
-	var contentType string
-	var fileExtension string
+	// path is a directory unless it names a file with an extension
+	path := strings.Trim(fmt.Sprintf("%v", inFields["path"]), "/")
+	if inFields["path"] == nil || path == "" {
+		path = fileName
+	} else if !strings.Contains(path[strings.LastIndex(path, "/")+1:], ".") {
+		path = path + "/" + fileName
+	}
 
-	switch format {
-	case FormatJSON:
-		contentType = "application/json"
-		fileExtension = "json"
-	case FormatCSV:
-		contentType = "text/csv"
-		fileExtension = "csv"
-	case FormatXLSX:
-		contentType = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
-		fileExtension = "xlsx"
-	case FormatPDF:
-		contentType = "application/pdf"
-		fileExtension = "pdf"
-	case FormatHTML:
-		contentType = "text/html"
-		fileExtension = "html"
-	default:
-		contentType = "application/json"
-		fileExtension = "json"
+	linkExpiry := 24 * time.Hour
+	if expiry, ok := inFields["link_expiry"].(string); ok && expiry != "" {
+		linkExpiry, err = time.ParseDuration(expiry)
+		if err != nil {
+			return nil, nil, []error{fmt.Errorf("invalid link_expiry [%v]: %w", expiry, err)}
+		}
+	}
+	notify, _ := inFields["notify"].(string)
+	if notify == "" {
+		notify = "websocket"
+	}
+
+	job := exportJob{
+		referenceId: daptinid.DaptinReferenceId(uuid.Must(uuid.NewV7())),
+		tableName:   finalName,
+		format:      export.format,
+		cloudStore:  cloudStore,
+		path:        path,
+		notify:      notify,
+		user:        sessionUser,
+	}
+
+	if background, _ := inFields["background"].(bool); background {
+		jobCrud := d.cruds[exportJobTable]
+		go func() {
+			// every page is read in a transaction of its own, the action transaction is
+			// committed by the time the first one begins
+			inTransaction := func(begin func() (*sqlx.Tx, error)) func(fn func(tx *sqlx.Tx) error) error {
+				return func(fn func(tx *sqlx.Tx) error) error {
+					tx, err := begin()
+					if err != nil {
+						return err
+					}
+					err = fn(tx)
+					if err != nil {
+						tx.Rollback()
+						return err
+					}
+					return tx.Commit()
+				}
+			}
+			withTx := inTransaction(jobCrud.Connection().Beginx)
+			// the pages are read from a read replica when there is one, the job runs after the
+			// request which started it has completed
+			readTx := inTransaction(func() (*sqlx.Tx, error) {
+				return jobCrud.BeginReadTransaction(database.WithReadConsistency(context.Background(), false))
+			})
+			d.runExportJob(&job, export, linkExpiry, withTx, readTx)
+			d.notifyExportJob(&job, withTx)
+		}()
+
+		responseAttrs := make(map[string]interface{})
+		responseAttrs["message"] = fmt.Sprintf("Export of %v started, track the progress in export_job", finalName)
+		responseAttrs["export_job_id"] = job.referenceId.String()
+		return nil, []actionresponse.ActionResponse{resource.NewActionResponse("client.notify", responseAttrs)}, nil
+	}
+
+	inActionTransaction := func(fn func(tx *sqlx.Tx) error) error {
+		return fn(transaction)
+	}
+	err = d.runExportJob(&job, export, linkExpiry, inActionTransaction, inActionTransaction)
+	if err != nil {
+		return nil, nil, []error{err}
 	}
 
-	// Create response with the exported data
 	responseAttrs := make(map[string]interface{})
-	responseAttrs["content"] = base64.StdEncoding.EncodeToString(content)
-	responseAttrs["name"] = fmt.Sprintf("daptin_export_%v.%s", finalName, fileExtension)
-	responseAttrs["contentType"] = contentType
-	responseAttrs["message"] = fmt.Sprintf("Downloading data as %s", format)
+	responseAttrs["message"] = job.message
+	responseAttrs["export_job_id"] = job.referenceId.String()
+	responseAttrs["path"] = job.path
+	responseAttrs["download_url"] = job.downloadUrl
+	responseAttrs["rows_exported"] = job.rowsExported
+	return nil, []actionresponse.ActionResponse{resource.NewActionResponse("client.notify", responseAttrs)}, nil
+}
 
-	actionResponse := resource.NewActionResponse("client.file.download", responseAttrs)
-	responses = append(responses, actionResponse)
+// exportJob is an export to a cloud store, saved to export_job when it starts and ends
+type exportJob struct {
+	referenceId  daptinid.DaptinReferenceId
+	tableName    string
+	format       ExportFormat
+	cloudStore   rootpojo.CloudStore
+	path         string
+	notify       string
+	user         *auth.SessionUser
+	status       string
+	rowsExported int
+	downloadUrl  string
+	message      string
+}
 
-	return nil, responses, nil
+// runExportJob uploads the export to the cloud store and records the outcome on the job. The job is
+// saved in the transactions of withTx, the pages are read in the ones of readTx.
+func (d *exportDataPerformer) runExportJob(job *exportJob, export exportRequest, linkExpiry time.Duration,
+	withTx func(fn func(tx *sqlx.Tx) error) error, readTx func(fn func(tx *sqlx.Tx) error) error) error {
+
+	job.status = "running"
+	err := withTx(func(tx *sqlx.Tx) error {
+		return d.saveExportJob(job, true, tx)
+	})
+	if err != nil {
+		log.Errorf("Failed to save export job for [%s]: %v", job.tableName, err)
+		return err
+	}
+
+	exportErr := writeCloudStoreFile(job.cloudStore, job.path, func(output io.Writer) error {
+		var err error
+		job.rowsExported, err = d.runExport(output, export, readTx)
+		return err
+	})
+
+	if exportErr != nil {
+		job.status = "failed"
+		job.message = exportErr.Error()
+		log.Errorf("Export of [%s] to [%s] failed: %v", job.tableName, job.path, exportErr)
+	} else {
+		job.status = "completed"
+		job.message = fmt.Sprintf("Exported %d rows of %s to %s", job.rowsExported, job.tableName, job.path)
+		link, err := cloudStoreLink(job.cloudStore, job.path, linkExpiry)
+		if err != nil {
+			log.Infof("Cloud store [%s] gives no link to [%s]: %v", job.cloudStore.Name, job.path, err)
+		}
+		job.downloadUrl = link
+	}
+
+	err = withTx(func(tx *sqlx.Tx) error {
+		return d.saveExportJob(job, false, tx)
+	})
+	if err != nil {
+		log.Errorf("Failed to save export job for [%s]: %v", job.tableName, err)
+	}
+	return exportErr
+}
+
+// saveExportJob creates the export_job row, or updates it with the outcome of the export
+func (d *exportDataPerformer) saveExportJob(job *exportJob, create bool, tx *sqlx.Tx) error {
+	now := time.Now()
+	var query string
+	var args []interface{}
+	var err error
+	if create {
+		var userId interface{}
+		if job.user != nil {
+			userId = job.user.UserId
+		}
+		query, args, err = statementbuilder.Squirrel.Insert(exportJobTable).Prepared(true).
+			Cols("table_name", "format", "status", "rows_exported", "cloud_store_id", "path", "notify", "started_at",
+				resource.USER_ACCOUNT_ID_COLUMN, "reference_id", "permission", "created_at", "updated_at").
+			Vals([]interface{}{job.tableName, string(job.format), job.status, 0, job.cloudStore.ReferenceId.String(), job.path, job.notify, now,
+				userId, job.referenceId[:], auth.DEFAULT_PERMISSION, now, now}).ToSQL()
+	} else {
+		query, args, err = statementbuilder.Squirrel.Update(exportJobTable).Prepared(true).
+			Set(goqu.Record{
+				"status":        job.status,
+				"rows_exported": job.rowsExported,
+				"download_url":  job.downloadUrl,
+				"message":       job.message,
+				"finished_at":   now,
+				"updated_at":    now,
+			}).Where(goqu.Ex{"reference_id": job.referenceId[:]}).ToSQL()
+	}
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(query, args...)
+	return err
+}
+
+// notifyExportJob tells the user the export finished, as an update event on the export_job topic
+// of the websocket or by mail
+func (d *exportDataPerformer) notifyExportJob(job *exportJob, withTx func(fn func(tx *sqlx.Tx) error) error) {
+	if job.user == nil || job.notify == "none" {
+		return
+	}
+
+	event := map[string]interface{}{
+		"__type":                        exportJobTable,
+		"reference_id":                  job.referenceId.String(),
+		resource.USER_ACCOUNT_ID_COLUMN: job.user.UserReferenceId.String(),
+		"table_name":                    job.tableName,
+		"format":                        string(job.format),
+		"status":                        job.status,
+		"rows_exported":                 job.rowsExported,
+		"path":                          job.path,
+		"download_url":                  job.downloadUrl,
+		"message":                       job.message,
+	}
+
+	switch job.notify {
+	case "websocket":
+		pubSub := d.cruds[exportJobTable].PubSub
+		if pubSub == nil {
+			return
+		}
+		_, err := pubSub.Publish(context.Background(), exportJobTable, resource.WsOutMessage{
+			Type:   "event",
+			Topic:  exportJobTable,
+			Event:  "update",
+			Source: "database",
+			Data:   []byte(resource.ToJson(event)),
+		})
+		resource.CheckErr(err, "Failed to publish export job notification")
+
+	case "email":
+		performer, ok := resource.GetActionHandler(d.cruds["mail"], "mail.send")
+		if !ok {
+			log.Warnf("No mail.send performer to notify about export job [%v]", job.referenceId)
+			return
+		}
+		body := job.message
+		if job.downloadUrl != "" {
+			body = fmt.Sprintf("%s\n\nDownload: %s", job.message, job.downloadUrl)
+		}
+		err := withTx(func(tx *sqlx.Tx) error {
+			user, err := d.cruds[resource.USER_ACCOUNT_TABLE_NAME].GetIdToObject(resource.USER_ACCOUNT_TABLE_NAME, job.user.UserId, tx)
+			if err != nil {
+				return err
+			}
+			email, _ := user["email"].(string)
+			if email == "" {
+				return fmt.Errorf("user has no email")
+			}
+			from, err := d.exportEmailFrom(tx)
+			if err != nil {
+				return err
+			}
+			_, _, errs := performer.DoAction(actionresponse.Outcome{}, map[string]interface{}{
+				"to":               []string{email},
+				"subject":          fmt.Sprintf("Export of %s %s", job.tableName, job.status),
+				"body":             body,
+				"from":             from,
+				"send_immediately": true,
+			}, tx)
+			if len(errs) > 0 {
+				return errs[0]
+			}
+			return nil
+		})
+		resource.CheckErr(err, "Failed to mail export job notification")
+	}
+}
+
+// exportEmailFrom is the sender of export notifications, export.email.from in the backend config,
+// or no-reply at the configured hostname when it is not set
+func (d *exportDataPerformer) exportEmailFrom(transaction *sqlx.Tx) (string, error) {
+	world, ok := d.cruds["world"]
+	if !ok || world.ConfigStore == nil {
+		return "", fmt.Errorf("no config store to read the export email sender")
+	}
+	configStore := world.ConfigStore
+	from, err := configStore.GetConfigValueFor("export.email.from", "backend", transaction)
+	if err == nil && from != "" {
+		return from, nil
+	}
+	hostname, err := configStore.GetConfigValueFor("hostname", "backend", transaction)
+	if err != nil || hostname == "" {
+		hostname, err = os.Hostname()
+		if err != nil {
+			return "", err
+		}
+	}
+	return "no-reply@" + hostname, nil
 }
 
 // NewExportDataPerformer creates a new instance of the export data performer
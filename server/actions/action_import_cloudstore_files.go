@@ -131,6 +131,7 @@ func (d *importCloudStoreFilesPerformer) DoAction(request actionresponse.Outcome
 			})
 
 			resource.InfoErr(err, "Failed to sync files for upload to cloud")
+			resource.MarkTableChanged(ctx, tableName)
 			return err
 		})
 	}
@@ -1,25 +1,82 @@
 package actions
 
 import (
+	"bufio"
+	"bytes"
+	"context"
 	"encoding/base64"
+	"encoding/csv"
 	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/artpar/api2go/v2"
 	"github.com/daptin/daptin/server/actionresponse"
+	"github.com/daptin/daptin/server/auth"
 	daptinid "github.com/daptin/daptin/server/id"
 	"github.com/daptin/daptin/server/resource"
+	"github.com/daptin/daptin/server/statementbuilder"
+	"github.com/daptin/daptin/server/tenant"
+	"github.com/doug-martin/goqu/v9"
+	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
 	log "github.com/sirupsen/logrus"
 )
 
+// the import_job table tracks every import, its row counts and the rows which failed
+const importJobTable = "import_job"
+
+// at most this many failed rows are kept in the error report of an import job
+const importErrorReportLimit = 10000
+
 // importDataPerformer handles data import from various formats
 type importDataPerformer struct {
 	cmsConfig *resource.CmsConfig
 	cruds     map[string]*resource.DbResource
 }
 
+// importSource is a file to import, opened only when the import starts reading it
+type importSource struct {
+	name         string
+	cloudStoreId string
+	open         func() (io.ReadCloser, error)
+}
+
+// importOptions are the settings shared by every file of an import
+type importOptions struct {
+	tableName     string
+	truncate      bool
+	mode          string
+	upsertKey     string
+	batchSize     int
+	userId        int64
+	isUserPresent bool
+	// sessionUser has to be allowed to update the rows an upsert overwrites, unless isAdmin
+	sessionUser *auth.SessionUser
+	isAdmin     bool
+	// ctx collects the tables written to, so their cached results are not used again
+	ctx context.Context
+}
+
+// importJob is the progress of importing one file, saved to import_job after every batch
+type importJob struct {
+	referenceId   daptinid.DaptinReferenceId
+	exists        bool
+	skip          int
+	rowsProcessed int
+	rowsImported  int
+	rowsFailed    int
+	// rows imported by this run, a resumed job also counts the rows of earlier runs
+	importedThisRun int
+	errorReport     [][]string
+	format          ImportFormat
+}
+
 // Name returns the name of this action
 func (d *importDataPerformer) Name() string {
 	return "__data_import"
@@ -30,51 +87,309 @@ func (d *importDataPerformer) DoAction(request actionresponse.Outcome, inFields
 	responses := make([]actionresponse.ActionResponse, 0)
 	errors := make([]error, 0)
 
+	options := importOptions{
+		mode:      "insert",
+		upsertKey: "reference_id",
+		batchSize: 100,
+		userId:    1,
+		ctx:       context.Background(),
+	}
+	httpRequest, _ := inFields["httpRequest"].(*http.Request)
+	if httpRequest != nil {
+		options.ctx = httpRequest.Context()
+	}
+	options.sessionUser, _ = inFields["sessionUser"].(*auth.SessionUser)
+	options.isAdmin = options.sessionUser != nil && resource.IsAdminWithTransaction(options.sessionUser, transaction)
+
 	// Get the target table name if specified
-	tableName, isSubjected := inFields["table_name"]
+	if tableName, ok := inFields["table_name"].(string); ok {
+		options.tableName = tableName
+	}
 
 	// Get user information if present
 	user, isUserPresent := inFields["user"]
-	var userIdInt int64 = 1
-	if isUserPresent {
+	if isUserPresent && user != nil {
+		options.isUserPresent = true
 		userMap := user.(map[string]interface{})
 		userReferenceId := daptinid.InterfaceToDIR(userMap["reference_id"])
 		var err error
-		userIdInt, err = d.cruds[resource.USER_ACCOUNT_TABLE_NAME].GetReferenceIdToId(resource.USER_ACCOUNT_TABLE_NAME, userReferenceId, transaction)
+		options.userId, err = d.cruds[resource.USER_ACCOUNT_TABLE_NAME].GetReferenceIdToId(resource.USER_ACCOUNT_TABLE_NAME, userReferenceId, transaction)
 		if err != nil {
 			log.Errorf("Failed to get user id from user reference id: %v", err)
 		}
 	}
 
 	// Get import options
-	truncateBeforeInsert := false
-	if val, ok := inFields["truncate_before_insert"]; ok && val != nil {
-		if boolVal, ok := val.(bool); ok {
-			truncateBeforeInsert = boolVal
+	// values of a multipart form are text
+	options.truncate = resource.ValueToBool(inFields["truncate_before_insert"])
+	if mode, ok := inFields["mode"].(string); ok && mode != "" {
+		options.mode = mode
+	}
+	if upsertKey, ok := inFields["upsert_key"].(string); ok && upsertKey != "" {
+		options.upsertKey = upsertKey
+	}
+	if intVal, ok := toInt(inFields["batch_size"]); ok && intVal > 0 {
+		options.batchSize = intVal
+	} else if text, ok := inFields["batch_size"].(string); ok {
+		if intVal, err := strconv.Atoi(strings.TrimSpace(text)); err == nil && intVal > 0 {
+			options.batchSize = intVal
 		}
 	}
+	background := resource.ValueToBool(inFields["background"])
+
+	job := &importJob{}
+	var sources []importSource
 
-	batchSize := 100
-	if val, ok := inFields["batch_size"]; ok && val != nil {
-		if intVal, ok := val.(int); ok && intVal > 0 {
-			batchSize = intVal
+	// Resume an import job, the rows it already processed are skipped
+	if jobId, ok := inFields["import_job_id"].(string); ok && jobId != "" {
+		var err error
+		job, sources, err = d.resumeImportJob(daptinid.InterfaceToDIR(jobId), inFields, &options, transaction)
+		if err != nil {
+			return nil, responses, []error{err}
 		}
 	}
 
-	// Get files to import
-	files, ok := inFields["dump_file"].([]interface{})
-	if !ok || len(files) == 0 {
+	// files uploaded as multipart/form-data are read from the request body as they are imported
+	uploads := resource.MultipartFilesFromRequest(httpRequest)
+	if cloudStoreId, _ := inFields["cloud_store_id"].(string); len(sources) > 0 || cloudStoreId != "" {
+		uploads = nil
+	}
+	if len(sources) == 0 && uploads == nil {
+		var err error
+		sources, err = d.importSources(inFields, transaction)
+		if err != nil {
+			return nil, responses, []error{err}
+		}
+	}
+	if len(sources) == 0 && uploads == nil {
 		err := fmt.Errorf("no files provided for import")
 		errors = append(errors, err)
 		return nil, responses, errors
 	}
+	if job.exists && len(sources) > 1 {
+		return nil, responses, []error{fmt.Errorf("an import job can be resumed with one file only")}
+	}
+	if options.mode != "insert" && options.mode != "upsert" {
+		return nil, responses, []error{fmt.Errorf("unknown import mode [%v], expected insert or upsert", options.mode)}
+	}
+	if options.tableName != "" {
+		if _, ok := d.cruds[options.tableName]; !ok {
+			return nil, responses, []error{fmt.Errorf("no such table [%v]", options.tableName)}
+		}
+	}
+
+	if background {
+		// the request body is gone once the response is sent, uploads are kept on disk meanwhile
+		cleanup := func() {}
+		if uploads != nil {
+			var err error
+			sources, cleanup, err = spoolUploads(uploads)
+			if err != nil {
+				return nil, responses, []error{fmt.Errorf("failed to read the uploaded files: %w", err)}
+			}
+			if len(sources) == 0 {
+				return nil, responses, []error{fmt.Errorf("no files provided for import")}
+			}
+			if job.exists && len(sources) > 1 {
+				cleanup()
+				return nil, responses, []error{fmt.Errorf("an import job can be resumed with one file only")}
+			}
+		}
+		jobs := make([]*importJob, len(sources))
+		jobIds := make([]string, len(sources))
+		for i := range sources {
+			jobs[i] = importJobFor(i, job)
+			jobIds[i] = jobs[i].referenceId.String()
+		}
+
+		connection := d.cruds[importJobTable].Connection()
+		// the batches are committed as they are written, after the request is done, as the user and
+		// for the tenant of the request
+		background := tenant.WithTenant(context.Background(), tenant.FromContext(options.ctx))
+		if options.sessionUser != nil {
+			background = context.WithValue(background, "user", options.sessionUser)
+		}
+		options.ctx = background
+		go func() {
+			defer cleanup()
+			// every batch is written in a transaction of its own, the action transaction is
+			// committed by the time the first one begins
+			withBatch := func(fn func(tx *sqlx.Tx) error) error {
+				tx, err := connection.Beginx()
+				if err != nil {
+					return err
+				}
+				err = fn(tx)
+				if err != nil {
+					tx.Rollback()
+					return err
+				}
+				return tx.Commit()
+			}
+			for i, source := range sources {
+				_, err := d.importFile(source, jobs[i], options, withBatch)
+				if err != nil {
+					log.Errorf("Background import of [%s] failed: %v", source.name, err)
+				}
+			}
+		}()
+
+		responseAttrs := make(map[string]interface{})
+		responseAttrs["message"] = fmt.Sprintf("Import of %d files started, track the progress in import_job", len(sources))
+		responseAttrs["import_job_ids"] = jobIds
+		responses = append(responses, resource.NewActionResponse("client.notify", responseAttrs))
+		return nil, responses, errors
+	}
+
+	withBatch := func(fn func(tx *sqlx.Tx) error) error {
+		return fn(transaction)
+	}
 
 	startTime := time.Now()
 	totalRowsImported := 0
 	successfulImports := 0
 	failedImports := 0
 
+	nextSource := func() (importSource, bool, error) {
+		if len(sources) == 0 {
+			return importSource{}, false, nil
+		}
+		source := sources[0]
+		sources = sources[1:]
+		return source, true, nil
+	}
+	if uploads != nil {
+		nextSource = uploadSources(uploads)
+	}
+
 	// Process each file
+	jobIds := make([]string, 0)
+	for i := 0; ; i++ {
+		source, ok, err := nextSource()
+		if err != nil {
+			errors = append(errors, fmt.Errorf("failed to read the uploaded files: %w", err))
+			break
+		}
+		if !ok {
+			break
+		}
+		if i > 0 && job.exists {
+			errors = append(errors, fmt.Errorf("an import job can be resumed with one file only"))
+			break
+		}
+		fileJob := importJobFor(i, job)
+		jobIds = append(jobIds, fileJob.referenceId.String())
+		tableCount, err := d.importFile(source, fileJob, options, withBatch)
+		totalRowsImported += fileJob.importedThisRun
+		failedImports += fileJob.rowsFailed
+		successfulImports += tableCount
+		if err != nil {
+			errors = append(errors, fmt.Errorf("failed to import file '%s': %w", source.name, err))
+		}
+	}
+	if len(jobIds) == 0 && len(errors) == 0 {
+		return nil, responses, []error{fmt.Errorf("no files provided for import")}
+	}
+
+	// Create response with import summary
+	duration := time.Since(startTime)
+	responseAttrs := make(map[string]interface{})
+	responseAttrs["message"] = fmt.Sprintf("Import completed in %v. %d rows imported successfully across %d tables.", duration.Round(time.Millisecond), totalRowsImported, successfulImports)
+	responseAttrs["rows_imported"] = totalRowsImported
+	responseAttrs["successful_tables"] = successfulImports
+	responseAttrs["failed_tables"] = failedImports
+	responseAttrs["import_job_ids"] = jobIds
+
+	actionResponse := resource.NewActionResponse("client.notify", responseAttrs)
+	responses = append(responses, actionResponse)
+
+	return nil, responses, errors
+}
+
+// importJobFor is the job of the i-th file of an import, the first one continues a resumed job
+func importJobFor(i int, resumed *importJob) *importJob {
+	if i == 0 && resumed.exists {
+		return resumed
+	}
+	return &importJob{referenceId: daptinid.DaptinReferenceId(uuid.Must(uuid.NewV7()))}
+}
+
+// uploadSources reads the files uploaded as multipart/form-data straight from the request body, one
+// after the other
+func uploadSources(uploads *resource.MultipartFiles) func() (importSource, bool, error) {
+	return func() (importSource, bool, error) {
+		part, ok, err := uploads.Next()
+		if err != nil || !ok {
+			return importSource{}, false, err
+		}
+		return importSource{
+			name: part.FileName(),
+			open: func() (io.ReadCloser, error) {
+				return io.NopCloser(part), nil
+			},
+		}, true, nil
+	}
+}
+
+// spoolUploads copies the uploaded files to temporary files, for an import running after the
+// request is done. cleanup removes them.
+func spoolUploads(uploads *resource.MultipartFiles) ([]importSource, func(), error) {
+	paths := make([]string, 0)
+	cleanup := func() {
+		for _, path := range paths {
+			if err := os.Remove(path); err != nil {
+				log.Errorf("Failed to remove uploaded import file [%v]: %v", path, err)
+			}
+		}
+	}
+	sources := make([]importSource, 0)
+	for {
+		part, ok, err := uploads.Next()
+		if err != nil {
+			cleanup()
+			return nil, nil, err
+		}
+		if !ok {
+			return sources, cleanup, nil
+		}
+		file, err := os.CreateTemp("", "daptin-import-*")
+		if err != nil {
+			cleanup()
+			return nil, nil, err
+		}
+		paths = append(paths, file.Name())
+		_, err = io.Copy(file, part)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			cleanup()
+			return nil, nil, err
+		}
+		path := file.Name()
+		sources = append(sources, importSource{
+			name: part.FileName(),
+			open: func() (io.ReadCloser, error) {
+				return os.Open(path)
+			},
+		})
+	}
+}
+
+// importSources opens the uploaded files, or the file at path in a cloud store
+func (d *importDataPerformer) importSources(inFields map[string]interface{}, transaction *sqlx.Tx) ([]importSource, error) {
+	if cloudStoreId, ok := inFields["cloud_store_id"].(string); ok && cloudStoreId != "" {
+		path, _ := inFields["path"].(string)
+		source, err := d.cloudStoreSource(daptinid.InterfaceToDIR(cloudStoreId), path, inFields, transaction)
+		if err != nil {
+			return nil, err
+		}
+		return []importSource{source}, nil
+	}
+
+	// Get files to import
+	files, _ := inFields["dump_file"].([]interface{})
+	sources := make([]importSource, 0, len(files))
 	for fileIndex, fileInterface := range files {
 		file, ok := fileInterface.(map[string]interface{})
 		if !ok {
@@ -94,129 +409,349 @@ func (d *importDataPerformer) DoAction(request actionresponse.Outcome, inFields
 			continue
 		}
 
-		// Decode base64 content
-		contentParts := strings.Split(fileContentsBase64, ",")
-		var fileBytes []byte
-		var err error
-
-		if len(contentParts) > 1 {
-			fileBytes, err = base64.StdEncoding.DecodeString(contentParts[1])
-		} else {
-			fileBytes, err = base64.StdEncoding.DecodeString(contentParts[0])
+		// Decode base64 content as it is read, skipping a data url prefix
+		if comma := strings.Index(fileContentsBase64, ","); comma > -1 {
+			fileContentsBase64 = fileContentsBase64[comma+1:]
 		}
+		sources = append(sources, importSource{
+			name: fileName,
+			open: func() (io.ReadCloser, error) {
+				return io.NopCloser(base64.NewDecoder(base64.StdEncoding, strings.NewReader(fileContentsBase64))), nil
+			},
+		})
+	}
+	return sources, nil
+}
 
-		if err != nil {
-			log.Errorf("Failed to decode file contents as base64: %v", err)
-			errors = append(errors, fmt.Errorf("failed to decode file '%s': %w", fileName, err))
-			continue
+// cloudStoreSource opens a file in a cloud store the user can read
+func (d *importDataPerformer) cloudStoreSource(cloudStoreId daptinid.DaptinReferenceId, path string, inFields map[string]interface{}, transaction *sqlx.Tx) (importSource, error) {
+	if path == "" {
+		return importSource{}, fmt.Errorf("path of the file in the cloud store is required")
+	}
+	sessionUser, _ := inFields["sessionUser"].(*auth.SessionUser)
+	if sessionUser != nil {
+		storePermission := d.cruds["cloud_store"].GetObjectPermissionByReferenceId("cloud_store", cloudStoreId, transaction)
+		if !storePermission.CanRead(sessionUser.UserReferenceId, sessionUser.Groups, d.cruds["cloud_store"].AdministratorGroupId) {
+			return importSource{}, api2go.NewHTTPError(nil, "cannot read from cloud store", 403)
 		}
+	}
 
-		log.Infof("Processing import file: %s (%d bytes)", fileName, len(fileBytes))
+	cloudStore, err := loadCloudStore(d.cruds, cloudStoreId, transaction)
+	if err != nil {
+		return importSource{}, err
+	}
 
-		// Detect file format and create appropriate parser
-		format := DetectFileFormat(fileBytes, fileName)
-		parser, err := CreateStreamingImportParser(format)
-		if err != nil {
-			log.Errorf("Failed to create parser for file '%s': %v", fileName, err)
-			errors = append(errors, fmt.Errorf("failed to create parser for file '%s': %w", fileName, err))
-			continue
+	path = strings.TrimLeft(path, "/")
+	return importSource{
+		name:         path,
+		cloudStoreId: cloudStoreId.String(),
+		open: func() (io.ReadCloser, error) {
+			return openCloudStoreFile(cloudStore, path)
+		},
+	}, nil
+}
+
+// resumeImportJob loads an unfinished import job, the import continues with the same table,
+// mode and file. A file uploaded to the first run has to be uploaded again
+func (d *importDataPerformer) resumeImportJob(jobId daptinid.DaptinReferenceId, inFields map[string]interface{},
+	options *importOptions, transaction *sqlx.Tx) (*importJob, []importSource, error) {
+
+	sessionUser, _ := inFields["sessionUser"].(*auth.SessionUser)
+	if sessionUser != nil {
+		jobPermission := d.cruds[importJobTable].GetObjectPermissionByReferenceId(importJobTable, jobId, transaction)
+		if !jobPermission.CanUpdate(sessionUser.UserReferenceId, sessionUser.Groups, d.cruds[importJobTable].AdministratorGroupId) {
+			return nil, nil, api2go.NewHTTPError(nil, "cannot resume import job", 403)
 		}
+	}
 
-		tableNameString := tableName.(string)
-		// Initialize the parser with file content
-		err = parser.Initialize(fileBytes, tableNameString)
-		if err != nil {
-			log.Errorf("Failed to parse file '%s': %v", fileName, err)
-			errors = append(errors, fmt.Errorf("failed to parse file '%s': %w", fileName, err))
-			continue
+	row, err := d.cruds[importJobTable].GetReferenceIdToObjectWithTransaction(importJobTable, jobId, transaction)
+	if err != nil {
+		return nil, nil, fmt.Errorf("import job [%v] not found: %w", jobId, err)
+	}
+	if row["status"] == "completed" {
+		return nil, nil, fmt.Errorf("import job [%v] is already completed", jobId)
+	}
+
+	job := &importJob{referenceId: jobId, exists: true}
+	job.skip, _ = toInt(row["rows_processed"])
+	job.rowsProcessed = job.skip
+	job.rowsImported, _ = toInt(row["rows_imported"])
+	job.rowsFailed, _ = toInt(row["rows_failed"])
+	if report, ok := row["error_report"].(string); ok && report != "" {
+		records, err := csv.NewReader(strings.NewReader(report)).ReadAll()
+		if err == nil && len(records) > 1 {
+			job.errorReport = records[1:]
 		}
+	}
+
+	options.tableName, _ = row["table_name"].(string)
+	if mode, ok := row["mode"].(string); ok && mode != "" {
+		options.mode = mode
+	}
+	if upsertKey, ok := row["upsert_key"].(string); ok && upsertKey != "" {
+		options.upsertKey = upsertKey
+	}
+	// truncating again would remove the rows imported by the earlier run
+	options.truncate = false
 
-		// Get table names from the import file
-		tableNames, err := parser.GetTableNames()
+	if cloudStoreId, ok := row["cloud_store_id"].(string); ok && cloudStoreId != "" {
+		fileName, _ := row["file_name"].(string)
+		source, err := d.cloudStoreSource(daptinid.InterfaceToDIR(cloudStoreId), fileName, inFields, transaction)
 		if err != nil {
-			log.Errorf("Failed to get table names from file '%s': %v", fileName, err)
-			errors = append(errors, fmt.Errorf("failed to get table names from file '%s': %w", fileName, err))
-			continue
+			return nil, nil, err
 		}
+		return job, []importSource{source}, nil
+	}
+	return job, nil, nil
+}
 
-		// Filter tables if a specific table is requested
-		if isSubjected && tableName != nil {
-			targetTable := tableName.(string)
+// importFile imports the rows of one file, calling withBatch to get the transaction for each
+// batch of rows. The job is saved with the batch so a failed import can be resumed from the
+// last batch written. Returns the number of tables imported
+func (d *importDataPerformer) importFile(source importSource, job *importJob, options importOptions,
+	withBatch func(fn func(tx *sqlx.Tx) error) error) (int, error) {
+
+	err := withBatch(func(tx *sqlx.Tx) error {
+		return d.saveImportJob(job, source, options, "running", "", tx)
+	})
+	if err != nil {
+		log.Errorf("Failed to save import job for [%s]: %v", source.name, err)
+		return 0, err
+	}
 
-			tableNames = []string{targetTable}
-		}
+	tableCount, importErr := d.importRows(source, job, options, withBatch)
 
-		// Process each table in the file
-		for _, currentTable := range tableNames {
-			// Skip if we don't have access to this table
-			if _, ok := d.cruds[currentTable]; !ok {
-				log.Warnf("Skipping table [%s]: not accessible", currentTable)
-				continue
-			}
+	status := "completed"
+	message := fmt.Sprintf("%d rows imported, %d rows failed", job.rowsImported, job.rowsFailed)
+	if importErr != nil {
+		status = "failed"
+		message = importErr.Error()
+	}
+	err = withBatch(func(tx *sqlx.Tx) error {
+		return d.saveImportJob(job, source, options, status, message, tx)
+	})
+	if err != nil {
+		log.Errorf("Failed to save import job for [%s]: %v", source.name, err)
+	}
+	return tableCount, importErr
+}
 
-			// Truncate table if requested
-			if truncateBeforeInsert {
-				instance, ok := d.cruds[currentTable]
-				if !ok {
-					log.Warnf("Wanted to truncate table '%s', but no instance available", currentTable)
-					continue
-				}
+func (d *importDataPerformer) importRows(source importSource, job *importJob, options importOptions,
+	withBatch func(fn func(tx *sqlx.Tx) error) error) (int, error) {
 
-				err := instance.TruncateTable(currentTable, false, transaction)
-				if err != nil {
-					log.Errorf("Failed to truncate table '%s': %v", currentTable, err)
-					errors = append(errors, fmt.Errorf("failed to truncate table '%s': %w", currentTable, err))
-				} else {
-					log.Infof("Truncated table '%s' before import", currentTable)
-				}
-			}
+	reader, err := source.open()
+	if err != nil {
+		return 0, err
+	}
+	defer reader.Close()
 
-			tableRowCount := 0
-			tableFailCount := 0
+	// Detect file format from the name and the first bytes and create appropriate parser
+	buffered := bufio.NewReaderSize(reader, importReadBufferSize)
+	head, _ := buffered.Peek(importReadBufferSize)
+	job.format = DetectFileFormat(head, source.name)
+	log.Infof("Processing import file: %s (%s)", source.name, job.format)
 
-			// Process rows in batches
-			err = parser.ParseRows(currentTable, batchSize, func(rows []map[string]interface{}) error {
-				for _, row := range rows {
-					// Add user reference if present
-					if isUserPresent {
-						row[resource.USER_ACCOUNT_TABLE_NAME] = userIdInt
-					}
+	parser, err := CreateStreamingImportParser(job.format)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create parser: %w", err)
+	}
+	defer parser.Close()
+
+	err = parser.Initialize(buffered, options.tableName)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse file: %w", err)
+	}
+
+	// Get table names from the import file
+	tableNames, err := parser.GetTableNames()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get table names: %w", err)
+	}
+
+	// Filter tables if a specific table is requested
+	if options.tableName != "" {
+		tableNames = []string{options.tableName}
+	}
+
+	position := 0
+	tableCount := 0
+	// Process each table in the file
+	for _, currentTable := range tableNames {
+		// Skip if we don't have access to this table
+		instance, ok := d.cruds[currentTable]
+		if !ok {
+			log.Warnf("Skipping table [%s]: not accessible", currentTable)
+			continue
+		}
 
-					err := d.cruds[currentTable].DirectInsert(currentTable, row, transaction)
-					if err != nil {
-						log.Errorf("Failed to insert row into table '%s': %v", currentTable, err)
-						tableFailCount++
-						failedImports++
-					} else {
-						tableRowCount++
-						totalRowsImported++
+		// Truncate table if requested
+		if options.truncate {
+			err = withBatch(func(tx *sqlx.Tx) error {
+				return instance.TruncateTable(currentTable, false, tx)
+			})
+			if err != nil {
+				return tableCount, fmt.Errorf("failed to truncate table '%s': %w", currentTable, err)
+			}
+			log.Infof("Truncated table '%s' before import", currentTable)
+		}
+
+		// Process rows in batches
+		err = parser.ParseRows(currentTable, options.batchSize, func(rows []map[string]interface{}) error {
+			if position+len(rows) <= job.skip {
+				position += len(rows)
+				return nil
+			}
+			err := withBatch(func(tx *sqlx.Tx) error {
+				for _, row := range rows {
+					position++
+					if position <= job.skip {
+						continue
 					}
+					d.importRow(instance, currentTable, position, row, job, options, tx)
 				}
-				return nil
+				return d.saveImportJob(job, source, options, "running", "", tx)
 			})
+			resource.MarkTableChanged(options.ctx, currentTable)
+			return err
+		})
+		if err != nil {
+			return tableCount, fmt.Errorf("error processing rows for table '%s': %w", currentTable, err)
+		}
+		log.Infof("Imported rows into table '%s' (imported: %d, failed: %d)", currentTable, job.rowsImported, job.rowsFailed)
+		tableCount++
+	}
+	return tableCount, nil
+}
 
-			if err != nil {
-				log.Errorf("Error processing rows for table '%s': %v", currentTable, err)
-				errors = append(errors, fmt.Errorf("error processing rows for table '%s': %w", currentTable, err))
-			} else {
-				log.Infof("Successfully imported %d rows into table '%s' (failed: %d)", tableRowCount, currentTable, tableFailCount)
-				successfulImports++
+// importRow writes one row within a savepoint, a failing row is rolled back on its own and
+// added to the error report of the job
+func (d *importDataPerformer) importRow(instance *resource.DbResource, tableName string, position int,
+	row map[string]interface{}, job *importJob, options importOptions, tx *sqlx.Tx) {
+
+	job.rowsProcessed++
+
+	original := resource.ToJson(row)
+	// rows without a valid reference id get a new one
+	referenceId := daptinid.InterfaceToDIR(row["reference_id"])
+	if referenceId == daptinid.NullReferenceId {
+		referenceId = daptinid.DaptinReferenceId(uuid.Must(uuid.NewV7()))
+	}
+	row["reference_id"] = referenceId[:]
+	if options.isUserPresent {
+		row[resource.USER_ACCOUNT_ID_COLUMN] = options.userId
+	}
+
+	req := api2go.Request{PlainRequest: (&http.Request{Method: "POST", URL: &url.URL{Path: "/" + tableName}}).WithContext(options.ctx)}
+	_, err := tx.Exec("SAVEPOINT import_row")
+	if err == nil {
+		if options.mode == "upsert" {
+			err = checkUpsertPermission(instance, tableName, options, row, req, tx)
+			if err == nil {
+				_, err = instance.DirectUpsert(tableName, options.upsertKey, row, req, tx)
 			}
+		} else {
+			instance.SetRowTenant(row, req)
+			err = instance.DirectInsert(tableName, row, tx)
+		}
+		if err != nil {
+			if _, rollbackErr := tx.Exec("ROLLBACK TO SAVEPOINT import_row"); rollbackErr != nil {
+				log.Errorf("Failed to roll back failed import row: %v", rollbackErr)
+			}
+		} else {
+			_, err = tx.Exec("RELEASE SAVEPOINT import_row")
 		}
 	}
 
-	// Create response with import summary
-	duration := time.Since(startTime)
-	responseAttrs := make(map[string]interface{})
-	responseAttrs["message"] = fmt.Sprintf("Import completed in %v. %d rows imported successfully across %d tables.", duration.Round(time.Millisecond), totalRowsImported, successfulImports)
-	responseAttrs["rows_imported"] = totalRowsImported
-	responseAttrs["successful_tables"] = successfulImports
-	responseAttrs["failed_tables"] = failedImports
+	if err != nil {
+		log.Errorf("Failed to import row %d into table '%s': %v", position, tableName, err)
+		job.rowsFailed++
+		if len(job.errorReport) < importErrorReportLimit {
+			job.errorReport = append(job.errorReport, []string{fmt.Sprintf("%d", position), tableName, err.Error(), original})
+		}
+		return
+	}
+	job.rowsImported++
+	job.importedThisRun++
+}
 
-	actionResponse := resource.NewActionResponse("client.notify", responseAttrs)
-	responses = append(responses, actionResponse)
+// checkUpsertPermission refuses an upsert over an existing row the user is not allowed to update
+func checkUpsertPermission(instance *resource.DbResource, tableName string, options importOptions, row map[string]interface{},
+	req api2go.Request, tx *sqlx.Tx) error {
+	if options.sessionUser == nil || options.isAdmin {
+		return nil
+	}
+	existing, err := instance.GetUpsertTargetWithTransaction(tableName, options.upsertKey, row, req, tx)
+	if err != nil || existing == nil {
+		return err
+	}
+	referenceId := daptinid.InterfaceToDIR(existing["reference_id"])
+	permission := instance.GetObjectPermissionByReferenceId(tableName, referenceId, tx)
+	if !permission.CanUpdate(options.sessionUser.UserReferenceId, options.sessionUser.Groups, instance.AdministratorGroupId) {
+		return fmt.Errorf("not allowed to update the existing row [%v]", referenceId)
+	}
+	return nil
+}
 
-	return nil, responses, errors
+// saveImportJob creates or updates the import_job row with the progress of the import
+func (d *importDataPerformer) saveImportJob(job *importJob, source importSource, options importOptions,
+	status string, message string, tx *sqlx.Tx) error {
+
+	now := time.Now()
+	errorReport := importErrorReportCSV(job.errorReport)
+
+	var query string
+	var args []interface{}
+	var err error
+	if !job.exists {
+		var cloudStoreId interface{}
+		if source.cloudStoreId != "" {
+			cloudStoreId = source.cloudStoreId
+		}
+		query, args, err = statementbuilder.Squirrel.Insert(importJobTable).Prepared(true).
+			Cols("table_name", "file_name", "cloud_store_id", "format", "mode", "upsert_key", "status",
+				"rows_processed", "rows_imported", "rows_failed", "error_report", "message", "started_at",
+				resource.USER_ACCOUNT_ID_COLUMN, "reference_id", "permission", "created_at", "updated_at").
+			Vals([]interface{}{options.tableName, source.name, cloudStoreId, string(job.format), options.mode, options.upsertKey, status,
+				job.rowsProcessed, job.rowsImported, job.rowsFailed, errorReport, message, now,
+				options.userId, job.referenceId[:], auth.DEFAULT_PERMISSION, now, now}).ToSQL()
+	} else {
+		record := goqu.Record{
+			"format":         string(job.format),
+			"status":         status,
+			"rows_processed": job.rowsProcessed,
+			"rows_imported":  job.rowsImported,
+			"rows_failed":    job.rowsFailed,
+			"error_report":   errorReport,
+			"message":        message,
+			"updated_at":     now,
+		}
+		if status == "completed" || status == "failed" {
+			record["finished_at"] = now
+		}
+		query, args, err = statementbuilder.Squirrel.Update(importJobTable).Prepared(true).
+			Set(record).Where(goqu.Ex{"reference_id": job.referenceId[:]}).ToSQL()
+	}
+	if err != nil {
+		return err
+	}
+	if _, err = tx.Exec(query, args...); err != nil {
+		return err
+	}
+	job.exists = true
+	return nil
+}
+
+// importErrorReportCSV renders the failed rows of an import as csv, the row number in the file,
+// the table, the error and the row as json
+func importErrorReportCSV(errorReport [][]string) interface{} {
+	if len(errorReport) == 0 {
+		return nil
+	}
+	var buffer bytes.Buffer
+	csvWriter := csv.NewWriter(&buffer)
+	csvWriter.Write([]string{"row", "table", "error", "data"})
+	csvWriter.WriteAll(errorReport)
+	return buffer.String()
 }
 
 // NewImportDataPerformer creates a new instance of the import data performer
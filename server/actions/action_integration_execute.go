@@ -12,6 +12,7 @@ import (
 	"github.com/daptin/daptin/server/auth"
 	daptinid "github.com/daptin/daptin/server/id"
 	"github.com/daptin/daptin/server/resource"
+	"github.com/daptin/daptin/server/telemetry"
 	"github.com/getkin/kin-openapi/openapi2"
 	"github.com/getkin/kin-openapi/openapi2conv"
 	"github.com/getkin/kin-openapi/openapi3"
@@ -102,6 +103,21 @@ func (d *integrationActionPerformer) IsIntegrationPerformer() {}
 
 // Perform integration api
 func (d *integrationActionPerformer) DoAction(request actionresponse.Outcome, inFieldMap map[string]interface{}, transaction *sqlx.Tx) (api2go.Responder, []actionresponse.ActionResponse, []error) {
+	ctx := context.Background()
+	if httpRequest, ok := inFieldMap["httpRequest"].(*http.Request); ok && httpRequest != nil {
+		ctx = httpRequest.Context()
+	}
+	ctx, span := telemetry.StartSpan(ctx, "integration "+d.integration.Name+"."+request.Method)
+	responder, responses, errs := d.doAction(ctx, request, inFieldMap, transaction)
+	var err error
+	if len(errs) > 0 {
+		err = errs[0]
+	}
+	telemetry.EndSpan(span, err)
+	return responder, responses, errs
+}
+
+func (d *integrationActionPerformer) doAction(ctx context.Context, request actionresponse.Outcome, inFieldMap map[string]interface{}, transaction *sqlx.Tx) (api2go.Responder, []actionresponse.ActionResponse, []error) {
 
 	operation, ok := d.commandMap[request.Method]
 	method := d.methodMap[request.Method]
@@ -355,6 +371,8 @@ func (d *integrationActionPerformer) DoAction(request actionresponse.Outcome, in
 	}
 
 	arguments = append(arguments, authArguments...)
+	// the trace context goes on to the integration
+	arguments = append(arguments, req.Header(telemetry.PropagationHeaders(ctx)))
 
 	transportAuth := integrationTransportAuthFromArguments(authArguments, protectedHeaders, protectedQueryParams)
 	var graphqlBody map[string]interface{}
@@ -141,6 +141,14 @@ func (d *mailSendActionPerformer) DoAction(request actionresponse.Outcome, inFie
 		return nil, nil, []error{err}
 	}
 
+	collectContext := context.Background()
+	if httpRequest, ok := inFields["httpRequest"].(*http.Request); ok && httpRequest != nil {
+		collectContext = httpRequest.Context()
+	}
+	if err := d.cruds["contact"].AddCollectedContacts(collectContext, mailFromAddress.String(), mailTo, transaction); err != nil {
+		log.Errorf("Failed to collect recipient addresses for [%v]: %v", mailFromAddress.String(), err)
+	}
+
 	for _, toAddress := range toAddresses {
 		outboxMailBody := d.cruds["outbox"].MailColumnValue("outbox", "mail", finalMail, subject)
 
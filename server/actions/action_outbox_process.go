@@ -19,10 +19,12 @@ import (
 	daptinid "github.com/daptin/daptin/server/id"
 	"github.com/daptin/daptin/server/resource"
 	"github.com/daptin/daptin/server/statementbuilder"
+	"github.com/daptin/daptin/server/telemetry"
 	"github.com/doug-martin/goqu/v9"
 	smtp "github.com/emersion/go-smtp"
 	"github.com/jmoiron/sqlx"
 	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 type outboxProcessActionPerformer struct {
@@ -460,8 +462,13 @@ func sendOutboxMailWith(
 	return nil
 }
 
-func sendOutboxSMTPData(mxHost, ehloHostname, from string, to []string, message []byte) error {
+func sendOutboxSMTPData(mxHost, ehloHostname, from string, to []string, message []byte) (err error) {
 	serverName := strings.TrimSuffix(mxHost, ".")
+	_, span := telemetry.StartSpan(context.Background(), "smtp deliver", attribute.String("server.address", serverName))
+	defer func() {
+		telemetry.EndSpan(span, err)
+	}()
+
 	c, err := smtp.Dial(net.JoinHostPort(serverName, "25"))
 	if err != nil {
 		return err
@@ -0,0 +1,72 @@
+package actions
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/artpar/api2go/v2"
+	"github.com/daptin/daptin/server/actionresponse"
+	daptinid "github.com/daptin/daptin/server/id"
+	"github.com/daptin/daptin/server/resource"
+	"github.com/jmoiron/sqlx"
+)
+
+// rollupColumnsRecomputePerformer rebuilds the rollup columns of a table from the related rows, of a
+// single row when a reference id is given
+type rollupColumnsRecomputePerformer struct {
+	cruds map[string]*resource.DbResource
+}
+
+func (d *rollupColumnsRecomputePerformer) Name() string {
+	return "rollup_columns.recompute"
+}
+
+func (d *rollupColumnsRecomputePerformer) DoAction(request actionresponse.Outcome, inFieldMap map[string]interface{}, transaction *sqlx.Tx) (api2go.Responder, []actionresponse.ActionResponse, []error) {
+
+	tableName, _ := inFieldMap["table_name"].(string)
+	dbResource, ok := d.cruds[tableName]
+	if !ok {
+		return nil, nil, []error{fmt.Errorf("unknown table [%v]", tableName)}
+	}
+
+	var ids []int64
+	message := fmt.Sprintf("Recomputed rollup columns of %v", tableName)
+	if referenceId := daptinid.InterfaceToDIR(inFieldMap["reference_id"]); referenceId != daptinid.NullReferenceId {
+		id, err := resource.GetReferenceIdToIdWithTransaction(tableName, referenceId, transaction)
+		if err != nil {
+			return nil, nil, []error{fmt.Errorf("unknown %v [%v]", tableName, referenceId)}
+		}
+		ids = []int64{id}
+		message = fmt.Sprintf("Recomputed rollup columns of %v [%v]", tableName, referenceId)
+	}
+
+	err := dbResource.RecomputeRollupColumns(ids, transaction)
+	if err != nil {
+		return nil, nil, []error{err}
+	}
+	ctx := context.Background()
+	if httpRequest, ok := inFieldMap["httpRequest"].(*http.Request); ok && httpRequest != nil {
+		ctx = httpRequest.Context()
+	}
+	resource.MarkTableChanged(ctx, tableName)
+
+	return nil, []actionresponse.ActionResponse{
+		resource.NewActionResponse("client.notify", map[string]interface{}{
+			"type":    "success",
+			"title":   "Success",
+			"message": message,
+		}),
+	}, nil
+}
+
+// NewRollupColumnsRecomputePerformer creates the performer behind the recompute_rollups action of tables
+// with rollup columns
+func NewRollupColumnsRecomputePerformer(cruds map[string]*resource.DbResource) (actionresponse.ActionPerformerInterface, error) {
+
+	handler := rollupColumnsRecomputePerformer{
+		cruds: cruds,
+	}
+
+	return &handler, nil
+}
@@ -0,0 +1,34 @@
+package actions
+
+import (
+	"github.com/artpar/api2go/v2"
+	"github.com/daptin/daptin/server/actionresponse"
+	"github.com/daptin/daptin/server/resource"
+	"github.com/jmoiron/sqlx"
+)
+
+// rollupRefreshPerformer rebuilds and applies the pending changes of the materialized rollups, run
+// by the task scheduler every 10 seconds
+type rollupRefreshPerformer struct {
+	cruds map[string]*resource.DbResource
+}
+
+func (d *rollupRefreshPerformer) Name() string {
+	return "rollup.refresh"
+}
+
+func (d *rollupRefreshPerformer) DoAction(request actionresponse.Outcome, inFields map[string]interface{}, transaction *sqlx.Tx) (api2go.Responder, []actionresponse.ActionResponse, []error) {
+	// every rollup is refreshed in a transaction of its own, under a lease on its row
+	resource.RefreshRollups(d.cruds)
+	return nil, []actionresponse.ActionResponse{}, nil
+}
+
+// NewRollupRefreshPerformer creates the performer behind the refresh_rollups action
+func NewRollupRefreshPerformer(cruds map[string]*resource.DbResource) (actionresponse.ActionPerformerInterface, error) {
+
+	handler := rollupRefreshPerformer{
+		cruds: cruds,
+	}
+
+	return &handler, nil
+}
@@ -0,0 +1,60 @@
+package actions
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/artpar/api2go/v2"
+	"github.com/daptin/daptin/server/actionresponse"
+	daptinid "github.com/daptin/daptin/server/id"
+	"github.com/daptin/daptin/server/resource"
+	"github.com/jmoiron/sqlx"
+)
+
+// rowRestorePerformer brings back a soft deleted row, along with the rows deleted with it
+type rowRestorePerformer struct {
+	cruds map[string]*resource.DbResource
+}
+
+func (d *rowRestorePerformer) Name() string {
+	return "row.restore"
+}
+
+func (d *rowRestorePerformer) DoAction(request actionresponse.Outcome, inFieldMap map[string]interface{}, transaction *sqlx.Tx) (api2go.Responder, []actionresponse.ActionResponse, []error) {
+
+	tableName, _ := inFieldMap["table_name"].(string)
+	dbResource, ok := d.cruds[tableName]
+	if !ok {
+		return nil, nil, []error{fmt.Errorf("unknown table [%v]", tableName)}
+	}
+
+	referenceId := daptinid.InterfaceToDIR(inFieldMap["reference_id"])
+	if referenceId == daptinid.NullReferenceId {
+		return nil, nil, []error{errors.New("invalid reference_id")}
+	}
+
+	httpRequest, _ := inFieldMap["httpRequest"].(*http.Request)
+	err := dbResource.RestoreWithTransaction(referenceId, api2go.Request{PlainRequest: httpRequest}, transaction)
+	if err != nil {
+		return nil, nil, []error{err}
+	}
+
+	return nil, []actionresponse.ActionResponse{
+		resource.NewActionResponse("client.notify", map[string]interface{}{
+			"type":    "success",
+			"title":   "Success",
+			"message": "Restored",
+		}),
+	}, nil
+}
+
+// NewRowRestorePerformer creates the performer behind the restore action of soft delete tables
+func NewRowRestorePerformer(cruds map[string]*resource.DbResource) (actionresponse.ActionPerformerInterface, error) {
+
+	handler := rowRestorePerformer{
+		cruds: cruds,
+	}
+
+	return &handler, nil
+}
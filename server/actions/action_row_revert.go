@@ -0,0 +1,89 @@
+package actions
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/artpar/api2go/v2"
+	"github.com/daptin/daptin/server/actionresponse"
+	"github.com/daptin/daptin/server/auth"
+	daptinid "github.com/daptin/daptin/server/id"
+	"github.com/daptin/daptin/server/resource"
+	"github.com/jmoiron/sqlx"
+)
+
+// rowRevertPerformer updates a row of an audited table back to an earlier version
+type rowRevertPerformer struct {
+	cruds map[string]*resource.DbResource
+}
+
+func (d *rowRevertPerformer) Name() string {
+	return "row.revert"
+}
+
+func (d *rowRevertPerformer) DoAction(request actionresponse.Outcome, inFieldMap map[string]interface{}, transaction *sqlx.Tx) (api2go.Responder, []actionresponse.ActionResponse, []error) {
+
+	tableName, _ := inFieldMap["table_name"].(string)
+	dbResource, ok := d.cruds[tableName]
+	if !ok {
+		return nil, nil, []error{fmt.Errorf("unknown table [%v]", tableName)}
+	}
+
+	referenceId := daptinid.InterfaceToDIR(inFieldMap["reference_id"])
+	if referenceId == daptinid.NullReferenceId {
+		return nil, nil, []error{errors.New("invalid reference_id")}
+	}
+
+	version, ok := toInt(inFieldMap["version"])
+	if versionString, isString := inFieldMap["version"].(string); isString {
+		parsed, err := strconv.Atoi(versionString)
+		version, ok = parsed, err == nil
+	}
+	if !ok || version < 1 {
+		return nil, nil, []error{errors.New("invalid version")}
+	}
+
+	// the update is checked against the permissions the user had before the action ran
+	httpRequest, ok := inFieldMap["httpRequest"].(*http.Request)
+	if !ok || httpRequest == nil {
+		return nil, nil, []error{errors.New("no request to revert in")}
+	}
+	requestSessionUser, ok := inFieldMap["requestSessionUser"].(*auth.SessionUser)
+	if !ok || requestSessionUser == nil {
+		requestSessionUser = &auth.SessionUser{}
+	}
+	pr := &http.Request{
+		Method: "PATCH",
+		URL:    &url.URL{Path: "/api/" + tableName + "/" + referenceId.String()},
+	}
+	pr = pr.WithContext(context.WithValue(httpRequest.Context(), "user", requestSessionUser))
+
+	responder, err := dbResource.RevertToVersionWithTransaction(referenceId, int64(version), api2go.Request{
+		PlainRequest: pr,
+	}, transaction)
+	if err != nil {
+		return nil, nil, []error{err}
+	}
+
+	return responder, []actionresponse.ActionResponse{
+		resource.NewActionResponse("client.notify", map[string]interface{}{
+			"type":    "success",
+			"title":   "Success",
+			"message": fmt.Sprintf("Reverted to version %d", version),
+		}),
+	}, nil
+}
+
+// NewRowRevertPerformer creates the performer behind the revert_to_version action of audited tables
+func NewRowRevertPerformer(cruds map[string]*resource.DbResource) (actionresponse.ActionPerformerInterface, error) {
+
+	handler := rowRevertPerformer{
+		cruds: cruds,
+	}
+
+	return &handler, nil
+}
@@ -115,6 +115,10 @@ func (d *syncSiteStorageActionPerformer) DoAction(request actionresponse.Outcome
 			log.Printf("Hugo command response for [%v] [%v]: %v", tempDirectoryPath, tempDirectoryPath+"/"+"public", hugoCommandResponse)
 		}
 
+		if err == nil {
+			createSyncDeployment(d.cruds, siteId, tempDirectoryPath)
+		}
+
 		return err
 	})
 
@@ -128,6 +132,31 @@ func (d *syncSiteStorageActionPerformer) DoAction(request actionresponse.Outcome
 	return nil, responses, nil
 }
 
+// createSyncDeployment records the synced files as a new deployment of a site which is already served
+// from deployments, so a sync goes live the same way an upload does
+func createSyncDeployment(cruds map[string]*resource.DbResource, siteId daptinid.DaptinReferenceId, syncedPath string) {
+	performer, ok := resource.GetGlobalActionHandler("site.deployment.create")
+	if !ok {
+		return
+	}
+	transaction, err := cruds["site"].Connection().Beginx()
+	if resource.CheckErr(err, "Failed to begin transaction for site deployment") {
+		return
+	}
+	_, _, errs := performer.DoAction(actionresponse.Outcome{}, map[string]interface{}{
+		"site_id":          siteId,
+		"source_path":      syncedPath,
+		"only_if_deployed": true,
+	}, transaction)
+	if len(errs) > 0 {
+		_ = transaction.Rollback()
+		log.Errorf("Failed to deploy synced files of site [%v]: %v", siteId, errs)
+		return
+	}
+	err = transaction.Commit()
+	resource.CheckErr(err, "Failed to commit site deployment")
+}
+
 func NewSyncSiteStorageActionPerformer(cruds map[string]*resource.DbResource) (actionresponse.ActionPerformerInterface, error) {
 
 	handler := syncSiteStorageActionPerformer{
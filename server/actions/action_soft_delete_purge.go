@@ -0,0 +1,34 @@
+package actions
+
+import (
+	"github.com/artpar/api2go/v2"
+	"github.com/daptin/daptin/server/actionresponse"
+	"github.com/daptin/daptin/server/resource"
+	"github.com/jmoiron/sqlx"
+)
+
+// softDeletePurgePerformer removes the rows of soft delete tables deleted longer ago than their
+// retention, run by the task scheduler every hour
+type softDeletePurgePerformer struct {
+	cruds map[string]*resource.DbResource
+}
+
+func (d *softDeletePurgePerformer) Name() string {
+	return "soft_delete.purge"
+}
+
+func (d *softDeletePurgePerformer) DoAction(request actionresponse.Outcome, inFields map[string]interface{}, transaction *sqlx.Tx) (api2go.Responder, []actionresponse.ActionResponse, []error) {
+	// every table is purged in a transaction of its own
+	resource.PurgeSoftDeletedRows(d.cruds)
+	return nil, []actionresponse.ActionResponse{}, nil
+}
+
+// NewSoftDeletePurgePerformer creates the performer behind the purge_deleted_rows action
+func NewSoftDeletePurgePerformer(cruds map[string]*resource.DbResource) (actionresponse.ActionPerformerInterface, error) {
+
+	handler := softDeletePurgePerformer{
+		cruds: cruds,
+	}
+
+	return &handler, nil
+}
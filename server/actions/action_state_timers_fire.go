@@ -0,0 +1,34 @@
+package actions
+
+import (
+	"github.com/artpar/api2go/v2"
+	"github.com/daptin/daptin/server/actionresponse"
+	"github.com/daptin/daptin/server/resource"
+	"github.com/jmoiron/sqlx"
+)
+
+// stateTimersFirePerformer fires the events of state machines whose After duration has passed,
+// run by the task scheduler every minute
+type stateTimersFirePerformer struct {
+	cruds map[string]*resource.DbResource
+}
+
+func (d *stateTimersFirePerformer) Name() string {
+	return "state.timers.fire"
+}
+
+func (d *stateTimersFirePerformer) DoAction(request actionresponse.Outcome, inFields map[string]interface{}, transaction *sqlx.Tx) (api2go.Responder, []actionresponse.ActionResponse, []error) {
+	// every timer fires in a transaction of its own, as the owner of the object
+	resource.FireStateTimers(d.cruds)
+	return nil, []actionresponse.ActionResponse{}, nil
+}
+
+// NewStateTimersFirePerformer creates the performer behind the fire_state_timers action
+func NewStateTimersFirePerformer(cruds map[string]*resource.DbResource) (actionresponse.ActionPerformerInterface, error) {
+
+	handler := stateTimersFirePerformer{
+		cruds: cruds,
+	}
+
+	return &handler, nil
+}
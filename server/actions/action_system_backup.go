@@ -0,0 +1,244 @@
+package actions
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/artpar/api2go/v2"
+	"github.com/daptin/daptin/server/actionresponse"
+	"github.com/daptin/daptin/server/auth"
+	"github.com/daptin/daptin/server/backup"
+	daptinid "github.com/daptin/daptin/server/id"
+	"github.com/daptin/daptin/server/resource"
+	"github.com/jmoiron/sqlx"
+	log "github.com/sirupsen/logrus"
+)
+
+// systemBackupPerformer writes a backup of the instance, to download or to a cloud store
+type systemBackupPerformer struct {
+	cruds map[string]*resource.DbResource
+}
+
+// Name returns the name of this action
+func (d *systemBackupPerformer) Name() string {
+	return "__system_backup"
+}
+
+// DoAction writes the backup in the action transaction, so it holds the rows as they were when the
+// action began
+func (d *systemBackupPerformer) DoAction(request actionresponse.Outcome, inFields map[string]interface{}, transaction *sqlx.Tx) (api2go.Responder, []actionresponse.ActionResponse, []error) {
+
+	options := backup.Options{IncludeFiles: true}
+	if includeFiles, ok := inFields["include_files"].(bool); ok {
+		options.IncludeFiles = includeFiles
+	}
+	options.EncryptionKey, _ = d.cruds["world"].ConfigStore.GetConfigValueFor(backup.EncryptionKeyConfig, "backend", transaction)
+	fileName := backup.FileName(time.Now(), options.EncryptionKey != "")
+	contentType := "application/gzip"
+	if options.EncryptionKey != "" {
+		contentType = "application/octet-stream"
+	}
+
+	cloudStoreId, _ := inFields["cloud_store_id"].(string)
+	if cloudStoreId == "" {
+		var content bytes.Buffer
+		_, err := backup.Write(&content, transaction, options)
+		if err != nil {
+			log.Errorf("Failed to write backup: %v", err)
+			return nil, nil, []error{err}
+		}
+
+		responseAttrs := make(map[string]interface{})
+		responseAttrs["content"] = base64.StdEncoding.EncodeToString(content.Bytes())
+		responseAttrs["name"] = fileName
+		responseAttrs["contentType"] = contentType
+		responseAttrs["message"] = "Downloading backup"
+		return nil, []actionresponse.ActionResponse{resource.NewActionResponse("client.file.download", responseAttrs)}, nil
+	}
+
+	// a scheduled backup leaves the instance unattended, its secrets are not sent out in the clear
+	if options.EncryptionKey == "" && isScheduledRun(inFields) {
+		return nil, nil, []error{api2go.NewHTTPError(backup.ErrEncryptionKeyRequired,
+			fmt.Sprintf("set the %s config to back up to a cloud store on a schedule", backup.EncryptionKeyConfig), 400)}
+	}
+
+	storeId := daptinid.InterfaceToDIR(cloudStoreId)
+	sessionUser, _ := inFields["sessionUser"].(*auth.SessionUser)
+	if sessionUser != nil {
+		storePermission := d.cruds["cloud_store"].GetObjectPermissionByReferenceId("cloud_store", storeId, transaction)
+		if !storePermission.CanCreate(sessionUser.UserReferenceId, sessionUser.Groups, d.cruds["cloud_store"].AdministratorGroupId) {
+			return nil, nil, []error{api2go.NewHTTPError(nil, "cannot write to cloud store", 403)}
+		}
+	}
+	cloudStore, err := loadCloudStore(d.cruds, storeId, transaction)
+	if err != nil {
+		return nil, nil, []error{err}
+	}
+
+	// path is a directory unless it names a file with an extension
+	path := strings.Trim(fmt.Sprintf("%v", inFields["path"]), "/")
+	if inFields["path"] == nil || path == "" {
+		path = fileName
+	} else if !strings.Contains(path[strings.LastIndex(path, "/")+1:], ".") {
+		path = path + "/" + fileName
+	}
+
+	var manifest backup.Manifest
+	err = writeCloudStoreFile(cloudStore, path, func(output io.Writer) error {
+		var err error
+		manifest, err = backup.Write(output, transaction, options)
+		return err
+	})
+	if err != nil {
+		log.Errorf("Failed to write backup to [%v]: %v", path, err)
+		return nil, nil, []error{err}
+	}
+
+	link, err := cloudStoreLink(cloudStore, path, 24*time.Hour)
+	if err != nil {
+		log.Infof("Cloud store [%s] gives no link to [%s]: %v", cloudStore.Name, path, err)
+	}
+
+	responseAttrs := make(map[string]interface{})
+	responseAttrs["type"] = "success"
+	responseAttrs["title"] = "Success"
+	responseAttrs["message"] = fmt.Sprintf("Backup of %d tables written to %s", len(manifest.Tables), path)
+	responseAttrs["path"] = path
+	responseAttrs["download_url"] = link
+	return nil, []actionresponse.ActionResponse{resource.NewActionResponse("client.notify", responseAttrs)}, nil
+}
+
+// NewSystemBackupPerformer creates the performer behind the backup_system action
+func NewSystemBackupPerformer(cruds map[string]*resource.DbResource) (actionresponse.ActionPerformerInterface, error) {
+
+	handler := systemBackupPerformer{
+		cruds: cruds,
+	}
+
+	return &handler, nil
+}
+
+// isScheduledRun is true when the action was run by the task scheduler
+func isScheduledRun(inFields map[string]interface{}) bool {
+	httpRequest, ok := inFields["httpRequest"].(*http.Request)
+	if !ok || httpRequest == nil {
+		return false
+	}
+	return httpRequest.Context().Value(resource.ScheduledTaskContextKey) != nil
+}
+
+// systemRestorePerformer replaces the data of the instance with the data of a backup
+type systemRestorePerformer struct {
+	cruds map[string]*resource.DbResource
+}
+
+// Name returns the name of this action
+func (d *systemRestorePerformer) Name() string {
+	return "__system_restore"
+}
+
+// DoAction restores the backup in the action transaction, a failed restore leaves the data as it was
+func (d *systemRestorePerformer) DoAction(request actionresponse.Outcome, inFields map[string]interface{}, transaction *sqlx.Tx) (api2go.Responder, []actionresponse.ActionResponse, []error) {
+
+	input, err := d.backupSource(inFields, transaction)
+	if err != nil {
+		return nil, nil, []error{err}
+	}
+	defer input.Close()
+
+	encryptionKey, _ := d.cruds["world"].ConfigStore.GetConfigValueFor(backup.EncryptionKeyConfig, "backend", transaction)
+	reader, err := backup.NewReader(input, encryptionKey)
+	if err == backup.ErrEncryptionKeyRequired {
+		return nil, nil, []error{api2go.NewHTTPError(err, fmt.Sprintf("set the %s config to restore an encrypted backup", backup.EncryptionKeyConfig), 400)}
+	}
+	if err != nil {
+		return nil, nil, []error{err}
+	}
+	defer reader.Close()
+
+	result, err := reader.Restore(transaction)
+	if err != nil {
+		log.Errorf("Failed to restore backup: %v", err)
+		return nil, nil, []error{err}
+	}
+
+	// cached results of the restored tables are dropped once the action transaction is committed
+	ctx := context.Background()
+	if httpRequest, ok := inFields["httpRequest"].(*http.Request); ok && httpRequest != nil {
+		ctx = httpRequest.Context()
+	}
+	rowCount := 0
+	for tableName, count := range result.Rows {
+		rowCount += count
+		resource.MarkTableChanged(ctx, tableName)
+	}
+	message := fmt.Sprintf("Restored %d rows of %d tables and %d files from the backup of %s, restart daptin to load the restored schema",
+		rowCount, len(result.Rows), result.Files, reader.Manifest.CreatedAt.Format(time.RFC3339))
+	if len(result.Skipped) > 0 {
+		sort.Strings(result.Skipped)
+		message = fmt.Sprintf("%s. Tables not in this instance were skipped: %s", message, strings.Join(result.Skipped, ", "))
+	}
+
+	responseAttrs := make(map[string]interface{})
+	responseAttrs["type"] = "success"
+	responseAttrs["title"] = "Success"
+	responseAttrs["message"] = message
+	responseAttrs["rows"] = result.Rows
+	responseAttrs["skipped_tables"] = result.Skipped
+	return nil, []actionresponse.ActionResponse{resource.NewActionResponse("client.notify", responseAttrs)}, nil
+}
+
+// backupSource opens the uploaded backup, or the backup at path in the cloud store
+func (d *systemRestorePerformer) backupSource(inFields map[string]interface{}, transaction *sqlx.Tx) (io.ReadCloser, error) {
+	if cloudStoreId, ok := inFields["cloud_store_id"].(string); ok && cloudStoreId != "" {
+		path, _ := inFields["path"].(string)
+		if path == "" {
+			return nil, fmt.Errorf("path of the backup in the cloud store is required")
+		}
+		storeId := daptinid.InterfaceToDIR(cloudStoreId)
+		sessionUser, _ := inFields["sessionUser"].(*auth.SessionUser)
+		if sessionUser != nil {
+			storePermission := d.cruds["cloud_store"].GetObjectPermissionByReferenceId("cloud_store", storeId, transaction)
+			if !storePermission.CanRead(sessionUser.UserReferenceId, sessionUser.Groups, d.cruds["cloud_store"].AdministratorGroupId) {
+				return nil, api2go.NewHTTPError(nil, "cannot read from cloud store", 403)
+			}
+		}
+		cloudStore, err := loadCloudStore(d.cruds, storeId, transaction)
+		if err != nil {
+			return nil, err
+		}
+		return openCloudStoreFile(cloudStore, strings.TrimLeft(path, "/"))
+	}
+
+	files, _ := inFields["backup_file"].([]interface{})
+	if len(files) != 1 {
+		return nil, fmt.Errorf("upload one backup file or give the cloud_store_id and path of one")
+	}
+	file, _ := files[0].(map[string]interface{})
+	contents, ok := file["file"].(string)
+	if !ok {
+		return nil, fmt.Errorf("backup file has no contents")
+	}
+	// skip a data url prefix
+	if comma := strings.Index(contents, ","); comma > -1 {
+		contents = contents[comma+1:]
+	}
+	return io.NopCloser(base64.NewDecoder(base64.StdEncoding, strings.NewReader(contents))), nil
+}
+
+// NewSystemRestorePerformer creates the performer behind the restore_system action
+func NewSystemRestorePerformer(cruds map[string]*resource.DbResource) (actionresponse.ActionPerformerInterface, error) {
+
+	handler := systemRestorePerformer{
+		cruds: cruds,
+	}
+
+	return &handler, nil
+}
@@ -0,0 +1,97 @@
+package actions
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/artpar/rclone/fs"
+	"github.com/artpar/rclone/fs/config"
+	"github.com/artpar/rclone/fs/operations"
+	daptinid "github.com/daptin/daptin/server/id"
+	"github.com/daptin/daptin/server/resource"
+	"github.com/daptin/daptin/server/rootpojo"
+	"github.com/jmoiron/sqlx"
+)
+
+// loadCloudStore reads the cloud store and sets its credential in the rclone config
+func loadCloudStore(cruds map[string]*resource.DbResource, cloudStoreId daptinid.DaptinReferenceId, transaction *sqlx.Tx) (rootpojo.CloudStore, error) {
+	cloudStore, err := cruds["cloud_store"].GetCloudStoreByReferenceId(cloudStoreId, transaction)
+	if err != nil {
+		return cloudStore, err
+	}
+	if cloudStore.RootPath == "" {
+		return cloudStore, fmt.Errorf("cloud store [%v] not found", cloudStoreId)
+	}
+
+	configSetName := cloudStore.Name
+	if strings.Index(cloudStore.RootPath, ":") > -1 {
+		configSetName = strings.Split(cloudStore.RootPath, ":")[0]
+	}
+	if cloudStore.CredentialName != "" {
+		cred, err := cruds["credential"].GetCredentialByName(cloudStore.CredentialName, transaction)
+		resource.CheckErr(err, fmt.Sprintf("Failed to get credential for [%s]", cloudStore.CredentialName))
+		if cred != nil && cred.DataMap != nil {
+			for key, val := range cred.DataMap {
+				config.Data().SetValue(configSetName, key, fmt.Sprintf("%s", val))
+			}
+		}
+	}
+	return cloudStore, nil
+}
+
+// openCloudStoreFile opens the file at path in the cloud store for reading
+func openCloudStoreFile(cloudStore rootpojo.CloudStore, path string) (io.ReadCloser, error) {
+	ctx := context.Background()
+	storeFs, err := fs.NewFs(ctx, cloudStore.RootPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open cloud store [%s]: %w", cloudStore.Name, err)
+	}
+	object, err := storeFs.NewObject(ctx, path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find [%s] in cloud store [%s]: %w", path, cloudStore.Name, err)
+	}
+	return object.Open(ctx)
+}
+
+// writeCloudStoreFile uploads what write writes to the file at path in the cloud store as it is
+// written, the content is not held in memory
+func writeCloudStoreFile(cloudStore rootpojo.CloudStore, path string, write func(output io.Writer) error) error {
+	ctx := context.Background()
+	storeFs, err := fs.NewFs(ctx, cloudStore.RootPath)
+	if err != nil {
+		return fmt.Errorf("failed to open cloud store [%s]: %w", cloudStore.Name, err)
+	}
+
+	reader, writer := io.Pipe()
+	uploaded := make(chan error, 1)
+	go func() {
+		_, err := operations.Rcat(ctx, storeFs, path, reader, time.Now(), nil)
+		reader.CloseWithError(err)
+		uploaded <- err
+	}()
+
+	err = write(writer)
+	writer.CloseWithError(err)
+	uploadErr := <-uploaded
+	if err != nil {
+		return err
+	}
+	if uploadErr != nil {
+		return fmt.Errorf("failed to upload [%s] to cloud store [%s]: %w", path, cloudStore.Name, uploadErr)
+	}
+	return nil
+}
+
+// cloudStoreLink returns a link to download the file without credentials, presigned for stores
+// like s3 and valid for expiry. Not every store supports links
+func cloudStoreLink(cloudStore rootpojo.CloudStore, path string, expiry time.Duration) (string, error) {
+	ctx := context.Background()
+	storeFs, err := fs.NewFs(ctx, cloudStore.RootPath)
+	if err != nil {
+		return "", err
+	}
+	return operations.PublicLink(ctx, storeFs, path, fs.Duration(expiry), false)
+}
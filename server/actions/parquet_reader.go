@@ -0,0 +1,162 @@
+package actions
+
+import (
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/parquet-go/parquet-go/format"
+)
+
+// Parquet files are decoded by parquet-go, the functions below turn the rows it reconstructs into
+// the rows of an import: a row has a value for each top level field, groups are maps, repeated
+// fields and LIST groups are slices and MAP groups are maps keyed by the text of the key.
+
+var parquetMagic = []byte("PAR1")
+
+// parquetColumnNames returns the top level fields of the schema
+func parquetColumnNames(schema *parquet.Schema) []string {
+	fields := schema.Fields()
+	names := make([]string, 0, len(fields))
+	for _, field := range fields {
+		names = append(names, field.Name())
+	}
+	return names
+}
+
+// readParquetRow reconstructs a row of the file and presents its values
+func readParquetRow(schema *parquet.Schema, values parquet.Row) (map[string]interface{}, error) {
+	row := make(map[string]interface{})
+	if err := schema.Reconstruct(&row, values); err != nil {
+		return nil, err
+	}
+	for _, field := range schema.Fields() {
+		row[field.Name()] = presentParquetValue(field, row[field.Name()])
+	}
+	return row, nil
+}
+
+// presentParquetValue converts a reconstructed value using the logical type of the field
+func presentParquetValue(node parquet.Node, value interface{}) interface{} {
+	if value == nil {
+		switch {
+		case node.Repeated():
+			// a repeated field is never null, it has no values
+			return []interface{}{}
+		case node.Optional() || node.Leaf():
+			return nil
+		}
+		// a required LIST or MAP group without entries is read as nothing
+	}
+	if list, ok := value.([]interface{}); ok && node.Repeated() {
+		for i, item := range list {
+			list[i] = presentParquetElement(node, item)
+		}
+		return list
+	}
+	return presentParquetElement(node, value)
+}
+
+// presentParquetElement presents one value of a field
+func presentParquetElement(node parquet.Node, value interface{}) interface{} {
+	logicalType := node.Type().LogicalType()
+	if node.Leaf() {
+		return presentParquetLeaf(logicalType, value)
+	}
+
+	fields := node.Fields()
+	switch {
+	case logicalType != nil && logicalType.List != nil && len(fields) == 1:
+		// the repeated field is the element unless it is a group of a single field
+		repeated := fields[0]
+		list, _ := value.([]interface{})
+		elements := make([]interface{}, len(list))
+		for i, item := range list {
+			if len(repeated.Fields()) == 1 {
+				elements[i] = presentParquetValue(repeated.Fields()[0], item)
+			} else {
+				elements[i] = presentParquetElement(repeated, item)
+			}
+		}
+		return elements
+
+	case logicalType != nil && logicalType.Map != nil && len(fields) == 1 && len(fields[0].Fields()) == 2:
+		mapValue := fields[0].Fields()[1]
+		entries := make(map[string]interface{})
+		if group, ok := value.(map[string]interface{}); ok {
+			for key, item := range group {
+				entries[key] = presentParquetValue(mapValue, item)
+			}
+		}
+		return entries
+	}
+
+	group, ok := value.(map[string]interface{})
+	if !ok {
+		return value
+	}
+	for _, field := range fields {
+		group[field.Name()] = presentParquetValue(field, group[field.Name()])
+	}
+	return group
+}
+
+// presentParquetLeaf converts a value of a column, integers are widened to int64 and floats to
+// float64 like the values of the other import formats
+func presentParquetLeaf(logicalType *format.LogicalType, value interface{}) interface{} {
+	switch v := value.(type) {
+	case int32:
+		return presentParquetLeaf(logicalType, int64(v))
+	case float32:
+		return float64(v)
+	case int64:
+		switch {
+		case logicalType == nil:
+		case logicalType.Decimal != nil:
+			return formatParquetDecimal(big.NewInt(v), logicalType.Decimal.Scale)
+		case logicalType.Date != nil:
+			return time.Unix(v*86400, 0).UTC().Format("2006-01-02")
+		case logicalType.Timestamp != nil && logicalType.Timestamp.Unit.Millis != nil:
+			return time.UnixMilli(v).UTC()
+		case logicalType.Timestamp != nil && logicalType.Timestamp.Unit.Micros != nil:
+			return time.UnixMicro(v).UTC()
+		case logicalType.Timestamp != nil && logicalType.Timestamp.Unit.Nanos != nil:
+			return time.Unix(0, v).UTC()
+		}
+		return v
+	case time.Time:
+		return v.UTC()
+	case []byte:
+		switch {
+		case logicalType == nil:
+		case logicalType.Decimal != nil:
+			unscaled := new(big.Int).SetBytes(v)
+			if len(v) > 0 && v[0]&0x80 != 0 {
+				unscaled.Sub(unscaled, new(big.Int).Lsh(big.NewInt(1), uint(len(v)*8)))
+			}
+			return formatParquetDecimal(unscaled, logicalType.Decimal.Scale)
+		case logicalType.Json != nil:
+			var decoded interface{}
+			if err := json.Unmarshal(v, &decoded); err == nil {
+				return decoded
+			}
+		}
+		return string(v)
+	}
+	return value
+}
+
+func formatParquetDecimal(unscaled *big.Int, scale int32) string {
+	digits := new(big.Int).Abs(unscaled).String()
+	if scale > 0 {
+		if int32(len(digits)) <= scale {
+			digits = strings.Repeat("0", int(scale)-len(digits)+1) + digits
+		}
+		digits = digits[:int32(len(digits))-scale] + "." + digits[int32(len(digits))-scale:]
+	}
+	if unscaled.Sign() < 0 {
+		digits = "-" + digits
+	}
+	return digits
+}
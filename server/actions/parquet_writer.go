@@ -0,0 +1,273 @@
+package actions
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+	log "github.com/sirupsen/logrus"
+)
+
+// The parquet writer below writes a flat schema of optional columns with parquet-go. Column types
+// are taken from the values of the first row group, maps and slices are written as JSON text
+// columns. parquet-go orders the columns of a group by name.
+
+// rows buffered in memory before they are written out as a row group
+const parquetRowGroupSize = 10000
+
+// types of the columns written to a parquet file
+const (
+	parquetText = iota
+	parquetInteger
+	parquetDouble
+	parquetBoolean
+	parquetTimestamp
+	parquetJson
+)
+
+type parquetColumn struct {
+	name string
+	kind int
+}
+
+// StreamingParquetWriter implements streaming Apache Parquet export of a single table
+type StreamingParquetWriter struct {
+	output    io.Writer
+	tableName string
+	names     []string
+	columns   []parquetColumn
+	rows      []map[string]interface{}
+	writer    *parquet.Writer
+}
+
+// Initialize prepares the Parquet writer
+func (w *StreamingParquetWriter) Initialize(output io.Writer, tableNames []string, includeHeaders bool, selectedColumns map[string][]string) error {
+	if len(tableNames) != 1 {
+		return errors.New("parquet export needs exactly one table or stream")
+	}
+	w.output = output
+	w.tableName = tableNames[0]
+	w.rows = make([]map[string]interface{}, 0)
+	return nil
+}
+
+// WriteTable is a no-op, a parquet file holds one table
+func (w *StreamingParquetWriter) WriteTable(tableName string) error {
+	return nil
+}
+
+// WriteHeaders sets the columns of the file
+func (w *StreamingParquetWriter) WriteHeaders(tableName string, columns []string) error {
+	if w.names == nil {
+		w.names = columns
+	}
+	return nil
+}
+
+// WriteRows buffers the rows and writes a row group when enough rows are buffered
+func (w *StreamingParquetWriter) WriteRows(tableName string, rows []map[string]interface{}) error {
+	log.Infof("Writing [%d] rows", len(rows))
+	w.rows = append(w.rows, rows...)
+	for len(w.rows) >= parquetRowGroupSize {
+		if err := w.flushRowGroup(w.rows[:parquetRowGroupSize]); err != nil {
+			return err
+		}
+		w.rows = append(w.rows[:0:0], w.rows[parquetRowGroupSize:]...)
+	}
+	return nil
+}
+
+// Finalize writes the buffered rows and the footer
+func (w *StreamingParquetWriter) Finalize() error {
+	if err := w.flushRowGroup(w.rows); err != nil {
+		return err
+	}
+	w.rows = nil
+	if w.writer == nil {
+		w.open(nil)
+	}
+	return w.writer.Close()
+}
+
+// open creates the schema from the columns of the rows and starts the file
+func (w *StreamingParquetWriter) open(rows []map[string]interface{}) {
+	w.columns = inferParquetColumns(w.names, rows)
+	sort.Slice(w.columns, func(i, j int) bool {
+		return w.columns[i].name < w.columns[j].name
+	})
+	group := parquet.Group{}
+	for _, column := range w.columns {
+		group[column.name] = column.node()
+	}
+	schema := parquet.NewSchema(w.tableName, group)
+	w.writer = parquet.NewWriter(w.output, schema, parquet.Compression(&parquet.Snappy))
+}
+
+// flushRowGroup writes the rows as a row group
+func (w *StreamingParquetWriter) flushRowGroup(rows []map[string]interface{}) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	if w.writer == nil {
+		w.open(rows)
+	}
+
+	values := make([]parquet.Row, 0, len(rows))
+	for _, row := range rows {
+		parquetRow := make(parquet.Row, 0, len(w.columns))
+		for i, column := range w.columns {
+			value, err := column.parquetValue(row[column.name])
+			if err != nil {
+				return fmt.Errorf("column [%v]: %w", column.name, err)
+			}
+			if value == nil {
+				parquetRow = append(parquetRow, parquet.NullValue().Level(0, 0, i))
+				continue
+			}
+			parquetRow = append(parquetRow, parquet.ValueOf(value).Level(0, 1, i))
+		}
+		values = append(values, parquetRow)
+	}
+	if _, err := w.writer.WriteRows(values); err != nil {
+		return err
+	}
+	return w.writer.Flush()
+}
+
+// inferParquetColumns picks the type of each column from the values in the rows, columns with
+// mixed or no values are written as text
+func inferParquetColumns(names []string, rows []map[string]interface{}) []parquetColumn {
+	columns := make([]parquetColumn, 0, len(names))
+	for _, name := range names {
+		column := parquetColumn{name: name, kind: parquetText}
+		kinds := make(map[int]bool)
+		for _, row := range rows {
+			switch row[name].(type) {
+			case nil:
+			case int, int8, int16, int32, int64, uint8, uint16, uint32:
+				kinds[parquetInteger] = true
+			case float32, float64:
+				kinds[parquetDouble] = true
+			case bool:
+				kinds[parquetBoolean] = true
+			case time.Time:
+				kinds[parquetTimestamp] = true
+			case map[string]interface{}, []interface{}:
+				kinds[parquetJson] = true
+			default:
+				kinds[parquetText] = true
+			}
+		}
+		switch {
+		case len(kinds) == 1 && !kinds[parquetDouble]:
+			for kind := range kinds {
+				column.kind = kind
+			}
+		case len(kinds) > 0 && len(kinds) <= 2 && kinds[parquetDouble] && (len(kinds) == 1 || kinds[parquetInteger]):
+			column.kind = parquetDouble
+		}
+		columns = append(columns, column)
+	}
+	return columns
+}
+
+// node is the optional parquet-go node of the column
+func (column parquetColumn) node() parquet.Node {
+	switch column.kind {
+	case parquetInteger:
+		return parquet.Optional(parquet.Int(64))
+	case parquetDouble:
+		return parquet.Optional(parquet.Leaf(parquet.DoubleType))
+	case parquetBoolean:
+		return parquet.Optional(parquet.Leaf(parquet.BooleanType))
+	case parquetTimestamp:
+		return parquet.Optional(parquet.Timestamp(parquet.Millisecond))
+	case parquetJson:
+		return parquet.Optional(parquet.JSON())
+	}
+	return parquet.Optional(parquet.String())
+}
+
+// parquetValue converts a row value to the type of the column, nil for a null
+func (column parquetColumn) parquetValue(value interface{}) (interface{}, error) {
+	if value == nil {
+		return nil, nil
+	}
+	switch column.kind {
+	case parquetBoolean:
+		switch v := value.(type) {
+		case bool:
+			return v, nil
+		case string:
+			return strconv.ParseBool(v)
+		}
+	case parquetTimestamp:
+		if v, ok := value.(time.Time); ok {
+			return v.UnixMilli(), nil
+		}
+	case parquetInteger:
+		switch v := value.(type) {
+		case int:
+			return int64(v), nil
+		case int8:
+			return int64(v), nil
+		case int16:
+			return int64(v), nil
+		case int32:
+			return int64(v), nil
+		case int64:
+			return v, nil
+		case uint8:
+			return int64(v), nil
+		case uint16:
+			return int64(v), nil
+		case uint32:
+			return int64(v), nil
+		case float64:
+			if v == math.Trunc(v) {
+				return int64(v), nil
+			}
+		case string:
+			return strconv.ParseInt(v, 10, 64)
+		}
+	case parquetDouble:
+		if number, ok := toFloat64(value); ok {
+			return number, nil
+		}
+		switch v := value.(type) {
+		case int8:
+			return float64(v), nil
+		case int16:
+			return float64(v), nil
+		case int32:
+			return float64(v), nil
+		case uint8:
+			return float64(v), nil
+		case uint16:
+			return float64(v), nil
+		case uint32:
+			return float64(v), nil
+		case string:
+			return strconv.ParseFloat(v, 64)
+		}
+	default:
+		switch v := value.(type) {
+		case string:
+			return v, nil
+		case []byte:
+			return string(v), nil
+		case time.Time:
+			return v.Format(time.RFC3339), nil
+		case map[string]interface{}, []interface{}:
+			encoded, err := json.Marshal(v)
+			return string(encoded), err
+		}
+		return fmt.Sprintf("%v", value), nil
+	}
+	return nil, fmt.Errorf("value %v does not match the type of the column", value)
+}
@@ -1,10 +1,12 @@
 package actions
 
 import (
-	"bytes"
+	"bufio"
 	"encoding/csv"
+	"errors"
 	"fmt"
 	log "github.com/sirupsen/logrus"
+	"io"
 	"strings"
 	"time"
 
@@ -15,14 +17,14 @@ import (
 
 // StreamingJSONWriter implements streaming JSON export
 type StreamingJSONWriter struct {
-	buffer       *bytes.Buffer
+	buffer       *bufio.Writer
 	isFirstRow   bool
 	isFirstTable bool
 }
 
 // Initialize prepares the JSON writer
-func (w *StreamingJSONWriter) Initialize(tableNames []string, includeHeaders bool, selectedColumns map[string][]string) error {
-	w.buffer = &bytes.Buffer{}
+func (w *StreamingJSONWriter) Initialize(output io.Writer, tableNames []string, includeHeaders bool, selectedColumns map[string][]string) error {
+	w.buffer = bufio.NewWriter(output)
 	w.isFirstTable = true
 	w.isFirstRow = true
 
@@ -34,7 +36,7 @@ func (w *StreamingJSONWriter) Initialize(tableNames []string, includeHeaders boo
 // WriteTable writes a table name header
 func (w *StreamingJSONWriter) WriteTable(tableName string) error {
 	if !w.isFirstTable {
-		w.buffer.WriteString(",")
+		w.buffer.WriteString("\n],")
 	}
 	w.isFirstTable = false
 	w.isFirstRow = true
@@ -72,15 +74,65 @@ func (w *StreamingJSONWriter) WriteRows(tableName string, rows []map[string]inte
 }
 
 // Finalize completes the JSON export
-func (w *StreamingJSONWriter) Finalize() ([]byte, error) {
+func (w *StreamingJSONWriter) Finalize() error {
 	// Close the array and object
-	w.buffer.WriteString("\n]}")
-	return w.buffer.Bytes(), nil
+	if !w.isFirstTable {
+		w.buffer.WriteString("\n]")
+	}
+	w.buffer.WriteString("}")
+	return w.buffer.Flush()
+}
+
+// StreamingNDJSONWriter implements newline delimited JSON export of a single table, one row
+// object per line
+type StreamingNDJSONWriter struct {
+	buffer *bufio.Writer
+}
+
+// Initialize prepares the NDJSON writer
+func (w *StreamingNDJSONWriter) Initialize(output io.Writer, tableNames []string, includeHeaders bool, selectedColumns map[string][]string) error {
+	if len(tableNames) != 1 {
+		return errors.New("ndjson export needs exactly one table or stream")
+	}
+	w.buffer = bufio.NewWriter(output)
+	return nil
+}
+
+// WriteTable is a no-op for NDJSON
+func (w *StreamingNDJSONWriter) WriteTable(tableName string) error {
+	return nil
+}
+
+// WriteHeaders is a no-op for NDJSON
+func (w *StreamingNDJSONWriter) WriteHeaders(tableName string, columns []string) error {
+	return nil
+}
+
+// WriteRows writes each row on a line of its own
+func (w *StreamingNDJSONWriter) WriteRows(tableName string, rows []map[string]interface{}) error {
+	log.Infof("Writing [%d] rows", len(rows))
+
+	for _, row := range rows {
+		rowBytes, err := json.Marshal(row)
+		if err != nil {
+			return err
+		}
+		w.buffer.Write(rowBytes)
+		if err = w.buffer.WriteByte('\n'); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Finalize completes the NDJSON export
+func (w *StreamingNDJSONWriter) Finalize() error {
+	return w.buffer.Flush()
 }
 
 // StreamingHTMLWriter implements streaming HTML table export
 type StreamingHTMLWriter struct {
-	buffer       *bytes.Buffer
+	buffer       *bufio.Writer
 	isFirstRow   bool
 	isFirstTable bool
 	tableCount   int
@@ -88,8 +140,8 @@ type StreamingHTMLWriter struct {
 }
 
 // Initialize prepares the HTML writer
-func (w *StreamingHTMLWriter) Initialize(tableNames []string, includeHeaders bool, selectedColumns map[string][]string) error {
-	w.buffer = &bytes.Buffer{}
+func (w *StreamingHTMLWriter) Initialize(output io.Writer, tableNames []string, includeHeaders bool, selectedColumns map[string][]string) error {
+	w.buffer = bufio.NewWriter(output)
 	w.isFirstTable = true
 	w.isFirstRow = true
 	w.tableCount = 0
@@ -306,7 +358,7 @@ func (w *StreamingHTMLWriter) WriteRows(tableName string, rows []map[string]inte
 }
 
 // Finalize completes the HTML export with JavaScript for interactivity
-func (w *StreamingHTMLWriter) Finalize() ([]byte, error) {
+func (w *StreamingHTMLWriter) Finalize() error {
 	// Close the last table if any were written
 	if w.tableCount > 0 {
 		w.buffer.WriteString("</tbody></table>")
@@ -425,7 +477,7 @@ func (w *StreamingHTMLWriter) Finalize() ([]byte, error) {
 </body>
 </html>`)
 
-	return w.buffer.Bytes(), nil
+	return w.buffer.Flush()
 }
 
 // Helper function to escape HTML content
@@ -463,7 +515,6 @@ func formatValue(value interface{}) string {
 
 // StreamingCSVWriter implements streaming CSV export
 type StreamingCSVWriter struct {
-	buffer          *bytes.Buffer
 	writer          *csv.Writer
 	includeHeaders  bool
 	selectedColumns map[string][]string
@@ -472,11 +523,13 @@ type StreamingCSVWriter struct {
 }
 
 // Initialize prepares the CSV writer
-func (w *StreamingCSVWriter) Initialize(tableNames []string, includeHeaders bool, selectedColumns map[string][]string) error {
-	w.buffer = &bytes.Buffer{}
-	w.writer = csv.NewWriter(w.buffer)
+func (w *StreamingCSVWriter) Initialize(output io.Writer, tableNames []string, includeHeaders bool, selectedColumns map[string][]string) error {
+	w.writer = csv.NewWriter(output)
 	w.includeHeaders = includeHeaders
 	w.selectedColumns = selectedColumns
+	if w.selectedColumns == nil {
+		w.selectedColumns = make(map[string][]string)
+	}
 	w.columnsWritten = make(map[string]bool)
 	return nil
 }
@@ -496,6 +549,9 @@ func (w *StreamingCSVWriter) WriteTable(tableName string) error {
 
 // WriteHeaders writes column headers for CSV
 func (w *StreamingCSVWriter) WriteHeaders(tableName string, columns []string) error {
+	if len(w.selectedColumns[tableName]) == 0 {
+		w.selectedColumns[tableName] = columns
+	}
 	if w.includeHeaders && !w.columnsWritten[tableName] {
 		err := w.writer.Write(columns)
 		if err != nil {
@@ -530,17 +586,19 @@ func (w *StreamingCSVWriter) WriteRows(tableName string, rows []map[string]inter
 	}
 
 	w.writer.Flush()
-	return nil
+	return w.writer.Error()
 }
 
 // Finalize completes the CSV export
-func (w *StreamingCSVWriter) Finalize() ([]byte, error) {
+func (w *StreamingCSVWriter) Finalize() error {
 	w.writer.Flush()
-	return w.buffer.Bytes(), nil
+	return w.writer.Error()
 }
 
-// StreamingXLSXWriter implements streaming XLSX export
+// StreamingXLSXWriter implements XLSX export, the workbook is built in memory and written out
+// when the export is finalized
 type StreamingXLSXWriter struct {
+	output          io.Writer
 	file            *xlsx.File
 	sheets          map[string]*xlsx.Sheet
 	includeHeaders  bool
@@ -549,7 +607,8 @@ type StreamingXLSXWriter struct {
 }
 
 // Initialize prepares the XLSX writer
-func (w *StreamingXLSXWriter) Initialize(tableNames []string, includeHeaders bool, selectedColumns map[string][]string) error {
+func (w *StreamingXLSXWriter) Initialize(output io.Writer, tableNames []string, includeHeaders bool, selectedColumns map[string][]string) error {
+	w.output = output
 	w.file = xlsx.NewFile()
 	w.sheets = make(map[string]*xlsx.Sheet)
 	w.includeHeaders = includeHeaders
@@ -610,17 +669,14 @@ func (w *StreamingXLSXWriter) WriteRows(tableName string, rows []map[string]inte
 }
 
 // Finalize completes the XLSX export
-func (w *StreamingXLSXWriter) Finalize() ([]byte, error) {
-	buffer := &bytes.Buffer{}
-	err := w.file.Write(buffer)
-	if err != nil {
-		return nil, err
-	}
-	return buffer.Bytes(), nil
+func (w *StreamingXLSXWriter) Finalize() error {
+	return w.file.Write(w.output)
 }
 
-// StreamingPDFWriter implements streaming PDF export
+// StreamingPDFWriter implements PDF export, the document is built in memory and written out
+// when the export is finalized
 type StreamingPDFWriter struct {
+	output          io.Writer
 	pdf             *gofpdf.Fpdf
 	includeHeaders  bool
 	selectedColumns map[string][]string
@@ -631,7 +687,8 @@ type StreamingPDFWriter struct {
 }
 
 // Initialize prepares the PDF writer
-func (w *StreamingPDFWriter) Initialize(tableNames []string, includeHeaders bool, selectedColumns map[string][]string) error {
+func (w *StreamingPDFWriter) Initialize(output io.Writer, tableNames []string, includeHeaders bool, selectedColumns map[string][]string) error {
+	w.output = output
 	w.pdf = gofpdf.New("L", "mm", "A4", "")
 	w.includeHeaders = includeHeaders
 	w.selectedColumns = selectedColumns
@@ -743,13 +800,8 @@ func (w *StreamingPDFWriter) WriteRows(tableName string, rows []map[string]inter
 }
 
 // Finalize completes the PDF export
-func (w *StreamingPDFWriter) Finalize() ([]byte, error) {
-	buffer := new(bytes.Buffer)
-	err := w.pdf.Output(buffer)
-	if err != nil {
-		return nil, err
-	}
-	return buffer.Bytes(), nil
+func (w *StreamingPDFWriter) Finalize() error {
+	return w.pdf.Output(w.output)
 }
 
 // CreateStreamingExportWriter creates the appropriate writer based on format
@@ -765,6 +817,10 @@ func CreateStreamingExportWriter(format ExportFormat) (resource.StreamingExportW
 		return &StreamingPDFWriter{}, nil
 	case FormatHTML:
 		return &StreamingHTMLWriter{}, nil
+	case FormatNDJSON:
+		return &StreamingNDJSONWriter{}, nil
+	case FormatParquet:
+		return &StreamingParquetWriter{}, nil
 	default:
 		return &StreamingJSONWriter{}, nil
 	}
@@ -0,0 +1,134 @@
+package actions
+
+import (
+	"bytes"
+	"encoding/csv"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+func writeTestExport(t *testing.T, format ExportFormat, tables map[string][]map[string]interface{}, order []string, columns []string) []byte {
+	t.Helper()
+	writer, err := CreateStreamingExportWriter(format)
+	if err != nil {
+		t.Fatalf("create writer: %v", err)
+	}
+	var output bytes.Buffer
+	if err = writer.Initialize(&output, order, true, map[string][]string{}); err != nil {
+		t.Fatalf("initialize: %v", err)
+	}
+	for _, tableName := range order {
+		if err = writer.WriteTable(tableName); err != nil {
+			t.Fatalf("write table: %v", err)
+		}
+		if err = writer.WriteHeaders(tableName, columns); err != nil {
+			t.Fatalf("write headers: %v", err)
+		}
+		if err = writer.WriteRows(tableName, tables[tableName]); err != nil {
+			t.Fatalf("write rows: %v", err)
+		}
+	}
+	if err = writer.Finalize(); err != nil {
+		t.Fatalf("finalize: %v", err)
+	}
+	return output.Bytes()
+}
+
+func TestStreamingJSONWriterMultipleTables(t *testing.T) {
+	content := writeTestExport(t, FormatJSON, map[string][]map[string]interface{}{
+		"user":  {{"name": "ann"}, {"name": "bob"}},
+		"group": {{"name": "admins"}},
+	}, []string{"user", "group"}, []string{"name"})
+
+	exported := make(map[string][]map[string]interface{})
+	if err := json.Unmarshal(content, &exported); err != nil {
+		t.Fatalf("export is not valid json: %v\n%s", err, content)
+	}
+	if len(exported["user"]) != 2 || exported["group"][0]["name"] != "admins" {
+		t.Errorf("unexpected export %v", exported)
+	}
+}
+
+func TestStreamingNDJSONWriter(t *testing.T) {
+	content := writeTestExport(t, FormatNDJSON, map[string][]map[string]interface{}{
+		"user": {{"name": "ann", "age": 31}, {"name": "bob", "age": nil}},
+	}, []string{"user"}, []string{"name", "age"})
+
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected a line per row, got %q", content)
+	}
+	row := make(map[string]interface{})
+	if err := json.Unmarshal([]byte(lines[1]), &row); err != nil || row["name"] != "bob" || row["age"] != nil {
+		t.Errorf("unexpected row %v: %v", row, err)
+	}
+
+	writer, _ := CreateStreamingExportWriter(FormatNDJSON)
+	if err := writer.Initialize(&bytes.Buffer{}, []string{"user", "group"}, true, nil); err == nil {
+		t.Errorf("ndjson should only export a single table")
+	}
+}
+
+func TestStreamingCSVWriter(t *testing.T) {
+	content := writeTestExport(t, FormatCSV, map[string][]map[string]interface{}{
+		"user": {{"name": "ann, jr", "age": 31}},
+	}, []string{"user"}, []string{"name", "age"})
+
+	records, err := csv.NewReader(bytes.NewReader(content)).ReadAll()
+	if err != nil {
+		t.Fatalf("export is not valid csv: %v", err)
+	}
+	last := records[len(records)-1]
+	if !reflect.DeepEqual(last, []string{"ann, jr", "31"}) {
+		t.Errorf("unexpected records %v", records)
+	}
+}
+
+func TestStreamingParquetWriterRoundTrip(t *testing.T) {
+	created := time.Date(2024, 3, 1, 10, 30, 0, 0, time.UTC)
+	rows := make([]map[string]interface{}, 0)
+	for i := 0; i < parquetRowGroupSize+5; i++ {
+		row := map[string]interface{}{
+			"id":         int64(i),
+			"score":      float64(i) / 2,
+			"name":       "user",
+			"active":     i%2 == 0,
+			"created_at": created,
+			"tags":       []interface{}{"a", "b"},
+		}
+		if i == 1 {
+			row["name"] = nil
+		}
+		rows = append(rows, row)
+	}
+	columns := []string{"id", "score", "name", "active", "created_at", "tags"}
+	content := writeTestExport(t, FormatParquet, map[string][]map[string]interface{}{"user": rows}, []string{"user"}, columns)
+
+	file, err := parquet.OpenFile(bytes.NewReader(content), int64(len(content)))
+	if err != nil {
+		t.Fatalf("open written file: %v", err)
+	}
+	if len(file.RowGroups()) != 2 {
+		t.Fatalf("expected 2 row groups, got %d", len(file.RowGroups()))
+	}
+
+	// parquet-go orders the columns by name
+	readColumns, read := readParquetTestRows(t, content)
+	if !reflect.DeepEqual(readColumns, []string{"active", "created_at", "id", "name", "score", "tags"}) {
+		t.Fatalf("unexpected columns %v", readColumns)
+	}
+	if len(read) != len(rows) {
+		t.Fatalf("expected %d rows, read %d", len(rows), len(read))
+	}
+	expected := map[string]interface{}{"id": int64(1), "score": 0.5, "name": nil, "active": false, "created_at": created, "tags": []interface{}{"a", "b"}}
+	if !reflect.DeepEqual(read[1], expected) {
+		t.Errorf("expected %v, read %v", expected, read[1])
+	}
+	if last := read[len(read)-1]; last["id"] != int64(parquetRowGroupSize+4) || last["name"] != "user" {
+		t.Errorf("unexpected last row %v", last)
+	}
+}
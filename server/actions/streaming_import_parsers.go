@@ -1,13 +1,18 @@
 package actions
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/csv"
+	stdjson "encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"os"
 	"strings"
 
 	"github.com/artpar/xlsx/v2"
+	"github.com/parquet-go/parquet-go"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -21,12 +26,19 @@ const (
 	ImportFormatCSV ImportFormat = "csv"
 	// ImportFormatXLSX imports data from Excel spreadsheet
 	ImportFormatXLSX ImportFormat = "xlsx"
+	// ImportFormatNDJSON imports data from newline delimited JSON, one object per line
+	ImportFormatNDJSON ImportFormat = "ndjson"
+	// ImportFormatParquet imports data from an Apache Parquet file
+	ImportFormatParquet ImportFormat = "parquet"
 )
 
+// size of the buffer between the upload or cloud store reader and a parser
+const importReadBufferSize = 64 * 1024
+
 // StreamingImportParser defines the interface for import parsers
 type StreamingImportParser interface {
-	// Initialize prepares the parser with the file content
-	Initialize(fileContent []byte, tableName string) error
+	// Initialize prepares the parser to read the file from the reader
+	Initialize(reader io.Reader, tableName string) error
 
 	// GetTableNames returns the names of tables found in the import file
 	GetTableNames() ([]string, error)
@@ -34,60 +46,141 @@ type StreamingImportParser interface {
 	// GetColumnsForTable returns the column names for a specific table
 	GetColumnsForTable(tableName string) ([]string, error)
 
-	// ParseRows processes rows for a specific table and calls the handler for each batch
+	// ParseRows processes rows for a specific table and calls the handler for each batch.
+	// Rows are read from the reader as they are parsed, so a table can be parsed only once
 	ParseRows(tableName string, batchSize int, handler func(rows []map[string]interface{}) error) error
 
 	// GetFormat returns the format of this parser
 	GetFormat() ImportFormat
+
+	// Close releases the temporary files held by the parser
+	Close() error
 }
 
-// StreamingJSONParser implements JSON import parsing
+// StreamingJSONParser implements JSON import parsing. The file is an object of table name to
+// array of rows, or an array of rows for the table being imported. The rows of the table being
+// imported are decoded one at a time; without a table name the whole file is loaded
 type StreamingJSONParser struct {
+	decoder    *stdjson.Decoder
+	tableName  string
+	topLevel   stdjson.Delim
+	positioned string
+	pending    map[string]interface{}
 	data       map[string][]map[string]interface{}
 	tableNames []string
 }
 
 // Initialize prepares the JSON parser
-func (p *StreamingJSONParser) Initialize(fileContent []byte, tableName string) error {
-	log.Debugf("Initializing JSON parser with %d bytes", len(fileContent))
-	// Parse the JSON content
-	var jsonData map[string]interface{}
-	err := json.Unmarshal(fileContent, &jsonData)
+func (p *StreamingJSONParser) Initialize(reader io.Reader, tableName string) error {
+	log.Debugf("Initializing JSON parser for table [%v]", tableName)
+	p.decoder = stdjson.NewDecoder(bufio.NewReaderSize(reader, importReadBufferSize))
+	p.tableName = tableName
+
+	token, err := p.decoder.Token()
 	if err != nil {
 		return fmt.Errorf("failed to parse JSON: %w", err)
 	}
+	delim, ok := token.(stdjson.Delim)
+	if !ok || (delim != '{' && delim != '[') {
+		return errors.New("failed to parse JSON: expected an object of tables or an array of rows")
+	}
+	p.topLevel = delim
 
-	// Initialize data map
+	if delim == '[' {
+		if tableName == "" {
+			return errors.New("a JSON array of rows needs a table name")
+		}
+		p.positioned = tableName
+		return nil
+	}
+	if tableName != "" {
+		return nil
+	}
+
+	// no table selected, load every table in the file
 	p.data = make(map[string][]map[string]interface{})
 	p.tableNames = make([]string, 0)
-
-	// Process each table in the JSON
-	for tableName, tableData := range jsonData {
-		// Each table should contain an array of objects
-		tableArray, ok := tableData.([]interface{})
-		if !ok {
-			return fmt.Errorf("invalid JSON format for table '%s': expected array", tableName)
+	for p.decoder.More() {
+		name, err := p.nextTableName()
+		if err != nil {
+			return err
 		}
+		var tableRows []map[string]interface{}
+		if err := p.decoder.Decode(&tableRows); err != nil {
+			return fmt.Errorf("invalid JSON format for table '%s': expected array of objects: %w", name, err)
+		}
+		p.data[name] = tableRows
+		p.tableNames = append(p.tableNames, name)
+	}
+	return nil
+}
 
-		// Convert each row to a map
-		tableRows := make([]map[string]interface{}, 0, len(tableArray))
-		for _, rowData := range tableArray {
-			row, ok := rowData.(map[string]interface{})
-			if !ok {
-				return fmt.Errorf("invalid JSON format for table '%s': expected object in array", tableName)
+func (p *StreamingJSONParser) nextTableName() (string, error) {
+	token, err := p.decoder.Token()
+	if err != nil {
+		return "", fmt.Errorf("failed to parse JSON: %w", err)
+	}
+	name, ok := token.(string)
+	if !ok {
+		return "", errors.New("failed to parse JSON: expected a table name")
+	}
+	return name, nil
+}
+
+// seekTable moves the decoder into the rows array of the table, skipping the tables before it
+func (p *StreamingJSONParser) seekTable(tableName string) error {
+	if p.positioned == tableName {
+		return nil
+	}
+	if p.positioned != "" || p.topLevel != '{' {
+		return fmt.Errorf("[118] table '%s' not found", tableName)
+	}
+	for p.decoder.More() {
+		name, err := p.nextTableName()
+		if err != nil {
+			return err
+		}
+		if name != tableName {
+			var skipped stdjson.RawMessage
+			if err := p.decoder.Decode(&skipped); err != nil {
+				return fmt.Errorf("failed to parse JSON: %w", err)
 			}
-			tableRows = append(tableRows, row)
+			continue
 		}
-
-		p.data[tableName] = tableRows
-		p.tableNames = append(p.tableNames, tableName)
+		token, err := p.decoder.Token()
+		if err != nil {
+			return fmt.Errorf("failed to parse JSON: %w", err)
+		}
+		if delim, ok := token.(stdjson.Delim); !ok || delim != '[' {
+			return fmt.Errorf("invalid JSON format for table '%s': expected array", tableName)
+		}
+		p.positioned = tableName
+		return nil
 	}
+	return fmt.Errorf("[118] table '%s' not found", tableName)
+}
 
-	return nil
+func (p *StreamingJSONParser) nextRow() (map[string]interface{}, error) {
+	if p.pending != nil {
+		row := p.pending
+		p.pending = nil
+		return row, nil
+	}
+	if !p.decoder.More() {
+		return nil, io.EOF
+	}
+	var row map[string]interface{}
+	if err := p.decoder.Decode(&row); err != nil {
+		return nil, fmt.Errorf("invalid JSON format for table '%s': expected object in array: %w", p.positioned, err)
+	}
+	return row, nil
 }
 
 // GetTableNames returns the names of tables in the JSON
 func (p *StreamingJSONParser) GetTableNames() ([]string, error) {
+	if p.tableName != "" {
+		return []string{p.tableName}, nil
+	}
 	if len(p.tableNames) == 0 {
 		return nil, errors.New("no tables found in JSON")
 	}
@@ -96,13 +189,26 @@ func (p *StreamingJSONParser) GetTableNames() ([]string, error) {
 
 // GetColumnsForTable returns the column names for a specific table
 func (p *StreamingJSONParser) GetColumnsForTable(tableName string) ([]string, error) {
-	tableData, ok := p.data[tableName]
-	if !ok || len(tableData) == 0 {
-		return nil, fmt.Errorf("[101] table '%s' not found or empty", tableName)
+	var firstRow map[string]interface{}
+	if p.data != nil {
+		tableData := p.data[tableName]
+		if len(tableData) == 0 {
+			return nil, fmt.Errorf("[101] table '%s' not found or empty", tableName)
+		}
+		firstRow = tableData[0]
+	} else {
+		if err := p.seekTable(tableName); err != nil {
+			return nil, err
+		}
+		row, err := p.nextRow()
+		if err != nil {
+			return nil, fmt.Errorf("[101] table '%s' not found or empty", tableName)
+		}
+		p.pending = row
+		firstRow = row
 	}
 
 	// Get columns from the first row
-	firstRow := tableData[0]
 	columns := make([]string, 0, len(firstRow))
 	for col := range firstRow {
 		columns = append(columns, col)
@@ -113,25 +219,27 @@ func (p *StreamingJSONParser) GetColumnsForTable(tableName string) ([]string, er
 
 // ParseRows processes rows for a specific table
 func (p *StreamingJSONParser) ParseRows(tableName string, batchSize int, handler func(rows []map[string]interface{}) error) error {
-	tableData, ok := p.data[tableName]
-	if !ok {
-		return fmt.Errorf("[118] table '%s' not found", tableName)
-	}
-
-	// Process in batches
-	for i := 0; i < len(tableData); i += batchSize {
-		end := i + batchSize
-		if end > len(tableData) {
-			end = len(tableData)
+	if p.data != nil {
+		tableData, ok := p.data[tableName]
+		if !ok {
+			return fmt.Errorf("[118] table '%s' not found", tableName)
 		}
-
-		batch := tableData[i:end]
-		if err := handler(batch); err != nil {
-			return err
+		for i := 0; i < len(tableData); i += batchSize {
+			end := i + batchSize
+			if end > len(tableData) {
+				end = len(tableData)
+			}
+			if err := handler(tableData[i:end]); err != nil {
+				return err
+			}
 		}
+		return nil
 	}
 
-	return nil
+	if err := p.seekTable(tableName); err != nil {
+		return err
+	}
+	return parseRowsInBatches(batchSize, p.nextRow, handler)
 }
 
 // GetFormat returns the format of this parser
@@ -139,68 +247,151 @@ func (p *StreamingJSONParser) GetFormat() ImportFormat {
 	return ImportFormatJSON
 }
 
-// StreamingCSVParser implements CSV import parsing
-type StreamingCSVParser struct {
-	content        []byte
-	headers        []string
-	rows           [][]string
-	hasTableHeader bool
-	tableName      string
-	tableMap       map[string][][]string // Maps table names to their rows
+// Close releases nothing, the JSON parser reads from memory or the reader
+func (p *StreamingJSONParser) Close() error {
+	return nil
 }
 
-// Initialize prepares the CSV parser
-func (p *StreamingCSVParser) Initialize(fileContent []byte, tableName string) error {
-	log.Debugf("Initializing CSV parser with %d bytes", len(fileContent))
-	reader := csv.NewReader(bytes.NewReader(fileContent))
+// StreamingNDJSONParser implements newline delimited JSON parsing, one row object per line
+type StreamingNDJSONParser struct {
+	reader    *bufio.Reader
+	tableName string
+	line      int
+	pending   map[string]interface{}
+}
+
+// Initialize prepares the NDJSON parser
+func (p *StreamingNDJSONParser) Initialize(reader io.Reader, tableName string) error {
+	log.Debugf("Initializing NDJSON parser for table [%v]", tableName)
+	if tableName == "" {
+		return errors.New("NDJSON import needs a table name")
+	}
+	p.reader = bufio.NewReaderSize(reader, importReadBufferSize)
 	p.tableName = tableName
+	return nil
+}
+
+func (p *StreamingNDJSONParser) nextRow() (map[string]interface{}, error) {
+	if p.pending != nil {
+		row := p.pending
+		p.pending = nil
+		return row, nil
+	}
+	for {
+		line, err := p.reader.ReadBytes('\n')
+		if len(line) == 0 && err != nil {
+			return nil, err
+		}
+		p.line++
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		var row map[string]interface{}
+		if jsonErr := json.Unmarshal(line, &row); jsonErr != nil {
+			return nil, fmt.Errorf("invalid JSON object on line %d: %w", p.line, jsonErr)
+		}
+		return row, nil
+	}
+}
+
+// GetTableNames returns the table being imported
+func (p *StreamingNDJSONParser) GetTableNames() ([]string, error) {
+	return []string{p.tableName}, nil
+}
 
-	// Read all CSV records
-	records, err := reader.ReadAll()
+// GetColumnsForTable returns the keys of the first row
+func (p *StreamingNDJSONParser) GetColumnsForTable(tableName string) ([]string, error) {
+	row, err := p.nextRow()
 	if err != nil {
-		return fmt.Errorf("failed to parse CSV: %w", err)
+		return nil, fmt.Errorf("[101] table '%s' not found or empty", tableName)
 	}
+	p.pending = row
+	columns := make([]string, 0, len(row))
+	for col := range row {
+		columns = append(columns, col)
+	}
+	return columns, nil
+}
 
-	if len(records) < 1 {
-		return errors.New("CSV file is empty")
+// ParseRows processes the rows line by line
+func (p *StreamingNDJSONParser) ParseRows(tableName string, batchSize int, handler func(rows []map[string]interface{}) error) error {
+	if tableName != p.tableName {
+		return fmt.Errorf("[118] table '%s' not found", tableName)
 	}
+	return parseRowsInBatches(batchSize, p.nextRow, handler)
+}
 
-	p.rows = records
-	p.tableMap = make(map[string][][]string)
+// GetFormat returns the format of this parser
+func (p *StreamingNDJSONParser) GetFormat() ImportFormat {
+	return ImportFormatNDJSON
+}
 
-	// Check if the first row is a table header (starts with "Table:" or similar)
-	p.hasTableHeader = false
-	currentTable := tableName
-	tableRows := make([][]string, 0)
-
-	for i, row := range records {
-		if len(row) > 0 && strings.HasPrefix(strings.ToLower(row[0]), "table:") {
-			// This is a table header row
-			if i > 0 && len(tableRows) > 0 {
-				// Save the previous table's rows
-				p.tableMap[currentTable] = tableRows
-			}
+// Close releases nothing, the NDJSON parser reads from the reader
+func (p *StreamingNDJSONParser) Close() error {
+	return nil
+}
 
-			// Extract the new table name
-			currentTable = tableName
-			p.hasTableHeader = true
-			tableRows = make([][]string, 0)
-		} else if i == 0 && !p.hasTableHeader {
-			// First row is headers if no table markers
-			p.headers = row
-			tableRows = append(tableRows, row) // Include headers in the rows
-		} else {
-			// Regular data row
-			tableRows = append(tableRows, row)
+// StreamingCSVParser implements CSV import parsing. The first record holds the column names,
+// records marking a table ("Table: name") are skipped
+type StreamingCSVParser struct {
+	reader    *csv.Reader
+	headers   []string
+	tableName string
+}
+
+// Initialize prepares the CSV parser
+func (p *StreamingCSVParser) Initialize(reader io.Reader, tableName string) error {
+	log.Debugf("Initializing CSV parser for table [%v]", tableName)
+	p.reader = csv.NewReader(bufio.NewReaderSize(reader, importReadBufferSize))
+	p.reader.FieldsPerRecord = -1
+	p.tableName = tableName
+
+	for {
+		record, err := p.reader.Read()
+		if err == io.EOF {
+			return errors.New("CSV file is empty")
+		}
+		if err != nil {
+			return fmt.Errorf("failed to parse CSV: %w", err)
+		}
+		if isCSVTableMarker(record) {
+			continue
 		}
+		p.headers = record
+		return nil
 	}
+}
 
-	// Save the last table
-	if len(tableRows) > 0 {
-		p.tableMap[currentTable] = tableRows
-	}
+func isCSVTableMarker(record []string) bool {
+	return len(record) > 0 && strings.HasPrefix(strings.ToLower(record[0]), "table:")
+}
 
-	return nil
+func (p *StreamingCSVParser) nextRow() (map[string]interface{}, error) {
+	for {
+		record, err := p.reader.Read()
+		if err == io.EOF {
+			return nil, err
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse CSV: %w", err)
+		}
+		if isCSVTableMarker(record) {
+			continue
+		}
+		rowMap := make(map[string]interface{})
+
+		// Map each column value to its header
+		for k, header := range p.headers {
+			if k < len(record) {
+				rowMap[header] = record[k]
+			}
+		}
+		return rowMap, nil
+	}
 }
 
 // GetTableNames returns the names of tables in the CSV
@@ -210,63 +401,173 @@ func (p *StreamingCSVParser) GetTableNames() ([]string, error) {
 
 // GetColumnsForTable returns the column names for a specific table
 func (p *StreamingCSVParser) GetColumnsForTable(tableName string) ([]string, error) {
-	tableRows, ok := p.tableMap[tableName]
-	if !ok || len(tableRows) == 0 {
+	if tableName != p.tableName || len(p.headers) == 0 {
 		return nil, fmt.Errorf("[231] table '%s' not found or empty", tableName)
 	}
 
 	// First row contains headers
-	return tableRows[0], nil
+	return p.headers, nil
 }
 
 // ParseRows processes rows for a specific table
 func (p *StreamingCSVParser) ParseRows(tableName string, batchSize int, handler func(rows []map[string]interface{}) error) error {
-	tableRows, ok := p.tableMap[tableName]
-	if !ok {
+	if tableName != p.tableName {
 		return fmt.Errorf("[242] table '%s' not found", tableName)
 	}
+	return parseRowsInBatches(batchSize, p.nextRow, handler)
+}
 
-	if len(tableRows) <= 1 {
-		// Only headers, no data
-		return nil
-	}
+// GetFormat returns the format of this parser
+func (p *StreamingCSVParser) GetFormat() ImportFormat {
+	return ImportFormatCSV
+}
 
-	headers := tableRows[0]
-	dataRows := tableRows[1:] // Skip headers
+// Close releases nothing, the CSV parser reads from the reader
+func (p *StreamingCSVParser) Close() error {
+	return nil
+}
 
-	// Process in batches
-	for i := 0; i < len(dataRows); i += batchSize {
-		end := i + batchSize
-		if end > len(dataRows) {
-			end = len(dataRows)
+// parseRowsInBatches reads rows until io.EOF and hands them to the handler batchSize at a time
+func parseRowsInBatches(batchSize int, next func() (map[string]interface{}, error), handler func(rows []map[string]interface{}) error) error {
+	batch := make([]map[string]interface{}, 0, batchSize)
+	for {
+		row, err := next()
+		if err == io.EOF {
+			break
 		}
+		if err != nil {
+			return err
+		}
+		batch = append(batch, row)
+		if len(batch) >= batchSize {
+			if err := handler(batch); err != nil {
+				return err
+			}
+			batch = make([]map[string]interface{}, 0, batchSize)
+		}
+	}
+	if len(batch) > 0 {
+		return handler(batch)
+	}
+	return nil
+}
 
-		batch := make([]map[string]interface{}, 0, end-i)
-		for j := i; j < end; j++ {
-			row := dataRows[j]
-			rowMap := make(map[string]interface{})
+// StreamingParquetParser implements Apache Parquet import parsing with parquet-go. Parquet keeps
+// its metadata at the end of the file, a reader which is not a file is first copied to a temporary
+// file. Rows are read one row group after another
+type StreamingParquetParser struct {
+	file      *parquet.File
+	tableName string
+	temporary *os.File
+}
 
-			// Map each column value to its header
-			for k, header := range headers {
-				if k < len(row) {
-					rowMap[header] = row[k]
-				}
-			}
+// rows read from a row group at once
+const parquetReadRows = 256
 
-			batch = append(batch, rowMap)
-		}
+// Initialize prepares the Parquet parser
+func (p *StreamingParquetParser) Initialize(reader io.Reader, tableName string) error {
+	log.Debugf("Initializing Parquet parser for table [%v]", tableName)
+	if tableName == "" {
+		return errors.New("parquet import needs a table name")
+	}
+	p.tableName = tableName
 
-		if err := handler(batch); err != nil {
-			return err
+	file, ok := reader.(*os.File)
+	if !ok {
+		temporary, err := os.CreateTemp("", "daptin-import-*.parquet")
+		if err != nil {
+			return fmt.Errorf("failed to create temporary file for parquet import: %w", err)
+		}
+		p.temporary = temporary
+		if _, err = io.Copy(temporary, reader); err != nil {
+			return fmt.Errorf("failed to read parquet file: %w", err)
 		}
+		file = temporary
 	}
 
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	p.file, err = parquet.OpenFile(file, info.Size())
+	if err != nil {
+		return fmt.Errorf("failed to parse Parquet: %w", err)
+	}
 	return nil
 }
 
+// GetTableNames returns the table being imported
+func (p *StreamingParquetParser) GetTableNames() ([]string, error) {
+	return []string{p.tableName}, nil
+}
+
+// GetColumnsForTable returns the top level fields of the parquet schema
+func (p *StreamingParquetParser) GetColumnsForTable(tableName string) ([]string, error) {
+	if tableName != p.tableName {
+		return nil, fmt.Errorf("table '%s' not found", tableName)
+	}
+	return parquetColumnNames(p.file.Schema()), nil
+}
+
+// ParseRows processes the rows of each row group in batches
+func (p *StreamingParquetParser) ParseRows(tableName string, batchSize int, handler func(rows []map[string]interface{}) error) error {
+	if tableName != p.tableName {
+		return fmt.Errorf("table '%s' not found", tableName)
+	}
+	schema := p.file.Schema()
+	rowGroups := p.file.RowGroups()
+	buffer := make([]parquet.Row, parquetReadRows)
+	var rows []map[string]interface{}
+	var groupRows parquet.Rows
+	rowGroup := 0
+	defer func() {
+		if groupRows != nil {
+			groupRows.Close()
+		}
+	}()
+
+	return parseRowsInBatches(batchSize, func() (map[string]interface{}, error) {
+		for len(rows) == 0 {
+			if groupRows == nil {
+				if rowGroup >= len(rowGroups) {
+					return nil, io.EOF
+				}
+				groupRows = rowGroups[rowGroup].Rows()
+				rowGroup++
+			}
+			n, err := groupRows.ReadRows(buffer)
+			for _, values := range buffer[:n] {
+				row, rowErr := readParquetRow(schema, values)
+				if rowErr != nil {
+					return nil, fmt.Errorf("failed to read parquet row group %d: %w", rowGroup-1, rowErr)
+				}
+				rows = append(rows, row)
+			}
+			if err == io.EOF {
+				groupRows.Close()
+				groupRows = nil
+			} else if err != nil {
+				return nil, fmt.Errorf("failed to read parquet row group %d: %w", rowGroup-1, err)
+			}
+		}
+		row := rows[0]
+		rows = rows[1:]
+		return row, nil
+	}, handler)
+}
+
 // GetFormat returns the format of this parser
-func (p *StreamingCSVParser) GetFormat() ImportFormat {
-	return ImportFormatCSV
+func (p *StreamingParquetParser) GetFormat() ImportFormat {
+	return ImportFormatParquet
+}
+
+// Close removes the temporary copy of the file
+func (p *StreamingParquetParser) Close() error {
+	if p.temporary == nil {
+		return nil
+	}
+	p.temporary.Close()
+	return os.Remove(p.temporary.Name())
 }
 
 // StreamingXLSXParser implements Excel spreadsheet import parsing
@@ -276,10 +577,13 @@ type StreamingXLSXParser struct {
 	tableMap map[string][][]string
 }
 
-// Initialize prepares the XLSX parser
-func (p *StreamingXLSXParser) Initialize(fileContent []byte, tableName string) error {
+// Initialize prepares the XLSX parser. An xlsx file is a zip archive, it is read into memory
+func (p *StreamingXLSXParser) Initialize(reader io.Reader, tableName string) error {
+	fileContent, err := io.ReadAll(reader)
+	if err != nil {
+		return fmt.Errorf("failed to read XLSX: %w", err)
+	}
 	log.Debugf("Initializing XLSX parser with %d bytes", len(fileContent))
-	var err error
 
 	// Parse the XLSX content
 	p.file, err = xlsx.OpenBinary(fileContent)
@@ -397,41 +701,62 @@ func (p *StreamingXLSXParser) GetFormat() ImportFormat {
 	return ImportFormatXLSX
 }
 
+// Close releases nothing, the XLSX parser reads from memory
+func (p *StreamingXLSXParser) Close() error {
+	return nil
+}
+
 // Helper function to avoid json package name collision
 func jsonUnmarshal(data []byte, v interface{}) error {
 	return json.Unmarshal(data, v)
 }
 
-// DetectFileFormat attempts to determine the format of the import file
+// DetectFileFormat attempts to determine the format of the import file from its name and the
+// first bytes of its content
 func DetectFileFormat(fileContent []byte, fileName string) ImportFormat {
 	// Check by file extension first
 	lowerFileName := strings.ToLower(fileName)
-	if strings.HasSuffix(lowerFileName, ".json") {
+	if strings.HasSuffix(lowerFileName, ".ndjson") || strings.HasSuffix(lowerFileName, ".jsonl") {
+		return ImportFormatNDJSON
+	} else if strings.HasSuffix(lowerFileName, ".json") {
 		return ImportFormatJSON
 	} else if strings.HasSuffix(lowerFileName, ".csv") {
 		return ImportFormatCSV
 	} else if strings.HasSuffix(lowerFileName, ".xlsx") || strings.HasSuffix(lowerFileName, ".xls") {
 		return ImportFormatXLSX
+	} else if strings.HasSuffix(lowerFileName, ".parquet") {
+		return ImportFormatParquet
 	}
 
 	// Try to detect by content
 	if len(fileContent) > 0 {
-		// Check for JSON format (starts with { or [)
+		// Parquet files start with PAR1
+		if bytes.HasPrefix(fileContent, parquetMagic) {
+			return ImportFormatParquet
+		}
+
+		// Check for JSON format (starts with { or [), one complete object on the first line
+		// followed by more content is NDJSON
 		trimmed := bytes.TrimSpace(fileContent)
+		if len(trimmed) > 0 && trimmed[0] == '{' {
+			if lineEnd := bytes.IndexByte(trimmed, '\n'); lineEnd > 0 && stdjson.Valid(trimmed[:lineEnd]) {
+				return ImportFormatNDJSON
+			}
+		}
 		if len(trimmed) > 0 && (trimmed[0] == '{' || trimmed[0] == '[') {
 			return ImportFormatJSON
 		}
 
-		// Check for CSV (contains commas and newlines)
-		if bytes.Contains(fileContent, []byte{','}) && bytes.Contains(fileContent, []byte{'\n'}) {
-			return ImportFormatCSV
-		}
-
 		// XLSX is a binary format, harder to detect by content inspection
 		// Excel files start with PK (zip file signature)
 		if len(fileContent) > 2 && fileContent[0] == 'P' && fileContent[1] == 'K' {
 			return ImportFormatXLSX
 		}
+
+		// Check for CSV (contains commas and newlines)
+		if bytes.Contains(fileContent, []byte{','}) && bytes.Contains(fileContent, []byte{'\n'}) {
+			return ImportFormatCSV
+		}
 	}
 
 	// Default to JSON if we can't determine
@@ -447,6 +772,10 @@ func CreateStreamingImportParser(format ImportFormat) (StreamingImportParser, er
 		return &StreamingCSVParser{}, nil
 	case ImportFormatXLSX:
 		return &StreamingXLSXParser{}, nil
+	case ImportFormatNDJSON:
+		return &StreamingNDJSONParser{}, nil
+	case ImportFormatParquet:
+		return &StreamingParquetParser{}, nil
 	default:
 		return nil, fmt.Errorf("unsupported import format: %s", format)
 	}
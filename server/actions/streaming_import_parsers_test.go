@@ -0,0 +1,305 @@
+package actions
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+func collectImportRows(t *testing.T, parser StreamingImportParser, tableName string, batchSize int) ([]map[string]interface{}, int) {
+	t.Helper()
+	rows := make([]map[string]interface{}, 0)
+	batches := 0
+	err := parser.ParseRows(tableName, batchSize, func(batch []map[string]interface{}) error {
+		batches++
+		rows = append(rows, batch...)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("parse rows of [%v]: %v", tableName, err)
+	}
+	return rows, batches
+}
+
+func TestDetectFileFormat(t *testing.T) {
+	cases := []struct {
+		content  string
+		fileName string
+		expected ImportFormat
+	}{
+		{"", "users.ndjson", ImportFormatNDJSON},
+		{"", "users.jsonl", ImportFormatNDJSON},
+		{"", "users.parquet", ImportFormatParquet},
+		{"", "users.csv", ImportFormatCSV},
+		{"PAR1\x15\x04", "upload", ImportFormatParquet},
+		{"{\"name\": \"a\"}\n{\"name\": \"b\"}\n", "upload", ImportFormatNDJSON},
+		{"{\n  \"user\": [{\"name\": \"a\"}]\n}", "upload", ImportFormatJSON},
+		{"[{\"name\": \"a\"}]", "upload", ImportFormatJSON},
+		{"name,email\na,a@example.com\n", "upload", ImportFormatCSV},
+	}
+	for _, c := range cases {
+		if format := DetectFileFormat([]byte(c.content), c.fileName); format != c.expected {
+			t.Errorf("%q named [%v]: expected %v, got %v", c.content, c.fileName, c.expected, format)
+		}
+	}
+}
+
+func TestStreamingJSONParserSeeksTable(t *testing.T) {
+	content := `{"group": [{"name": "skipped"}], "user": [{"name": "a"}, {"name": "b"}, {"name": "c"}]}`
+
+	parser := &StreamingJSONParser{}
+	if err := parser.Initialize(strings.NewReader(content), "user"); err != nil {
+		t.Fatalf("initialize: %v", err)
+	}
+	columns, err := parser.GetColumnsForTable("user")
+	if err != nil || !reflect.DeepEqual(columns, []string{"name"}) {
+		t.Fatalf("unexpected columns %v: %v", columns, err)
+	}
+	rows, batches := collectImportRows(t, parser, "user", 2)
+	if len(rows) != 3 || batches != 2 || rows[0]["name"] != "a" || rows[2]["name"] != "c" {
+		t.Fatalf("unexpected rows %v in %d batches", rows, batches)
+	}
+
+	parser = &StreamingJSONParser{}
+	if err := parser.Initialize(strings.NewReader(`[{"name": "a"}]`), "user"); err != nil {
+		t.Fatalf("initialize array: %v", err)
+	}
+	if rows, _ := collectImportRows(t, parser, "user", 10); len(rows) != 1 {
+		t.Fatalf("unexpected rows %v", rows)
+	}
+
+	parser = &StreamingJSONParser{}
+	if err := parser.Initialize(strings.NewReader(content), ""); err != nil {
+		t.Fatalf("initialize without table: %v", err)
+	}
+	if tables, err := parser.GetTableNames(); err != nil || !reflect.DeepEqual(tables, []string{"group", "user"}) {
+		t.Fatalf("unexpected tables %v: %v", tables, err)
+	}
+}
+
+func TestStreamingNDJSONParser(t *testing.T) {
+	content := "{\"name\": \"a\", \"age\": 1}\n\n{\"name\": \"b\", \"age\": 2}\n{\"name\": \"c\"}"
+
+	parser := &StreamingNDJSONParser{}
+	if err := parser.Initialize(strings.NewReader(content), "user"); err != nil {
+		t.Fatalf("initialize: %v", err)
+	}
+	if columns, err := parser.GetColumnsForTable("user"); err != nil || len(columns) != 2 {
+		t.Fatalf("unexpected columns %v: %v", columns, err)
+	}
+	rows, batches := collectImportRows(t, parser, "user", 2)
+	if len(rows) != 3 || batches != 2 || rows[0]["name"] != "a" || rows[1]["age"] != float64(2) || rows[2]["name"] != "c" {
+		t.Fatalf("unexpected rows %v in %d batches", rows, batches)
+	}
+
+	parser = &StreamingNDJSONParser{}
+	if err := parser.Initialize(strings.NewReader("{\"name\": \"a\"}\nnot json\n"), "user"); err != nil {
+		t.Fatalf("initialize: %v", err)
+	}
+	err := parser.ParseRows("user", 10, func(rows []map[string]interface{}) error { return nil })
+	if err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Fatalf("expected an error on line 2, got %v", err)
+	}
+}
+
+func TestStreamingCSVParser(t *testing.T) {
+	content := "Table: user\nname,email\na,a@example.com\nb\nc,c@example.com\n"
+
+	parser := &StreamingCSVParser{}
+	if err := parser.Initialize(strings.NewReader(content), "user"); err != nil {
+		t.Fatalf("initialize: %v", err)
+	}
+	if columns, err := parser.GetColumnsForTable("user"); err != nil || !reflect.DeepEqual(columns, []string{"name", "email"}) {
+		t.Fatalf("unexpected columns %v: %v", columns, err)
+	}
+	rows, batches := collectImportRows(t, parser, "user", 2)
+	if len(rows) != 3 || batches != 2 || rows[0]["email"] != "a@example.com" || rows[1]["email"] != nil || rows[2]["name"] != "c" {
+		t.Fatalf("unexpected rows %v in %d batches", rows, batches)
+	}
+}
+
+type parquetTestUser struct {
+	Id   int64   `parquet:"id"`
+	Name *string `parquet:"name,optional"`
+	City string  `parquet:"city,dict"`
+}
+
+// parquetTestFile writes a file with a required int64 column, an optional string column and a
+// dictionary encoded string column
+func parquetTestFile(t *testing.T) []byte {
+	t.Helper()
+	name := func(value string) *string { return &value }
+	var file bytes.Buffer
+	err := parquet.Write(&file, []parquetTestUser{
+		{Id: 1, Name: name("ann"), City: "paris"},
+		{Id: 2, City: "oslo"},
+		{Id: 3, Name: name("cid"), City: "paris"},
+	})
+	if err != nil {
+		t.Fatalf("write parquet file: %v", err)
+	}
+	return file.Bytes()
+}
+
+func readParquetTestRows(t *testing.T, content []byte) ([]string, []map[string]interface{}) {
+	t.Helper()
+	parser := &StreamingParquetParser{}
+	if err := parser.Initialize(bytes.NewReader(content), "user"); err != nil {
+		t.Fatalf("initialize: %v", err)
+	}
+	defer parser.Close()
+	columns, err := parser.GetColumnsForTable("user")
+	if err != nil {
+		t.Fatalf("columns: %v", err)
+	}
+	rows, _ := collectImportRows(t, parser, "user", 100)
+	return columns, rows
+}
+
+func TestStreamingParquetParser(t *testing.T) {
+	content := parquetTestFile(t)
+	if format := DetectFileFormat(content, "upload"); format != ImportFormatParquet {
+		t.Fatalf("expected parquet, got %v", format)
+	}
+
+	parser := &StreamingParquetParser{}
+	if err := parser.Initialize(io.MultiReader(bytes.NewReader(content)), "user"); err != nil {
+		t.Fatalf("initialize: %v", err)
+	}
+	defer parser.Close()
+
+	if columns, err := parser.GetColumnsForTable("user"); err != nil || !reflect.DeepEqual(columns, []string{"id", "name", "city"}) {
+		t.Fatalf("unexpected columns %v: %v", columns, err)
+	}
+	rows, batches := collectImportRows(t, parser, "user", 2)
+	expected := []map[string]interface{}{
+		{"id": int64(1), "name": "ann", "city": "paris"},
+		{"id": int64(2), "name": nil, "city": "oslo"},
+		{"id": int64(3), "name": "cid", "city": "paris"},
+	}
+	if batches != 2 || !reflect.DeepEqual(rows, expected) {
+		t.Fatalf("unexpected rows %v in %d batches", rows, batches)
+	}
+}
+
+func TestParquetRejectsCorruptFile(t *testing.T) {
+	content := parquetTestFile(t)
+	truncated := append(append([]byte{}, content[:len(content)-20]...), content[len(content)-8:]...)
+	if err := (&StreamingParquetParser{}).Initialize(bytes.NewReader(truncated), "user"); err == nil {
+		t.Errorf("a truncated footer should be rejected")
+	}
+	if err := (&StreamingParquetParser{}).Initialize(bytes.NewReader(content[:8]), "user"); err == nil {
+		t.Errorf("a file without a footer should be rejected")
+	}
+}
+
+type parquetTestLanguage struct {
+	Code    string  `parquet:"Code"`
+	Country *string `parquet:"Country,optional"`
+}
+
+type parquetTestName struct {
+	Language []parquetTestLanguage `parquet:"Language"`
+	Url      *string               `parquet:"Url,optional"`
+}
+
+type parquetTestLinks struct {
+	Backward []int64 `parquet:"Backward"`
+	Forward  []int64 `parquet:"Forward"`
+}
+
+type parquetTestDocument struct {
+	DocId int64             `parquet:"DocId"`
+	Links *parquetTestLinks `parquet:"Links,optional"`
+	Name  []parquetTestName `parquet:"Name"`
+}
+
+// TestParquetNestedColumns reads the Document records of the Dremel paper
+func TestParquetNestedColumns(t *testing.T) {
+	text := func(value string) *string { return &value }
+	var file bytes.Buffer
+	err := parquet.Write(&file, []parquetTestDocument{
+		{
+			DocId: 10,
+			Links: &parquetTestLinks{Forward: []int64{20, 40, 60}},
+			Name: []parquetTestName{
+				{Language: []parquetTestLanguage{{Code: "en-us", Country: text("us")}, {Code: "en"}}, Url: text("http://A")},
+				{Url: text("http://B")},
+				{Language: []parquetTestLanguage{{Code: "en-gb", Country: text("gb")}}},
+			},
+		},
+		{
+			DocId: 20,
+			Links: &parquetTestLinks{Backward: []int64{10, 30}, Forward: []int64{80}},
+			Name:  []parquetTestName{{Url: text("http://C")}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("write parquet file: %v", err)
+	}
+
+	columns, rows := readParquetTestRows(t, file.Bytes())
+	if !reflect.DeepEqual(columns, []string{"DocId", "Links", "Name"}) {
+		t.Fatalf("unexpected columns %v", columns)
+	}
+	expected := []map[string]interface{}{
+		{
+			"DocId": int64(10),
+			"Links": map[string]interface{}{"Backward": []interface{}{}, "Forward": []interface{}{int64(20), int64(40), int64(60)}},
+			"Name": []interface{}{
+				map[string]interface{}{
+					"Language": []interface{}{
+						map[string]interface{}{"Code": "en-us", "Country": "us"},
+						map[string]interface{}{"Code": "en", "Country": nil},
+					},
+					"Url": "http://A",
+				},
+				map[string]interface{}{"Language": []interface{}{}, "Url": "http://B"},
+				map[string]interface{}{
+					"Language": []interface{}{map[string]interface{}{"Code": "en-gb", "Country": "gb"}},
+					"Url":      nil,
+				},
+			},
+		},
+		{
+			"DocId": int64(20),
+			"Links": map[string]interface{}{"Backward": []interface{}{int64(10), int64(30)}, "Forward": []interface{}{int64(80)}},
+			"Name":  []interface{}{map[string]interface{}{"Language": []interface{}{}, "Url": "http://C"}},
+		},
+	}
+	if !reflect.DeepEqual(rows, expected) {
+		t.Errorf("expected %v, got %v", expected, rows)
+	}
+}
+
+type parquetTestTagged struct {
+	Tags  []string         `parquet:"tags,list"`
+	Attrs map[string]int32 `parquet:"attrs"`
+}
+
+func TestParquetListAndMapColumns(t *testing.T) {
+	var file bytes.Buffer
+	err := parquet.Write(&file, []parquetTestTagged{
+		{Tags: []string{"a", "b"}, Attrs: map[string]int32{"x": 1, "y": 2}},
+		{Tags: []string{}, Attrs: map[string]int32{}},
+	})
+	if err != nil {
+		t.Fatalf("write parquet file: %v", err)
+	}
+
+	columns, rows := readParquetTestRows(t, file.Bytes())
+	if !reflect.DeepEqual(columns, []string{"tags", "attrs"}) {
+		t.Fatalf("unexpected columns %v", columns)
+	}
+	expected := []map[string]interface{}{
+		{"tags": []interface{}{"a", "b"}, "attrs": map[string]interface{}{"x": int64(1), "y": int64(2)}},
+		{"tags": []interface{}{}, "attrs": map[string]interface{}{}},
+	}
+	if !reflect.DeepEqual(rows, expected) {
+		t.Errorf("expected %v, got %v", expected, rows)
+	}
+}
@@ -968,6 +968,20 @@ Executes JavaScript in the client.
 
 			properties[colInfo.ColumnName] = CreateColumnLine(colInfo)
 		}
+		for _, computed := range tableInfo.ComputedColumns {
+			colInfo := computed.ColumnInfo()
+			if existing, ok := tableInfo.GetColumnByName(computed.Name); ok {
+				colInfo = *existing
+			}
+			property := CreateColumnLine(colInfo)
+			property["readOnly"] = true
+			properties[computed.Name] = property
+		}
+		for _, rollup := range tableInfo.RollupColumns {
+			if property, ok := properties[rollup.Name].(map[string]interface{}); ok {
+				property["readOnly"] = true
+			}
+		}
 
 		ramlType["properties"] = properties
 		ramlType["required"] = requiredCols
@@ -1024,6 +1038,12 @@ Executes JavaScript in the client.
 			if resource.IsStandardColumn(colInfo.ColumnName) {
 				continue
 			}
+			if _, isComputed := tableInfo.GetComputedColumn(colInfo.ColumnName); isComputed {
+				continue
+			}
+			if _, isRollup := tableInfo.GetRollupColumn(colInfo.ColumnName); isRollup {
+				continue
+			}
 
 			if !colInfo.IsNullable && colInfo.DefaultValue == "" {
 				requiredCols = append(requiredCols, colInfo.ColumnName)
@@ -2305,6 +2325,12 @@ func CreatePostRequestExample(tableInfo table_info.TableInfo) map[string]interfa
 		if col.IsForeignKey || skipColumns[col.ColumnName] || resource.IsStandardColumn(col.ColumnName) {
 			continue
 		}
+		if _, isComputed := tableInfo.GetComputedColumn(col.ColumnName); isComputed {
+			continue
+		}
+		if _, isRollup := tableInfo.GetRollupColumn(col.ColumnName); isRollup {
+			continue
+		}
 
 		switch col.ColumnType {
 		case "email":
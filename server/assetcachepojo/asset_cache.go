@@ -204,6 +204,16 @@ func (afc *AssetFolderCache) downloadFileFromCloudStore(ctx context.Context, fil
 	return nil
 }
 
+// CloudFilesystem returns the rclone filesystem rooted at the store path of this cache
+func (afc *AssetFolderCache) CloudFilesystem(ctx context.Context) (fs.Fs, error) {
+	configSetName := afc.CloudStore.Name
+	if strings.Index(afc.CloudStore.RootPath, ":") > -1 {
+		configSetName = strings.Split(afc.CloudStore.RootPath, ":")[0]
+	}
+	keyname := strings.Trim(afc.Keyname, "/")
+	return afc.newCloudFilesystem(ctx, path.Clean(afc.CloudStore.RootPath+"/"+keyname), configSetName)
+}
+
 func (afc *AssetFolderCache) newCloudFilesystem(ctx context.Context, sourcePath, configSetName string) (fs.Fs, error) {
 	rcloneConfigurationMu.Lock()
 	defer rcloneConfigurationMu.Unlock()
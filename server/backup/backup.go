@@ -0,0 +1,503 @@
+// Package backup writes a daptin instance to a portable archive and restores it, on the same kind
+// of database or another one.
+//
+// An archive is a gzip compressed tar, encrypted when an encryption key is given, of
+//
+//	manifest.json            the tables and their columns, in the order they are restored
+//	schema.json              the tables of the instance, in the form of a schema file
+//	tables/<table>.ndjson    the rows of a table, one json object per line
+//	files/<store>/<path>     the files of the local cloud stores, by cloud store reference id
+package backup
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/daptin/daptin/server/resource"
+	"github.com/daptin/daptin/server/statementbuilder"
+	"github.com/daptin/daptin/server/table_info"
+	"github.com/doug-martin/goqu/v9"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	log "github.com/sirupsen/logrus"
+)
+
+// FormatVersion is the version of the archive layout, archives of a newer version are not restored
+const FormatVersion = 1
+
+const (
+	manifestEntry   = "manifest.json"
+	schemaEntry     = "schema.json"
+	tablesDirectory = "tables/"
+	filesDirectory  = "files/"
+)
+
+// the _config table is not listed in world
+const configTable = "_config"
+
+const (
+	// KindBinary columns hold bytes, written to the archive in base64
+	KindBinary = "binary"
+	// KindTime columns hold timestamps, written to the archive in RFC 3339
+	KindTime = "time"
+)
+
+// Column is a column of a backed up table, kind tells how its values are written
+type Column struct {
+	Name string `json:"name"`
+	Kind string `json:"kind,omitempty"`
+}
+
+// Table is a backed up table
+type Table struct {
+	Name    string   `json:"name"`
+	Columns []Column `json:"columns"`
+}
+
+// Manifest describes an archive, it is the first entry of the archive
+type Manifest struct {
+	FormatVersion int       `json:"format_version"`
+	CreatedAt     time.Time `json:"created_at"`
+	DatabaseType  string    `json:"database_type"`
+	// Tables are listed so that a table comes after the tables it refers to
+	Tables []Table `json:"tables"`
+	Files  bool    `json:"files"`
+}
+
+// Options are the choices of what to back up
+type Options struct {
+	// IncludeFiles adds the files of the local cloud stores
+	IncludeFiles bool
+	// EncryptionKey encrypts the archive when it is set
+	EncryptionKey string
+}
+
+// Write writes a backup of every table listed in world, the _config table and, if asked, the files
+// of the local cloud stores to output. The rows are read in transaction.
+func Write(output io.Writer, transaction *sqlx.Tx, options Options) (Manifest, error) {
+
+	manifest := Manifest{
+		FormatVersion: FormatVersion,
+		CreatedAt:     time.Now().UTC(),
+		DatabaseType:  transaction.DriverName(),
+		Files:         options.IncludeFiles,
+	}
+
+	worldTables, err := readWorldTables(transaction)
+	if err != nil {
+		return manifest, fmt.Errorf("failed to read the tables from world: %w", err)
+	}
+
+	generatedColumns := generatedColumnsOf(worldTables)
+	for _, tableName := range append([]string{configTable}, dependencyOrder(worldTables)...) {
+		columns, err := describeTable(transaction, tableName, generatedColumns[tableName])
+		if err != nil {
+			return manifest, fmt.Errorf("failed to read the columns of [%v]: %w", tableName, err)
+		}
+		manifest.Tables = append(manifest.Tables, Table{Name: tableName, Columns: columns})
+	}
+
+	var encrypter *encryptWriter
+	if options.EncryptionKey != "" {
+		encrypter, err = newEncryptWriter(output, options.EncryptionKey)
+		if err != nil {
+			return manifest, fmt.Errorf("failed to encrypt the backup: %w", err)
+		}
+		output = encrypter
+	}
+	gzipWriter := gzip.NewWriter(output)
+	archive := tar.NewWriter(gzipWriter)
+
+	if err = writeJsonEntry(archive, manifestEntry, manifest, manifest.CreatedAt); err != nil {
+		return manifest, err
+	}
+	if err = writeJsonEntry(archive, schemaEntry, map[string]interface{}{
+		"Tables": schemaTables(worldTables),
+	}, manifest.CreatedAt); err != nil {
+		return manifest, err
+	}
+
+	for _, table := range manifest.Tables {
+		if err = writeTable(archive, table, manifest.CreatedAt, transaction); err != nil {
+			return manifest, fmt.Errorf("failed to back up [%v]: %w", table.Name, err)
+		}
+	}
+
+	if options.IncludeFiles {
+		if err = writeLocalStoreFiles(archive, transaction); err != nil {
+			return manifest, err
+		}
+	}
+
+	if err = archive.Close(); err != nil {
+		return manifest, err
+	}
+	if err = gzipWriter.Close(); err != nil {
+		return manifest, err
+	}
+	if encrypter != nil {
+		return manifest, encrypter.Close()
+	}
+	return manifest, nil
+}
+
+// readWorldTables reads the schema of every table listed in world, including the join and audit
+// tables daptin generates
+func readWorldTables(transaction *sqlx.Tx) ([]table_info.TableInfo, error) {
+	query, args, err := statementbuilder.Squirrel.Select("table_name", "world_schema_json").Prepared(true).
+		From("world").Order(goqu.C("id").Asc()).ToSQL()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := transaction.Queryx(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tables := make([]table_info.TableInfo, 0)
+	for rows.Next() {
+		var tableName string
+		var schemaJson []byte
+		if err = rows.Scan(&tableName, &schemaJson); err != nil {
+			return nil, err
+		}
+		var table table_info.TableInfo
+		if err = json.Unmarshal(schemaJson, &table); err != nil {
+			log.Warnf("Schema of [%v] in world is not readable, its rows are backed up without it: %v", tableName, err)
+		}
+		table.TableName = tableName
+		tables = append(tables, table)
+	}
+	return tables, rows.Err()
+}
+
+// schemaTables are the tables of the schema file, the tables daptin generates from them are left out
+// like they are when the schema is read back from world
+func schemaTables(worldTables []table_info.TableInfo) []table_info.TableInfo {
+	tables := make([]table_info.TableInfo, 0, len(worldTables))
+	for _, table := range worldTables {
+		if strings.Contains(table.TableName, "_has_") || strings.HasSuffix(table.TableName, "_audit") {
+			continue
+		}
+		switch table.TableName {
+		case "world", "action", "usergroup":
+			continue
+		}
+		tables = append(tables, table)
+	}
+	return tables
+}
+
+// dependencyOrder lists the tables so that a table comes after the tables its foreign keys refer to
+func dependencyOrder(tables []table_info.TableInfo) []string {
+	dependsOn := make(map[string]map[string]bool)
+	names := make([]string, 0, len(tables))
+	for _, table := range tables {
+		if _, ok := dependsOn[table.TableName]; ok {
+			continue
+		}
+		names = append(names, table.TableName)
+		dependsOn[table.TableName] = make(map[string]bool)
+	}
+	sort.Strings(names)
+	for _, table := range tables {
+		for _, column := range table.Columns {
+			referred := column.ForeignKeyData.Namespace
+			if !column.IsForeignKey || column.ForeignKeyData.DataSource != "self" || referred == table.TableName {
+				continue
+			}
+			if _, ok := dependsOn[referred]; ok {
+				dependsOn[table.TableName][referred] = true
+			}
+		}
+	}
+
+	ordered := make([]string, 0, len(names))
+	added := make(map[string]bool)
+	for len(ordered) < len(names) {
+		progress := false
+		for _, name := range names {
+			if added[name] {
+				continue
+			}
+			ready := true
+			for referred := range dependsOn[name] {
+				if !added[referred] {
+					ready = false
+					break
+				}
+			}
+			if ready {
+				ordered = append(ordered, name)
+				added[name] = true
+				progress = true
+			}
+		}
+		if progress {
+			continue
+		}
+		// a cycle is broken at its first table by name
+		for _, name := range names {
+			if !added[name] {
+				ordered = append(ordered, name)
+				added[name] = true
+				break
+			}
+		}
+	}
+	return ordered
+}
+
+// generatedColumnsOf lists the computed columns of each table which the database generates from sql
+func generatedColumnsOf(tables []table_info.TableInfo) map[string]map[string]bool {
+	generatedColumns := make(map[string]map[string]bool)
+	for _, table := range tables {
+		for _, computed := range table.ComputedColumns {
+			if computed.Sql == "" {
+				continue
+			}
+			if generatedColumns[table.TableName] == nil {
+				generatedColumns[table.TableName] = make(map[string]bool)
+			}
+			generatedColumns[table.TableName][computed.Name] = true
+		}
+	}
+	return generatedColumns
+}
+
+// describeTable reads the columns of the table, the search column and the generated columns are
+// computed by the database and are not backed up
+func describeTable(transaction *sqlx.Tx, tableName string, generatedColumns map[string]bool) ([]Column, error) {
+	query, args, err := statementbuilder.Squirrel.Select(goqu.Star()).Prepared(true).From(tableName).
+		Where(goqu.L("1 = 0")).ToSQL()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := transaction.Queryx(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columnTypes, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, err
+	}
+	columns := make([]Column, 0, len(columnTypes))
+	for _, columnType := range columnTypes {
+		if columnType.Name() == resource.SearchVectorColumn || generatedColumns[columnType.Name()] {
+			continue
+		}
+		columns = append(columns, Column{Name: columnType.Name(), Kind: columnKind(columnType.DatabaseTypeName())})
+	}
+	return columns, nil
+}
+
+// columnKind tells binary and time columns apart from the others by their database type
+func columnKind(databaseType string) string {
+	databaseType = strings.ToUpper(databaseType)
+	switch {
+	case strings.Contains(databaseType, "BLOB"), strings.Contains(databaseType, "BINARY"), databaseType == "BYTEA":
+		return KindBinary
+	case strings.Contains(databaseType, "TIMESTAMP"), strings.Contains(databaseType, "DATETIME"), databaseType == "DATE":
+		return KindTime
+	}
+	return ""
+}
+
+func writeJsonEntry(archive *tar.Writer, name string, value interface{}, modTime time.Time) error {
+	contents, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = archive.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    int64(len(contents)),
+		ModTime: modTime,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = archive.Write(contents)
+	return err
+}
+
+// writeTable writes the rows of the table to the archive. The size of a tar entry is written before
+// its contents, so the rows are spooled to a temporary file first instead of being held in memory
+func writeTable(archive *tar.Writer, table Table, modTime time.Time, transaction *sqlx.Tx) error {
+	spool, err := os.CreateTemp("", "daptin-backup-*.ndjson")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+
+	columnNames := make([]interface{}, 0, len(table.Columns))
+	hasId := false
+	for _, column := range table.Columns {
+		columnNames = append(columnNames, column.Name)
+		hasId = hasId || column.Name == "id"
+	}
+	builder := statementbuilder.Squirrel.Select(columnNames...).Prepared(true).From(table.Name)
+	// rows referring to rows of the same table are restored after them
+	if hasId {
+		builder = builder.Order(goqu.C("id").Asc())
+	}
+	query, args, err := builder.ToSQL()
+	if err != nil {
+		return err
+	}
+	rows, err := transaction.Queryx(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	encoder := json.NewEncoder(spool)
+	for rows.Next() {
+		values, err := rows.SliceScan()
+		if err != nil {
+			return err
+		}
+		row := make(map[string]interface{}, len(values))
+		for i, value := range values {
+			row[table.Columns[i].Name] = encodeValue(value, table.Columns[i].Kind)
+		}
+		if err = encoder.Encode(row); err != nil {
+			return err
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	size, err := spool.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err = spool.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	err = archive.WriteHeader(&tar.Header{
+		Name:    tablesDirectory + table.Name + ".ndjson",
+		Mode:    0644,
+		Size:    size,
+		ModTime: modTime,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(archive, spool)
+	return err
+}
+
+// encodeValue turns a value read from the database into one every database reads back the same way
+func encodeValue(value interface{}, kind string) interface{} {
+	switch typed := value.(type) {
+	case []byte:
+		if kind == KindBinary {
+			return base64.StdEncoding.EncodeToString(typed)
+		}
+		return string(typed)
+	case string:
+		if kind == KindBinary {
+			return base64.StdEncoding.EncodeToString([]byte(typed))
+		}
+		return typed
+	case time.Time:
+		return typed.Format(time.RFC3339Nano)
+	}
+	return value
+}
+
+// localStores reads the root path of every cloud store on the local file system, by reference id
+func localStores(transaction *sqlx.Tx) (map[string]string, error) {
+	query, args, err := statementbuilder.Squirrel.Select("reference_id", "root_path").Prepared(true).
+		From("cloud_store").Where(goqu.Ex{"store_provider": "local"}).ToSQL()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := transaction.Queryx(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stores := make(map[string]string)
+	for rows.Next() {
+		var referenceId []byte
+		var rootPath string
+		if err = rows.Scan(&referenceId, &rootPath); err != nil {
+			return nil, err
+		}
+		storeId, err := uuid.FromBytes(referenceId)
+		if err != nil {
+			log.Warnf("Cloud store with reference id [%x] is left out of the backup: %v", referenceId, err)
+			continue
+		}
+		stores[storeId.String()] = rootPath
+	}
+	return stores, rows.Err()
+}
+
+// writeLocalStoreFiles adds every file under the root path of the local cloud stores
+func writeLocalStoreFiles(archive *tar.Writer, transaction *sqlx.Tx) error {
+	stores, err := localStores(transaction)
+	if err != nil {
+		return fmt.Errorf("failed to read the local cloud stores: %w", err)
+	}
+
+	for storeId, rootPath := range stores {
+		if _, err := os.Stat(rootPath); err != nil {
+			log.Warnf("Files of cloud store [%v] at [%v] are left out of the backup: %v", storeId, rootPath, err)
+			continue
+		}
+		err = filepath.WalkDir(rootPath, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil || !entry.Type().IsRegular() {
+				return err
+			}
+			info, err := entry.Info()
+			if err != nil {
+				return err
+			}
+			relativePath, err := filepath.Rel(rootPath, path)
+			if err != nil {
+				return err
+			}
+			file, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+
+			err = archive.WriteHeader(&tar.Header{
+				Name:    filesDirectory + storeId + "/" + filepath.ToSlash(relativePath),
+				Mode:    0644,
+				Size:    info.Size(),
+				ModTime: info.ModTime(),
+			})
+			if err != nil {
+				return err
+			}
+			// a file growing while it is read is cut at the size in its header
+			_, err = io.CopyN(archive, file, info.Size())
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("failed to back up the files of cloud store [%v]: %w", storeId, err)
+		}
+	}
+	return nil
+}
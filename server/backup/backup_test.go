@@ -0,0 +1,233 @@
+package backup
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func openDatabase(t *testing.T, statements ...string) *sqlx.DB {
+	db, err := sqlx.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	db.SetMaxOpenConns(1)
+	for _, statement := range statements {
+		if _, err := db.Exec(statement); err != nil {
+			t.Fatalf("statement [%v] failed: %v", statement, err)
+		}
+	}
+	return db
+}
+
+func TestWriteAndRestore(t *testing.T) {
+	storagePath := t.TempDir()
+	storeRef := uuid.New()
+	noteRef := uuid.New()
+	written := time.Date(2026, 3, 1, 9, 30, 0, 0, time.UTC)
+
+	schema := []string{
+		`create table world (id integer primary key, table_name text, world_schema_json text)`,
+		`create table _config (id integer primary key, name text, value text)`,
+		`create table cloud_store (id integer primary key, reference_id blob, store_provider text, root_path text)`,
+		`create table note (id integer primary key, reference_id blob, title text, created_at timestamp, cloud_store_id integer)`,
+	}
+	source := openDatabase(t, schema...)
+	defer source.Close()
+	source.MustExec(`insert into world (id, table_name, world_schema_json) values
+		(1, 'note', '{"TableName":"note","Columns":[{"ColumnName":"cloud_store_id","IsForeignKey":true,"ForeignKeyData":{"DataSource":"self","Namespace":"cloud_store","KeyName":"id"}}]}'),
+		(2, 'cloud_store', '{"TableName":"cloud_store"}'),
+		(3, 'world', '{"TableName":"world"}')`)
+	source.MustExec(`insert into _config (id, name, value) values (1, 'hostname', 'example.com')`)
+	source.MustExec(`insert into cloud_store (id, reference_id, store_provider, root_path) values (1, ?, 'local', ?)`, storeRef[:], storagePath)
+	source.MustExec(`insert into note (id, reference_id, title, created_at, cloud_store_id) values (7, ?, 'first', ?, 1)`, noteRef[:], written)
+
+	if err := os.MkdirAll(filepath.Join(storagePath, "images"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(storagePath, "images", "logo.png"), []byte("logo"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var archive bytes.Buffer
+	tx := source.MustBegin()
+	manifest, err := Write(&archive, tx, Options{IncludeFiles: true})
+	tx.Rollback()
+	if err != nil {
+		t.Fatalf("write backup: %v", err)
+	}
+	order := make([]string, 0)
+	for _, table := range manifest.Tables {
+		order = append(order, table.Name)
+	}
+	if len(order) != 4 || order[0] != configTable || order[1] != "cloud_store" || order[2] != "note" {
+		t.Errorf("expected _config, cloud_store, note, world in that order, got %v", order)
+	}
+
+	if err := os.RemoveAll(filepath.Join(storagePath, "images")); err != nil {
+		t.Fatal(err)
+	}
+
+	// the target has rows of its own, no world table and a note table without cloud_store_id
+	target := openDatabase(t,
+		`create table _config (id integer primary key, name text, value text)`,
+		`create table cloud_store (id integer primary key, reference_id blob, store_provider text, root_path text)`,
+		`create table note (id integer primary key, reference_id blob, title text, created_at timestamp)`,
+		`insert into note (id, title) values (1, 'stale')`,
+	)
+	defer target.Close()
+
+	reader, err := NewReader(&archive, "")
+	if err != nil {
+		t.Fatalf("read backup: %v", err)
+	}
+	defer reader.Close()
+	if len(reader.Schema) != 2 {
+		t.Errorf("expected the schema of note and cloud_store, got %+v", reader.Schema)
+	}
+
+	tx = target.MustBegin()
+	result, err := reader.Restore(tx)
+	if err != nil {
+		tx.Rollback()
+		t.Fatalf("restore: %v", err)
+	}
+	if err = tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Rows["note"] != 1 || result.Rows["cloud_store"] != 1 || result.Rows[configTable] != 1 {
+		t.Errorf("unexpected restored rows %v", result.Rows)
+	}
+	if len(result.Skipped) != 1 || result.Skipped[0] != "world" {
+		t.Errorf("expected world to be skipped, got %v", result.Skipped)
+	}
+	if result.Files != 1 {
+		t.Errorf("expected 1 file restored, got %d", result.Files)
+	}
+
+	var notes []struct {
+		Id          int64     `db:"id"`
+		ReferenceId []byte    `db:"reference_id"`
+		Title       string    `db:"title"`
+		CreatedAt   time.Time `db:"created_at"`
+	}
+	if err = target.Select(&notes, `select id, reference_id, title, created_at from note`); err != nil {
+		t.Fatal(err)
+	}
+	if len(notes) != 1 || notes[0].Id != 7 || !bytes.Equal(notes[0].ReferenceId, noteRef[:]) ||
+		notes[0].Title != "first" || !notes[0].CreatedAt.Equal(written) {
+		t.Errorf("expected the backed up note, got %+v", notes)
+	}
+
+	contents, err := os.ReadFile(filepath.Join(storagePath, "images", "logo.png"))
+	if err != nil || string(contents) != "logo" {
+		t.Errorf("expected the file to be restored, got %q %v", contents, err)
+	}
+
+	if _, err = NewReader(bytes.NewReader([]byte("not a backup")), ""); err == nil {
+		t.Errorf("expected an error reading something which is not a backup")
+	}
+}
+
+func TestEncryptedBackup(t *testing.T) {
+	source := openDatabase(t,
+		`create table world (id integer primary key, table_name text, world_schema_json text)`,
+		`create table _config (id integer primary key, name text, value text)`,
+		`insert into _config (id, name, value) values (1, 'jwt.secret', 'do not leak')`,
+	)
+	defer source.Close()
+
+	var archive bytes.Buffer
+	tx := source.MustBegin()
+	_, err := Write(&archive, tx, Options{EncryptionKey: "correct horse"})
+	tx.Rollback()
+	if err != nil {
+		t.Fatalf("write backup: %v", err)
+	}
+	if bytes.Contains(archive.Bytes(), []byte("jwt.secret")) || !bytes.HasPrefix(archive.Bytes(), []byte(encryptedMagic)) {
+		t.Fatalf("expected the archive to be encrypted")
+	}
+
+	if _, err = NewReader(bytes.NewReader(archive.Bytes()), ""); err != ErrEncryptionKeyRequired {
+		t.Errorf("expected the key to be required, got %v", err)
+	}
+	if _, err = NewReader(bytes.NewReader(archive.Bytes()), "wrong key"); err == nil {
+		t.Errorf("expected an error reading with the wrong key")
+	}
+	if _, err = NewReader(bytes.NewReader(archive.Bytes()[:archive.Len()-10]), "correct horse"); err == nil {
+		t.Errorf("expected an error reading a cut off archive")
+	}
+
+	reader, err := NewReader(bytes.NewReader(archive.Bytes()), "correct horse")
+	if err != nil {
+		t.Fatalf("read backup: %v", err)
+	}
+	defer reader.Close()
+	target := openDatabase(t, `create table _config (id integer primary key, name text, value text)`)
+	defer target.Close()
+	tx = target.MustBegin()
+	if _, err = reader.Restore(tx); err != nil {
+		tx.Rollback()
+		t.Fatalf("restore: %v", err)
+	}
+	if err = tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	var value string
+	if err = target.Get(&value, `select value from _config where name = 'jwt.secret'`); err != nil || value != "do not leak" {
+		t.Errorf("expected the config to be restored, got %q %v", value, err)
+	}
+}
+
+func TestEncryptionChunks(t *testing.T) {
+	plain := make([]byte, 2*encryptionChunkSize+100)
+	if _, err := rand.Read(plain); err != nil {
+		t.Fatal(err)
+	}
+	for _, size := range []int{0, 1, encryptionChunkSize, 2 * encryptionChunkSize, len(plain)} {
+		var sealed bytes.Buffer
+		writer, err := newEncryptWriter(&sealed, "key")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = writer.Write(plain[:size]); err != nil {
+			t.Fatal(err)
+		}
+		if err = writer.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		input, err := openArchive(bytes.NewReader(sealed.Bytes()), "key")
+		if err != nil {
+			t.Fatal(err)
+		}
+		opened, err := io.ReadAll(input)
+		if err != nil || !bytes.Equal(opened, plain[:size]) {
+			t.Errorf("size %d: expected the written bytes back, got %d bytes %v", size, len(opened), err)
+		}
+
+		// dropping the last chunk leaves a stream which still opens chunk by chunk, but never ends
+		if size > encryptionChunkSize {
+			lastChunk := encryptionChunkSize + 4 + 16
+			if size%encryptionChunkSize != 0 {
+				lastChunk = size%encryptionChunkSize + 4 + 16
+			}
+			input, err = openArchive(bytes.NewReader(sealed.Bytes()[:sealed.Len()-lastChunk]), "key")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err = io.ReadAll(input); err == nil {
+				t.Errorf("size %d: expected an error reading without the last chunk", size)
+			}
+		}
+	}
+}
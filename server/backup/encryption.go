@@ -0,0 +1,217 @@
+package backup
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"time"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// An encrypted archive is the gzip compressed tar sealed with AES-256-GCM in chunks, under a key
+// derived from the encryption key with scrypt:
+//
+//	magic (8 bytes) | salt (16 bytes) | nonce prefix (7 bytes) | chunk | chunk | ...
+//
+// A chunk is the length of its sealed bytes, 4 bytes big endian, followed by them. The nonce of a
+// chunk is the prefix, the index of the chunk in 4 bytes and a byte set on the last chunk only, so
+// chunks cannot be reordered or dropped and an archive cut short does not read as a whole one.
+
+// EncryptionKeyConfig is the _config entry holding the key backups are encrypted with
+const EncryptionKeyConfig = "backup.encryption.key"
+
+// ErrEncryptionKeyRequired is returned when an encrypted archive is read without a key
+var ErrEncryptionKeyRequired = errors.New("the backup is encrypted, its encryption key is required")
+
+const (
+	encryptedMagic      = "DPTNBKE1"
+	encryptionSaltSize  = 16
+	noncePrefixSize     = 7
+	encryptionChunkSize = 64 * 1024
+)
+
+// FileName is the name of a backup taken at the time
+func FileName(createdAt time.Time, encrypted bool) string {
+	name := fmt.Sprintf("daptin_backup_%s.tar.gz", createdAt.UTC().Format("20060102_150405"))
+	if encrypted {
+		name = name + ".enc"
+	}
+	return name
+}
+
+func encryptionCipher(encryptionKey string, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key([]byte(encryptionKey), salt, 1<<15, 8, 1, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func chunkNonce(prefix []byte, index uint32, last bool) []byte {
+	nonce := make([]byte, 0, noncePrefixSize+5)
+	nonce = append(nonce, prefix...)
+	nonce = binary.BigEndian.AppendUint32(nonce, index)
+	if last {
+		return append(nonce, 1)
+	}
+	return append(nonce, 0)
+}
+
+// encryptWriter seals what is written to it in chunks, Close seals the last chunk
+type encryptWriter struct {
+	output io.Writer
+	aead   cipher.AEAD
+	prefix []byte
+	index  uint32
+	buffer []byte
+}
+
+func newEncryptWriter(output io.Writer, encryptionKey string) (*encryptWriter, error) {
+	header := make([]byte, len(encryptedMagic)+encryptionSaltSize+noncePrefixSize)
+	copy(header, encryptedMagic)
+	if _, err := rand.Read(header[len(encryptedMagic):]); err != nil {
+		return nil, err
+	}
+	salt := header[len(encryptedMagic) : len(encryptedMagic)+encryptionSaltSize]
+	aead, err := encryptionCipher(encryptionKey, salt)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = output.Write(header); err != nil {
+		return nil, err
+	}
+	return &encryptWriter{
+		output: output,
+		aead:   aead,
+		prefix: header[len(encryptedMagic)+encryptionSaltSize:],
+		buffer: make([]byte, 0, encryptionChunkSize),
+	}, nil
+}
+
+func (w *encryptWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		// a full chunk is sealed once more follows, so the last chunk is always sealed by Close
+		if len(w.buffer) == encryptionChunkSize {
+			if err := w.seal(false); err != nil {
+				return written, err
+			}
+		}
+		n := copy(w.buffer[len(w.buffer):encryptionChunkSize], p)
+		w.buffer = w.buffer[:len(w.buffer)+n]
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+// Close seals the last chunk, it does not close the output
+func (w *encryptWriter) Close() error {
+	return w.seal(true)
+}
+
+func (w *encryptWriter) seal(last bool) error {
+	if w.index == math.MaxUint32 {
+		return errors.New("backup is too large to encrypt")
+	}
+	sealed := w.aead.Seal(nil, chunkNonce(w.prefix, w.index, last), w.buffer, nil)
+	length := binary.BigEndian.AppendUint32(nil, uint32(len(sealed)))
+	if _, err := w.output.Write(length); err != nil {
+		return err
+	}
+	if _, err := w.output.Write(sealed); err != nil {
+		return err
+	}
+	w.index++
+	w.buffer = w.buffer[:0]
+	return nil
+}
+
+// decryptReader opens the chunks of an encrypted archive as they are read
+type decryptReader struct {
+	input  io.Reader
+	aead   cipher.AEAD
+	prefix []byte
+	index  uint32
+	plain  []byte
+	last   bool
+}
+
+// openArchive returns the gzip stream of the archive in input, decrypting it when it is encrypted.
+// Archives which are not encrypted are read as they are.
+func openArchive(input io.Reader, encryptionKey string) (io.Reader, error) {
+	buffered := bufio.NewReader(input)
+	magic, err := buffered.Peek(len(encryptedMagic))
+	if err != nil || string(magic) != encryptedMagic {
+		return buffered, nil
+	}
+	if encryptionKey == "" {
+		return nil, ErrEncryptionKeyRequired
+	}
+
+	header := make([]byte, len(encryptedMagic)+encryptionSaltSize+noncePrefixSize)
+	if _, err = io.ReadFull(buffered, header); err != nil {
+		return nil, fmt.Errorf("encrypted backup is cut off: %w", err)
+	}
+	aead, err := encryptionCipher(encryptionKey, header[len(encryptedMagic):len(encryptedMagic)+encryptionSaltSize])
+	if err != nil {
+		return nil, err
+	}
+	return &decryptReader{
+		input:  buffered,
+		aead:   aead,
+		prefix: header[len(encryptedMagic)+encryptionSaltSize:],
+	}, nil
+}
+
+func (r *decryptReader) Read(p []byte) (int, error) {
+	for len(r.plain) == 0 {
+		if r.last {
+			return 0, io.EOF
+		}
+		if err := r.open(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.plain)
+	r.plain = r.plain[n:]
+	return n, nil
+}
+
+func (r *decryptReader) open() error {
+	length := make([]byte, 4)
+	if _, err := io.ReadFull(r.input, length); err != nil {
+		return fmt.Errorf("encrypted backup is cut off: %w", io.ErrUnexpectedEOF)
+	}
+	size := binary.BigEndian.Uint32(length)
+	if size < uint32(r.aead.Overhead()) || size > uint32(encryptionChunkSize+r.aead.Overhead()) {
+		return errors.New("encrypted backup is damaged")
+	}
+	sealed := make([]byte, size)
+	if _, err := io.ReadFull(r.input, sealed); err != nil {
+		return fmt.Errorf("encrypted backup is cut off: %w", io.ErrUnexpectedEOF)
+	}
+
+	plain, err := r.aead.Open(nil, chunkNonce(r.prefix, r.index, false), sealed, nil)
+	if err != nil {
+		plain, err = r.aead.Open(nil, chunkNonce(r.prefix, r.index, true), sealed, nil)
+		if err != nil {
+			return errors.New("the encryption key is wrong or the backup is damaged")
+		}
+		r.last = true
+	}
+	r.index++
+	r.plain = plain
+	return nil
+}
@@ -0,0 +1,404 @@
+package backup
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/daptin/daptin/server/resource"
+	"github.com/daptin/daptin/server/statementbuilder"
+	"github.com/daptin/daptin/server/table_info"
+	"github.com/doug-martin/goqu/v9"
+	"github.com/jmoiron/sqlx"
+	log "github.com/sirupsen/logrus"
+)
+
+// rows of a table are inserted in statements of at most this many values, below the limit of
+// every supported database
+const maxInsertValues = 900
+
+// the layouts timestamps are read back in, the first is the one Write uses
+var timeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999-07:00",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02",
+}
+
+// Result is what a restore restored
+type Result struct {
+	// Rows is the number of rows restored by table
+	Rows map[string]int
+	// Skipped are the tables of the backup which are not in the database
+	Skipped []string
+	// Files is the number of files restored to local cloud stores
+	Files int
+}
+
+// Reader reads an archive written by Write. The manifest and the schema are read when the reader is
+// created, the rows and the files when it is restored
+type Reader struct {
+	Manifest Manifest
+	// Schema are the tables of the backed up instance, to create before restoring the rows
+	Schema []table_info.TableInfo
+
+	gzipReader *gzip.Reader
+	archive    *tar.Reader
+}
+
+// NewReader reads the manifest and the schema of the archive in input. An encrypted archive is
+// decrypted with encryptionKey, ErrEncryptionKeyRequired is returned when it is empty.
+func NewReader(input io.Reader, encryptionKey string) (*Reader, error) {
+	input, err := openArchive(input, encryptionKey)
+	if err != nil {
+		return nil, err
+	}
+	gzipReader, err := gzip.NewReader(input)
+	if err != nil {
+		return nil, fmt.Errorf("not a daptin backup: %w", err)
+	}
+	reader := &Reader{
+		gzipReader: gzipReader,
+		archive:    tar.NewReader(gzipReader),
+	}
+
+	if err = reader.readJsonEntry(manifestEntry, &reader.Manifest); err != nil {
+		return nil, err
+	}
+	if reader.Manifest.FormatVersion > FormatVersion {
+		return nil, fmt.Errorf("backup format version %d is newer than the supported version %d",
+			reader.Manifest.FormatVersion, FormatVersion)
+	}
+
+	var schema struct {
+		Tables []table_info.TableInfo
+	}
+	if err = reader.readJsonEntry(schemaEntry, &schema); err != nil {
+		return nil, err
+	}
+	reader.Schema = schema.Tables
+	return reader, nil
+}
+
+// Close closes the reader, not the input it reads from
+func (r *Reader) Close() error {
+	return r.gzipReader.Close()
+}
+
+func (r *Reader) readJsonEntry(name string, value interface{}) error {
+	header, err := r.archive.Next()
+	if err != nil {
+		return fmt.Errorf("not a daptin backup: %w", err)
+	}
+	if header.Name != name {
+		return fmt.Errorf("not a daptin backup: expected [%v], found [%v]", name, header.Name)
+	}
+	return json.NewDecoder(r.archive).Decode(value)
+}
+
+// Restore replaces the rows of the tables in the backup with the rows of the backup, and writes the
+// files of the backup to the local cloud stores. Tables of the backup missing in the database are
+// skipped, and so are their columns missing in the database. The rows are written in transaction,
+// which the caller commits.
+func (r *Reader) Restore(transaction *sqlx.Tx) (Result, error) {
+	result := Result{
+		Rows:    make(map[string]int),
+		Skipped: make([]string, 0),
+	}
+
+	existing, err := existingTables(transaction)
+	if err != nil {
+		return result, fmt.Errorf("failed to list the tables of the database: %w", err)
+	}
+	tables := make(map[string]Table)
+	for _, table := range r.Manifest.Tables {
+		if !existing[table.Name] {
+			result.Skipped = append(result.Skipped, table.Name)
+			continue
+		}
+		tables[table.Name] = table
+	}
+	generatedColumns := generatedColumnsOf(r.Schema)
+
+	if err = setForeignKeyChecks(transaction, false); err != nil {
+		return result, err
+	}
+	// a mysql connection keeps the setting after the transaction, also when the restore fails
+	defer func() {
+		resource.CheckErr(setForeignKeyChecks(transaction, true), "Failed to turn the foreign key checks back on")
+	}()
+
+	// rows refer to the rows of tables before them, so the tables are emptied in reverse
+	for i := len(r.Manifest.Tables) - 1; i >= 0; i-- {
+		tableName := r.Manifest.Tables[i].Name
+		if _, ok := tables[tableName]; !ok {
+			continue
+		}
+		query, args, err := statementbuilder.Squirrel.Delete(tableName).Prepared(true).ToSQL()
+		if err != nil {
+			return result, err
+		}
+		if _, err = transaction.Exec(query, args...); err != nil {
+			return result, fmt.Errorf("failed to empty [%v]: %w", tableName, err)
+		}
+	}
+
+	var stores map[string]string
+	for {
+		header, err := r.archive.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return result, fmt.Errorf("failed to read the backup: %w", err)
+		}
+
+		switch {
+		case strings.HasPrefix(header.Name, tablesDirectory):
+			tableName := strings.TrimSuffix(strings.TrimPrefix(header.Name, tablesDirectory), ".ndjson")
+			table, ok := tables[tableName]
+			if !ok {
+				continue
+			}
+			count, err := restoreTable(r.archive, table, generatedColumns[table.Name], transaction)
+			if err != nil {
+				return result, fmt.Errorf("failed to restore [%v]: %w", tableName, err)
+			}
+			result.Rows[tableName] = count
+
+		case strings.HasPrefix(header.Name, filesDirectory) && header.Typeflag == tar.TypeReg:
+			// the files go to the root paths of the restored cloud stores
+			if stores == nil {
+				stores, err = localStores(transaction)
+				if err != nil {
+					return result, fmt.Errorf("failed to read the local cloud stores: %w", err)
+				}
+			}
+			restored, err := restoreFile(r.archive, header, stores)
+			if err != nil {
+				return result, err
+			}
+			if restored {
+				result.Files++
+			}
+		}
+	}
+
+	if transaction.DriverName() == "postgres" {
+		for tableName := range tables {
+			if err = resetSequence(transaction, tableName); err != nil {
+				return result, fmt.Errorf("failed to reset the id sequence of [%v]: %w", tableName, err)
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// existingTables lists the tables of the database
+func existingTables(transaction *sqlx.Tx) (map[string]bool, error) {
+	var query string
+	switch transaction.DriverName() {
+	case "sqlite3":
+		query = "SELECT name FROM sqlite_master WHERE type = 'table'"
+	case "mysql":
+		query = "SELECT table_name FROM information_schema.tables WHERE table_schema = DATABASE()"
+	case "postgres":
+		query = "SELECT tablename FROM pg_tables WHERE schemaname = current_schema()"
+	default:
+		return nil, fmt.Errorf("restore is not supported on [%v]", transaction.DriverName())
+	}
+
+	var names []string
+	if err := transaction.Select(&names, query); err != nil {
+		return nil, err
+	}
+	tables := make(map[string]bool, len(names))
+	for _, name := range names {
+		tables[name] = true
+	}
+	return tables, nil
+}
+
+// setForeignKeyChecks turns the foreign key checks of the transaction off while the tables are
+// emptied and filled, where the database allows it. On postgres the order of the tables keeps the
+// foreign keys satisfied
+func setForeignKeyChecks(transaction *sqlx.Tx, enabled bool) error {
+	var err error
+	switch transaction.DriverName() {
+	case "mysql":
+		if enabled {
+			_, err = transaction.Exec("SET FOREIGN_KEY_CHECKS = 1")
+		} else {
+			_, err = transaction.Exec("SET FOREIGN_KEY_CHECKS = 0")
+		}
+	case "sqlite3":
+		// checked when the transaction commits, the pragma ends with the transaction
+		if !enabled {
+			_, err = transaction.Exec("PRAGMA defer_foreign_keys = ON")
+		}
+	}
+	return err
+}
+
+// restoreTable inserts the rows read from input into the table, leaving out the columns the table
+// does not have and the columns the database generates
+func restoreTable(input io.Reader, table Table, generatedColumns map[string]bool, transaction *sqlx.Tx) (int, error) {
+	targetColumns, err := describeTable(transaction, table.Name, generatedColumns)
+	if err != nil {
+		return 0, err
+	}
+	hasColumn := make(map[string]bool, len(targetColumns))
+	for _, column := range targetColumns {
+		hasColumn[column.Name] = true
+	}
+	columns := make([]Column, 0, len(table.Columns))
+	columnNames := make([]interface{}, 0, len(table.Columns))
+	for _, column := range table.Columns {
+		if !hasColumn[column.Name] {
+			log.Warnf("Column [%v] of [%v] is not in the database and is not restored", column.Name, table.Name)
+			continue
+		}
+		columns = append(columns, column)
+		columnNames = append(columnNames, column.Name)
+	}
+	if len(columns) == 0 {
+		return 0, nil
+	}
+
+	batchSize := maxInsertValues / len(columns)
+	if batchSize < 1 {
+		batchSize = 1
+	}
+	batch := make([][]interface{}, 0, batchSize)
+	count := 0
+	insert := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		query, args, err := statementbuilder.Squirrel.Insert(table.Name).Prepared(true).
+			Cols(columnNames...).Vals(batch...).ToSQL()
+		if err != nil {
+			return err
+		}
+		if _, err = transaction.Exec(query, args...); err != nil {
+			return err
+		}
+		count += len(batch)
+		batch = batch[:0]
+		return nil
+	}
+
+	lines := bufio.NewScanner(input)
+	lines.Buffer(make([]byte, 0, 64*1024), 1<<30)
+	for lines.Scan() {
+		decoder := json.NewDecoder(strings.NewReader(lines.Text()))
+		decoder.UseNumber()
+		row := make(map[string]interface{})
+		if err = decoder.Decode(&row); err != nil {
+			return count, fmt.Errorf("row %d: %w", count+len(batch)+1, err)
+		}
+		values := make([]interface{}, len(columns))
+		for i, column := range columns {
+			values[i], err = decodeValue(row[column.Name], column.Kind)
+			if err != nil {
+				return count, fmt.Errorf("row %d, column [%v]: %w", count+len(batch)+1, column.Name, err)
+			}
+		}
+		batch = append(batch, values)
+		if len(batch) == batchSize {
+			if err = insert(); err != nil {
+				return count, err
+			}
+		}
+	}
+	if err = lines.Err(); err != nil {
+		return count, err
+	}
+	return count, insert()
+}
+
+// decodeValue turns a value of the archive back into the value written by encodeValue
+func decodeValue(value interface{}, kind string) (interface{}, error) {
+	switch typed := value.(type) {
+	case json.Number:
+		if integer, err := typed.Int64(); err == nil {
+			return integer, nil
+		}
+		return typed.Float64()
+	case string:
+		switch kind {
+		case KindBinary:
+			return base64.StdEncoding.DecodeString(typed)
+		case KindTime:
+			for _, layout := range timeLayouts {
+				if parsed, err := time.Parse(layout, typed); err == nil {
+					return parsed, nil
+				}
+			}
+		}
+		return typed, nil
+	}
+	return value, nil
+}
+
+// restoreFile writes a file of the archive below the root path of its cloud store. Files of cloud
+// stores which are not local in the restored database are skipped
+func restoreFile(input io.Reader, header *tar.Header, stores map[string]string) (bool, error) {
+	storeId, relativePath, _ := strings.Cut(strings.TrimPrefix(header.Name, filesDirectory), "/")
+	rootPath, ok := stores[storeId]
+	if !ok {
+		log.Warnf("Cloud store [%v] is not a local cloud store, [%v] is not restored", storeId, relativePath)
+		return false, nil
+	}
+
+	relativePath = filepath.Clean(filepath.FromSlash(relativePath))
+	if relativePath == "." || filepath.IsAbs(relativePath) || relativePath == ".." ||
+		strings.HasPrefix(relativePath, ".."+string(filepath.Separator)) {
+		return false, fmt.Errorf("invalid file path [%v] in the backup", header.Name)
+	}
+	path := filepath.Join(rootPath, relativePath)
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return false, err
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return false, err
+	}
+	_, err = io.Copy(file, input)
+	closeErr := file.Close()
+	if err = errors.Join(err, closeErr); err != nil {
+		return false, fmt.Errorf("failed to restore [%v]: %w", path, err)
+	}
+	if !header.ModTime.IsZero() {
+		_ = os.Chtimes(path, header.ModTime, header.ModTime)
+	}
+	return true, nil
+}
+
+// resetSequence moves the id sequence of a postgres table past the restored ids
+func resetSequence(transaction *sqlx.Tx, tableName string) error {
+	var hasId bool
+	err := transaction.Get(&hasId, "SELECT count(*) > 0 FROM information_schema.columns "+
+		"WHERE table_schema = current_schema() AND table_name = $1 AND column_name = 'id'", tableName)
+	if err != nil || !hasId {
+		return err
+	}
+	query, args, err := statementbuilder.Squirrel.Select(goqu.L("setval(pg_get_serial_sequence(?, 'id'), COALESCE(MAX(id), 0) + 1, false)", tableName)).
+		From(tableName).ToSQL()
+	if err != nil {
+		return err
+	}
+	_, err = transaction.Exec(query, args...)
+	return err
+}
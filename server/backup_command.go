@@ -0,0 +1,106 @@
+package server
+
+import (
+	"os"
+	"sort"
+	"time"
+
+	"github.com/daptin/daptin/server/backup"
+	"github.com/daptin/daptin/server/database"
+	"github.com/daptin/daptin/server/resource"
+	log "github.com/sirupsen/logrus"
+)
+
+// RunBackupCommand writes a backup of the instance in db to the file at path, for the backup mode of
+// the command line. The backup is encrypted with encryptionKey, or else with the key in the config
+// of the instance when it has one. An empty path names the file after the time of the backup
+func RunBackupCommand(db database.DatabaseConnection, path string, includeFiles bool, encryptionKey string) error {
+	configStore, err := resource.NewConfigStore(db)
+	if err != nil {
+		return err
+	}
+	transaction, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	if encryptionKey == "" {
+		encryptionKey, _ = configStore.GetConfigValueFor(backup.EncryptionKeyConfig, "backend", transaction)
+	}
+	if path == "" {
+		path = backup.FileName(time.Now(), encryptionKey != "")
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		transaction.Rollback()
+		return err
+	}
+	manifest, err := backup.Write(file, transaction, backup.Options{IncludeFiles: includeFiles, EncryptionKey: encryptionKey})
+	transaction.Rollback()
+	closeErr := file.Close()
+	if err != nil {
+		os.Remove(path)
+		return err
+	}
+	if closeErr != nil {
+		return closeErr
+	}
+
+	log.Printf("Backup of %d tables written to [%v]", len(manifest.Tables), path)
+	return nil
+}
+
+// RunRestoreCommand creates the tables of the backup at path in db, then replaces their rows with the
+// rows of the backup, for the restore mode of the command line. The database may be of another type
+// than the one backed up. An encrypted backup is decrypted with encryptionKey
+func RunRestoreCommand(db database.DatabaseConnection, path string, encryptionKey string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader, err := backup.NewReader(file, encryptionKey)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	log.Printf("Restoring the backup of [%v] taken on %v", reader.Manifest.DatabaseType, reader.Manifest.CreatedAt)
+
+	initConfig, errs := LoadConfigFiles()
+	for _, err := range errs {
+		log.Errorf("Failed to load config file: %v", err)
+	}
+	initConfig.Tables = MergeTables(reader.Schema, initConfig.Tables)
+	InitialiseServerResources(&initConfig, db)
+	if _, err = resource.NewConfigStore(db); err != nil {
+		return err
+	}
+
+	transaction, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	result, err := reader.Restore(transaction)
+	if err != nil {
+		transaction.Rollback()
+		return err
+	}
+	if err = transaction.Commit(); err != nil {
+		return err
+	}
+
+	tables := make([]string, 0, len(result.Rows))
+	for tableName := range result.Rows {
+		tables = append(tables, tableName)
+	}
+	sort.Strings(tables)
+	for _, tableName := range tables {
+		log.Printf("Restored %d rows of [%v]", result.Rows[tableName], tableName)
+	}
+	if len(result.Skipped) > 0 {
+		log.Warnf("Tables not in the database were skipped: %v", result.Skipped)
+	}
+	log.Printf("Restore complete, restored %d files", result.Files)
+	return nil
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"github.com/daptin/daptin/server/auth"
 	"github.com/daptin/daptin/server/resource"
+	"github.com/daptin/daptin/server/tenant"
 	"github.com/gin-gonic/gin"
 	log "github.com/sirupsen/logrus"
 )
@@ -27,10 +28,14 @@ func CreateConfigHandler(initConfig *resource.CmsConfig, cruds map[string]*resou
 
 		defer transaction.Commit()
 
-		if !resource.IsAdminWithTransaction(sessionUser, transaction) {
+		// on the hostnames of a tenant the values of the tenant are read and written, by the
+		// administrators and the administrator group of the tenant
+		requestTenant := tenant.FromContext(c.Request.Context())
+		if !resource.IsAdminWithTransaction(sessionUser, transaction) && !requestTenant.IsAdmin(sessionUser) {
 			c.AbortWithError(403, fmt.Errorf("unauthorized"))
 			return
 		}
+		configStore := configStore.ForTenant(requestTenant)
 		log.Tracef("User [%v] has access to config", sessionUser.UserReferenceId)
 
 		if c.Request.Method == "GET" {
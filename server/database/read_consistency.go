@@ -0,0 +1,36 @@
+package database
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// ReadConsistencyContextKey is the request context key under which the http layer places the
+// *ReadConsistency of the request
+const ReadConsistencyContextKey = "read_consistency"
+
+// ReadConsistency decides if the reads of a request can go to a replica. A request asking for strong
+// consistency, and every read after a write of the request, reads from the primary.
+type ReadConsistency struct {
+	strong bool
+	wrote  atomic.Bool
+}
+
+// WithReadConsistency returns a context whose reads go to a replica, unless strong is set
+func WithReadConsistency(ctx context.Context, strong bool) context.Context {
+	return context.WithValue(ctx, ReadConsistencyContextKey, &ReadConsistency{strong: strong})
+}
+
+// MarkWrite pins the remaining reads of the request to the primary
+func MarkWrite(ctx context.Context) {
+	if consistency, ok := ctx.Value(ReadConsistencyContextKey).(*ReadConsistency); ok {
+		consistency.wrote.Store(true)
+	}
+}
+
+// ReadFromPrimary is true for a context which has to read from the primary. Contexts not coming from
+// the http layer, like the ones of actions and background jobs, always do.
+func ReadFromPrimary(ctx context.Context) bool {
+	consistency, ok := ctx.Value(ReadConsistencyContextKey).(*ReadConsistency)
+	return !ok || consistency.strong || consistency.wrote.Load()
+}
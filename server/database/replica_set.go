@@ -0,0 +1,153 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	log "github.com/sirupsen/logrus"
+)
+
+// ReplicaSet is the primary connection along with its read replicas. Every method of the
+// DatabaseConnection goes to the primary, read only requests take a replica from Reader.
+type ReplicaSet struct {
+	DatabaseConnection
+	replicas []*replica
+	maxLag   time.Duration
+	next     atomic.Uint64
+}
+
+type replica struct {
+	name    string
+	db      *sqlx.DB
+	healthy atomic.Bool
+	lag     atomic.Int64
+}
+
+// NewReplicaSet returns the replica set over the primary. Replicas are only used after a health
+// check found them reachable and at most maxLag behind the primary.
+func NewReplicaSet(primary DatabaseConnection, replicas []*sqlx.DB, maxLag time.Duration) *ReplicaSet {
+	replicaSet := &ReplicaSet{
+		DatabaseConnection: primary,
+		maxLag:             maxLag,
+	}
+	for i, db := range replicas {
+		replicaSet.replicas = append(replicaSet.replicas, &replica{
+			name: fmt.Sprintf("replica-%d", i+1),
+			db:   db,
+		})
+	}
+	return replicaSet
+}
+
+// Primary is the connection which takes the writes
+func (replicaSet *ReplicaSet) Primary() DatabaseConnection {
+	return replicaSet.DatabaseConnection
+}
+
+// Reader returns the next healthy replica, or the primary when none of the replicas is usable
+func (replicaSet *ReplicaSet) Reader() DatabaseConnection {
+	count := uint64(len(replicaSet.replicas))
+	start := replicaSet.next.Add(1)
+	for i := uint64(0); i < count; i++ {
+		candidate := replicaSet.replicas[(start+i)%count]
+		if candidate.healthy.Load() && time.Duration(candidate.lag.Load()) <= replicaSet.maxLag {
+			return candidate.db
+		}
+	}
+	return replicaSet.DatabaseConnection
+}
+
+// MarkDown takes a replica which failed a request out of rotation until the next health check
+func (replicaSet *ReplicaSet) MarkDown(connection DatabaseConnection, err error) {
+	for _, candidate := range replicaSet.replicas {
+		if DatabaseConnection(candidate.db) == connection && candidate.healthy.Swap(false) {
+			log.Warnf("Read replica [%s] is down, reading from the primary: %v", candidate.name, err)
+		}
+	}
+}
+
+// CheckReplicas checks every replica is reachable and measures how far it is behind the primary
+func (replicaSet *ReplicaSet) CheckReplicas() {
+	for _, candidate := range replicaSet.replicas {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		lag, err := replicaLag(ctx, candidate.db)
+		cancel()
+
+		wasUsable := candidate.healthy.Load() && time.Duration(candidate.lag.Load()) <= replicaSet.maxLag
+		candidate.healthy.Store(err == nil)
+		candidate.lag.Store(int64(lag))
+		isUsable := err == nil && lag <= replicaSet.maxLag
+
+		switch {
+		case err != nil && wasUsable:
+			log.Warnf("Read replica [%s] failed its health check: %v", candidate.name, err)
+		case err == nil && !isUsable && wasUsable:
+			log.Warnf("Read replica [%s] is %v behind the primary, reading from the primary", candidate.name, lag)
+		case isUsable && !wasUsable:
+			log.Infof("Read replica [%s] is available, %v behind the primary", candidate.name, lag)
+		}
+	}
+}
+
+// StartHealthChecks checks the replicas once and then again every interval
+func (replicaSet *ReplicaSet) StartHealthChecks(interval time.Duration) {
+	replicaSet.CheckReplicas()
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			replicaSet.CheckReplicas()
+		}
+	}()
+}
+
+// replicaLag pings the replica and returns its replication delay. A database which is not
+// replicating from anywhere has no delay.
+func replicaLag(ctx context.Context, db *sqlx.DB) (time.Duration, error) {
+	err := db.PingContext(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	switch db.DriverName() {
+	case "postgres":
+		var seconds float64
+		err = db.GetContext(ctx, &seconds, `SELECT CASE
+			WHEN NOT pg_is_in_recovery() OR pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() THEN 0
+			ELSE COALESCE(EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()), 0) END`)
+		return time.Duration(seconds * float64(time.Second)), err
+	case "mysql":
+		rows, err := db.QueryxContext(ctx, "SHOW REPLICA STATUS")
+		if err != nil {
+			// before 8.0.22
+			rows, err = db.QueryxContext(ctx, "SHOW SLAVE STATUS")
+		}
+		if err != nil {
+			return 0, err
+		}
+		defer rows.Close()
+		if !rows.Next() {
+			return 0, rows.Err()
+		}
+		status := make(map[string]interface{})
+		err = rows.MapScan(status)
+		if err != nil {
+			return 0, err
+		}
+		seconds, ok := status["Seconds_Behind_Source"]
+		if !ok {
+			seconds = status["Seconds_Behind_Master"]
+		}
+		if seconds == nil {
+			return 0, fmt.Errorf("replication is not running")
+		}
+		secondsInt, err := strconv.ParseInt(fmt.Sprintf("%s", seconds), 10, 64)
+		return time.Duration(secondsInt) * time.Second, err
+	default:
+		return 0, nil
+	}
+}
@@ -0,0 +1,83 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestReadConsistency(t *testing.T) {
+	if !ReadFromPrimary(context.Background()) {
+		t.Errorf("a context without read consistency should read from the primary")
+	}
+
+	ctx := WithReadConsistency(context.Background(), false)
+	if ReadFromPrimary(ctx) {
+		t.Errorf("a request should read from a replica")
+	}
+	MarkWrite(ctx)
+	if !ReadFromPrimary(ctx) {
+		t.Errorf("reads after a write should go to the primary")
+	}
+
+	if !ReadFromPrimary(WithReadConsistency(context.Background(), true)) {
+		t.Errorf("a request asking for strong consistency should read from the primary")
+	}
+}
+
+func openTestDb(t *testing.T) *sqlx.DB {
+	t.Helper()
+	db, err := sqlx.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	return db
+}
+
+func TestReplicaSetReader(t *testing.T) {
+	primary := openTestDb(t)
+	defer primary.Close()
+	first, second := openTestDb(t), openTestDb(t)
+	defer first.Close()
+
+	replicaSet := NewReplicaSet(primary, []*sqlx.DB{first, second}, time.Second)
+	if replicaSet.Reader() != DatabaseConnection(primary) {
+		t.Errorf("replicas should not be read from before their first health check")
+	}
+
+	replicaSet.CheckReplicas()
+	readers := map[DatabaseConnection]bool{}
+	for i := 0; i < 4; i++ {
+		readers[replicaSet.Reader()] = true
+	}
+	if len(readers) != 2 || readers[primary] {
+		t.Errorf("reads should be spread over the replicas, got %v", readers)
+	}
+
+	second.Close()
+	replicaSet.CheckReplicas()
+	for i := 0; i < 4; i++ {
+		if reader := replicaSet.Reader(); reader != DatabaseConnection(first) {
+			t.Fatalf("a replica failing its health check should not be read from, got %v", reader)
+		}
+	}
+
+	replicaSet.replicas[0].lag.Store(int64(2 * time.Second))
+	if replicaSet.Reader() != DatabaseConnection(primary) {
+		t.Errorf("a replica lagging behind should not be read from")
+	}
+
+	replicaSet.CheckReplicas()
+	replicaSet.MarkDown(first, errors.New("connection refused"))
+	if replicaSet.Reader() != DatabaseConnection(primary) {
+		t.Errorf("a replica marked down should not be read from")
+	}
+	replicaSet.CheckReplicas()
+	if replicaSet.Reader() != DatabaseConnection(first) {
+		t.Errorf("a replica should be read from again after it passes a health check")
+	}
+}
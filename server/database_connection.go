@@ -1,6 +1,7 @@
 package server
 
 import (
+	"github.com/daptin/daptin/server/database"
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/jmoiron/sqlx"
 	_ "github.com/lib/pq"
@@ -99,6 +100,24 @@ func GetDbConnection(dbType string, connectionString string) (*sqlx.DB, error) {
 	return db, e
 }
 
+// GetReplicaSet opens the read replicas, their connection strings are separated by ;
+func GetReplicaSet(dbType string, primary database.DatabaseConnection, replicaConnectionStrings string, maxLag time.Duration) (*database.ReplicaSet, error) {
+	replicas := make([]*sqlx.DB, 0)
+	for _, connectionString := range strings.Split(replicaConnectionStrings, ";") {
+		connectionString = strings.TrimSpace(connectionString)
+		if connectionString == "" {
+			continue
+		}
+		replica, err := GetDbConnection(dbType, connectionString)
+		if err != nil {
+			return nil, err
+		}
+		replicas = append(replicas, replica)
+	}
+	log.Infof("Reading from [%d] read replicas at most [%v] behind the primary", len(replicas), maxLag)
+	return database.NewReplicaSet(primary, replicas, maxLag), nil
+}
+
 //
 //func GetCasbinAdapter(dbType string, connectionString string) (*xormadapter.Adapter) {
 //	a := xormadapter.NewAdapter(dbType, connectionString) // Your driver and data source.
@@ -0,0 +1,559 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/artpar/api2go/v2"
+	"github.com/artpar/api2go/v2/jsonapi"
+	daptinid "github.com/daptin/daptin/server/id"
+	"github.com/daptin/daptin/server/resource"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	log "github.com/sirupsen/logrus"
+)
+
+// AtomicOperationsExtension is the JSON:API extension served at /api/operations
+const AtomicOperationsExtension = "https://jsonapi.org/ext/atomic"
+
+const atomicOperationsContentType = `application/vnd.api+json; ext="` + AtomicOperationsExtension + `"`
+
+// maxAtomicOperations keeps a single request from holding a transaction open for too long
+const maxAtomicOperations = 1000
+
+type atomicOperationsDocument struct {
+	Operations []atomicOperation `json:"atomic:operations"`
+}
+
+type atomicOperation struct {
+	Op   string      `json:"op"`
+	Ref  *atomicRef  `json:"ref,omitempty"`
+	Href string      `json:"href,omitempty"`
+	Data interface{} `json:"data"`
+}
+
+type atomicRef struct {
+	Type         string `json:"type"`
+	Id           string `json:"id,omitempty"`
+	Lid          string `json:"lid,omitempty"`
+	Relationship string `json:"relationship,omitempty"`
+}
+
+// atomicOperationError is reported with a pointer to the operation which failed
+type atomicOperationError struct {
+	index  int
+	status int
+	err    error
+}
+
+func (e *atomicOperationError) Error() string {
+	return fmt.Sprintf("operation %d: %v", e.index, e.err)
+}
+
+func newAtomicOperationError(index int, err error) *atomicOperationError {
+	status := http.StatusBadRequest
+	var httpErr api2go.HTTPError
+	if errors.As(err, &httpErr) {
+		status = httpErr.Status()
+	}
+	return &atomicOperationError{index: index, status: status, err: err}
+}
+
+// atomicLocalIds maps the lid of every row created in the request to the reference id it was given
+type atomicLocalIds map[string]atomicRef
+
+func (l atomicLocalIds) resolve(identity map[string]interface{}) error {
+	lid, ok := identity["lid"].(string)
+	if !ok || lid == "" {
+		return nil
+	}
+	created, ok := l[lid]
+	if !ok {
+		return fmt.Errorf("unknown lid [%v], a lid must be introduced by an earlier add operation", lid)
+	}
+	if typeName, ok := identity["type"].(string); ok && typeName != created.Type {
+		return fmt.Errorf("lid [%v] is a [%v], not a [%v]", lid, created.Type, typeName)
+	}
+	delete(identity, "lid")
+	identity["id"] = created.Id
+	return nil
+}
+
+// resolveRelationships replaces local ids in the relationships of a resource object
+func (l atomicLocalIds) resolveRelationships(resourceObject map[string]interface{}) error {
+	relationships, ok := resourceObject["relationships"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	for name, relationship := range relationships {
+		relationshipMap, ok := relationship.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("relationship [%v] must be an object", name)
+		}
+		if err := l.resolveLinkage(relationshipMap["data"]); err != nil {
+			return fmt.Errorf("relationship [%v]: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// resolveLinkage replaces local ids in a resource linkage, which is null, an identifier or a list of them
+func (l atomicLocalIds) resolveLinkage(linkage interface{}) error {
+	switch value := linkage.(type) {
+	case nil:
+		return nil
+	case map[string]interface{}:
+		return l.resolve(value)
+	case []interface{}:
+		for _, item := range value {
+			identity, ok := item.(map[string]interface{})
+			if !ok {
+				return errors.New("resource identifier must be an object")
+			}
+			if err := l.resolve(identity); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return errors.New("invalid resource linkage")
+	}
+}
+
+func (l atomicLocalIds) resolveRef(ref *atomicRef) error {
+	if ref.Lid == "" {
+		return nil
+	}
+	created, ok := l[ref.Lid]
+	if !ok {
+		return fmt.Errorf("unknown lid [%v], a lid must be introduced by an earlier add operation", ref.Lid)
+	}
+	if ref.Type != created.Type {
+		return fmt.Errorf("lid [%v] is a [%v], not a [%v]", ref.Lid, created.Type, ref.Type)
+	}
+	ref.Id = created.Id
+	ref.Lid = ""
+	return nil
+}
+
+// AtomicOperationsHandler runs the add, update and remove operations of a JSON:API atomic request in
+// one transaction through the same create, update and delete paths and middlewares as /api/<entity>.
+// Any failing operation rolls back the whole request.
+func AtomicOperationsHandler(cruds map[string]*resource.DbResource) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			writeAtomicError(c, &atomicOperationError{index: -1, status: http.StatusBadRequest, err: err})
+			return
+		}
+		var document atomicOperationsDocument
+		if err = json.Unmarshal(body, &document); err != nil {
+			writeAtomicError(c, &atomicOperationError{index: -1, status: http.StatusBadRequest, err: err})
+			return
+		}
+		if len(document.Operations) == 0 {
+			writeAtomicError(c, &atomicOperationError{index: -1, status: http.StatusBadRequest, err: errors.New("atomic:operations must not be empty")})
+			return
+		}
+		if len(document.Operations) > maxAtomicOperations {
+			writeAtomicError(c, &atomicOperationError{index: -1, status: http.StatusRequestEntityTooLarge,
+				err: fmt.Errorf("at most %d operations are allowed in one request", maxAtomicOperations)})
+			return
+		}
+
+		if operationError := validateAtomicOperations(cruds, document.Operations); operationError != nil {
+			writeAtomicError(c, operationError)
+			return
+		}
+
+		transaction, err := cruds["world"].Connection().Beginx()
+		if err != nil {
+			resource.CheckErr(err, "Failed to begin transaction for atomic operations")
+			writeAtomicError(c, &atomicOperationError{index: -1, status: http.StatusServiceUnavailable, err: err})
+			return
+		}
+
+		localIds := make(atomicLocalIds)
+		results := make([]interface{}, 0, len(document.Operations))
+		for index, operation := range document.Operations {
+			result, err := runAtomicOperation(cruds, c.Request, operation, localIds, transaction)
+			if err != nil {
+				rollbackErr := transaction.Rollback()
+				resource.CheckErr(rollbackErr, "Failed to rollback atomic operations")
+				log.Warnf("Atomic operation %d [%v] failed: %v", index, operation.Op, err)
+				writeAtomicError(c, newAtomicOperationError(index, err))
+				return
+			}
+			results = append(results, result)
+		}
+
+		if err = transaction.Commit(); err != nil {
+			writeAtomicError(c, &atomicOperationError{index: -1, status: http.StatusInternalServerError, err: err})
+			return
+		}
+
+		c.Header("Content-Type", atomicOperationsContentType)
+		c.JSON(http.StatusOK, gin.H{
+			"atomic:results": results,
+		})
+	}
+}
+
+// atomicTarget is the row an operation works on, taken from ref or else from the resource object in data
+func atomicTarget(operation atomicOperation) *atomicRef {
+	if operation.Ref != nil {
+		return operation.Ref
+	}
+	resourceObject, ok := operation.Data.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	typeName, _ := resourceObject["type"].(string)
+	id, _ := resourceObject["id"].(string)
+	lid, _ := resourceObject["lid"].(string)
+	return &atomicRef{Type: typeName, Id: id, Lid: lid}
+}
+
+// validateAtomicOperations checks the whole request before anything is written, including that every
+// lid is introduced by an add operation before it is used
+func validateAtomicOperations(cruds map[string]*resource.DbResource, operations []atomicOperation) *atomicOperationError {
+	declaredLids := make(map[string]string)
+	usesLid := func(typeName string, lid string) error {
+		if lid == "" {
+			return nil
+		}
+		declaredType, ok := declaredLids[lid]
+		if !ok {
+			return fmt.Errorf("unknown lid [%v], a lid must be introduced by an earlier add operation", lid)
+		}
+		if typeName != "" && typeName != declaredType {
+			return fmt.Errorf("lid [%v] is a [%v], not a [%v]", lid, declaredType, typeName)
+		}
+		return nil
+	}
+	linkageLids := func(linkage interface{}) error {
+		identities := make([]interface{}, 0)
+		switch value := linkage.(type) {
+		case map[string]interface{}:
+			identities = append(identities, value)
+		case []interface{}:
+			identities = value
+		}
+		for _, item := range identities {
+			identity, ok := item.(map[string]interface{})
+			if !ok {
+				return errors.New("resource identifier must be an object")
+			}
+			typeName, _ := identity["type"].(string)
+			lid, _ := identity["lid"].(string)
+			if err := usesLid(typeName, lid); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for index, operation := range operations {
+		invalid := func(err error) *atomicOperationError {
+			return &atomicOperationError{index: index, status: http.StatusBadRequest, err: err}
+		}
+		if operation.Href != "" {
+			return invalid(errors.New("href is not supported, use ref to target a resource"))
+		}
+		if operation.Op != "add" && operation.Op != "update" && operation.Op != "remove" {
+			return invalid(fmt.Errorf("unknown op [%v], expected add, update or remove", operation.Op))
+		}
+		target := atomicTarget(operation)
+		if target == nil || target.Type == "" {
+			return invalid(errors.New("operation needs a ref or data with a type"))
+		}
+		if _, ok := cruds[target.Type]; !ok {
+			return invalid(fmt.Errorf("unknown type [%v]", target.Type))
+		}
+
+		resourceObject, _ := operation.Data.(map[string]interface{})
+		if operation.Op != "remove" && target.Relationship == "" && resourceObject == nil {
+			return invalid(fmt.Errorf("%v needs a resource object in data", operation.Op))
+		}
+		if operation.Op == "add" && target.Relationship == "" {
+			if target.Lid != "" {
+				if _, exists := declaredLids[target.Lid]; exists {
+					return invalid(fmt.Errorf("lid [%v] is used more than once", target.Lid))
+				}
+			}
+		} else if err := usesLid(target.Type, target.Lid); err != nil {
+			return invalid(err)
+		}
+
+		if target.Relationship != "" {
+			if err := linkageLids(operation.Data); err != nil {
+				return invalid(err)
+			}
+		} else if resourceObject != nil {
+			if operation.Op == "update" {
+				dataLid, _ := resourceObject["lid"].(string)
+				if err := usesLid(target.Type, dataLid); err != nil {
+					return invalid(err)
+				}
+			}
+			relationships, _ := resourceObject["relationships"].(map[string]interface{})
+			for name, relationship := range relationships {
+				relationshipMap, ok := relationship.(map[string]interface{})
+				if !ok {
+					return invalid(fmt.Errorf("relationship [%v] must be an object", name))
+				}
+				if err := linkageLids(relationshipMap["data"]); err != nil {
+					return invalid(fmt.Errorf("relationship [%v]: %v", name, err))
+				}
+			}
+		}
+
+		if operation.Op == "add" && target.Relationship == "" && target.Lid != "" {
+			declaredLids[target.Lid] = target.Type
+		}
+	}
+	return nil
+}
+
+func runAtomicOperation(cruds map[string]*resource.DbResource, httpRequest *http.Request, operation atomicOperation,
+	localIds atomicLocalIds, transaction *sqlx.Tx) (interface{}, error) {
+
+	resourceObject, _ := operation.Data.(map[string]interface{})
+	target := atomicTarget(operation)
+	dbResource := cruds[target.Type]
+
+	if target.Relationship != "" {
+		if err := localIds.resolveRef(target); err != nil {
+			return nil, err
+		}
+		return runAtomicRelationshipOperation(dbResource, httpRequest, operation, *target, localIds, transaction)
+	}
+
+	switch operation.Op {
+	case "add":
+		if err := localIds.resolveRelationships(resourceObject); err != nil {
+			return nil, err
+		}
+		lid, _ := resourceObject["lid"].(string)
+		delete(resourceObject, "lid")
+
+		var model api2go.Api2GoModel
+		dbResource.InitializeObject(&model)
+		if err := unmarshalAtomicResourceObject(resourceObject, &model); err != nil {
+			return nil, err
+		}
+		created, err := dbResource.CreateWithTransaction(model, atomicApiRequest(httpRequest, "POST", target.Type), transaction)
+		if err != nil {
+			return nil, err
+		}
+		result, ok := created.Result().(api2go.Api2GoModel)
+		if !ok {
+			return nil, errors.New("created row is not readable")
+		}
+		if lid != "" {
+			localIds[lid] = atomicRef{Type: target.Type, Id: result.GetID()}
+		}
+		return atomicResult(result)
+
+	case "update":
+		if err := localIds.resolveRef(target); err != nil {
+			return nil, err
+		}
+		if err := localIds.resolve(resourceObject); err != nil {
+			return nil, err
+		}
+		if err := localIds.resolveRelationships(resourceObject); err != nil {
+			return nil, err
+		}
+		if id, _ := resourceObject["id"].(string); id == "" {
+			resourceObject["id"] = target.Id
+		} else if id != target.Id {
+			return nil, api2go.NewHTTPError(nil, "id in data does not match the id in ref", http.StatusConflict)
+		}
+
+		request := atomicApiRequest(httpRequest, "PATCH", target.Type)
+		model, err := findAtomicTarget(dbResource, *target, request, transaction)
+		if err != nil {
+			return nil, err
+		}
+		if err = unmarshalAtomicResourceObject(resourceObject, &model); err != nil {
+			return nil, err
+		}
+		updated, err := dbResource.UpdateWithTransaction(model, request, transaction)
+		if err != nil {
+			return nil, err
+		}
+		result, ok := updated.Result().(api2go.Api2GoModel)
+		if !ok {
+			return map[string]interface{}{}, nil
+		}
+		return atomicResult(result)
+
+	case "remove":
+		if err := localIds.resolveRef(target); err != nil {
+			return nil, err
+		}
+		referenceId, err := uuid.Parse(target.Id)
+		if err != nil {
+			return nil, fmt.Errorf("invalid id [%v]", target.Id)
+		}
+		_, err = dbResource.DeleteWithTransaction(daptinid.DaptinReferenceId(referenceId),
+			atomicApiRequest(httpRequest, "DELETE", target.Type), transaction)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{}, nil
+	}
+
+	return nil, fmt.Errorf("unknown op [%v], expected add, update or remove", operation.Op)
+}
+
+// runAtomicRelationshipOperation changes a relationship of a row the same way the
+// /api/<entity>/<id>/relationships/<name> endpoints do
+func runAtomicRelationshipOperation(dbResource *resource.DbResource, httpRequest *http.Request, operation atomicOperation,
+	target atomicRef, localIds atomicLocalIds, transaction *sqlx.Tx) (interface{}, error) {
+
+	if err := localIds.resolveLinkage(operation.Data); err != nil {
+		return nil, err
+	}
+
+	request := atomicApiRequest(httpRequest, "PATCH", target.Type)
+	model, err := findAtomicTarget(dbResource, target, request, transaction)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]map[string]interface{}, 0)
+	idStrings := make([]string, 0)
+	if linkage, ok := operation.Data.([]interface{}); ok {
+		for _, item := range linkage {
+			id, _ := item.(map[string]interface{})["id"].(string)
+			if id == "" {
+				return nil, errors.New("resource identifier needs an id or lid")
+			}
+			ids = append(ids, map[string]interface{}{"id": id})
+			idStrings = append(idStrings, id)
+		}
+	}
+
+	switch operation.Op {
+	case "update":
+		switch linkage := operation.Data.(type) {
+		case nil:
+			model.SetAttributes(map[string]interface{}{target.Relationship: nil})
+		case map[string]interface{}:
+			id, _ := linkage["id"].(string)
+			if id == "" {
+				return nil, errors.New("resource identifier needs an id or lid")
+			}
+			err = model.SetToOneReferenceID(target.Relationship, id)
+		default:
+			err = model.SetToManyReferenceIDs(target.Relationship, ids)
+		}
+	case "add":
+		if _, ok := operation.Data.([]interface{}); !ok {
+			return nil, errors.New("add to a relationship needs a list of resource identifiers")
+		}
+		err = model.SetToManyReferenceIDs(target.Relationship, ids)
+	case "remove":
+		if _, ok := operation.Data.([]interface{}); !ok {
+			return nil, errors.New("remove from a relationship needs a list of resource identifiers")
+		}
+		err = model.DeleteToManyIDs(target.Relationship, idStrings)
+	default:
+		return nil, fmt.Errorf("unknown op [%v], expected add, update or remove", operation.Op)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = dbResource.UpdateWithTransaction(model, request, transaction)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{}, nil
+}
+
+func findAtomicTarget(dbResource *resource.DbResource, target atomicRef, request api2go.Request, transaction *sqlx.Tx) (api2go.Api2GoModel, error) {
+	referenceId, err := uuid.Parse(target.Id)
+	if err != nil {
+		return api2go.Api2GoModel{}, fmt.Errorf("invalid id [%v]", target.Id)
+	}
+	found, err := dbResource.FindOneWithTransaction(daptinid.DaptinReferenceId(referenceId), request, transaction)
+	if err != nil {
+		return api2go.Api2GoModel{}, err
+	}
+	model, ok := found.Result().(api2go.Api2GoModel)
+	if !ok {
+		return api2go.Api2GoModel{}, fmt.Errorf("[%v][%v] not found", target.Type, target.Id)
+	}
+	return model, nil
+}
+
+func unmarshalAtomicResourceObject(resourceObject map[string]interface{}, model *api2go.Api2GoModel) error {
+	document, err := json.Marshal(map[string]interface{}{
+		"data": resourceObject,
+	})
+	if err != nil {
+		return err
+	}
+	err = jsonapi.Unmarshal(document, model)
+	if err != nil {
+		return api2go.NewHTTPError(err, err.Error(), http.StatusNotAcceptable)
+	}
+	return nil
+}
+
+func atomicResult(model api2go.Api2GoModel) (interface{}, error) {
+	document, err := jsonapi.MarshalToStruct(model, nil)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"data": document.Data.DataObject,
+	}, nil
+}
+
+// atomicApiRequest is the request an operation would have been as a call to /api/<entity>
+func atomicApiRequest(httpRequest *http.Request, method string, typeName string) api2go.Request {
+	operationUrl, _ := url.Parse("/api/" + typeName)
+	plainRequest := &http.Request{
+		Method: method,
+		URL:    operationUrl,
+		Header: httpRequest.Header,
+	}
+	return api2go.Request{
+		PlainRequest: plainRequest.WithContext(httpRequest.Context()),
+		QueryParams:  map[string][]string{},
+		Header:       httpRequest.Header,
+	}
+}
+
+func writeAtomicError(c *gin.Context, operationError *atomicOperationError) {
+	errorObject := api2go.Error{
+		Status: fmt.Sprintf("%d", operationError.status),
+		Title:  http.StatusText(operationError.status),
+		Detail: operationError.err.Error(),
+	}
+	if operationError.index >= 0 {
+		errorObject.Source = &api2go.ErrorSource{
+			Pointer: fmt.Sprintf("/atomic:operations/%d", operationError.index),
+		}
+	}
+	c.Header("Content-Type", atomicOperationsContentType)
+	c.AbortWithStatusJSON(operationError.status, gin.H{
+		"errors": []api2go.Error{errorObject},
+	})
+}
+
+func InitializeAtomicOperationsResource(cruds map[string]*resource.DbResource, defaultRouter *gin.Engine) {
+	if _, ok := cruds["operations"]; ok {
+		log.Warnf("A table named [operations] is served at /api/operations, atomic operations are not available")
+		return
+	}
+	defaultRouter.POST("/api/operations", AtomicOperationsHandler(cruds))
+}
@@ -0,0 +1,212 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/artpar/api2go/v2"
+	"github.com/buraksezer/olric"
+	olricConfig "github.com/buraksezer/olric/config"
+	"github.com/daptin/daptin/server/auth"
+	daptinid "github.com/daptin/daptin/server/id"
+	"github.com/daptin/daptin/server/resource"
+	"github.com/daptin/daptin/server/table_info"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestAtomicLocalIdsResolveRelationships(t *testing.T) {
+	localIds := atomicLocalIds{
+		"order-1": {Type: "order", Id: "0191f0a4-0000-7000-8000-000000000001"},
+		"item-1":  {Type: "line_item", Id: "0191f0a4-0000-7000-8000-000000000002"},
+	}
+
+	resourceObject := map[string]interface{}{
+		"type": "shipment",
+		"relationships": map[string]interface{}{
+			"order_id": map[string]interface{}{
+				"data": map[string]interface{}{"type": "order", "lid": "order-1"},
+			},
+			"line_item_id": map[string]interface{}{
+				"data": []interface{}{
+					map[string]interface{}{"type": "line_item", "lid": "item-1"},
+					map[string]interface{}{"type": "line_item", "id": "0191f0a4-0000-7000-8000-000000000003"},
+				},
+			},
+		},
+	}
+	if err := localIds.resolveRelationships(resourceObject); err != nil {
+		t.Fatal(err)
+	}
+	relationships := resourceObject["relationships"].(map[string]interface{})
+	order := relationships["order_id"].(map[string]interface{})["data"].(map[string]interface{})
+	if order["id"] != "0191f0a4-0000-7000-8000-000000000001" || order["lid"] != nil {
+		t.Fatalf("to-one lid not resolved: %v", order)
+	}
+	items := relationships["line_item_id"].(map[string]interface{})["data"].([]interface{})
+	if items[0].(map[string]interface{})["id"] != "0191f0a4-0000-7000-8000-000000000002" {
+		t.Fatalf("to-many lid not resolved: %v", items)
+	}
+
+	ref := &atomicRef{Type: "line_item", Lid: "order-1"}
+	if err := localIds.resolveRef(ref); err == nil {
+		t.Fatal("lid of an order resolved as a line item")
+	}
+	if err := localIds.resolveLinkage(map[string]interface{}{"type": "order", "lid": "missing"}); err == nil {
+		t.Fatal("unknown lid resolved")
+	}
+}
+
+func TestAtomicOperationsHandlerRejectsInvalidRequests(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	// invalid requests are rejected before a transaction is started
+	InitializeAtomicOperationsResource(map[string]*resource.DbResource{"world": nil, "order": nil}, router)
+
+	for body, wantPointer := range map[string]string{
+		`{"atomic:operations": []}`: "",
+		`{"atomic:operations": [{"op": "add", "data": {"type": "no_such_table", "attributes": {}}}]}`:                                           "/atomic:operations/0",
+		`{"atomic:operations": [{"op": "add", "href": "/api/order", "data": {"type": "order", "attributes": {}}}]}`:                             "/atomic:operations/0",
+		`{"atomic:operations": [{"op": "upsert", "data": {"type": "order", "attributes": {}}}]}`:                                                "/atomic:operations/0",
+		`{"atomic:operations": [{"op": "add", "data": {"type": "order", "lid": "a"}}, {"op": "remove", "ref": {"type": "order", "lid": "b"}}]}`: "/atomic:operations/1",
+		`{"atomic:operations": [{"op": "add", "data": {"type": "order", "lid": "a"}}, {"op": "add", "data": {"type": "order", "lid": "a"}}]}`:   "/atomic:operations/1",
+		`{"atomic:operations": [{"op": "add", "data": {"type": "order", "lid": "a"}}, {"op": "add", "data": {"type": "world",
+			"relationships": {"order_id": {"data": {"type": "world", "lid": "a"}}}}}]}`: "/atomic:operations/1",
+	} {
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodPost, "/api/operations", strings.NewReader(body))
+		request.Header.Set("Content-Type", atomicOperationsContentType)
+		router.ServeHTTP(recorder, request)
+
+		if recorder.Code != http.StatusBadRequest {
+			t.Fatalf("%s: status %d, want 400", body, recorder.Code)
+		}
+		if !strings.Contains(recorder.Header().Get("Content-Type"), AtomicOperationsExtension) {
+			t.Fatalf("%s: content type %q", body, recorder.Header().Get("Content-Type"))
+		}
+		if wantPointer != "" && !strings.Contains(recorder.Body.String(), `"pointer":"`+wantPointer+`"`) {
+			t.Fatalf("%s: error does not point at the operation: %s", body, recorder.Body.String())
+		}
+	}
+}
+
+// failingAfterInterceptor fails the after phase of writes once fail is set
+type failingAfterInterceptor struct {
+	fail bool
+}
+
+func (f *failingAfterInterceptor) String() string {
+	return "failing_after"
+}
+
+func (f *failingAfterInterceptor) InterceptBefore(dr *resource.DbResource, req *api2go.Request, objects []map[string]interface{}, transaction *sqlx.Tx) ([]map[string]interface{}, error) {
+	return objects, nil
+}
+
+func (f *failingAfterInterceptor) InterceptAfter(dr *resource.DbResource, req *api2go.Request, results []map[string]interface{}, transaction *sqlx.Tx) ([]map[string]interface{}, error) {
+	if f.fail {
+		return nil, errors.New("after interceptor failed")
+	}
+	return results, nil
+}
+
+func TestAtomicOperationsRollBackWhenAnAfterInterceptorFails(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db, err := sqlx.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	noteInfo := table_info.TableInfo{
+		TableName:         "note",
+		Columns:           append([]api2go.ColumnInfo{{Name: "title", ColumnName: "title", DataType: "varchar(100)", ColumnType: "label"}}, resource.StandardColumns...),
+		DefaultPermission: auth.DEFAULT_PERMISSION,
+	}
+	adminGroupRef := uuid.New()
+	db.MustExec(`create table usergroup (id integer primary key, name text, reference_id blob, permission integer)`)
+	db.MustExec(`insert into usergroup (id, name, reference_id, permission) values (2, 'administrators', ?, ?)`, adminGroupRef[:], int64(auth.DEFAULT_PERMISSION))
+	db.MustExec(resource.MakeCreateTableQuery(&noteInfo, "sqlite3"))
+
+	olricDb, _ := olric.New(olricConfig.New("local"))
+	interceptor := &failingAfterInterceptor{}
+	middlewares := &resource.MiddlewareSet{
+		AfterCreate: []resource.DatabaseRequestInterceptor{interceptor},
+		AfterUpdate: []resource.DatabaseRequestInterceptor{interceptor},
+	}
+	cruds := make(map[string]*resource.DbResource)
+	noteCrud, err := resource.NewDbResource(api2go.NewApi2GoModel("note", noteInfo.Columns, int64(auth.DEFAULT_PERMISSION), nil),
+		db, middlewares, cruds, nil, olricDb.NewEmbeddedClient(), noteInfo)
+	if err != nil {
+		t.Fatalf("create note resource: %v", err)
+	}
+	cruds["note"] = noteCrud
+	cruds["world"] = noteCrud
+	previousUserAccount := resource.CRUD_MAP[resource.USER_ACCOUNT_TABLE_NAME]
+	resource.CRUD_MAP[resource.USER_ACCOUNT_TABLE_NAME] = noteCrud
+	t.Cleanup(func() {
+		resource.CRUD_MAP[resource.USER_ACCOUNT_TABLE_NAME] = previousUserAccount
+		delete(resource.CRUD_MAP, "note")
+	})
+
+	router := gin.New()
+	InitializeAtomicOperationsResource(cruds, router)
+	sessionUser := &auth.SessionUser{
+		UserReferenceId: daptinid.DaptinReferenceId(uuid.New()),
+		Groups:          auth.GroupPermissionList{{GroupReferenceId: daptinid.DaptinReferenceId(adminGroupRef)}},
+	}
+	serve := func(body string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodPost, "/api/operations", strings.NewReader(body))
+		request.Header.Set("Content-Type", atomicOperationsContentType)
+		router.ServeHTTP(recorder, request.WithContext(context.WithValue(request.Context(), "user", sessionUser)))
+		return recorder
+	}
+	titles := func() []string {
+		t.Helper()
+		rows := make([]string, 0)
+		if err := db.Select(&rows, `select title from note order by id`); err != nil {
+			t.Fatalf("read notes: %v", err)
+		}
+		return rows
+	}
+
+	response := serve(`{"atomic:operations": [{"op": "add", "data": {"type": "note", "lid": "a", "attributes": {"title": "kept"}}}]}`)
+	if response.Code != http.StatusOK {
+		t.Fatalf("add answered with %d: %s", response.Code, response.Body.String())
+	}
+	if rows := titles(); len(rows) != 1 || rows[0] != "kept" {
+		t.Fatalf("expected the added note, got %v", rows)
+	}
+	var referenceId []byte
+	if err = db.QueryRowx(`select reference_id from note`).Scan(&referenceId); err != nil {
+		t.Fatalf("read note reference id: %v", err)
+	}
+	noteId := uuid.Must(uuid.FromBytes(referenceId)).String()
+
+	interceptor.fail = true
+	response = serve(`{"atomic:operations": [
+		{"op": "update", "ref": {"type": "note", "id": "` + noteId + `"}, "data": {"type": "note", "attributes": {"title": "changed"}}},
+		{"op": "add", "data": {"type": "note", "attributes": {"title": "added"}}}]}`)
+	if response.Code < 400 {
+		t.Fatalf("a failing after interceptor answered with %d: %s", response.Code, response.Body.String())
+	}
+	if rows := titles(); len(rows) != 1 || rows[0] != "kept" {
+		t.Fatalf("the failed batch should change nothing, got %v", rows)
+	}
+
+	response = serve(`{"atomic:operations": [{"op": "add", "data": {"type": "note", "attributes": {"title": "added"}}}]}`)
+	if response.Code < 400 {
+		t.Fatalf("a failing AfterCreate answered with %d: %s", response.Code, response.Body.String())
+	}
+	if rows := titles(); len(rows) != 1 {
+		t.Fatalf("the failed add should not be committed, got %v", rows)
+	}
+}
@@ -59,27 +59,13 @@ func InitializeCaldavResources(
 	defaultRouter.Handle("MKCOL", "/caldav/*path", caldavHttpHandler)
 	defaultRouter.Handle("PROPPATCH", "/caldav/*path", caldavHttpHandler)
 
-	defaultRouter.Handle("OPTIONS", "/carddav/*path", caldavHttpHandler)
-	defaultRouter.Handle("HEAD", "/carddav/*path", caldavHttpHandler)
-	defaultRouter.Handle("GET", "/carddav/*path", caldavHttpHandler)
-	defaultRouter.Handle("POST", "/carddav/*path", caldavHttpHandler)
-	defaultRouter.Handle("PUT", "/carddav/*path", caldavHttpHandler)
-	defaultRouter.Handle("PATCH", "/carddav/*path", caldavHttpHandler)
-	defaultRouter.Handle("PROPFIND", "/carddav/*path", caldavHttpHandler)
-	defaultRouter.Handle("DELETE", "/carddav/*path", caldavHttpHandler)
-	defaultRouter.Handle("COPY", "/carddav/*path", caldavHttpHandler)
-	defaultRouter.Handle("MOVE", "/carddav/*path", caldavHttpHandler)
-	defaultRouter.Handle("MKCOL", "/carddav/*path", caldavHttpHandler)
-	defaultRouter.Handle("PROPPATCH", "/carddav/*path", caldavHttpHandler)
+	InitializeCarddavResources(authMiddleware, cruds, defaultRouter)
 
 	// Well-known URIs for service discovery (RFC 6764)
 	// Allows clients to auto-discover CalDAV/CardDAV endpoints
 	defaultRouter.GET("/.well-known/caldav", func(c *gin.Context) {
 		c.Redirect(http.StatusMovedPermanently, "/caldav/")
 	})
-	defaultRouter.GET("/.well-known/carddav", func(c *gin.Context) {
-		c.Redirect(http.StatusMovedPermanently, "/carddav/")
-	})
 
 	logrus.Printf("[CALDAV ENDPOINT] All CalDAV/CardDAV routes registered successfully!")
 	logrus.Printf("[CALDAV ENDPOINT] Routes: MKCOL, OPTIONS, GET, PUT, PROPFIND, DELETE, COPY, MOVE, PROPPATCH")
@@ -0,0 +1,336 @@
+package server
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/daptin/daptin/server/auth"
+	"github.com/daptin/daptin/server/resource"
+	"github.com/emersion/go-vcard"
+	"github.com/emersion/go-webdav"
+	"github.com/emersion/go-webdav/carddav"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	davNamespace            = "DAV:"
+	carddavNamespace        = "urn:ietf:params:xml:ns:carddav"
+	calendarServerNamespace = "http://calendarserver.org/ns/"
+)
+
+// carddavPropRequest is the body of a PROPFIND or sync-collection REPORT, only the requested property names are kept
+type carddavPropRequest struct {
+	XMLName   xml.Name
+	SyncToken string    `xml:"DAV: sync-token"`
+	AllProp   *struct{} `xml:"DAV: allprop"`
+	Prop      *struct {
+		Props []struct {
+			XMLName xml.Name
+		} `xml:",any"`
+	} `xml:"DAV: prop"`
+}
+
+func (r *carddavPropRequest) propNames(defaults []xml.Name) []xml.Name {
+	if r == nil || r.AllProp != nil || r.Prop == nil {
+		return defaults
+	}
+	names := make([]xml.Name, 0, len(r.Prop.Props))
+	for _, prop := range r.Prop.Props {
+		names = append(names, prop.XMLName)
+	}
+	return names
+}
+
+// InitializeCarddavResources sets up the CardDAV endpoint backed by the address_book and contact tables
+// Pattern: like InitializeCaldavResources - the backend is created per request for the authenticated user
+func InitializeCarddavResources(
+	authMiddleware *auth.AuthMiddleware,
+	cruds map[string]*resource.DbResource,
+	defaultRouter *gin.Engine) {
+
+	carddavHttpHandler := func(c *gin.Context) {
+		ok, abort, modifiedRequest := authMiddleware.AuthCheckMiddlewareWithHttp(c.Request, c.Writer, true)
+		if !ok || abort {
+			c.Header("WWW-Authenticate", "Basic realm='carddav'")
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+
+		sessionUser := modifiedRequest.Context().Value("user").(*auth.SessionUser)
+		backend := resource.NewCarddavBackend(cruds, sessionUser)
+
+		principalPath, _ := backend.CurrentUserPrincipal(modifiedRequest.Context())
+		requestPath := modifiedRequest.URL.Path
+		cleanPath := path.Clean(requestPath)
+		if cleanPath == "/.well-known/carddav" || cleanPath == resource.CarddavPrefix {
+			http.Redirect(c.Writer, modifiedRequest, principalPath, http.StatusPermanentRedirect)
+			return
+		}
+		if !strings.HasPrefix(cleanPath+"/", principalPath) {
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+
+		if err := backend.BindRequestPath(modifiedRequest.Context(), requestPath); err != nil {
+			serveCarddavError(c.Writer, err)
+			return
+		}
+
+		homeSetPath, _ := backend.AddressbookHomeSetPath(modifiedRequest.Context())
+		bookPath := backend.AddressBookPath(backend.BoundAddressBook())
+		isHomeSet := cleanPath+"/" == homeSetPath
+		isAddressBook := cleanPath+"/" == bookPath
+
+		switch {
+		case modifiedRequest.Method == "PROPFIND" && (isHomeSet || isAddressBook):
+			err := serveCarddavCollectionPropfind(c.Writer, modifiedRequest, backend, isHomeSet)
+			if err != nil {
+				serveCarddavError(c.Writer, err)
+			}
+			return
+		case modifiedRequest.Method == "REPORT" && isAddressBook:
+			body, err := io.ReadAll(modifiedRequest.Body)
+			if err != nil {
+				serveCarddavError(c.Writer, err)
+				return
+			}
+			if bytes.Contains(body, []byte("sync-collection")) {
+				if err = serveCarddavSyncCollection(c.Writer, body, backend); err != nil {
+					serveCarddavError(c.Writer, err)
+				}
+				return
+			}
+			modifiedRequest.Body = io.NopCloser(bytes.NewReader(body))
+		case modifiedRequest.Method == "DELETE" && (isHomeSet || isAddressBook):
+			c.AbortWithStatus(http.StatusForbidden)
+			return
+		}
+
+		carddavHandler := carddav.Handler{Backend: backend, Prefix: resource.CarddavPrefix}
+		carddavHandler.ServeHTTP(c.Writer, modifiedRequest)
+	}
+
+	// COPY, MOVE and MKCOL are not supported by the carddav handler
+	for _, method := range []string{"OPTIONS", "HEAD", "GET", "PUT", "DELETE", "PROPFIND", "PROPPATCH", "REPORT"} {
+		defaultRouter.Handle(method, "/carddav/*path", carddavHttpHandler)
+	}
+	defaultRouter.Handle("GET", "/.well-known/carddav", carddavHttpHandler)
+	defaultRouter.Handle("PROPFIND", "/.well-known/carddav", carddavHttpHandler)
+
+	logrus.Printf("[CARDDAV ENDPOINT] CardDAV routes registered at %v", resource.CarddavPrefix)
+}
+
+// serveCarddavCollectionPropfind answers PROPFIND on the address book home set and on an address book.
+// The carddav handler only knows a single address book per user, so the home set listing and the
+// sync properties are written here.
+func serveCarddavCollectionPropfind(w http.ResponseWriter, r *http.Request, backend *resource.DaptinCarddavBackend, isHomeSet bool) error {
+	request := &carddavPropRequest{}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	if len(bytes.TrimSpace(body)) > 0 {
+		if err = xml.Unmarshal(body, request); err != nil {
+			return webdav.NewHTTPError(http.StatusBadRequest, err)
+		}
+	}
+
+	depth := r.Header.Get("Depth")
+	principalPath, _ := backend.CurrentUserPrincipal(r.Context())
+	homeSetPath, _ := backend.AddressbookHomeSetPath(r.Context())
+
+	ms := &carddavMultiStatus{}
+	if isHomeSet {
+		ms.addPropResponse(homeSetPath, request.propNames(nil), map[xml.Name]string{
+			{Space: davNamespace, Local: "resourcetype"}:           "<d:collection/>",
+			{Space: davNamespace, Local: "current-user-principal"}: "<d:href>" + xmlEscape(principalPath) + "</d:href>",
+		})
+		if depth != "0" {
+			books, err := backend.ListAddressBooks(r.Context())
+			if err != nil {
+				return err
+			}
+			for _, book := range books {
+				ms.addPropResponse(backend.AddressBookPath(book), request.propNames(nil), carddavAddressBookProps(backend, book, principalPath))
+			}
+		}
+	} else {
+		book := backend.BoundAddressBook()
+		ms.addPropResponse(backend.AddressBookPath(book), request.propNames(nil), carddavAddressBookProps(backend, book, principalPath))
+		if depth != "0" {
+			addressObjects, err := backend.ListAddressObjects(r.Context(), &carddav.AddressDataRequest{})
+			if err != nil {
+				return err
+			}
+			for _, addressObject := range addressObjects {
+				ms.addPropResponse(addressObject.Path, request.propNames(nil), carddavAddressObjectProps(addressObject, false))
+			}
+		}
+	}
+
+	return ms.serve(w, "")
+}
+
+// serveCarddavSyncCollection answers the sync-collection REPORT (RFC 6578) of an address book
+func serveCarddavSyncCollection(w http.ResponseWriter, body []byte, backend *resource.DaptinCarddavBackend) error {
+	request := &carddavPropRequest{}
+	if err := xml.Unmarshal(body, request); err != nil {
+		return webdav.NewHTTPError(http.StatusBadRequest, err)
+	}
+
+	syncResponse, err := backend.SyncAddressObjects(strings.TrimSpace(request.SyncToken))
+	if err != nil {
+		return err
+	}
+
+	propNames := request.propNames([]xml.Name{{Space: davNamespace, Local: "getetag"}})
+	withAddressData := false
+	for _, name := range propNames {
+		if name.Space == carddavNamespace && name.Local == "address-data" {
+			withAddressData = true
+		}
+	}
+
+	ms := &carddavMultiStatus{}
+	for _, addressObject := range syncResponse.Updated {
+		ms.addPropResponse(addressObject.Path, propNames, carddavAddressObjectProps(addressObject, withAddressData))
+	}
+	for _, deletedPath := range syncResponse.Deleted {
+		ms.addStatusResponse(deletedPath, http.StatusNotFound)
+	}
+
+	return ms.serve(w, syncResponse.SyncToken)
+}
+
+func carddavAddressBookProps(backend *resource.DaptinCarddavBackend, book *resource.CarddavAddressBook, principalPath string) map[xml.Name]string {
+	privileges := "<d:privilege><d:read/></d:privilege>"
+	if book.CanWrite {
+		privileges += "<d:privilege><d:write/></d:privilege><d:privilege><d:write-content/></d:privilege><d:privilege><d:bind/></d:privilege><d:privilege><d:unbind/></d:privilege>"
+	}
+	syncToken := backend.SyncToken(book)
+	return map[xml.Name]string{
+		{Space: davNamespace, Local: "resourcetype"}:                "<d:collection/><card:addressbook/>",
+		{Space: davNamespace, Local: "displayname"}:                 xmlEscape(book.Name),
+		{Space: davNamespace, Local: "current-user-principal"}:      "<d:href>" + xmlEscape(principalPath) + "</d:href>",
+		{Space: davNamespace, Local: "current-user-privilege-set"}:  privileges,
+		{Space: davNamespace, Local: "sync-token"}:                  xmlEscape(syncToken),
+		{Space: davNamespace, Local: "supported-report-set"}:        "<d:supported-report><d:report><card:addressbook-query/></d:report></d:supported-report><d:supported-report><d:report><card:addressbook-multiget/></d:report></d:supported-report><d:supported-report><d:report><d:sync-collection/></d:report></d:supported-report>",
+		{Space: calendarServerNamespace, Local: "getctag"}:          xmlEscape(syncToken),
+		{Space: carddavNamespace, Local: "addressbook-description"}: xmlEscape(book.Description),
+		{Space: carddavNamespace, Local: "supported-address-data"}:  "<card:address-data-type content-type=\"text/vcard\" version=\"3.0\"/><card:address-data-type content-type=\"text/vcard\" version=\"4.0\"/>",
+	}
+}
+
+func carddavAddressObjectProps(addressObject carddav.AddressObject, withAddressData bool) map[xml.Name]string {
+	props := map[xml.Name]string{
+		{Space: davNamespace, Local: "resourcetype"}:   "",
+		{Space: davNamespace, Local: "getetag"}:        xmlEscape(fmt.Sprintf("%q", addressObject.ETag)),
+		{Space: davNamespace, Local: "getcontenttype"}: vcard.MIMEType,
+	}
+	if withAddressData {
+		var buf bytes.Buffer
+		if err := vcard.NewEncoder(&buf).Encode(addressObject.Card); err == nil {
+			props[xml.Name{Space: carddavNamespace, Local: "address-data"}] = xmlEscape(buf.String())
+		}
+	}
+	return props
+}
+
+// carddavMultiStatus builds a 207 Multi-Status body
+type carddavMultiStatus struct {
+	responses bytes.Buffer
+}
+
+func (ms *carddavMultiStatus) addPropResponse(href string, requested []xml.Name, available map[xml.Name]string) {
+	if requested == nil {
+		requested = make([]xml.Name, 0, len(available))
+		for name := range available {
+			requested = append(requested, name)
+		}
+	}
+
+	var found, missing bytes.Buffer
+	for _, name := range requested {
+		value, ok := available[name]
+		if ok {
+			found.WriteString(carddavPropElement(name, value))
+		} else {
+			missing.WriteString(carddavPropElement(name, ""))
+		}
+	}
+
+	ms.responses.WriteString("<d:response><d:href>" + xmlEscape(href) + "</d:href>")
+	if found.Len() > 0 {
+		ms.responses.WriteString("<d:propstat><d:prop>" + found.String() + "</d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat>")
+	}
+	if missing.Len() > 0 {
+		ms.responses.WriteString("<d:propstat><d:prop>" + missing.String() + "</d:prop><d:status>HTTP/1.1 404 Not Found</d:status></d:propstat>")
+	}
+	ms.responses.WriteString("</d:response>")
+}
+
+func (ms *carddavMultiStatus) addStatusResponse(href string, status int) {
+	ms.responses.WriteString(fmt.Sprintf("<d:response><d:href>%s</d:href><d:status>HTTP/1.1 %d %s</d:status></d:response>",
+		xmlEscape(href), status, http.StatusText(status)))
+}
+
+func (ms *carddavMultiStatus) serve(w http.ResponseWriter, syncToken string) error {
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(http.StatusMultiStatus)
+	_, err := io.WriteString(w, xml.Header+
+		"<d:multistatus xmlns:d=\"DAV:\" xmlns:card=\""+carddavNamespace+"\" xmlns:cs=\""+calendarServerNamespace+"\">")
+	if err != nil {
+		return err
+	}
+	if _, err = w.Write(ms.responses.Bytes()); err != nil {
+		return err
+	}
+	if syncToken != "" {
+		if _, err = io.WriteString(w, "<d:sync-token>"+xmlEscape(syncToken)+"</d:sync-token>"); err != nil {
+			return err
+		}
+	}
+	_, err = io.WriteString(w, "</d:multistatus>")
+	return err
+}
+
+func carddavPropElement(name xml.Name, value string) string {
+	var tag string
+	switch name.Space {
+	case davNamespace:
+		tag = "d:" + name.Local
+	case carddavNamespace:
+		tag = "card:" + name.Local
+	case calendarServerNamespace:
+		tag = "cs:" + name.Local
+	default:
+		return fmt.Sprintf("<x:%s xmlns:x=\"%s\">%s</x:%s>", name.Local, xmlEscape(name.Space), value, name.Local)
+	}
+	if value == "" {
+		return "<" + tag + "/>"
+	}
+	return "<" + tag + ">" + value + "</" + tag + ">"
+}
+
+func xmlEscape(value string) string {
+	var buf bytes.Buffer
+	_ = xml.EscapeText(&buf, []byte(value))
+	return buf.String()
+}
+
+// serveCarddavError writes errors created by webdav.NewHTTPError with their status code, the error type
+// itself is internal to go-webdav but its message starts with the code
+func serveCarddavError(w http.ResponseWriter, err error) {
+	code := 0
+	if _, scanErr := fmt.Sscanf(err.Error(), "%d ", &code); scanErr != nil || http.StatusText(code) == "" {
+		logrus.Errorf("[CARDDAV] request failed: %v", err)
+		code = http.StatusInternalServerError
+	}
+	http.Error(w, err.Error(), code)
+}
@@ -14,6 +14,21 @@ import (
 
 func CreateFtpServers(resources map[string]*resource.DbResource, resourcesInterfaces map[string]dbresourceinterface.DbResourceInterface, certManager *resource.CertificateManager, ftp_interface string, transaction *sqlx.Tx) (*server.FtpServer, error) {
 
+	sites, err := GetFtpEnabledSites(resourcesInterfaces, transaction)
+	if err != nil {
+		return nil, err
+	}
+
+	driver, err := NewDaptinFtpDriver(resources, certManager, ftp_interface, sites)
+	ftpS := server.NewFtpServer(driver)
+	resource.CheckErr(err, "Failed to create daptin ftp driver [%v]", driver)
+	return ftpS, err
+
+}
+
+// GetFtpEnabledSites returns the sites with ftp enabled along with their synced asset folders
+func GetFtpEnabledSites(resourcesInterfaces map[string]dbresourceinterface.DbResourceInterface, transaction *sqlx.Tx) ([]SubSiteAssetCache, error) {
+
 	subsites, err := subsite.GetAllSites(resourcesInterfaces["site"], transaction)
 	if err != nil {
 		return nil, err
@@ -28,7 +43,6 @@ func CreateFtpServers(resources map[string]*resource.DbResource, resourcesInterf
 		re, _ := uuid.FromBytes(cloudStore.ReferenceId[:])
 		cloudStoreMap[re] = cloudStore
 	}
-	var driver *DaptinFtpDriver
 
 	sites := make([]SubSiteAssetCache, 0)
 	for _, ftpServer := range subsites {
@@ -48,12 +62,7 @@ func CreateFtpServers(resources map[string]*resource.DbResource, resourcesInterf
 		sites = append(sites, site)
 
 	}
-
-	driver, err = NewDaptinFtpDriver(resources, certManager, ftp_interface, sites)
-	ftpS := server.NewFtpServer(driver)
-	resource.CheckErr(err, "Failed to create daptin ftp driver [%v]", driver)
-	return ftpS, err
-
+	return sites, nil
 }
 
 type SubSiteAssetCache struct {
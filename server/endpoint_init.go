@@ -7,8 +7,13 @@ import (
 
 func InitialiseServerResources(initConfig *resource.CmsConfig, db database.DatabaseConnection) {
 	resource.CheckRelations(initConfig)
+	resource.CheckTenantTables(initConfig)
+	resource.CheckComputedColumns(initConfig)
+	resource.CheckRollupColumns(initConfig)
 	resource.CheckAuditTables(initConfig)
 	resource.CheckTranslationTables(initConfig)
+	resource.CheckSoftDeleteTables(initConfig)
+	resource.CheckHistoryActions(initConfig)
 	//lock := new(sync.Mutex)
 	//AddStateMachines(&initConfig, db)
 
@@ -33,6 +38,8 @@ func InitialiseServerResources(initConfig *resource.CmsConfig, db database.Datab
 	}
 
 	resource.CreateIndexes(initConfig, db)
+	resource.SyncTableIndexes(initConfig, db)
+	resource.CreateSearchIndexes(initConfig, db)
 
 	var errb error
 	transaction, err = db.Beginx()
@@ -0,0 +1,47 @@
+package server
+
+import (
+	"github.com/daptin/daptin/server/dbresourceinterface"
+	"github.com/daptin/daptin/server/resource"
+	"github.com/jmoiron/sqlx"
+	"github.com/sirupsen/logrus"
+)
+
+func InitializeSftpResources(configStore *resource.ConfigStore, transaction *sqlx.Tx, cruds map[string]*resource.DbResource, crudsInterface map[string]dbresourceinterface.DbResourceInterface, certificateManager *resource.CertificateManager) *DaptinSftpServer {
+	sftpInterface, err := configStore.GetConfigValueFor("sftp.listen_interface", "backend", transaction)
+	if err != nil {
+		sftpInterface = "0.0.0.0:2222"
+		err = configStore.SetConfigValueFor("sftp.listen_interface", sftpInterface, "backend", transaction)
+		resource.CheckErr(err, "Failed to store default value for sftp.listen_interface")
+	}
+	hostKeyName, err := configStore.GetConfigValueFor("sftp.host_key_name", "backend", transaction)
+	if err != nil {
+		hostKeyName = "daptin-sftp-host-key"
+		err = configStore.SetConfigValueFor("sftp.host_key_name", hostKeyName, "backend", transaction)
+		resource.CheckErr(err, "Failed to store default value for sftp.host_key_name")
+	}
+
+	sites, err := GetFtpEnabledSites(crudsInterface, transaction)
+	if err != nil {
+		resource.CheckErr(err, "Failed to load ftp enabled sites for SFTP")
+		return nil
+	}
+	hostKey, err := LoadSftpHostKey(certificateManager, hostKeyName, transaction)
+	if err != nil {
+		resource.CheckErr(err, "Failed to load SFTP host key")
+		return nil
+	}
+	driver, err := NewDaptinFtpDriver(cruds, certificateManager, sftpInterface, sites)
+	if err != nil {
+		resource.CheckErr(err, "Failed to create SFTP driver")
+		return nil
+	}
+
+	sftpServer := NewDaptinSftpServer(driver, sftpInterface, hostKey)
+	go func() {
+		logrus.Printf("SFTP server started at %v", sftpInterface)
+		err := sftpServer.ListenAndServe()
+		resource.CheckErr(err, "Failed to listen at sftp interface")
+	}()
+	return sftpServer
+}
@@ -0,0 +1,308 @@
+package server
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/daptin/daptin/server/assetcachepojo"
+	"github.com/daptin/daptin/server/auth"
+	"github.com/daptin/daptin/server/cloud_store"
+	"github.com/daptin/daptin/server/dbresourceinterface"
+	daptinid "github.com/daptin/daptin/server/id"
+	"github.com/daptin/daptin/server/permission"
+	"github.com/daptin/daptin/server/resource"
+	"github.com/daptin/daptin/server/subsite"
+	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/webdav"
+)
+
+// LoadWebdavMounts collects the sites, cloud stores and asset columns served under /webdav/. Sites are
+// served from their synced folder, local cloud stores from their root path and remote cloud stores
+// through rclone.
+func LoadWebdavMounts(cruds map[string]*resource.DbResource, crudsInterface map[string]dbresourceinterface.DbResourceInterface, transaction *sqlx.Tx) (*WebdavMounts, error) {
+
+	mounts := &WebdavMounts{
+		Folders: map[string]map[string]*WebdavMount{
+			WebdavSiteFolder:       {},
+			WebdavCloudStoreFolder: {},
+			WebdavAssetFolder:      {},
+		},
+		LoadedAt: time.Now(),
+	}
+
+	sites, err := subsite.GetAllSites(crudsInterface["site"], transaction)
+	if err != nil {
+		return nil, err
+	}
+	for _, site := range sites {
+		siteCache, ok := cruds["site"].SubsiteFolderCache(site.ReferenceId)
+		if !ok || siteCache == nil || siteCache.LocalSyncPath == "" {
+			continue
+		}
+		mounts.Folders[WebdavSiteFolder][site.Hostname] = &WebdavMount{
+			Name:                 site.Hostname,
+			Permission:           site.Permission,
+			AdministratorGroupId: cruds["site"].AdministratorGroupId,
+			Cache:                siteCache,
+			LocalPath:            siteCache.LocalSyncPath,
+			WriteThrough:         true,
+		}
+	}
+
+	cloudStores, err := cloud_store.GetAllCloudStores(crudsInterface["cloud_store"], transaction)
+	if err != nil {
+		return nil, err
+	}
+	for _, cloudStore := range cloudStores {
+		if cloudStore.Name == "" || cloudStore.Name == "." || cloudStore.Name == ".." || strings.ContainsAny(cloudStore.Name, "/\\") {
+			logrus.Warnf("Cloud store [%v] cannot be served over webdav, its name is not a valid folder name", cloudStore.ReferenceId)
+			continue
+		}
+		mount := &WebdavMount{
+			Name:                 cloudStore.Name,
+			Permission:           cloudStore.Permission,
+			AdministratorGroupId: cruds["cloud_store"].AdministratorGroupId,
+		}
+		if cloudStore.StoreProvider == "local" || cloudStore.StoreType == "local" {
+			// changes to a local store are made in place, same as the cloud store actions do
+			mount.Cache = &assetcachepojo.AssetFolderCache{CloudStore: cloudStore}
+			mount.LocalPath = cloudStore.RootPath
+		} else {
+			var credentials map[string]interface{}
+			if cloudStore.CredentialName != "" {
+				credential, err := cruds["credential"].GetCredentialByName(cloudStore.CredentialName, transaction)
+				if err == nil && credential != nil {
+					credentials = credential.DataMap
+				}
+			}
+			// the same folder is used on every load, files pending upload are listed from it
+			cacheDirectory := webdavCacheFolder(cloudStore.Name)
+			err := os.MkdirAll(cacheDirectory, 0755)
+			if resource.CheckErr(err, "Failed to create webdav cache folder for cloud store [%v]", cloudStore.Name) {
+				continue
+			}
+			mount.Cache = &assetcachepojo.AssetFolderCache{
+				LocalSyncPath: cacheDirectory,
+				CloudStore:    cloudStore,
+				Credentials:   credentials,
+			}
+			mount.WriteThrough = true
+		}
+		mounts.Folders[WebdavCloudStoreFolder][cloudStore.Name] = mount
+	}
+
+	// the asset columns of a table share the permission of the table
+	for tableName, columnCaches := range cruds["world"].AssetFolderCache {
+		tableCrud, ok := cruds[tableName]
+		if !ok || len(columnCaches) == 0 {
+			continue
+		}
+		tablePermission := cruds["world"].GetObjectPermissionByWhereClauseWithTransaction("world", "table_name", tableName, transaction)
+		for columnName, columnCache := range columnCaches {
+			mount := assetColumnMount(tableName, columnName, columnCache, tablePermission, tableCrud.AdministratorGroupId)
+			if mount == nil {
+				continue
+			}
+			mounts.Folders[WebdavAssetFolder][mount.Name] = mount
+		}
+	}
+
+	return mounts, nil
+}
+
+// assetColumnMount serves the folder of an asset column as <table>.<column>. Columns on a local store
+// are changed in place, columns on a cached store are served from their synced folder and others
+// through rclone, and changes to both are pushed to the store like the changes to a site.
+func assetColumnMount(tableName string, columnName string, cache *assetcachepojo.AssetFolderCache,
+	tablePermission permission.PermissionInstance, administratorGroupId daptinid.DaptinReferenceId) *WebdavMount {
+	if cache == nil || cache.CloudStore.RootPath == "" {
+		return nil
+	}
+	mount := &WebdavMount{
+		Name:                 tableName + "." + columnName,
+		Permission:           tablePermission,
+		AdministratorGroupId: administratorGroupId,
+		Cache:                cache,
+	}
+	switch {
+	case cache.CloudStore.StoreProvider == "local" || cache.CloudStore.StoreType == "local":
+		mount.LocalPath = filepath.Join(cache.CloudStore.RootPath, cache.Keyname)
+	case cache.CloudStore.StoreType == "cached":
+		if cache.LocalSyncPath == "" {
+			return nil
+		}
+		mount.LocalPath = cache.LocalSyncPath
+		mount.WriteThrough = true
+	default:
+		if cache.LocalSyncPath == "" {
+			return nil
+		}
+		mount.WriteThrough = true
+	}
+	return mount
+}
+
+// webdavCacheFolder is the local folder of a remote cloud store served over webdav
+func webdavCacheFolder(cloudStoreName string) string {
+	cacheFolder := os.Getenv("DAPTIN_CACHE_FOLDER")
+	if cacheFolder == "" {
+		cacheFolder = os.TempDir()
+	}
+	return filepath.Join(cacheFolder, "webdav", cloudStoreName)
+}
+
+const (
+	// webdavCheckInterval is how often a request checks whether the mounts changed
+	webdavCheckInterval = 5 * time.Second
+	// webdavReloadInterval is how often the mounts are read again anyway, for changes which do not
+	// bump the table generations, like a node running without the cache
+	webdavReloadInterval = time.Minute
+)
+
+// webdavMountTables are the tables the mounts are read from, the permissions of the asset columns
+// are read from world
+var webdavMountTables = []string{"site", "cloud_store", "credential", "world"}
+
+// WebdavMountRegistry keeps the mounts served under /webdav/. It reads them again when a site, cloud
+// store, credential or table changed on any node of the cluster, so they are served without a restart.
+type WebdavMountRegistry struct {
+	load        func() (*WebdavMounts, error)
+	lock        sync.Mutex
+	mounts      *WebdavMounts
+	generations map[string]int
+	checkedAt   time.Time
+}
+
+// NewWebdavMountRegistry serves the mounts until they change, load reads them again
+func NewWebdavMountRegistry(mounts *WebdavMounts, load func() (*WebdavMounts, error)) *WebdavMountRegistry {
+	return &WebdavMountRegistry{
+		load:        load,
+		mounts:      mounts,
+		generations: webdavMountGenerations(),
+		checkedAt:   time.Now(),
+	}
+}
+
+// Reload reads the mounts again
+func (registry *WebdavMountRegistry) Reload() error {
+	generations := webdavMountGenerations()
+	mounts, err := registry.load()
+	if err != nil {
+		return err
+	}
+
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+	registry.mounts = mounts
+	registry.generations = generations
+	registry.checkedAt = time.Now()
+	return nil
+}
+
+// Mounts are the current mounts, read again first if they changed
+func (registry *WebdavMountRegistry) Mounts() *WebdavMounts {
+	if registry.stale() {
+		resource.CheckErr(registry.Reload(), "Failed to reload webdav mounts")
+	}
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+	return registry.mounts
+}
+
+// stale tells one caller per check interval whether the mounts changed since they were read
+func (registry *WebdavMountRegistry) stale() bool {
+	registry.lock.Lock()
+	now := time.Now()
+	if now.Sub(registry.checkedAt) < webdavCheckInterval {
+		registry.lock.Unlock()
+		return false
+	}
+	registry.checkedAt = now
+	generations, loadedAt := registry.generations, registry.mounts.LoadedAt
+	registry.lock.Unlock()
+
+	if now.Sub(loadedAt) >= webdavReloadInterval {
+		return true
+	}
+	current := webdavMountGenerations()
+	for _, tableName := range webdavMountTables {
+		if current[tableName] != generations[tableName] {
+			return true
+		}
+	}
+	return false
+}
+
+// webdavMountGenerations are the generations of the tables the mounts are read from
+func webdavMountGenerations() map[string]int {
+	generations := make(map[string]int)
+	for _, tableName := range webdavMountTables {
+		generation, err := resource.TableGeneration(tableName)
+		if err != nil {
+			logrus.Warnf("[WEBDAV] failed to read the generation of [%v]: %v", tableName, err)
+		}
+		generations[tableName] = generation
+	}
+	return generations
+}
+
+// InitializeWebdavResources mounts sites, cloud stores and asset columns at /webdav/ for desktop clients
+func InitializeWebdavResources(
+	authMiddleware *auth.AuthMiddleware,
+	cruds map[string]*resource.DbResource,
+	crudsInterface map[string]dbresourceinterface.DbResourceInterface,
+	transaction *sqlx.Tx,
+	defaultRouter *gin.Engine) {
+
+	mounts, err := LoadWebdavMounts(cruds, crudsInterface, transaction)
+	if resource.CheckErr(err, "Failed to load webdav mounts") {
+		return
+	}
+	registry := NewWebdavMountRegistry(mounts, func() (*WebdavMounts, error) {
+		transaction, err := cruds["site"].Connection().Beginx()
+		if err != nil {
+			return nil, err
+		}
+		defer transaction.Rollback()
+		return LoadWebdavMounts(cruds, crudsInterface, transaction)
+	})
+
+	// locks are shared between users so a file locked by one client is locked for everyone
+	lockSystem := webdav.NewMemLS()
+
+	webdavHttpHandler := func(c *gin.Context) {
+		ok, abort, modifiedRequest := authMiddleware.AuthCheckMiddlewareWithHttp(c.Request, c.Writer, true)
+		if !ok || abort {
+			c.Header("WWW-Authenticate", "Basic realm='webdav'")
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+		sessionUser := modifiedRequest.Context().Value("user").(*auth.SessionUser)
+
+		webdavHandler := webdav.Handler{
+			Prefix:     WebdavPrefix,
+			FileSystem: NewWebdavFileSystem(cruds, registry.Mounts(), sessionUser),
+			LockSystem: lockSystem,
+			Logger: func(request *http.Request, err error) {
+				if err != nil {
+					logrus.Debugf("[WEBDAV] %v %v: %v", request.Method, request.URL.Path, err)
+				}
+			},
+		}
+		webdavHandler.ServeHTTP(c.Writer, modifiedRequest)
+	}
+
+	for _, method := range []string{"OPTIONS", "HEAD", "GET", "POST", "PUT", "PROPFIND", "PROPPATCH",
+		"DELETE", "COPY", "MOVE", "MKCOL", "LOCK", "UNLOCK"} {
+		defaultRouter.Handle(method, WebdavPrefix+"/*path", webdavHttpHandler)
+	}
+
+	logrus.Printf("WebDAV enabled at %v/ with %d sites, %d cloud stores and %d asset columns", WebdavPrefix,
+		len(mounts.Folders[WebdavSiteFolder]), len(mounts.Folders[WebdavCloudStoreFolder]), len(mounts.Folders[WebdavAssetFolder]))
+}
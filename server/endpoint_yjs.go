@@ -94,6 +94,11 @@ func InitializeYjsResources(store ydb.Store, defaultRouter *gin.Engine,
 	var err error
 
 	broadcaster := ydb.NewLocalBroadcaster(64)
+	if relayPubSub, ok := dtopicMap["world"]; ok && relayPubSub != nil {
+		clusterBroadcaster := NewYjsClusterBroadcaster(broadcaster, relayPubSub, uuid.NewString())
+		go clusterBroadcaster.Listen(relayPubSub.Subscribe(context.Background(), YjsRelayTopic))
+		broadcaster = clusterBroadcaster
+	}
 	ydbInstance := ydb.InitYdb(store, broadcaster)
 
 	yjsConnectionHandler := ydb.YdbWsConnectionHandler(ydbInstance)
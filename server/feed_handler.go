@@ -10,6 +10,7 @@ import (
 	"github.com/jmoiron/sqlx"
 	"net/http"
 	"strings"
+	"time"
 )
 
 func CreateFeedHandler(cruds map[string]*resource.DbResource, streams []*resource.StreamProcessor, transaction *sqlx.Tx) func(*gin.Context) {
@@ -55,7 +56,7 @@ func CreateFeedHandler(cruds map[string]*resource.DbResource, streams []*resourc
 			return
 		}
 
-		if feedInfo["enable"].(string) != "1" {
+		if enabled := feedString(feedInfo, "enable"); enabled != "1" && enabled != "true" {
 			c.AbortWithStatus(404)
 			return
 		}
@@ -71,13 +72,16 @@ func CreateFeedHandler(cruds map[string]*resource.DbResource, streams []*resourc
 			return
 		}
 
-		streamProcessor, ok := streamMap[streamInfo["stream_name"].(string)]
+		streamProcessor, ok := streamMap[feedString(streamInfo, "stream_name")]
 		if !ok {
 			c.AbortWithStatus(404)
 			return
 		}
 
-		pageSize := feedInfo["page_size"].(string)
+		pageSize := feedString(feedInfo, "page_size")
+		if pageSize == "" {
+			pageSize = "10"
+		}
 
 		pr := &http.Request{
 			Method: "GET",
@@ -100,27 +104,26 @@ func CreateFeedHandler(cruds map[string]*resource.DbResource, streams []*resourc
 			return
 		}
 
-		createdAtTime, _, _ := fieldtypes.GetTime(feedInfo["created_at"].(string))
 		feed := &feeds.Feed{
-			Title:       feedInfo["title"].(string),
-			Link:        &feeds.Link{Href: feedInfo["link"].(string)},
-			Description: feedInfo["description"].(string),
-			Author:      &feeds.Author{Name: feedInfo["author_name"].(string), Email: feedInfo["author_email"].(string)},
-			Created:     createdAtTime,
+			Title:       feedString(feedInfo, "title"),
+			Link:        &feeds.Link{Href: feedString(feedInfo, "link")},
+			Description: feedString(feedInfo, "description"),
+			Author:      &feeds.Author{Name: feedString(feedInfo, "author_name"), Email: feedString(feedInfo, "author_email")},
+			Created:     feedTime(feedInfo, "created_at"),
 		}
 
 		feedItems := make([]*feeds.Item, 0)
 
 		for _, rowInterface := range rows.Result().([]api2go.Api2GoModel) {
 
+			// stream rows are shaped by the stream transformations, columns missing from a row are left empty
 			row := rowInterface.GetAttributes()
-			createdAtTime, _, _ = fieldtypes.GetTime(row["created_at"].(string))
 			feedItems = append(feedItems, &feeds.Item{
-				Title:       row["title"].(string),
-				Link:        &feeds.Link{Href: row["link"].(string)},
-				Description: row["description"].(string),
-				Author:      &feeds.Author{Name: row["author_name"].(string), Email: row["author_email"].(string)},
-				Created:     createdAtTime,
+				Title:       feedString(row, "title"),
+				Link:        &feeds.Link{Href: feedString(row, "link")},
+				Description: feedString(row, "description"),
+				Author:      &feeds.Author{Name: feedString(row, "author_name"), Email: feedString(row, "author_email")},
+				Created:     feedTime(row, "created_at"),
 			})
 
 		}
@@ -150,3 +153,24 @@ func CreateFeedHandler(cruds map[string]*resource.DbResource, streams []*resourc
 
 	}
 }
+
+func feedString(row map[string]interface{}, key string) string {
+	switch value := row[key].(type) {
+	case nil:
+		return ""
+	case string:
+		return value
+	case []byte:
+		return string(value)
+	default:
+		return fmt.Sprintf("%v", value)
+	}
+}
+
+func feedTime(row map[string]interface{}, key string) time.Time {
+	if value, ok := row[key].(time.Time); ok {
+		return value
+	}
+	createdAt, _, _ := fieldtypes.GetTime(feedString(row, key))
+	return createdAt
+}
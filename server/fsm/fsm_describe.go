@@ -0,0 +1,200 @@
+package fsm
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// StateNames lists the initial state followed by every destination of an event, in the order
+// they first appear
+func (d LoopbookFsmDescription) StateNames() []string {
+	names := make([]string, 0)
+	seen := make(map[string]bool)
+	add := func(name string) {
+		if name != "" && !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	add(d.InitialState)
+	for _, event := range d.Events {
+		add(event.Dst)
+	}
+	return names
+}
+
+// Validate lists the problems in a state machine definition: unknown source states, events
+// defined twice for the same source state, states which cannot be reached from the initial
+// state, hooks on states no event leads to and timers which do not parse.
+func (d LoopbookFsmDescription) Validate() []error {
+	problems := make([]error, 0)
+	if d.InitialState == "" {
+		problems = append(problems, fmt.Errorf("state machine [%v] has no initial state", d.Name))
+	}
+
+	known := make(map[string]bool)
+	for _, name := range d.StateNames() {
+		known[name] = true
+	}
+
+	eventSources := make(map[string]bool)
+	for _, event := range d.Events {
+		if event.Name == "" {
+			problems = append(problems, fmt.Errorf("state machine [%v] has an event without a name", d.Name))
+			continue
+		}
+		if event.Dst == "" {
+			problems = append(problems, fmt.Errorf("event [%v] has no destination state", event.Name))
+		}
+		if len(event.Src) == 0 {
+			problems = append(problems, fmt.Errorf("event [%v] has no source state", event.Name))
+		}
+		for _, src := range event.Src {
+			if !known[src] {
+				problems = append(problems, fmt.Errorf("event [%v] has unknown source state [%v]", event.Name, src))
+			}
+			key := event.Name + "\x00" + src
+			if eventSources[key] {
+				problems = append(problems, fmt.Errorf("event [%v] is defined more than once for state [%v]", event.Name, src))
+			}
+			eventSources[key] = true
+		}
+		if _, err := event.AfterDuration(); err != nil {
+			problems = append(problems, fmt.Errorf("event [%v] has invalid After [%v]: %v", event.Name, event.After, err))
+		}
+	}
+
+	reachable := map[string]bool{d.InitialState: true}
+	for changed := true; changed; {
+		changed = false
+		for _, event := range d.Events {
+			if reachable[event.Dst] {
+				continue
+			}
+			for _, src := range event.Src {
+				if reachable[src] {
+					reachable[event.Dst] = true
+					changed = true
+					break
+				}
+			}
+		}
+	}
+	for _, name := range d.StateNames() {
+		if !reachable[name] {
+			problems = append(problems, fmt.Errorf("state [%v] is unreachable from [%v]", name, d.InitialState))
+		}
+	}
+
+	for _, state := range d.States {
+		if !known[state.Name] {
+			problems = append(problems, fmt.Errorf("actions are defined on unknown state [%v]", state.Name))
+		}
+	}
+
+	return problems
+}
+
+// Dot renders the state machine as a Graphviz digraph
+func (d LoopbookFsmDescription) Dot() string {
+	var out strings.Builder
+	fmt.Fprintf(&out, "digraph %s {\n", dotQuote(d.Name))
+	fmt.Fprintf(&out, "  label=%s;\n", dotQuote(descriptionLabel(d)))
+	out.WriteString("  rankdir=LR;\n")
+	out.WriteString("  node [shape=ellipse];\n")
+	for _, name := range d.StateNames() {
+		if name == d.InitialState {
+			fmt.Fprintf(&out, "  %s [shape=doublecircle];\n", dotQuote(name))
+		} else {
+			fmt.Fprintf(&out, "  %s;\n", dotQuote(name))
+		}
+	}
+	for _, edge := range d.edges() {
+		attributes := []string{"label=" + dotQuote(edge.label)}
+		if edge.color != "" {
+			attributes = append(attributes, "color="+dotQuote(edge.color))
+		}
+		fmt.Fprintf(&out, "  %s -> %s [%s];\n", dotQuote(edge.src), dotQuote(edge.dst), strings.Join(attributes, ", "))
+	}
+	out.WriteString("}\n")
+	return out.String()
+}
+
+// Mermaid renders the state machine as a mermaid stateDiagram-v2
+func (d LoopbookFsmDescription) Mermaid() string {
+	var out strings.Builder
+	out.WriteString("---\n")
+	fmt.Fprintf(&out, "title: %s\n", descriptionLabel(d))
+	out.WriteString("---\n")
+	out.WriteString("stateDiagram-v2\n")
+	ids := make(map[string]string)
+	for i, name := range d.StateNames() {
+		ids[name] = fmt.Sprintf("s%d", i)
+		fmt.Fprintf(&out, "  state %s as %s\n", mermaidQuote(name), ids[name])
+	}
+	if d.InitialState != "" {
+		fmt.Fprintf(&out, "  [*] --> %s\n", ids[d.InitialState])
+	}
+	for _, edge := range d.edges() {
+		src, ok := ids[edge.src]
+		if !ok {
+			// unknown source states are reported by Validate, draw them anyway
+			src = fmt.Sprintf("s%d", len(ids))
+			ids[edge.src] = src
+			fmt.Fprintf(&out, "  state %s as %s\n", mermaidQuote(edge.src), src)
+		}
+		fmt.Fprintf(&out, "  %s --> %s : %s\n", src, ids[edge.dst], strings.ReplaceAll(edge.label, ":", " "))
+	}
+	return out.String()
+}
+
+type describedEdge struct {
+	src   string
+	dst   string
+	label string
+	color string
+}
+
+// edges is one edge per source state of every event, labelled with the event, its guard and timer
+func (d LoopbookFsmDescription) edges() []describedEdge {
+	edges := make([]describedEdge, 0)
+	for _, event := range d.Events {
+		label := event.Name
+		if event.Label != "" {
+			label = event.Label
+		}
+		if event.Guard != "" {
+			label += " [" + event.Guard + "]"
+		}
+		if event.After != "" {
+			label += " after " + event.After
+		}
+		sources := append([]string{}, event.Src...)
+		sort.Strings(sources)
+		for _, src := range sources {
+			edges = append(edges, describedEdge{
+				src:   src,
+				dst:   event.Dst,
+				label: label,
+				color: event.Color,
+			})
+		}
+	}
+	return edges
+}
+
+func descriptionLabel(d LoopbookFsmDescription) string {
+	if d.Label != "" {
+		return d.Label
+	}
+	return d.Name
+}
+
+func dotQuote(value string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value) + `"`
+}
+
+func mermaidQuote(value string) string {
+	return `"` + strings.ReplaceAll(value, `"`, "#quot;") + `"`
+}
@@ -0,0 +1,90 @@
+package fsm
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateStateMachineDescription(t *testing.T) {
+	valid := LoopbookFsmDescription{
+		Name:         "ticket",
+		InitialState: "open",
+		Events: []LoopbackEventDesc{
+			{Name: "assign", Src: []string{"open"}, Dst: "assigned"},
+			{Name: "close", Src: []string{"open", "assigned"}, Dst: "closed", After: "72h"},
+			{Name: "reopen", Src: []string{"closed"}, Dst: "open"},
+		},
+		States: []LoopbackStateDesc{{Name: "closed"}},
+	}
+	if problems := valid.Validate(); len(problems) != 0 {
+		t.Fatalf("valid machine reported %v", problems)
+	}
+
+	invalid := LoopbookFsmDescription{
+		Name:         "ticket",
+		InitialState: "open",
+		Events: []LoopbackEventDesc{
+			{Name: "assign", Src: []string{"open"}, Dst: "assigned"},
+			{Name: "assign", Src: []string{"open"}, Dst: "closed"},
+			{Name: "archive", Src: []string{"limbo"}, Dst: "archived"},
+			{Name: "snooze", Src: []string{"assigned"}, Dst: "assigned", After: "soon"},
+		},
+		States: []LoopbackStateDesc{{Name: "pending"}},
+	}
+	problems := make([]string, 0)
+	for _, problem := range invalid.Validate() {
+		problems = append(problems, problem.Error())
+	}
+	reported := strings.Join(problems, "\n")
+	for _, expected := range []string{
+		"event [assign] is defined more than once for state [open]",
+		"event [archive] has unknown source state [limbo]",
+		"state [archived] is unreachable from [open]",
+		"event [snooze] has invalid After [soon]",
+		"actions are defined on unknown state [pending]",
+	} {
+		if !strings.Contains(reported, expected) {
+			t.Errorf("expected problem %q in\n%v", expected, reported)
+		}
+	}
+	if len(problems) != 5 {
+		t.Errorf("expected 5 problems, got %d:\n%v", len(problems), reported)
+	}
+}
+
+func TestStateMachineDiagrams(t *testing.T) {
+	machine := LoopbookFsmDescription{
+		Name:         "ticket",
+		Label:        "Ticket",
+		InitialState: "open",
+		Events: []LoopbackEventDesc{
+			{Name: "close", Label: "Close", Src: []string{"open"}, Dst: "closed", Guard: "subject.done", Color: "#dc3545"},
+			{Name: "reopen", Src: []string{"closed"}, Dst: "open", After: "24h"},
+		},
+	}
+
+	dot := machine.Dot()
+	for _, expected := range []string{
+		`digraph "ticket" {`,
+		`"open" [shape=doublecircle];`,
+		`"open" -> "closed" [label="Close [subject.done]", color="#dc3545"];`,
+		`"closed" -> "open" [label="reopen after 24h"];`,
+	} {
+		if !strings.Contains(dot, expected) {
+			t.Errorf("expected %q in dot output\n%v", expected, dot)
+		}
+	}
+
+	mermaid := machine.Mermaid()
+	for _, expected := range []string{
+		"stateDiagram-v2",
+		`state "open" as s0`,
+		"[*] --> s0",
+		"s0 --> s1 : Close [subject.done]",
+		"s1 --> s0 : reopen after 24h",
+	} {
+		if !strings.Contains(mermaid, expected) {
+			t.Errorf("expected %q in mermaid output\n%v", expected, mermaid)
+		}
+	}
+}
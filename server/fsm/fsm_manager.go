@@ -9,6 +9,7 @@ import (
 	"github.com/daptin/daptin/server/statementbuilder"
 	"github.com/doug-martin/goqu/v9"
 	"github.com/jmoiron/sqlx"
+	"time"
 
 	loopfsm "github.com/looplab/fsm"
 	"github.com/pkg/errors"
@@ -84,6 +85,41 @@ type LoopbackEventDesc struct {
 	// Dst is the destination state that the FSM will be in if the transition
 	// succeeds.
 	Dst string
+
+	// Guard is a javascript expression evaluated with the subject row as `subject`, the
+	// transition is refused unless it is true.
+	Guard string `json:",omitempty"`
+
+	// After fires the event on its own once the object has been in one of the source
+	// states for this long, eg "48h".
+	After string `json:",omitempty"`
+
+	// Actions are invoked in the transaction of the transition, after the OnExit actions
+	// of the source state and before the OnEnter actions of the destination state.
+	Actions []LoopbackActionDesc `json:",omitempty"`
+}
+
+// AfterDuration is how long an object stays in a source state before the event fires on its own
+func (e LoopbackEventDesc) AfterDuration() (time.Duration, error) {
+	if e.After == "" {
+		return 0, nil
+	}
+	return time.ParseDuration(e.After)
+}
+
+// LoopbackActionDesc invokes the action named Action on the entity Type. Attribute values are
+// evaluated like action outcome attributes, against `subject`, `from`, `to` and `event`.
+type LoopbackActionDesc struct {
+	Type       string
+	Action     string
+	Attributes map[string]interface{} `json:",omitempty"`
+}
+
+// LoopbackStateDesc holds the actions invoked when an object enters or leaves the state
+type LoopbackStateDesc struct {
+	Name    string
+	OnEnter []LoopbackActionDesc `json:",omitempty"`
+	OnExit  []LoopbackActionDesc `json:",omitempty"`
 }
 
 type LoopbookFsmDescription struct {
@@ -91,6 +127,62 @@ type LoopbookFsmDescription struct {
 	Name         string
 	Label        string
 	Events       []LoopbackEventDesc
+	States       []LoopbackStateDesc `json:",omitempty"`
+}
+
+// State returns the description of a state, states without hooks are not described
+func (d LoopbookFsmDescription) State(name string) LoopbackStateDesc {
+	for _, state := range d.States {
+		if state.Name == name {
+			return state
+		}
+	}
+	return LoopbackStateDesc{Name: name}
+}
+
+// Event returns the event which moves an object out of currentState on eventName
+func (d LoopbookFsmDescription) Event(currentState string, eventName string) (LoopbackEventDesc, bool) {
+	for _, event := range d.Events {
+		if event.Name != eventName {
+			continue
+		}
+		for _, src := range event.Src {
+			if src == currentState {
+				return event, true
+			}
+		}
+	}
+	return LoopbackEventDesc{}, false
+}
+
+// NextState is the state an object in currentState moves to on eventName
+func (d LoopbookFsmDescription) NextState(currentState string, eventName string) (string, error) {
+	if currentState == "" {
+		currentState = d.InitialState
+	}
+	stateMachineRunner := newStateMachineRunner(currentState, d.Events)
+	if !stateMachineRunner.Can(eventName) {
+		return currentState, errors.New(fmt.Sprintf("Cannot apply event %s at this state [%v]", eventName, currentState))
+	}
+	err := stateMachineRunner.Event(context.TODO(), eventName)
+	if err != nil && err.Error() != "no transition" {
+		return currentState, err
+	}
+	return stateMachineRunner.Current(), nil
+}
+
+func newStateMachineRunner(currentState string, events []LoopbackEventDesc) *loopfsm.FSM {
+	listOfEvents := make([]loopfsm.EventDesc, 0)
+	for _, e := range events {
+		e1 := loopfsm.EventDesc{
+			Name: e.Name,
+			Src:  e.Src,
+			Dst:  e.Dst,
+		}
+		listOfEvents = append(listOfEvents, e1)
+	}
+
+	return loopfsm.NewFSM(currentState, listOfEvents, map[string]loopfsm.Callback{})
 }
 
 func (fsm *fsmManager) stateMachineRunnerFor(currentState string, typeName string, machineId int64) (*loopfsm.FSM, error) {
@@ -132,18 +224,7 @@ func (fsm *fsmManager) stateMachineRunnerFor(currentState string, typeName strin
 		return nil, err
 	}
 
-	listOfEvents := make([]loopfsm.EventDesc, 0)
-	for _, e := range events {
-		e1 := loopfsm.EventDesc{
-			Name: e.Name,
-			Src:  e.Src,
-			Dst:  e.Dst,
-		}
-		listOfEvents = append(listOfEvents, e1)
-	}
-
-	fsmI := loopfsm.NewFSM(currentState, listOfEvents, map[string]loopfsm.Callback{})
-	return fsmI, nil
+	return newStateMachineRunner(currentState, events), nil
 }
 
 func (fsm *fsmManager) ApplyEvent(subject map[string]interface{}, stateMachineEvent StateMachineEvent) (string, error) {
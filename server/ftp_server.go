@@ -23,6 +23,7 @@ import (
 	"sync/atomic"
 
 	"github.com/fclairamb/ftpserver/server"
+	"github.com/jmoiron/sqlx"
 )
 
 // DaptinFtpDriver defines a very basic ftpserver driver
@@ -179,6 +180,30 @@ func (driver *DaptinFtpDriver) WelcomeUser(cc server.ClientContext) (string, err
 // AuthUser authenticates the user and selects an handling driver
 func (driver *DaptinFtpDriver) AuthUser(cc server.ClientContext, user, pass string) (server.ClientHandlingDriver, error) {
 
+	sessionUser, err := driver.authenticateUser(user, func(userAccount map[string]interface{}, transaction *sqlx.Tx) error {
+		passwordHash, ok := userAccount["password"].(string)
+		if !ok || !resource.BcryptCheckStringHash(pass, passwordHash) {
+			return fmt.Errorf("could not authenticate you")
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	log.Infof("FTP Login [%s][%s][%s]", driver.BaseDir, user, cc.RemoteAddr())
+	return &ClientDriver{
+		BaseDir:     "/",
+		CurrentDir:  "/",
+		FtpDriver:   driver,
+		sessionUser: sessionUser,
+	}, nil
+}
+
+// authenticateUser loads the user account by email, runs checkCredentials against it and builds the
+// session user with its groups. Shared by the FTP and SFTP servers.
+func (driver *DaptinFtpDriver) authenticateUser(email string,
+	checkCredentials func(userAccount map[string]interface{}, transaction *sqlx.Tx) error) (*auth.SessionUser, error) {
+
 	transaction, err := driver.cruds["user_account"].Connection().Beginx()
 	if err != nil {
 		resource.CheckErr(err, "Failed to begin transaction [174]")
@@ -186,14 +211,13 @@ func (driver *DaptinFtpDriver) AuthUser(cc server.ClientContext, user, pass stri
 	}
 
 	defer transaction.Rollback()
-	userAccount, err := driver.cruds["user_account"].GetUserAccountRowByEmail(user, transaction)
+	userAccount, err := driver.cruds["user_account"].GetUserAccountRowByEmail(email, transaction)
 	if err != nil {
 		return nil, err
 	}
 
-	passwordHash, ok := userAccount["password"].(string)
-	if !ok || !resource.BcryptCheckStringHash(pass, passwordHash) {
-		return nil, fmt.Errorf("could not authenticate you")
+	if err = checkCredentials(userAccount, transaction); err != nil {
+		return nil, err
 	}
 	userId, ok := userAccount["id"].(int64)
 	if !ok {
@@ -208,13 +232,7 @@ func (driver *DaptinFtpDriver) AuthUser(cc server.ClientContext, user, pass stri
 	if sessionUser.UserReferenceId == daptinid.NullReferenceId {
 		return nil, errors.New("invalid user account reference id")
 	}
-	log.Infof("FTP Login [%s][%s][%s]", driver.BaseDir, user, cc.RemoteAddr())
-	return &ClientDriver{
-		BaseDir:     "/",
-		CurrentDir:  "/",
-		FtpDriver:   driver,
-		sessionUser: sessionUser,
-	}, nil
+	return sessionUser, nil
 }
 
 func (driver *ClientDriver) sitePath(ftpPath string) (string, SubSiteAssetCache, string, error) {
@@ -399,18 +417,8 @@ func (driver *ClientDriver) OpenFile(cc server.ClientContext, path string, flag
 		return nil, err
 	}
 	if (flag & (os.O_WRONLY | os.O_RDWR)) != 0 {
-		_, statErr := os.Stat(fullPath)
-		if errors.Is(statErr, os.ErrNotExist) {
-			if !site.Permission.CanCreate(driver.sessionUser.UserReferenceId, driver.sessionUser.Groups, driver.FtpDriver.cruds["site"].AdministratorGroupId) {
-				return nil, errors.New("permission denied")
-			}
-		} else {
-			if statErr != nil {
-				return nil, statErr
-			}
-			if !site.Permission.CanUpdate(driver.sessionUser.UserReferenceId, driver.sessionUser.Groups, driver.FtpDriver.cruds["site"].AdministratorGroupId) {
-				return nil, errors.New("permission denied")
-			}
+		if err = driver.checkWritePermission(site, fullPath); err != nil {
+			return nil, err
 		}
 		flag |= os.O_CREATE
 		if (flag & os.O_APPEND) == 0 {
@@ -422,6 +430,24 @@ func (driver *ClientDriver) OpenFile(cc server.ClientContext, path string, flag
 	return os.OpenFile(fullPath, flag, 0600)
 }
 
+// checkWritePermission checks create permission for new files and update permission for existing ones
+func (driver *ClientDriver) checkWritePermission(site SubSiteAssetCache, fullPath string) error {
+	_, statErr := os.Stat(fullPath)
+	if errors.Is(statErr, os.ErrNotExist) {
+		if !site.Permission.CanCreate(driver.sessionUser.UserReferenceId, driver.sessionUser.Groups, driver.FtpDriver.cruds["site"].AdministratorGroupId) {
+			return errors.New("permission denied")
+		}
+		return nil
+	}
+	if statErr != nil {
+		return statErr
+	}
+	if !site.Permission.CanUpdate(driver.sessionUser.UserReferenceId, driver.sessionUser.Groups, driver.FtpDriver.cruds["site"].AdministratorGroupId) {
+		return errors.New("permission denied")
+	}
+	return nil
+}
+
 // GetFileInfo gets some info around a file or a directory
 func (driver *ClientDriver) GetFileInfo(cc server.ClientContext, path string) (os.FileInfo, error) {
 	_, site, fullPath, err := driver.resolveSitePath(path)
@@ -471,6 +497,10 @@ func (driver *ClientDriver) DeleteFile(cc server.ClientContext, path string) err
 }
 
 // RenameFile renames a file or a directory
+// errCrossSiteRename is returned for renames between two sites, each site is synced to its own
+// cloud store
+var errCrossSiteRename = errors.New("cannot rename across sites")
+
 func (driver *ClientDriver) RenameFile(cc server.ClientContext, from, to string) error {
 	fromSiteName, fromSite, fromPath, err := driver.resolveSitePath(from)
 	if err != nil {
@@ -481,7 +511,7 @@ func (driver *ClientDriver) RenameFile(cc server.ClientContext, from, to string)
 		return err
 	}
 	if fromSiteName != toSiteName {
-		return errors.New("cannot rename across sites")
+		return errCrossSiteRename
 	}
 	if !fromSite.Permission.CanUpdate(driver.sessionUser.UserReferenceId, driver.sessionUser.Groups, driver.FtpDriver.cruds["site"].AdministratorGroupId) ||
 		!toSite.Permission.CanUpdate(driver.sessionUser.UserReferenceId, driver.sessionUser.Groups, driver.FtpDriver.cruds["site"].AdministratorGroupId) {
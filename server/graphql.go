@@ -7,6 +7,7 @@ import (
 	daptinid "github.com/daptin/daptin/server/id"
 	"github.com/daptin/daptin/server/resource"
 	"github.com/daptin/daptin/server/table_info"
+	"github.com/daptin/daptin/server/tenant"
 	"github.com/gobuffalo/flect"
 	"github.com/google/uuid"
 	"github.com/graphql-go/graphql"
@@ -163,6 +164,54 @@ func MakeGraphqlSchema(cmsConfig *resource.CmsConfig, resources map[string]*reso
 		DefaultValue: "",
 	}
 
+	asOfArgument := graphql.ArgumentConfig{
+		Type:        graphql.String,
+		Description: "read the rows as they were at this time, from the audit rows",
+	}
+
+	fieldChangeType := graphql.NewObject(graphql.ObjectConfig{
+		Name:        "FieldChange",
+		Description: "Change of one column of a row",
+		Fields: graphql.Fields{
+			"field": &graphql.Field{
+				Type: graphql.String,
+			},
+			"from": &graphql.Field{
+				Type: graphql.String,
+			},
+			"to": &graphql.Field{
+				Type: graphql.String,
+			},
+		},
+	})
+
+	rowChangeType := graphql.NewObject(graphql.ObjectConfig{
+		Name:        "RowChange",
+		Description: "One change in the history of a row",
+		Fields: graphql.Fields{
+			"version": &graphql.Field{
+				Type:        graphql.Int,
+				Description: "version of the row the change was made to",
+			},
+			"operation": &graphql.Field{
+				Type: graphql.String,
+			},
+			"changed_at": &graphql.Field{
+				Type: graphql.DateTime,
+			},
+			"changed_by": &graphql.Field{
+				Type:        graphql.String,
+				Description: "reference id of the user who made the change",
+			},
+			"request_id": &graphql.Field{
+				Type: graphql.String,
+			},
+			"changes": &graphql.Field{
+				Type: graphql.NewList(fieldChangeType),
+			},
+		},
+	})
+
 	for _, table := range cmsConfig.Tables {
 
 		if len(table.TableName) < 1 {
@@ -252,6 +301,17 @@ func MakeGraphqlSchema(cmsConfig *resource.CmsConfig, resources map[string]*reso
 			}
 		}
 
+		// virtual computed columns have no column in the table, their values are added when read
+		for _, computed := range table.ComputedColumns {
+			if !computed.IsVirtual() {
+				continue
+			}
+			fields[computed.Name] = &graphql.Field{
+				Type:        resource.ColumnManager.GetGraphqlType(computed.ColumnType),
+				Description: computed.ColumnDescription,
+			}
+		}
+
 		for _, relation := range table.Relations {
 
 			targetName := relation.GetSubjectName()
@@ -298,6 +358,64 @@ func MakeGraphqlSchema(cmsConfig *resource.CmsConfig, resources map[string]*reso
 			Type:        graphql.NewNonNull(graphql.ID),
 		}
 
+		if table.IsAuditEnabled {
+			fields["history"] = &graphql.Field{
+				Type:        graphql.NewList(rowChangeType),
+				Description: "Changes made to this " + table.TableName + ", oldest first",
+				Resolve: func(table table_info.TableInfo) func(params graphql.ResolveParams) (interface{}, error) {
+					return func(params graphql.ResolveParams) (interface{}, error) {
+						source, ok := params.Source.(map[string]interface{})
+						if !ok {
+							return nil, nil
+						}
+						referenceId := daptinid.InterfaceToDIR(source["reference_id"])
+
+						ur, _ := url.Parse("/api/" + table.TableName + "/" + referenceId.String() + "/history")
+						pr := &http.Request{
+							Method: "GET",
+							URL:    ur,
+						}
+						pr = pr.WithContext(params.Context)
+
+						transaction, err := resources[table.TableName].BeginReadTransaction(params.Context)
+						if err != nil {
+							resource.CheckErr(err, "Failed to begin transaction [history]")
+							return nil, err
+						}
+						defer transaction.Rollback()
+
+						history, err := resources[table.TableName].RowHistoryWithTransaction(referenceId, api2go.Request{
+							PlainRequest: pr,
+						}, transaction)
+						if err != nil {
+							return nil, err
+						}
+
+						items := make([]map[string]interface{}, 0, len(history))
+						for _, rowChange := range history {
+							changes := make([]map[string]interface{}, 0, len(rowChange.Changes))
+							for _, change := range rowChange.Changes {
+								changes = append(changes, map[string]interface{}{
+									"field": change.Field,
+									"from":  graphqlHistoryValue(change.From),
+									"to":    graphqlHistoryValue(change.To),
+								})
+							}
+							items = append(items, map[string]interface{}{
+								"version":    rowChange.Version,
+								"operation":  rowChange.Operation,
+								"changed_at": rowChange.ChangedAt,
+								"changed_by": rowChange.ChangedBy,
+								"request_id": rowChange.RequestId,
+								"changes":    changes,
+							})
+						}
+						return items, nil
+					}
+				}(table),
+			}
+		}
+
 		for fieldName, config := range fields {
 			inputTypesMap[table.TableName].AddFieldConfig(fieldName, config)
 		}
@@ -307,11 +425,11 @@ func MakeGraphqlSchema(cmsConfig *resource.CmsConfig, resources map[string]*reso
 		rootFields[table.TableName] = &graphql.Field{
 			Type:        graphql.NewList(inputTypesMap[table.TableName]),
 			Description: "Find all " + table.TableName,
-			Args: graphql.FieldConfigArgument{
+			Args: findAllArguments(table, graphql.FieldConfigArgument{
 				"filter": &filterArgument,
 				"query":  &queryArgument,
 				"page":   &pageConfig,
-			},
+			}, &asOfArgument),
 			//Args:        uniqueFields,
 			Resolve: func(table table_info.TableInfo) func(params graphql.ResolveParams) (interface{}, error) {
 				return func(params graphql.ResolveParams) (interface{}, error) {
@@ -379,6 +497,9 @@ func MakeGraphqlSchema(cmsConfig *resource.CmsConfig, resources map[string]*reso
 							"included_relations": {"*"},
 						},
 					}
+					if asOf, ok := params.Args["asOf"].(string); ok && asOf != "" {
+						req.QueryParams[resource.AsOfQueryParameter] = []string{asOf}
+					}
 
 					_, responder, err := resources[table.TableName].PaginatedFindAll(req)
 
@@ -459,6 +580,14 @@ func MakeGraphqlSchema(cmsConfig *resource.CmsConfig, resources map[string]*reso
 				"order": &graphql.ArgumentConfig{
 					Type: graphql.NewList(graphql.String),
 				},
+				"rollup": &graphql.ArgumentConfig{
+					Type:        graphql.String,
+					Description: "Read the rows materialized for this rollup",
+				},
+				"pivot": &graphql.ArgumentConfig{
+					Type:        graphql.String,
+					Description: "Turn the values of this group column into columns",
+				},
 			},
 			Resolve: func(table table_info.TableInfo) func(params graphql.ResolveParams) (interface{}, error) {
 
@@ -471,7 +600,7 @@ func MakeGraphqlSchema(cmsConfig *resource.CmsConfig, resources map[string]*reso
 						sessionUser = user.(*auth.SessionUser)
 					}
 
-					transaction, err := resources[table.TableName].Connection().Beginx()
+					transaction, err := resources[table.TableName].BeginReadTransaction(params.Context)
 					if err != nil {
 						resource.CheckErr(err, "Failed to begin transaction [548]")
 						return nil, err
@@ -487,6 +616,7 @@ func MakeGraphqlSchema(cmsConfig *resource.CmsConfig, resources map[string]*reso
 					aggReq := resource.AggregationRequest{}
 
 					aggReq.RootEntity = table.TableName
+					aggReq.Tenant = tenant.FromContext(params.Context)
 
 					if params.Args["group"] != nil {
 						groupBys := params.Args["group"].([]interface{})
@@ -534,6 +664,13 @@ func MakeGraphqlSchema(cmsConfig *resource.CmsConfig, resources map[string]*reso
 						}
 					}
 
+					if params.Args["rollup"] != nil {
+						aggReq.Rollup = params.Args["rollup"].(string)
+					}
+					if params.Args["pivot"] != nil {
+						aggReq.Pivot = params.Args["pivot"].(string)
+					}
+
 					joinTables, err := resources[table.TableName].AggregationJoinTables(aggReq)
 					if err != nil {
 						log.Warnf("invalid GraphQL aggregation request for [%v]: %v", table.TableName, err)
@@ -600,6 +737,12 @@ func MakeGraphqlSchema(cmsConfig *resource.CmsConfig, resources map[string]*reso
 				if col.IsForeignKey {
 					continue
 				}
+				if _, isComputed := table.GetComputedColumn(col.ColumnName); isComputed {
+					continue
+				}
+				if _, isRollup := table.GetRollupColumn(col.ColumnName); isRollup {
+					continue
+				}
 
 				var finalGraphqlType graphql.Type
 				var finalGraphqlType1 graphql.Type
@@ -668,6 +811,10 @@ func MakeGraphqlSchema(cmsConfig *resource.CmsConfig, resources map[string]*reso
 				Type:        graphql.NewNonNull(graphql.String),
 				Description: "Resource id",
 			}
+			updateInputFields["expectedVersion"] = &graphql.ArgumentConfig{
+				Type:        graphql.Int,
+				Description: "Fail unless the resource is still at this version",
+			}
 
 			mutationFields["update"+strcase.ToCamel(table.TableName)] = &graphql.Field{
 				Type:        inputTypesMap[table.TableName],
@@ -730,13 +877,15 @@ func MakeGraphqlSchema(cmsConfig *resource.CmsConfig, resources map[string]*reso
 					}
 
 					delete(args, "reference_id")
+					delete(args, "expectedVersion")
 
 					obj.SetAttributes(args)
-					ur, _ := url.Parse("/api/" + table.TableName)
+					ur, _ := url.Parse("/api/" + table.TableName + "/" + referenceId.String())
 
 					pr := &http.Request{
 						Method: "PATCH",
 						URL:    ur,
+						Header: expectedVersionHeader(params.Args),
 					}
 
 					pr = pr.WithContext(params.Context)
@@ -765,13 +914,18 @@ func MakeGraphqlSchema(cmsConfig *resource.CmsConfig, resources map[string]*reso
 						Type:        graphql.String,
 						Description: "Resource id",
 					},
+					"expectedVersion": &graphql.ArgumentConfig{
+						Type:        graphql.Int,
+						Description: "Fail unless the resource is still at this version",
+					},
 				},
 				Resolve: func(params graphql.ResolveParams) (interface{}, error) {
 
-					ur, _ := url.Parse("/api/" + table.TableName)
+					ur, _ := url.Parse("/api/" + table.TableName + "/" + fmt.Sprintf("%v", params.Args["reference_id"]))
 					pr := &http.Request{
 						Method: "DELETE",
 						URL:    ur,
+						Header: expectedVersionHeader(params.Args),
 					}
 
 					pr = pr.WithContext(params.Context)
@@ -1093,3 +1247,29 @@ func MakeGraphqlSchema(cmsConfig *resource.CmsConfig, resources map[string]*reso
 	//return &schema
 
 }
+
+// expectedVersionHeader turns the expectedVersion argument of a mutation into the If-Match header
+// the resource checks for REST requests
+// findAllArguments adds asOf to the arguments of the find all field of an audited table
+func findAllArguments(table table_info.TableInfo, args graphql.FieldConfigArgument, asOfArgument *graphql.ArgumentConfig) graphql.FieldConfigArgument {
+	if table.IsAuditEnabled {
+		args["asOf"] = asOfArgument
+	}
+	return args
+}
+
+// graphqlHistoryValue shows a column value of the history as a string
+func graphqlHistoryValue(value interface{}) interface{} {
+	if value == nil {
+		return nil
+	}
+	return fmt.Sprint(value)
+}
+
+func expectedVersionHeader(args map[string]interface{}) http.Header {
+	header := http.Header{}
+	if expectedVersion, ok := args["expectedVersion"].(int); ok {
+		header.Set("If-Match", resource.VersionETag(int64(expectedVersion)))
+	}
+	return header
+}
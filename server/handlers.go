@@ -1,15 +1,12 @@
 package server
 
 import (
-	"fmt"
 	"github.com/artpar/api2go/v2"
 	"github.com/daptin/daptin/server/auth"
 	"github.com/daptin/daptin/server/database"
 	"github.com/daptin/daptin/server/fsm"
 	daptinid "github.com/daptin/daptin/server/id"
 	"github.com/daptin/daptin/server/resource"
-	"github.com/daptin/daptin/server/statementbuilder"
-	"github.com/doug-martin/goqu/v9"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	log "github.com/sirupsen/logrus"
@@ -75,8 +72,6 @@ func CreateEventHandler(initConfig *resource.CmsConfig, fsmManager fsm.FsmManage
 			return
 		}
 
-		stateObject := objectStateMachine.GetAttributes()
-
 		var subjectInstanceModel api2go.Api2GoModel
 		//var stateMachineDescriptionInstance *api2go.Api2GoModel
 
@@ -111,43 +106,29 @@ func CreateEventHandler(initConfig *resource.CmsConfig, fsmManager fsm.FsmManage
 			return
 		}
 
-		// Commit transaction BEFORE calling FSM to avoid deadlock
-		err = transaction.Commit()
-		if err != nil {
-			resource.CheckErr(err, "Failed to commit permission check transaction")
-			return
+		// guard, actions, state update, history and audit commit or roll back together
+		newRequest := &http.Request{
+			Method: "POST",
+			URL:    gincontext.Request.URL,
 		}
-
-		nextState, err := fsmManager.ApplyEvent(subjectInstanceModel.GetAllAsAttributes(),
-			fsm.NewStateMachineEvent(daptinid.DaptinReferenceId(stateMachineId), eventName))
-		if err != nil {
-			gincontext.AbortWithError(400, err)
-			return
+		newRequest = newRequest.WithContext(gincontext.Request.Context())
+		req = api2go.Request{
+			PlainRequest: newRequest,
+			QueryParams:  map[string][]string{},
 		}
 
-		// Start new transaction for audit and state update
-		transaction, err = db.Beginx()
+		_, err = cruds[typename_state].ApplyStateTransition(typename, daptinid.DaptinReferenceId(stateMachineId),
+			eventName, resource.StateTransitionFiredByUser, req, transaction)
 		if err != nil {
-			resource.CheckErr(err, "Failed to begin transaction for state update")
+			rollbackErr := transaction.Rollback()
+			resource.CheckErr(rollbackErr, "Failed to rollback")
+			abortWithHTTPError(gincontext, err, 500)
 			return
 		}
-		defer transaction.Commit()
 
 		stateAudit := objectStateMachine.GetAuditModel()
 		creator, ok := cruds[stateAudit.GetTableName()]
 		if ok {
-
-			newRequest := &http.Request{
-				Method: "POST",
-				URL:    gincontext.Request.URL,
-			}
-			newRequest = newRequest.WithContext(gincontext.Request.Context())
-
-			req := api2go.Request{
-				PlainRequest: newRequest,
-				QueryParams:  map[string][]string{},
-			}
-
 			stateAudit.Set("source_reference_id", objectStateMachine.GetReferenceId())
 			stateAudit.Set("operation", resource.AuditOperationStateTransition)
 
@@ -155,32 +136,6 @@ func CreateEventHandler(initConfig *resource.CmsConfig, fsmManager fsm.FsmManage
 			resource.CheckErr(err, "Failed to create audit for [%v]", objectStateMachine.GetTableName())
 		}
 
-		// Get current version, default to 0 if not present
-		versionInt := int64(0)
-		if stateObject["version"] != nil {
-			if v, ok := stateObject["version"].(int64); ok {
-				versionInt = v
-			} else if versionFloat, ok := stateObject["version"].(float64); ok {
-				versionInt = int64(versionFloat)
-			}
-		}
-
-		// Use hex format for WHERE clause since goqu doesn't handle binary properly
-		hexId := fmt.Sprintf("%X", stateMachineId[:])
-		s, v, err := statementbuilder.Squirrel.Update(typename+"_state").
-			Set(goqu.Record{
-				"current_state": nextState,
-				"version":       versionInt + 1,
-			}).
-			Where(goqu.L("reference_id = X'" + hexId + "'")).ToSQL()
-
-		_, err = transaction.Exec(s, v...)
-		if err != nil {
-			transaction.Rollback()
-			gincontext.AbortWithError(500, err)
-			return
-		}
-		// Commit transaction before returning
 		err = transaction.Commit()
 		if err != nil {
 			gincontext.AbortWithError(500, err)
@@ -276,8 +231,157 @@ func CreateEventStartHandler(fsmManager fsm.FsmManager, cruds map[string]*resour
 			return
 		}
 
+		stateReferenceId := daptinid.InterfaceToDIR(resp.Result().(api2go.Api2GoModel).GetID())
+		err = cruds[typename+"_state"].EnterInitialState(typename, stateReferenceId, req, transaction)
+		if err != nil {
+			log.Errorf("Failed to enter initial state of [%v]: %v", typename, err)
+			rollbackErr := transaction.Rollback()
+			resource.CheckErr(rollbackErr, "Failed to rollback")
+			gincontext.AbortWithError(500, err)
+			return
+		}
+
 		gincontext.JSON(200, resp)
 
 	}
 
 }
+
+// CreateStateMachineDescribeHandler serves a state machine as a Graphviz (dot) or mermaid diagram,
+// or the list of problems in its definition (validate)
+func CreateStateMachineDescribeHandler(cruds map[string]*resource.DbResource) func(context *gin.Context) {
+
+	return func(gincontext *gin.Context) {
+
+		pr := &http.Request{
+			Method: "GET",
+			URL:    gincontext.Request.URL,
+		}
+		pr = pr.WithContext(gincontext.Request.Context())
+		req := api2go.Request{
+			PlainRequest: pr,
+			QueryParams:  map[string][]string{},
+		}
+
+		response, err := cruds["smd"].FindOne(gincontext.Param("stateMachineId"), req)
+		if err != nil {
+			abortWithHTTPError(gincontext, err, 404)
+			return
+		}
+
+		machine, err := resource.StateMachineDescriptionFromRow(response.Result().(api2go.Api2GoModel).GetAttributes())
+		if err != nil {
+			gincontext.AbortWithError(500, err)
+			return
+		}
+
+		switch gincontext.Param("format") {
+		case "dot":
+			gincontext.Data(200, "text/vnd.graphviz; charset=utf-8", []byte(machine.Dot()))
+		case "mermaid":
+			gincontext.Data(200, "text/plain; charset=utf-8", []byte(machine.Mermaid()))
+		case "validate":
+			problems := make([]string, 0)
+			for _, problem := range machine.Validate() {
+				problems = append(problems, problem.Error())
+			}
+			gincontext.JSON(200, gin.H{
+				"name":     machine.Name,
+				"valid":    len(problems) == 0,
+				"problems": problems,
+			})
+		default:
+			gincontext.AbortWithStatus(404)
+		}
+	}
+}
+
+// CreateStateMachineStatsHandler counts the objects of a table in each state of a state machine
+func CreateStateMachineStatsHandler(cruds map[string]*resource.DbResource) func(context *gin.Context) {
+
+	return func(gincontext *gin.Context) {
+
+		user := gincontext.Request.Context().Value("user")
+		if user == nil {
+			gincontext.AbortWithStatus(401)
+			return
+		}
+		sessionUser := user.(*auth.SessionUser)
+
+		typename := gincontext.Param("typename")
+		stateCrud, ok := cruds[typename+"_state"]
+		if !ok {
+			gincontext.AbortWithStatus(404)
+			return
+		}
+
+		pr := &http.Request{
+			Method: "GET",
+			URL:    gincontext.Request.URL,
+		}
+		pr = pr.WithContext(gincontext.Request.Context())
+		req := api2go.Request{
+			PlainRequest: pr,
+			QueryParams:  map[string][]string{},
+		}
+		response, err := cruds["smd"].FindOne(gincontext.Param("smd"), req)
+		if err != nil {
+			abortWithHTTPError(gincontext, err, 404)
+			return
+		}
+		machineAttributes := response.Result().(api2go.Api2GoModel).GetAttributes()
+		machine, err := resource.StateMachineDescriptionFromRow(machineAttributes)
+		if err != nil {
+			gincontext.AbortWithError(500, err)
+			return
+		}
+
+		transaction, err := stateCrud.Connection().Beginx()
+		if err != nil {
+			resource.CheckErr(err, "Failed to begin transaction for state stats")
+			gincontext.AbortWithStatus(500)
+			return
+		}
+		defer transaction.Rollback()
+
+		perm := stateCrud.GetObjectPermissionByWhereClause("world", "table_name", typename+"_state", transaction)
+		if !perm.CanExecute(sessionUser.UserReferenceId, sessionUser.Groups, cruds["usergroup"].AdministratorGroupId) ||
+			!perm.CanPeek(sessionUser.UserReferenceId, sessionUser.Groups, cruds["usergroup"].AdministratorGroupId) {
+			log.Infof("user [%v] not allowed to read state stats of [%v]", sessionUser, typename)
+			gincontext.AbortWithStatus(403)
+			return
+		}
+
+		machineId, err := stateCrud.GetReferenceIdToId("smd", daptinid.InterfaceToDIR(machineAttributes["reference_id"]), transaction)
+		if err != nil {
+			gincontext.AbortWithError(404, err)
+			return
+		}
+
+		counts, err := stateCrud.StateCounts(typename, machine, machineId, transaction)
+		if err != nil {
+			log.Errorf("Failed to count states of [%v]: %v", typename, err)
+			gincontext.AbortWithStatus(500)
+			return
+		}
+
+		total := int64(0)
+		for _, count := range counts {
+			total += count
+		}
+		gincontext.JSON(200, gin.H{
+			"typename":      typename,
+			"state_machine": machine.Name,
+			"total":         total,
+			"states":        counts,
+		})
+	}
+}
+
+func abortWithHTTPError(gincontext *gin.Context, err error, defaultStatus int) {
+	if httpErr, ok := err.(api2go.HTTPError); ok {
+		gincontext.AbortWithError(httpErr.Status(), err)
+		return
+	}
+	gincontext.AbortWithError(defaultStatus, err)
+}
@@ -5,17 +5,26 @@ import (
 	"github.com/daptin/daptin/server/constants"
 	daptinid "github.com/daptin/daptin/server/id"
 	"github.com/daptin/daptin/server/subsite"
+	"github.com/daptin/daptin/server/tenant"
 	"github.com/gin-gonic/gin"
 	log "github.com/sirupsen/logrus"
 	"net/http"
 	"strings"
 )
 
+// PreviewRouterProvider serves hostnames which are not in the HandlerMap, like the
+// <deployment>.<site hostname> preview hostnames of site deployments
+type PreviewRouterProvider interface {
+	PreviewRouter(hostName string) (*gin.Engine, subsite.SubSite, bool)
+}
+
 type HostSwitch struct {
 	HandlerMap           map[string]*gin.Engine
 	SiteMap              map[string]subsite.SubSite
 	AuthMiddleware       *auth.AuthMiddleware
 	AdministratorGroupId daptinid.DaptinReferenceId
+	Previews             PreviewRouterProvider
+	Tenants              tenant.Resolver
 }
 
 func (hs HostSwitch) GetHostRouter(name string) *gin.Engine {
@@ -40,6 +49,11 @@ func (hs HostSwitch) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	// Check if a http.Handler is registered for the given host.
 	// If yes, use it to handle the request.
 	hostName := strings.Split(r.Host, ":")[0]
+	if hs.Tenants != nil {
+		if t, ok := hs.Tenants.TenantForHost(hostName); ok {
+			r = r.WithContext(tenant.WithTenant(r.Context(), t))
+		}
+	}
 	pathParts := strings.Split(r.URL.Path, "/")
 	isAPIPath := isWellDefinedAPIPath(r.URL.Path)
 
@@ -48,15 +62,20 @@ func (hs HostSwitch) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if handler := hs.HandlerMap[hostName]; handler != nil && !isAPIPath {
+	handler, subSite := hs.HandlerMap[hostName], hs.SiteMap[hostName]
+	if handler == nil && hs.Previews != nil && !isAPIPath {
+		if previewHandler, previewSite, ok := hs.Previews.PreviewRouter(hostName); ok {
+			handler, subSite = previewHandler, previewSite
+		}
+	}
+
+	if handler != nil && !isAPIPath {
 
 		ok, abort, modifiedRequest := hs.AuthMiddleware.AuthCheckMiddlewareWithHttp(r, w, true)
 		if ok {
 			r = modifiedRequest
 		}
 
-		subSite := hs.SiteMap[hostName]
-
 		permission := subSite.Permission
 		if abort {
 			w.Header().Set("WWW-Authenticate", `Basic realm="`+hostName+`"`)
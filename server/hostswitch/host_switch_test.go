@@ -92,6 +92,51 @@ func TestHostSwitchSubsiteFallbackStillServesAppShell(t *testing.T) {
 	}
 }
 
+type testPreviewRouters map[string]*gin.Engine
+
+func (p testPreviewRouters) PreviewRouter(hostName string) (*gin.Engine, subsite.SubSite, bool) {
+	router, ok := p[hostName]
+	return router, subsite.SubSite{
+		Hostname:   "site.example.test",
+		Permission: permission.PermissionInstance{Permission: auth.GuestExecute},
+	}, ok
+}
+
+func TestHostSwitchServesDeploymentPreviewHostnames(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	previewRouter := gin.New()
+	previewRouter.NoRoute(func(c *gin.Context) {
+		c.Header("X-Test-Router", "preview")
+		c.Data(http.StatusOK, "text/html; charset=UTF-8", []byte("<!doctype html><html><body>preview</body></html>"))
+	})
+	hostSwitch := testHostSwitch()
+	hostSwitch.Previews = testPreviewRouters{"a1b2c3d4e5f6.site.example.test": previewRouter}
+
+	for host, wantRouter := range map[string]string{
+		"a1b2c3d4e5f6.site.example.test": "preview",
+		"site.example.test":              "subsite",
+	} {
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodGet, "/static/app.js", nil)
+		request.Host = host
+
+		hostSwitch.ServeHTTP(recorder, request)
+
+		if recorder.Header().Get("X-Test-Router") != wantRouter {
+			t.Fatalf("host %q routed to %q, want %s", host, recorder.Header().Get("X-Test-Router"), wantRouter)
+		}
+	}
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodGet, "/_config", nil)
+	request.Host = "a1b2c3d4e5f6.site.example.test"
+	hostSwitch.ServeHTTP(recorder, request)
+	if recorder.Header().Get("X-Test-Router") != "dashboard" {
+		t.Fatalf("api path on a preview hostname routed to %q, want dashboard", recorder.Header().Get("X-Test-Router"))
+	}
+}
+
 func testHostSwitch() HostSwitch {
 	dashboardRouter := gin.New()
 	dashboardRouter.POST("/integration/:providerName/*operationName", func(c *gin.Context) {
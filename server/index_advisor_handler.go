@@ -0,0 +1,53 @@
+package server
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/daptin/daptin/server/auth"
+	"github.com/daptin/daptin/server/resource"
+	"github.com/gin-gonic/gin"
+)
+
+// CreateIndexAdvisorHandler lists the indexes missing for the filters observed on find all queries
+// since the server started, to administrators. min_average_ms leaves out the faster queries.
+func CreateIndexAdvisorHandler(cruds map[string]*resource.DbResource) func(*gin.Context) {
+	return func(c *gin.Context) {
+
+		user := c.Request.Context().Value("user")
+		sessionUser := &auth.SessionUser{}
+
+		if user != nil {
+			sessionUser = user.(*auth.SessionUser)
+		}
+
+		transaction, err := cruds[resource.USER_ACCOUNT_TABLE_NAME].Connection().Beginx()
+		if err != nil {
+			resource.CheckErr(err, "Failed to begin transaction [index advisor]")
+			c.AbortWithStatus(500)
+			return
+		}
+		isAdmin := resource.IsAdminWithTransaction(sessionUser, transaction)
+		transaction.Rollback()
+
+		if !isAdmin {
+			c.AbortWithError(403, fmt.Errorf("unauthorized"))
+			return
+		}
+
+		minAverage := time.Duration(0)
+		if value := c.Query("min_average_ms"); value != "" {
+			milliseconds, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				c.AbortWithStatusJSON(400, gin.H{"error": "min_average_ms is not a number"})
+				return
+			}
+			minAverage = time.Duration(milliseconds * float64(time.Millisecond))
+		}
+
+		c.JSON(200, gin.H{
+			"suggestions": resource.IndexSuggestions(cruds, minAverage),
+		})
+	}
+}
@@ -11,6 +11,7 @@ import (
 	daptinid "github.com/daptin/daptin/server/id"
 	"github.com/daptin/daptin/server/resource"
 	"github.com/daptin/daptin/server/table_info"
+	"github.com/daptin/daptin/server/tenant"
 	"github.com/gin-gonic/gin"
 	"github.com/jmoiron/sqlx"
 	log "github.com/sirupsen/logrus"
@@ -68,7 +69,7 @@ func CreateStatsHandler(initConfig *resource.CmsConfig, cruds map[string]*resour
 			return
 		}
 
-		transaction, err := cruds[typeName].Connection().Beginx()
+		transaction, err := cruds[typeName].BeginReadTransaction(c.Request.Context())
 		if err != nil {
 			resource.CheckErr(err, "Failed to begin transaction [65]")
 			return
@@ -106,8 +107,12 @@ func CreateStatsHandler(initConfig *resource.CmsConfig, cruds map[string]*resour
 			aggReq.TimeFrom = c.Query("timefrom")
 			aggReq.TimeTo = c.Query("timeto")
 			aggReq.Order = c.QueryArray("order")
+			aggReq.Rollup = c.Query("rollup")
+			aggReq.Pivot = c.Query("pivot")
 		}
 
+		aggReq.Tenant = tenant.FromContext(c.Request.Context())
+
 		joinTables, err := cruds[typeName].AggregationJoinTables(aggReq)
 		if err != nil {
 			log.Warnf("invalid aggregation request for [%v]: %v", typeName, err)
@@ -229,7 +234,7 @@ func CreateJsModelHandler(initConfig *resource.CmsConfig, cruds map[string]*reso
 		cols := selectedTable.Columns
 
 		//log.Printf("data: %v", selectedTable.Relations)
-		tx, err := cruds["world"].Connection().Beginx()
+		tx, err := cruds["world"].BeginReadTransaction(c.Request.Context())
 		if err != nil {
 			resource.CheckErr(err, "Failed to begin transaction [170]")
 			return
@@ -294,6 +299,32 @@ func CreateJsModelHandler(initConfig *resource.CmsConfig, cruds map[string]*reso
 			}
 		}
 
+		for _, computed := range selectedTable.ComputedColumns {
+			col := computed.ColumnInfo()
+			if existing, ok := selectedTable.GetColumnByName(computed.Name); ok {
+				col = *existing
+			}
+			res[computed.Name] = ComputedColumnModel{
+				ColumnInfo: col,
+				IsComputed: true,
+				IsReadOnly: true,
+				IsVirtual:  computed.IsVirtual(),
+			}
+		}
+
+		for _, rollup := range selectedTable.RollupColumns {
+			col := rollup.ColumnInfo()
+			if existing, ok := selectedTable.GetColumnByName(rollup.Name); ok {
+				col = *existing
+			}
+			res[rollup.Name] = ComputedColumnModel{
+				ColumnInfo: col,
+				IsComputed: true,
+				IsReadOnly: true,
+				IsRollup:   true,
+			}
+		}
+
 		for _, rel := range selectedTable.Relations {
 			//log.Printf("Relation [%v][%v]", selectedTable.TableName, rel.String())
 
@@ -366,6 +397,16 @@ type JsModel struct {
 	IsStateMachineEnabled bool
 }
 
+// ComputedColumnModel is a column of the js model whose value is computed or rolled up from related
+// rows, it cannot be written
+type ComputedColumnModel struct {
+	api2go.ColumnInfo
+	IsComputed bool
+	IsReadOnly bool
+	IsVirtual  bool
+	IsRollup   bool
+}
+
 func NewJsonApiRelation(name string, relationName string, relationType string, columnType string) JsonApiRelation {
 
 	return JsonApiRelation{
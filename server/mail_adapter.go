@@ -17,6 +17,7 @@ import (
 	"github.com/daptin/daptin/server/auth"
 	daptinid "github.com/daptin/daptin/server/id"
 	"github.com/daptin/daptin/server/resource"
+	"github.com/daptin/daptin/server/telemetry"
 	"github.com/emersion/go-message"
 	_ "github.com/emersion/go-message/charset"
 	mailpacket "github.com/emersion/go-message/mail"
@@ -612,6 +613,16 @@ func DaptinSmtpDbResource(dbResource *resource.DbResource, certificateManager *r
 				}
 			}
 
+			// every mail transaction ends in a save mail task
+			saveMail := mailSender
+			mailSender = func(e *mail.Envelope, task backends.SelectTask) (backends.Result, error) {
+				result, err := saveMail(e, task)
+				if task == backends.TaskSaveMail {
+					telemetry.MailSession("smtp", err)
+				}
+				return result, err
+			}
+
 			dbResource.MailSender = mailSender
 
 			return backends.ProcessWith(mailSender)
@@ -135,6 +135,9 @@ func mergeTableConfigIntoExisting(existing table_info.TableInfo, override table_
 	if override.CompositeKeys != nil {
 		existing.CompositeKeys = override.CompositeKeys
 	}
+	if override.Indexes != nil {
+		existing.Indexes = override.Indexes
+	}
 	if !partialOverride || override.Icon != "" || override.ExplicitFields["Icon"] || override.ExplicitFields["icon"] {
 		existing.Icon = override.Icon
 	}
@@ -144,6 +147,30 @@ func mergeTableConfigIntoExisting(existing table_info.TableInfo, override table_
 	if override.Metering != nil {
 		existing.Metering = override.Metering
 	}
+	if override.ResultCache != nil {
+		existing.ResultCache = override.ResultCache
+	}
+	if !partialOverride || override.SoftDelete || override.ExplicitFields["SoftDelete"] || override.ExplicitFields["soft_delete"] {
+		existing.SoftDelete = override.SoftDelete
+	}
+	if !partialOverride || override.SoftDeleteRetentionDays != 0 || override.ExplicitFields["SoftDeleteRetentionDays"] || override.ExplicitFields["soft_delete_retention_days"] {
+		existing.SoftDeleteRetentionDays = override.SoftDeleteRetentionDays
+	}
+	if !partialOverride || override.TenantScoped || override.ExplicitFields["TenantScoped"] || override.ExplicitFields["tenant_scoped"] {
+		existing.TenantScoped = override.TenantScoped
+	}
+	if override.OnDelete != nil {
+		existing.OnDelete = override.OnDelete
+	}
+	if override.Searchable != nil {
+		existing.Searchable = override.Searchable
+	}
+	if override.ComputedColumns != nil {
+		existing.ComputedColumns = override.ComputedColumns
+	}
+	if override.RollupColumns != nil {
+		existing.RollupColumns = override.RollupColumns
+	}
 
 	return existing
 }
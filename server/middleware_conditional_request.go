@@ -0,0 +1,86 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/daptin/daptin/server/resource"
+	"github.com/gin-gonic/gin"
+)
+
+// ConditionalRequestMiddleware exposes the version of a single entity row as its ETag and answers
+// conditional GETs with 304. If-Match and If-None-Match on writes are checked by the resource
+// against the row it updates or deletes.
+func ConditionalRequestMiddleware(c *gin.Context) {
+	if !isEntityRowPath(c.Request.URL.Path) {
+		return
+	}
+	switch c.Request.Method {
+	case http.MethodGet, http.MethodHead, http.MethodPatch:
+	default:
+		return
+	}
+
+	entityVersion := &resource.EntityVersion{}
+	c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), resource.EntityVersionContextKey, entityVersion))
+	c.Writer = &conditionalResponseWriter{
+		ResponseWriter: c.Writer,
+		request:        c.Request,
+		entityVersion:  entityVersion,
+	}
+}
+
+// isEntityRowPath matches /api/<entity>/<id>, relationship and action paths are longer
+func isEntityRowPath(requestPath string) bool {
+	parts := strings.Split(strings.Trim(requestPath, "/"), "/")
+	return len(parts) == 3 && parts[0] == "api" && parts[2] != ""
+}
+
+// conditionalResponseWriter adds the ETag when the response status is written, the version
+// is known by then since the row was loaded before anything is sent
+type conditionalResponseWriter struct {
+	gin.ResponseWriter
+	request       *http.Request
+	entityVersion *resource.EntityVersion
+	headerWritten bool
+	notModified   bool
+}
+
+func (w *conditionalResponseWriter) WriteHeader(code int) {
+	if w.headerWritten {
+		return
+	}
+	w.headerWritten = true
+	if code >= 200 && code < 300 && w.entityVersion.Found {
+		query := w.request.URL.Query()
+		etag := resource.RepresentationETag(w.entityVersion.Version, query)
+		w.Header().Set("ETag", etag)
+		ifNoneMatch := w.request.Header.Get("If-None-Match")
+		// included rows change without a new version of this row, those responses are always sent
+		if code == http.StatusOK && ifNoneMatch != "" && w.request.Method != http.MethodPatch &&
+			query.Get("include") == "" && resource.RepresentationETagMatches(ifNoneMatch, etag) {
+			w.notModified = true
+			w.Header().Del("Content-Type")
+			w.Header().Del("Content-Length")
+			code = http.StatusNotModified
+		}
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *conditionalResponseWriter) Write(data []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	if w.notModified {
+		return len(data), nil
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *conditionalResponseWriter) WriteString(s string) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	if w.notModified {
+		return len(s), nil
+	}
+	return w.ResponseWriter.WriteString(s)
+}
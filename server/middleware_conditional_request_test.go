@@ -0,0 +1,89 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/daptin/daptin/server/resource"
+	"github.com/gin-gonic/gin"
+)
+
+func TestConditionalRequestMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(ConditionalRequestMiddleware)
+	entityHandler := func(c *gin.Context) {
+		if entityVersion, ok := c.Request.Context().Value(resource.EntityVersionContextKey).(*resource.EntityVersion); ok {
+			entityVersion.Version = 4
+			entityVersion.Found = true
+		}
+		c.Writer.Header().Set("Content-Type", "application/vnd.api+json")
+		c.Writer.WriteHeader(http.StatusOK)
+		c.Writer.Write([]byte(`{"data":{}}`))
+	}
+	router.GET("/api/todo/:id", entityHandler)
+	router.PATCH("/api/todo/:id", entityHandler)
+	router.GET("/api/todo", entityHandler)
+
+	serve := func(method string, path string, ifNoneMatch string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(method, path, nil)
+		if ifNoneMatch != "" {
+			request.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		router.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	response := serve(http.MethodGet, "/api/todo/0191f0a4-0000-7000-8000-000000000001", "")
+	if response.Code != http.StatusOK || response.Header().Get("ETag") != `"4"` {
+		t.Fatalf("expected 200 with ETag, got %d %q", response.Code, response.Header().Get("ETag"))
+	}
+
+	response = serve(http.MethodGet, "/api/todo/0191f0a4-0000-7000-8000-000000000001", `W/"4"`)
+	if response.Code != http.StatusNotModified || response.Body.Len() != 0 {
+		t.Fatalf("expected empty 304, got %d %q", response.Code, response.Body.String())
+	}
+
+	response = serve(http.MethodGet, "/api/todo/0191f0a4-0000-7000-8000-000000000001", `"3"`)
+	if response.Code != http.StatusOK || response.Body.Len() == 0 {
+		t.Fatalf("stale ETag answered with %d", response.Code)
+	}
+
+	// the ETag of a write is the version after the update, If-None-Match there is a precondition
+	response = serve(http.MethodPatch, "/api/todo/0191f0a4-0000-7000-8000-000000000001", `"4"`)
+	if response.Code != http.StatusOK || response.Header().Get("ETag") != `"4"` {
+		t.Fatalf("patch answered with %d %q", response.Code, response.Header().Get("ETag"))
+	}
+
+	// other fields are another response of the same version
+	fieldsPath := "/api/todo/0191f0a4-0000-7000-8000-000000000001?fields[todo]=title,done"
+	response = serve(http.MethodGet, fieldsPath, `"4"`)
+	fieldsETag := response.Header().Get("ETag")
+	if response.Code != http.StatusOK || fieldsETag == `"4"` || !strings.HasPrefix(fieldsETag, `"4-`) {
+		t.Fatalf("fields answered with %d %q", response.Code, fieldsETag)
+	}
+	response = serve(http.MethodGet, "/api/todo/0191f0a4-0000-7000-8000-000000000001?fields[todo]=done,title", fieldsETag)
+	if response.Code != http.StatusNotModified {
+		t.Fatalf("the same fields in another order answered with %d", response.Code)
+	}
+	response = serve(http.MethodGet, "/api/todo/0191f0a4-0000-7000-8000-000000000001?fields[todo]=title", fieldsETag)
+	if response.Code != http.StatusOK || response.Header().Get("ETag") == fieldsETag {
+		t.Fatalf("other fields answered with %d %q", response.Code, response.Header().Get("ETag"))
+	}
+
+	// included rows change without a new version of the row
+	includePath := "/api/todo/0191f0a4-0000-7000-8000-000000000001?include=tags"
+	response = serve(http.MethodGet, includePath, "")
+	response = serve(http.MethodGet, includePath, response.Header().Get("ETag"))
+	if response.Code != http.StatusOK || response.Body.Len() == 0 {
+		t.Fatalf("a response including rows answered with %d", response.Code)
+	}
+
+	response = serve(http.MethodGet, "/api/todo", `"4"`)
+	if response.Code != http.StatusOK || response.Header().Get("ETag") != "" {
+		t.Fatalf("collection answered with %d %q", response.Code, response.Header().Get("ETag"))
+	}
+}
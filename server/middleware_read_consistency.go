@@ -0,0 +1,13 @@
+package server
+
+import (
+	"github.com/daptin/daptin/server/database"
+	"github.com/gin-gonic/gin"
+)
+
+// ReadConsistencyMiddleware lets the reads of a request go to a read replica, ?consistency=strong
+// keeps all of them on the primary
+func ReadConsistencyMiddleware(c *gin.Context) {
+	strong := c.Query("consistency") == "strong"
+	c.Request = c.Request.WithContext(database.WithReadConsistency(c.Request.Context(), strong))
+}
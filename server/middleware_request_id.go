@@ -0,0 +1,27 @@
+package server
+
+import (
+	"context"
+
+	"github.com/daptin/daptin/server/resource"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// RequestIdHeader carries the id of a request, taken from the client when it sends one
+const RequestIdHeader = "X-Request-Id"
+
+// maxRequestIdLength keeps ids sent by clients to what audit rows can hold
+const maxRequestIdLength = 64
+
+// RequestIdMiddleware gives every request an id, returned in the X-Request-Id header. Audit rows
+// record it with the changes the request made.
+func RequestIdMiddleware(c *gin.Context) {
+	requestId := c.GetHeader(RequestIdHeader)
+	if requestId == "" || len(requestId) > maxRequestIdLength {
+		u, _ := uuid.NewV7()
+		requestId = u.String()
+	}
+	c.Header(RequestIdHeader, requestId)
+	c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), resource.RequestIdContextKey, requestId))
+}
@@ -0,0 +1,48 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/daptin/daptin/server/resource"
+	"github.com/gin-gonic/gin"
+)
+
+func TestRequestIdMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(RequestIdMiddleware)
+	router.GET("/api/todo", func(c *gin.Context) {
+		requestId, _ := c.Request.Context().Value(resource.RequestIdContextKey).(string)
+		c.String(http.StatusOK, requestId)
+	})
+
+	serve := func(requestId string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodGet, "/api/todo", nil)
+		if requestId != "" {
+			request.Header.Set(RequestIdHeader, requestId)
+		}
+		router.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	response := serve("")
+	generated := response.Header().Get(RequestIdHeader)
+	if generated == "" || response.Body.String() != generated {
+		t.Fatalf("expected a generated request id in the header and the context, got %q and %q", generated, response.Body.String())
+	}
+
+	response = serve("client-42")
+	if response.Header().Get(RequestIdHeader) != "client-42" || response.Body.String() != "client-42" {
+		t.Fatalf("expected the request id of the client, got %q", response.Header().Get(RequestIdHeader))
+	}
+
+	tooLong := strings.Repeat("x", 65)
+	response = serve(tooLong)
+	if response.Header().Get(RequestIdHeader) == tooLong {
+		t.Fatalf("a request id longer than audit rows keep should be replaced")
+	}
+}
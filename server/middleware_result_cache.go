@@ -0,0 +1,144 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/daptin/daptin/server/auth"
+	"github.com/daptin/daptin/server/database"
+	"github.com/daptin/daptin/server/resource"
+	"github.com/gin-gonic/gin"
+)
+
+// ResultCacheMiddleware answers list and aggregate GETs on tables caching their results from the
+// result cache, and caches the results it misses. Writes bump the generation of the tables they
+// changed once the request is done. Cache-Control: no-cache and ?consistency=strong skip the lookup.
+// Results which are cached are read from the primary.
+func ResultCacheMiddleware(cruds map[string]*resource.DbResource) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.Method != http.MethodGet {
+			c.Request = c.Request.WithContext(resource.WithTableChanges(c.Request.Context()))
+			c.Next()
+			resource.BumpChangedTables(c.Request.Context())
+			return
+		}
+
+		kind, tableName, ok := resultCachePath(c.Request.URL.Path)
+		if !ok {
+			return
+		}
+		dbResource, ok := cruds[tableName]
+		if !ok {
+			return
+		}
+		ttl := dbResource.ResultCacheTtl()
+		if ttl == 0 {
+			return
+		}
+
+		sessionUser, _ := c.Request.Context().Value("user").(*auth.SessionUser)
+		key, ok := dbResource.ResultCacheKey(c.Request.Context(), kind, c.Request.URL.Query(), sessionUser)
+		if !ok {
+			return
+		}
+
+		skipLookup := strings.Contains(c.GetHeader("Cache-Control"), "no-cache") || c.Query("consistency") == "strong"
+		if !skipLookup {
+			if cached, found := resource.GetCachedResult(c.Request.Context(), tableName, key); found {
+				age := time.Since(cached.StoredAt)
+				setResultCacheHeaders(c.Writer.Header(), ttl, age)
+				c.Data(cached.Status, cached.ContentType, cached.Body)
+				c.Abort()
+				return
+			}
+		}
+
+		// a result to be cached is read from the primary, a replica can still be behind the
+		// generation the result is cached under
+		c.Request = c.Request.WithContext(database.WithReadConsistency(c.Request.Context(), true))
+		writer := &resultCacheWriter{ResponseWriter: c.Writer, ttl: ttl}
+		c.Writer = writer
+		c.Next()
+
+		if writer.Status() == http.StatusOK && !writer.overflow {
+			resource.PutCachedResult(c.Request.Context(), key, resource.CachedResult{
+				Status:      http.StatusOK,
+				ContentType: writer.Header().Get("Content-Type"),
+				Body:        writer.body,
+				StoredAt:    time.Now(),
+			}, ttl)
+		}
+	}
+}
+
+// resultCachePath matches the list path /api/<entity> and the aggregate path /aggregate/<entity>
+func resultCachePath(requestPath string) (string, string, bool) {
+	parts := strings.Split(strings.Trim(requestPath, "/"), "/")
+	if len(parts) != 2 || parts[1] == "" {
+		return "", "", false
+	}
+	switch parts[0] {
+	case "api":
+		return "list", parts[1], true
+	case "aggregate":
+		return "aggregate", parts[1], true
+	}
+	return "", "", false
+}
+
+// setResultCacheHeaders lets clients keep a result for what is left of its ttl, the result is read
+// with the permissions of the user so only private caches can keep it
+func setResultCacheHeaders(header http.Header, ttl time.Duration, age time.Duration) {
+	maxAge := ttl - age
+	if maxAge < 0 {
+		maxAge = 0
+	}
+	header.Set("Cache-Control", fmt.Sprintf("private, max-age=%d", int(maxAge.Seconds())))
+	header.Set("Age", fmt.Sprintf("%d", int(age.Seconds())))
+}
+
+// resultCacheWriter keeps a copy of the response body to cache it, a body larger than what is
+// cached is not kept
+type resultCacheWriter struct {
+	gin.ResponseWriter
+	ttl           time.Duration
+	body          []byte
+	overflow      bool
+	headerWritten bool
+}
+
+func (w *resultCacheWriter) WriteHeader(code int) {
+	if !w.headerWritten {
+		w.headerWritten = true
+		if code == http.StatusOK {
+			setResultCacheHeaders(w.Header(), w.ttl, 0)
+		}
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *resultCacheWriter) keep(data []byte) {
+	if w.overflow {
+		return
+	}
+	if len(w.body)+len(data) > resource.MaxCachedResultBytes {
+		w.overflow = true
+		w.body = nil
+		return
+	}
+	w.body = append(w.body, data...)
+}
+
+func (w *resultCacheWriter) Write(data []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	w.keep(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *resultCacheWriter) WriteString(s string) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	w.keep([]byte(s))
+	return w.ResponseWriter.WriteString(s)
+}
@@ -0,0 +1,43 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestResultCachePath(t *testing.T) {
+	cases := []struct {
+		path      string
+		kind      string
+		tableName string
+		ok        bool
+	}{
+		{"/api/book", "list", "book", true},
+		{"/aggregate/book", "aggregate", "book", true},
+		{"/api/book/", "list", "book", true},
+		{"/api/book/0191a4c4-3f5e-7b52-9d35-1c4a9f0e2b11", "", "", false},
+		{"/api/book/0191a4c4-3f5e-7b52-9d35-1c4a9f0e2b11/author", "", "", false},
+		{"/action/book/publish", "", "", false},
+		{"/api", "", "", false},
+	}
+	for _, c := range cases {
+		kind, tableName, ok := resultCachePath(c.path)
+		if kind != c.kind || tableName != c.tableName || ok != c.ok {
+			t.Errorf("[%s] expected %q %q %v, got %q %q %v", c.path, c.kind, c.tableName, c.ok, kind, tableName, ok)
+		}
+	}
+}
+
+func TestSetResultCacheHeaders(t *testing.T) {
+	header := http.Header{}
+	setResultCacheHeaders(header, time.Minute, 20*time.Second)
+	if header.Get("Cache-Control") != "private, max-age=40" || header.Get("Age") != "20" {
+		t.Errorf("unexpected headers %v", header)
+	}
+
+	setResultCacheHeaders(header, time.Minute, 2*time.Minute)
+	if header.Get("Cache-Control") != "private, max-age=0" {
+		t.Errorf("an expired result should not be kept by the client, got %v", header.Get("Cache-Control"))
+	}
+}
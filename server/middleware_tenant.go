@@ -0,0 +1,55 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/daptin/daptin/server/auth"
+	"github.com/daptin/daptin/server/resource"
+	"github.com/daptin/daptin/server/tenant"
+	"github.com/gin-gonic/gin"
+)
+
+// TenantMiddleware keeps the users of a tenant on the hostnames of their tenant. A user signed in on
+// the hostnames of another tenant, or of no tenant, is refused. Users are of no tenant when user
+// accounts are not tenant scoped. Administrators are let through on every hostname.
+func TenantMiddleware(cruds map[string]*resource.DbResource) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		sessionUser, ok := c.Request.Context().Value("user").(*auth.SessionUser)
+		if !ok || sessionUser == nil || sessionUser.UserId == 0 {
+			return
+		}
+		userAccount, ok := cruds[resource.USER_ACCOUNT_TABLE_NAME]
+		if !ok {
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		requestTenant := tenant.FromContext(c.Request.Context())
+		if requestTenant == nil && (userAccount.TableInfo() == nil || !userAccount.TableInfo().TenantScoped) {
+			// a user of no tenant on the hostnames of no tenant
+			return
+		}
+
+		transaction, err := userAccount.BeginReadTransaction(c.Request.Context())
+		if err != nil {
+			resource.CheckErr(err, "Failed to begin transaction [tenant]")
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		userTenantId, err := userAccount.UserTenantId(sessionUser.UserReferenceId, transaction)
+		isAdmin := err == nil && resource.IsAdminWithTransaction(sessionUser, transaction)
+		transaction.Rollback()
+		if err != nil {
+			resource.CheckErr(err, "Failed to get the tenant of user [%v]", sessionUser.UserReferenceId)
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+
+		var requestTenantId int64
+		if requestTenant != nil {
+			requestTenantId = requestTenant.Id
+		}
+		if userTenantId != requestTenantId && !isAdmin {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "user belongs to another tenant"})
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package resource
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+
+	"github.com/daptin/daptin/server/actionresponse"
+	"github.com/gin-gonic/gin"
+)
+
+// MultipartFilesContextKey holds the *MultipartFiles of an action request sent as multipart/form-data
+const MultipartFilesContextKey = "multipart_files"
+
+// maxMultipartFieldBytes is the size of the largest form field read into the action attributes
+const maxMultipartFieldBytes = 1 << 20
+
+// MultipartFiles are the file parts of a multipart/form-data action request. They are read from the
+// request body one after the other as the action asks for them, never held in memory.
+type MultipartFiles struct {
+	reader  *multipart.Reader
+	pending *multipart.Part
+}
+
+// Next returns the next file part of the body, false after the last one. The part is read up to its
+// end before the next one is returned. Form fields after the first file are skipped.
+func (files *MultipartFiles) Next() (*multipart.Part, bool, error) {
+	if files.pending != nil {
+		part := files.pending
+		files.pending = nil
+		return part, true, nil
+	}
+	for {
+		part, err := files.reader.NextPart()
+		if err == io.EOF {
+			return nil, false, nil
+		}
+		if err != nil {
+			return nil, false, err
+		}
+		if part.FileName() != "" {
+			return part, true, nil
+		}
+	}
+}
+
+// MultipartFilesFromRequest returns the file parts of the action request, nil when it was not sent
+// as multipart/form-data
+func MultipartFilesFromRequest(request *http.Request) *MultipartFiles {
+	if request == nil {
+		return nil
+	}
+	files, _ := request.Context().Value(MultipartFilesContextKey).(*MultipartFiles)
+	return files
+}
+
+// isMultipartRequest tells whether the body of the request is multipart/form-data
+func isMultipartRequest(request *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(request.Header.Get("Content-Type"))
+	return err == nil && mediaType == "multipart/form-data"
+}
+
+// BuildMultipartActionRequest reads the form fields of a multipart/form-data action request up to the
+// first file into the attributes. The files are left in the body and returned to be read as a stream,
+// so form fields have to come before the files.
+func BuildMultipartActionRequest(request *http.Request, actionType, actionName string,
+	params gin.Params, queryParams url.Values) (actionresponse.ActionRequest, *MultipartFiles, error) {
+
+	actionRequest := actionresponse.ActionRequest{
+		Type:       actionType,
+		Action:     actionName,
+		Attributes: make(map[string]interface{}),
+	}
+	reader, err := request.MultipartReader()
+	if err != nil {
+		return actionRequest, nil, err
+	}
+
+	files := &MultipartFiles{reader: reader}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return actionRequest, nil, err
+		}
+		if part.FileName() != "" {
+			files.pending = part
+			break
+		}
+		value, err := io.ReadAll(io.LimitReader(part, maxMultipartFieldBytes+1))
+		if err != nil {
+			return actionRequest, nil, err
+		}
+		if len(value) > maxMultipartFieldBytes {
+			return actionRequest, nil, fmt.Errorf("form field [%v] is larger than %d bytes", part.FormName(), maxMultipartFieldBytes)
+		}
+		name := part.FormName()
+		if existing, ok := actionRequest.Attributes[name]; ok {
+			values, isList := existing.([]string)
+			if !isList {
+				values = []string{existing.(string)}
+			}
+			actionRequest.Attributes[name] = append(values, string(value))
+		} else {
+			actionRequest.Attributes[name] = string(value)
+		}
+	}
+
+	for _, param := range params {
+		actionRequest.Attributes[param.Key] = param.Value
+	}
+	for key, valueArray := range queryParams {
+		if len(valueArray) == 1 {
+			actionRequest.Attributes[key] = valueArray[0]
+		} else {
+			actionRequest.Attributes[key] = valueArray
+		}
+	}
+	return actionRequest, files, nil
+}
+
+// withMultipartFiles makes the file parts available to the actions through the request context
+func withMultipartFiles(ctx context.Context, files *MultipartFiles) context.Context {
+	if files == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, MultipartFilesContextKey, files)
+}
@@ -0,0 +1,61 @@
+package resource
+
+import (
+	"bytes"
+	"io"
+	"mime/multipart"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestBuildMultipartActionRequest(t *testing.T) {
+	body := &bytes.Buffer{}
+	form := multipart.NewWriter(body)
+	form.WriteField("table_name", "product")
+	form.WriteField("tag", "a")
+	form.WriteField("tag", "b")
+	first, _ := form.CreateFormFile("dump_file", "products.csv")
+	first.Write([]byte("name\nchair\n"))
+	form.WriteField("ignored", "after the first file")
+	second, _ := form.CreateFormFile("dump_file", "more.csv")
+	second.Write([]byte("name\ntable\n"))
+	form.Close()
+
+	request := httptest.NewRequest("POST", "/action/world/import_data", body)
+	request.Header.Set("Content-Type", form.FormDataContentType())
+	if !isMultipartRequest(request) {
+		t.Fatalf("expected a multipart request")
+	}
+
+	actionRequest, files, err := BuildMultipartActionRequest(request, "world", "import_data",
+		gin.Params{{Key: "typename", Value: "world"}}, url.Values{"background": {"true"}})
+	if err != nil {
+		t.Fatalf("build action request: %v", err)
+	}
+	attributes := actionRequest.Attributes
+	if attributes["table_name"] != "product" || attributes["typename"] != "world" || attributes["background"] != "true" {
+		t.Errorf("expected the form fields, params and query in the attributes, got %v", attributes)
+	}
+	if tags, ok := attributes["tag"].([]string); !ok || len(tags) != 2 {
+		t.Errorf("expected both values of a repeated field, got %v", attributes["tag"])
+	}
+
+	contents := make(map[string]string)
+	for {
+		part, ok, err := files.Next()
+		if err != nil {
+			t.Fatalf("next file: %v", err)
+		}
+		if !ok {
+			break
+		}
+		data, _ := io.ReadAll(part)
+		contents[part.FileName()] = string(data)
+	}
+	if len(contents) != 2 || contents["products.csv"] != "name\nchair\n" || contents["more.csv"] != "name\ntable\n" {
+		t.Errorf("expected both files from the body, got %v", contents)
+	}
+}
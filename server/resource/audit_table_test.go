@@ -50,7 +50,7 @@ func TestCheckAuditTablesGeneratesSafeSchema(t *testing.T) {
 			t.Errorf("sensitive column %q was copied to audit schema", excluded)
 		}
 	}
-	for _, required := range []string{"name", "project_id", "source_reference_id", "operation"} {
+	for _, required := range []string{"name", "project_id", "source_reference_id", "operation", AuditChangedByColumn, AuditRequestIdColumn, AuditSourceCreatedAtColumn} {
 		if _, exists := columns[required]; !exists {
 			t.Errorf("required column %q missing from audit schema", required)
 		}
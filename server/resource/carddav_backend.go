@@ -0,0 +1,771 @@
+package resource
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/artpar/api2go/v2"
+	"github.com/daptin/daptin/server/auth"
+	daptinid "github.com/daptin/daptin/server/id"
+	"github.com/daptin/daptin/server/statementbuilder"
+	"github.com/doug-martin/goqu/v9"
+	"github.com/emersion/go-vcard"
+	"github.com/emersion/go-webdav"
+	"github.com/emersion/go-webdav/carddav"
+	"github.com/jmoiron/sqlx"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// CarddavPrefix is the mount point of the CardDAV handler
+	CarddavPrefix = "/carddav"
+
+	AddressBookTypePersonal  = "personal"
+	AddressBookTypeCollected = "collected"
+
+	carddavSyncTokenPrefix = "https://daptin.com/ns/carddav/sync/"
+)
+
+// Path structure:
+// /carddav/{user_reference_id}/                                   principal
+// /carddav/{user_reference_id}/contacts/                          address book home set
+// /carddav/{user_reference_id}/contacts/{address_book_reference}/ address book
+// /carddav/{user_reference_id}/contacts/{address_book_reference}/{resource_name}.vcf
+
+var contactSelectColumns = []interface{}{
+	"id", "reference_id", "resource_name", "contact_uid", "full_name", "given_name", "family_name", "nickname",
+	"organization", "job_title", "birthday", "note", "emails", "phones", "addresses", "photo",
+	"vcard_version", "vcard", "sync_revision", "created_at", "updated_at",
+}
+
+// DaptinCarddavBackend implements carddav.Backend for one authenticated user.
+// Pattern: like DaptinCaldavFileSystem (caldav_filesystem.go), a backend is created per request after authentication
+type DaptinCarddavBackend struct {
+	cruds       map[string]*DbResource
+	sessionUser *auth.SessionUser
+	// addressBook is the book addressed by the request path, nil for principal and home set requests
+	addressBook *CarddavAddressBook
+}
+
+// CarddavAddressBook is an address_book row with the permission of the current user on it
+type CarddavAddressBook struct {
+	Id           int64
+	ReferenceId  daptinid.DaptinReferenceId
+	Name         string
+	Description  string
+	BookType     string
+	SyncRevision int64
+	CanWrite     bool
+}
+
+// NewCarddavBackend creates the CardDAV backend for an authenticated user
+func NewCarddavBackend(cruds map[string]*DbResource, sessionUser *auth.SessionUser) *DaptinCarddavBackend {
+	return &DaptinCarddavBackend{
+		cruds:       cruds,
+		sessionUser: sessionUser,
+	}
+}
+
+// BindRequestPath resolves the address book referenced by a request path. Requests above the
+// address book level are bound to the personal address book of the user, which is created on first use.
+func (b *DaptinCarddavBackend) BindRequestPath(ctx context.Context, requestPath string) error {
+	transaction, err := b.cruds["address_book"].Connection().Beginx()
+	if err != nil {
+		return err
+	}
+
+	book, err := b.addressBookForRequestPath(ctx, requestPath, transaction)
+	if err != nil {
+		transaction.Rollback()
+		return err
+	}
+	if err = transaction.Commit(); err != nil {
+		return err
+	}
+	b.addressBook = book
+	return nil
+}
+
+func (b *DaptinCarddavBackend) addressBookForRequestPath(ctx context.Context, requestPath string, transaction *sqlx.Tx) (*CarddavAddressBook, error) {
+	_, bookReference, _ := b.parsePath(requestPath)
+	if bookReference == "" {
+		book, err := getOrCreateAddressBook(ctx, b.cruds, b.sessionUser.UserId, AddressBookTypePersonal, transaction)
+		if err != nil {
+			return nil, err
+		}
+		book.CanWrite = true
+		return book, nil
+	}
+
+	bookReferenceId := daptinid.InterfaceToDIR(bookReference)
+	if bookReferenceId == daptinid.NullReferenceId {
+		return nil, webdav.NewHTTPError(http.StatusNotFound, fmt.Errorf("address book not found [%v]", bookReference))
+	}
+
+	return b.loadAddressBook(goqu.Ex{"reference_id": bookReferenceId[:]}, transaction)
+}
+
+// ListAddressBooks returns the books owned by the user and the books shared with one of the user's groups
+func (b *DaptinCarddavBackend) ListAddressBooks(ctx context.Context) ([]*CarddavAddressBook, error) {
+	transaction, err := b.cruds["address_book"].Connection().Beginx()
+	if err != nil {
+		return nil, err
+	}
+
+	books, err := b.listAddressBooks(ctx, transaction)
+	if err != nil {
+		transaction.Rollback()
+		return nil, err
+	}
+	if err = transaction.Commit(); err != nil {
+		return nil, err
+	}
+	return books, nil
+}
+
+func (b *DaptinCarddavBackend) listAddressBooks(ctx context.Context, transaction *sqlx.Tx) ([]*CarddavAddressBook, error) {
+	if _, err := getOrCreateAddressBook(ctx, b.cruds, b.sessionUser.UserId, AddressBookTypePersonal, transaction); err != nil {
+		return nil, err
+	}
+
+	books, err := b.queryAddressBooks(goqu.Ex{USER_ACCOUNT_ID_COLUMN: b.sessionUser.UserId}, transaction)
+	if err != nil {
+		return nil, err
+	}
+
+	groupReferenceIds := make([]interface{}, 0)
+	for _, group := range b.sessionUser.Groups {
+		groupReferenceIds = append(groupReferenceIds, group.GroupReferenceId[:])
+	}
+	if len(groupReferenceIds) == 0 {
+		return books, nil
+	}
+
+	query, args, err := statementbuilder.Squirrel.Select(goqu.I("uug.address_book_id")).Distinct().Prepared(true).
+		From(goqu.T("address_book_address_book_id_has_usergroup_usergroup_id").As("uug")).
+		Join(goqu.T("usergroup").As("ug"), goqu.On(goqu.Ex{"uug.usergroup_id": goqu.I("ug.id")})).
+		Where(goqu.Ex{"ug.reference_id": groupReferenceIds}).ToSQL()
+	if err != nil {
+		return nil, err
+	}
+
+	sharedIds := make([]int64, 0)
+	if err = transaction.Select(&sharedIds, query, args...); err != nil {
+		return nil, err
+	}
+
+	seen := make(map[int64]bool)
+	for _, book := range books {
+		seen[book.Id] = true
+	}
+	for _, sharedId := range sharedIds {
+		if seen[sharedId] {
+			continue
+		}
+		book, err := b.loadAddressBook(goqu.Ex{"id": sharedId}, transaction)
+		if err != nil {
+			// not readable by this user
+			continue
+		}
+		books = append(books, book)
+	}
+
+	return books, nil
+}
+
+// CurrentUserPrincipal implements webdav.UserPrincipalBackend
+func (b *DaptinCarddavBackend) CurrentUserPrincipal(ctx context.Context) (string, error) {
+	return CarddavPrefix + "/" + b.sessionUser.UserReferenceId.String() + "/", nil
+}
+
+// AddressbookHomeSetPath implements carddav.Backend
+func (b *DaptinCarddavBackend) AddressbookHomeSetPath(ctx context.Context) (string, error) {
+	return CarddavPrefix + "/" + b.sessionUser.UserReferenceId.String() + "/contacts/", nil
+}
+
+// AddressBookPath returns the collection path of an address book as seen by the current user
+func (b *DaptinCarddavBackend) AddressBookPath(book *CarddavAddressBook) string {
+	return CarddavPrefix + "/" + b.sessionUser.UserReferenceId.String() + "/contacts/" + book.ReferenceId.String() + "/"
+}
+
+// BoundAddressBook returns the address book resolved by BindRequestPath
+func (b *DaptinCarddavBackend) BoundAddressBook() *CarddavAddressBook {
+	return b.addressBook
+}
+
+// SyncToken builds the opaque sync token announced for an address book revision
+func (b *DaptinCarddavBackend) SyncToken(book *CarddavAddressBook) string {
+	return carddavSyncTokenPrefix + strconv.FormatInt(book.SyncRevision, 10)
+}
+
+// AddressBook implements carddav.Backend
+func (b *DaptinCarddavBackend) AddressBook(ctx context.Context) (*carddav.AddressBook, error) {
+	if b.addressBook == nil {
+		return nil, webdav.NewHTTPError(http.StatusNotFound, errors.New("address book not found"))
+	}
+	return &carddav.AddressBook{
+		Path:        b.AddressBookPath(b.addressBook),
+		Name:        b.addressBook.Name,
+		Description: b.addressBook.Description,
+	}, nil
+}
+
+// GetAddressObject implements carddav.Backend
+func (b *DaptinCarddavBackend) GetAddressObject(ctx context.Context, objectPath string, req *carddav.AddressDataRequest) (*carddav.AddressObject, error) {
+	book, resourceName, err := b.addressBookForObjectPath(objectPath)
+	if err != nil {
+		return nil, err
+	}
+
+	transaction, err := b.cruds["contact"].Connection().Beginx()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := queryContactRows(goqu.Ex{
+		"address_book_id": book.Id,
+		"resource_name":   resourceName,
+		"removed_at":      nil,
+	}, transaction)
+	if err != nil {
+		transaction.Rollback()
+		return nil, err
+	}
+	if err = transaction.Commit(); err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, webdav.NewHTTPError(http.StatusNotFound, fmt.Errorf("contact not found [%v]", resourceName))
+	}
+
+	return b.addressObjectFromRow(book, rows[0])
+}
+
+// ListAddressObjects implements carddav.Backend
+func (b *DaptinCarddavBackend) ListAddressObjects(ctx context.Context, req *carddav.AddressDataRequest) ([]carddav.AddressObject, error) {
+	if b.addressBook == nil {
+		return nil, webdav.NewHTTPError(http.StatusNotFound, errors.New("address book not found"))
+	}
+
+	transaction, err := b.cruds["contact"].Connection().Beginx()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := queryContactRows(goqu.Ex{
+		"address_book_id": b.addressBook.Id,
+		"removed_at":      nil,
+	}, transaction)
+	if err != nil {
+		transaction.Rollback()
+		return nil, err
+	}
+	if err = transaction.Commit(); err != nil {
+		return nil, err
+	}
+
+	addressObjects := make([]carddav.AddressObject, 0, len(rows))
+	for _, row := range rows {
+		addressObject, err := b.addressObjectFromRow(b.addressBook, row)
+		if err != nil {
+			log.Errorf("[carddav] failed to build vcard for contact [%v]: %v", row["contact_uid"], err)
+			continue
+		}
+		addressObjects = append(addressObjects, *addressObject)
+	}
+	return addressObjects, nil
+}
+
+// QueryAddressObjects implements carddav.Backend, filters are evaluated on the generated vCards
+func (b *DaptinCarddavBackend) QueryAddressObjects(ctx context.Context, query *carddav.AddressBookQuery) ([]carddav.AddressObject, error) {
+	addressObjects, err := b.ListAddressObjects(ctx, &query.DataRequest)
+	if err != nil {
+		return nil, err
+	}
+	return carddav.Filter(query, addressObjects)
+}
+
+// PutAddressObject implements carddav.Backend
+func (b *DaptinCarddavBackend) PutAddressObject(ctx context.Context, objectPath string, card vcard.Card, opts *carddav.PutAddressObjectOptions) (string, error) {
+	book, resourceName, err := b.addressBookForObjectPath(objectPath)
+	if err != nil {
+		return "", err
+	}
+	if !book.CanWrite {
+		return "", webdav.NewHTTPError(http.StatusForbidden, errors.New("address book is read only"))
+	}
+
+	row, photo, err := ContactRowFromVCard(card)
+	if err != nil {
+		return "", webdav.NewHTTPError(http.StatusBadRequest, err)
+	}
+
+	transaction, err := b.cruds["contact"].Connection().Beginx()
+	if err != nil {
+		return "", err
+	}
+
+	// removed contacts are matched too, so re-creating a deleted resource revives its row
+	existing, err := queryContactRows(goqu.Ex{
+		"address_book_id": book.Id,
+		"resource_name":   resourceName,
+	}, transaction, "removed_at")
+	if err != nil {
+		transaction.Rollback()
+		return "", err
+	}
+	live := make([]map[string]interface{}, 0, 1)
+	for _, existingRow := range existing {
+		if existingRow["removed_at"] == nil {
+			live = append(live, existingRow)
+		}
+	}
+
+	if opts != nil {
+		if err = checkCarddavPreconditions(b, book, live, opts); err != nil {
+			transaction.Rollback()
+			return "", err
+		}
+	}
+
+	if photo != nil {
+		row["photo"] = ToJson(ContactPhotoAssetValue(row["contact_uid"].(string), photo))
+	} else {
+		row["photo"] = nil
+	}
+
+	var existingRow map[string]interface{}
+	if len(existing) > 0 {
+		existingRow = existing[0]
+	}
+	err = upsertContactRow(ctx, b.cruds, book, existingRow, resourceName, row, transaction)
+	if err != nil {
+		transaction.Rollback()
+		return "", err
+	}
+
+	if err = transaction.Commit(); err != nil {
+		return "", err
+	}
+
+	return b.AddressBookPath(book) + resourceName, nil
+}
+
+// DeleteAddressObject implements carddav.Backend. The row is kept with removed_at set so the removal
+// can be reported to clients syncing the address book.
+func (b *DaptinCarddavBackend) DeleteAddressObject(ctx context.Context, objectPath string) error {
+	book, resourceName, err := b.addressBookForObjectPath(objectPath)
+	if err != nil {
+		return err
+	}
+	if !book.CanWrite {
+		return webdav.NewHTTPError(http.StatusForbidden, errors.New("address book is read only"))
+	}
+
+	transaction, err := b.cruds["contact"].Connection().Beginx()
+	if err != nil {
+		return err
+	}
+
+	live, err := queryContactRows(goqu.Ex{
+		"address_book_id": book.Id,
+		"resource_name":   resourceName,
+		"removed_at":      nil,
+	}, transaction)
+	if err != nil {
+		transaction.Rollback()
+		return err
+	}
+	if len(live) == 0 {
+		transaction.Rollback()
+		return webdav.NewHTTPError(http.StatusNotFound, fmt.Errorf("contact not found [%v]", resourceName))
+	}
+
+	revision, err := bumpAddressBookRevision(ctx, b.cruds, book, transaction)
+	if err != nil {
+		transaction.Rollback()
+		return err
+	}
+
+	err = updateContactRow(ctx, b.cruds, live[0], map[string]interface{}{
+		"removed_at":    time.Now(),
+		"sync_revision": revision,
+	}, transaction)
+	if err != nil {
+		transaction.Rollback()
+		return err
+	}
+
+	return transaction.Commit()
+}
+
+// SyncAddressObjects returns the contacts changed and removed since the given sync token. An empty
+// token returns the full address book.
+func (b *DaptinCarddavBackend) SyncAddressObjects(syncToken string) (*carddav.SyncResponse, error) {
+	if b.addressBook == nil {
+		return nil, webdav.NewHTTPError(http.StatusNotFound, errors.New("address book not found"))
+	}
+
+	var sinceRevision int64
+	if syncToken != "" {
+		if !strings.HasPrefix(syncToken, carddavSyncTokenPrefix) {
+			return nil, webdav.NewHTTPError(http.StatusForbidden, fmt.Errorf("invalid sync token [%v]", syncToken))
+		}
+		revision, err := strconv.ParseInt(strings.TrimPrefix(syncToken, carddavSyncTokenPrefix), 10, 64)
+		if err != nil || revision > b.addressBook.SyncRevision {
+			return nil, webdav.NewHTTPError(http.StatusForbidden, fmt.Errorf("invalid sync token [%v]", syncToken))
+		}
+		sinceRevision = revision
+	}
+
+	transaction, err := b.cruds["contact"].Connection().Beginx()
+	if err != nil {
+		return nil, err
+	}
+
+	where := goqu.Ex{"address_book_id": b.addressBook.Id}
+	if syncToken != "" {
+		where["sync_revision"] = goqu.Op{"gt": sinceRevision}
+	} else {
+		where["removed_at"] = nil
+	}
+
+	rows, err := queryContactRows(where, transaction, "removed_at")
+	if err != nil {
+		transaction.Rollback()
+		return nil, err
+	}
+	if err = transaction.Commit(); err != nil {
+		return nil, err
+	}
+
+	response := &carddav.SyncResponse{
+		SyncToken: b.SyncToken(b.addressBook),
+		Updated:   make([]carddav.AddressObject, 0),
+		Deleted:   make([]string, 0),
+	}
+	for _, row := range rows {
+		if row["removed_at"] != nil {
+			response.Deleted = append(response.Deleted, b.AddressBookPath(b.addressBook)+contactRowString(row, "resource_name"))
+			continue
+		}
+		addressObject, err := b.addressObjectFromRow(b.addressBook, row)
+		if err != nil {
+			log.Errorf("[carddav] failed to build vcard for contact [%v]: %v", row["contact_uid"], err)
+			continue
+		}
+		response.Updated = append(response.Updated, *addressObject)
+	}
+
+	return response, nil
+}
+
+// parsePath splits a CardDAV path into the user, address book and resource name parts
+func (b *DaptinCarddavBackend) parsePath(requestPath string) (userReference, bookReference, resourceName string) {
+	cleaned := strings.Trim(strings.TrimPrefix(path.Clean(requestPath), CarddavPrefix), "/")
+	parts := strings.Split(cleaned, "/")
+	if len(parts) > 0 {
+		userReference = parts[0]
+	}
+	if len(parts) > 2 && parts[1] == "contacts" {
+		bookReference = parts[2]
+	}
+	if len(parts) > 3 {
+		resourceName = parts[3]
+	}
+	return
+}
+
+func (b *DaptinCarddavBackend) addressBookForObjectPath(objectPath string) (*CarddavAddressBook, string, error) {
+	userReference, bookReference, resourceName := b.parsePath(objectPath)
+	if userReference != b.sessionUser.UserReferenceId.String() || resourceName == "" {
+		return nil, "", webdav.NewHTTPError(http.StatusNotFound, fmt.Errorf("invalid contact path [%v]", objectPath))
+	}
+	if b.addressBook == nil || b.addressBook.ReferenceId.String() != bookReference {
+		return nil, "", webdav.NewHTTPError(http.StatusNotFound, fmt.Errorf("address book not found [%v]", bookReference))
+	}
+	return b.addressBook, resourceName, nil
+}
+
+func (b *DaptinCarddavBackend) addressObjectFromRow(book *CarddavAddressBook, row map[string]interface{}) (*carddav.AddressObject, error) {
+	card, err := VCardFromContactRow(row)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err = vcard.NewEncoder(&buf).Encode(card); err != nil {
+		return nil, err
+	}
+
+	modTime := toTime(row["updated_at"])
+	if modTime.IsZero() {
+		modTime = toTime(row["created_at"])
+	}
+
+	return &carddav.AddressObject{
+		Path:          b.AddressBookPath(book) + contactRowString(row, "resource_name"),
+		ModTime:       modTime,
+		ContentLength: int64(buf.Len()),
+		ETag:          GetMD5Hash(buf.Bytes()),
+		Card:          card,
+	}, nil
+}
+
+// loadAddressBook reads a single address book and checks the current user can read it
+func (b *DaptinCarddavBackend) loadAddressBook(where goqu.Ex, transaction *sqlx.Tx) (*CarddavAddressBook, error) {
+	books, err := b.queryAddressBooks(where, transaction)
+	if err != nil {
+		return nil, err
+	}
+	if len(books) == 0 {
+		return nil, webdav.NewHTTPError(http.StatusNotFound, errors.New("address book not found"))
+	}
+	book := books[0]
+
+	if book.CanWrite {
+		return book, nil
+	}
+
+	bookPermission := b.cruds["address_book"].GetObjectPermissionByIdWithTransaction("address_book", book.Id, transaction)
+	adminGroupId := b.cruds["address_book"].AdministratorGroupId
+	if !bookPermission.CanRead(b.sessionUser.UserReferenceId, b.sessionUser.Groups, adminGroupId) {
+		return nil, webdav.NewHTTPError(http.StatusNotFound, errors.New("address book not found"))
+	}
+	book.CanWrite = bookPermission.CanUpdate(b.sessionUser.UserReferenceId, b.sessionUser.Groups, adminGroupId)
+	return book, nil
+}
+
+// queryAddressBooks reads address book rows, books owned by the current user are writable
+func (b *DaptinCarddavBackend) queryAddressBooks(where goqu.Ex, transaction *sqlx.Tx) ([]*CarddavAddressBook, error) {
+	rows, err := queryAddressBookRows(where, transaction)
+	if err != nil {
+		return nil, err
+	}
+
+	books := make([]*CarddavAddressBook, 0, len(rows))
+	for _, row := range rows {
+		book := addressBookFromRow(row)
+		book.CanWrite = toInt64(row[USER_ACCOUNT_ID_COLUMN]) == b.sessionUser.UserId
+		books = append(books, book)
+	}
+	return books, nil
+}
+
+// checkCarddavPreconditions evaluates If-Match / If-None-Match of a PUT against the stored contact
+func checkCarddavPreconditions(b *DaptinCarddavBackend, book *CarddavAddressBook, existing []map[string]interface{}, opts *carddav.PutAddressObjectOptions) error {
+	currentETag := ""
+	if len(existing) > 0 {
+		addressObject, err := b.addressObjectFromRow(book, existing[0])
+		if err != nil {
+			return err
+		}
+		currentETag = addressObject.ETag
+	}
+
+	if opts.IfNoneMatch.IsSet() {
+		if opts.IfNoneMatch.IsWildcard() && currentETag != "" {
+			return webdav.NewHTTPError(http.StatusPreconditionFailed, errors.New("contact already exists"))
+		}
+		if etag, err := opts.IfNoneMatch.ETag(); err == nil && etag != "" && etag == currentETag {
+			return webdav.NewHTTPError(http.StatusPreconditionFailed, errors.New("contact has not changed"))
+		}
+	}
+
+	if opts.IfMatch.IsSet() {
+		if currentETag == "" {
+			return webdav.NewHTTPError(http.StatusPreconditionFailed, errors.New("contact does not exist"))
+		}
+		if opts.IfMatch.IsWildcard() {
+			return nil
+		}
+		etag, err := opts.IfMatch.ETag()
+		if err != nil {
+			return webdav.NewHTTPError(http.StatusBadRequest, err)
+		}
+		if etag != currentETag {
+			return webdav.NewHTTPError(http.StatusPreconditionFailed, errors.New("contact was modified"))
+		}
+	}
+
+	return nil
+}
+
+func addressBookFromRow(row map[string]interface{}) *CarddavAddressBook {
+	return &CarddavAddressBook{
+		Id:           toInt64(row["id"]),
+		ReferenceId:  daptinid.InterfaceToDIR(row["reference_id"]),
+		Name:         contactRowString(row, "name"),
+		Description:  contactRowString(row, "description"),
+		BookType:     contactRowString(row, "book_type"),
+		SyncRevision: toInt64(row["sync_revision"]),
+	}
+}
+
+func queryAddressBookRows(where goqu.Ex, transaction *sqlx.Tx) ([]map[string]interface{}, error) {
+	query, args, err := statementbuilder.Squirrel.
+		Select("id", "reference_id", "name", "description", "book_type", "sync_revision", USER_ACCOUNT_ID_COLUMN).
+		From("address_book").
+		Where(where).
+		Order(goqu.I("id").Asc()).
+		Prepared(true).
+		ToSQL()
+	if err != nil {
+		return nil, err
+	}
+	return queryRowsAsMaps(query, args, transaction)
+}
+
+func queryContactRows(where goqu.Ex, transaction *sqlx.Tx, extraColumns ...interface{}) ([]map[string]interface{}, error) {
+	query, args, err := statementbuilder.Squirrel.
+		Select(append(append([]interface{}{}, contactSelectColumns...), extraColumns...)...).
+		From("contact").
+		Where(where).
+		Order(goqu.I("id").Asc()).
+		Prepared(true).
+		ToSQL()
+	if err != nil {
+		return nil, err
+	}
+	return queryRowsAsMaps(query, args, transaction)
+}
+
+func queryRowsAsMaps(query string, args []interface{}, transaction *sqlx.Tx) ([]map[string]interface{}, error) {
+	stmt, err := transaction.Preparex(query)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.Queryx(args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make([]map[string]interface{}, 0)
+	for rows.Next() {
+		row := make(map[string]interface{})
+		if err = rows.MapScan(row); err != nil {
+			return nil, err
+		}
+		result = append(result, row)
+	}
+	return result, rows.Err()
+}
+
+// carddavRequest builds the request rows are written with over CardDAV. Writes go through the address_book
+// and contact resources so permissions, audit rows, events and usergroup rows are handled as for the API.
+// ctx carries the user the rows are written as.
+func carddavRequest(ctx context.Context, method string, tableName string) api2go.Request {
+	requestUrl, _ := url.Parse("/api/" + tableName)
+	httpRequest := &http.Request{
+		Method: method,
+		URL:    requestUrl,
+	}
+	return api2go.Request{PlainRequest: httpRequest.WithContext(ctx)}
+}
+
+// getOrCreateAddressBook returns the personal or collected address book of a user, creating it on first use
+func getOrCreateAddressBook(ctx context.Context, cruds map[string]*DbResource, userId int64, bookType string, transaction *sqlx.Tx) (*CarddavAddressBook, error) {
+	where := goqu.Ex{
+		USER_ACCOUNT_ID_COLUMN: userId,
+		"book_type":            bookType,
+	}
+	rows, err := queryAddressBookRows(where, transaction)
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) > 0 {
+		return addressBookFromRow(rows[0]), nil
+	}
+
+	name := "Contacts"
+	if bookType == AddressBookTypeCollected {
+		name = "Collected addresses"
+	}
+
+	_, err = cruds["address_book"].CreateWithTransaction(api2go.NewApi2GoModelWithData("address_book", nil, 0, nil, map[string]interface{}{
+		"name":          name,
+		"book_type":     bookType,
+		"sync_revision": 0,
+	}), carddavRequest(ctx, "POST", "address_book"), transaction)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err = queryAddressBookRows(where, transaction)
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("failed to create %v address book for user [%v]", bookType, userId)
+	}
+	return addressBookFromRow(rows[0]), nil
+}
+
+// bumpAddressBookRevision increments the sync revision of an address book and returns the new value.
+// Every write to a contact bumps the revision of its book.
+func bumpAddressBookRevision(ctx context.Context, cruds map[string]*DbResource, book *CarddavAddressBook, transaction *sqlx.Tx) (int64, error) {
+	rows, err := queryAddressBookRows(goqu.Ex{"id": book.Id}, transaction)
+	if err != nil {
+		return 0, err
+	}
+	if len(rows) == 0 {
+		return 0, fmt.Errorf("address book not found [%v]", book.ReferenceId)
+	}
+
+	// the update is conditional on the row version, a concurrent bump fails instead of reusing the revision
+	revision := toInt64(rows[0]["sync_revision"]) + 1
+	model := api2go.NewApi2GoModelWithData("address_book", nil, 0, nil, nil)
+	model.SetID(book.ReferenceId.String())
+	model.SetAttributes(map[string]interface{}{
+		"sync_revision": revision,
+	})
+	if _, err = cruds["address_book"].UpdateWithTransaction(model, carddavRequest(ctx, "PATCH", "address_book"), transaction); err != nil {
+		return 0, err
+	}
+	return revision, nil
+}
+
+// updateContactRow writes attributes to an existing contact row
+func updateContactRow(ctx context.Context, cruds map[string]*DbResource, existing map[string]interface{}, attributes map[string]interface{}, transaction *sqlx.Tx) error {
+	model := api2go.NewApi2GoModelWithData("contact", nil, 0, nil, nil)
+	model.SetID(daptinid.InterfaceToDIR(existing["reference_id"]).String())
+	model.SetAttributes(attributes)
+	_, err := cruds["contact"].UpdateWithTransaction(model, carddavRequest(ctx, "PATCH", "contact"), transaction)
+	return err
+}
+
+// upsertContactRow writes the columns produced by ContactRowFromVCard, updating existing when it is not nil
+func upsertContactRow(ctx context.Context, cruds map[string]*DbResource, book *CarddavAddressBook, existing map[string]interface{}, resourceName string, row map[string]interface{}, transaction *sqlx.Tx) error {
+	revision, err := bumpAddressBookRevision(ctx, cruds, book, transaction)
+	if err != nil {
+		return err
+	}
+
+	attributes := make(map[string]interface{}, len(row)+4)
+	for key, value := range row {
+		attributes[key] = value
+	}
+	attributes["resource_name"] = resourceName
+	attributes["sync_revision"] = revision
+	attributes["removed_at"] = nil
+
+	if existing != nil {
+		return updateContactRow(ctx, cruds, existing, attributes, transaction)
+	}
+
+	attributes["address_book_id"] = book.ReferenceId.String()
+	_, err = cruds["contact"].CreateWithTransaction(api2go.NewApi2GoModelWithData("contact", nil, 0, nil, attributes),
+		carddavRequest(ctx, "POST", "contact"), transaction)
+	return err
+}
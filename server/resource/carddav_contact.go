@@ -0,0 +1,551 @@
+package resource
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"mime"
+	"net/mail"
+	"strings"
+
+	"github.com/daptin/daptin/server/auth"
+	daptinid "github.com/daptin/daptin/server/id"
+	"github.com/doug-martin/goqu/v9"
+	"github.com/emersion/go-vcard"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	log "github.com/sirupsen/logrus"
+)
+
+// ContactEmail is one EMAIL entry of a vCard as stored in contact.emails
+type ContactEmail struct {
+	Address   string   `json:"address"`
+	Types     []string `json:"types,omitempty"`
+	Preferred bool     `json:"preferred,omitempty"`
+}
+
+// ContactPhone is one TEL entry of a vCard as stored in contact.phones
+type ContactPhone struct {
+	Number    string   `json:"number"`
+	Types     []string `json:"types,omitempty"`
+	Preferred bool     `json:"preferred,omitempty"`
+}
+
+// ContactAddress is one ADR entry of a vCard as stored in contact.addresses
+type ContactAddress struct {
+	PostOfficeBox   string   `json:"post_office_box,omitempty"`
+	ExtendedAddress string   `json:"extended_address,omitempty"`
+	StreetAddress   string   `json:"street_address,omitempty"`
+	Locality        string   `json:"locality,omitempty"`
+	Region          string   `json:"region,omitempty"`
+	PostalCode      string   `json:"postal_code,omitempty"`
+	Country         string   `json:"country,omitempty"`
+	Types           []string `json:"types,omitempty"`
+}
+
+// ContactPhoto is the decoded PHOTO property of a vCard
+type ContactPhoto struct {
+	MimeType string
+	Data     []byte
+}
+
+// contactPhotoExtensions maps photo mime types to the file name used in the asset column
+var contactPhotoExtensions = map[string]string{
+	"image/jpeg": "jpg",
+	"image/png":  "png",
+	"image/gif":  "gif",
+	"image/webp": "webp",
+}
+
+// ContactRowFromVCard flattens a vCard (version 3.0 or 4.0) into the columns of the contact table.
+// The full card is kept in the vcard column so properties which are not mapped survive a round trip.
+func ContactRowFromVCard(card vcard.Card) (map[string]interface{}, *ContactPhoto, error) {
+	uid := strings.TrimSpace(card.Value(vcard.FieldUID))
+	if uid == "" {
+		return nil, nil, fmt.Errorf("vcard has no UID")
+	}
+
+	row := map[string]interface{}{
+		"contact_uid":   uid,
+		"full_name":     card.PreferredValue(vcard.FieldFormattedName),
+		"nickname":      card.PreferredValue(vcard.FieldNickname),
+		"organization":  card.PreferredValue(vcard.FieldOrganization),
+		"job_title":     card.PreferredValue(vcard.FieldTitle),
+		"birthday":      card.Value(vcard.FieldBirthday),
+		"note":          card.Value(vcard.FieldNote),
+		"vcard_version": card.Value(vcard.FieldVersion),
+	}
+
+	if name := card.Name(); name != nil {
+		row["given_name"] = name.GivenName
+		row["family_name"] = name.FamilyName
+	} else {
+		row["given_name"] = ""
+		row["family_name"] = ""
+	}
+
+	if row["full_name"] == "" {
+		row["full_name"] = strings.TrimSpace(fmt.Sprintf("%v %v", row["given_name"], row["family_name"]))
+	}
+
+	emails := make([]ContactEmail, 0)
+	for _, field := range card[vcard.FieldEmail] {
+		value := strings.TrimPrefix(strings.TrimSpace(field.Value), "mailto:")
+		if value == "" {
+			continue
+		}
+		emails = append(emails, ContactEmail{
+			Address:   value,
+			Types:     contactFieldTypes(field),
+			Preferred: contactFieldIsPreferred(field),
+		})
+	}
+
+	phones := make([]ContactPhone, 0)
+	for _, field := range card[vcard.FieldTelephone] {
+		value := strings.TrimPrefix(strings.TrimSpace(field.Value), "tel:")
+		if value == "" {
+			continue
+		}
+		phones = append(phones, ContactPhone{
+			Number:    value,
+			Types:     contactFieldTypes(field),
+			Preferred: contactFieldIsPreferred(field),
+		})
+	}
+
+	addresses := make([]ContactAddress, 0)
+	for _, address := range card.Addresses() {
+		addresses = append(addresses, ContactAddress{
+			PostOfficeBox:   address.PostOfficeBox,
+			ExtendedAddress: address.ExtendedAddress,
+			StreetAddress:   address.StreetAddress,
+			Locality:        address.Locality,
+			Region:          address.Region,
+			PostalCode:      address.PostalCode,
+			Country:         address.Country,
+			Types:           contactFieldTypes(address.Field),
+		})
+	}
+
+	row["emails"] = ToJson(emails)
+	row["phones"] = ToJson(phones)
+	row["addresses"] = ToJson(addresses)
+
+	var photo *ContactPhoto
+	if photoField := card.Get(vcard.FieldPhoto); photoField != nil {
+		photo = contactPhotoFromField(photoField)
+	}
+
+	var buf bytes.Buffer
+	if err := vcard.NewEncoder(&buf).Encode(card); err != nil {
+		return nil, nil, err
+	}
+	row["vcard"] = buf.String()
+
+	return row, photo, nil
+}
+
+// VCardFromContactRow builds the vCard served over CardDAV for a contact row. The stored card is
+// used as the base and the mapped columns are applied on top, so edits made through the JSON API
+// are reflected in the card.
+func VCardFromContactRow(row map[string]interface{}) (vcard.Card, error) {
+	card := make(vcard.Card)
+
+	if raw := contactRowString(row, "vcard"); strings.TrimSpace(raw) != "" {
+		decoded, err := vcard.NewDecoder(strings.NewReader(raw)).Decode()
+		if err != nil {
+			return nil, err
+		}
+		card = decoded
+	}
+
+	version := contactRowString(row, "vcard_version")
+	if version == "" {
+		version = card.Value(vcard.FieldVersion)
+	}
+	if version == "" {
+		version = "3.0"
+	}
+	card.SetValue(vcard.FieldVersion, version)
+	card.SetValue(vcard.FieldUID, contactRowString(row, "contact_uid"))
+
+	fullName := contactRowString(row, "full_name")
+	givenName := contactRowString(row, "given_name")
+	familyName := contactRowString(row, "family_name")
+	if fullName == "" {
+		fullName = strings.TrimSpace(givenName + " " + familyName)
+	}
+	card.SetValue(vcard.FieldFormattedName, fullName)
+
+	name := card.Name()
+	if name == nil {
+		name = &vcard.Name{}
+	}
+	name.GivenName = givenName
+	name.FamilyName = familyName
+	card.SetName(name)
+
+	setOrDeleteContactValue(card, vcard.FieldNickname, contactRowString(row, "nickname"))
+	setOrDeleteContactValue(card, vcard.FieldOrganization, contactRowString(row, "organization"))
+	setOrDeleteContactValue(card, vcard.FieldTitle, contactRowString(row, "job_title"))
+	setOrDeleteContactValue(card, vcard.FieldBirthday, contactRowString(row, "birthday"))
+	setOrDeleteContactValue(card, vcard.FieldNote, contactRowString(row, "note"))
+
+	var emails []ContactEmail
+	if err := contactRowJson(row, "emails", &emails); err != nil {
+		return nil, err
+	}
+	delete(card, vcard.FieldEmail)
+	for _, email := range emails {
+		card.Add(vcard.FieldEmail, contactField(email.Address, email.Types, email.Preferred))
+	}
+
+	var phones []ContactPhone
+	if err := contactRowJson(row, "phones", &phones); err != nil {
+		return nil, err
+	}
+	delete(card, vcard.FieldTelephone)
+	for _, phone := range phones {
+		card.Add(vcard.FieldTelephone, contactField(phone.Number, phone.Types, phone.Preferred))
+	}
+
+	var addresses []ContactAddress
+	if err := contactRowJson(row, "addresses", &addresses); err != nil {
+		return nil, err
+	}
+	delete(card, vcard.FieldAddress)
+	for _, address := range addresses {
+		card.AddAddress(&vcard.Address{
+			Field:           contactField("", address.Types, false),
+			PostOfficeBox:   address.PostOfficeBox,
+			ExtendedAddress: address.ExtendedAddress,
+			StreetAddress:   address.StreetAddress,
+			Locality:        address.Locality,
+			Region:          address.Region,
+			PostalCode:      address.PostalCode,
+			Country:         address.Country,
+		})
+	}
+
+	if card.Get(vcard.FieldPhoto) == nil {
+		if photo := contactPhotoFromAssetColumn(row["photo"]); photo != nil {
+			if version == "4.0" {
+				card.SetValue(vcard.FieldPhoto, "data:"+photo.MimeType+";base64,"+base64.StdEncoding.EncodeToString(photo.Data))
+			} else {
+				params := vcard.Params{}
+				params.Set("ENCODING", "b")
+				params.Set(vcard.ParamType, strings.ToUpper(contactPhotoExtensions[photo.MimeType]))
+				card.Set(vcard.FieldPhoto, &vcard.Field{
+					Value:  base64.StdEncoding.EncodeToString(photo.Data),
+					Params: params,
+				})
+			}
+		}
+	}
+
+	return card, nil
+}
+
+// ContactPhotoAssetValue converts a vCard photo into the file list stored in the contact.photo asset column
+func ContactPhotoAssetValue(uid string, photo *ContactPhoto) []interface{} {
+	extension, ok := contactPhotoExtensions[photo.MimeType]
+	if !ok {
+		extension = "bin"
+	}
+	return []interface{}{
+		map[string]interface{}{
+			"name":     uid + "." + extension,
+			"type":     photo.MimeType,
+			"contents": "data:" + photo.MimeType + ";base64," + base64.StdEncoding.EncodeToString(photo.Data),
+			"md5":      GetMD5Hash(photo.Data),
+			"size":     len(photo.Data),
+			"path":     "",
+		},
+	}
+}
+
+// contactPhotoFromField decodes both the vCard 3 (ENCODING=b;TYPE=JPEG) and the vCard 4 (data: uri) forms
+func contactPhotoFromField(field *vcard.Field) *ContactPhoto {
+	value := strings.TrimSpace(field.Value)
+	if value == "" {
+		return nil
+	}
+
+	if strings.HasPrefix(value, "data:") {
+		commaIndex := strings.Index(value, ",")
+		if commaIndex == -1 {
+			return nil
+		}
+		header := value[len("data:"):commaIndex]
+		if !strings.HasSuffix(header, ";base64") {
+			return nil
+		}
+		mimeType, _, err := mime.ParseMediaType(strings.TrimSuffix(header, ";base64"))
+		if err != nil {
+			return nil
+		}
+		data, err := base64.StdEncoding.DecodeString(value[commaIndex+1:])
+		if err != nil {
+			return nil
+		}
+		return &ContactPhoto{MimeType: mimeType, Data: data}
+	}
+
+	encoding := strings.ToLower(field.Params.Get("ENCODING"))
+	if encoding != "b" && encoding != "base64" {
+		// a url to an externally hosted photo, nothing to store
+		return nil
+	}
+
+	data, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return nil
+	}
+
+	mimeType := "image/jpeg"
+	photoType := strings.ToLower(field.Params.Get(vcard.ParamType))
+	for knownMimeType, extension := range contactPhotoExtensions {
+		if photoType == extension || photoType == strings.TrimPrefix(knownMimeType, "image/") {
+			mimeType = knownMimeType
+		}
+	}
+
+	return &ContactPhoto{MimeType: mimeType, Data: data}
+}
+
+// contactPhotoFromAssetColumn reads back a photo stored inline in the asset column
+func contactPhotoFromAssetColumn(value interface{}) *ContactPhoto {
+	var files []map[string]interface{}
+	switch typed := value.(type) {
+	case []map[string]interface{}:
+		files = typed
+	case string:
+		if err := json.Unmarshal([]byte(typed), &files); err != nil {
+			return nil
+		}
+	case []byte:
+		if err := json.Unmarshal(typed, &files); err != nil {
+			return nil
+		}
+	default:
+		return nil
+	}
+
+	for _, file := range files {
+		contents, ok := file["contents"].(string)
+		if !ok || contents == "" {
+			continue
+		}
+		field := &vcard.Field{Value: contents}
+		if !strings.HasPrefix(contents, "data:") {
+			field.Params = vcard.Params{}
+			field.Params.Set("ENCODING", "b")
+			if fileType, ok := file["type"].(string); ok {
+				field.Params.Set(vcard.ParamType, strings.TrimPrefix(fileType, "image/"))
+			}
+		}
+		if photo := contactPhotoFromField(field); photo != nil {
+			return photo
+		}
+	}
+	return nil
+}
+
+func contactFieldTypes(field *vcard.Field) []string {
+	if field == nil {
+		return nil
+	}
+	types := make([]string, 0)
+	for _, fieldType := range field.Params.Types() {
+		fieldType = strings.ToLower(strings.TrimSpace(fieldType))
+		if fieldType == "" || fieldType == "pref" {
+			continue
+		}
+		types = append(types, fieldType)
+	}
+	return types
+}
+
+func contactFieldIsPreferred(field *vcard.Field) bool {
+	if field.Params.Get(vcard.ParamPreferred) != "" {
+		return true
+	}
+	for _, fieldType := range field.Params.Types() {
+		if strings.EqualFold(fieldType, "pref") {
+			return true
+		}
+	}
+	return false
+}
+
+func contactField(value string, types []string, preferred bool) *vcard.Field {
+	params := vcard.Params{}
+	for _, fieldType := range types {
+		params.Add(vcard.ParamType, fieldType)
+	}
+	if preferred {
+		params.Set(vcard.ParamPreferred, "1")
+	}
+	return &vcard.Field{Value: value, Params: params}
+}
+
+func setOrDeleteContactValue(card vcard.Card, key string, value string) {
+	if value == "" {
+		delete(card, key)
+		return
+	}
+	card.SetValue(key, value)
+}
+
+func contactRowString(row map[string]interface{}, key string) string {
+	switch value := row[key].(type) {
+	case nil:
+		return ""
+	case string:
+		return value
+	case []byte:
+		return string(value)
+	default:
+		return fmt.Sprintf("%v", value)
+	}
+}
+
+func contactRowJson(row map[string]interface{}, key string, target interface{}) error {
+	switch value := row[key].(type) {
+	case nil:
+		return nil
+	case string:
+		if strings.TrimSpace(value) == "" {
+			return nil
+		}
+		return json.Unmarshal([]byte(value), target)
+	case []byte:
+		if len(value) == 0 {
+			return nil
+		}
+		return json.Unmarshal(value, target)
+	default:
+		// already decoded by the json column type
+		return json.Unmarshal([]byte(ToJson(value)), target)
+	}
+}
+
+// AddCollectedContacts records the recipients of a mail sent from senderAddress in the "collected addresses"
+// book of the user owning the sender mail account. Recipients already present in any address book of that
+// user are skipped. The contacts are written as that user inside a savepoint, so a failure leaves the rest of
+// the transaction usable.
+func (dbResource *DbResource) AddCollectedContacts(ctx context.Context, senderAddress string, recipients []string, transaction *sqlx.Tx) error {
+	if transaction == nil {
+		return errors.New("collecting addresses requires a transaction")
+	}
+
+	senderAddress, err := normalizedMailAddress(senderAddress)
+	if err != nil {
+		return err
+	}
+
+	mailAccount, err := dbResource.GetUserMailAccountRowByEmail(senderAddress, transaction)
+	if err != nil {
+		// mail sent from an address which is not a local mail account
+		return nil
+	}
+
+	userCrud := dbResource.Cruds[USER_ACCOUNT_TABLE_NAME]
+	if userCrud == nil {
+		return errors.New("user_account resource is not configured")
+	}
+
+	user, _, err := getMailAccountUserRow(userCrud, mailAccount[USER_ACCOUNT_ID_COLUMN], transaction)
+	if err != nil || user == nil {
+		return fmt.Errorf("failed to get user account for sender [%s]: %w", senderAddress, err)
+	}
+
+	userId, ok := user["id"].(int64)
+	if !ok || userId == 0 {
+		return fmt.Errorf("invalid user id for sender [%s]", senderAddress)
+	}
+
+	sessionUser := &auth.SessionUser{
+		UserId:          userId,
+		UserReferenceId: daptinid.InterfaceToDIR(user["reference_id"]),
+		Groups:          userCrud.GetObjectUserGroupsByWhereWithTransaction(USER_ACCOUNT_TABLE_NAME, transaction, "id", userId),
+	}
+
+	if _, err = transaction.Exec("SAVEPOINT collect_contacts"); err != nil {
+		return err
+	}
+	err = dbResource.collectContacts(context.WithValue(ctx, "user", sessionUser), senderAddress, recipients, transaction)
+	if err != nil {
+		if _, rollbackErr := transaction.Exec("ROLLBACK TO SAVEPOINT collect_contacts"); rollbackErr != nil {
+			log.Errorf("[carddav] failed to roll back collected addresses: %v", rollbackErr)
+		}
+		return err
+	}
+	_, err = transaction.Exec("RELEASE SAVEPOINT collect_contacts")
+	return err
+}
+
+func (dbResource *DbResource) collectContacts(ctx context.Context, senderAddress string, recipients []string, transaction *sqlx.Tx) error {
+	userId := ctx.Value("user").(*auth.SessionUser).UserId
+
+	var collectedBook *CarddavAddressBook
+	for _, recipientValue := range recipients {
+		recipient, err := mail.ParseAddress(recipientValue)
+		if err != nil {
+			log.Warnf("[carddav] not collecting invalid address [%v]: %v", recipientValue, err)
+			continue
+		}
+		address := strings.ToLower(strings.TrimSpace(recipient.Address))
+		if address == "" || address == senderAddress {
+			continue
+		}
+
+		known, err := queryContactRows(goqu.Ex{
+			USER_ACCOUNT_ID_COLUMN: userId,
+			"removed_at":           nil,
+			"emails":               goqu.Op{"like": "%\"" + address + "\"%"},
+		}, transaction)
+		if err != nil {
+			return err
+		}
+		if len(known) > 0 {
+			continue
+		}
+
+		if collectedBook == nil {
+			collectedBook, err = getOrCreateAddressBook(ctx, dbResource.Cruds, userId, AddressBookTypeCollected, transaction)
+			if err != nil {
+				return err
+			}
+		}
+
+		uid := uuid.New().String()
+		card := make(vcard.Card)
+		card.SetValue(vcard.FieldVersion, "3.0")
+		card.SetValue(vcard.FieldUID, uid)
+		fullName := strings.TrimSpace(recipient.Name)
+		if fullName == "" {
+			fullName = address
+		}
+		card.SetValue(vcard.FieldFormattedName, fullName)
+		card.SetName(&vcard.Name{GivenName: fullName})
+		card.SetValue(vcard.FieldEmail, address)
+
+		row, _, err := ContactRowFromVCard(card)
+		if err != nil {
+			return err
+		}
+		row["is_collected"] = true
+
+		err = upsertContactRow(ctx, dbResource.Cruds, collectedBook, nil, uid+".vcf", row, transaction)
+		if err != nil {
+			return err
+		}
+		log.Printf("[carddav] collected address [%v] for user [%v]", address, userId)
+	}
+
+	return nil
+}
@@ -0,0 +1,141 @@
+package resource
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/emersion/go-vcard"
+)
+
+const testVCard3 = "BEGIN:VCARD\r\n" +
+	"VERSION:3.0\r\n" +
+	"UID:4f1c3a2e-contact\r\n" +
+	"FN:Ada Lovelace\r\n" +
+	"N:Lovelace;Ada;;;\r\n" +
+	"ORG:Analytical Engines\r\n" +
+	"EMAIL;TYPE=work,pref:ada@example.com\r\n" +
+	"EMAIL;TYPE=home:ada@home.example.com\r\n" +
+	"TEL;TYPE=cell:+44 20 7946 0000\r\n" +
+	"ADR;TYPE=home:;;12 St James Square;London;;SW1Y 4JH;United Kingdom\r\n" +
+	"PHOTO;ENCODING=b;TYPE=PNG:iVBORw0KGgo=\r\n" +
+	"X-CUSTOM:kept\r\n" +
+	"END:VCARD\r\n"
+
+func decodeTestCard(t *testing.T, raw string) vcard.Card {
+	card, err := vcard.NewDecoder(strings.NewReader(raw)).Decode()
+	if err != nil {
+		t.Fatalf("failed to decode test vcard: %v", err)
+	}
+	return card
+}
+
+func TestContactRowFromVCard(t *testing.T) {
+	row, photo, err := ContactRowFromVCard(decodeTestCard(t, testVCard3))
+	if err != nil {
+		t.Fatalf("ContactRowFromVCard failed: %v", err)
+	}
+
+	expected := map[string]string{
+		"contact_uid":   "4f1c3a2e-contact",
+		"full_name":     "Ada Lovelace",
+		"given_name":    "Ada",
+		"family_name":   "Lovelace",
+		"organization":  "Analytical Engines",
+		"vcard_version": "3.0",
+	}
+	for column, value := range expected {
+		if row[column] != value {
+			t.Errorf("expected %v to be [%v], got [%v]", column, value, row[column])
+		}
+	}
+
+	var emails []ContactEmail
+	if err := contactRowJson(row, "emails", &emails); err != nil {
+		t.Fatalf("emails column is not valid json: %v", err)
+	}
+	if len(emails) != 2 || emails[0].Address != "ada@example.com" || !emails[0].Preferred {
+		t.Errorf("unexpected emails: %+v", emails)
+	}
+	if len(emails[0].Types) != 1 || emails[0].Types[0] != "work" {
+		t.Errorf("expected pref to be dropped from email types, got %v", emails[0].Types)
+	}
+
+	var addresses []ContactAddress
+	if err := contactRowJson(row, "addresses", &addresses); err != nil {
+		t.Fatalf("addresses column is not valid json: %v", err)
+	}
+	if len(addresses) != 1 || addresses[0].Locality != "London" || addresses[0].PostalCode != "SW1Y 4JH" {
+		t.Errorf("unexpected addresses: %+v", addresses)
+	}
+
+	if photo == nil || photo.MimeType != "image/png" || len(photo.Data) == 0 {
+		t.Fatalf("expected png photo to be extracted, got %+v", photo)
+	}
+}
+
+func TestContactRowFromVCardRequiresUID(t *testing.T) {
+	card := make(vcard.Card)
+	card.SetValue(vcard.FieldVersion, "4.0")
+	card.SetValue(vcard.FieldFormattedName, "No Uid")
+	if _, _, err := ContactRowFromVCard(card); err == nil {
+		t.Fatal("expected a vcard without UID to be rejected")
+	}
+}
+
+func TestVCardFromContactRowAppliesColumnEdits(t *testing.T) {
+	row, _, err := ContactRowFromVCard(decodeTestCard(t, testVCard3))
+	if err != nil {
+		t.Fatalf("ContactRowFromVCard failed: %v", err)
+	}
+
+	// an edit made through the json api
+	row["family_name"] = "King"
+	row["full_name"] = "Ada King"
+	row["phones"] = ToJson([]ContactPhone{{Number: "+44 20 7946 0001", Types: []string{"work"}}})
+
+	card, err := VCardFromContactRow(row)
+	if err != nil {
+		t.Fatalf("VCardFromContactRow failed: %v", err)
+	}
+
+	if card.PreferredValue(vcard.FieldFormattedName) != "Ada King" {
+		t.Errorf("expected FN to follow full_name, got %v", card.PreferredValue(vcard.FieldFormattedName))
+	}
+	if card.Name().FamilyName != "King" {
+		t.Errorf("expected N to follow family_name, got %v", card.Name().FamilyName)
+	}
+	if phones := card.Values(vcard.FieldTelephone); len(phones) != 1 || phones[0] != "+44 20 7946 0001" {
+		t.Errorf("expected TEL to follow phones column, got %v", phones)
+	}
+	if card.Value("X-CUSTOM") != "kept" {
+		t.Errorf("expected unmapped properties to survive, got %v", card.Value("X-CUSTOM"))
+	}
+	if card.Get(vcard.FieldPhoto) == nil {
+		t.Error("expected PHOTO to be kept from the stored vcard")
+	}
+}
+
+func TestContactPhotoAssetRoundTrip(t *testing.T) {
+	photo := &ContactPhoto{MimeType: "image/jpeg", Data: []byte{0xff, 0xd8, 0xff, 0xe0}}
+	row := map[string]interface{}{
+		"contact_uid":   "photo-contact",
+		"full_name":     "Photo Contact",
+		"vcard_version": "4.0",
+		"photo":         ToJson(ContactPhotoAssetValue("photo-contact", photo)),
+	}
+
+	card, err := VCardFromContactRow(row)
+	if err != nil {
+		t.Fatalf("VCardFromContactRow failed: %v", err)
+	}
+
+	field := card.Get(vcard.FieldPhoto)
+	if field == nil || !strings.HasPrefix(field.Value, "data:image/jpeg;base64,") {
+		t.Fatalf("expected a data uri photo for vcard 4.0, got %+v", field)
+	}
+
+	decoded := contactPhotoFromField(field)
+	if decoded == nil || string(decoded.Data) != string(photo.Data) {
+		t.Fatalf("photo did not survive the round trip: %+v", decoded)
+	}
+}
@@ -14,6 +14,7 @@ import (
 	"github.com/daptin/daptin/server/subsite"
 	"github.com/daptin/daptin/server/table_info"
 	"github.com/daptin/daptin/server/task"
+	"github.com/daptin/daptin/server/tenant"
 	"github.com/doug-martin/goqu/v9"
 	"github.com/jmoiron/sqlx"
 	log "github.com/sirupsen/logrus"
@@ -176,6 +177,15 @@ func (configStore *ConfigStore) SetDefaultEnv(env string) {
 	configStore.defaultEnv = env
 }
 
+// ForTenant is the store of the values of the tenant. They are kept apart from the values of the
+// deployment, so a tenant reads and writes only its own values. The store itself is returned for nil.
+func (configStore *ConfigStore) ForTenant(t *tenant.Tenant) *ConfigStore {
+	if t == nil {
+		return configStore
+	}
+	return &ConfigStore{defaultEnv: configStore.defaultEnv + "@" + t.ReferenceId.String()}
+}
+
 func (configStore *ConfigStore) GetConfigValueFor(key string, configtype string, transaction *sqlx.Tx) (string, error) {
 	var val interface{}
 
@@ -205,7 +215,7 @@ func (configStore *ConfigStore) GetConfigValueFor(key string, configtype string,
 func (configStore *ConfigStore) GetConfigValueForWithTransaction(key string, configtype string, transaction *sqlx.Tx) (string, error) {
 	var val interface{}
 
-	cacheKey := fmt.Sprintf("config-%v-%v", configtype, key)
+	cacheKey := fmt.Sprintf("config-%v-%v-%v", configStore.defaultEnv, configtype, key)
 
 	if OlricCache != nil {
 		cachedValue, err := OlricCache.Get(context.Background(), cacheKey)
@@ -94,6 +94,36 @@ var StandardColumns = []api2go.ColumnInfo{
 	},
 }
 
+// SoftDeleteColumnName marks rows of a soft_delete table as deleted, they stay hidden until restored or purged
+const SoftDeleteColumnName = "deleted_at"
+
+var SoftDeleteColumn = api2go.ColumnInfo{
+	Name:       SoftDeleteColumnName,
+	ColumnName: SoftDeleteColumnName,
+	DataType:   "timestamp",
+	ColumnDescription: "Timestamp recording when the record was deleted. Rows of tables with soft delete enabled " +
+		"are kept with this set and hidden from reads until they are restored or purged after the retention period.",
+	IsIndexed:  true,
+	IsNullable: true,
+	ColumnType: "datetime",
+}
+
+// TenantColumnName is the tenant a row of a tenant scoped table belongs to, null for the rows of the
+// hostnames which are not a tenant
+const TenantColumnName = "tenant_id"
+
+var TenantColumn = api2go.ColumnInfo{
+	Name:       TenantColumnName,
+	ColumnName: TenantColumnName,
+	DataType:   "INTEGER",
+	ColumnDescription: "Internal id of the tenant the record belongs to. Set from the hostname the record was " +
+		"created on, reads and writes on the hostnames of other tenants do not see the record.",
+	IsIndexed:      true,
+	IsNullable:     true,
+	ExcludeFromApi: true,
+	ColumnType:     "value",
+}
+
 var StandardRelations = []api2go.TableRelation{
 	api2go.NewTableRelation("action", "belongs_to", "world"),
 	api2go.NewTableRelation("feed", "belongs_to", "stream"),
@@ -114,13 +144,16 @@ var StandardRelations = []api2go.TableRelation{
 	api2go.NewTableRelation("api_usage", "has_one", "api_member"),
 	api2go.NewTableRelation("api_quota", "has_one", "api_plan"),
 	api2go.NewTableRelation("api_quota", "has_one", "api_member"),
+	api2go.NewTableRelationWithNames("tenant", "tenant", "has_one", "usergroup", "admin_group_id"),
 	api2go.NewTableRelation("site", "has_one", "cloud_store"),
+	api2go.NewTableRelation("site_deployment", "belongs_to", "site"),
 	api2go.NewTableRelation("outbox", "belongs_to", "mail_server"),
 	api2go.NewTableRelation("mail_account", "belongs_to", "mail_server"),
 	api2go.NewTableRelation("mail_box", "belongs_to", "mail_account"),
 	api2go.NewTableRelation("mail", "belongs_to", "mail_box"),
 	api2go.NewTableRelationWithNames("task", "task_executed", "has_one", USER_ACCOUNT_TABLE_NAME, "as_user_id"),
 	api2go.NewTableRelation("calendar", "has_one", "collection"),
+	api2go.NewTableRelation("contact", "belongs_to", "address_book"),
 	api2go.NewTableRelationWithNames("user_otp_account", "primary_user_otp", "belongs_to", "user_account", "otp_of_account"),
 }
 
@@ -477,6 +510,69 @@ var SystemActions = []actionresponse.Action{
 			},
 		},
 	},
+	{
+		Name:             "restore_yjs_snapshot",
+		Label:            "Restore document to this snapshot",
+		OnType:           "yjs_document_snapshot",
+		InstanceOptional: false,
+		InFields:         []api2go.ColumnInfo{},
+		OutFields: []actionresponse.Outcome{
+			{
+				Type:   "yjs.snapshot.restore",
+				Method: "EXECUTE",
+				Attributes: map[string]interface{}{
+					"snapshot_id": "$.reference_id",
+				},
+			},
+		},
+	},
+	{
+		Name:             "deploy_site",
+		Label:            "Create a deployment",
+		OnType:           "site",
+		InstanceOptional: false,
+		InFields: []api2go.ColumnInfo{
+			{
+				Name:       "Files",
+				ColumnName: "files",
+				ColumnType: "file.*",
+				IsNullable: true,
+			},
+			{
+				Name:       "Activate",
+				ColumnName: "activate",
+				ColumnType: "truefalse",
+				IsNullable: true,
+			},
+		},
+		OutFields: []actionresponse.Outcome{
+			{
+				Type:   "site.deployment.create",
+				Method: "EXECUTE",
+				Attributes: map[string]interface{}{
+					"site_id":  "$.reference_id",
+					"files":    "~files",
+					"activate": "~activate",
+				},
+			},
+		},
+	},
+	{
+		Name:             "activate_deployment",
+		Label:            "Serve the site from this deployment",
+		OnType:           "site_deployment",
+		InstanceOptional: false,
+		InFields:         []api2go.ColumnInfo{},
+		OutFields: []actionresponse.Outcome{
+			{
+				Type:   "site.deployment.activate",
+				Method: "EXECUTE",
+				Attributes: map[string]interface{}{
+					"deployment_id": "$.reference_id",
+				},
+			},
+		},
+	},
 	{
 		Name:             "sync_site_storage",
 		Label:            "Sync site storage",
@@ -609,6 +705,34 @@ var SystemActions = []actionresponse.Action{
 			},
 		},
 	},
+	{
+		Name:             "fire_state_timers",
+		Label:            "Fire due state machine timers",
+		OnType:           "smd",
+		InstanceOptional: true,
+		InFields:         []api2go.ColumnInfo{},
+		OutFields: []actionresponse.Outcome{
+			{
+				Type:       "state.timers.fire",
+				Method:     "EXECUTE",
+				Attributes: map[string]interface{}{},
+			},
+		},
+	},
+	{
+		Name:             "refresh_rollups",
+		Label:            "Refresh materialized rollups",
+		OnType:           "rollup",
+		InstanceOptional: true,
+		InFields:         []api2go.ColumnInfo{},
+		OutFields: []actionresponse.Outcome{
+			{
+				Type:       "rollup.refresh",
+				Method:     "EXECUTE",
+				Attributes: map[string]interface{}{},
+			},
+		},
+	},
 	{
 		Name:             "restart_daptin",
 		Label:            "Restart system",
@@ -690,6 +814,71 @@ var SystemActions = []actionresponse.Action{
 				Name:       "include_headers",
 				ColumnType: "truefalse",
 			},
+			{
+				ColumnName: "stream_name",
+				Name:       "stream_name",
+				ColumnType: "label",
+			},
+			{
+				ColumnName: "page_size",
+				Name:       "page_size",
+				ColumnType: "measurement",
+				IsNullable: true,
+			},
+			{
+				ColumnName:        "query",
+				Name:              "query",
+				ColumnType:        "json",
+				IsNullable:        true,
+				ColumnDescription: "Query conditions as accepted by the query parameter of the api.",
+			},
+			{
+				ColumnName: "filter",
+				Name:       "filter",
+				ColumnType: "label",
+				IsNullable: true,
+			},
+			{
+				ColumnName:        "sort",
+				Name:              "sort",
+				ColumnType:        "label",
+				IsNullable:        true,
+				ColumnDescription: "Comma separated columns to sort by, prefix a column with - to sort descending.",
+			},
+			{
+				ColumnName:        "cloud_store_id",
+				Name:              "cloud_store_id",
+				ColumnType:        "label",
+				IsNullable:        true,
+				ColumnDescription: "Write the export to this cloud store instead of downloading it.",
+			},
+			{
+				ColumnName: "path",
+				Name:       "path",
+				ColumnType: "label",
+				IsNullable: true,
+			},
+			{
+				ColumnName:        "background",
+				Name:              "background",
+				ColumnType:        "truefalse",
+				IsNullable:        true,
+				ColumnDescription: "Run the export after the request returns, needs a cloud_store_id.",
+			},
+			{
+				ColumnName:   "notify",
+				Name:         "notify",
+				ColumnType:   "label",
+				IsNullable:   true,
+				DefaultValue: "websocket",
+			},
+			{
+				ColumnName:   "link_expiry",
+				Name:         "link_expiry",
+				ColumnType:   "label",
+				IsNullable:   true,
+				DefaultValue: "24h",
+			},
 		},
 		OutFields: []actionresponse.Outcome{
 			{
@@ -700,6 +889,16 @@ var SystemActions = []actionresponse.Action{
 					"format":          "~format",
 					"include_headers": "~include_headers",
 					"columns":         "~columns",
+					"stream_name":     "~stream_name",
+					"page_size":       "~page_size",
+					"query":           "~query",
+					"filter":          "~filter",
+					"sort":            "~sort",
+					"cloud_store_id":  "~cloud_store_id",
+					"path":            "~path",
+					"background":      "~background",
+					"notify":          "~notify",
+					"link_expiry":     "~link_expiry",
 				},
 			},
 		},
@@ -737,19 +936,58 @@ var SystemActions = []actionresponse.Action{
 			{
 				Name:       "Import file",
 				ColumnName: "dump_file",
-				ColumnType: "file.json|yaml|toml|hcl|csv|docx|xlsx|pdf|html",
-				IsNullable: false,
+				ColumnType: "file.json|ndjson|jsonl|yaml|toml|hcl|csv|docx|xlsx|parquet|pdf|html",
+				IsNullable: true,
+			},
+			{
+				Name:              "cloud_store_id",
+				ColumnName:        "cloud_store_id",
+				ColumnType:        "label",
+				IsNullable:        true,
+				ColumnDescription: "Import the file at path in this cloud store instead of an uploaded file",
+			},
+			{
+				Name:       "path",
+				ColumnName: "path",
+				ColumnType: "label",
+				IsNullable: true,
 			},
 			{
 				Name:       "truncate_before_insert",
 				ColumnName: "truncate_before_insert",
 				ColumnType: "truefalse",
 			},
+			{
+				Name:              "mode",
+				ColumnName:        "mode",
+				ColumnType:        "label",
+				IsNullable:        true,
+				ColumnDescription: "insert adds every row, upsert updates the row having the same upsert_key and inserts the others",
+			},
+			{
+				Name:       "upsert_key",
+				ColumnName: "upsert_key",
+				ColumnType: "label",
+				IsNullable: true,
+			},
 			{
 				Name:       "batch_size",
 				ColumnName: "batch_size",
 				ColumnType: "measurement",
 			},
+			{
+				Name:              "import_job_id",
+				ColumnName:        "import_job_id",
+				ColumnType:        "label",
+				IsNullable:        true,
+				ColumnDescription: "Resume this unfinished import job, skipping the rows it already processed",
+			},
+			{
+				Name:              "background",
+				ColumnName:        "background",
+				ColumnType:        "truefalse",
+				ColumnDescription: "Return at once and import in the background, committing every batch",
+			},
 		},
 		OutFields: []actionresponse.Outcome{
 			{
@@ -759,6 +997,12 @@ var SystemActions = []actionresponse.Action{
 					"world_reference_id":     "$.reference_id",
 					"truncate_before_insert": "~truncate_before_insert",
 					"dump_file":              "~dump_file",
+					"cloud_store_id":         "~cloud_store_id",
+					"path":                   "~path",
+					"mode":                   "~mode",
+					"upsert_key":             "~upsert_key",
+					"import_job_id":          "~import_job_id",
+					"background":             "~background",
 					"table_name":             "$.table_name",
 					"batch_size":             "~batch_size",
 					"user":                   "~user",
@@ -766,6 +1010,103 @@ var SystemActions = []actionresponse.Action{
 			},
 		},
 	},
+	{
+		Name:             "backup_system",
+		Label:            "Backup data, schema and files",
+		OnType:           "world",
+		InstanceOptional: true,
+		Permission:       &adminOnlyActionPermission,
+		AccessGroups:     adminOnlyActionAccessGroups,
+		InFields: []api2go.ColumnInfo{
+			{
+				ColumnName:        "cloud_store_id",
+				Name:              "cloud_store_id",
+				ColumnType:        "label",
+				IsNullable:        true,
+				ColumnDescription: "Write the backup to this cloud store instead of downloading it.",
+			},
+			{
+				ColumnName: "path",
+				Name:       "path",
+				ColumnType: "label",
+				IsNullable: true,
+			},
+			{
+				ColumnName:        "include_files",
+				Name:              "include_files",
+				ColumnType:        "truefalse",
+				IsNullable:        true,
+				ColumnDescription: "Add the files of the local cloud stores, true unless set.",
+			},
+		},
+		OutFields: []actionresponse.Outcome{
+			{
+				Type:   "__system_backup",
+				Method: "EXECUTE",
+				Attributes: map[string]interface{}{
+					"cloud_store_id": "~cloud_store_id",
+					"path":           "~path",
+					"include_files":  "~include_files",
+				},
+			},
+		},
+	},
+	{
+		Name:             "restore_system",
+		Label:            "Restore from a backup",
+		OnType:           "world",
+		InstanceOptional: true,
+		Permission:       &adminOnlyActionPermission,
+		AccessGroups:     adminOnlyActionAccessGroups,
+		InFields: []api2go.ColumnInfo{
+			{
+				Name:       "Backup file",
+				ColumnName: "backup_file",
+				ColumnType: "file.gz|tgz|enc",
+				IsNullable: true,
+			},
+			{
+				ColumnName:        "cloud_store_id",
+				Name:              "cloud_store_id",
+				ColumnType:        "label",
+				IsNullable:        true,
+				ColumnDescription: "Restore the backup at path in this cloud store instead of an uploaded file.",
+			},
+			{
+				ColumnName: "path",
+				Name:       "path",
+				ColumnType: "label",
+				IsNullable: true,
+			},
+		},
+		OutFields: []actionresponse.Outcome{
+			{
+				Type:   "__system_restore",
+				Method: "EXECUTE",
+				Attributes: map[string]interface{}{
+					"backup_file":    "~backup_file",
+					"cloud_store_id": "~cloud_store_id",
+					"path":           "~path",
+				},
+			},
+		},
+	},
+	{
+		Name:             "download_import_errors",
+		Label:            "Download rows which failed to import",
+		OnType:           "import_job",
+		InstanceOptional: false,
+		OutFields: []actionresponse.Outcome{
+			{
+				Type:   "__import_errors_download",
+				Method: "EXECUTE",
+				Attributes: map[string]interface{}{
+					"error_report": "$.error_report",
+					"table_name":   "$.table_name",
+				},
+			},
+		},
+	},
 	{
 		Name:             "upload_file",
 		Label:            "Upload file to external store",
@@ -1867,76 +2208,277 @@ var StandardTables = []table_info.TableInfo{
 		},
 	},
 	{
-		TableName:     "collection",
+		TableName:     "address_book",
 		IsHidden:      false,
 		DefaultGroups: adminsGroup,
-		Icon:          "fa-folder-open",
+		Icon:          "fa-address-book",
 		Columns: []api2go.ColumnInfo{
 			{
 				ColumnName:        "name",
 				Name:              "name",
 				ColumnType:        "label",
-				DataType:          "varchar(500)",
+				DataType:          "varchar(200)",
 				IsNullable:        false,
 				IsIndexed:         true,
-				ColumnDescription: "The name of the collection, which serves as the primary identifier for users. This field is indexed to enable quick lookups and filtering by collection name.",
+				ColumnDescription: "Display name of the address book shown by CardDAV clients.",
 			},
 			{
 				ColumnName:        "description",
 				Name:              "description",
-				ColumnType:        "label",
+				ColumnType:        "content",
 				DataType:          "text",
-				IsNullable:        false,
-				IsIndexed:         true,
-				ColumnDescription: "A detailed description of the collection's purpose, contents, and other relevant information. Indexed to support searching collections by their descriptions.",
+				IsNullable:        true,
+				ColumnDescription: "Optional description of the address book, exposed as the CardDAV addressbook-description property.",
 			},
-		},
-	},
-	{
-		TableName:     "credential",
-		IsHidden:      false,
-		DefaultGroups: adminsGroup,
-		Icon:          "fa-key",
-		Columns: []api2go.ColumnInfo{
 			{
-				ColumnName:        "name",
-				Name:              "name",
+				ColumnName:        "book_type",
+				Name:              "book_type",
 				ColumnType:        "label",
-				DataType:          "varchar(500)",
-				IsNullable:        false,
+				DataType:          "varchar(20)",
+				DefaultValue:      "'personal'",
 				IsIndexed:         true,
-				ColumnDescription: "A human-readable identifier for the credential entry, allowing users to recognize the purpose or system associated with these credentials. Indexed for quick search and reference.",
+				ColumnDescription: "Either 'personal' for books managed by the user or 'collected' for the book filled automatically with the recipients of sent mail.",
 			},
 			{
-				ColumnName:        "content",
-				Name:              "content",
-				ColumnType:        "encrypted",
-				DataType:          "text",
-				IsNullable:        false,
-				IsIndexed:         false,
-				ColumnDescription: "The sensitive credential information stored in encrypted format. May contain passwords, API keys, tokens, or other authentication data that requires security protection.",
+				ColumnName:        "sync_revision",
+				Name:              "sync_revision",
+				ColumnType:        "measurement",
+				DataType:          "int(11)",
+				DefaultValue:      "0",
+				ColumnDescription: "Revision counter incremented on every contact change, used to build CardDAV sync tokens.",
 			},
 		},
 	},
 	{
-		TableName:     "certificate",
+		TableName:     "contact",
 		IsHidden:      false,
 		DefaultGroups: adminsGroup,
-		Icon:          "fa-certificate",
+		Icon:          "fa-address-card",
 		Columns: []api2go.ColumnInfo{
 			{
-				Name:              "hostname",
-				ColumnName:        "hostname",
-				IsUnique:          true,
-				IsIndexed:         true,
+				ColumnName:        "contact_uid",
+				Name:              "contact_uid",
 				ColumnType:        "label",
-				DataType:          "varchar(100)",
+				DataType:          "varchar(200)",
 				IsNullable:        false,
-				ColumnDescription: "The fully qualified domain name (FQDN) for which this certificate is valid. This field is unique and indexed as it serves as the primary identifier for certificate lookup.",
+				IsIndexed:         true,
+				ColumnDescription: "The vCard UID of the contact.",
 			},
 			{
-				Name:              "issuer",
-				ColumnName:        "issuer",
+				ColumnName:        "resource_name",
+				Name:              "resource_name",
+				ColumnType:        "label",
+				DataType:          "varchar(300)",
+				IsNullable:        false,
+				IsIndexed:         true,
+				ColumnDescription: "File name of the contact within its CardDAV address book, usually {contact_uid}.vcf but chosen by the client.",
+			},
+			{
+				ColumnName:        "full_name",
+				Name:              "full_name",
+				ColumnType:        "label",
+				DataType:          "varchar(500)",
+				IsNullable:        true,
+				IsIndexed:         true,
+				ColumnDescription: "Formatted name (FN) of the contact.",
+			},
+			{
+				ColumnName: "given_name",
+				Name:       "given_name",
+				ColumnType: "label",
+				DataType:   "varchar(200)",
+				IsNullable: true,
+			},
+			{
+				ColumnName: "family_name",
+				Name:       "family_name",
+				ColumnType: "label",
+				DataType:   "varchar(200)",
+				IsNullable: true,
+			},
+			{
+				ColumnName: "nickname",
+				Name:       "nickname",
+				ColumnType: "label",
+				DataType:   "varchar(200)",
+				IsNullable: true,
+			},
+			{
+				ColumnName: "organization",
+				Name:       "organization",
+				ColumnType: "label",
+				DataType:   "varchar(500)",
+				IsNullable: true,
+			},
+			{
+				ColumnName: "job_title",
+				Name:       "job_title",
+				ColumnType: "label",
+				DataType:   "varchar(200)",
+				IsNullable: true,
+			},
+			{
+				ColumnName:        "birthday",
+				Name:              "birthday",
+				ColumnType:        "label",
+				DataType:          "varchar(50)",
+				IsNullable:        true,
+				ColumnDescription: "Birthday as written in the vCard; kept as text since vCard allows partial dates such as --0415.",
+			},
+			{
+				ColumnName: "note",
+				Name:       "note",
+				ColumnType: "content",
+				DataType:   "text",
+				IsNullable: true,
+			},
+			{
+				ColumnName:        "emails",
+				Name:              "emails",
+				ColumnType:        "json",
+				DataType:          "text",
+				IsNullable:        true,
+				ColumnDescription: "List of email addresses with their types, e.g. [{\"address\": \"a@example.com\", \"types\": [\"work\"]}].",
+			},
+			{
+				ColumnName:        "phones",
+				Name:              "phones",
+				ColumnType:        "json",
+				DataType:          "text",
+				IsNullable:        true,
+				ColumnDescription: "List of phone numbers with their types.",
+			},
+			{
+				ColumnName:        "addresses",
+				Name:              "addresses",
+				ColumnType:        "json",
+				DataType:          "text",
+				IsNullable:        true,
+				ColumnDescription: "List of postal addresses split into street, locality, region, postal code and country.",
+			},
+			{
+				ColumnName:        "photo",
+				Name:              "photo",
+				ColumnType:        "file.image",
+				DataType:          "longblob",
+				IsForeignKey:      true,
+				IsNullable:        true,
+				ColumnDescription: "Contact photo extracted from the vCard PHOTO property.",
+			},
+			{
+				ColumnName: "vcard_version",
+				Name:       "vcard_version",
+				ColumnType: "label",
+				DataType:   "varchar(10)",
+				IsNullable: true,
+			},
+			{
+				ColumnName:        "vcard",
+				Name:              "vcard",
+				ColumnType:        "content",
+				DataType:          "text",
+				IsNullable:        true,
+				ColumnDescription: "The vCard as last received, so properties without a dedicated column survive a round trip.",
+			},
+			{
+				ColumnName:        "sync_revision",
+				Name:              "sync_revision",
+				ColumnType:        "measurement",
+				DataType:          "int(11)",
+				DefaultValue:      "0",
+				IsIndexed:         true,
+				ColumnDescription: "Address book revision at which this contact was last changed.",
+			},
+			{
+				ColumnName:        "is_collected",
+				Name:              "is_collected",
+				ColumnType:        "truefalse",
+				DataType:          "bool",
+				DefaultValue:      "false",
+				ColumnDescription: "True when the contact was created automatically from an outgoing mail.",
+			},
+			{
+				ColumnName:        "removed_at",
+				Name:              "removed_at",
+				ColumnType:        "datetime",
+				DataType:          "timestamp",
+				IsNullable:        true,
+				IsIndexed:         true,
+				ColumnDescription: "Set when the contact is deleted over CardDAV; the row is kept so sync-collection reports can announce the removal.",
+			},
+		},
+	},
+	{
+		TableName:     "collection",
+		IsHidden:      false,
+		DefaultGroups: adminsGroup,
+		Icon:          "fa-folder-open",
+		Columns: []api2go.ColumnInfo{
+			{
+				ColumnName:        "name",
+				Name:              "name",
+				ColumnType:        "label",
+				DataType:          "varchar(500)",
+				IsNullable:        false,
+				IsIndexed:         true,
+				ColumnDescription: "The name of the collection, which serves as the primary identifier for users. This field is indexed to enable quick lookups and filtering by collection name.",
+			},
+			{
+				ColumnName:        "description",
+				Name:              "description",
+				ColumnType:        "label",
+				DataType:          "text",
+				IsNullable:        false,
+				IsIndexed:         true,
+				ColumnDescription: "A detailed description of the collection's purpose, contents, and other relevant information. Indexed to support searching collections by their descriptions.",
+			},
+		},
+	},
+	{
+		TableName:     "credential",
+		IsHidden:      false,
+		DefaultGroups: adminsGroup,
+		Icon:          "fa-key",
+		Columns: []api2go.ColumnInfo{
+			{
+				ColumnName:        "name",
+				Name:              "name",
+				ColumnType:        "label",
+				DataType:          "varchar(500)",
+				IsNullable:        false,
+				IsIndexed:         true,
+				ColumnDescription: "A human-readable identifier for the credential entry, allowing users to recognize the purpose or system associated with these credentials. Indexed for quick search and reference.",
+			},
+			{
+				ColumnName:        "content",
+				Name:              "content",
+				ColumnType:        "encrypted",
+				DataType:          "text",
+				IsNullable:        false,
+				IsIndexed:         false,
+				ColumnDescription: "The sensitive credential information stored in encrypted format. May contain passwords, API keys, tokens, or other authentication data that requires security protection.",
+			},
+		},
+	},
+	{
+		TableName:     "certificate",
+		IsHidden:      false,
+		DefaultGroups: adminsGroup,
+		Icon:          "fa-certificate",
+		Columns: []api2go.ColumnInfo{
+			{
+				Name:              "hostname",
+				ColumnName:        "hostname",
+				IsUnique:          true,
+				IsIndexed:         true,
+				ColumnType:        "label",
+				DataType:          "varchar(100)",
+				IsNullable:        false,
+				ColumnDescription: "The fully qualified domain name (FQDN) for which this certificate is valid. This field is unique and indexed as it serves as the primary identifier for certificate lookup.",
+			},
+			{
+				Name:              "issuer",
+				ColumnName:        "issuer",
 				ColumnType:        "label",
 				DataType:          "varchar(100)",
 				IsNullable:        false,
@@ -2517,6 +3059,328 @@ var StandardTables = []table_info.TableInfo{
 			},
 		},
 	},
+	{
+		TableName:     "user_ssh_key",
+		Icon:          "fa-key",
+		IsHidden:      false,
+		DefaultGroups: table_info.DefaultGroups(),
+		Columns: []api2go.ColumnInfo{
+			{
+				ColumnName:        "name",
+				Name:              "name",
+				DataType:          "varchar(100)",
+				ColumnType:        "label",
+				IsNullable:        true,
+				ColumnDescription: "A label for the key, for example the machine or CI pipeline it belongs to.",
+			},
+			{
+				ColumnName:        "public_key",
+				Name:              "public_key",
+				DataType:          "text",
+				ColumnType:        "content",
+				IsNullable:        false,
+				ColumnDescription: "The public key in OpenSSH authorized_keys format (e.g. ssh-ed25519 AAAA... comment). The owner of the key can log in to the SFTP server with the matching private key.",
+			},
+		},
+	},
+	{
+		TableName:     "site_deployment",
+		Icon:          "fa-rocket",
+		DefaultGroups: adminsGroup,
+		Columns: []api2go.ColumnInfo{
+			{
+				ColumnName:        "deployment_key",
+				Name:              "deployment_key",
+				DataType:          "varchar(20)",
+				ColumnType:        "label",
+				IsUnique:          true,
+				IsNullable:        false,
+				ColumnDescription: "Short identifier of the deployment, the deployment is previewed at <deployment_key>.<site hostname>.",
+			},
+			{
+				ColumnName:        "content_hash",
+				Name:              "content_hash",
+				DataType:          "varchar(64)",
+				ColumnType:        "label",
+				IsIndexed:         true,
+				IsNullable:        false,
+				ColumnDescription: "SHA-256 of the file manifest. Deploying the same files again reuses the existing deployment.",
+			},
+			{
+				ColumnName:        "manifest",
+				Name:              "manifest",
+				DataType:          "longtext",
+				ColumnType:        "json",
+				IsNullable:        false,
+				ColumnDescription: "JSON list of the files in the deployment with their path, size and SHA-256 content hash.",
+			},
+			{
+				ColumnName:        "file_count",
+				Name:              "file_count",
+				DataType:          "int(11)",
+				ColumnType:        "measurement",
+				DefaultValue:      "0",
+				ColumnDescription: "Number of files in the deployment.",
+			},
+			{
+				ColumnName:        "total_size",
+				Name:              "total_size",
+				DataType:          "bigint",
+				ColumnType:        "measurement",
+				DefaultValue:      "0",
+				ColumnDescription: "Total size in bytes of the files in the deployment.",
+			},
+			{
+				ColumnName:        "source",
+				Name:              "source",
+				DataType:          "varchar(20)",
+				ColumnType:        "label",
+				IsNullable:        false,
+				ColumnDescription: "How the deployment was created: sync from the cloud store, upload through the deploy_site action, or snapshot of the synced folder.",
+			},
+			{
+				ColumnName:        "is_active",
+				Name:              "is_active",
+				DataType:          "bool",
+				ColumnType:        "truefalse",
+				DefaultValue:      "false",
+				IsIndexed:         true,
+				ColumnDescription: "True for the deployment the site is currently served from. Activating another deployment of the site clears it.",
+			},
+		},
+	},
+	{
+		TableName:     "rollup",
+		Icon:          "fa-layer-group",
+		DefaultGroups: adminsGroup,
+		Columns: []api2go.ColumnInfo{
+			{
+				ColumnName:        "name",
+				Name:              "name",
+				DataType:          "varchar(100)",
+				ColumnType:        "label",
+				IsUnique:          true,
+				IsIndexed:         true,
+				IsNullable:        false,
+				ColumnDescription: "Name of the rollup, the rows are materialized into the table rollup_<name>. Lower case letters, digits and underscores.",
+			},
+			{
+				ColumnName:        "definition",
+				Name:              "definition",
+				DataType:          "text",
+				ColumnType:        "json",
+				IsNullable:        false,
+				ColumnDescription: "Aggregation request materialized by the rollup: root_entity, group, column, join, filter and having as accepted by the aggregate API.",
+			},
+			{
+				ColumnName:        "refresh_interval",
+				Name:              "refresh_interval",
+				DataType:          "varchar(20)",
+				ColumnType:        "label",
+				IsNullable:        true,
+				ColumnDescription: "Rebuild the rollup from scratch this often, eg 1h. Empty rebuilds it only when the definition changes or incremental refresh cannot apply a change.",
+			},
+			{
+				ColumnName:        "incremental",
+				Name:              "incremental",
+				DataType:          "bool",
+				ColumnType:        "truefalse",
+				DefaultValue:      "true",
+				ColumnDescription: "Recompute the groups touched by created, updated and deleted rows as they change. When false the rollup is only rebuilt on schedule.",
+			},
+			{
+				ColumnName:        "last_refreshed_at",
+				Name:              "last_refreshed_at",
+				DataType:          "timestamp",
+				ColumnType:        "datetime",
+				IsNullable:        true,
+				ColumnDescription: "When the rollup was last rebuilt from scratch.",
+			},
+			{
+				ColumnName:        "last_refresh_error",
+				Name:              "last_refresh_error",
+				DataType:          "text",
+				ColumnType:        "content",
+				IsNullable:        true,
+				ColumnDescription: "Error of the last failed refresh, cleared by the next successful one.",
+			},
+			{
+				ColumnName:        "row_count",
+				Name:              "row_count",
+				DataType:          "int(11)",
+				ColumnType:        "measurement",
+				DefaultValue:      "0",
+				ColumnDescription: "Number of rows in the materialized table after the last refresh.",
+			},
+			{
+				ColumnName:        "refresh_lease_until",
+				Name:              "refresh_lease_until",
+				DataType:          "timestamp",
+				ColumnType:        "datetime",
+				IsNullable:        true,
+				ColumnDescription: "Set while a server refreshes the rollup, other servers leave the rollup alone until then.",
+			},
+		},
+	},
+	{
+		TableName:     "import_job",
+		Icon:          "fa-file-import",
+		DefaultGroups: adminsGroup,
+		Columns: []api2go.ColumnInfo{
+			{Name: "table_name", ColumnName: "table_name", ColumnType: "label", DataType: "varchar(100)", IsNullable: true, IsIndexed: true},
+			{Name: "file_name", ColumnName: "file_name", ColumnType: "label", DataType: "varchar(500)"},
+			{Name: "cloud_store_id", ColumnName: "cloud_store_id", ColumnType: "label", DataType: "varchar(100)", IsNullable: true,
+				ColumnDescription: "Cloud store the file was read from, a resumed job reads it again from there. Empty for uploaded files."},
+			{Name: "format", ColumnName: "format", ColumnType: "label", DataType: "varchar(20)", IsNullable: true},
+			{Name: "mode", ColumnName: "mode", ColumnType: "label", DataType: "varchar(20)", DefaultValue: "'insert'"},
+			{Name: "upsert_key", ColumnName: "upsert_key", ColumnType: "label", DataType: "varchar(100)", IsNullable: true},
+			{Name: "status", ColumnName: "status", ColumnType: "label", DataType: "varchar(20)", DefaultValue: "'running'", IsIndexed: true},
+			{Name: "rows_processed", ColumnName: "rows_processed", ColumnType: "measurement", DataType: "int(11)", DefaultValue: "0",
+				ColumnDescription: "Rows read from the file and written, a resumed job skips this many rows."},
+			{Name: "rows_imported", ColumnName: "rows_imported", ColumnType: "measurement", DataType: "int(11)", DefaultValue: "0"},
+			{Name: "rows_failed", ColumnName: "rows_failed", ColumnType: "measurement", DataType: "int(11)", DefaultValue: "0"},
+			{Name: "error_report", ColumnName: "error_report", ColumnType: "content", DataType: "text", IsNullable: true,
+				ColumnDescription: "The rows which failed as csv, with the row number, table, error and row data."},
+			{Name: "message", ColumnName: "message", ColumnType: "content", DataType: "text", IsNullable: true},
+			{Name: "started_at", ColumnName: "started_at", ColumnType: "datetime", DataType: "timestamp", IsNullable: true},
+			{Name: "finished_at", ColumnName: "finished_at", ColumnType: "datetime", DataType: "timestamp", IsNullable: true},
+		},
+	},
+	{
+		TableName:     "export_job",
+		Icon:          "fa-file-export",
+		DefaultGroups: adminsGroup,
+		Columns: []api2go.ColumnInfo{
+			{Name: "table_name", ColumnName: "table_name", ColumnType: "label", DataType: "varchar(100)", IsIndexed: true},
+			{Name: "format", ColumnName: "format", ColumnType: "label", DataType: "varchar(20)"},
+			{Name: "status", ColumnName: "status", ColumnType: "label", DataType: "varchar(20)", DefaultValue: "'running'", IsIndexed: true},
+			{Name: "rows_exported", ColumnName: "rows_exported", ColumnType: "measurement", DataType: "int(11)", DefaultValue: "0"},
+			{Name: "cloud_store_id", ColumnName: "cloud_store_id", ColumnType: "label", DataType: "varchar(100)"},
+			{Name: "path", ColumnName: "path", ColumnType: "label", DataType: "varchar(500)",
+				ColumnDescription: "Path of the exported file in the cloud store."},
+			{Name: "download_url", ColumnName: "download_url", ColumnType: "url", DataType: "varchar(2000)", IsNullable: true,
+				ColumnDescription: "Link to the exported file, empty when the cloud store cannot give out links."},
+			{Name: "notify", ColumnName: "notify", ColumnType: "label", DataType: "varchar(20)", DefaultValue: "'websocket'"},
+			{Name: "message", ColumnName: "message", ColumnType: "content", DataType: "text", IsNullable: true},
+			{Name: "started_at", ColumnName: "started_at", ColumnType: "datetime", DataType: "timestamp", IsNullable: true},
+			{Name: "finished_at", ColumnName: "finished_at", ColumnType: "datetime", DataType: "timestamp", IsNullable: true},
+		},
+	},
+	{
+		TableName:     "yjs_document",
+		Icon:          "fa-file-alt",
+		IsHidden:      true,
+		DefaultGroups: adminsGroup,
+		Columns: []api2go.ColumnInfo{
+			{
+				ColumnName:        "room_name",
+				Name:              "room_name",
+				DataType:          "varchar(500)",
+				ColumnType:        "label",
+				IsUnique:          true,
+				IsNullable:        false,
+				ColumnDescription: "The yjs room this document belongs to, typename.referenceId.columnName for rooms bound to a file column.",
+			},
+			{
+				ColumnName:        "document_state",
+				Name:              "document_state",
+				DataType:          "longtext",
+				ColumnType:        "content",
+				IsNullable:        true,
+				ColumnDescription: "Base64 encoded update log of the room up to the last checkpoint.",
+			},
+			{
+				ColumnName:        "document_size",
+				Name:              "document_size",
+				DataType:          "int(11)",
+				ColumnType:        "measurement",
+				DefaultValue:      "0",
+				ColumnDescription: "Size in bytes of the decoded document state.",
+			},
+			{
+				ColumnName:        "log_offset",
+				Name:              "log_offset",
+				DataType:          "int(11)",
+				ColumnType:        "measurement",
+				DefaultValue:      "0",
+				ColumnDescription: "Offset in the room log the document state ends at, set when a checkpoint compacts the state.",
+			},
+		},
+	},
+	{
+		TableName:     "yjs_document_update",
+		Icon:          "fa-stream",
+		IsHidden:      true,
+		DefaultGroups: adminsGroup,
+		Columns: []api2go.ColumnInfo{
+			{
+				ColumnName:        "room_name",
+				Name:              "room_name",
+				DataType:          "varchar(500)",
+				ColumnType:        "label",
+				IsIndexed:         true,
+				IsNullable:        false,
+				ColumnDescription: "The yjs room this update was sent to.",
+			},
+			{
+				ColumnName:        "update_data",
+				Name:              "update_data",
+				DataType:          "longtext",
+				ColumnType:        "content",
+				IsNullable:        false,
+				ColumnDescription: "Base64 encoded update, as it was appended to the room log. Moved into the document state on checkpoint.",
+			},
+			{
+				ColumnName:        "update_size",
+				Name:              "update_size",
+				DataType:          "int(11)",
+				ColumnType:        "measurement",
+				DefaultValue:      "0",
+				ColumnDescription: "Size in bytes of the decoded update.",
+			},
+		},
+	},
+	{
+		TableName:     "yjs_document_snapshot",
+		Icon:          "fa-history",
+		DefaultGroups: adminsGroup,
+		Columns: []api2go.ColumnInfo{
+			{
+				ColumnName:        "room_name",
+				Name:              "room_name",
+				DataType:          "varchar(500)",
+				ColumnType:        "label",
+				IsIndexed:         true,
+				IsNullable:        false,
+				ColumnDescription: "The yjs room this snapshot was taken of.",
+			},
+			{
+				ColumnName:        "snapshot_reason",
+				Name:              "snapshot_reason",
+				DataType:          "varchar(50)",
+				ColumnType:        "label",
+				IsNullable:        false,
+				ColumnDescription: "Why the snapshot was taken: checkpoint, or before_restore when a document was rolled back to an older snapshot.",
+			},
+			{
+				ColumnName:        "document_state",
+				Name:              "document_state",
+				DataType:          "longtext",
+				ColumnType:        "content",
+				IsNullable:        true,
+				ExcludeFromApi:    true,
+				ColumnDescription: "Base64 encoded document state at the time of the snapshot.",
+			},
+			{
+				ColumnName:        "document_size",
+				Name:              "document_size",
+				DataType:          "int(11)",
+				ColumnType:        "measurement",
+				DefaultValue:      "0",
+				ColumnDescription: "Size in bytes of the decoded document state.",
+			},
+		},
+	},
 	{
 		TableName:     USER_ACCOUNT_TABLE_NAME,
 		Icon:          "fa-user",
@@ -2682,6 +3546,14 @@ var StandardTables = []table_info.TableInfo{
 				ColumnType: "json",
 				IsNullable: false,
 			},
+			{
+				Name:              "states",
+				ColumnName:        "states",
+				DataType:          "text",
+				ColumnType:        "json",
+				IsNullable:        true,
+				ColumnDescription: "Actions invoked when an object enters or leaves a state of this machine.",
+			},
 		},
 	},
 	{
@@ -3321,6 +4193,36 @@ var StandardTables = []table_info.TableInfo{
 			},
 		},
 	},
+	{
+		TableName:     "tenant",
+		DefaultGroups: adminsGroup,
+		Icon:          "fa-building",
+		IsHidden:      false,
+		Columns: []api2go.ColumnInfo{
+			{
+				Name:       "name",
+				ColumnName: "name",
+				ColumnType: "label",
+				DataType:   "varchar(100)",
+				IsUnique:   true,
+				IsIndexed:  true,
+			},
+			{
+				Name:              "hostnames",
+				ColumnName:        "hostnames",
+				ColumnType:        "label",
+				DataType:          "varchar(1000)",
+				ColumnDescription: "Comma separated hostnames the tenant is served on",
+			},
+			{
+				Name:         "enable",
+				ColumnName:   "enable",
+				ColumnType:   "truefalse",
+				DataType:     "bool",
+				DefaultValue: "true",
+			},
+		},
+	},
 	{
 		TableName:     "mail_server",
 		IsHidden:      false,
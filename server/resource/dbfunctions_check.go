@@ -220,6 +220,19 @@ func CheckRelations(config *CmsConfig) {
 			}
 
 		}
+
+		if config.Tables[i].IsStateTrackingEnabled {
+			transitionTable := stateTransitionTable(config.Tables[i].TableName)
+			for _, rel := range transitionTable.Relations {
+				if !relationsDone[relationHash(rel)] {
+					relationsDone[relationHash(rel)] = true
+					finalRelations = append(finalRelations, rel)
+				}
+			}
+			if !tableNamePresent(config.Tables, transitionTable.TableName) && !tableNamePresent(newTables, transitionTable.TableName) {
+				newTables = append(newTables, transitionTable)
+			}
+		}
 	}
 
 	for i, tab := range config.Tables {
@@ -230,6 +243,15 @@ func CheckRelations(config *CmsConfig) {
 		}
 	}
 
+	// state tables created above get the relation to their transition history here
+	for i, tab := range newTables {
+		for _, rel := range finalRelations {
+			if rel.GetSubject() == tab.TableName || rel.GetObject() == tab.TableName {
+				newTables[i].AddRelation(rel)
+			}
+		}
+	}
+
 	log.Printf("%d state tables on base entities", len(newTables))
 	config.Tables = append(config.Tables, newTables...)
 
@@ -255,6 +277,60 @@ func CheckRelations(config *CmsConfig) {
 	}
 }
 
+// stateTransitionTable is the history of transitions of objects of a state tracked table, one row per
+// event applied to a <type>_state row
+func stateTransitionTable(typeName string) table_info.TableInfo {
+	tableName := typeName + "_state_transition"
+	return table_info.TableInfo{
+		TableName: tableName,
+		Columns: []api2go.ColumnInfo{
+			{
+				Name:       "event_name",
+				ColumnName: "event_name",
+				ColumnType: "label",
+				DataType:   "varchar(100)",
+				IsNullable: false,
+			},
+			{
+				Name:       "from_state",
+				ColumnName: "from_state",
+				ColumnType: "label",
+				DataType:   "varchar(100)",
+				IsNullable: true,
+			},
+			{
+				Name:       "to_state",
+				ColumnName: "to_state",
+				ColumnType: "label",
+				DataType:   "varchar(100)",
+				IsNullable: false,
+			},
+			{
+				Name:         "fired_by",
+				ColumnName:   "fired_by",
+				ColumnType:   "label",
+				DataType:     "varchar(20)",
+				IsNullable:   false,
+				DefaultValue: "'user'",
+			},
+		},
+		Relations: []api2go.TableRelation{
+			api2go.NewTableRelation(tableName, "belongs_to", typeName+"_state"),
+			api2go.NewTableRelation(tableName, "belongs_to", USER_ACCOUNT_TABLE_NAME),
+			api2go.NewTableRelation(tableName, "has_many", "usergroup"),
+		},
+	}
+}
+
+func tableNamePresent(tables []table_info.TableInfo, tableName string) bool {
+	for _, table := range tables {
+		if table.TableName == tableName {
+			return true
+		}
+	}
+	return false
+}
+
 func filterRelations(name string, relations []api2go.TableRelation, relations2 []api2go.TableRelation) []api2go.TableRelation {
 
 	relationList := make([]api2go.TableRelation, 0)
@@ -368,6 +444,16 @@ func CreateAMapOfColumnsWeWantInTheFinalTable(tableInfo *table_info.TableInfo) (
 			finalColumnList = append(finalColumnList, sCol)
 		}
 	}
+	if tableInfo.SoftDelete {
+		colInfoMap[SoftDeleteColumn.Name] = SoftDeleteColumn
+		columnsWeWant[SoftDeleteColumn.Name] = false
+		finalColumnList = append(finalColumnList, SoftDeleteColumn)
+	}
+	if tableInfo.TenantScoped {
+		colInfoMap[TenantColumn.Name] = TenantColumn
+		columnsWeWant[TenantColumn.Name] = false
+		finalColumnList = append(finalColumnList, TenantColumn)
+	}
 
 	// first fist column names for each column, if they were initially left blank.
 	for _, c := range tableInfo.Columns {
@@ -400,6 +486,7 @@ func CheckTable(tableInfo *table_info.TableInfo, db database.DatabaseConnection)
 	}
 
 	columnsWeWant, colInfoMap := CreateAMapOfColumnsWeWantInTheFinalTable(tableInfo)
+	checkTableIndexes(tableInfo)
 
 	s := fmt.Sprintf("select * from %s limit 1", tableInfo.TableName)
 	log.Debugf("Sql: %v", s)
@@ -447,6 +534,9 @@ func CheckTable(tableInfo *table_info.TableInfo, db database.DatabaseConnection)
 			}
 
 			query := alterTableAddColumn(tableInfo.TableName, &info, db.DriverName())
+			if computed, ok := tableInfo.GetComputedColumn(col); ok && computed.Sql != "" {
+				query = fmt.Sprintf("alter table %v add column %v", tableInfo.TableName, generatedColumnLine(&info, computed, db.DriverName(), true))
+			}
 			log.Printf("Alter query: %v", query)
 			_, err := db.Exec(query)
 			if err != nil {
@@ -458,6 +548,60 @@ func CheckTable(tableInfo *table_info.TableInfo, db database.DatabaseConnection)
 	return nil
 }
 
+// tableIndexMethods are the postgres index types an index can ask for
+var tableIndexMethods = map[string]bool{
+	"btree":  true,
+	"hash":   true,
+	"gin":    true,
+	"gist":   true,
+	"spgist": true,
+	"brin":   true,
+}
+
+// checkTableIndexes keeps the declared indexes which can be created, with the column names of the
+// table. An index on a column the table does not have, without columns, or with a statement in its
+// expression or where clause is skipped with a warning.
+func checkTableIndexes(tableInfo *table_info.TableInfo) {
+	if len(tableInfo.Indexes) == 0 {
+		return
+	}
+	validIndexes := make([]table_info.TableIndex, 0, len(tableInfo.Indexes))
+	for _, index := range tableInfo.Indexes {
+		if err := checkTableIndex(tableInfo, &index); err != nil {
+			log.Warnf("Table [%v] index %v skipped: %v", tableInfo.TableName, index.Columns, err)
+			continue
+		}
+		validIndexes = append(validIndexes, index)
+	}
+	tableInfo.Indexes = validIndexes
+}
+
+func checkTableIndex(tableInfo *table_info.TableInfo, index *table_info.TableIndex) error {
+	index.Method = strings.ToLower(strings.TrimSpace(index.Method))
+	if index.Method != "" && !tableIndexMethods[index.Method] {
+		return fmt.Errorf("unknown index method [%v]", index.Method)
+	}
+	if strings.Contains(index.Expression, ";") || strings.Contains(index.Where, ";") {
+		return fmt.Errorf("expression and where clause cannot contain a statement separator")
+	}
+	if index.Expression != "" {
+		return nil
+	}
+	if len(index.Columns) == 0 {
+		return fmt.Errorf("an index needs columns or an expression")
+	}
+	columnNames := make([]string, 0, len(index.Columns))
+	for _, name := range index.Columns {
+		column, ok := tableInfo.GetColumnByName(name)
+		if !ok {
+			return fmt.Errorf("no such column [%v]", name)
+		}
+		columnNames = append(columnNames, column.ColumnName)
+	}
+	index.Columns = columnNames
+	return nil
+}
+
 func PrintTableInfo(info *table_info.TableInfo, title string) {
 
 	table := simpletable.New()
@@ -30,7 +30,8 @@ func shouldCopyColumnToAudit(col api2go.ColumnInfo) bool {
 	dataType := strings.ToLower(col.DataType)
 
 	switch columnName {
-	case "id", "reference_id", "permission", USER_ACCOUNT_ID_COLUMN, "source_reference_id", "operation":
+	case "id", "reference_id", "permission", USER_ACCOUNT_ID_COLUMN, "source_reference_id", "operation",
+		AuditChangedByColumn, AuditRequestIdColumn, AuditSourceCreatedAtColumn:
 		return false
 	}
 
@@ -159,6 +160,125 @@ func CreateIndexes(initConfig *CmsConfig, db database.DatabaseConnection) {
 	}
 }
 
+// managedIndexPrefix starts the names of the indexes declared in table schemas, the indexes with it
+// which are no longer declared are dropped
+const managedIndexPrefix = "dx"
+
+// TableIndexName is the name of a declared index, derived from its definition so a changed
+// definition is created again under a new name
+func TableIndexName(tableName string, index table_info.TableIndex) string {
+	definition := fmt.Sprintf("%s|%s|%s|%v|%s|%s", tableName, strings.Join(index.Columns, ","),
+		index.Expression, index.Unique, index.Where, index.Method)
+	return managedIndexPrefix + GetMD5HashString(definition)
+}
+
+func isManagedIndexName(indexName string) bool {
+	return strings.HasPrefix(indexName, managedIndexPrefix) && len(indexName) == len(managedIndexPrefix)+32
+}
+
+// MakeCreateTableIndexQuery is the create index statement of a declared index. The index method is
+// used on postgres only, mysql has no partial indexes.
+func MakeCreateTableIndexQuery(tableName string, index table_info.TableIndex, sqlDriverName string) (string, error) {
+	query := "create "
+	if index.Unique {
+		query += "unique "
+	}
+	query += "index " + TableIndexName(tableName, index) + " on " + tableName
+
+	if sqlDriverName == "postgres" && index.Method != "" {
+		query += " using " + index.Method
+	}
+
+	if index.Expression != "" {
+		// an expression is wrapped in its own parentheses, as mysql asks for functional key parts
+		query += " ((" + index.Expression + "))"
+	} else {
+		query += " (" + strings.Join(index.Columns, ", ") + ")"
+	}
+
+	if index.Where != "" {
+		if sqlDriverName == "mysql" {
+			return "", fmt.Errorf("partial indexes are not supported on mysql")
+		}
+		query += " where " + index.Where
+	}
+	return query, nil
+}
+
+// existingManagedIndexes are the names of the declared indexes present on the table
+func existingManagedIndexes(db database.DatabaseConnection, tableName string) (map[string]bool, error) {
+	indexQuery := ""
+	switch db.DriverName() {
+	case "mysql":
+		indexQuery = "SELECT DISTINCT INDEX_NAME FROM INFORMATION_SCHEMA.STATISTICS WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ?"
+	case "postgres":
+		indexQuery = "SELECT indexname FROM pg_indexes WHERE schemaname = current_schema() AND tablename = ?"
+	default:
+		indexQuery = "SELECT name FROM sqlite_master WHERE type = 'index' AND tbl_name = ?"
+	}
+
+	var indexNames []string
+	if err := db.Select(&indexNames, db.Rebind(indexQuery), tableName); err != nil {
+		return nil, err
+	}
+	existing := make(map[string]bool)
+	for _, indexName := range indexNames {
+		if isManagedIndexName(indexName) {
+			existing[indexName] = true
+		}
+	}
+	return existing, nil
+}
+
+// SyncTableIndexes creates the indexes declared in the table schemas which are missing and drops
+// the declared indexes which were removed from the schema
+func SyncTableIndexes(initConfig *CmsConfig, db database.DatabaseConnection) {
+	log.Infof("Sync declared table indexes")
+
+	for _, table := range initConfig.Tables {
+		existing, err := existingManagedIndexes(db, table.TableName)
+		if err != nil {
+			log.Warnf("Failed to read the indexes of table [%v]: %v", table.TableName, err)
+			continue
+		}
+
+		declared := make(map[string]bool)
+		for _, index := range table.Indexes {
+			indexName := TableIndexName(table.TableName, index)
+			declared[indexName] = true
+			if existing[indexName] {
+				continue
+			}
+			createIndex, err := MakeCreateTableIndexQuery(table.TableName, index, db.DriverName())
+			if err != nil {
+				log.Warnf("Table [%v] index %v not created: %v", table.TableName, index.Columns, err)
+				continue
+			}
+			if err = executeSchemaStatementInTransaction(db, createIndex); err != nil {
+				log.Errorf("Failed to create index [%v] on table [%v]: %v", indexName, table.TableName, err)
+				log.Errorf("Create index sql: %v", createIndex)
+				continue
+			}
+			log.Infof("Index created [%v][%v]", table.TableName, indexName)
+		}
+
+		for indexName := range existing {
+			if declared[indexName] {
+				continue
+			}
+			dropIndex := "drop index " + indexName
+			if db.DriverName() == "mysql" {
+				dropIndex += " on " + table.TableName
+			}
+			if err = executeSchemaStatementInTransaction(db, dropIndex); err != nil {
+				log.Errorf("Failed to drop index [%v] on table [%v]: %v", indexName, table.TableName, err)
+				continue
+			}
+			log.Infof("Index dropped [%v][%v]", table.TableName, indexName)
+		}
+	}
+}
+
 func usergroupAccessIndexName(tableName, usergroupColumnName, entityColumnName string) string {
 	indexName := fmt.Sprintf("index_auth_%s_%s_%s", tableName, usergroupColumnName, entityColumnName)
 	if len(indexName) <= 60 {
@@ -555,6 +675,27 @@ func CheckAuditTables(config *CmsConfig) {
 			DataType:   "varchar(32)",
 			IsNullable: false,
 		})
+		columnsCopy = append(columnsCopy, api2go.ColumnInfo{
+			Name:       AuditChangedByColumn,
+			ColumnName: AuditChangedByColumn,
+			ColumnType: "label",
+			DataType:   "varchar(64)",
+			IsNullable: true,
+		})
+		columnsCopy = append(columnsCopy, api2go.ColumnInfo{
+			Name:       AuditRequestIdColumn,
+			ColumnName: AuditRequestIdColumn,
+			ColumnType: "label",
+			DataType:   "varchar(64)",
+			IsNullable: true,
+		})
+		columnsCopy = append(columnsCopy, api2go.ColumnInfo{
+			Name:       AuditSourceCreatedAtColumn,
+			ColumnName: AuditSourceCreatedAtColumn,
+			ColumnType: "datetime",
+			DataType:   "timestamp",
+			IsNullable: true,
+		})
 
 		//newRelation := api2go.TableRelation{
 		//	Subject:    auditTableName,
@@ -861,6 +1002,9 @@ func MakeCreateTableQuery(tableInfo *table_info.TableInfo, sqlDriverName string)
 		}
 
 		columnLine := getColumnLine(&c, sqlDriverName)
+		if computed, ok := tableInfo.GetComputedColumn(c.ColumnName); ok && computed.Sql != "" {
+			columnLine = generatedColumnLine(&c, computed, sqlDriverName, false)
+		}
 
 		colsDone[c.ColumnName] = true
 		columnStrings = append(columnStrings, columnLine)
@@ -880,28 +1024,7 @@ func getColumnLine(c *api2go.ColumnInfo, sqlDriverName string) string {
 
 	//log.Warnf("Get column line [%v] => [%v][%v]", c.ColumnName, c.ColumnType, c.DataType)
 
-	datatype := c.DataType
-
-	if datatype == "" {
-		datatype = "varchar(100)"
-	}
-
-	// update column type if the db is postgres
-	if sqlDriverName == "postgres" {
-		if BeginsWith(datatype, "int(") {
-			datatype = "INTEGER"
-		} else if BeginsWith(datatype, "medium") {
-			datatype = datatype[len("medium"):]
-		} else if BeginsWith(datatype, "long") {
-			datatype = datatype[len("long"):]
-		} else if BeginsWith(datatype, "varbinary") {
-			datatype = strings.Replace(datatype, "varbinary", "bit", 1)
-		}
-	}
-
-	if BeginsWith(datatype, "blob") && sqlDriverName == "postgres" {
-		datatype = "bytea"
-	}
+	datatype := columnDataType(c, sqlDriverName)
 
 	columnParams := []string{c.ColumnName, datatype}
 
@@ -939,3 +1062,31 @@ func getColumnLine(c *api2go.ColumnInfo, sqlDriverName string) string {
 	columnLine := strings.Join(columnParams, " ")
 	return columnLine
 }
+
+// columnDataType is the data type of the column in the database of sqlDriverName
+func columnDataType(c *api2go.ColumnInfo, sqlDriverName string) string {
+	datatype := c.DataType
+
+	if datatype == "" {
+		datatype = "varchar(100)"
+	}
+
+	// update column type if the db is postgres
+	if sqlDriverName == "postgres" {
+		if BeginsWith(datatype, "int(") {
+			datatype = "INTEGER"
+		} else if BeginsWith(datatype, "medium") {
+			datatype = datatype[len("medium"):]
+		} else if BeginsWith(datatype, "long") {
+			datatype = datatype[len("long"):]
+		} else if BeginsWith(datatype, "varbinary") {
+			datatype = strings.Replace(datatype, "varbinary", "bit", 1)
+		}
+	}
+
+	if BeginsWith(datatype, "blob") && sqlDriverName == "postgres" {
+		datatype = "bytea"
+	}
+
+	return datatype
+}
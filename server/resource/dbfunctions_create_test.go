@@ -224,3 +224,93 @@ func assertSqliteIndexColumns(t *testing.T, db *sqlx.DB, indexName string, colum
 		}
 	}
 }
+
+func TestSyncTableIndexesCreatesAndDropsDeclaredIndexes(t *testing.T) {
+	db, err := sqlx.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	if _, err := db.Exec(`create table ticket (
+		id integer primary key,
+		status text,
+		priority integer,
+		email text
+	)`); err != nil {
+		t.Fatalf("create ticket table: %v", err)
+	}
+
+	table := table_info.TableInfo{
+		TableName: "ticket",
+		Columns: []api2go.ColumnInfo{
+			{Name: "status", ColumnName: "status", DataType: "varchar(20)"},
+			{Name: "priority", ColumnName: "priority", DataType: "int(11)"},
+			{Name: "email", ColumnName: "email", DataType: "varchar(100)"},
+		},
+		Indexes: []table_info.TableIndex{
+			{Columns: []string{"status", "priority"}},
+			{Columns: []string{"email"}, Unique: true, Where: "status = 'open'"},
+			{Expression: "lower(email)"},
+			{Columns: []string{"missing"}},
+		},
+	}
+	checkTableIndexes(&table)
+	if len(table.Indexes) != 3 {
+		t.Fatalf("expected the index on a missing column to be skipped, got %v", table.Indexes)
+	}
+
+	config := CmsConfig{Tables: []table_info.TableInfo{table}}
+	SyncTableIndexes(&config, db)
+
+	existing, err := existingManagedIndexes(db, "ticket")
+	if err != nil {
+		t.Fatalf("read indexes: %v", err)
+	}
+	for _, index := range table.Indexes {
+		if !existing[TableIndexName("ticket", index)] {
+			t.Errorf("expected index %v to be created", index)
+		}
+	}
+
+	if _, err := db.Exec("insert into ticket (status, email) values ('open', 'a@example.com'), ('closed', 'a@example.com')"); err != nil {
+		t.Fatalf("a partial unique index should allow duplicates outside its where clause: %v", err)
+	}
+	if _, err := db.Exec("insert into ticket (status, email) values ('open', 'a@example.com')"); err == nil {
+		t.Errorf("a partial unique index should reject duplicates inside its where clause")
+	}
+
+	config.Tables[0].Indexes = config.Tables[0].Indexes[:1]
+	SyncTableIndexes(&config, db)
+
+	existing, err = existingManagedIndexes(db, "ticket")
+	if err != nil {
+		t.Fatalf("read indexes: %v", err)
+	}
+	if len(existing) != 1 || !existing[TableIndexName("ticket", table.Indexes[0])] {
+		t.Errorf("expected the indexes removed from the schema to be dropped, got %v", existing)
+	}
+}
+
+func TestMakeCreateTableIndexQuery(t *testing.T) {
+	ginIndex := table_info.TableIndex{Columns: []string{"tags"}, Method: "gin"}
+	query, err := MakeCreateTableIndexQuery("post", ginIndex, "postgres")
+	if err != nil {
+		t.Fatalf("create gin index query: %v", err)
+	}
+	expected := "create index " + TableIndexName("post", ginIndex) + " on post using gin (tags)"
+	if query != expected {
+		t.Errorf("expected [%v], got [%v]", expected, query)
+	}
+
+	query, err = MakeCreateTableIndexQuery("post", ginIndex, "mysql")
+	if err != nil || query != "create index "+TableIndexName("post", ginIndex)+" on post (tags)" {
+		t.Errorf("the index method should be used on postgres only, got [%v] %v", query, err)
+	}
+
+	partial := table_info.TableIndex{Columns: []string{"title"}, Where: "deleted_at is null"}
+	if _, err = MakeCreateTableIndexQuery("post", partial, "mysql"); err == nil {
+		t.Errorf("partial indexes should not be created on mysql")
+	}
+}
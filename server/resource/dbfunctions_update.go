@@ -521,6 +521,12 @@ func UpdateStateMachineDescriptions(initConfig *CmsConfig, db *sqlx.Tx) {
 	for _, smd := range initConfig.StateMachineDescriptions {
 
 		log.Tracef("Update StateMachineDescriptions: [%s]", smd.Name)
+		if problems := smd.Validate(); len(problems) > 0 {
+			for _, problem := range problems {
+				log.Errorf("Invalid state machine [%v]: %v", smd.Name, problem)
+			}
+			continue
+		}
 		s, v, err := statementbuilder.Squirrel.
 			Select("reference_id").
 			Prepared(true).From("smd").Where(goqu.Ex{"name": smd.Name}).ToSQL()
@@ -546,6 +552,11 @@ func UpdateStateMachineDescriptions(initConfig *CmsConfig, db *sqlx.Tx) {
 				log.Errorf("Failed to convert to json: %v", err)
 				continue
 			}
+			statesDescription, err := json.Marshal(smd.States)
+			if err != nil {
+				log.Errorf("Failed to convert to json: %v", err)
+				continue
+			}
 			u, _ := uuid.NewV7()
 
 			insertMap := map[string]interface{}{}
@@ -553,6 +564,7 @@ func UpdateStateMachineDescriptions(initConfig *CmsConfig, db *sqlx.Tx) {
 			insertMap["label"] = smd.Label
 			insertMap["initial_state"] = smd.InitialState
 			insertMap["events"] = eventsDescription
+			insertMap["states"] = statesDescription
 			insertMap["reference_id"] = u[:]
 			insertMap["permission"] = auth.DEFAULT_PERMISSION
 			insertMap[USER_ACCOUNT_ID_COLUMN] = adminUserId
@@ -576,12 +588,18 @@ func UpdateStateMachineDescriptions(initConfig *CmsConfig, db *sqlx.Tx) {
 				log.Errorf("Failed to convert to json: %v", err)
 				continue
 			}
+			statesDescription, err := json.Marshal(smd.States)
+			if err != nil {
+				log.Errorf("Failed to convert to json: %v", err)
+				continue
+			}
 
 			updateMap := map[string]interface{}{}
 			updateMap["name"] = smd.Name
 			updateMap["label"] = smd.Label
 			updateMap["initial_state"] = smd.InitialState
 			updateMap["events"] = eventsDescription
+			updateMap["states"] = statesDescription
 			updateMap[USER_ACCOUNT_ID_COLUMN] = adminUserId
 			s, v, err := statementbuilder.Squirrel.Update("smd").Prepared(true).
 				Set(updateMap).Where(goqu.Ex{"reference_id": refId[:]}).ToSQL()
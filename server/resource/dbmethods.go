@@ -2,6 +2,7 @@ package resource
 
 import (
 	"context"
+	"database/sql"
 	"encoding/base64"
 	stdjson "encoding/json"
 	"errors"
@@ -2459,6 +2460,7 @@ func (dbResource *DbResource) TruncateTable(typeName string, skipRelations bool,
 	}
 
 	_, err = transaction.Exec(s, q...)
+	MarkTableChanged(context.Background(), typeName)
 
 	return err
 
@@ -2480,22 +2482,9 @@ func (dbResource *DbResource) DirectInsert(typeName string, data map[string]inte
 			log.Printf("No column named [%v]", columnName)
 			continue
 		}
-		value := data[columnName]
-		switch colInfo.ColumnType {
-		case "datetime":
-			if value != nil {
-				valStr, ok := value.(string)
-				if !ok {
-
-				} else {
-
-					value, err = dateparse.ParseLocal(valStr)
-					if err != nil {
-						log.Errorf("Failed to parse value as time, insert will fail [%v][%v]: %v", columnName, value, err)
-						continue
-					}
-				}
-			}
+		value, ok := directColumnValue(colInfo, data[columnName])
+		if !ok {
+			continue
 		}
 
 		if columnName == "permission" {
@@ -2520,6 +2509,145 @@ func (dbResource *DbResource) DirectInsert(typeName string, data map[string]inte
 	return err
 }
 
+// directColumnValue converts an imported value to the value stored in the column, datetime
+// columns accept text in any format understood by dateparse and nested maps and slices are stored
+// as JSON text. Returns false if the value cannot be stored
+func directColumnValue(colInfo *api2go.ColumnInfo, value interface{}) (interface{}, bool) {
+	switch value.(type) {
+	case map[string]interface{}, []interface{}:
+		return ToJson(value), true
+	}
+	if colInfo.ColumnType != "datetime" || value == nil {
+		return value, true
+	}
+	valStr, ok := value.(string)
+	if !ok {
+		return value, true
+	}
+	parsed, err := dateparse.ParseLocal(valStr)
+	if err != nil {
+		log.Errorf("Failed to parse value as time, insert will fail [%v][%v]: %v", colInfo.ColumnName, value, err)
+		return nil, false
+	}
+	return parsed, true
+}
+
+// SetRowTenant puts the tenant of the request on a row written around the api to a tenant scoped
+// table, like the create of the row through the api does
+func (dbResource *DbResource) SetRowTenant(data map[string]interface{}, req api2go.Request) {
+	if dbResource.tableInfo == nil || !dbResource.tableInfo.TenantScoped {
+		return
+	}
+	data[TenantColumnName] = tenantRowId(requestTenant(req))
+}
+
+// GetUpsertTargetWithTransaction is the row of `typeName` an upsert of data on keyColumn writes to,
+// nil when there is none. Rows of other tenants are not found.
+func (dbResource *DbResource) GetUpsertTargetWithTransaction(typeName string, keyColumn string, data map[string]interface{},
+	req api2go.Request, transaction *sqlx.Tx) (map[string]interface{}, error) {
+	keyInfo, ok := dbResource.tableInfo.GetColumnByName(keyColumn)
+	if !ok {
+		return nil, fmt.Errorf("no column named [%v] in [%v]", keyColumn, typeName)
+	}
+	keyValue, ok := data[keyColumn]
+	if !ok || keyValue == nil {
+		return nil, nil
+	}
+	if keyColumn == "reference_id" {
+		referenceId := daptinid.InterfaceToDIR(keyValue)
+		keyValue = referenceId[:]
+	}
+
+	query := statementbuilder.Squirrel.Select(goqu.I("reference_id")).Prepared(true).
+		From(typeName).Where(goqu.Ex{keyInfo.ColumnName: keyValue}).Limit(1)
+	if tenantFilter := dbResource.tenantFilter(typeName, requestTenant(req)); tenantFilter != nil {
+		query = query.Where(tenantFilter)
+	}
+	sql1, args, err := query.ToSQL()
+	if err != nil {
+		return nil, err
+	}
+	var referenceId daptinid.DaptinReferenceId
+	err = transaction.QueryRowx(sql1, args...).Scan(&referenceId)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return dbResource.GetReferenceIdToObjectWithTransaction(typeName, referenceId, transaction)
+}
+
+// DirectUpsert updates the row of `typeName` having the same value in keyColumn as data, or inserts
+// data as a new row when there is none. Like DirectInsert it does not check permissions or run
+// middlewares. Only the columns present in data are updated, the owner and permission of an
+// existing row are kept. The version of an updated row goes up and audited tables get an audit row
+// of the values before the update. Rows of other tenants are not updated, inserted rows belong to
+// the tenant of the request. Returns true if a row was inserted
+func (dbResource *DbResource) DirectUpsert(typeName string, keyColumn string, data map[string]interface{},
+	req api2go.Request, transaction *sqlx.Tx) (bool, error) {
+	existing, err := dbResource.GetUpsertTargetWithTransaction(typeName, keyColumn, data, req, transaction)
+	if err != nil {
+		return false, err
+	}
+	if existing == nil {
+		dbResource.SetRowTenant(data, req)
+		if data["version"] == nil {
+			data["version"] = 1
+		}
+		return true, dbResource.DirectInsert(typeName, data, transaction)
+	}
+
+	updates := goqu.Record{}
+	for columnName, value := range data {
+		if columnName == "id" || columnName == "permission" || columnName == USER_ACCOUNT_ID_COLUMN ||
+			columnName == keyColumn || columnName == "reference_id" || columnName == "version" ||
+			columnName == TenantColumnName {
+			continue
+		}
+		colInfo, ok := dbResource.tableInfo.GetColumnByName(columnName)
+		if !ok {
+			continue
+		}
+		value, ok = directColumnValue(colInfo, value)
+		if !ok {
+			continue
+		}
+		updates[columnName] = value
+	}
+	if len(updates) == 0 {
+		return false, nil
+	}
+
+	referenceId := daptinid.InterfaceToDIR(existing["reference_id"])
+	if dbResource.tableInfo.IsAuditEnabled {
+		err = dbResource.createAuditRow(existing, referenceId, AuditOperationUpdate, req, transaction)
+		if err != nil {
+			log.Errorf("Failed to create audit entry for upsert of [%v][%v]: %v", typeName, referenceId, err)
+			return false, err
+		}
+	}
+	if _, ok := dbResource.tableInfo.GetColumnByName("version"); ok {
+		updates["version"] = goqu.L("COALESCE(version, 0) + 1")
+	}
+	if _, ok := dbResource.tableInfo.GetColumnByName("updated_at"); ok {
+		if _, isSet := updates["updated_at"]; !isSet {
+			updates["updated_at"] = time.Now()
+		}
+	}
+
+	query, args, err := statementbuilder.Squirrel.Update(typeName).Prepared(true).
+		Set(updates).Where(goqu.Ex{"reference_id": referenceId[:]}).ToSQL()
+	if err != nil {
+		return false, err
+	}
+	_, err = transaction.Exec(query, args...)
+	if err != nil {
+		log.Errorf("Failed SQL  [%v] [%v]", query, args)
+	}
+	return false, err
+}
+
 // GetAllObjects Gets all rows from the table `typeName`
 // Returns an array of Map object, each object has the column name to value mapping
 // Utility method for loading all objects having low count
@@ -2701,7 +2829,8 @@ func (dbResource *DbResource) GetAllRawObjects(typeName string) ([]map[string]in
 }
 
 func (dbResource *DbResource) GetAllRawObjectsWithTransaction(typeName string, transaction *sqlx.Tx) ([]map[string]interface{}, error) {
-	s, q, err := statementbuilder.Squirrel.Select(goqu.L("*")).Prepared(true).From(typeName).ToSQL()
+	s, q, err := statementbuilder.Squirrel.Select(goqu.L("*")).Prepared(true).From(typeName).
+		Where(dbResource.withoutSoftDeleted(typeName, goqu.Ex{})).ToSQL()
 	if err != nil {
 		return nil, err
 	}
@@ -3569,6 +3698,9 @@ func (dbResource *DbResource) ResultToArrayOfMapWithTransaction(
 					}
 					duration := time.Since(start)
 					log.Tracef("[TIMING] RowsToMap IdToObject: %v", duration)
+					if obj[SoftDeleteColumnName] != nil && dbResource.Cruds[namespace] != nil && dbResource.Cruds[namespace].tableInfo.SoftDelete {
+						continue
+					}
 
 					obj["__type"] = namespace
 
@@ -3720,9 +3852,9 @@ func (dbResource *DbResource) ResultToArrayOfMapWithTransaction(
 						continue
 					}
 
-					includes1, err := dbResource.Cruds[relation.GetObject()].GetAllObjectsWithWhereWithTransaction(relation.GetObject(), transaction, goqu.Ex{
+					includes1, err := dbResource.Cruds[relation.GetObject()].GetAllObjectsWithWhereWithTransaction(relation.GetObject(), transaction, dbResource.withoutSoftDeleted(relation.GetObject(), goqu.Ex{
 						"id": ids,
-					})
+					}))
 
 					_, ok := row[relation.GetObjectName()]
 					if !ok {
@@ -3798,9 +3930,9 @@ func (dbResource *DbResource) ResultToArrayOfMapWithTransaction(
 						continue
 					}
 
-					localSubjectInclude, err := dbResource.Cruds[relation.GetSubject()].GetAllObjectsWithWhereWithTransaction(relation.GetSubject(), transaction, goqu.Ex{
+					localSubjectInclude, err := dbResource.Cruds[relation.GetSubject()].GetAllObjectsWithWhereWithTransaction(relation.GetSubject(), transaction, dbResource.withoutSoftDeleted(relation.GetSubject(), goqu.Ex{
 						"id": includedSubjectId,
-					})
+					}))
 					CheckErr(err, "[1923] failed to get object by od")
 
 					_, ok := row[relation.GetSubjectName()]
@@ -3893,9 +4025,9 @@ func (dbResource *DbResource) ResultToArrayOfMapWithTransaction(
 						continue
 					}
 
-					includes1, err := dbResource.Cruds[relation.GetSubject()].GetAllObjectsWithWhereWithTransaction(relation.GetSubject(), transaction, goqu.Ex{
+					includes1, err := dbResource.Cruds[relation.GetSubject()].GetAllObjectsWithWhereWithTransaction(relation.GetSubject(), transaction, dbResource.withoutSoftDeleted(relation.GetSubject(), goqu.Ex{
 						"id": ids,
-					})
+					}))
 					if err != nil {
 						log.Errorf("Failed to get objects by where clause: %v", err)
 						return nil, nil, err
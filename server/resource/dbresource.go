@@ -70,6 +70,37 @@ func (dbResource *DbResource) Connection() database.DatabaseConnection {
 	return dbResource.connection
 }
 
+// ReadConnection is the connection for reads of a request, a read replica when replicas are configured
+// and the request neither asked for ?consistency=strong nor wrote anything yet
+func (dbResource *DbResource) ReadConnection(ctx context.Context) database.DatabaseConnection {
+	replicaSet, ok := dbResource.connection.(*database.ReplicaSet)
+	if !ok || database.ReadFromPrimary(ctx) {
+		return dbResource.connection
+	}
+	return replicaSet.Reader()
+}
+
+// BeginReadTransaction begins a transaction on the ReadConnection, falling back to the primary when
+// the replica cannot be reached
+func (dbResource *DbResource) BeginReadTransaction(ctx context.Context) (*sqlx.Tx, error) {
+	connection := dbResource.ReadConnection(ctx)
+	transaction, err := connection.Beginx()
+	if err != nil && connection != dbResource.connection {
+		dbResource.connection.(*database.ReplicaSet).MarkDown(connection, err)
+		return dbResource.connection.Beginx()
+	}
+	return transaction, err
+}
+
+// requestContext is the context of the http request behind req, requests made by daptin itself
+// have none
+func requestContext(req api2go.Request) context.Context {
+	if req.PlainRequest == nil {
+		return context.Background()
+	}
+	return req.PlainRequest.Context()
+}
+
 func (dbResource *DbResource) SubsiteFolderCache(id daptinid.DaptinReferenceId) (*assetcachepojo.AssetFolderCache, bool) {
 	val, ok := dbResource.subsiteFolderCache[id]
 	return val, ok
@@ -91,6 +122,7 @@ func NewDbResource(model api2go.Api2GoModel, db database.DatabaseConnection,
 
 	if OlricCache == nil {
 		OlricCache, _ = olricDb.NewDMap("default-cache")
+		OlricCache = MeteredCache(OlricCache)
 	}
 	tx, err := db.Beginx()
 	administratorGroupId, err := GetIdToReferenceIdWithTransaction("usergroup", 2, tx)
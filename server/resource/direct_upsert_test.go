@@ -0,0 +1,199 @@
+package resource
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/artpar/api2go/v2"
+	"github.com/daptin/daptin/server/auth"
+	daptinid "github.com/daptin/daptin/server/id"
+	"github.com/daptin/daptin/server/table_info"
+	"github.com/daptin/daptin/server/tenant"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestDirectUpsertKeepsOwnerAndPermission(t *testing.T) {
+	db, err := sqlx.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	noteRef := daptinid.DaptinReferenceId(uuid.New())
+	for _, statement := range []struct {
+		query string
+		args  []interface{}
+	}{
+		{`create table note (id integer primary key, reference_id blob not null unique, title text, permission integer, user_account_id integer)`, nil},
+		{`insert into note (id, reference_id, title, permission, user_account_id) values (1, ?, 'first', 704, 7)`, []interface{}{noteRef[:]}},
+	} {
+		if _, err := db.Exec(statement.query, statement.args...); err != nil {
+			t.Fatalf("setup statement failed: %v", err)
+		}
+	}
+
+	columns := []api2go.ColumnInfo{
+		{Name: "reference_id", ColumnName: "reference_id"},
+		{Name: "title", ColumnName: "title", ColumnType: "label"},
+		{Name: "permission", ColumnName: "permission"},
+		{Name: USER_ACCOUNT_ID_COLUMN, ColumnName: USER_ACCOUNT_ID_COLUMN},
+	}
+	cruds := map[string]*DbResource{}
+	cruds["note"] = &DbResource{
+		model: api2go.NewApi2GoModel("note", columns, int64(auth.DEFAULT_PERMISSION), nil),
+		tableInfo: &table_info.TableInfo{
+			TableName:         "note",
+			Columns:           columns,
+			DefaultPermission: auth.DEFAULT_PERMISSION,
+		},
+		connection: db,
+		Cruds:      cruds,
+	}
+
+	tx := db.MustBegin()
+	defer tx.Rollback()
+	inserted, err := cruds["note"].DirectUpsert("note", "reference_id", map[string]interface{}{
+		"reference_id":         noteRef[:],
+		"title":                "imported",
+		"permission":           int64(auth.ALLOW_ALL_PERMISSIONS),
+		USER_ACCOUNT_ID_COLUMN: 1,
+	}, api2go.Request{}, tx)
+	if err != nil || inserted {
+		t.Fatalf("expected the existing row to be updated, inserted %v: %v", inserted, err)
+	}
+
+	var row struct {
+		Title      string `db:"title"`
+		Permission int64  `db:"permission"`
+		Owner      int64  `db:"user_account_id"`
+	}
+	if err = tx.Get(&row, "select title, permission, user_account_id from note where id = 1"); err != nil {
+		t.Fatalf("read note: %v", err)
+	}
+	if row.Title != "imported" || row.Permission != 704 || row.Owner != 7 {
+		t.Errorf("expected the title updated and the owner and permission kept, got %+v", row)
+	}
+}
+
+func TestDirectUpsertStaysInTheTenantBumpsTheVersionAndAudits(t *testing.T) {
+	db, err := sqlx.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	sharedKey := "key-1"
+	ownRef := daptinid.DaptinReferenceId(uuid.New())
+	otherRef := daptinid.DaptinReferenceId(uuid.New())
+	created := time.Now().Add(-time.Hour)
+	for _, statement := range []struct {
+		query string
+		args  []interface{}
+	}{
+		{`create table note (id integer primary key, reference_id blob not null unique, code text, title text, permission integer, version integer, created_at timestamp, updated_at timestamp, tenant_id integer)`, nil},
+		{`create table note_audit (id integer primary key, reference_id blob, code text, title text, permission integer, version integer, created_at timestamp, updated_at timestamp, tenant_id integer, source_reference_id text, operation text, audit_changed_by text, audit_request_id text, source_created_at timestamp)`, nil},
+		{`insert into note (id, reference_id, code, title, permission, version, created_at, tenant_id) values (1, ?, ?, 'ours', 704, 3, ?, 1)`, []interface{}{ownRef[:], sharedKey, created}},
+		{`insert into note (id, reference_id, code, title, permission, version, created_at, tenant_id) values (2, ?, ?, 'theirs', 704, 1, ?, 2)`, []interface{}{otherRef[:], "key-2", created}},
+	} {
+		if _, err := db.Exec(statement.query, statement.args...); err != nil {
+			t.Fatalf("setup statement failed: %v", err)
+		}
+	}
+
+	columns := []api2go.ColumnInfo{
+		{Name: "reference_id", ColumnName: "reference_id"},
+		{Name: "code", ColumnName: "code", ColumnType: "label"},
+		{Name: "title", ColumnName: "title", ColumnType: "label"},
+		{Name: "permission", ColumnName: "permission"},
+		{Name: "version", ColumnName: "version"},
+		{Name: "created_at", ColumnName: "created_at", ColumnType: "datetime"},
+		{Name: "updated_at", ColumnName: "updated_at", ColumnType: "datetime"},
+		TenantColumn,
+	}
+	auditColumns := append(append([]api2go.ColumnInfo{}, columns...),
+		api2go.ColumnInfo{Name: "source_reference_id", ColumnName: "source_reference_id"},
+		api2go.ColumnInfo{Name: "operation", ColumnName: "operation"},
+		api2go.ColumnInfo{Name: AuditChangedByColumn, ColumnName: AuditChangedByColumn},
+		api2go.ColumnInfo{Name: AuditRequestIdColumn, ColumnName: AuditRequestIdColumn},
+		api2go.ColumnInfo{Name: AuditSourceCreatedAtColumn, ColumnName: AuditSourceCreatedAtColumn, ColumnType: "datetime"},
+	)
+	cruds := map[string]*DbResource{}
+	for tableName, tableColumns := range map[string][]api2go.ColumnInfo{"note": columns, "note_audit": auditColumns} {
+		cruds[tableName] = &DbResource{
+			model: api2go.NewApi2GoModel(tableName, tableColumns, int64(auth.DEFAULT_PERMISSION), nil),
+			tableInfo: &table_info.TableInfo{
+				TableName:         tableName,
+				Columns:           tableColumns,
+				DefaultPermission: auth.DEFAULT_PERMISSION,
+				TenantScoped:      tableName == "note",
+				IsAuditEnabled:    tableName == "note",
+			},
+			connection: db,
+			ms:         &MiddlewareSet{},
+			Cruds:      cruds,
+		}
+	}
+	previousUserAccount := CRUD_MAP[USER_ACCOUNT_TABLE_NAME]
+	CRUD_MAP[USER_ACCOUNT_TABLE_NAME] = &DbResource{AdministratorGroupId: daptinid.DaptinReferenceId(uuid.New())}
+	defer func() { CRUD_MAP[USER_ACCOUNT_TABLE_NAME] = previousUserAccount }()
+
+	ctx := tenant.WithTenant(context.Background(), &tenant.Tenant{Id: 1})
+	req := api2go.Request{PlainRequest: (&http.Request{Method: "POST", URL: &url.URL{Path: "/note"}}).WithContext(ctx)}
+	tx := db.MustBegin()
+	defer tx.Rollback()
+
+	inserted, err := cruds["note"].DirectUpsert("note", "code", map[string]interface{}{"code": sharedKey, "title": "imported"}, req, tx)
+	if err != nil || inserted {
+		t.Fatalf("expected the row of the tenant to be updated, inserted %v: %v", inserted, err)
+	}
+	var row struct {
+		Title   string `db:"title"`
+		Version int64  `db:"version"`
+	}
+	if err = tx.Get(&row, "select title, version from note where id = 1"); err != nil {
+		t.Fatalf("read note: %v", err)
+	}
+	if row.Title != "imported" || row.Version != 4 {
+		t.Errorf("expected the title updated and the version bumped, got %+v", row)
+	}
+	var audit struct {
+		Title     string `db:"title"`
+		Version   int64  `db:"version"`
+		Source    string `db:"source_reference_id"`
+		Operation string `db:"operation"`
+	}
+	if err = tx.Get(&audit, "select title, version, source_reference_id, operation from note_audit"); err != nil {
+		t.Fatalf("read audit row: %v", err)
+	}
+	if audit.Title != "ours" || audit.Version != 3 || audit.Source != ownRef.String() || audit.Operation != AuditOperationUpdate {
+		t.Errorf("expected an audit row of the values before the upsert, got %+v", audit)
+	}
+
+	// the key of a row of another tenant is not found, the row is inserted for the tenant of the request
+	inserted, err = cruds["note"].DirectUpsert("note", "code", map[string]interface{}{
+		"reference_id": uuid.New().String(),
+		"code":         "key-2",
+		"title":        "ours too",
+	}, req, tx)
+	if err != nil || !inserted {
+		t.Fatalf("expected a new row for the tenant, inserted %v: %v", inserted, err)
+	}
+	var theirs string
+	if err = tx.Get(&theirs, "select title from note where id = 2"); err != nil || theirs != "theirs" {
+		t.Errorf("the row of the other tenant should be kept, got %q: %v", theirs, err)
+	}
+	var tenantId, version int64
+	if err = tx.QueryRow("select tenant_id, version from note where title = 'ours too'").Scan(&tenantId, &version); err != nil {
+		t.Fatalf("read inserted note: %v", err)
+	}
+	if tenantId != 1 || version != 1 {
+		t.Errorf("expected the inserted row in tenant 1 at version 1, got tenant %d version %d", tenantId, version)
+	}
+}
@@ -7,7 +7,7 @@ import (
 )
 
 func TestExportActionsAreAdministratorOnly(t *testing.T) {
-	for _, actionName := range []string{"export_data", "export_csv_data"} {
+	for _, actionName := range []string{"export_data", "export_csv_data", "backup_system", "restore_system"} {
 		t.Run(actionName, func(t *testing.T) {
 			var found bool
 			for _, action := range SystemActions {
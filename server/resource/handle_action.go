@@ -6,7 +6,10 @@ import (
 	"errors"
 	"fmt"
 	"github.com/daptin/daptin/server/actionresponse"
+	"github.com/daptin/daptin/server/database"
 	daptinid "github.com/daptin/daptin/server/id"
+	"github.com/daptin/daptin/server/telemetry"
+	"github.com/daptin/daptin/server/tenant"
 	"github.com/jmoiron/sqlx"
 	"os"
 	"path/filepath"
@@ -93,8 +96,17 @@ func CreatePostActionHandler(initConfig *CmsConfig,
 		actionName := ginContext.Param("actionName")
 		actionType := ginContext.Param("typename")
 
-		actionRequest, err := BuildActionRequest(ginContext.Request.Body, actionType, actionName,
-			ginContext.Params, ginContext.Request.URL.Query())
+		// files of a multipart body are left in the body for the action to stream
+		var actionRequest actionresponse.ActionRequest
+		var multipartFiles *MultipartFiles
+		var err error
+		if isMultipartRequest(ginContext.Request) {
+			actionRequest, multipartFiles, err = BuildMultipartActionRequest(ginContext.Request, actionType, actionName,
+				ginContext.Params, ginContext.Request.URL.Query())
+		} else {
+			actionRequest, err = BuildActionRequest(ginContext.Request.Body, actionType, actionName,
+				ginContext.Params, ginContext.Request.URL.Query())
+		}
 
 		if err != nil {
 			ginContext.Error(err)
@@ -110,7 +122,7 @@ func CreatePostActionHandler(initConfig *CmsConfig,
 			},
 		}
 
-		req.PlainRequest = req.PlainRequest.WithContext(ginContext.Request.Context())
+		req.PlainRequest = req.PlainRequest.WithContext(withMultipartFiles(ginContext.Request.Context(), multipartFiles))
 
 		actionCrudResource, ok := cruds[actionType]
 		if !ok {
@@ -221,7 +233,18 @@ func CreatePostActionHandler(initConfig *CmsConfig,
 func (dbResource *DbResource) HandleActionRequest(actionRequest actionresponse.ActionRequest,
 	req api2go.Request, transaction *sqlx.Tx) ([]actionresponse.ActionResponse, error) {
 
+	ctx, span := telemetry.StartSpan(req.PlainRequest.Context(), "action "+actionRequest.Type+"."+actionRequest.Action)
+	req.PlainRequest = req.PlainRequest.WithContext(ctx)
+	responses, err := dbResource.handleActionRequest(actionRequest, req, transaction)
+	telemetry.EndSpan(span, err)
+	return responses, err
+}
+
+func (dbResource *DbResource) handleActionRequest(actionRequest actionresponse.ActionRequest,
+	req api2go.Request, transaction *sqlx.Tx) ([]actionresponse.ActionResponse, error) {
+
 	start := time.Now()
+	database.MarkWrite(req.PlainRequest.Context())
 	user := req.PlainRequest.Context().Value("user")
 	sessionUser := &auth.SessionUser{}
 
@@ -443,6 +466,10 @@ OutFields:
 			}
 		}
 		model = *modelPointer
+		// outcomes write to the tenant the action is performed in
+		if t := requestTenant(req); t != nil && request.PlainRequest != nil {
+			request.PlainRequest = request.PlainRequest.WithContext(tenant.WithTenant(request.PlainRequest.Context(), t))
+		}
 
 		requestContext := req.PlainRequest.Context()
 		//var adminUserReferenceId daptinid.DaptinReferenceId
@@ -606,7 +633,7 @@ OutFields:
 				performerFields["requestSessionUser"] = requestSessionUser
 				performerFields["httpRequest"] = req.PlainRequest
 				performerFields["httpRequestHeaders"] = map[string][]string(req.PlainRequest.Header)
-				responder, responses1, errors1 = performer.DoAction(outcome, performerFields, transaction)
+				responder, responses1, errors1 = performAction(performer, outcome, performerFields, req, transaction)
 
 				actionResponses = append(actionResponses, responses1...)
 				if len(errors1) > 0 {
@@ -649,7 +676,7 @@ OutFields:
 			performerFields["requestSessionUser"] = requestSessionUser
 			performerFields["httpRequest"] = req.PlainRequest
 			performerFields["httpRequestHeaders"] = map[string][]string(req.PlainRequest.Header)
-			responder, responses1, err1 := handler.DoAction(outcome, performerFields, transaction)
+			responder, responses1, err1 := performAction(handler, outcome, performerFields, req, transaction)
 			if err1 != nil {
 				err = err1[0]
 			} else {
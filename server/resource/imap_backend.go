@@ -11,6 +11,7 @@ import (
 	"github.com/buraksezer/olric"
 	"github.com/daptin/daptin/server/auth"
 	daptinid "github.com/daptin/daptin/server/id"
+	"github.com/daptin/daptin/server/telemetry"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -51,6 +52,12 @@ func (be *DaptinImapBackend) LoginMd5(conn *imap.ConnInfo, username, challenge s
 }
 
 func (be *DaptinImapBackend) Login(conn *imap.ConnInfo, username, password string) (backend.User, error) {
+	user, err := be.login(conn, username, password)
+	telemetry.MailSession("imap", err)
+	return user, err
+}
+
+func (be *DaptinImapBackend) login(conn *imap.ConnInfo, username, password string) (backend.User, error) {
 	log.Printf("[IMAP] Login: starting for user %s", username)
 
 	// Brute force protection: check failed login count via Olric
@@ -0,0 +1,233 @@
+package resource
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/daptin/daptin/server/table_info"
+)
+
+// maxObservedQueryShapes bounds the filter combinations the index advisor keeps, new combinations
+// are not counted once it is reached
+const maxObservedQueryShapes = 1000
+
+// maxSuggestedIndexColumns is how many columns a suggested index has at most
+const maxSuggestedIndexColumns = 4
+
+// equalityOperators are the filter operators an index serves by an exact match, rangeOperators the
+// ones it serves by a range scan. Other operators (like, not, fuzzy) are not helped by an index.
+var equalityOperators = map[string]bool{
+	"is":       true,
+	"eq":       true,
+	"=":        true,
+	"in":       true,
+	"is nil":   true,
+	"is true":  true,
+	"is false": true,
+}
+
+var rangeOperators = map[string]bool{
+	"lt":  true,
+	"lte": true,
+	"gt":  true,
+	"gte": true,
+}
+
+// IndexSuggestion is an index the index advisor suggests for a combination of filters and sort
+// columns observed on a table, with the time the queries took
+type IndexSuggestion struct {
+	Table     string                `json:"table"`
+	Columns   []string              `json:"columns"`
+	Queries   int64                 `json:"queries"`
+	AverageMs float64               `json:"average_ms"`
+	MaxMs     float64               `json:"max_ms"`
+	TotalMs   float64               `json:"total_ms"`
+	Index     table_info.TableIndex `json:"index"`
+}
+
+type queryShape struct {
+	table    string
+	columns  []string
+	equality int
+	count    int64
+	total    time.Duration
+	max      time.Duration
+}
+
+type indexAdvisor struct {
+	lock   sync.Mutex
+	shapes map[string]*queryShape
+}
+
+var queryIndexAdvisor = &indexAdvisor{shapes: make(map[string]*queryShape)}
+
+// ObserveFilteredQuery counts a find all query on the table with its filters and sort order, for
+// the index advisor
+func ObserveFilteredQuery(tableName string, queries []Query, sortOrder []string, duration time.Duration) {
+	columns, equality := indexCandidateColumns(queries, sortOrder)
+	if len(columns) == 0 {
+		return
+	}
+	queryIndexAdvisor.observe(tableName, columns, equality, duration)
+}
+
+// indexCandidateColumns are the columns an index serving the filters would have: the columns
+// matched exactly first, then the first range matched column, or the sort columns when there is
+// no range. The second value is how many of the columns are matched exactly.
+func indexCandidateColumns(queries []Query, sortOrder []string) ([]string, int) {
+	columns := make([]string, 0)
+	seen := make(map[string]bool)
+	add := func(columnName string) {
+		if columnName == "" || columnName == "id" || columnName == "reference_id" || seen[columnName] {
+			return
+		}
+		seen[columnName] = true
+		columns = append(columns, columnName)
+	}
+
+	rangeColumn := ""
+	for _, query := range queries {
+		operator, ok := OperatorMap[query.Operator]
+		if !ok {
+			operator = query.Operator
+		}
+		if equalityOperators[operator] {
+			add(query.ColumnName)
+		} else if rangeOperators[operator] && rangeColumn == "" {
+			rangeColumn = query.ColumnName
+		}
+	}
+	// columns matched exactly are kept in a stable order, so the same filters in another order
+	// count as the same query
+	sort.Strings(columns)
+	equality := len(columns)
+
+	if rangeColumn != "" {
+		add(rangeColumn)
+	} else {
+		for _, sortColumn := range sortOrder {
+			add(strings.TrimLeft(sortColumn, "+-"))
+		}
+	}
+
+	if len(columns) > maxSuggestedIndexColumns {
+		columns = columns[:maxSuggestedIndexColumns]
+	}
+	if equality > len(columns) {
+		equality = len(columns)
+	}
+	return columns, equality
+}
+
+func (advisor *indexAdvisor) observe(tableName string, columns []string, equality int, duration time.Duration) {
+	key := tableName + "|" + strings.Join(columns, ",")
+
+	advisor.lock.Lock()
+	defer advisor.lock.Unlock()
+
+	shape, ok := advisor.shapes[key]
+	if !ok {
+		if len(advisor.shapes) >= maxObservedQueryShapes {
+			return
+		}
+		shape = &queryShape{table: tableName, columns: columns, equality: equality}
+		advisor.shapes[key] = shape
+	}
+	shape.count++
+	shape.total += duration
+	if duration > shape.max {
+		shape.max = duration
+	}
+}
+
+// IndexSuggestions are the indexes missing for the observed queries whose average time is at least
+// minAverage, the ones the queries spent the most time on first
+func IndexSuggestions(cruds map[string]*DbResource, minAverage time.Duration) []IndexSuggestion {
+	queryIndexAdvisor.lock.Lock()
+	shapes := make([]queryShape, 0, len(queryIndexAdvisor.shapes))
+	for _, shape := range queryIndexAdvisor.shapes {
+		shapes = append(shapes, *shape)
+	}
+	queryIndexAdvisor.lock.Unlock()
+
+	suggestions := make([]IndexSuggestion, 0)
+	for _, shape := range shapes {
+		average := shape.total / time.Duration(shape.count)
+		if average < minAverage {
+			continue
+		}
+		dbResource, ok := cruds[shape.table]
+		if !ok || dbResource.TableInfo() == nil {
+			continue
+		}
+		if indexCovers(tableIndexColumns(dbResource.TableInfo()), shape.columns, shape.equality) {
+			continue
+		}
+		suggestions = append(suggestions, IndexSuggestion{
+			Table:     shape.table,
+			Columns:   shape.columns,
+			Queries:   shape.count,
+			AverageMs: durationMs(average),
+			MaxMs:     durationMs(shape.max),
+			TotalMs:   durationMs(shape.total),
+			Index:     table_info.TableIndex{Columns: shape.columns},
+		})
+	}
+
+	sort.Slice(suggestions, func(i, j int) bool {
+		return suggestions[i].TotalMs > suggestions[j].TotalMs
+	})
+	return suggestions
+}
+
+// tableIndexColumns are the column lists of the indexes a table has: the indexed and unique
+// columns, the composite keys and the declared indexes on columns
+func tableIndexColumns(tableInfo *table_info.TableInfo) [][]string {
+	indexes := make([][]string, 0)
+	for _, column := range tableInfo.Columns {
+		if column.IsIndexed || column.IsUnique || column.IsPrimaryKey {
+			indexes = append(indexes, []string{column.ColumnName})
+		}
+	}
+	indexes = append(indexes, tableInfo.CompositeKeys...)
+	for _, index := range tableInfo.Indexes {
+		if index.Expression == "" && index.Where == "" && len(index.Columns) > 0 {
+			indexes = append(indexes, index.Columns)
+		}
+	}
+	return indexes
+}
+
+// indexCovers tells if one of the indexes serves the candidate columns: its leading columns are the
+// columns matched exactly, in any order, followed by the rest of the candidate in order
+func indexCovers(indexes [][]string, columns []string, equality int) bool {
+	for _, index := range indexes {
+		if len(index) < len(columns) {
+			continue
+		}
+		leading := make(map[string]bool)
+		for _, columnName := range index[:equality] {
+			leading[columnName] = true
+		}
+		covers := true
+		for _, columnName := range columns[:equality] {
+			if !leading[columnName] {
+				covers = false
+				break
+			}
+		}
+		for i := equality; covers && i < len(columns); i++ {
+			covers = index[i] == columns[i]
+		}
+		if covers {
+			return true
+		}
+	}
+	return false
+}
+
+func durationMs(duration time.Duration) float64 {
+	return float64(duration.Microseconds()) / 1000
+}
@@ -0,0 +1,70 @@
+package resource
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/artpar/api2go/v2"
+	"github.com/daptin/daptin/server/table_info"
+)
+
+func TestIndexCandidateColumns(t *testing.T) {
+	queries := []Query{
+		{ColumnName: "status", Operator: "is", Value: "open"},
+		{ColumnName: "title", Operator: "contains", Value: "printer"},
+		{ColumnName: "created_at", Operator: "after", Value: "2024-01-01"},
+		{ColumnName: "assignee", Operator: "any of", Value: []string{"a", "b"}},
+	}
+	columns, equality := indexCandidateColumns(queries, []string{"-priority"})
+	if !reflect.DeepEqual(columns, []string{"assignee", "status", "created_at"}) || equality != 2 {
+		t.Errorf("expected the exact matches then the range column, got %v %d", columns, equality)
+	}
+
+	columns, equality = indexCandidateColumns([]Query{{ColumnName: "status", Operator: "eq", Value: "open"}}, []string{"-priority"})
+	if !reflect.DeepEqual(columns, []string{"status", "priority"}) || equality != 1 {
+		t.Errorf("expected the exact matches then the sort columns, got %v %d", columns, equality)
+	}
+
+	columns, _ = indexCandidateColumns([]Query{{ColumnName: "title", Operator: "contains", Value: "x"}}, nil)
+	if len(columns) != 0 {
+		t.Errorf("like filters should not suggest an index, got %v", columns)
+	}
+}
+
+func TestIndexSuggestions(t *testing.T) {
+	queryIndexAdvisor = &indexAdvisor{shapes: make(map[string]*queryShape)}
+	defer func() {
+		queryIndexAdvisor = &indexAdvisor{shapes: make(map[string]*queryShape)}
+	}()
+
+	tableInfo := &table_info.TableInfo{
+		TableName: "ticket",
+		Columns: []api2go.ColumnInfo{
+			{ColumnName: "status"},
+			{ColumnName: "priority"},
+			{ColumnName: "email", IsIndexed: true},
+		},
+	}
+	cruds := map[string]*DbResource{"ticket": {tableInfo: tableInfo}}
+
+	for i := 0; i < 3; i++ {
+		ObserveFilteredQuery("ticket", []Query{{ColumnName: "status", Operator: "is", Value: "open"}}, []string{"priority"}, 30*time.Millisecond)
+	}
+	ObserveFilteredQuery("ticket", []Query{{ColumnName: "email", Operator: "is", Value: "a@example.com"}}, nil, 50*time.Millisecond)
+	ObserveFilteredQuery("ticket", []Query{{ColumnName: "priority", Operator: "is", Value: 1}}, nil, time.Millisecond)
+
+	suggestions := IndexSuggestions(cruds, 10*time.Millisecond)
+	if len(suggestions) != 1 {
+		t.Fatalf("expected one suggestion, the indexed column and the fast query left out, got %v", suggestions)
+	}
+	suggestion := suggestions[0]
+	if !reflect.DeepEqual(suggestion.Index.Columns, []string{"status", "priority"}) || suggestion.Queries != 3 || suggestion.AverageMs != 30 {
+		t.Errorf("unexpected suggestion %+v", suggestion)
+	}
+
+	tableInfo.Indexes = []table_info.TableIndex{{Columns: []string{"status", "priority", "email"}}}
+	if suggestions = IndexSuggestions(cruds, 10*time.Millisecond); len(suggestions) != 0 {
+		t.Errorf("a declared index should cover the query, got %v", suggestions)
+	}
+}
@@ -248,6 +248,12 @@ func decodeString(buffer *bytes.Buffer) (string, error) {
 func (pc *eventHandlerMiddleware) InterceptAfter(dr *DbResource, req *api2go.Request, results []map[string]interface{}, transaction *sqlx.Tx) ([]map[string]interface{}, error) {
 
 	tableName := dr.model.GetTableName()
+	switch strings.ToLower(req.PlainRequest.Method) {
+	case "post", "patch":
+		// groups the rows moved into, for rollups over this table
+		trackRollupChanges(dr, results, transaction)
+	}
+
 	topic := (*pc.dtopicMap)[tableName]
 	if topic == nil {
 		return results, nil
@@ -257,7 +263,7 @@ func (pc *eventHandlerMiddleware) InterceptAfter(dr *DbResource, req *api2go.Req
 	case "get":
 		break
 	case "post":
-		messageBytes, err := json.Marshal(results[0])
+		messageBytes, err := json.Marshal(tenantEventData(dr, *req, results[0]))
 		if err != nil {
 			log.Errorf("Failed to serialize create message: %v", err)
 		} else {
@@ -271,7 +277,7 @@ func (pc *eventHandlerMiddleware) InterceptAfter(dr *DbResource, req *api2go.Req
 		}
 		break
 	case "delete":
-		messageBytes, err := json.Marshal(results[0])
+		messageBytes, err := json.Marshal(tenantEventData(dr, *req, results[0]))
 		if err != nil {
 			log.Errorf("Failed to serialize delete message: %v", err)
 		} else {
@@ -285,7 +291,7 @@ func (pc *eventHandlerMiddleware) InterceptAfter(dr *DbResource, req *api2go.Req
 		}
 		break
 	case "patch":
-		messageBytes, err := json.Marshal(results[0])
+		messageBytes, err := json.Marshal(tenantEventData(dr, *req, results[0]))
 		if err != nil {
 			log.Errorf("Failed to serialize update message: %v", err)
 		} else {
@@ -306,6 +312,20 @@ func (pc *eventHandlerMiddleware) InterceptAfter(dr *DbResource, req *api2go.Req
 
 }
 
+// tenantEventData adds the tenant the row was written on to the event of a tenant scoped table, so
+// the event only goes to subscribers of that tenant
+func tenantEventData(dr *DbResource, req api2go.Request, row map[string]interface{}) map[string]interface{} {
+	if dr.tableInfo == nil || !dr.tableInfo.TenantScoped {
+		return row
+	}
+	data := make(map[string]interface{}, len(row)+1)
+	for key, value := range row {
+		data[key] = value
+	}
+	data[TenantColumnName] = tenantRowId(requestTenant(req))
+	return data
+}
+
 func (pc *eventHandlerMiddleware) InterceptBefore(dr *DbResource, req *api2go.Request, objects []map[string]interface{}, transaction *sqlx.Tx) ([]map[string]interface{}, error) {
 
 	reqmethod := req.PlainRequest.Method
@@ -315,9 +335,9 @@ func (pc *eventHandlerMiddleware) InterceptBefore(dr *DbResource, req *api2go.Re
 		break
 	case "POST":
 		break
-	case "DELETE":
-		break
-	case "PATCH":
+	case "DELETE", "PATCH":
+		// groups the rows are about to leave, for rollups over this table
+		trackRollupChanges(dr, objects, transaction)
 		break
 	default:
 		log.Errorf("Invalid method: %v", reqmethod)
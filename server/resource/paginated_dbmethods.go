@@ -2,10 +2,12 @@ package resource
 
 import (
 	"fmt"
+	"github.com/artpar/api2go/v2"
 	"github.com/daptin/daptin/server/statementbuilder"
 	"github.com/doug-martin/goqu/v9"
 	"github.com/jmoiron/sqlx"
 	log "github.com/sirupsen/logrus"
+	"io"
 )
 
 // PaginatedResultCallback is a function that processes each batch of results
@@ -15,6 +17,7 @@ type PaginatedResultCallback func([]map[string]interface{}) error
 func (dbResource *DbResource) GetAllRawObjectsWithPaginationAndTransaction(
 	typeName string,
 	pageSize int,
+	req api2go.Request,
 	transaction *sqlx.Tx,
 	callback PaginatedResultCallback, limit int) error {
 	log.Infof("Starting paginated export for table [%s] with page size %d", typeName, pageSize)
@@ -27,39 +30,9 @@ func (dbResource *DbResource) GetAllRawObjectsWithPaginationAndTransaction(
 	hasMore := true
 
 	for hasMore {
-		// Build query with pagination
-		s, q, err := statementbuilder.Squirrel.
-			Select(goqu.L("*")).
-			Prepared(true).
-			From(typeName).
-			Limit(uint(pageSize)).
-			Offset(uint(offset)).
-			ToSQL()
-
-		if err != nil {
-			return fmt.Errorf("failed to build paginated query: %v", err)
-		}
-
-		// Prepare statement
-		stmt, err := transaction.Preparex(s)
-		if err != nil {
-			return fmt.Errorf("failed to prepare paginated statement: %v", err)
-		}
-
-		// Execute query
-		rows, err := stmt.Queryx(q...)
-		if err != nil {
-			stmt.Close()
-			return fmt.Errorf("failed to execute paginated query: %v", err)
-		}
-
-		// Process results
-		results, err := RowsToMap(rows, typeName)
-		rows.Close()
-		stmt.Close()
-
+		results, err := dbResource.GetRawObjectsPage(typeName, pageSize, offset, req, transaction)
 		if err != nil {
-			return fmt.Errorf("failed to convert rows to map: %v", err)
+			return err
 		}
 
 		// Check if we have more results
@@ -86,10 +59,58 @@ func (dbResource *DbResource) GetAllRawObjectsWithPaginationAndTransaction(
 	return nil
 }
 
+// GetRawObjectsPage fetches pageSize rows of the table starting at offset, without permission checks.
+// Rows of a tenant scoped table are limited to the tenant of the request.
+func (dbResource *DbResource) GetRawObjectsPage(typeName string, pageSize int, offset int, req api2go.Request, transaction *sqlx.Tx) ([]map[string]interface{}, error) {
+	query := statementbuilder.Squirrel.
+		Select(goqu.L("*")).
+		Prepared(true).
+		From(typeName).
+		Where(dbResource.withoutSoftDeleted(typeName, goqu.Ex{}))
+	if tenantFilter := dbResource.tenantFilter(typeName, requestTenant(req)); tenantFilter != nil {
+		query = query.Where(tenantFilter)
+	}
+
+	// Build query with pagination
+	s, q, err := query.
+		Limit(uint(pageSize)).
+		Offset(uint(offset)).
+		ToSQL()
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to build paginated query: %v", err)
+	}
+
+	// Prepare statement
+	stmt, err := transaction.Preparex(s)
+	if err != nil {
+		return nil, fmt.Errorf("failed to prepare paginated statement: %v", err)
+	}
+	defer stmt.Close()
+
+	// Execute query
+	rows, err := stmt.Queryx(q...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute paginated query: %v", err)
+	}
+	defer rows.Close()
+
+	// Process results
+	results, err := RowsToMap(rows, typeName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert rows to map: %v", err)
+	}
+	// the generated search column is maintained by the database
+	for _, row := range results {
+		delete(row, SearchVectorColumn)
+	}
+	return results, nil
+}
+
 // StreamingExportWriter interface for different export format writers
 type StreamingExportWriter interface {
-	// Initialize prepares the writer for streaming
-	Initialize(tableNames []string, includeHeaders bool, selectedColumns map[string][]string) error
+	// Initialize prepares the writer for streaming the export to output
+	Initialize(output io.Writer, tableNames []string, includeHeaders bool, selectedColumns map[string][]string) error
 
 	// WriteTable writes a table name header if needed
 	WriteTable(tableName string) error
@@ -100,6 +121,6 @@ type StreamingExportWriter interface {
 	// WriteRows writes a batch of rows
 	WriteRows(tableName string, rows []map[string]interface{}) error
 
-	// Finalize completes the export and returns the final content
-	Finalize() ([]byte, error)
+	// Finalize completes the export and flushes what is left to the output
+	Finalize() error
 }
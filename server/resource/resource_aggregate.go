@@ -12,6 +12,7 @@ import (
 	"strings"
 
 	"github.com/daptin/daptin/server/statementbuilder"
+	"github.com/daptin/daptin/server/tenant"
 	"github.com/doug-martin/goqu/v9"
 )
 
@@ -29,6 +30,12 @@ type AggregationRequest struct {
 	TimeSample    TimeStamp `json:"timesample,omitempty"`
 	TimeFrom      string    `json:"timefrom,omitempty"`
 	TimeTo        string    `json:"timeto,omitempty"`
+	// Rollup reads the rows materialized for the named rollup instead of querying the tables
+	Rollup string `json:"rollup,omitempty"`
+	// Pivot turns the values of this group column into output columns
+	Pivot string `json:"pivot,omitempty"`
+	// Tenant is the tenant the request is served for, the rows of tenant scoped tables are limited to it
+	Tenant *tenant.Tenant `json:"-"`
 }
 
 type AggregateRow struct {
@@ -39,7 +46,8 @@ type AggregateRow struct {
 
 // PaginatedFindAll(req Request) (totalCount uint, response Responder, err error)
 type AggregateData struct {
-	Data []AggregateRow `json:"data"`
+	Data []AggregateRow         `json:"data"`
+	Meta map[string]interface{} `json:"meta,omitempty"`
 }
 
 func InArray(val []interface{}, ar interface{}) (exists bool) {
@@ -455,6 +463,13 @@ func (dbResource *DbResource) parseAggExpr(expr string, tables []string, allowAg
 // requested join. It is shared by authorization and query construction so a
 // joined table cannot bypass either layer.
 func (dbResource *DbResource) AggregationJoinTables(req AggregationRequest) ([]string, error) {
+	if req.Rollup != "" {
+		rollup, err := dbResource.rollupForRequest(req)
+		if err != nil {
+			return nil, err
+		}
+		req = rollup.request
+	}
 	tables := make([]string, 0, len(req.Join))
 	seen := make(map[string]bool)
 	for _, rawJoin := range req.Join {
@@ -583,7 +598,84 @@ func (dbResource *DbResource) parseHavingExpression(raw string, tables []string)
 	return builder(built[0]), nil
 }
 
+// aggregateQuery is the select built for an AggregationRequest. The group by expressions follow
+// the projections in the select list.
+type aggregateQuery struct {
+	builder           *goqu.SelectDataset
+	groupBy           []interface{}
+	projectionCount   int
+	joinedTables      []string
+	requestedGroupBys []string
+
+	// source is the builder before projections, grouping and ordering, percentiles the
+	// database cannot compute read their values from it
+	source      *goqu.SelectDataset
+	percentiles []aggregatePercentile
+	windows     []aggregateWindow
+	rowOrder    []aggregateSortKey
+	pivot       string
+	// groupColumns are the result columns of the group by expressions, known once the query ran
+	groupColumns []string
+}
+
 func (dbResource *DbResource) DataStats(req AggregationRequest, transaction *sqlx.Tx) (*AggregateData, error) {
+	if req.Rollup != "" {
+		return dbResource.rollupStats(req, transaction)
+	}
+
+	query, err := dbResource.buildAggregateQuery(req, transaction)
+	if err != nil {
+		return nil, err
+	}
+
+	sql, args, err := query.builder.ToSQL()
+	CheckErr(err, "Failed to generate stats sql: [%v]")
+	if err != nil {
+		return nil, err
+	}
+
+	log.Infof("Aggregation query: %v", sql)
+
+	stmt1, err := transaction.Preparex(sql)
+	if err != nil {
+		log.Errorf("[291] failed to prepare statment [%v]: %v", sql, err)
+		return nil, err
+	}
+	defer func(stmt1 *sqlx.Stmt) {
+		err := stmt1.Close()
+		if err != nil {
+			log.Errorf("failed to close prepared statement: %v", err)
+		}
+	}(stmt1)
+
+	queryResult, err := stmt1.Queryx(args...)
+	if err != nil {
+		CheckErr(err, "Failed to query stats: %v", sql)
+		return nil, err
+	}
+	defer func() {
+		if err := queryResult.Close(); err != nil {
+			log.Errorf("failed to close aggregate query result - %v", err)
+		}
+	}()
+
+	columns, err := queryResult.Columns()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := RowsToMap(queryResult, "aggregate_"+req.RootEntity)
+	CheckErr(err, "Failed to scan ")
+	stmt1.Close()
+
+	rows, err = dbResource.computeAggregateColumns(query, columns, rows, transaction)
+	if err != nil {
+		return nil, err
+	}
+
+	return dbResource.aggregateData(req.RootEntity, query, rows, transaction)
+}
+
+func (dbResource *DbResource) buildAggregateQuery(req AggregationRequest, transaction *sqlx.Tx) (*aggregateQuery, error) {
 	if !isSimpleIdentifier(req.RootEntity) || dbResource.Cruds[req.RootEntity] == nil {
 		return nil, invalidAggregation("entity", "unknown root entity %q", req.RootEntity)
 	}
@@ -610,13 +702,42 @@ func (dbResource *DbResource) DataStats(req AggregationRequest, transaction *sql
 	}
 	projections = updatedProjections
 
+	// Window functions, and percentiles the database cannot compute, are computed over the
+	// result rows and are left out of the select.
+	nativePercentile := transaction.DriverName() == "postgres"
+	sqlProjections := make([]string, 0, len(projections))
+	windows := make([]aggregateWindow, 0)
+	percentiles := make([]aggregatePercentile, 0)
+	computedColumns := make(map[string]bool)
 	for _, project := range projections {
 		project = strings.TrimSpace(project)
+		if window, ok, err := parseAggregateWindow(project); ok {
+			if err != nil {
+				return nil, invalidAggregation("column", "%v", err)
+			}
+			windows = append(windows, window)
+			computedColumns[window.alias] = true
+			continue
+		}
+		if percentile, ok, err := dbResource.parseAggregatePercentile(project, allowedTables); ok {
+			if err != nil {
+				return nil, invalidAggregation("column", "%v", err)
+			}
+			if nativePercentile {
+				projectionsAdded = append(projectionsAdded, percentile.expression())
+				sqlProjections = append(sqlProjections, project)
+			} else {
+				percentiles = append(percentiles, percentile)
+				computedColumns[percentile.alias] = true
+			}
+			continue
+		}
 		expr, err := dbResource.parseAggExpr(project, allowedTables, true)
 		if err != nil {
 			return nil, invalidAggregation("column", "%v", err)
 		}
 		projectionsAdded = append(projectionsAdded, expr)
+		sqlProjections = append(sqlProjections, project)
 	}
 
 	// Parse and validate group-by expressions.
@@ -631,20 +752,43 @@ func (dbResource *DbResource) DataStats(req AggregationRequest, transaction *sql
 		groupBysAdded = append(groupBysAdded, expr)
 	}
 
+	projectionCount := len(projectionsAdded) - len(groupBysAdded)
 	if len(projectionsAdded) == 0 {
 		projectionsAdded = append(projectionsAdded, goqu.COUNT(goqu.Star()).As("count"))
 	}
 
-	selectBuilder := statementbuilder.Squirrel.Select(projectionsAdded...).Prepared(true)
-	builder := selectBuilder.From(req.RootEntity)
-
-	builder = builder.GroupBy(groupBysAdded...)
+	outputColumns := aggregateOutputColumns(sqlProjections, requestedGroupBys)
+	for _, percentile := range percentiles {
+		outputColumns[percentile.alias] = true
+	}
+	for _, window := range windows {
+		if err := window.validate(outputColumns); err != nil {
+			return nil, invalidAggregation("column", "%v", err)
+		}
+		outputColumns[window.alias] = true
+	}
+	if req.Pivot != "" {
+		if !aggregateGroupColumns(requestedGroupBys)[req.Pivot] {
+			return nil, invalidAggregation("pivot", "pivot %q is not a group column", req.Pivot)
+		}
+	}
 
-	orderExpressions, err := dbResource.buildAggregateOrder(req.Order, projections, allowedTables)
-	if err != nil {
-		return nil, err
+	// Ordering by a computed column sorts the result rows instead of the select
+	var orderExpressions []exp.OrderedExpression
+	var rowOrder []aggregateSortKey
+	if orderUsesColumns(req.Order, computedColumns) {
+		rowOrder, err = parseAggregateSortKeys(req.Order, outputColumns)
+		if err != nil {
+			return nil, invalidAggregation("order", "%v", err)
+		}
+	} else {
+		orderExpressions, err = dbResource.buildAggregateOrder(req.Order, sqlProjections, allowedTables)
+		if err != nil {
+			return nil, err
+		}
 	}
-	builder = builder.Order(orderExpressions...)
+
+	builder := statementbuilder.Squirrel.From(req.RootEntity).Prepared(true)
 
 	whereExpressions := make([]goqu.Expression, 0)
 	for _, rawFilter := range req.Filter {
@@ -672,6 +816,12 @@ func (dbResource *DbResource) DataStats(req AggregationRequest, transaction *sql
 		}
 		whereExpressions = append(whereExpressions, whereClause)
 	}
+	if dbResource.Cruds[req.RootEntity].tableInfo.SoftDelete {
+		whereExpressions = append(whereExpressions, goqu.I(req.RootEntity+"."+SoftDeleteColumnName).IsNull())
+	}
+	if tenantFilter := dbResource.Cruds[req.RootEntity].tenantFilter(req.RootEntity, req.Tenant); tenantFilter != nil {
+		whereExpressions = append(whereExpressions, tenantFilter)
+	}
 	builder = builder.Where(whereExpressions...)
 
 	havingExpressions := make([]goqu.Expression, 0)
@@ -690,7 +840,6 @@ func (dbResource *DbResource) DataStats(req AggregationRequest, transaction *sql
 		}
 		havingExpressions = append(havingExpressions, predicate)
 	}
-	builder = builder.Having(havingExpressions...)
 
 	for _, join := range req.Join {
 		joinParts := strings.SplitN(join, "@", 2)
@@ -760,45 +909,44 @@ func (dbResource *DbResource) DataStats(req AggregationRequest, transaction *sql
 			}
 			joinWhereList = append(joinWhereList, joinWhere)
 		}
+		if joinResource := dbResource.Cruds[joinTable]; joinResource != nil && joinResource.tableInfo.SoftDelete {
+			joinWhereList = append(joinWhereList, goqu.I(joinTable+"."+SoftDeleteColumnName).IsNull())
+		}
+		if joinResource := dbResource.Cruds[joinTable]; joinResource != nil {
+			if tenantFilter := joinResource.tenantFilter(joinTable, req.Tenant); tenantFilter != nil {
+				joinWhereList = append(joinWhereList, tenantFilter)
+			}
+		}
 		builder = builder.LeftJoin(goqu.T(joinTable), goqu.On(joinWhereList...))
 
 	}
 
-	sql, args, err := builder.ToSQL()
-	CheckErr(err, "Failed to generate stats sql: [%v]")
-	if err != nil {
-		return nil, err
-	}
-
-	log.Infof("Aggregation query: %v", sql)
-
-	stmt1, err := transaction.Preparex(sql)
-	if err != nil {
-		log.Errorf("[291] failed to prepare statment [%v]: %v", sql, err)
-		return nil, err
-	}
-	defer func(stmt1 *sqlx.Stmt) {
-		err := stmt1.Close()
-		if err != nil {
-			log.Errorf("failed to close prepared statement: %v", err)
-		}
-	}(stmt1)
-
-	queryResult, err := stmt1.Queryx(args...)
-	if err != nil {
-		CheckErr(err, "Failed to query stats: %v", sql)
-		return nil, err
-	}
-	defer func() {
-		if err := queryResult.Close(); err != nil {
-			log.Errorf("failed to close aggregate query result - %v", err)
-		}
-	}()
+	source := builder
+	builder = builder.Select(projectionsAdded...).
+		GroupBy(groupBysAdded...).
+		Having(havingExpressions...).
+		Order(orderExpressions...)
+
+	return &aggregateQuery{
+		builder:           builder,
+		groupBy:           groupBysAdded,
+		projectionCount:   projectionCount,
+		joinedTables:      joinedTables,
+		requestedGroupBys: requestedGroupBys,
+		source:            source,
+		percentiles:       percentiles,
+		windows:           windows,
+		rowOrder:          rowOrder,
+		pivot:             req.Pivot,
+	}, nil
+}
 
-	returnModelName := "aggregate_" + req.RootEntity
-	rows, err := RowsToMap(queryResult, returnModelName)
-	CheckErr(err, "Failed to scan ")
-	stmt1.Close()
+// aggregateData converts foreign keys in grouped columns to reference ids and wraps the rows
+func (dbResource *DbResource) aggregateData(rootEntity string, query *aggregateQuery, rows []map[string]interface{}, transaction *sqlx.Tx) (*AggregateData, error) {
+	var err error
+	requestedGroupBys := query.requestedGroupBys
+	joinedTables := query.joinedTables
+	returnModelName := "aggregate_" + rootEntity
 
 	for _, groupedColumn := range requestedGroupBys {
 		var columnInfo *api2go.ColumnInfo
@@ -808,8 +956,8 @@ func (dbResource *DbResource) DataStats(req AggregationRequest, transaction *sql
 			groupedColumn = strings.Split(groupedColumn, ".")[1]
 		}
 
-		if dbResource.Cruds[req.RootEntity] != nil {
-			columnInfo, ok = dbResource.Cruds[req.RootEntity].TableInfo().GetColumnByName(groupedColumn)
+		if dbResource.Cruds[rootEntity] != nil {
+			columnInfo, ok = dbResource.Cruds[rootEntity].TableInfo().GetColumnByName(groupedColumn)
 		}
 
 		if columnInfo == nil {
@@ -854,6 +1002,13 @@ func (dbResource *DbResource) DataStats(req AggregationRequest, transaction *sql
 		}
 	}
 
+	if query.pivot != "" {
+		rows, err = pivotAggregateRows(rows, query.pivot, query.groupColumns)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	returnRows := make([]AggregateRow, 0)
 	for _, row := range rows {
 		newId, _ := uuid.NewV7()
@@ -0,0 +1,603 @@
+package resource
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/doug-martin/goqu/v9"
+	"github.com/doug-martin/goqu/v9/exp"
+	"github.com/jmoiron/sqlx"
+)
+
+// aggregateWindowFuncs is the allowlist of window functions accepted in the column parameter,
+// with the number of positional arguments each takes. Window functions are computed over the
+// result rows, so they behave the same on every database and can use the aggregated values.
+var aggregateWindowFuncs = map[string]int{
+	"row_number":  0,
+	"rank":        0,
+	"dense_rank":  0,
+	"running_sum": 1,
+	"moving_avg":  2,
+	"moving_sum":  2,
+}
+
+// maxMovingWindow bounds the frame of moving_avg and moving_sum
+const maxMovingWindow = 1000
+
+// maxAggregatePivotColumns bounds the number of distinct values a pivot turns into columns
+const maxAggregatePivotColumns = 200
+
+type aggregateSortKey struct {
+	column     string
+	descending bool
+}
+
+// aggregateWindow is a window function over the result rows. Its arguments name result
+// columns: group columns, aliased projections and earlier window functions.
+type aggregateWindow struct {
+	function  string
+	alias     string
+	column    string
+	size      int
+	partition []string
+	order     []aggregateSortKey
+}
+
+// aggregatePercentile is the continuous percentile of a column in each group, as computed by
+// percentile_cont
+type aggregatePercentile struct {
+	alias    string
+	column   string
+	fraction float64
+}
+
+// splitAggregateAlias peels off a trailing " as alias"
+func splitAggregateAlias(expr string) (string, string, error) {
+	expr = strings.TrimSpace(expr)
+	if idx := strings.LastIndex(expr, " as "); idx > 0 {
+		alias := strings.TrimSpace(expr[idx+4:])
+		if !isSimpleIdentifier(alias) {
+			return "", "", fmt.Errorf("invalid alias: %q", alias)
+		}
+		return strings.TrimSpace(expr[:idx]), alias, nil
+	}
+	return expr, "", nil
+}
+
+// splitAggregateCall splits name(args) and reports false when expr is not a call to one of names
+func splitAggregateCall(expr string, names func(string) bool) (string, []string, bool) {
+	openParen := strings.Index(expr, "(")
+	if openParen <= 0 || !strings.HasSuffix(expr, ")") {
+		return "", nil, false
+	}
+	name := strings.TrimSpace(expr[:openParen])
+	if !names(name) {
+		return "", nil, false
+	}
+	args := make([]string, 0)
+	for _, arg := range splitFuncArgs(expr[openParen+1 : len(expr)-1]) {
+		if arg = strings.TrimSpace(arg); arg != "" {
+			args = append(args, arg)
+		}
+	}
+	return name, args, true
+}
+
+func parseAggregateSortKey(raw string) (aggregateSortKey, error) {
+	raw = strings.TrimSpace(raw)
+	key := aggregateSortKey{column: raw}
+	if strings.HasPrefix(raw, "-") {
+		key = aggregateSortKey{column: strings.TrimSpace(raw[1:]), descending: true}
+	}
+	if !isSimpleIdentifier(key.column) {
+		return key, fmt.Errorf("invalid order column %q", raw)
+	}
+	return key, nil
+}
+
+// parseAggregateWindow parses row_number(), rank(order=-total), running_sum(total, order=month)
+// and moving_avg(total, 7, order=day, partition=status). The second result is false when expr
+// is not a window function.
+func parseAggregateWindow(expr string) (aggregateWindow, bool, error) {
+	expr, alias, err := splitAggregateAlias(expr)
+	if err != nil {
+		return aggregateWindow{}, false, nil
+	}
+	name, args, ok := splitAggregateCall(expr, func(name string) bool {
+		_, ok := aggregateWindowFuncs[name]
+		return ok
+	})
+	if !ok {
+		return aggregateWindow{}, false, nil
+	}
+
+	window := aggregateWindow{function: name, alias: alias}
+	if window.alias == "" {
+		window.alias = name
+	}
+	positional := make([]string, 0)
+	for _, arg := range args {
+		switch {
+		case strings.HasPrefix(arg, "partition="):
+			column := strings.TrimSpace(strings.TrimPrefix(arg, "partition="))
+			if !isSimpleIdentifier(column) {
+				return window, true, fmt.Errorf("invalid partition column %q in %s()", column, name)
+			}
+			window.partition = append(window.partition, column)
+		case strings.HasPrefix(arg, "order="):
+			key, err := parseAggregateSortKey(strings.TrimPrefix(arg, "order="))
+			if err != nil {
+				return window, true, fmt.Errorf("%v in %s()", err, name)
+			}
+			window.order = append(window.order, key)
+		default:
+			positional = append(positional, arg)
+		}
+	}
+	if len(positional) != aggregateWindowFuncs[name] {
+		return window, true, fmt.Errorf("%s() takes %d arguments besides partition and order", name, aggregateWindowFuncs[name])
+	}
+	if (name == "rank" || name == "dense_rank") && len(window.order) == 0 {
+		return window, true, fmt.Errorf("%s() requires an order", name)
+	}
+	if len(positional) > 0 {
+		if !isSimpleIdentifier(positional[0]) {
+			return window, true, fmt.Errorf("invalid column %q in %s()", positional[0], name)
+		}
+		window.column = positional[0]
+	}
+	if len(positional) > 1 {
+		size, err := strconv.Atoi(positional[1])
+		if err != nil || size < 1 || size > maxMovingWindow {
+			return window, true, fmt.Errorf("%s() window size must be between 1 and %d", name, maxMovingWindow)
+		}
+		window.size = size
+	}
+	return window, true, nil
+}
+
+// validate checks the window only names columns of the result
+func (window aggregateWindow) validate(outputColumns map[string]bool) error {
+	names := append([]string{}, window.partition...)
+	for _, key := range window.order {
+		names = append(names, key.column)
+	}
+	if window.column != "" {
+		names = append(names, window.column)
+	}
+	for _, name := range names {
+		if !outputColumns[name] {
+			return fmt.Errorf("%s() refers to %q which is not a result column", window.function, name)
+		}
+	}
+	return nil
+}
+
+// parseAggregatePercentile parses percentile(col, 0.95) and median(col). The second result is
+// false when expr is neither.
+func (dbResource *DbResource) parseAggregatePercentile(expr string, tables []string) (aggregatePercentile, bool, error) {
+	expr, alias, err := splitAggregateAlias(expr)
+	if err != nil {
+		return aggregatePercentile{}, false, nil
+	}
+	name, args, ok := splitAggregateCall(expr, func(name string) bool {
+		return name == "percentile" || name == "median"
+	})
+	if !ok {
+		return aggregatePercentile{}, false, nil
+	}
+
+	percentile := aggregatePercentile{alias: alias, fraction: 0.5}
+	if percentile.alias == "" {
+		percentile.alias = name
+	}
+	if name == "median" && len(args) != 1 {
+		return percentile, true, fmt.Errorf("median() takes exactly one argument")
+	}
+	if name == "percentile" {
+		if len(args) != 2 {
+			return percentile, true, fmt.Errorf("percentile() takes a column and a fraction")
+		}
+		fraction, err := strconv.ParseFloat(args[1], 64)
+		if err != nil || fraction < 0 || fraction > 1 {
+			return percentile, true, fmt.Errorf("percentile() fraction must be between 0 and 1")
+		}
+		percentile.fraction = fraction
+	}
+	if err := dbResource.validateColumnRef(args[0], tables); err != nil {
+		return percentile, true, fmt.Errorf("arg %q in %s(): %w", args[0], name, err)
+	}
+	percentile.column = args[0]
+	return percentile, true, nil
+}
+
+// expression is the percentile as an ordered-set aggregate, for databases which have one. Both
+// placeholders are bound to typed values, the column has been checked against the schema.
+func (percentile aggregatePercentile) expression() exp.AliasedExpression {
+	return goqu.L("percentile_cont(?) WITHIN GROUP (ORDER BY ?)", percentile.fraction, goqu.I(percentile.column)).As(percentile.alias)
+}
+
+// aggregateOutputName is the result column of a projection or group by expression, empty when
+// the database names it
+func aggregateOutputName(expr string) string {
+	expr = strings.TrimSpace(expr)
+	if expr == "count" {
+		return "count"
+	}
+	if idx := strings.LastIndex(expr, " as "); idx > 0 {
+		return strings.TrimSpace(expr[idx+4:])
+	}
+	if strings.Contains(expr, "(") {
+		return ""
+	}
+	if idx := strings.LastIndex(expr, "."); idx >= 0 {
+		return expr[idx+1:]
+	}
+	return expr
+}
+
+func aggregateGroupColumns(groups []string) map[string]bool {
+	columns := make(map[string]bool)
+	for _, group := range groups {
+		if name := aggregateOutputName(group); name != "" {
+			columns[name] = true
+		}
+	}
+	return columns
+}
+
+// aggregateOutputColumns are the named columns of the select built for the projections and groups
+func aggregateOutputColumns(projections []string, groups []string) map[string]bool {
+	columns := aggregateGroupColumns(groups)
+	if len(projections) == 0 && len(groups) == 0 {
+		columns["count"] = true
+	}
+	for _, projection := range projections {
+		if name := aggregateOutputName(projection); name != "" {
+			columns[name] = true
+		}
+	}
+	return columns
+}
+
+func orderUsesColumns(order []string, columns map[string]bool) bool {
+	for _, raw := range order {
+		if columns[strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(raw), "-"))] {
+			return true
+		}
+	}
+	return false
+}
+
+func parseAggregateSortKeys(order []string, outputColumns map[string]bool) ([]aggregateSortKey, error) {
+	keys := make([]aggregateSortKey, 0, len(order))
+	for _, raw := range order {
+		key, err := parseAggregateSortKey(raw)
+		if err != nil {
+			return nil, err
+		}
+		if !outputColumns[key.column] {
+			return nil, fmt.Errorf("ordering by a computed column only accepts result columns, %q is not one", key.column)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// computedInRows is true when part of the result is computed after the query runs
+func (query *aggregateQuery) computedInRows() bool {
+	return len(query.percentiles) > 0 || len(query.windows) > 0 || len(query.rowOrder) > 0 || query.pivot != ""
+}
+
+// computeAggregateColumns adds the percentiles the database could not compute and the window
+// functions to the result rows, then applies an order on computed columns
+func (dbResource *DbResource) computeAggregateColumns(query *aggregateQuery, columns []string, rows []map[string]interface{}, transaction *sqlx.Tx) ([]map[string]interface{}, error) {
+	groupCount := len(query.groupBy)
+	if query.projectionCount+groupCount <= len(columns) {
+		query.groupColumns = columns[query.projectionCount : query.projectionCount+groupCount]
+	}
+
+	for _, percentile := range query.percentiles {
+		if err := fillAggregatePercentile(query, percentile, rows, transaction); err != nil {
+			return nil, err
+		}
+	}
+	for _, window := range query.windows {
+		applyAggregateWindow(rows, window)
+	}
+	if len(query.rowOrder) > 0 {
+		sort.SliceStable(rows, func(i, j int) bool {
+			return compareAggregateRows(rows[i], rows[j], query.rowOrder) < 0
+		})
+	}
+	return rows, nil
+}
+
+// fillAggregatePercentile reads the values of the percentile column with the group by
+// expressions of their row and computes the percentile of each group
+func fillAggregatePercentile(query *aggregateQuery, percentile aggregatePercentile, rows []map[string]interface{}, transaction *sqlx.Tx) error {
+	selects := append(append([]interface{}{}, query.groupBy...), goqu.I(percentile.column))
+	sqlQuery, args, err := query.source.Select(selects...).ToSQL()
+	if err != nil {
+		return err
+	}
+	result, err := transaction.Queryx(sqlQuery, args...)
+	if err != nil {
+		CheckErr(err, "Failed to query percentile values: %v", sqlQuery)
+		return err
+	}
+	defer result.Close()
+
+	values := make(map[string][]float64)
+	for result.Next() {
+		scanned, err := result.SliceScan()
+		if err != nil {
+			return err
+		}
+		value, ok := aggregateNumber(scanned[len(scanned)-1])
+		if !ok {
+			continue
+		}
+		key := aggregateGroupKey(scanned[:len(scanned)-1])
+		values[key] = append(values[key], value)
+	}
+	if err := result.Err(); err != nil {
+		return err
+	}
+
+	for _, row := range rows {
+		groupValues := make([]interface{}, len(query.groupColumns))
+		for i, column := range query.groupColumns {
+			groupValues[i] = row[column]
+		}
+		row[percentile.alias] = percentileOf(values[aggregateGroupKey(groupValues)], percentile.fraction)
+	}
+	return nil
+}
+
+// percentileOf interpolates linearly between the closest ranks, like percentile_cont
+func percentileOf(values []float64, fraction float64) interface{} {
+	if len(values) == 0 {
+		return nil
+	}
+	sorted := append([]float64{}, values...)
+	sort.Float64s(sorted)
+	position := fraction * float64(len(sorted)-1)
+	lower := int(math.Floor(position))
+	upper := int(math.Ceil(position))
+	return sorted[lower] + (sorted[upper]-sorted[lower])*(position-float64(lower))
+}
+
+func applyAggregateWindow(rows []map[string]interface{}, window aggregateWindow) {
+	partitions := make(map[string][]int)
+	partitionKeys := make([]string, 0)
+	for i, row := range rows {
+		values := make([]interface{}, len(window.partition))
+		for j, column := range window.partition {
+			values[j] = row[column]
+		}
+		key := aggregateGroupKey(values)
+		if _, ok := partitions[key]; !ok {
+			partitionKeys = append(partitionKeys, key)
+		}
+		partitions[key] = append(partitions[key], i)
+	}
+
+	for _, key := range partitionKeys {
+		indices := partitions[key]
+		sort.SliceStable(indices, func(a, b int) bool {
+			return compareAggregateRows(rows[indices[a]], rows[indices[b]], window.order) < 0
+		})
+
+		var rank, denseRank int64
+		var runningSum float64
+		for position, index := range indices {
+			row := rows[index]
+			changed := position == 0 || compareAggregateRows(rows[indices[position-1]], row, window.order) != 0
+			switch window.function {
+			case "row_number":
+				row[window.alias] = int64(position + 1)
+			case "rank":
+				if changed {
+					rank = int64(position + 1)
+				}
+				row[window.alias] = rank
+			case "dense_rank":
+				if changed {
+					denseRank++
+				}
+				row[window.alias] = denseRank
+			case "running_sum":
+				if value, ok := aggregateNumber(row[window.column]); ok {
+					runningSum += value
+				}
+				row[window.alias] = runningSum
+			case "moving_avg", "moving_sum":
+				start := position - window.size + 1
+				if start < 0 {
+					start = 0
+				}
+				var sum float64
+				count := 0
+				for _, frameIndex := range indices[start : position+1] {
+					if value, ok := aggregateNumber(rows[frameIndex][window.column]); ok {
+						sum += value
+						count++
+					}
+				}
+				if count == 0 {
+					row[window.alias] = nil
+				} else if window.function == "moving_avg" {
+					row[window.alias] = sum / float64(count)
+				} else {
+					row[window.alias] = sum
+				}
+			}
+		}
+	}
+}
+
+// pivotAggregateRows merges the rows which differ only in the pivot column, the values of the
+// other result columns move to columns named after the pivot value, or <pivot value>_<column>
+// when there is more than one
+func pivotAggregateRows(rows []map[string]interface{}, pivot string, groupColumns []string) ([]map[string]interface{}, error) {
+	isGroupColumn := map[string]bool{"__type": true}
+	keyColumns := make([]string, 0, len(groupColumns))
+	for _, column := range groupColumns {
+		isGroupColumn[column] = true
+		if column != pivot {
+			keyColumns = append(keyColumns, column)
+		}
+	}
+	valueColumnSet := make(map[string]bool)
+	for _, row := range rows {
+		for column := range row {
+			if !isGroupColumn[column] {
+				valueColumnSet[column] = true
+			}
+		}
+	}
+	valueColumns := make([]string, 0, len(valueColumnSet))
+	for column := range valueColumnSet {
+		valueColumns = append(valueColumns, column)
+	}
+	sort.Strings(valueColumns)
+
+	pivotColumns := make([]string, 0)
+	seenPivotColumns := make(map[string]bool)
+	pivoted := make([]map[string]interface{}, 0)
+	pivotedByKey := make(map[string]map[string]interface{})
+	for _, row := range rows {
+		keyValues := make([]interface{}, len(keyColumns))
+		for i, column := range keyColumns {
+			keyValues[i] = row[column]
+		}
+		key := aggregateGroupKey(keyValues)
+		target, ok := pivotedByKey[key]
+		if !ok {
+			target = map[string]interface{}{"__type": row["__type"]}
+			for _, column := range keyColumns {
+				target[column] = row[column]
+			}
+			pivotedByKey[key] = target
+			pivoted = append(pivoted, target)
+		}
+
+		pivotValue := "null"
+		if row[pivot] != nil {
+			pivotValue = fmt.Sprint(row[pivot])
+		}
+		for _, column := range valueColumns {
+			name := pivotValue
+			if len(valueColumns) > 1 {
+				name = pivotValue + "_" + column
+			}
+			if !seenPivotColumns[name] {
+				if len(pivotColumns) >= maxAggregatePivotColumns {
+					return nil, invalidAggregation("pivot", "pivot %q produces more than %d columns", pivot, maxAggregatePivotColumns)
+				}
+				seenPivotColumns[name] = true
+				pivotColumns = append(pivotColumns, name)
+			}
+			target[name] = row[column]
+		}
+	}
+
+	for _, row := range pivoted {
+		for _, column := range pivotColumns {
+			if _, ok := row[column]; !ok {
+				row[column] = nil
+			}
+		}
+	}
+	return pivoted, nil
+}
+
+func compareAggregateRows(left, right map[string]interface{}, keys []aggregateSortKey) int {
+	for _, key := range keys {
+		result := compareAggregateValues(left[key.column], right[key.column])
+		if key.descending {
+			result = -result
+		}
+		if result != 0 {
+			return result
+		}
+	}
+	return 0
+}
+
+// compareAggregateValues orders nulls first, then numbers, times and everything else as text
+func compareAggregateValues(left, right interface{}) int {
+	if left == nil || right == nil {
+		switch {
+		case left == nil && right == nil:
+			return 0
+		case left == nil:
+			return -1
+		default:
+			return 1
+		}
+	}
+	if leftNumber, ok := aggregateNumber(left); ok {
+		if rightNumber, ok := aggregateNumber(right); ok {
+			switch {
+			case leftNumber < rightNumber:
+				return -1
+			case leftNumber > rightNumber:
+				return 1
+			}
+			return 0
+		}
+	}
+	if leftTime, ok := left.(time.Time); ok {
+		if rightTime, ok := right.(time.Time); ok {
+			return leftTime.Compare(rightTime)
+		}
+	}
+	return strings.Compare(fmt.Sprint(left), fmt.Sprint(right))
+}
+
+func aggregateNumber(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case int64:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case []byte:
+		number, err := strconv.ParseFloat(string(v), 64)
+		return number, err == nil
+	case string:
+		number, err := strconv.ParseFloat(v, 64)
+		return number, err == nil
+	}
+	return 0, false
+}
+
+// aggregateGroupKey identifies a combination of group values, text read as bytes or as a
+// string gives the same key
+func aggregateGroupKey(values []interface{}) string {
+	parts := make([]string, len(values))
+	for i, value := range values {
+		switch v := value.(type) {
+		case nil:
+			parts[i] = "\x00"
+		case []byte:
+			parts[i] = string(v)
+		default:
+			parts[i] = fmt.Sprint(v)
+		}
+	}
+	return strings.Join(parts, "\x1f")
+}
@@ -0,0 +1,116 @@
+package resource
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/artpar/api2go/v2"
+	"github.com/daptin/daptin/server/auth"
+	"github.com/daptin/daptin/server/table_info"
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestAggregateWindowPercentileAndPivot(t *testing.T) {
+	db, err := sqlx.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	if _, err := db.Exec(`create table orders (id integer primary key, month text, status text, total integer)`); err != nil {
+		t.Fatalf("create table: %v", err)
+	}
+	for _, order := range []struct {
+		month, status string
+		total         int
+	}{
+		{"2024-01", "open", 10}, {"2024-01", "open", 30}, {"2024-01", "closed", 5},
+		{"2024-02", "open", 20}, {"2024-02", "closed", 40}, {"2024-03", "closed", 60},
+	} {
+		if _, err := db.Exec(`insert into orders (month, status, total) values (?, ?, ?)`, order.month, order.status, order.total); err != nil {
+			t.Fatalf("insert order: %v", err)
+		}
+	}
+
+	columns := []api2go.ColumnInfo{
+		{Name: "month", ColumnName: "month"},
+		{Name: "status", ColumnName: "status"},
+		{Name: "total", ColumnName: "total"},
+	}
+	orders := &DbResource{
+		model:      api2go.NewApi2GoModel("orders", columns, int64(auth.DEFAULT_PERMISSION), nil),
+		tableInfo:  &table_info.TableInfo{TableName: "orders", Columns: columns},
+		connection: db,
+	}
+	orders.Cruds = map[string]*DbResource{"orders": orders}
+
+	stats := func(req AggregationRequest) ([]map[string]interface{}, error) {
+		req.RootEntity = "orders"
+		tx := db.MustBegin()
+		defer tx.Rollback()
+		data, err := orders.DataStats(req, tx)
+		if err != nil {
+			return nil, err
+		}
+		rows := make([]map[string]interface{}, 0)
+		for _, row := range data.Data {
+			rows = append(rows, row.Attributes)
+		}
+		return rows, nil
+	}
+
+	rows, err := stats(AggregationRequest{
+		GroupBy:       []string{"status"},
+		ProjectColumn: []string{"sum(total) as total", "median(total) as median_total", "percentile(total, 0.5) as p50", "rank(order=-total) as position"},
+		Order:         []string{"position"},
+	})
+	if err != nil {
+		t.Fatalf("percentile and rank: %v", err)
+	}
+	if len(rows) != 2 || rows[0]["status"] != "closed" || rows[0]["position"] != int64(1) ||
+		rows[0]["median_total"] != float64(40) || rows[0]["p50"] != float64(40) || rows[1]["median_total"] != float64(20) {
+		t.Fatalf("unexpected percentile and rank rows %v", rows)
+	}
+
+	rows, err = stats(AggregationRequest{
+		GroupBy:       []string{"month"},
+		ProjectColumn: []string{"sum(total) as total", "running_sum(total, order=month) as cumulative", "moving_avg(total, 2, order=month) as smoothed", "row_number(order=-month) as latest"},
+		Order:         []string{"month"},
+	})
+	if err != nil {
+		t.Fatalf("running and moving windows: %v", err)
+	}
+	if len(rows) != 3 || rows[2]["cumulative"] != float64(165) || rows[1]["smoothed"] != float64(52.5) || rows[2]["latest"] != int64(1) {
+		t.Fatalf("unexpected window rows %v", rows)
+	}
+
+	rows, err = stats(AggregationRequest{
+		GroupBy:       []string{"month", "status"},
+		ProjectColumn: []string{"sum(total) as total"},
+		Order:         []string{"month"},
+		Pivot:         "status",
+	})
+	if err != nil {
+		t.Fatalf("pivot: %v", err)
+	}
+	if len(rows) != 3 || rows[0]["month"] != "2024-01" || toInt64(rows[0]["open"]) != 40 || toInt64(rows[0]["closed"]) != 5 ||
+		rows[2]["open"] != nil || toInt64(rows[2]["closed"]) != 60 {
+		t.Fatalf("unexpected pivot rows %v", rows)
+	}
+
+	var validationError *AggregationValidationError
+	for _, invalid := range []AggregationRequest{
+		{GroupBy: []string{"status"}, ProjectColumn: []string{"rank(order=-missing)"}},
+		{GroupBy: []string{"status"}, ProjectColumn: []string{"rank()"}},
+		{GroupBy: []string{"status"}, ProjectColumn: []string{"moving_avg(total, 0, order=status)"}},
+		{GroupBy: []string{"status"}, ProjectColumn: []string{"percentile(total, 2)"}},
+		{GroupBy: []string{"status"}, ProjectColumn: []string{"median(secret)"}},
+		{GroupBy: []string{"status"}, Pivot: "month"},
+	} {
+		if _, err := stats(invalid); !errors.As(err, &validationError) {
+			t.Errorf("request %+v should be rejected as invalid, got %v", invalid, err)
+		}
+	}
+}
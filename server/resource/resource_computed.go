@@ -0,0 +1,430 @@
+package resource
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/artpar/api2go/v2"
+	"github.com/daptin/daptin/server/table_info"
+	"github.com/dop251/goja"
+	"github.com/jmoiron/sqlx"
+	log "github.com/sirupsen/logrus"
+)
+
+// VirtualColumnRowLimit is how many rows a listing reads to filter or sort them on virtual computed
+// columns, which are evaluated in memory
+const VirtualColumnRowLimit = 10000
+
+// computedPrograms caches the compiled expressions of computed columns, by expression
+var computedPrograms sync.Map
+
+// CheckComputedColumns validates the computed columns of every table and adds a column to the table
+// for each computed column which is kept in the database, a generated column for Sql and a plain
+// column for a stored Expression. A computed column with both or neither of Expression and Sql, or
+// named like a column of the table, is dropped with an error.
+func CheckComputedColumns(config *CmsConfig) {
+	for i := range config.Tables {
+		table := &config.Tables[i]
+		if len(table.ComputedColumns) == 0 {
+			continue
+		}
+
+		computedColumns := make([]table_info.ComputedColumn, 0, len(table.ComputedColumns))
+		for _, computed := range table.ComputedColumns {
+			computed.Name = strings.TrimSpace(computed.Name)
+			if computed.Name == "" {
+				log.Errorf("Computed column without a name in table [%v]", table.TableName)
+				continue
+			}
+			if (computed.Expression == "") == (computed.Sql == "") {
+				log.Errorf("Computed column [%v] of table [%v] needs one of expression and sql", computed.Name, table.TableName)
+				continue
+			}
+			if computed.Expression != "" {
+				if _, err := computedProgram(computed.Expression); err != nil {
+					log.Errorf("Computed column [%v] of table [%v] has an invalid expression: %v", computed.Name, table.TableName, err)
+					continue
+				}
+			}
+			if computed.ColumnType == "" {
+				computed.ColumnType = "label"
+			}
+			if computed.DataType == "" {
+				computed.DataType = "varchar(100)"
+				if ColumnManager != nil {
+					if columnType, ok := ColumnManager.ColumnMap[computed.ColumnType]; ok && len(columnType.DataTypes) > 0 {
+						computed.DataType = columnType.DataTypes[0]
+					}
+				}
+			}
+
+			_, exists := table.GetColumnByName(computed.Name)
+			if computed.IsVirtual() {
+				if exists {
+					log.Errorf("Computed column [%v] of table [%v] has the name of a column of the table", computed.Name, table.TableName)
+					continue
+				}
+			} else if !exists {
+				// a column from an earlier start, kept in the world schema, is not added again
+				table.Columns = append(table.Columns, computed.ColumnInfo())
+			}
+			computedColumns = append(computedColumns, computed)
+		}
+		table.ComputedColumns = computedColumns
+	}
+}
+
+// generatedColumnLine is the column definition of a computed column evaluated by the database. Postgres
+// only has stored generated columns and sqlite can only add virtual ones to an existing table.
+func generatedColumnLine(c *api2go.ColumnInfo, computed *table_info.ComputedColumn, sqlDriverName string, alter bool) string {
+	kind := "VIRTUAL"
+	if computed.Stored {
+		kind = "STORED"
+	}
+	switch sqlDriverName {
+	case "postgres":
+		kind = "STORED"
+	case "sqlite3":
+		if alter {
+			kind = "VIRTUAL"
+		}
+	}
+	return fmt.Sprintf("%s %s GENERATED ALWAYS AS (%s) %s", c.ColumnName, columnDataType(c, sqlDriverName), computed.Sql, kind)
+}
+
+// isReadOnlyColumn is true for a computed or rollup column, whose value cannot be written by a request
+func (dbResource *DbResource) isReadOnlyColumn(columnName string) bool {
+	if dbResource.tableInfo == nil {
+		return false
+	}
+	if _, ok := dbResource.tableInfo.GetComputedColumn(columnName); ok {
+		return true
+	}
+	_, ok := dbResource.tableInfo.GetRollupColumn(columnName)
+	return ok
+}
+
+// virtualColumnError is returned for a filter or sort in sql on a column which is only computed when
+// read, listings filter and sort on them in memory instead
+func (dbResource *DbResource) virtualColumnError(columnName string) error {
+	if !dbResource.isVirtualColumn(columnName) {
+		return nil
+	}
+	return api2go.NewHTTPError(fmt.Errorf("table [%v] column [%v] is computed when read", dbResource.model.GetName(), columnName),
+		fmt.Sprintf("computed column [%v] cannot be used in a database query, make it stored", columnName), http.StatusBadRequest)
+}
+
+// isVirtualColumn is true for a computed column which is evaluated on the rows as they are read
+func (dbResource *DbResource) isVirtualColumn(columnName string) bool {
+	if dbResource.tableInfo == nil {
+		return false
+	}
+	computed, ok := dbResource.tableInfo.GetComputedColumn(columnName)
+	return ok && computed.IsVirtual()
+}
+
+// requestSortOrder is the sort parameter of a listing, or the default order of the table without one
+func (dbResource *DbResource) requestSortOrder(req api2go.Request) []string {
+	if len(req.QueryParams["sort"]) > 0 {
+		return req.QueryParams["sort"]
+	}
+	_, hasCreatedAt := dbResource.tableInfo.GetColumnByName("created_at")
+	return resolveDefaultSortOrder(dbResource.tableInfo.DefaultOrder, hasCreatedAt)
+}
+
+// validateInMemorySortOrder checks the sort order of rows sorted in memory, which can also sort on
+// virtual computed columns
+func (dbResource *DbResource) validateInMemorySortOrder(sortOrder []string) ([]string, error) {
+	validSortOrder := make([]string, 0, len(sortOrder))
+	for _, order := range sortOrder {
+		if dbResource.isVirtualColumn(strings.TrimLeft(order, "+-")) {
+			validSortOrder = append(validSortOrder, order)
+			continue
+		}
+		validOrder, err := dbResource.validateSortOrder([]string{order})
+		if err != nil {
+			return nil, err
+		}
+		validSortOrder = append(validSortOrder, validOrder...)
+	}
+	return validSortOrder, nil
+}
+
+// usesVirtualColumns tells if the listing filters or sorts on a virtual computed column
+func (dbResource *DbResource) usesVirtualColumns(req api2go.Request) bool {
+	if dbResource.tableInfo == nil || len(dbResource.tableInfo.ComputedColumns) == 0 {
+		return false
+	}
+	for _, order := range dbResource.requestSortOrder(req) {
+		if dbResource.isVirtualColumn(strings.TrimLeft(strings.TrimSpace(order), "+-")) {
+			return true
+		}
+	}
+	// a query which cannot be read is left to the listing to report
+	queries, err := dbResource.requestQueries(req)
+	if err != nil {
+		return false
+	}
+	for _, q := range queries {
+		if dbResource.isVirtualColumn(q.ColumnName) {
+			return true
+		}
+	}
+	return false
+}
+
+// paginatedFindAllWithVirtualColumns lists rows filtered or sorted on virtual computed columns. The
+// rows matching the queries on the columns of the table are read from the database, up to
+// VirtualColumnRowLimit of them, and the virtual columns are evaluated on them before the rest of
+// the queries, the sort, the page and the facets are applied in memory.
+func (dbResource *DbResource) paginatedFindAllWithVirtualColumns(req api2go.Request, transaction *sqlx.Tx) (
+	[]map[string]interface{}, [][]map[string]interface{}, *PaginationData, bool, error) {
+	tableName := dbResource.model.GetName()
+
+	queries, err := dbResource.requestQueries(req)
+	if err != nil {
+		return nil, nil, nil, false, err
+	}
+	// queries on the columns of the table outside of a logical group must all match and are left to
+	// the database, the others are matched on the evaluated rows
+	databaseQueries := make([]Query, 0, len(queries))
+	memoryQueries := make([]Query, 0, len(queries))
+	for _, q := range queries {
+		if q.LogicalGroup == "" && !dbResource.isVirtualColumn(q.ColumnName) {
+			databaseQueries = append(databaseQueries, q)
+		} else {
+			memoryQueries = append(memoryQueries, q)
+		}
+	}
+	if err = fuzzyQueryError(memoryQueries, "on virtual computed columns or in a logical group with them"); err != nil {
+		return nil, nil, nil, false, err
+	}
+	sortOrder, err := dbResource.validateInMemorySortOrder(dbResource.requestSortOrder(req))
+	if err != nil {
+		return nil, nil, nil, false, api2go.NewHTTPError(err, err.Error(), http.StatusBadRequest)
+	}
+	facetColumns, err := dbResource.facetColumns(req.QueryParams["facets"])
+	if err != nil {
+		return nil, nil, nil, false, err
+	}
+
+	queryParams := make(map[string][]string, len(req.QueryParams))
+	for key, values := range req.QueryParams {
+		switch key {
+		case "query", "sort", "facets", "page[number]", "page[size]":
+			continue
+		case "filter", "filter[]":
+			// like the listing, free text filters are ignored when there are queries
+			if len(queries) > 0 {
+				continue
+			}
+		}
+		queryParams[key] = values
+	}
+	if len(databaseQueries) > 0 {
+		databaseQueryJson, err := json.MarshalToString(databaseQueries)
+		if err != nil {
+			return nil, nil, nil, false, err
+		}
+		queryParams["query"] = []string{databaseQueryJson}
+	}
+	// the stored columns of the sort order are kept so ties in memory keep the database order
+	databaseSortOrder := make([]string, 0, len(sortOrder))
+	for _, order := range sortOrder {
+		if !dbResource.isVirtualColumn(strings.TrimLeft(order, "+-")) {
+			databaseSortOrder = append(databaseSortOrder, order)
+		}
+	}
+	queryParams["page[number]"] = []string{"1"}
+	queryParams["page[size]"] = []string{fmt.Sprintf("%d", VirtualColumnRowLimit)}
+	databaseRequest := req
+	databaseRequest.QueryParams = queryParams
+
+	rows, rowIncludes, databasePagination, single, err := dbResource.paginatedFindAllInDatabase(databaseRequest, databaseSortOrder, transaction)
+	if err != nil || single {
+		return rows, rowIncludes, databasePagination, single, err
+	}
+	if databasePagination != nil && databasePagination.TotalCount > VirtualColumnRowLimit {
+		return nil, nil, nil, false, api2go.NewHTTPError(
+			fmt.Errorf("[%v] has %d rows to evaluate virtual computed columns on", tableName, databasePagination.TotalCount),
+			fmt.Sprintf("more than %d rows to filter or sort on virtual computed columns, narrow the query on the stored columns", VirtualColumnRowLimit),
+			http.StatusBadRequest)
+	}
+
+	type listedRow struct {
+		row      map[string]interface{}
+		includes []map[string]interface{}
+	}
+	listed := make([]listedRow, 0, len(rows))
+	for i, row := range rows {
+		matches, err := dbResource.matchesAsOfQueries(row, memoryQueries)
+		if err != nil {
+			return nil, nil, nil, false, err
+		}
+		if !matches {
+			continue
+		}
+		included := make([]map[string]interface{}, 0)
+		if i < len(rowIncludes) {
+			included = rowIncludes[i]
+		}
+		listed = append(listed, listedRow{row: row, includes: included})
+	}
+	sort.SliceStable(listed, func(i, j int) bool {
+		return lessAsOf(listed[i].row, listed[j].row, sortOrder)
+	})
+
+	pagination := asOfPagination(req, uint64(len(listed)))
+	start := (pagination.PageNumber - 1) * pagination.PageSize
+	if start > uint64(len(listed)) {
+		start = uint64(len(listed))
+	}
+	end := start + pagination.PageSize
+	if end > uint64(len(listed)) {
+		end = uint64(len(listed))
+	}
+	visible := make([]map[string]interface{}, 0, len(listed))
+	for _, item := range listed {
+		visible = append(visible, item.row)
+	}
+	if len(facetColumns) > 0 {
+		pagination.Facets = countFacetsInMemory(visible, facetColumns)
+	}
+
+	results := make([]map[string]interface{}, 0, end-start)
+	includes := make([][]map[string]interface{}, 0, end-start)
+	for _, item := range listed[start:end] {
+		results = append(results, item.row)
+		includes = append(includes, item.includes)
+	}
+	return results, includes, pagination, false, nil
+}
+
+// storedComputedValues evaluates the stored expression columns of the table for row, in the order they
+// are declared, so an expression can use the columns declared before it
+func (dbResource *DbResource) storedComputedValues(row map[string]interface{}) map[string]interface{} {
+	values := make(map[string]interface{})
+	if dbResource.tableInfo == nil || len(dbResource.tableInfo.ComputedColumns) == 0 {
+		return values
+	}
+
+	env := dbResource.computedEnv(row)
+	for _, computed := range dbResource.tableInfo.ComputedColumns {
+		if computed.Expression == "" || !computed.Stored {
+			continue
+		}
+		value := dbResource.evaluateComputedColumn(computed, env)
+		switch value.(type) {
+		case map[string]interface{}, []interface{}:
+			asString, err := json.MarshalToString(value)
+			CheckErr(err, "Failed to marshal computed column [%v]", computed.Name)
+			value = asString
+		}
+		env[computed.Name] = value
+		values[computed.Name] = value
+	}
+	return values
+}
+
+// addVirtualColumns sets the values of the virtual expression columns of the table on each row
+func (dbResource *DbResource) addVirtualColumns(rows ...map[string]interface{}) {
+	if dbResource.tableInfo == nil || len(dbResource.tableInfo.ComputedColumns) == 0 {
+		return
+	}
+	hasVirtual := false
+	for _, computed := range dbResource.tableInfo.ComputedColumns {
+		if computed.IsVirtual() {
+			hasVirtual = true
+			break
+		}
+	}
+	if !hasVirtual {
+		return
+	}
+
+	for _, row := range rows {
+		if row == nil {
+			continue
+		}
+		env := dbResource.computedEnv(row)
+		for _, computed := range dbResource.tableInfo.ComputedColumns {
+			if !computed.IsVirtual() {
+				continue
+			}
+			value := dbResource.evaluateComputedColumn(computed, env)
+			env[computed.Name] = value
+			row[computed.Name] = value
+		}
+	}
+}
+
+// computedEnv is the variables of an expression, each column of the table with its value in row or null
+func (dbResource *DbResource) computedEnv(row map[string]interface{}) map[string]interface{} {
+	env := make(map[string]interface{}, len(dbResource.tableInfo.Columns))
+	for _, col := range dbResource.tableInfo.Columns {
+		env[col.ColumnName] = nil
+	}
+	for key, value := range row {
+		if key == "id" || key == "password" || key == "__type" {
+			continue
+		}
+		env[key] = value
+	}
+	return env
+}
+
+// evaluateComputedColumn runs the expression of computed with env, a failed expression gives null
+func (dbResource *DbResource) evaluateComputedColumn(computed table_info.ComputedColumn, env map[string]interface{}) interface{} {
+	value, err := EvaluateComputedExpression(computed.Expression, env)
+	if err != nil {
+		log.Warnf("Failed to compute column [%v] of table [%v]: %v", computed.Name, dbResource.tableInfo.TableName, err)
+		return nil
+	}
+	return value
+}
+
+// EvaluateComputedExpression runs the javascript expression with the keys of env as variables and
+// returns its value, null, undefined and values which are not finite numbers give nil
+func EvaluateComputedExpression(expression string, env map[string]interface{}) (interface{}, error) {
+	program, err := computedProgram(expression)
+	if err != nil {
+		return nil, err
+	}
+
+	vm := goja.New()
+	for key, val := range env {
+		if err := vm.Set(key, val); err != nil {
+			return nil, err
+		}
+	}
+
+	value, err := vm.RunProgram(program)
+	if err != nil {
+		return nil, err
+	}
+	if value == nil || goja.IsUndefined(value) || goja.IsNull(value) {
+		return nil, nil
+	}
+
+	exported := value.Export()
+	if number, ok := exported.(float64); ok && (math.IsNaN(number) || math.IsInf(number, 0)) {
+		return nil, nil
+	}
+	return exported, nil
+}
+
+func computedProgram(expression string) (*goja.Program, error) {
+	if program, ok := computedPrograms.Load(expression); ok {
+		return program.(*goja.Program), nil
+	}
+	program, err := goja.Compile("", expression, false)
+	if err != nil {
+		return nil, err
+	}
+	computedPrograms.Store(expression, program)
+	return program, nil
+}
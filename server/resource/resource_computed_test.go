@@ -0,0 +1,290 @@
+package resource
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/artpar/api2go/v2"
+	"github.com/daptin/daptin/server/auth"
+	daptinid "github.com/daptin/daptin/server/id"
+	"github.com/daptin/daptin/server/table_info"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func orderLineTable() table_info.TableInfo {
+	return table_info.TableInfo{
+		TableName: "order_line",
+		Columns: []api2go.ColumnInfo{
+			{Name: "quantity", ColumnName: "quantity", ColumnType: "measurement", DataType: "int(11)", IsNullable: true},
+			{Name: "unit_price", ColumnName: "unit_price", ColumnType: "measurement", DataType: "float(7,2)", IsNullable: true},
+			{Name: "first_name", ColumnName: "first_name", ColumnType: "label", DataType: "varchar(50)", IsNullable: true},
+			{Name: "last_name", ColumnName: "last_name", ColumnType: "label", DataType: "varchar(50)", IsNullable: true},
+		},
+		ComputedColumns: []table_info.ComputedColumn{
+			{Name: "total", ColumnType: "measurement", DataType: "float(11,2)", Expression: "quantity * unit_price", Stored: true},
+			{Name: "full_name", Expression: "[first_name, last_name].filter(Boolean).join(' ')"},
+			{Name: "total_with_tax", ColumnType: "measurement", DataType: "float(11,2)", Sql: "quantity * unit_price * 1.2"},
+			{Name: "broken", Expression: "1", Sql: "1"},
+			{Name: "quantity", Expression: "2"},
+			{Name: "invalid", Expression: "quantity *"},
+		},
+	}
+}
+
+func TestCheckComputedColumns(t *testing.T) {
+	config := &CmsConfig{Tables: []table_info.TableInfo{orderLineTable()}}
+	CheckComputedColumns(config)
+	table := config.Tables[0]
+
+	if len(table.ComputedColumns) != 3 {
+		t.Fatalf("expected total, full_name and total_with_tax to be kept, got %+v", table.ComputedColumns)
+	}
+	if _, ok := table.GetColumnByName("total"); !ok {
+		t.Errorf("expected a column for the stored expression")
+	}
+	if _, ok := table.GetColumnByName("total_with_tax"); !ok {
+		t.Errorf("expected a column for the generated column")
+	}
+	if _, ok := table.GetColumnByName("full_name"); ok {
+		t.Errorf("a virtual column should have no column in the table")
+	}
+	fullName, _ := table.GetComputedColumn("full_name")
+	if fullName.ColumnType != "label" || fullName.DataType == "" {
+		t.Errorf("expected the column type and data type to be defaulted, got %+v", fullName)
+	}
+
+	// a second check, with the columns read back from the world schema, adds nothing
+	CheckComputedColumns(config)
+	if len(config.Tables[0].Columns) != len(table.Columns) {
+		t.Errorf("columns added again: %v", config.Tables[0].Columns)
+	}
+}
+
+func TestSqliteGeneratedColumn(t *testing.T) {
+	config := &CmsConfig{Tables: []table_info.TableInfo{orderLineTable()}}
+	CheckComputedColumns(config)
+	table := config.Tables[0]
+
+	db, err := sqlx.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	defer db.Close()
+
+	if _, err = db.Exec(MakeCreateTableQuery(&table, "sqlite3")); err != nil {
+		t.Fatalf("create table: %v", err)
+	}
+	computed, _ := table.GetComputedColumn("total_with_tax")
+	column, _ := table.GetColumnByName("total_with_tax")
+	column.ColumnName = "total_with_discount"
+	computed.Sql = "quantity * unit_price * 0.9"
+	if _, err = db.Exec("alter table order_line add column " + generatedColumnLine(column, computed, "sqlite3", true)); err != nil {
+		t.Fatalf("add generated column: %v", err)
+	}
+
+	if _, err = db.Exec("insert into order_line (quantity, unit_price) values (3, 10)"); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+	var withTax, withDiscount float64
+	if err = db.QueryRow("select total_with_tax, total_with_discount from order_line").Scan(&withTax, &withDiscount); err != nil {
+		t.Fatalf("select: %v", err)
+	}
+	if withTax != 36 || withDiscount != 27 {
+		t.Errorf("expected 36 and 27, got %v and %v", withTax, withDiscount)
+	}
+}
+
+func TestComputedColumnValues(t *testing.T) {
+	config := &CmsConfig{Tables: []table_info.TableInfo{orderLineTable()}}
+	CheckComputedColumns(config)
+	table := config.Tables[0]
+	dbResource := &DbResource{
+		tableInfo: &table,
+		model:     api2go.NewApi2GoModel("order_line", table.Columns, int64(auth.DEFAULT_PERMISSION), nil),
+	}
+
+	stored := dbResource.storedComputedValues(map[string]interface{}{"quantity": 4, "unit_price": 2.5})
+	if len(stored) != 1 || fmt.Sprint(stored["total"]) != "10" {
+		t.Errorf("expected a stored total of 10, got %v", stored)
+	}
+
+	row := map[string]interface{}{"first_name": "Ada", "last_name": nil}
+	dbResource.addVirtualColumns(row)
+	if row["full_name"] != "Ada" {
+		t.Errorf("expected full_name Ada, got %v", row["full_name"])
+	}
+
+	if !dbResource.isReadOnlyColumn("total_with_tax") || dbResource.isReadOnlyColumn("quantity") {
+		t.Errorf("unexpected read only columns")
+	}
+
+	err := dbResource.virtualColumnError("full_name")
+	httpErr, ok := err.(api2go.HTTPError)
+	if !ok || httpErr.Status() != http.StatusBadRequest {
+		t.Errorf("expected a bad request for a virtual column, got %v", err)
+	}
+	if dbResource.virtualColumnError("total") != nil || dbResource.virtualColumnError("quantity") != nil {
+		t.Errorf("stored and plain columns can be filtered on")
+	}
+	if _, err = dbResource.validateSortOrder([]string{"-full_name"}); err == nil {
+		t.Errorf("expected sorting on a virtual column in the database to fail")
+	}
+	if order, err := dbResource.validateInMemorySortOrder([]string{"-full_name", "total"}); err != nil || len(order) != 2 || order[0] != "-full_name" {
+		t.Errorf("expected sorting on a virtual column in memory, got %v %v", order, err)
+	}
+	if order, err := dbResource.validateSortOrder([]string{"-total"}); err != nil || order[0] != "-total" {
+		t.Errorf("expected sorting on a stored column, got %v %v", order, err)
+	}
+}
+
+func TestListingFiltersAndSortsOnVirtualColumns(t *testing.T) {
+	db, err := sqlx.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	for _, statement := range []string{
+		`create table order_line (id integer primary key, quantity integer, unit_price real, first_name text, last_name text,
+			user_account_id integer, permission integer, reference_id blob not null unique, created_at timestamp)`,
+		`create table order_line_order_line_id_has_usergroup_usergroup_id (id integer primary key, order_line_id integer,
+			usergroup_id integer, permission integer, reference_id blob)`,
+	} {
+		if _, err = db.Exec(statement); err != nil {
+			t.Fatalf("create table: %v", err)
+		}
+	}
+	for i, line := range []struct {
+		quantity  int
+		firstName string
+		lastName  string
+	}{
+		{1, "Grace", "Hopper"},
+		{2, "Ada", "Lovelace"},
+		{3, "Alan", "Turing"},
+		{4, "Ada", "Byron"},
+	} {
+		referenceId := uuid.New()
+		if _, err = db.Exec(`insert into order_line (id, quantity, unit_price, first_name, last_name, permission, reference_id, created_at)
+			values (?, ?, 10, ?, ?, ?, ?, ?)`, i+1, line.quantity, line.firstName, line.lastName,
+			int64(auth.ALLOW_ALL_PERMISSIONS), referenceId[:], time.Now()); err != nil {
+			t.Fatalf("insert: %v", err)
+		}
+	}
+
+	columns := []api2go.ColumnInfo{
+		{Name: "quantity", ColumnName: "quantity", ColumnType: "measurement"},
+		{Name: "unit_price", ColumnName: "unit_price", ColumnType: "measurement"},
+		{Name: "first_name", ColumnName: "first_name", ColumnType: "label"},
+		{Name: "last_name", ColumnName: "last_name", ColumnType: "label"},
+		{Name: USER_ACCOUNT_ID_COLUMN, ColumnName: USER_ACCOUNT_ID_COLUMN},
+		{Name: "permission", ColumnName: "permission"},
+		{Name: "reference_id", ColumnName: "reference_id"},
+		{Name: "created_at", ColumnName: "created_at"},
+	}
+	dbResource := &DbResource{
+		model:      api2go.NewApi2GoModel("order_line", columns, int64(auth.DEFAULT_PERMISSION), nil),
+		connection: db,
+		tableInfo: &table_info.TableInfo{
+			TableName:         "order_line",
+			Columns:           columns,
+			DefaultPermission: auth.DEFAULT_PERMISSION,
+			ComputedColumns: []table_info.ComputedColumn{
+				{Name: "full_name", Expression: "first_name + ' ' + last_name"},
+			},
+		},
+		ms: &MiddlewareSet{},
+	}
+
+	adminGroupRef := daptinid.DaptinReferenceId(uuid.New())
+	oldUserAccountCrud := CRUD_MAP[USER_ACCOUNT_TABLE_NAME]
+	CRUD_MAP[USER_ACCOUNT_TABLE_NAME] = &DbResource{AdministratorGroupId: adminGroupRef}
+	defer func() {
+		if oldUserAccountCrud == nil {
+			delete(CRUD_MAP, USER_ACCOUNT_TABLE_NAME)
+			return
+		}
+		CRUD_MAP[USER_ACCOUNT_TABLE_NAME] = oldUserAccountCrud
+	}()
+	ctx := context.WithValue(context.Background(), "user", &auth.SessionUser{
+		UserReferenceId: daptinid.DaptinReferenceId(uuid.New()),
+		Groups:          auth.GroupPermissionList{{GroupReferenceId: adminGroupRef}},
+	})
+	plainRequest := (&http.Request{Method: http.MethodGet, URL: &url.URL{Path: "/api/order_line"}}).WithContext(ctx)
+
+	tx := db.MustBegin()
+	defer tx.Rollback()
+
+	for _, c := range []struct {
+		params map[string][]string
+		names  []string
+		total  uint64
+	}{
+		{map[string][]string{"sort": {"full_name"}}, []string{"Ada Byron", "Ada Lovelace", "Alan Turing", "Grace Hopper"}, 4},
+		{map[string][]string{"sort": {"-full_name"}, "page[size]": {"2"}, "page[number]": {"2"}}, []string{"Ada Lovelace", "Ada Byron"}, 4},
+		{map[string][]string{"query": {`[{"column":"full_name","operator":"like","value":"ada%"}]`}, "sort": {"-quantity"}}, []string{"Ada Byron", "Ada Lovelace"}, 2},
+		{map[string][]string{"query": {`[{"column":"full_name","operator":"like","value":"a%"}`, `{"column":"quantity","operator":"more than","value":2}]`}, "sort": {"full_name"}}, []string{"Ada Byron", "Alan Turing"}, 2},
+		{map[string][]string{"query": {`[{"column":"full_name","operator":"eq","value":"Grace Hopper","logical_group":"a"}`, `{"column":"quantity","operator":"eq","value":3,"logical_group":"a"}]`}, "sort": {"full_name"}}, []string{"Alan Turing", "Grace Hopper"}, 2},
+	} {
+		results, includes, pagination, _, err := dbResource.PaginatedFindAllWithoutFilters(api2go.Request{
+			PlainRequest: plainRequest,
+			QueryParams:  c.params,
+		}, tx)
+		if err != nil {
+			t.Errorf("%v: %v", c.params, err)
+			continue
+		}
+		names := make([]string, 0, len(results))
+		for _, row := range results {
+			names = append(names, fmt.Sprint(row["full_name"]))
+		}
+		if fmt.Sprint(names) != fmt.Sprint(c.names) || pagination.TotalCount != c.total || len(includes) != len(results) {
+			t.Errorf("%v: expected %v of %d, got %v of %d", c.params, c.names, c.total, names, pagination.TotalCount)
+		}
+	}
+
+	_, _, pagination, _, err := dbResource.PaginatedFindAllWithoutFilters(api2go.Request{
+		PlainRequest: plainRequest,
+		QueryParams: map[string][]string{
+			"query":  {`[{"column":"full_name","operator":"like","value":"a%"}]`},
+			"facets": {"first_name"},
+		},
+	}, tx)
+	if err != nil || fmt.Sprint(pagination.Facets["first_name"]) != "[{Ada 2} {Alan 1}]" {
+		t.Errorf("expected the facets of the filtered rows, got %v %v", pagination, err)
+	}
+
+	if _, _, _, _, err = dbResource.PaginatedFindAllWithoutFilters(api2go.Request{
+		PlainRequest: plainRequest,
+		QueryParams:  map[string][]string{"query": {`[{"column":"full_name","operator":"fuzzy","value":"ada"}]`}},
+	}, tx); err == nil {
+		t.Errorf("expected a fuzzy query on a virtual column to fail")
+	}
+}
+
+func TestEvaluateComputedExpression(t *testing.T) {
+	value, err := EvaluateComputedExpression("unit_price > 0 ? quantity / unit_price : null", map[string]interface{}{
+		"quantity":   5,
+		"unit_price": 0,
+	})
+	if err != nil || value != nil {
+		t.Errorf("expected null, got %v %v", value, err)
+	}
+	value, err = EvaluateComputedExpression("quantity / unit_price", map[string]interface{}{
+		"quantity":   5,
+		"unit_price": 0,
+	})
+	if err != nil || value != nil {
+		t.Errorf("expected infinity to give nil, got %v %v", value, err)
+	}
+	if _, err = EvaluateComputedExpression("missing + 1", map[string]interface{}{}); err == nil {
+		t.Errorf("expected an error for an unknown variable")
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"crypto/md5"
 	"encoding/base64"
 	"github.com/daptin/daptin/server/actionresponse"
+	"github.com/daptin/daptin/server/database"
 	daptinid "github.com/daptin/daptin/server/id"
 	"net/http"
 	"net/url"
@@ -74,6 +75,14 @@ func (dbResource *DbResource) CreateWithoutFilter(obj interface{}, req api2go.Re
 			continue
 		}
 
+		if col.ColumnName == SoftDeleteColumnName {
+			continue
+		}
+
+		if col.ColumnName == TenantColumnName {
+			continue
+		}
+
 		if col.ColumnName == "permission" {
 			continue
 		}
@@ -82,6 +91,10 @@ func (dbResource *DbResource) CreateWithoutFilter(obj interface{}, req api2go.Re
 			continue
 		}
 
+		if dbResource.isReadOnlyColumn(col.ColumnName) {
+			continue
+		}
+
 		//log.Printf("Check column: %v", col.ColumnName)
 
 		columnValue, columnValueOk := attrs[col.ColumnName]
@@ -125,7 +138,7 @@ func (dbResource *DbResource) CreateWithoutFilter(obj interface{}, req api2go.Re
 					}
 				} else {
 					var uId interface{}
-					foreignObjectReferenceId, err := GetReferenceIdToIdWithTransaction(col.ForeignKeyData.Namespace, dir, createTransaction)
+					foreignObjectReferenceId, err := dbResource.tenantReferenceIdToId(col.ForeignKeyData.Namespace, dir, req, createTransaction)
 					if err != nil {
 						return nil, fmt.Errorf("[129] foreign object not found [%v][%v]", col.ForeignKeyData.Namespace, dir)
 					}
@@ -417,6 +430,20 @@ func (dbResource *DbResource) CreateWithoutFilter(obj interface{}, req api2go.Re
 		colsList = append(colsList, "reference_id")
 		valsList = append(valsList, newObjectReferenceId[:])
 	}
+
+	computedRow := make(map[string]interface{})
+	for _, col := range allColumns {
+		if value, ok := attrs[col.ColumnName]; ok {
+			computedRow[col.ColumnName] = value
+		} else if value, ok := dataToInsert[col.ColumnName]; ok {
+			computedRow[col.ColumnName] = value
+		}
+	}
+	for columnName, value := range dbResource.storedComputedValues(computedRow) {
+		dataToInsert[columnName] = value
+		colsList = append(colsList, columnName)
+		valsList = append(valsList, value)
+	}
 	languagePreferences := make([]string, 0)
 	if dbResource.tableInfo.TranslationsEnabled {
 		prefs := req.PlainRequest.Context().Value("language_preference")
@@ -434,6 +461,11 @@ func (dbResource *DbResource) CreateWithoutFilter(obj interface{}, req api2go.Re
 	colsList = append(colsList, "updated_at")
 	valsList = append(valsList, time.Now())
 
+	if dbResource.tableInfo.TenantScoped {
+		colsList = append(colsList, TenantColumnName)
+		valsList = append(valsList, tenantRowId(requestTenant(req)))
+	}
+
 	if sessionUser.UserId != 0 && dbResource.model.HasColumn(USER_ACCOUNT_ID_COLUMN) && dbResource.model.GetName() != "user_account_user_account_id_has_usergroup_usergroup_id" {
 
 		colsList = append(colsList, USER_ACCOUNT_ID_COLUMN)
@@ -453,6 +485,7 @@ func (dbResource *DbResource) CreateWithoutFilter(obj interface{}, req api2go.Re
 		log.Errorf("[431] Failed to execute insert query: %v, vals [%v]", query, vals)
 		return nil, err
 	}
+	MarkTableChanged(requestContext(req), dbResource.model.GetName())
 	createdResource, err := dbResource.GetReferenceIdToObjectWithTransaction(dbResource.model.GetName(), newObjectReferenceId, createTransaction)
 
 	if err != nil {
@@ -559,6 +592,10 @@ func (dbResource *DbResource) CreateWithoutFilter(obj interface{}, req api2go.Re
 	}
 
 	groupsToAdd := dbResource.defaultGroups
+	if t := requestTenant(req); t != nil && t.AdminGroupId != 0 && dbResource.tableInfo.TenantScoped {
+		groupsToAdd = append(append(make([]ResolvedDefaultGroup, 0, len(groupsToAdd)+1), groupsToAdd...),
+			ResolvedDefaultGroup{GroupId: t.AdminGroupId, Permission: &tenantAdminPermission})
+	}
 	for _, group := range groupsToAdd {
 		nuuid, _ := uuid.NewV7()
 
@@ -710,8 +747,8 @@ func (dbResource *DbResource) CreateWithoutFilter(obj interface{}, req api2go.Re
 					}
 
 					subjectId := data.GetColumnOriginalValue("id")
-					objectId, err := GetReferenceIdToIdWithTransaction(rel.GetObject(),
-						daptinid.InterfaceToDIR(item[rel.GetObjectName()]), createTransaction)
+					objectId, err := dbResource.tenantReferenceIdToId(rel.GetObject(),
+						daptinid.InterfaceToDIR(item[rel.GetObjectName()]), req, createTransaction)
 					if err != nil {
 						return nil, fmt.Errorf("object not found [%v][%v]", rel.GetObject(), item[rel.GetObjectName()])
 					}
@@ -914,8 +951,8 @@ func (dbResource *DbResource) CreateWithoutFilter(obj interface{}, req api2go.Re
 						delete(item, "attributes")
 					}
 
-					subjectId, err := GetReferenceIdToIdWithTransaction(rel.GetSubject(),
-						item[rel.GetSubjectName()].(daptinid.DaptinReferenceId), createTransaction)
+					subjectId, err := dbResource.tenantReferenceIdToId(rel.GetSubject(),
+						item[rel.GetSubjectName()].(daptinid.DaptinReferenceId), req, createTransaction)
 					if err != nil {
 						return nil, fmt.Errorf("subject not found [%v][%v]", rel.GetSubject(), item[rel.GetSubjectName()])
 					}
@@ -991,6 +1028,7 @@ func (dbResource *DbResource) CreateWithoutFilter(obj interface{}, req api2go.Re
 
 	delete(createdResource, "id")
 	createdResource["__type"] = dbResource.model.GetName()
+	dbResource.addVirtualColumns(createdResource)
 	log.Tracef("[END] Create object of type [%v]", dbResource.model.GetName())
 
 	return createdResource, nil
@@ -1024,6 +1062,7 @@ func (dbResource *DbResource) CreateWithTransaction(obj interface{}, req api2go.
 		results, err := bf.InterceptAfter(dbResource, &req, []map[string]interface{}{createdResource}, transaction)
 		if err != nil {
 			log.Errorf("Error from AfterCreate[%v] middleware: %v", bf.String(), err)
+			return nil, err
 		}
 		if len(results) < 1 {
 			createdResource = nil
@@ -1044,9 +1083,18 @@ func (dbResource *DbResource) CreateWithTransaction(obj interface{}, req api2go.
 }
 
 func (dbResource *DbResource) Create(obj interface{}, req api2go.Request) (api2go.Responder, error) {
+	finish := dbResource.startOperation(&req, "create")
+	responder, err := dbResource.create(obj, req)
+	finish(err)
+	return responder, err
+}
+
+func (dbResource *DbResource) create(obj interface{}, req api2go.Request) (api2go.Responder, error) {
 	data := obj.(api2go.Api2GoModel)
 	//log.Printf("Create object request: [%v] %v", dbResource.model.GetTableName(), data.Data)
 
+	// reads after this in the same request see the new row
+	database.MarkWrite(requestContext(req))
 	transaction, err := dbResource.Connection().Beginx()
 	if err != nil {
 		CheckErr(err, "Failed to begin transaction [980]")
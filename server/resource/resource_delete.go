@@ -4,18 +4,19 @@ import (
 	"github.com/artpar/api2go/v2"
 	"github.com/daptin/daptin/server/actionresponse"
 	"github.com/daptin/daptin/server/auth"
+	"github.com/daptin/daptin/server/database"
 	daptinid "github.com/daptin/daptin/server/id"
 	"github.com/doug-martin/goqu/v9"
 	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
 	log "github.com/sirupsen/logrus"
-	"net/url"
 	"os"
 	"strings"
 
 	"fmt"
 	"github.com/daptin/daptin/server/statementbuilder"
 	"net/http"
+	"time"
 )
 
 // Delete an object
@@ -25,45 +26,44 @@ import (
 // - 204 No Content: Deletion was successful, return nothing
 
 func (dbResource *DbResource) DeleteWithoutFilters(id daptinid.DaptinReferenceId, req api2go.Request, transaction *sqlx.Tx) error {
+	return dbResource.deleteWithoutFilters(id, req, transaction, false, time.Now())
+}
+
+// deleteWithoutFilters only sets deleted_at on tables which keep deleted rows unless purge is set,
+// rows of OnDelete cascade relations are deleted before the row itself
+func (dbResource *DbResource) deleteWithoutFilters(id daptinid.DaptinReferenceId, req api2go.Request, transaction *sqlx.Tx, purge bool, deletedAt time.Time) error {
 
 	data, err := dbResource.GetReferenceIdToObjectWithTransaction(dbResource.model.GetTableName(), id, transaction)
 	if err != nil {
 		return err
 	}
+	softDelete := dbResource.tableInfo.SoftDelete && !purge
+	if softDelete && data[SoftDeleteColumnName] != nil {
+		return api2go.NewHTTPError(fmt.Errorf("[%v][%v] is already deleted", dbResource.model.GetName(), id), "object not found", http.StatusNotFound)
+	}
+	err = dbResource.checkTenant(data[TenantColumnName], id, req)
+	if err != nil {
+		return err
+	}
+	MarkTableChanged(requestContext(req), dbResource.model.GetName())
+	if version, versionErr := rowVersion(data["version"]); versionErr == nil {
+		err = CheckVersionPreconditions(req, id, version)
+		if err != nil {
+			return err
+		}
+	}
 	apiModel := api2go.NewApi2GoModelWithData(dbResource.model.GetTableName(), nil, 0, nil, data)
 
 	m := dbResource.model
 	//log.Printf("Get all resource type: %v\n", m)
 
 	if !EndsWithCheck(apiModel.GetTableName(), "_audit") && dbResource.tableInfo.IsAuditEnabled {
-		auditModel := apiModel.GetAuditModel()
-		auditModel.Set("source_reference_id", id.String())
-		auditModel.Set("operation", AuditOperationDelete)
-		log.Printf("Object [%v][%v] has been changed, trying to audit in %v", apiModel.GetTableName(), apiModel.GetID(), auditModel.GetTableName())
-		if auditModel.GetTableName() != "" {
-			//auditModel.Data["deleted_at"] = time.Now()
-			creator, ok := dbResource.Cruds[auditModel.GetTableName()]
-			if !ok {
-				log.Errorf("No creator for audit type: %v", auditModel.GetTableName())
-			} else {
-				ur, _ := url.Parse("/" + auditModel.GetTableName())
-
-				pr := &http.Request{
-					Method: "POST",
-					URL:    ur,
-				}
-				pr = pr.WithContext(req.PlainRequest.Context())
-				createRequest := api2go.Request{
-					PlainRequest: pr,
-				}
-				_, err := creator.CreateWithoutFilter(auditModel, createRequest, transaction)
-				if err != nil {
-					log.Errorf("[66] Failed to create audit entry: %v", err)
-				} else {
-					log.Printf("[%v][%v] Created audit record", auditModel.GetTableName(), apiModel.GetID())
-					//log.Printf("ReferenceId for change: %v", resp.Result())
-				}
-			}
+		log.Printf("Object [%v][%v] has been changed, trying to audit in %v", apiModel.GetTableName(), apiModel.GetID(), apiModel.GetTableName()+"_audit")
+		err = dbResource.createAuditRow(data, id, AuditOperationDelete, req, transaction)
+		if err != nil {
+			log.Errorf("[66] Failed to create audit entry: %v", err)
+		} else {
+			log.Printf("[%v][%v] Created audit record", apiModel.GetTableName()+"_audit", apiModel.GetID())
 		}
 	}
 
@@ -103,7 +103,8 @@ func (dbResource *DbResource) DeleteWithoutFilters(id daptinid.DaptinReferenceId
 	//parentReferenceId := daptinid.InterfaceToDIR(data["reference_id"])
 
 	for _, column := range dbResource.model.GetColumns() {
-		if column.IsForeignKey && column.ForeignKeyData.DataSource == "cloud_store" {
+		// files of a soft deleted row stay until it is purged
+		if !softDelete && column.IsForeignKey && column.ForeignKeyData.DataSource == "cloud_store" {
 
 			cloudStoreData, err := dbResource.GetCloudStoreByNameWithTransaction(column.ForeignKeyData.Namespace, transaction)
 			if err != nil {
@@ -483,6 +484,15 @@ func (dbResource *DbResource) DeleteWithoutFilters(id daptinid.DaptinReferenceId
 		}
 	} else {
 
+		err = dbResource.deleteCascadeChildren(parentId, req, transaction, purge, deletedAt)
+		if err != nil {
+			return err
+		}
+
+		if softDelete {
+			return dbResource.markSoftDeleted(id, transaction, deletedAt)
+		}
+
 		queryBuilder := statementbuilder.Squirrel.
 			Delete(m.GetTableName()).Prepared(true).Where(goqu.Ex{"reference_id": id[:]})
 
@@ -503,8 +513,16 @@ func (dbResource *DbResource) DeleteWithoutFilters(id daptinid.DaptinReferenceId
 }
 
 func (dbResource *DbResource) Delete(idString string, req api2go.Request) (api2go.Responder, error) {
+	finish := dbResource.startOperation(&req, "delete")
+	responder, err := dbResource.delete(idString, req)
+	finish(err)
+	return responder, err
+}
+
+func (dbResource *DbResource) delete(idString string, req api2go.Request) (api2go.Responder, error) {
 	id := daptinid.DaptinReferenceId(uuid.MustParse(idString))
 
+	database.MarkWrite(requestContext(req))
 	transaction, err := dbResource.Connection().Beginx()
 	if err != nil {
 		CheckErr(err, "Failed to begin transaction [451]")
@@ -562,6 +580,12 @@ func (dbResource *DbResource) Delete(idString string, req api2go.Request) (api2g
 }
 
 func (dbResource *DbResource) DeleteWithTransaction(id daptinid.DaptinReferenceId, req api2go.Request, transaction *sqlx.Tx) (api2go.Responder, error) {
+	return dbResource.deleteWithTransaction(id, req, transaction, time.Now())
+}
+
+// deleteWithTransaction runs the delete interceptors around deleteWithoutFilters, soft deleted rows
+// get deletedAt so rows deleted along with a parent carry the same time
+func (dbResource *DbResource) deleteWithTransaction(id daptinid.DaptinReferenceId, req api2go.Request, transaction *sqlx.Tx, deletedAt time.Time) (api2go.Responder, error) {
 
 	log.Printf("Delete [%v][%v]", dbResource.model.GetTableName(), id)
 	for _, bf := range dbResource.ms.BeforeDelete {
@@ -581,7 +605,7 @@ func (dbResource *DbResource) DeleteWithTransaction(id daptinid.DaptinReferenceI
 		}
 	}
 
-	err := dbResource.DeleteWithoutFilters(id, req, transaction)
+	err := dbResource.deleteWithoutFilters(id, req, transaction, false, deletedAt)
 	if err != nil {
 		return nil, err
 	}
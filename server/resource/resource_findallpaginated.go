@@ -151,6 +151,18 @@ type PaginationData struct {
 	PageNumber uint64
 	PageSize   uint64
 	TotalCount uint64
+	// Facets has the counts of values of the columns in the facets parameter
+	Facets map[string][]FacetCount
+}
+
+// metadata is the meta of the response, the facet counts when facets were asked for
+func (pagination *PaginationData) metadata() map[string]interface{} {
+	if pagination.Facets == nil {
+		return nil
+	}
+	return map[string]interface{}{
+		"facets": pagination.Facets,
+	}
 }
 
 type Query struct {
@@ -236,6 +248,10 @@ func (dbResource *DbResource) validateSortOrder(sortOrder []string) ([]string, e
 			return nil, fmt.Errorf("table [%v] invalid sort column [%v]", dbResource.model.GetName(), sort)
 		}
 
+		if err := dbResource.virtualColumnError(columnName); err != nil {
+			return nil, err
+		}
+
 		columnInfo, ok := dbResource.tableInfo.GetColumnByName(columnName)
 		if !ok {
 			log.Warnf("Table [%v] invalid sort column [%v]", dbResource.model.GetName(), columnName)
@@ -252,8 +268,23 @@ func (dbResource *DbResource) validateSortOrder(sortOrder []string) ([]string, e
 func (dbResource *DbResource) PaginatedFindAllWithoutFilters(req api2go.Request, transaction *sqlx.Tx) (
 	[]map[string]interface{}, [][]map[string]interface{}, *PaginationData, bool, error) {
 	log.Debugf("Find all row by params: [%v]: %v", dbResource.model.GetName(), req.QueryParams)
-	var err error
+	asOf, hasAsOf, err := dbResource.requestAsOf(req)
+	if err != nil {
+		return nil, nil, nil, false, err
+	}
+	if hasAsOf {
+		return dbResource.paginatedFindAllAsOf(req, asOf, transaction)
+	}
+	if dbResource.usesVirtualColumns(req) {
+		return dbResource.paginatedFindAllWithVirtualColumns(req, transaction)
+	}
+	return dbResource.paginatedFindAllInDatabase(req, dbResource.requestSortOrder(req), transaction)
+}
 
+// paginatedFindAllInDatabase lists the rows of the request from the database in the sort order
+func (dbResource *DbResource) paginatedFindAllInDatabase(req api2go.Request, sortOrder []string, transaction *sqlx.Tx) (
+	[]map[string]interface{}, [][]map[string]interface{}, *PaginationData, bool, error) {
+	var err error
 	user := req.PlainRequest.Context().Value("user")
 	sessionUser := &auth.SessionUser{}
 
@@ -362,14 +393,6 @@ func (dbResource *DbResource) PaginatedFindAllWithoutFilters(req api2go.Request,
 		pageSize = 1
 	}
 
-	var sortOrder []string
-	if len(req.QueryParams["sort"]) > 0 {
-		sortOrder = req.QueryParams["sort"]
-	} else {
-		_, hasCreatedAt := dbResource.tableInfo.GetColumnByName("created_at")
-		sortOrder = resolveDefaultSortOrder(dbResource.tableInfo.DefaultOrder, hasCreatedAt)
-	}
-
 	sortOrder, err = dbResource.validateSortOrder(sortOrder)
 	if err != nil {
 		return nil, nil, nil, false, err
@@ -615,6 +638,37 @@ func (dbResource *DbResource) PaginatedFindAllWithoutFilters(req api2go.Request,
 	duration = time.Since(start)
 	log.Tracef("[TIMING] FindAllAddFilters %v", duration)
 
+	// search matches the searchable columns, with a full text index the rows are ordered by
+	// relevance unless a sort order is asked for
+	var search *tableSearch
+	rankBySearch := false
+	if searchText := strings.Join(req.QueryParams["search"], ","); len(searchText) > 0 {
+		search, err = dbResource.newTableSearch(searchText)
+		if err != nil {
+			return nil, nil, nil, false, err
+		}
+		if len(search.terms) == 0 {
+			search = nil
+		}
+	}
+	if search != nil {
+		searchCondition, err := dbResource.searchCondition(search, prefix, transaction)
+		if err != nil {
+			return nil, nil, nil, false, err
+		}
+		queryBuilder = queryBuilder.Where(searchCondition)
+		countQueryBuilder = countQueryBuilder.Where(searchCondition)
+		if search.ranked && !isRelatedGroupRequest {
+			queryBuilder = queryBuilder.SelectAppend(search.rankColumn(prefix))
+			rankBySearch = len(req.QueryParams["sort"]) == 0
+		}
+	}
+
+	facetColumns, err := dbResource.facetColumns(req.QueryParams["facets"])
+	if err != nil {
+		return nil, nil, nil, false, err
+	}
+
 	//if len(groupings) > 0 && false {
 	//	for _, groupBy := range groupings {
 	//		queryBuilder = queryBuilder.GroupBy(fmt.Sprintf("%s %s", groupBy.ColumnName, groupBy.Order))
@@ -1004,6 +1058,10 @@ func (dbResource *DbResource) PaginatedFindAllWithoutFilters(req api2go.Request,
 		}
 	}
 
+	if rankBySearch {
+		orders = []exp.OrderedExpression{goqu.I("search_rank").Desc()}
+	}
+
 	if !isAdmin && tableModel.GetTableName() != "usergroup" {
 
 		groupReferenceIds := make([]daptinid.DaptinReferenceId, 0)
@@ -1065,6 +1123,17 @@ func (dbResource *DbResource) PaginatedFindAllWithoutFilters(req api2go.Request,
 
 	}
 
+	if dbResource.tableInfo.SoftDelete && !includeDeletedRows(req, isAdmin) {
+		notDeleted := goqu.I(tableModel.GetTableName() + "." + SoftDeleteColumnName).IsNull()
+		queryBuilder = queryBuilder.Where(notDeleted)
+		countQueryBuilder = countQueryBuilder.Where(notDeleted)
+	}
+
+	if tenantFilter := dbResource.tenantFilter(tableModel.GetTableName(), requestTenant(req)); tenantFilter != nil {
+		queryBuilder = queryBuilder.Where(tenantFilter)
+		countQueryBuilder = countQueryBuilder.Where(tenantFilter)
+	}
+
 	idsListQuery, args, err := queryBuilder.Order(orders...).ToSQL()
 	log.Tracef("[983] Id query: [%s]", err)
 	if err != nil {
@@ -1101,6 +1170,10 @@ func (dbResource *DbResource) PaginatedFindAllWithoutFilters(req api2go.Request,
 		return nil, nil, nil, false, err
 	}
 	ids := make([]int64, 0)
+	var searchRanks map[int64]float64
+	if search != nil && search.ranked && !isRelatedGroupRequest {
+		searchRanks = make(map[int64]float64)
+	}
 
 	for idsRow.Next() {
 		row := make(map[string]interface{})
@@ -1109,9 +1182,25 @@ func (dbResource *DbResource) PaginatedFindAllWithoutFilters(req api2go.Request,
 			return nil, nil, nil, false, err
 		}
 		ids = append(ids, row["id"].(int64))
+		if searchRanks != nil {
+			searchRanks[row["id"].(int64)] = searchRank(row["search_rank"])
+		}
 	}
 	_ = idsRow.Close()
 	_ = stmt.Close()
+	filteredQueryTime := time.Since(start)
+
+	// rows ranked by the search keep the order of the id query
+	resultOrders := orders
+	if rankBySearch {
+		resultOrders = []exp.OrderedExpression{idPositionOrder(idColumn, ids)}
+	}
+	if search != nil {
+		finalCols = append(finalCols, column{
+			originalvalue: goqu.C("id"),
+			reference:     "id",
+		})
+	}
 
 	if len(languagePreferences) == 0 {
 
@@ -1127,7 +1216,7 @@ func (dbResource *DbResource) PaginatedFindAllWithoutFilters(req api2go.Request,
 		queryBuilder = statementbuilder.Squirrel.Select(ColumnToInterfaceArray(finalCols)...).Prepared(true).
 			From(tableModel.GetTableName()).Where(goqu.Ex{
 			idColumn: ids,
-		}).Order(orders...)
+		}).Order(resultOrders...)
 
 	} else {
 		var preferredLanguage = languagePreferences[0]
@@ -1169,7 +1258,7 @@ func (dbResource *DbResource) PaginatedFindAllWithoutFilters(req api2go.Request,
 				})).
 			Where(goqu.Ex{
 				idColumn: ids,
-			}).Order(orders...)
+			}).Order(resultOrders...)
 
 	}
 
@@ -1233,9 +1322,13 @@ func (dbResource *DbResource) PaginatedFindAllWithoutFilters(req api2go.Request,
 		if err != nil {
 			return nil, nil, nil, false, err
 		}
+		dbResource.addVirtualColumns(results...)
 		duration = time.Since(start)
 		log.Tracef("[TIMING] FindAll ResultToArray: %v", duration)
 
+		if search != nil {
+			search.addSearchResults(results, searchRanks)
+		}
 	}
 	start = time.Now()
 
@@ -1246,6 +1339,13 @@ func (dbResource *DbResource) PaginatedFindAllWithoutFilters(req api2go.Request,
 
 	duration = time.Since(start)
 	log.Tracef("[TIMING] GetTotalCountBySelectBuilder: %v", duration)
+	filteredQueryTime += duration
+
+	var requestedSortOrder []string
+	if len(req.QueryParams["sort"]) > 0 {
+		requestedSortOrder = sortOrder
+	}
+	ObserveFilteredQuery(dbResource.tableInfo.TableName, queries, requestedSortOrder, filteredQueryTime)
 
 	//log.Printf("Found: %d results", len(results))
 	//log.Printf("Results: %v", results)
@@ -1260,6 +1360,13 @@ func (dbResource *DbResource) PaginatedFindAllWithoutFilters(req api2go.Request,
 		TotalCount: total1,
 	}
 
+	if len(facetColumns) > 0 {
+		paginationData.Facets, err = countFacets(countQueryBuilder, tableModel.GetTableName(), facetColumns, transaction)
+		if err != nil {
+			return nil, nil, nil, false, err
+		}
+	}
+
 	return results, includes, paginationData, finalResponseIsSingleObject, err
 
 }
@@ -1411,6 +1518,10 @@ func (dbResource *DbResource) processQueryFilter(filterQuery Query, prefix strin
 	columnName := filterQuery.ColumnName
 	tableInfo := dbResource.tableInfo
 
+	if err := dbResource.virtualColumnError(columnName); err != nil {
+		return nil, err
+	}
+
 	colInfo, ok := tableInfo.GetColumnByName(columnName)
 
 	if !ok {
@@ -1470,8 +1581,17 @@ func (dbResource *DbResource) processQueryFilter(filterQuery Query, prefix strin
 	actualvalue = filterQuery.Value
 
 	if filterQuery.ColumnName == "reference_id" {
-		i := daptinid.InterfaceToDIR(filterQuery.Value)
-		actualvalue = i[:]
+		if values, isList := filterQuery.Value.([]interface{}); isList {
+			referenceIds := make([]interface{}, 0, len(values))
+			for _, value := range values {
+				i := daptinid.InterfaceToDIR(value)
+				referenceIds = append(referenceIds, i[:])
+			}
+			actualvalue = referenceIds
+		} else {
+			i := daptinid.InterfaceToDIR(filterQuery.Value)
+			actualvalue = i[:]
+		}
 	}
 
 	// Handle "is" and "not" operators
@@ -1906,8 +2026,15 @@ func (dbResource *DbResource) FindAll(req api2go.Request) (response api2go.Respo
 }
 
 func (dbResource *DbResource) PaginatedFindAll(req api2go.Request) (totalCount uint, response api2go.Responder, err error) {
+	finish := dbResource.startOperation(&req, "find_all")
+	totalCount, response, err = dbResource.paginatedFindAll(req)
+	finish(err)
+	return totalCount, response, err
+}
+
+func (dbResource *DbResource) paginatedFindAll(req api2go.Request) (totalCount uint, response api2go.Responder, err error) {
 
-	transaction, err := dbResource.Connection().Beginx()
+	transaction, err := dbResource.BeginReadTransaction(requestContext(req))
 	if err != nil {
 		CheckErr(err, "Failed to begin transaction [1434]")
 		return 0, nil, err
@@ -2032,7 +2159,7 @@ func (dbResource *DbResource) PaginatedFindAll(req api2go.Request) (totalCount u
 			resultObj = nil
 		}
 	}
-	return uint(pagination.TotalCount), NewResponse(nil, resultObj, 200, &api2go.Pagination{
+	return uint(pagination.TotalCount), NewResponse(pagination.metadata(), resultObj, 200, &api2go.Pagination{
 		//Next:        map[string]string{"limit": fmt.Sprintf("%v", pagination.PageSize), "offset": fmt.Sprintf("%v", pagination.PageSize+pagination.PageNumber)},
 		//Prev:        map[string]string{"limit": fmt.Sprintf("%v", pagination.PageSize), "offset": fmt.Sprintf("%v", pagination.PageNumber-pagination.PageSize)},
 		//First:       map[string]string{},
@@ -2150,7 +2277,7 @@ func (dbResource *DbResource) PaginatedFindAllWithTransaction(req api2go.Request
 			resultObj = nil
 		}
 	}
-	return uint(pagination.TotalCount), NewResponse(nil, resultObj, 200, &api2go.Pagination{
+	return uint(pagination.TotalCount), NewResponse(pagination.metadata(), resultObj, 200, &api2go.Pagination{
 		//Next:        map[string]string{"limit": fmt.Sprintf("%v", pagination.PageSize), "offset": fmt.Sprintf("%v", pagination.PageSize+pagination.PageNumber)},
 		//Prev:        map[string]string{"limit": fmt.Sprintf("%v", pagination.PageSize), "offset": fmt.Sprintf("%v", pagination.PageNumber-pagination.PageSize)},
 		//First:       map[string]string{},
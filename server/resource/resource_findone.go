@@ -21,6 +21,13 @@ import (
 // FindOne returns an object by its ID
 // Possible Responder success status code 200
 func (dbResource *DbResource) FindOne(referenceIdString string, req api2go.Request) (api2go.Responder, error) {
+	finish := dbResource.startOperation(&req, "find_one")
+	responder, err := dbResource.findOne(referenceIdString, req)
+	finish(err)
+	return responder, err
+}
+
+func (dbResource *DbResource) findOne(referenceIdString string, req api2go.Request) (api2go.Responder, error) {
 
 	var referenceId daptinid.DaptinReferenceId
 
@@ -42,7 +49,7 @@ func (dbResource *DbResource) FindOne(referenceIdString string, req api2go.Reque
 		}
 	}
 
-	transaction, err := dbResource.Connection().Beginx()
+	transaction, err := dbResource.BeginReadTransaction(requestContext(req))
 	if err != nil {
 		CheckErr(err, "Failed to begin transaction [34]")
 		return nil, err
@@ -96,10 +103,30 @@ func (dbResource *DbResource) FindOne(referenceIdString string, req api2go.Reque
 		includedRelations = nil
 	}
 
+	asOf, hasAsOf, err := dbResource.requestAsOf(req)
+	if err != nil {
+		rollbackErr := transaction.Rollback()
+		CheckErr(rollbackErr, "Failed to rollback")
+		return nil, err
+	}
+
 	start := time.Now()
 	data, include, err := dbResource.GetSingleRowByReferenceIdWithTransaction(modelName, referenceId, includedRelations, transaction)
 	log.Tracef("Completed FindOne GetSingleRowByReferenceIdWithTransaction")
+	if hasAsOf {
+		if err != nil {
+			data = nil
+		}
+		data, err = dbResource.rowAsOf(referenceId, data, asOf, transaction)
+		include = nil
+	}
 
+	if err == nil {
+		err = dbResource.checkNotSoftDeleted(data, req, transaction)
+	}
+	if err == nil {
+		err = dbResource.checkTenant(data[TenantColumnName], data["reference_id"], req)
+	}
 	if err != nil {
 		rollbackErr := transaction.Rollback()
 		CheckErr(rollbackErr, "Failed to rollback")
@@ -107,8 +134,9 @@ func (dbResource *DbResource) FindOne(referenceIdString string, req api2go.Reque
 	}
 	duration := time.Since(start)
 	log.Tracef("[TIMING] FindOne: %v", duration)
+	version := data["version"]
 
-	if OlricCache != nil {
+	if OlricCache != nil && data["id"] != nil {
 		cacheKey := fmt.Sprintf("riti-%v-%v", modelName, referenceId)
 		_ = OlricCache.Put(context.Background(), cacheKey, data["id"], olric.EX(5*time.Minute), olric.NX())
 		cacheKey2 := fmt.Sprintf("itr-%v-%v", modelName, data["id"])
@@ -149,6 +177,8 @@ func (dbResource *DbResource) FindOne(referenceIdString string, req api2go.Reque
 		}
 	}
 
+	dbResource.addVirtualColumns(data)
+
 	//log.Tracef("Single row result: %v", data)
 	for _, bf := range dbResource.ms.AfterFindOne {
 		log.Tracef("Invoke AfterFindOne [%v][%v] on FindAll Request", bf.String(), modelName)
@@ -184,6 +214,9 @@ func (dbResource *DbResource) FindOne(referenceIdString string, req api2go.Reque
 
 	commitErr := transaction.Commit()
 	CheckErr(commitErr, "failed to commit")
+	if data != nil && !hasAsOf {
+		RecordEntityVersion(req, referenceId, version)
+	}
 
 	infos := dbResource.model.GetColumns()
 	var a = api2go.NewApi2GoModelWithData(dbResource.model.GetTableName(), infos,
@@ -258,6 +291,12 @@ func (dbResource *DbResource) FindOneWithTransaction(referenceId daptinid.Daptin
 	log.Tracef("FindOneWithTransaction GetSingleRowByReferenceIdWithTransaction")
 	data, include, err := dbResource.GetSingleRowByReferenceIdWithTransaction(modelName, referenceId, includedRelations, transaction)
 	log.Tracef("Completed FindOneWithTransaction GetSingleRowByReferenceIdWithTransaction")
+	if err == nil {
+		err = dbResource.checkNotSoftDeleted(data, req, transaction)
+	}
+	if err == nil {
+		err = dbResource.checkTenant(data[TenantColumnName], data["reference_id"], req)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -302,6 +341,8 @@ func (dbResource *DbResource) FindOneWithTransaction(referenceId daptinid.Daptin
 		}
 	}
 
+	dbResource.addVirtualColumns(data)
+
 	//log.Tracef("Single row result: %v", data)
 	for _, bf := range dbResource.ms.AfterFindOne {
 		log.Tracef("Invoke AfterFindOne [%v][%v] on FindAll Request", bf.String(), modelName)
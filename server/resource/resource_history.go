@@ -0,0 +1,883 @@
+package resource
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/araddon/dateparse"
+	"github.com/artpar/api2go/v2"
+	"github.com/daptin/daptin/server/actionresponse"
+	"github.com/daptin/daptin/server/auth"
+	daptinid "github.com/daptin/daptin/server/id"
+	"github.com/daptin/daptin/server/statementbuilder"
+	"github.com/doug-martin/goqu/v9"
+	"github.com/jmoiron/sqlx"
+	log "github.com/sirupsen/logrus"
+)
+
+// RequestIdContextKey is the request context key under which the http layer places the id of the
+// request, audit rows keep it to tell which changes were made together
+const RequestIdContextKey = "request_id"
+
+// AsOfQueryParameter reads the rows of an audited table as they were at a time
+const AsOfQueryParameter = "as_of"
+
+// AsOfRowLimit is the most rows and later changes a listing as of a time rebuilds, the rows at a time
+// are rebuilt in memory before they are filtered, sorted and paged
+const AsOfRowLimit = 10000
+
+const (
+	// AuditChangedByColumn is the reference id of the user who made the change an audit row records
+	AuditChangedByColumn = "audit_changed_by"
+	// AuditRequestIdColumn is the id of the request which made the change
+	AuditRequestIdColumn = "audit_request_id"
+	// AuditSourceCreatedAtColumn is when the audited row was created, to tell if it existed at a time
+	// after it is deleted
+	AuditSourceCreatedAtColumn = "source_created_at"
+)
+
+// FieldChange is the change of one column of a row
+type FieldChange struct {
+	Field string      `json:"field"`
+	From  interface{} `json:"from"`
+	To    interface{} `json:"to"`
+}
+
+// RowChange is one entry in the history of a row. Version is the version of the row the change was
+// made to, the row can be reverted to it.
+type RowChange struct {
+	Version   int64         `json:"version"`
+	Operation string        `json:"operation"`
+	ChangedAt time.Time     `json:"changed_at"`
+	ChangedBy string        `json:"changed_by,omitempty"`
+	RequestId string        `json:"request_id,omitempty"`
+	Changes   []FieldChange `json:"changes"`
+}
+
+// setAuditContext records who made the change, in which request, and when the audited row was created
+func setAuditContext(auditModel *api2go.Api2GoModel, sourceCreatedAt interface{}, req api2go.Request) {
+	ctx := requestContext(req)
+	if sessionUser, ok := ctx.Value("user").(*auth.SessionUser); ok && sessionUser != nil &&
+		sessionUser.UserReferenceId != daptinid.NullReferenceId {
+		auditModel.Set(AuditChangedByColumn, sessionUser.UserReferenceId.String())
+	}
+	if requestId, ok := ctx.Value(RequestIdContextKey).(string); ok && requestId != "" {
+		auditModel.Set(AuditRequestIdColumn, requestId)
+	}
+	if sourceCreatedAt != nil {
+		auditModel.Set(AuditSourceCreatedAtColumn, sourceCreatedAt)
+	}
+}
+
+// createAuditRow adds the values of an audited row, as read before the change, to its audit table
+func (dbResource *DbResource) createAuditRow(data map[string]interface{}, referenceId daptinid.DaptinReferenceId,
+	operation string, req api2go.Request, transaction *sqlx.Tx) error {
+	auditModel := api2go.NewApi2GoModelWithData(dbResource.model.GetTableName(), nil, 0, nil, data).GetAuditModel()
+	creator, ok := dbResource.Cruds[auditModel.GetTableName()]
+	if !ok {
+		log.Errorf("No creator for audit type: %v", auditModel.GetTableName())
+		return nil
+	}
+	auditAttrs := auditModel.GetAttributes()
+	for _, col := range dbResource.tableInfo.Columns {
+		if asDir, isDir := auditAttrs[col.ColumnName].(daptinid.DaptinReferenceId); isDir && col.IsForeignKey {
+			auditModel.Set(col.ColumnName, asDir.String())
+		}
+	}
+	auditModel.Set("source_reference_id", referenceId.String())
+	auditModel.Set("operation", operation)
+	setAuditContext(&auditModel, data["created_at"], req)
+
+	ur, _ := url.Parse("/" + auditModel.GetTableName())
+	pr := &http.Request{
+		Method: "POST",
+		URL:    ur,
+	}
+	pr = pr.WithContext(requestContext(req))
+	_, err := creator.CreateWithoutFilter(auditModel, api2go.Request{PlainRequest: pr}, transaction)
+	return err
+}
+
+// requestAsOf is the time of the as_of parameter of the request
+func (dbResource *DbResource) requestAsOf(req api2go.Request) (time.Time, bool, error) {
+	values := req.QueryParams[AsOfQueryParameter]
+	if len(values) == 0 && req.PlainRequest != nil && req.PlainRequest.URL != nil {
+		values = req.PlainRequest.URL.Query()[AsOfQueryParameter]
+	}
+	if len(values) == 0 || values[0] == "" {
+		return time.Time{}, false, nil
+	}
+	if !dbResource.tableInfo.IsAuditEnabled {
+		return time.Time{}, false, api2go.NewHTTPError(fmt.Errorf("[%v] is not audited", dbResource.model.GetName()),
+			"history is not kept for this table", http.StatusBadRequest)
+	}
+	asOf, err := dateparse.ParseAny(values[0])
+	if err != nil {
+		return time.Time{}, false, api2go.NewHTTPError(err, "invalid as_of", http.StatusBadRequest)
+	}
+	return asOf, true, nil
+}
+
+// historyTime reads a time stored in an audit row
+func historyTime(value interface{}) time.Time {
+	switch v := value.(type) {
+	case time.Time:
+		return v
+	case string:
+		t, _ := dateparse.ParseAny(v)
+		return t
+	case []byte:
+		t, _ := dateparse.ParseAny(string(v))
+		return t
+	default:
+		return time.Time{}
+	}
+}
+
+// historyValue is a column value as shown in the history, references as their string form
+func historyValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case []byte:
+		return string(v)
+	case daptinid.DaptinReferenceId:
+		return v.String()
+	default:
+		return v
+	}
+}
+
+// historyColumns are the columns of the table kept in its audit rows, without the columns every
+// change updates
+func (dbResource *DbResource) historyColumns() []api2go.ColumnInfo {
+	columns := make([]api2go.ColumnInfo, 0)
+	for _, col := range dbResource.tableInfo.Columns {
+		switch col.ColumnName {
+		case "created_at", "updated_at", "version", TenantColumnName:
+			continue
+		}
+		if !shouldCopyColumnToAudit(col) {
+			continue
+		}
+		columns = append(columns, col)
+	}
+	return columns
+}
+
+// selectAuditRows reads audit rows in the order they were written
+func (dbResource *DbResource) selectAuditRows(where goqu.Expression, transaction *sqlx.Tx) ([]map[string]interface{}, error) {
+	sql1, args, err := statementbuilder.Squirrel.Select(goqu.L("*")).Prepared(true).
+		From(dbResource.model.GetName() + "_audit").Where(where).Order(goqu.C("id").Asc()).ToSQL()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := transaction.Queryx(sql1, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	auditRows := make([]map[string]interface{}, 0)
+	for rows.Next() {
+		row := make(map[string]interface{})
+		err = rows.MapScan(row)
+		if err != nil {
+			return nil, err
+		}
+		for key, value := range row {
+			if asBytes, ok := value.([]byte); ok {
+				row[key] = string(asBytes)
+			}
+		}
+		auditRows = append(auditRows, row)
+	}
+	return auditRows, rows.Err()
+}
+
+// countRows is the number of rows the query selects
+func countRows(query *goqu.SelectDataset, transaction *sqlx.Tx) (int64, error) {
+	sql1, args, err := query.Select(goqu.COUNT(goqu.Star())).ToSQL()
+	if err != nil {
+		return 0, err
+	}
+	var count int64
+	err = transaction.QueryRowx(sql1, args...).Scan(&count)
+	return count, err
+}
+
+// auditRowsOf are the audit rows of a row, oldest first
+func (dbResource *DbResource) auditRowsOf(referenceId daptinid.DaptinReferenceId, transaction *sqlx.Tx) ([]map[string]interface{}, error) {
+	return dbResource.selectAuditRows(goqu.Ex{"source_reference_id": referenceId.String()}, transaction)
+}
+
+// fieldChanges lists the history columns which differ between two states of a row, a nil state is a
+// row which does not exist
+func (dbResource *DbResource) fieldChanges(before map[string]interface{}, after map[string]interface{}) []FieldChange {
+	changes := make([]FieldChange, 0)
+	for _, col := range dbResource.historyColumns() {
+		var from, to interface{}
+		if before != nil {
+			from = historyValue(before[col.ColumnName])
+		}
+		if after != nil {
+			to = historyValue(after[col.ColumnName])
+		}
+		if from == nil && to == nil {
+			continue
+		}
+		if from != nil && to != nil && fmt.Sprint(from) == fmt.Sprint(to) {
+			continue
+		}
+		changes = append(changes, FieldChange{Field: col.ColumnName, From: from, To: to})
+	}
+	return changes
+}
+
+// rowChanges turns the audit rows of a row into its history. Each audit row keeps the row before a
+// change, the row after it is the next audit row or the current row.
+func (dbResource *DbResource) rowChanges(auditRows []map[string]interface{}, current map[string]interface{}) []RowChange {
+	history := make([]RowChange, 0, len(auditRows))
+	for i, auditRow := range auditRows {
+		operation, _ := auditRow["operation"].(string)
+		var after map[string]interface{}
+		if operation != AuditOperationDelete {
+			if i+1 < len(auditRows) {
+				after = auditRows[i+1]
+			} else {
+				after = current
+			}
+		}
+		changedBy, _ := auditRow[AuditChangedByColumn].(string)
+		requestId, _ := auditRow[AuditRequestIdColumn].(string)
+		history = append(history, RowChange{
+			Version:   toInt64(auditRow["version"]),
+			Operation: operation,
+			ChangedAt: historyTime(auditRow["created_at"]),
+			ChangedBy: changedBy,
+			RequestId: requestId,
+			Changes:   dbResource.fieldChanges(auditRow, after),
+		})
+	}
+	return history
+}
+
+// RowHistoryWithTransaction is the history of a row, oldest change first. The user has to be able
+// to read the row, the history of deleted rows is for administrators.
+func (dbResource *DbResource) RowHistoryWithTransaction(referenceId daptinid.DaptinReferenceId, req api2go.Request, transaction *sqlx.Tx) ([]RowChange, error) {
+	tableName := dbResource.model.GetName()
+	if !dbResource.tableInfo.IsAuditEnabled {
+		return nil, api2go.NewHTTPError(fmt.Errorf("[%v] is not audited", tableName), "history is not kept for this table", http.StatusBadRequest)
+	}
+
+	sessionUser := &auth.SessionUser{}
+	if user, ok := requestContext(req).Value("user").(*auth.SessionUser); ok && user != nil {
+		sessionUser = user
+	}
+	isAdmin := IsAdminWithTransaction(sessionUser, transaction)
+
+	auditRows, err := dbResource.auditRowsOf(referenceId, transaction)
+	if err != nil {
+		return nil, err
+	}
+
+	current, err := dbResource.GetReferenceIdToObjectWithTransaction(tableName, referenceId, transaction)
+	if err != nil {
+		current = nil
+	}
+	notFound := api2go.NewHTTPError(fmt.Errorf("[%v][%v] not found", tableName, referenceId), "object not found", http.StatusNotFound)
+	if current == nil && (len(auditRows) == 0 || !isAdmin) {
+		return nil, notFound
+	}
+
+	tenantRow := current
+	if tenantRow == nil {
+		tenantRow = auditRows[len(auditRows)-1]
+	}
+	err = dbResource.checkTenant(tenantRow[TenantColumnName], referenceId, req)
+	if err != nil {
+		return nil, err
+	}
+
+	if current != nil && !isAdmin {
+		current["__type"] = tableName
+		permission := dbResource.GetRowPermissionWithTransaction(current, transaction)
+		if !permission.CanRead(sessionUser.UserReferenceId, sessionUser.Groups, dbResource.AdministratorGroupId) {
+			return nil, api2go.NewHTTPError(fmt.Errorf("[%v][%v] cannot be read", tableName, referenceId), "forbidden", http.StatusForbidden)
+		}
+	}
+
+	return dbResource.rowChanges(auditRows, current), nil
+}
+
+// stateAsOf is a row as it was at the time, from the audit rows of the row and the row as it is now,
+// which is nil for a deleted row. The first audit row written after the time keeps the row as it was
+// then. ok is false when the row did not exist at the time.
+func (dbResource *DbResource) stateAsOf(referenceId daptinid.DaptinReferenceId, current map[string]interface{},
+	auditRows []map[string]interface{}, asOf time.Time) (map[string]interface{}, bool) {
+
+	var snapshot map[string]interface{}
+	for _, auditRow := range auditRows {
+		if historyTime(auditRow["created_at"]).After(asOf) {
+			snapshot = auditRow
+			break
+		}
+	}
+
+	if snapshot == nil {
+		if current == nil || historyTime(current["created_at"]).After(asOf) {
+			return nil, false
+		}
+		return current, true
+	}
+
+	createdAt := historyTime(snapshot[AuditSourceCreatedAtColumn])
+	if createdAt.IsZero() && current != nil {
+		createdAt = historyTime(current["created_at"])
+	}
+	if createdAt.After(asOf) {
+		return nil, false
+	}
+
+	state := make(map[string]interface{})
+	if current != nil {
+		for key, value := range current {
+			state[key] = value
+		}
+	} else {
+		state["__type"] = dbResource.model.GetName()
+		state["reference_id"] = referenceId
+		state["permission"] = int64(0)
+		state[TenantColumnName] = snapshot[TenantColumnName]
+		state["created_at"] = snapshot[AuditSourceCreatedAtColumn]
+	}
+	state["version"] = snapshot["version"]
+	for _, col := range dbResource.historyColumns() {
+		value := snapshot[col.ColumnName]
+		if col.IsForeignKey && col.ForeignKeyData.DataSource == "self" {
+			if asString, ok := value.(string); ok && asString != "" {
+				value = daptinid.InterfaceToDIR(asString)
+			} else {
+				value = nil
+			}
+		}
+		state[col.ColumnName] = value
+	}
+	return state, true
+}
+
+// rowAsOf is the row with the reference id as it was at the time, current is the row as it is now
+// or nil when it is not found
+func (dbResource *DbResource) rowAsOf(referenceId daptinid.DaptinReferenceId, current map[string]interface{},
+	asOf time.Time, transaction *sqlx.Tx) (map[string]interface{}, error) {
+	auditRows, err := dbResource.auditRowsOf(referenceId, transaction)
+	if err != nil {
+		return nil, err
+	}
+	state, ok := dbResource.stateAsOf(referenceId, current, auditRows, asOf)
+	if !ok || (dbResource.tableInfo.SoftDelete && state[SoftDeleteColumnName] != nil) {
+		return nil, api2go.NewHTTPError(fmt.Errorf("[%v][%v] did not exist at %v", dbResource.model.GetName(), referenceId, asOf),
+			"object not found", http.StatusNotFound)
+	}
+	return state, nil
+}
+
+// paginatedFindAllAsOf lists the rows of the table as they were at the time, including the rows
+// deleted since. The rows at a time are not in any table, so the permission check, query, filter
+// and sort order are applied to the rebuilt rows before they are counted and paged.
+func (dbResource *DbResource) paginatedFindAllAsOf(req api2go.Request, asOf time.Time, transaction *sqlx.Tx) (
+	[]map[string]interface{}, [][]map[string]interface{}, *PaginationData, bool, error) {
+	tableName := dbResource.model.GetName()
+
+	queries, err := dbResource.inMemoryQueries(req, "with "+AsOfQueryParameter)
+	if err != nil {
+		return nil, nil, nil, false, err
+	}
+	sortOrder, err := dbResource.validateInMemorySortOrder(dbResource.requestSortOrder(req))
+	if err != nil {
+		return nil, nil, nil, false, api2go.NewHTTPError(err, err.Error(), http.StatusBadRequest)
+	}
+	var filters []string
+	if len(queries) == 0 {
+		filters = append(filters, req.QueryParams["filter"]...)
+		filters = append(filters, req.QueryParams["filter[]"]...)
+	}
+
+	sessionUser := &auth.SessionUser{}
+	if user, ok := requestContext(req).Value("user").(*auth.SessionUser); ok && user != nil {
+		sessionUser = user
+	}
+	isAdmin := IsAdminWithTransaction(sessionUser, transaction)
+
+	query := statementbuilder.Squirrel.From(tableName).Prepared(true).Where(goqu.C("created_at").Lte(asOf))
+	if tenantFilter := dbResource.tenantFilter(tableName, requestTenant(req)); tenantFilter != nil {
+		query = query.Where(tenantFilter)
+	}
+	rowCount, err := countRows(query, transaction)
+	if err != nil {
+		return nil, nil, nil, false, err
+	}
+	changeCount, err := countRows(statementbuilder.Squirrel.From(tableName+"_audit").Prepared(true).
+		Where(goqu.C("created_at").Gt(asOf)), transaction)
+	if err != nil {
+		return nil, nil, nil, false, err
+	}
+	if rowCount+changeCount > AsOfRowLimit {
+		return nil, nil, nil, false, api2go.NewHTTPError(
+			fmt.Errorf("[%v] has %d rows and %d changes to rebuild as of %v", tableName, rowCount, changeCount, asOf),
+			fmt.Sprintf("more than %d rows and changes to rebuild, read single rows as of the time instead", AsOfRowLimit),
+			http.StatusBadRequest)
+	}
+
+	sql1, args, err := query.Select(goqu.L("*")).Order(goqu.C("id").Asc()).ToSQL()
+	if err != nil {
+		return nil, nil, nil, false, err
+	}
+	rows, err := transaction.Queryx(sql1, args...)
+	if err != nil {
+		return nil, nil, nil, false, err
+	}
+	responseArray, err := RowsToMap(rows, tableName)
+	rows.Close()
+	if err != nil {
+		return nil, nil, nil, false, err
+	}
+	currentRows, _, err := dbResource.ResultToArrayOfMapWithTransaction(responseArray, dbResource.model.GetColumnMap(), nil, transaction)
+	if err != nil {
+		return nil, nil, nil, false, err
+	}
+
+	laterAuditRows, err := dbResource.selectAuditRows(goqu.C("created_at").Gt(asOf), transaction)
+	if err != nil {
+		return nil, nil, nil, false, err
+	}
+	auditRowsByReferenceId := make(map[string][]map[string]interface{})
+	deletedReferenceIds := make([]string, 0)
+	for _, auditRow := range laterAuditRows {
+		sourceReferenceId, _ := auditRow["source_reference_id"].(string)
+		if _, ok := auditRowsByReferenceId[sourceReferenceId]; !ok {
+			deletedReferenceIds = append(deletedReferenceIds, sourceReferenceId)
+		}
+		auditRowsByReferenceId[sourceReferenceId] = append(auditRowsByReferenceId[sourceReferenceId], auditRow)
+	}
+
+	results := make([]map[string]interface{}, 0)
+	listed := make(map[string]bool)
+	for _, current := range currentRows {
+		referenceId := daptinid.InterfaceToDIR(current["reference_id"])
+		listed[referenceId.String()] = true
+		state, ok := dbResource.stateAsOf(referenceId, current, auditRowsByReferenceId[referenceId.String()], asOf)
+		if !ok {
+			continue
+		}
+		if !isAdmin {
+			state["__type"] = tableName
+			permission := dbResource.GetRowPermissionWithTransaction(state, transaction)
+			if !permission.CanRead(sessionUser.UserReferenceId, sessionUser.Groups, dbResource.AdministratorGroupId) {
+				continue
+			}
+		}
+		results = append(results, state)
+	}
+
+	// rows which are not listed were created after the time or have been deleted since, the rows
+	// deleted since carry no permission and are listed to administrators only
+	for _, sourceReferenceId := range deletedReferenceIds {
+		if !isAdmin || listed[sourceReferenceId] {
+			continue
+		}
+		referenceId := daptinid.InterfaceToDIR(sourceReferenceId)
+		if referenceId == daptinid.NullReferenceId {
+			continue
+		}
+		if _, err := GetReferenceIdToIdWithTransaction(tableName, referenceId, transaction); err == nil {
+			continue
+		}
+		state, ok := dbResource.stateAsOf(referenceId, nil, auditRowsByReferenceId[sourceReferenceId], asOf)
+		if !ok || dbResource.checkTenant(state[TenantColumnName], referenceId, req) != nil {
+			continue
+		}
+		results = append(results, state)
+	}
+
+	// virtual computed columns are computed from the rows as they were, before they are filtered on
+	dbResource.addVirtualColumns(results...)
+	visible := make([]map[string]interface{}, 0, len(results))
+	for _, state := range results {
+		if dbResource.tableInfo.SoftDelete && state[SoftDeleteColumnName] != nil {
+			continue
+		}
+		matches, err := dbResource.matchesAsOfQueries(state, queries)
+		if err != nil {
+			return nil, nil, nil, false, err
+		}
+		if matches && dbResource.matchesAsOfFilters(state, filters) {
+			visible = append(visible, state)
+		}
+	}
+	sortAsOf(visible, sortOrder)
+
+	pagination := asOfPagination(req, uint64(len(visible)))
+	start := (pagination.PageNumber - 1) * pagination.PageSize
+	if start > uint64(len(visible)) {
+		start = uint64(len(visible))
+	}
+	end := start + pagination.PageSize
+	if end > uint64(len(visible)) {
+		end = uint64(len(visible))
+	}
+	page := visible[start:end]
+	log.Debugf("[%v] has %d rows as of %v", tableName, len(visible), asOf)
+
+	includes := make([][]map[string]interface{}, len(page))
+	for i := range includes {
+		includes[i] = make([]map[string]interface{}, 0)
+	}
+	return page, includes, pagination, false, nil
+}
+
+// inMemoryQueries reads the query parameter of a listing whose rows are filtered in memory, the rows
+// as they were at a time or rows filtered on virtual computed columns. Fuzzy searches need the
+// database and are not supported on them, unsupported tells the case in the error.
+func (dbResource *DbResource) inMemoryQueries(req api2go.Request, unsupported string) ([]Query, error) {
+	queries, err := dbResource.requestQueries(req)
+	if err != nil {
+		return nil, err
+	}
+	return queries, fuzzyQueryError(queries, unsupported)
+}
+
+// fuzzyQueryError is returned for a fuzzy query among queries which are matched in memory
+func fuzzyQueryError(queries []Query, unsupported string) error {
+	for _, q := range queries {
+		if BeginsWith(q.Operator, "fuzzy") {
+			err := fmt.Errorf("[%v] is not supported %v", q.Operator, unsupported)
+			return api2go.NewHTTPError(err, err.Error(), http.StatusBadRequest)
+		}
+	}
+	return nil
+}
+
+// requestQueries reads the json query parameter of a listing, each query is on a column of the
+// table or on a virtual computed column
+func (dbResource *DbResource) requestQueries(req api2go.Request) ([]Query, error) {
+	queries := make([]Query, 0)
+	query := req.QueryParams["query"]
+	if len(query) == 0 {
+		return queries, nil
+	}
+	// api2go splits the values on comma, join them back to read the json
+	queryJson := strings.Join(query, ",")
+	if len(queryJson) == 0 || queryJson[0] != '[' {
+		return queries, nil
+	}
+	if err := json.Unmarshal([]byte(queryJson), &queries); err != nil {
+		return nil, api2go.NewHTTPError(err, fmt.Sprintf("failed to read query: %v", err), http.StatusBadRequest)
+	}
+	for _, q := range queries {
+		if _, ok := dbResource.tableInfo.GetColumnByName(q.ColumnName); !ok && !dbResource.isVirtualColumn(q.ColumnName) {
+			err := fmt.Errorf("table [%v] invalid column query [%v]", dbResource.model.GetName(), q.ColumnName)
+			return nil, api2go.NewHTTPError(err, err.Error(), http.StatusBadRequest)
+		}
+		if _, ok := OperatorMap[q.Operator]; !ok {
+			err := fmt.Errorf("invalid query operator [%v]", q.Operator)
+			return nil, api2go.NewHTTPError(err, err.Error(), http.StatusBadRequest)
+		}
+	}
+	return queries, nil
+}
+
+// matchesAsOfQueries tells if the row matches the queries, like addFilters the queries without a
+// logical group must all match and at least one query of each logical group must match
+func (dbResource *DbResource) matchesAsOfQueries(row map[string]interface{}, queries []Query) (bool, error) {
+	groupMatches := make(map[string]bool)
+	for _, q := range queries {
+		matches, err := matchesAsOfQuery(row[q.ColumnName], q)
+		if err != nil {
+			return false, err
+		}
+		if q.LogicalGroup == "" {
+			if !matches {
+				return false, nil
+			}
+			continue
+		}
+		groupMatches[q.LogicalGroup] = groupMatches[q.LogicalGroup] || matches
+	}
+	for _, matches := range groupMatches {
+		if !matches {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// matchesAsOfQuery compares one value of a row as it was with the operators of OperatorMap
+func matchesAsOfQuery(value interface{}, q Query) (bool, error) {
+	switch OperatorMap[q.Operator] {
+	case "like", "iLike":
+		return value != nil && asOfLike(asOfText(value), asOfText(q.Value)), nil
+	case "notLike", "notILike":
+		return value != nil && !asOfLike(asOfText(value), asOfText(q.Value)), nil
+	case "is", "eq":
+		if q.Value == nil {
+			return value == nil, nil
+		}
+		return value != nil && compareAsOf(value, q.Value) == 0, nil
+	case "neq", "isNot":
+		if q.Value == nil {
+			return value != nil, nil
+		}
+		return value != nil && compareAsOf(value, q.Value) != 0, nil
+	case "in":
+		return value != nil && asOfIn(value, q.Value), nil
+	case "notIn":
+		return value != nil && !asOfIn(value, q.Value), nil
+	case "lt":
+		return value != nil && compareAsOf(value, q.Value) < 0, nil
+	case "gt":
+		return value != nil && compareAsOf(value, q.Value) > 0, nil
+	case "is nil":
+		return value == nil, nil
+	case "is true":
+		return ValueToBool(value), nil
+	case "is false":
+		return value != nil && !ValueToBool(value), nil
+	}
+	err := fmt.Errorf("invalid query operator [%v]", q.Operator)
+	return false, api2go.NewHTTPError(err, err.Error(), http.StatusBadRequest)
+}
+
+// matchesAsOfFilters is the free text filter of a listing, the searchable name, label and email
+// columns of the row contain one of the filters
+func (dbResource *DbResource) matchesAsOfFilters(row map[string]interface{}, filters []string) bool {
+	searched := false
+	for _, col := range dbResource.model.GetColumns() {
+		if !(col.IsIndexed || col.IsUnique) || !(strings.Index(col.ColumnType, "name") > -1 || col.ColumnType == "label" || col.ColumnType == "email") {
+			continue
+		}
+		for _, filter := range filters {
+			if len(filter) < 1 {
+				continue
+			}
+			searched = true
+			if row[col.ColumnName] != nil && asOfLike(asOfText(row[col.ColumnName]), "%"+filter+"%") {
+				return true
+			}
+		}
+	}
+	return !searched
+}
+
+// sortAsOf orders the rows by the validated sort order, a - before a column sorts it descending
+func sortAsOf(rows []map[string]interface{}, sortOrder []string) {
+	sort.SliceStable(rows, func(i, j int) bool {
+		return lessAsOf(rows[i], rows[j], sortOrder)
+	})
+}
+
+// lessAsOf tells if row a comes before row b in the validated sort order
+func lessAsOf(a, b map[string]interface{}, sortOrder []string) bool {
+	for _, order := range sortOrder {
+		columnName := strings.TrimLeft(order, "+-")
+		compared := compareAsOf(a[columnName], b[columnName])
+		if compared == 0 {
+			continue
+		}
+		if order[0] == '-' {
+			return compared > 0
+		}
+		return compared < 0
+	}
+	return false
+}
+
+// compareAsOf compares two values as numbers, then as times and then as text. Empty values come
+// first.
+func compareAsOf(a, b interface{}) int {
+	if a == nil || b == nil {
+		switch {
+		case a == nil && b == nil:
+			return 0
+		case a == nil:
+			return -1
+		default:
+			return 1
+		}
+	}
+	aNumber, aIsNumber := asOfNumber(a)
+	bNumber, bIsNumber := asOfNumber(b)
+	if aIsNumber && bIsNumber {
+		switch {
+		case aNumber < bNumber:
+			return -1
+		case aNumber > bNumber:
+			return 1
+		}
+		return 0
+	}
+	aTime, bTime := historyTime(a), historyTime(b)
+	if !aTime.IsZero() && !bTime.IsZero() {
+		return aTime.Compare(bTime)
+	}
+	return strings.Compare(asOfText(a), asOfText(b))
+}
+
+func asOfNumber(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case int, int32, int64, uint, uint64, float32, float64:
+		number, err := strconv.ParseFloat(fmt.Sprintf("%v", v), 64)
+		return number, err == nil
+	case bool:
+		if v {
+			return 1, true
+		}
+		return 0, true
+	case string, []byte:
+		number, err := strconv.ParseFloat(strings.TrimSpace(asOfText(v)), 64)
+		return number, err == nil
+	}
+	return 0, false
+}
+
+func asOfText(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case time.Time:
+		return v.Format(time.RFC3339)
+	}
+	return fmt.Sprintf("%v", historyValue(value))
+}
+
+// asOfIn tells if the value is one of the values of an in, any of or none of query
+func asOfIn(value interface{}, values interface{}) bool {
+	list, ok := values.([]interface{})
+	if !ok {
+		list = []interface{}{values}
+	}
+	for _, item := range list {
+		if compareAsOf(value, item) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// asOfLike matches the text with a like pattern, % matches any text and _ any one character. The
+// match ignores case like the default sqlite database does.
+func asOfLike(text string, pattern string) bool {
+	var expression strings.Builder
+	expression.WriteString("(?is)^")
+	for _, r := range pattern {
+		switch r {
+		case '%':
+			expression.WriteString(".*")
+		case '_':
+			expression.WriteString(".")
+		default:
+			expression.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	expression.WriteString("$")
+	matched, err := regexp.MatchString(expression.String(), text)
+	return err == nil && matched
+}
+
+// asOfPagination reads page[number] and page[size] of an as_of listing
+func asOfPagination(req api2go.Request, totalCount uint64) *PaginationData {
+	pagination := &PaginationData{PageNumber: 1, PageSize: 10, TotalCount: totalCount}
+	if values := req.QueryParams["page[number]"]; len(values) > 0 {
+		if pageNumber := toInt64(values[0]); pageNumber > 0 {
+			pagination.PageNumber = uint64(pageNumber)
+		}
+	}
+	if values := req.QueryParams["page[size]"]; len(values) > 0 {
+		if pageSize := toInt64(values[0]); pageSize > 0 {
+			pagination.PageSize = uint64(pageSize)
+		}
+	}
+	return pagination
+}
+
+// RevertToVersionWithTransaction updates the row back to the values it had at the version, as kept
+// in its audit rows. The update is checked and audited like any other update.
+func (dbResource *DbResource) RevertToVersionWithTransaction(referenceId daptinid.DaptinReferenceId, version int64,
+	req api2go.Request, transaction *sqlx.Tx) (api2go.Responder, error) {
+	tableName := dbResource.model.GetName()
+	if !dbResource.tableInfo.IsAuditEnabled {
+		return nil, api2go.NewHTTPError(fmt.Errorf("[%v] is not audited", tableName), "history is not kept for this table", http.StatusBadRequest)
+	}
+
+	auditRows, err := dbResource.auditRowsOf(referenceId, transaction)
+	if err != nil {
+		return nil, err
+	}
+	var snapshot map[string]interface{}
+	for _, auditRow := range auditRows {
+		if toInt64(auditRow["version"]) == version {
+			snapshot = auditRow
+		}
+	}
+	if snapshot == nil {
+		return nil, api2go.NewHTTPError(fmt.Errorf("[%v][%v] has no version %d", tableName, referenceId, version), "version not found", http.StatusNotFound)
+	}
+
+	current, err := dbResource.GetReferenceIdToObjectWithTransaction(tableName, referenceId, transaction)
+	if err != nil {
+		return nil, api2go.NewHTTPError(err, "object not found", http.StatusNotFound)
+	}
+
+	attributes := make(map[string]interface{})
+	for _, col := range dbResource.historyColumns() {
+		attributes[col.ColumnName] = snapshot[col.ColumnName]
+	}
+	model := api2go.NewApi2GoModelWithData(tableName, nil, 0, nil, current)
+	model.SetAttributes(attributes)
+
+	log.Infof("Revert [%v][%v] to version %d", tableName, referenceId, version)
+	return dbResource.UpdateWithTransaction(model, req, transaction)
+}
+
+// CheckHistoryActions adds a revert_to_version action to every audited table
+func CheckHistoryActions(config *CmsConfig) {
+	existingActions := make(map[string]bool)
+	for _, action := range config.Actions {
+		existingActions[action.OnType+"."+action.Name] = true
+	}
+
+	for _, table := range config.Tables {
+		if !table.IsAuditEnabled || existingActions[table.TableName+".revert_to_version"] {
+			continue
+		}
+		log.Printf("Add revert_to_version action for audited table [%v]", table.TableName)
+		config.Actions = append(config.Actions, actionresponse.Action{
+			Name:             "revert_to_version",
+			Label:            "Revert to an earlier version",
+			OnType:           table.TableName,
+			InstanceOptional: false,
+			InFields: []api2go.ColumnInfo{
+				{
+					Name:       "Version",
+					ColumnName: "version",
+					ColumnType: "measurement",
+				},
+			},
+			OutFields: []actionresponse.Outcome{
+				{
+					Type:   "row.revert",
+					Method: "EXECUTE",
+					Attributes: map[string]interface{}{
+						"table_name":   table.TableName,
+						"reference_id": "$.reference_id",
+						"version":      "~version",
+					},
+				},
+			},
+		})
+	}
+}
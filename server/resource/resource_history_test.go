@@ -0,0 +1,226 @@
+package resource
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/artpar/api2go/v2"
+	"github.com/daptin/daptin/server/auth"
+	daptinid "github.com/daptin/daptin/server/id"
+	"github.com/daptin/daptin/server/table_info"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestRowHistoryAndAsOf(t *testing.T) {
+	db, err := sqlx.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	noteRef := daptinid.DaptinReferenceId(uuid.New())
+	deletedRef := daptinid.DaptinReferenceId(uuid.New())
+	laterRef := daptinid.DaptinReferenceId(uuid.New())
+	editorRef := daptinid.DaptinReferenceId(uuid.New())
+
+	created := time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)
+	firstChange := created.Add(time.Hour)
+	secondChange := created.Add(2 * time.Hour)
+	deletion := created.Add(3 * time.Hour)
+	laterCreated := created.Add(4 * time.Hour)
+
+	for _, statement := range []struct {
+		query string
+		args  []interface{}
+	}{
+		{`create table note (id integer primary key, reference_id blob not null unique, title text, permission integer, version integer, created_at timestamp, updated_at timestamp)`, nil},
+		{`create table note_audit (id integer primary key, reference_id blob, title text, permission integer, version integer, created_at timestamp, source_reference_id text, operation text, audit_changed_by text, audit_request_id text, source_created_at timestamp)`, nil},
+		{`insert into note (id, reference_id, title, permission, version, created_at) values (1, ?, 'third', ?, 3, ?)`, []interface{}{noteRef[:], int64(auth.DEFAULT_PERMISSION), created}},
+		{`insert into note (id, reference_id, title, permission, version, created_at) values (2, ?, 'later', ?, 1, ?)`, []interface{}{laterRef[:], int64(auth.DEFAULT_PERMISSION | auth.GuestRead), laterCreated}},
+		{`insert into note_audit (id, title, version, created_at, source_reference_id, operation, audit_changed_by, audit_request_id, source_created_at) values (1, 'first', 1, ?, ?, 'update', ?, 'request-1', ?)`, []interface{}{firstChange, noteRef.String(), editorRef.String(), created}},
+		{`insert into note_audit (id, title, version, created_at, source_reference_id, operation, source_created_at) values (2, 'second', 2, ?, ?, 'update', ?)`, []interface{}{secondChange, noteRef.String(), created}},
+		{`insert into note_audit (id, title, version, created_at, source_reference_id, operation, source_created_at) values (3, 'gone', 1, ?, ?, 'delete', ?)`, []interface{}{deletion, deletedRef.String(), created}},
+	} {
+		if _, err := db.Exec(statement.query, statement.args...); err != nil {
+			t.Fatalf("setup statement failed: %v", err)
+		}
+	}
+
+	columns := []api2go.ColumnInfo{
+		{Name: "title", ColumnName: "title", ColumnType: "label", IsIndexed: true},
+		{Name: "permission", ColumnName: "permission"},
+		{Name: "reference_id", ColumnName: "reference_id"},
+		{Name: "version", ColumnName: "version"},
+		{Name: "created_at", ColumnName: "created_at", ColumnType: "datetime"},
+		{Name: "updated_at", ColumnName: "updated_at", ColumnType: "datetime"},
+	}
+	cruds := map[string]*DbResource{}
+	cruds["note"] = &DbResource{
+		model: api2go.NewApi2GoModel("note", columns, int64(auth.DEFAULT_PERMISSION), nil),
+		tableInfo: &table_info.TableInfo{
+			TableName:         "note",
+			Columns:           columns,
+			DefaultPermission: auth.DEFAULT_PERMISSION,
+			IsAuditEnabled:    true,
+		},
+		connection: db,
+		ms:         &MiddlewareSet{},
+		Cruds:      cruds,
+	}
+	note := cruds["note"]
+
+	tx := db.MustBegin()
+	defer tx.Rollback()
+
+	auditRows, err := note.auditRowsOf(noteRef, tx)
+	if err != nil {
+		t.Fatalf("audit rows: %v", err)
+	}
+	current, err := note.GetReferenceIdToObjectWithTransaction("note", noteRef, tx)
+	if err != nil {
+		t.Fatalf("current row: %v", err)
+	}
+	history := note.rowChanges(auditRows, current)
+	if len(history) != 2 {
+		t.Fatalf("expected 2 changes, got %+v", history)
+	}
+	first := history[0]
+	if first.Version != 1 || first.Operation != AuditOperationUpdate || first.ChangedBy != editorRef.String() || first.RequestId != "request-1" {
+		t.Errorf("unexpected first change %+v", first)
+	}
+	if len(first.Changes) != 1 || first.Changes[0] != (FieldChange{Field: "title", From: "first", To: "second"}) {
+		t.Errorf("expected title first -> second, got %+v", first.Changes)
+	}
+	if changes := history[1].Changes; len(changes) != 1 || changes[0] != (FieldChange{Field: "title", From: "second", To: "third"}) {
+		t.Errorf("expected title second -> third, got %+v", changes)
+	}
+
+	for _, c := range []struct {
+		at      time.Time
+		title   string
+		version int64
+		found   bool
+	}{
+		{created.Add(-time.Minute), "", 0, false},
+		{created.Add(time.Minute), "first", 1, true},
+		{firstChange.Add(time.Minute), "second", 2, true},
+		{secondChange.Add(time.Minute), "third", 3, true},
+	} {
+		state, err := note.rowAsOf(noteRef, current, c.at, tx)
+		if (err == nil) != c.found {
+			t.Errorf("as of %v: expected found %v, got %v", c.at, c.found, err)
+			continue
+		}
+		if c.found && (state["title"] != c.title || toInt64(state["version"]) != c.version) {
+			t.Errorf("as of %v: expected %v at version %d, got %v", c.at, c.title, c.version, state)
+		}
+	}
+
+	deletedState, err := note.rowAsOf(deletedRef, nil, secondChange, tx)
+	if err != nil || deletedState["title"] != "gone" || deletedState["reference_id"] != deletedRef {
+		t.Errorf("expected the deleted row as it was before its deletion, got %v %v", deletedState, err)
+	}
+	if _, err := note.rowAsOf(deletedRef, nil, deletion.Add(time.Minute), tx); err == nil {
+		t.Errorf("a row should not be found after its deletion")
+	}
+
+	adminGroupRef := daptinid.DaptinReferenceId(uuid.New())
+	oldUserAccountCrud := CRUD_MAP[USER_ACCOUNT_TABLE_NAME]
+	CRUD_MAP[USER_ACCOUNT_TABLE_NAME] = &DbResource{AdministratorGroupId: adminGroupRef}
+	defer func() {
+		if oldUserAccountCrud == nil {
+			delete(CRUD_MAP, USER_ACCOUNT_TABLE_NAME)
+			return
+		}
+		CRUD_MAP[USER_ACCOUNT_TABLE_NAME] = oldUserAccountCrud
+	}()
+	note.AdministratorGroupId = adminGroupRef
+	admin := &auth.SessionUser{
+		UserReferenceId: daptinid.DaptinReferenceId(uuid.New()),
+		Groups:          auth.GroupPermissionList{{GroupReferenceId: adminGroupRef}},
+	}
+	otherUser := &auth.SessionUser{UserReferenceId: daptinid.DaptinReferenceId(uuid.New())}
+
+	listRequest := func(asOf time.Time, user *auth.SessionUser, params map[string][]string) api2go.Request {
+		queryParams := map[string][]string{
+			AsOfQueryParameter: {asOf.Format(time.RFC3339)},
+			"page[size]":       {"10"},
+			"page[number]":     {"1"},
+		}
+		for key, values := range params {
+			queryParams[key] = values
+		}
+		ctx := context.WithValue(context.Background(), "user", user)
+		return api2go.Request{
+			PlainRequest: (&http.Request{Method: http.MethodGet, URL: &url.URL{Path: "/api/note"}}).WithContext(ctx),
+			QueryParams:  queryParams,
+		}
+	}
+	for _, c := range []struct {
+		at     time.Time
+		user   *auth.SessionUser
+		params map[string][]string
+		titles []string
+		total  uint64
+	}{
+		{firstChange.Add(time.Minute), admin, nil, []string{"second", "gone"}, 2},
+		{laterCreated.Add(time.Minute), admin, nil, []string{"later", "third"}, 2},
+		{laterCreated.Add(time.Minute), admin, map[string][]string{"sort": {"title"}}, []string{"later", "third"}, 2},
+		{laterCreated.Add(time.Minute), admin, map[string][]string{"sort": {"-title"}}, []string{"third", "later"}, 2},
+		{laterCreated.Add(time.Minute), admin, map[string][]string{"sort": {"title"}, "page[size]": {"1"}, "page[number]": {"2"}}, []string{"third"}, 2},
+		{firstChange.Add(time.Minute), admin, map[string][]string{"query": {`[{"column":"title","operator":"like","value":"%O%"}]`}}, []string{"second", "gone"}, 2},
+		{firstChange.Add(time.Minute), admin, map[string][]string{"query": {`[{"column":"title","operator":"eq","value":"gone"}]`}}, []string{"gone"}, 1},
+		{firstChange.Add(time.Minute), admin, map[string][]string{"query": {`[{"column":"title","operator":"is","value":"first"}]`}}, []string{}, 0},
+		{laterCreated.Add(time.Minute), admin, map[string][]string{"query": {`[{"column":"title","operator":"eq","value":"third","logical_group":"a"}`, `{"column":"title","operator":"eq","value":"later","logical_group":"a"}]`}}, []string{"later", "third"}, 2},
+		{laterCreated.Add(time.Minute), admin, map[string][]string{"query": {`[{"column":"version","operator":"more than","value":2}]`}}, []string{"third"}, 1},
+		{laterCreated.Add(time.Minute), admin, map[string][]string{"query": {`[{"column":"title","operator":"none of","value":["third"]}]`}}, []string{"later"}, 1},
+		{laterCreated.Add(time.Minute), admin, map[string][]string{"filter": {"HIR"}}, []string{"third"}, 1},
+		{laterCreated.Add(time.Minute), otherUser, nil, []string{"later"}, 1},
+		{firstChange.Add(time.Minute), otherUser, nil, []string{}, 0},
+	} {
+		results, includes, pagination, _, err := note.PaginatedFindAllWithoutFilters(listRequest(c.at, c.user, c.params), tx)
+		if err != nil {
+			t.Fatalf("find all as of %v with %v: %v", c.at, c.params, err)
+		}
+		if len(results) != len(c.titles) || len(includes) != len(results) || pagination.TotalCount != c.total {
+			t.Fatalf("as of %v with %v: expected %v of %d, got %v of %d", c.at, c.params, c.titles, c.total, results, pagination.TotalCount)
+		}
+		for i, title := range c.titles {
+			if results[i]["title"] != title {
+				t.Errorf("as of %v with %v: expected %v, got %v", c.at, c.params, c.titles, results)
+			}
+		}
+	}
+	for _, params := range []map[string][]string{
+		{"query": {`[{"column":"title","operator":"fuzzy","value":"thrid"}]`}},
+		{"query": {`[{"column":"missing","operator":"eq","value":"third"}]`}},
+		{"sort": {"missing"}},
+	} {
+		if _, _, _, _, err := note.PaginatedFindAllWithoutFilters(listRequest(laterCreated, admin, params), tx); err == nil {
+			t.Errorf("as of list with %v should be refused", params)
+		}
+	}
+
+	if _, err := tx.Exec(`insert into note_audit (title, version, created_at, source_reference_id, operation, source_created_at)
+		with recursive n(i) as (select 1 union all select i + 1 from n where i < ?)
+		select 'bulk', 1, ?, ?, 'update', ? from n`, AsOfRowLimit, laterCreated, noteRef.String(), created); err != nil {
+		t.Fatalf("add changes: %v", err)
+	}
+	if _, _, _, _, err := note.PaginatedFindAllWithoutFilters(listRequest(created.Add(time.Minute), admin, nil), tx); err == nil {
+		t.Errorf("an as of list with more than %d rows and changes to rebuild should be refused", AsOfRowLimit)
+	}
+	if _, err := note.rowAsOf(noteRef, current, created.Add(time.Minute), tx); err != nil {
+		t.Errorf("single rows should still be read as of the time: %v", err)
+	}
+
+	note.tableInfo.IsAuditEnabled = false
+	if _, _, err := note.requestAsOf(listRequest(created, admin, nil)); err == nil {
+		t.Errorf("as_of on a table without audit should be refused")
+	}
+}
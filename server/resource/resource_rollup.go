@@ -0,0 +1,762 @@
+package resource
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	fieldtypes "github.com/daptin/daptin/server/columntypes"
+	daptinid "github.com/daptin/daptin/server/id"
+	"github.com/daptin/daptin/server/statementbuilder"
+	"github.com/doug-martin/goqu/v9"
+	"github.com/doug-martin/goqu/v9/exp"
+	"github.com/jmoiron/sqlx"
+	log "github.com/sirupsen/logrus"
+)
+
+// RollupTablePrefix is prepended to the name of a rollup to name the table its rows are materialized into
+const RollupTablePrefix = "rollup_"
+
+var rollupNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_]{0,62}$`)
+
+// rollupSettleDelay holds back a captured change so the transaction which made it has committed
+// before the group is recomputed
+var rollupSettleDelay = 5 * time.Second
+
+// rollupRefreshLease is how long a server holds a rollup while refreshing it, other servers skip
+// the rollup until the refresh is done or the lease runs out
+var rollupRefreshLease = 10 * time.Minute
+
+// rollupGroupExpression is a group by expression which can be matched against a single group key
+type rollupGroupExpression interface {
+	exp.Comparable
+	exp.Isable
+}
+
+type rollupChange struct {
+	key []interface{}
+	at  time.Time
+}
+
+// rollupState is what the process knows about a rollup definition. Rollups are rebuilt once
+// after the process starts since the definition may have changed while it was not running.
+// Rebuilds made by other servers are picked up from last_refreshed_at.
+type rollupState struct {
+	id              int64
+	name            string
+	definition      string
+	definitionError error
+	request         AggregationRequest
+	interval        time.Duration
+	incremental     bool
+
+	materialized bool
+	columns      []string
+	refreshedAt  time.Time
+	appliedAt    time.Time
+	lastError    string
+
+	// rootGroupBy is nil when changed rows cannot be mapped to the groups they belong to,
+	// those changes rebuild the whole rollup
+	rootGroupBy []interface{}
+	pending     map[string]rollupChange
+	rebuildAt   time.Time
+
+	// pendingCount is only set on snapshots
+	pendingCount int
+}
+
+var rollups = struct {
+	sync.Mutex
+	byName map[string]*rollupState
+}{byName: make(map[string]*rollupState)}
+
+// snapshot copies the state for use outside the registry lock
+func (r *rollupState) snapshot() rollupState {
+	copied := *r
+	copied.pending = nil
+	copied.pendingCount = len(r.pending)
+	if !r.rebuildAt.IsZero() {
+		copied.pendingCount += 1
+	}
+	return copied
+}
+
+func (r rollupState) tableName() string {
+	return RollupTablePrefix + r.name
+}
+
+func (r rollupState) stale() bool {
+	return !r.materialized || r.pendingCount > 0 || r.lastError != "" ||
+		(r.interval > 0 && time.Since(r.refreshedAt) > r.interval)
+}
+
+func (r rollupState) meta() map[string]interface{} {
+	meta := map[string]interface{}{
+		"rollup":          r.name,
+		"refreshed_at":    r.refreshedAt,
+		"pending_changes": r.pendingCount,
+		"stale":           r.stale(),
+	}
+	if !r.appliedAt.IsZero() {
+		meta["changes_applied_at"] = r.appliedAt
+	}
+	if r.lastError != "" {
+		meta["refresh_error"] = r.lastError
+	}
+	return meta
+}
+
+// rollupForRequest looks up the rollup named in the request, it must aggregate the requested entity
+func (dbResource *DbResource) rollupForRequest(req AggregationRequest) (rollupState, error) {
+	rollups.Lock()
+	state, ok := rollups.byName[req.Rollup]
+	var rollup rollupState
+	if ok {
+		rollup = state.snapshot()
+	}
+	rollups.Unlock()
+
+	if !ok || rollup.definitionError != nil {
+		return rollup, invalidAggregation("rollup", "unknown rollup %q", req.Rollup)
+	}
+	if req.RootEntity != "" && req.RootEntity != rollup.request.RootEntity {
+		return rollup, invalidAggregation("rollup", "rollup %q does not aggregate %q", req.Rollup, req.RootEntity)
+	}
+	return rollup, nil
+}
+
+// rollupStats reads the materialized rows of a rollup. Filter and order apply to the columns of
+// the materialized table, the rest of the request comes from the rollup definition.
+func (dbResource *DbResource) rollupStats(req AggregationRequest, transaction *sqlx.Tx) (*AggregateData, error) {
+	rollup, err := dbResource.rollupForRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	if len(req.GroupBy) > 0 || len(req.ProjectColumn) > 0 || len(req.Join) > 0 || len(req.Having) > 0 || req.Pivot != "" {
+		return nil, invalidAggregation("rollup", "group, column, join, having and pivot come from the definition of rollup %q", rollup.name)
+	}
+	if !rollup.materialized {
+		return nil, invalidAggregation("rollup", "rollup %q has not been materialized yet", rollup.name)
+	}
+	if rootResource, ok := dbResource.Cruds[rollup.request.RootEntity]; ok && rootResource.tableInfo.TenantScoped {
+		return nil, invalidAggregation("rollup", "rollup %q is materialized across tenants, %q is tenant scoped", rollup.name, rollup.request.RootEntity)
+	}
+
+	query, err := dbResource.buildAggregateQuery(rollup.request, transaction)
+	if err != nil {
+		return nil, err
+	}
+
+	columns := make(map[string]bool)
+	for _, column := range rollup.columns {
+		columns[column] = true
+	}
+
+	builder := statementbuilder.Squirrel.Select(goqu.Star()).Prepared(true).From(rollup.tableName())
+	for _, rawFilter := range req.Filter {
+		condition, err := parseAggregateCondition(rawFilter)
+		if err != nil {
+			return nil, invalidAggregation("filter", "%v", err)
+		}
+		if !columns[condition.Left] {
+			return nil, invalidAggregation("filter", "unknown rollup column %q", condition.Left)
+		}
+		predicate, err := buildAggregatePredicate(goqu.C(condition.Left), condition)
+		if err != nil {
+			return nil, invalidAggregation("filter", "%v", err)
+		}
+		builder = builder.Where(predicate)
+	}
+	for _, rawOrder := range req.Order {
+		rawOrder = strings.TrimSpace(rawOrder)
+		descending := strings.HasPrefix(rawOrder, "-")
+		column := strings.TrimSpace(strings.TrimPrefix(rawOrder, "-"))
+		if !columns[column] {
+			return nil, invalidAggregation("order", "unknown rollup column %q", column)
+		}
+		if descending {
+			builder = builder.OrderAppend(goqu.C(column).Desc())
+		} else {
+			builder = builder.OrderAppend(goqu.C(column).Asc())
+		}
+	}
+
+	sqlQuery, args, err := builder.ToSQL()
+	if err != nil {
+		return nil, err
+	}
+	queryResult, err := transaction.Queryx(sqlQuery, args...)
+	if err != nil {
+		CheckErr(err, "Failed to query rollup: %v", sqlQuery)
+		return nil, err
+	}
+	rows, err := RowsToMap(queryResult, "aggregate_"+rollup.request.RootEntity)
+	if closeErr := queryResult.Close(); closeErr != nil {
+		log.Errorf("failed to close rollup query result - %v", closeErr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := dbResource.aggregateData(rollup.request.RootEntity, query, rows, transaction)
+	if err != nil {
+		return nil, err
+	}
+	data.Meta = rollup.meta()
+	return data, nil
+}
+
+// rollupRootGroupBy parses the group by expressions of a rollup against its root table. Rollups
+// with joins or without groups are rebuilt on every change instead.
+func (dbResource *DbResource) rollupRootGroupBy(req AggregationRequest) []interface{} {
+	if len(req.Join) > 0 || len(req.GroupBy) == 0 {
+		return nil
+	}
+	groupBy := make([]interface{}, 0, len(req.GroupBy))
+	for _, group := range req.GroupBy {
+		expr, err := dbResource.parseAggExpr(group, []string{req.RootEntity}, false)
+		if err != nil {
+			return nil
+		}
+		if _, ok := expr.(rollupGroupExpression); !ok {
+			return nil
+		}
+		groupBy = append(groupBy, expr)
+	}
+	return groupBy
+}
+
+// loadRollups syncs the registry with the rollup table and returns the names of rollups which
+// were removed
+func loadRollups(rollupCrud *DbResource) ([]string, error) {
+	transaction, err := rollupCrud.Connection().Beginx()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := rollupCrud.GetAllRawObjectsWithTransaction("rollup", transaction)
+	rollbackErr := transaction.Rollback()
+	CheckErr(rollbackErr, "Failed to rollback")
+	if err != nil {
+		return nil, err
+	}
+
+	rollups.Lock()
+	defer rollups.Unlock()
+
+	seen := make(map[string]bool)
+	for _, row := range rows {
+		name := stringColumnValue(row["name"])
+		definition := stringColumnValue(row["definition"])
+		seen[name] = true
+
+		state, ok := rollups.byName[name]
+		if !ok || state.definition != definition {
+			state = &rollupState{
+				name:       name,
+				definition: definition,
+				pending:    make(map[string]rollupChange),
+			}
+			if !rollupNamePattern.MatchString(name) {
+				state.definitionError = fmt.Errorf("rollup name %q must be lower case letters, digits and underscores", name)
+			} else if err := json.Unmarshal([]byte(definition), &state.request); err != nil {
+				state.definitionError = fmt.Errorf("invalid rollup definition: %v", err)
+			} else if _, ok := rollupCrud.Cruds[state.request.RootEntity]; !ok {
+				state.definitionError = fmt.Errorf("unknown root entity %q", state.request.RootEntity)
+			} else {
+				state.request.Rollup = ""
+				state.rootGroupBy = rollupCrud.rollupRootGroupBy(state.request)
+			}
+			rollups.byName[name] = state
+		}
+
+		state.id = toInt64(row["id"])
+		state.incremental = row["incremental"] == nil || ValueToBool(row["incremental"])
+		if refreshedAt := rollupRowTime(row["last_refreshed_at"]); state.materialized && refreshedAt.After(state.refreshedAt) {
+			// rebuilt by another server, which covered the changes settled before it started
+			settled := refreshedAt.Add(-rollupSettleDelay)
+			state.refreshedAt = refreshedAt
+			if !state.rebuildAt.After(settled) {
+				state.rebuildAt = time.Time{}
+			}
+			for key, change := range state.pending {
+				if !change.at.After(settled) {
+					delete(state.pending, key)
+				}
+			}
+		}
+		state.interval = 0
+		if interval := stringColumnValue(row["refresh_interval"]); interval != "" {
+			duration, err := time.ParseDuration(interval)
+			if err != nil && state.definitionError == nil {
+				state.definitionError = fmt.Errorf("invalid refresh interval %q: %v", interval, err)
+			}
+			state.interval = duration
+		}
+	}
+
+	removed := make([]string, 0)
+	for name := range rollups.byName {
+		if !seen[name] {
+			removed = append(removed, name)
+			delete(rollups.byName, name)
+		}
+	}
+	return removed, nil
+}
+
+// trackRollupChanges records the groups the rows belong to, so rollups over the table
+// recompute them on the next refresh. Rows are read in the transaction which changes them,
+// before an update or delete for the groups they leave and after a create or update for the
+// groups they join.
+func trackRollupChanges(dbResource *DbResource, rows []map[string]interface{}, transaction *sqlx.Tx) {
+	if dbResource.tableInfo == nil || len(rows) == 0 {
+		return
+	}
+	tableName := dbResource.tableInfo.TableName
+
+	type capture struct {
+		name    string
+		groupBy []interface{}
+	}
+	captures := make([]capture, 0)
+	now := time.Now()
+	rollups.Lock()
+	for _, state := range rollups.byName {
+		if !state.incremental || !state.materialized || state.definitionError != nil {
+			continue
+		}
+		if state.request.RootEntity == tableName {
+			if state.rootGroupBy == nil {
+				state.rebuildAt = now
+			} else {
+				captures = append(captures, capture{name: state.name, groupBy: state.rootGroupBy})
+			}
+			continue
+		}
+		for _, join := range state.request.Join {
+			if strings.SplitN(join, "@", 2)[0] == tableName {
+				state.rebuildAt = now
+			}
+		}
+	}
+	rollups.Unlock()
+
+	for _, captured := range captures {
+		for _, row := range rows {
+			referenceId := daptinid.InterfaceToDIR(row["reference_id"])
+			if referenceId == daptinid.NullReferenceId {
+				continue
+			}
+			query, args, err := statementbuilder.Squirrel.Select(captured.groupBy...).Prepared(true).
+				From(tableName).Where(goqu.Ex{"reference_id": referenceId[:]}).ToSQL()
+			if err != nil {
+				CheckErr(err, "Failed to create group query for rollup [%v]", captured.name)
+				continue
+			}
+			key, err := transaction.QueryRowx(query, args...).SliceScan()
+			if err == sql.ErrNoRows {
+				continue
+			}
+			if err != nil {
+				CheckErr(err, "Failed to read group of [%v] for rollup [%v]", referenceId, captured.name)
+				markRollupRebuild(captured.name)
+				continue
+			}
+			for i, value := range key {
+				if asBytes, ok := value.([]byte); ok {
+					key[i] = string(asBytes)
+				}
+			}
+			markRollupChange(captured.name, key)
+		}
+	}
+}
+
+func markRollupChange(name string, key []interface{}) {
+	keyString, err := json.MarshalToString(key)
+	if err != nil {
+		markRollupRebuild(name)
+		return
+	}
+	rollups.Lock()
+	defer rollups.Unlock()
+	if state, ok := rollups.byName[name]; ok {
+		state.pending[keyString] = rollupChange{key: key, at: time.Now()}
+	}
+}
+
+func markRollupRebuild(name string) {
+	rollups.Lock()
+	defer rollups.Unlock()
+	if state, ok := rollups.byName[name]; ok {
+		state.rebuildAt = time.Now()
+	}
+}
+
+// RefreshRollups rebuilds rollups which are new, changed, due on their schedule or marked for a
+// rebuild, and recomputes the groups touched by settled changes of the others
+func RefreshRollups(cruds map[string]*DbResource) {
+	rollupCrud, ok := cruds["rollup"]
+	if !ok {
+		return
+	}
+
+	removed, err := loadRollups(rollupCrud)
+	if err != nil {
+		CheckErr(err, "Failed to load rollups")
+		return
+	}
+	for _, name := range removed {
+		err := execRollupStatement(rollupCrud, "DROP TABLE IF EXISTS "+RollupTablePrefix+name)
+		CheckErr(err, "Failed to drop table of removed rollup [%v]", name)
+	}
+
+	rollups.Lock()
+	names := make([]string, 0, len(rollups.byName))
+	for name := range rollups.byName {
+		names = append(names, name)
+	}
+	rollups.Unlock()
+	sort.Strings(names)
+
+	for _, name := range names {
+		refreshRollup(rollupCrud, name)
+	}
+}
+
+func refreshRollup(rollupCrud *DbResource, name string) {
+	now := time.Now()
+	settled := now.Add(-rollupSettleDelay)
+
+	rollups.Lock()
+	state, ok := rollups.byName[name]
+	if !ok {
+		rollups.Unlock()
+		return
+	}
+	rollup := state.snapshot()
+	full := !state.materialized ||
+		(!state.rebuildAt.IsZero() && !state.rebuildAt.After(settled)) ||
+		(state.interval > 0 && now.Sub(state.refreshedAt) >= state.interval)
+	changes := make([]rollupChange, 0)
+	if !full {
+		for _, change := range state.pending {
+			if !change.at.After(settled) {
+				changes = append(changes, change)
+			}
+		}
+	}
+	rollups.Unlock()
+
+	if rollup.definitionError != nil {
+		if rollup.definitionError.Error() != rollup.lastError {
+			CheckErr(rollup.definitionError, "Rollup [%v] cannot be refreshed", name)
+			recordRollupFailure(rollupCrud, rollup, rollup.definitionError)
+		}
+		return
+	}
+	if !full && len(changes) == 0 {
+		return
+	}
+
+	claimed, err := claimRollupRefresh(rollupCrud, rollup, now)
+	if err != nil {
+		CheckErr(err, "Failed to claim refresh of rollup [%v]", name)
+		return
+	}
+	if !claimed {
+		// another server is refreshing it, the changes stay pending for the next run
+		return
+	}
+
+	transaction, err := rollupCrud.Connection().Beginx()
+	if err != nil {
+		CheckErr(err, "Failed to begin transaction to refresh rollup [%v]", name)
+		releaseRollupRefresh(rollupCrud, rollup)
+		return
+	}
+	columns := rollup.columns
+	if full {
+		columns, err = rollupCrud.materializeRollup(rollup, transaction)
+	} else {
+		err = rollupCrud.applyRollupChanges(rollup, changes, transaction)
+	}
+	if err == nil {
+		err = updateRollupRow(rollup, full, now, transaction)
+	}
+	if err != nil {
+		rollbackErr := transaction.Rollback()
+		CheckErr(rollbackErr, "Failed to rollback")
+		if err.Error() != rollup.lastError {
+			CheckErr(err, "Failed to refresh rollup [%v]", name)
+			recordRollupFailure(rollupCrud, rollup, err)
+		}
+		if !full {
+			markRollupRebuild(name)
+		}
+		return
+	}
+	if err := transaction.Commit(); err != nil {
+		CheckErr(err, "Failed to commit refresh of rollup [%v]", name)
+		releaseRollupRefresh(rollupCrud, rollup)
+		return
+	}
+	MarkTableChanged(context.Background(), rollup.tableName())
+	MarkTableChanged(context.Background(), "rollup")
+
+	rollups.Lock()
+	defer rollups.Unlock()
+	if state != rollups.byName[name] {
+		return
+	}
+	state.lastError = ""
+	state.appliedAt = now
+	if full {
+		state.materialized = true
+		state.columns = columns
+		state.refreshedAt = now
+		if !state.rebuildAt.After(settled) {
+			state.rebuildAt = time.Time{}
+		}
+		for key, change := range state.pending {
+			if !change.at.After(settled) {
+				delete(state.pending, key)
+			}
+		}
+		return
+	}
+	for _, change := range changes {
+		keyString, _ := json.MarshalToString(change.key)
+		if pending, ok := state.pending[keyString]; ok && pending.at.Equal(change.at) {
+			delete(state.pending, keyString)
+		}
+	}
+}
+
+// materializeRollup replaces the table of the rollup with the result of its aggregation and
+// returns the columns of the new table. The rows are built into a table of their own which is
+// then renamed, readers see the previous rows until the new ones are complete.
+func (dbResource *DbResource) materializeRollup(rollup rollupState, transaction *sqlx.Tx) ([]string, error) {
+	query, err := dbResource.buildAggregateQuery(rollup.request, transaction)
+	if err != nil {
+		return nil, err
+	}
+	if query.computedInRows() {
+		return nil, fmt.Errorf("rollup [%v] uses window functions, pivot or percentiles this database cannot compute, which are not materialized", rollup.name)
+	}
+	// CREATE TABLE .. AS does not take bind parameters on every database
+	selectQuery, _, err := query.builder.Prepared(false).ToSQL()
+	if err != nil {
+		return nil, err
+	}
+
+	tableName := rollup.tableName()
+	nextTableName := tableName + "_next"
+	if _, err := transaction.Exec("DROP TABLE IF EXISTS " + nextTableName); err != nil {
+		return nil, err
+	}
+	if _, err := transaction.Exec("CREATE TABLE " + nextTableName + " AS " + selectQuery); err != nil {
+		return nil, err
+	}
+	if err := swapRollupTable(transaction, tableName, nextTableName); err != nil {
+		return nil, err
+	}
+	log.Infof("Materialized rollup [%v] into [%v]", rollup.name, rollup.tableName())
+
+	rows, err := transaction.Queryx("SELECT * FROM " + rollup.tableName() + " WHERE 1 = 0")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return rows.Columns()
+}
+
+// applyRollupChanges deletes the materialized rows of the changed groups and aggregates them again
+func (dbResource *DbResource) applyRollupChanges(rollup rollupState, changes []rollupChange, transaction *sqlx.Tx) error {
+	query, err := dbResource.buildAggregateQuery(rollup.request, transaction)
+	if err != nil {
+		return err
+	}
+	if len(query.groupBy) != len(rollup.rootGroupBy) || len(rollup.columns) < query.projectionCount+len(query.groupBy) {
+		return fmt.Errorf("materialized table of rollup [%v] does not match its definition", rollup.name)
+	}
+	groupColumns := rollup.columns[query.projectionCount : query.projectionCount+len(query.groupBy)]
+
+	for _, change := range changes {
+		if len(change.key) != len(groupColumns) {
+			return fmt.Errorf("group key %v does not match rollup [%v]", change.key, rollup.name)
+		}
+		deleteWhere := make([]exp.Expression, 0, len(change.key))
+		selectWhere := make([]exp.Expression, 0, len(change.key))
+		for i, value := range change.key {
+			groupExpression := query.groupBy[i].(rollupGroupExpression)
+			if value == nil {
+				deleteWhere = append(deleteWhere, goqu.C(groupColumns[i]).IsNull())
+				selectWhere = append(selectWhere, groupExpression.IsNull())
+			} else {
+				deleteWhere = append(deleteWhere, goqu.C(groupColumns[i]).Eq(value))
+				selectWhere = append(selectWhere, groupExpression.Eq(value))
+			}
+		}
+
+		deleteQuery, args, err := statementbuilder.Squirrel.Delete(rollup.tableName()).Prepared(true).
+			Where(deleteWhere...).ToSQL()
+		if err != nil {
+			return err
+		}
+		if _, err := transaction.Exec(deleteQuery, args...); err != nil {
+			return err
+		}
+
+		selectQuery, args, err := query.builder.Where(selectWhere...).ToSQL()
+		if err != nil {
+			return err
+		}
+		if _, err := transaction.Exec("INSERT INTO "+rollup.tableName()+" "+selectQuery, args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func updateRollupRow(rollup rollupState, full bool, refreshedAt time.Time, transaction *sqlx.Tx) error {
+	var rowCount int64
+	if err := transaction.QueryRowx("SELECT COUNT(*) FROM " + rollup.tableName()).Scan(&rowCount); err != nil {
+		return err
+	}
+	record := goqu.Record{
+		"row_count":           rowCount,
+		"last_refresh_error":  nil,
+		"refresh_lease_until": nil,
+	}
+	if full {
+		record["last_refreshed_at"] = refreshedAt
+	}
+	query, args, err := statementbuilder.Squirrel.Update("rollup").Prepared(true).
+		Set(record).Where(goqu.Ex{"id": rollup.id}).ToSQL()
+	if err != nil {
+		return err
+	}
+	_, err = transaction.Exec(query, args...)
+	return err
+}
+
+func recordRollupFailure(rollupCrud *DbResource, rollup rollupState, failure error) {
+	rollups.Lock()
+	if state, ok := rollups.byName[rollup.name]; ok {
+		state.lastError = failure.Error()
+	}
+	rollups.Unlock()
+
+	query, args, err := statementbuilder.Squirrel.Update("rollup").Prepared(true).
+		Set(goqu.Record{"last_refresh_error": failure.Error(), "refresh_lease_until": nil}).Where(goqu.Ex{"id": rollup.id}).ToSQL()
+	if err != nil {
+		CheckErr(err, "Failed to create query to record rollup error")
+		return
+	}
+	err = execRollupStatement(rollupCrud, query, args...)
+	CheckErr(err, "Failed to record error of rollup [%v]", rollup.name)
+	MarkTableChanged(context.Background(), "rollup")
+}
+
+func execRollupStatement(rollupCrud *DbResource, query string, args ...interface{}) error {
+	transaction, err := rollupCrud.Connection().Beginx()
+	if err != nil {
+		return err
+	}
+	if _, err := transaction.Exec(query, args...); err != nil {
+		rollbackErr := transaction.Rollback()
+		CheckErr(rollbackErr, "Failed to rollback")
+		return err
+	}
+	return transaction.Commit()
+}
+
+// swapRollupTable replaces tableName with nextTableName. MySQL commits every schema change on its
+// own, both tables are renamed in one statement there so readers never miss the table.
+func swapRollupTable(transaction *sqlx.Tx, tableName string, nextTableName string) error {
+	previousTableName := tableName + "_previous"
+	if _, err := transaction.Exec("DROP TABLE IF EXISTS " + previousTableName); err != nil {
+		return err
+	}
+	if transaction.DriverName() == "mysql" {
+		if _, err := transaction.Exec("CREATE TABLE IF NOT EXISTS " + tableName + " LIKE " + nextTableName); err != nil {
+			return err
+		}
+		if _, err := transaction.Exec("RENAME TABLE " + tableName + " TO " + previousTableName + ", " + nextTableName + " TO " + tableName); err != nil {
+			return err
+		}
+	} else {
+		if _, err := transaction.Exec("DROP TABLE IF EXISTS " + tableName); err != nil {
+			return err
+		}
+		if _, err := transaction.Exec("ALTER TABLE " + nextTableName + " RENAME TO " + tableName); err != nil {
+			return err
+		}
+	}
+	_, err := transaction.Exec("DROP TABLE IF EXISTS " + previousTableName)
+	return err
+}
+
+// claimRollupRefresh takes the lease on a rollup row, it is false when another server holds it
+func claimRollupRefresh(rollupCrud *DbResource, rollup rollupState, now time.Time) (bool, error) {
+	now = now.UTC()
+	query, args, err := statementbuilder.Squirrel.Update("rollup").Prepared(true).
+		Set(goqu.Record{"refresh_lease_until": now.Add(rollupRefreshLease)}).
+		Where(goqu.Ex{"id": rollup.id}, goqu.Or(
+			goqu.C("refresh_lease_until").IsNull(),
+			goqu.C("refresh_lease_until").Lt(now),
+		)).ToSQL()
+	if err != nil {
+		return false, err
+	}
+
+	transaction, err := rollupCrud.Connection().Beginx()
+	if err != nil {
+		return false, err
+	}
+	result, err := transaction.Exec(query, args...)
+	if err != nil {
+		rollbackErr := transaction.Rollback()
+		CheckErr(rollbackErr, "Failed to rollback")
+		return false, err
+	}
+	if err = transaction.Commit(); err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	return affected == 1, err
+}
+
+// releaseRollupRefresh gives up the lease after a refresh which was not recorded on the rollup row
+func releaseRollupRefresh(rollupCrud *DbResource, rollup rollupState) {
+	query, args, err := statementbuilder.Squirrel.Update("rollup").Prepared(true).
+		Set(goqu.Record{"refresh_lease_until": nil}).Where(goqu.Ex{"id": rollup.id}).ToSQL()
+	if err != nil {
+		CheckErr(err, "Failed to create query to release rollup [%v]", rollup.name)
+		return
+	}
+	err = execRollupStatement(rollupCrud, query, args...)
+	CheckErr(err, "Failed to release rollup [%v]", rollup.name)
+}
+
+// rollupRowTime reads a timestamp column of the rollup row, sqlite returns them as text
+func rollupRowTime(value interface{}) time.Time {
+	switch v := value.(type) {
+	case time.Time:
+		return v
+	case string, []byte:
+		parsed, _, err := fieldtypes.GetDateTime(stringColumnValue(v))
+		if err != nil {
+			return time.Time{}
+		}
+		return parsed
+	}
+	return time.Time{}
+}
@@ -0,0 +1,452 @@
+package resource
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/artpar/api2go/v2"
+	"github.com/daptin/daptin/server/actionresponse"
+	daptinid "github.com/daptin/daptin/server/id"
+	"github.com/daptin/daptin/server/statementbuilder"
+	"github.com/daptin/daptin/server/table_info"
+	"github.com/doug-martin/goqu/v9"
+	"github.com/jmoiron/sqlx"
+	log "github.com/sirupsen/logrus"
+)
+
+// rollupColumnFunctions are the aggregates a rollup column can hold
+var rollupColumnFunctions = map[string]bool{
+	"count":  true,
+	"sum":    true,
+	"min":    true,
+	"max":    true,
+	"latest": true,
+}
+
+// aliases of the related table and the join table in the query computing a rollup column, so a table
+// related to itself can be rolled up
+const (
+	rollupChildAlias = "rollup_child"
+	rollupJoinAlias  = "rollup_join"
+)
+
+// rollupPendingTimeout drops the rows captured before a write which never reached its after
+// interceptors, the request failed and its transaction was rolled back
+var rollupPendingTimeout = 10 * time.Minute
+
+// rollupRelation is how the rows related to a row of the table with the rollup column are found, by a
+// column of the child table for a belongs_to or has_one, through a join table for a has_many
+type rollupRelation struct {
+	child            string
+	childColumn      string
+	joinTable        string
+	joinParentColumn string
+	joinChildColumn  string
+}
+
+// findRollupRelation looks up the relation of table named name, the name of the relation as seen from
+// table and then the name of the related table
+func findRollupRelation(table *table_info.TableInfo, name string) (rollupRelation, bool) {
+	for _, byTable := range []bool{false, true} {
+		matches := func(relationName, tableName string) bool {
+			if byTable {
+				return tableName == name
+			}
+			return relationName == name
+		}
+		for _, rel := range table.Relations {
+			switch rel.GetRelation() {
+			case "belongs_to", "has_one":
+				if rel.GetObject() == table.TableName && matches(rel.GetSubjectName(), rel.GetSubject()) {
+					return rollupRelation{
+						child:       rel.GetSubject(),
+						childColumn: rel.GetObjectName(),
+					}, true
+				}
+			case "has_many", "has_many_and_belongs_to_many":
+				if rel.GetSubject() == table.TableName && matches(rel.GetObjectName(), rel.GetObject()) {
+					return rollupRelation{
+						child:            rel.GetObject(),
+						joinTable:        rel.GetJoinTableName(),
+						joinParentColumn: rel.GetSubjectName(),
+						joinChildColumn:  rel.GetObjectName(),
+					}, true
+				}
+				if rel.GetObject() == table.TableName && matches(rel.GetSubjectName(), rel.GetSubject()) {
+					return rollupRelation{
+						child:            rel.GetSubject(),
+						joinTable:        rel.GetJoinTableName(),
+						joinParentColumn: rel.GetObjectName(),
+						joinChildColumn:  rel.GetSubjectName(),
+					}, true
+				}
+			}
+		}
+	}
+	return rollupRelation{}, false
+}
+
+// CheckRollupColumns validates the rollup columns of every table against its relations, adds a column to
+// the table for each of them and a recompute_rollups action to rebuild them. A rollup column over an
+// unknown relation or column, or with an unknown function, is dropped with an error.
+func CheckRollupColumns(config *CmsConfig) {
+	existingActions := make(map[string]bool)
+	for _, action := range config.Actions {
+		existingActions[action.OnType+"."+action.Name] = true
+	}
+
+	for i := range config.Tables {
+		table := &config.Tables[i]
+		if len(table.RollupColumns) == 0 {
+			continue
+		}
+
+		rollupColumns := make([]table_info.RollupColumn, 0, len(table.RollupColumns))
+		for _, rollup := range table.RollupColumns {
+			rollup.Name = strings.TrimSpace(rollup.Name)
+			rollup.Function = strings.ToLower(strings.TrimSpace(rollup.Function))
+			if rollup.Name == "" || IsStandardColumn(rollup.Name) {
+				log.Errorf("Rollup column [%v] of table [%v] needs a name which is not a standard column", rollup.Name, table.TableName)
+				continue
+			}
+			if !rollupColumnFunctions[rollup.Function] {
+				log.Errorf("Rollup column [%v] of table [%v] has an unknown function [%v]", rollup.Name, table.TableName, rollup.Function)
+				continue
+			}
+			relation, ok := findRollupRelation(table, rollup.Relation)
+			if !ok {
+				log.Errorf("Rollup column [%v] of table [%v] is over an unknown relation [%v]", rollup.Name, table.TableName, rollup.Relation)
+				continue
+			}
+
+			if rollup.Function == "count" {
+				rollup.Column = ""
+				if rollup.ColumnType == "" {
+					rollup.ColumnType = "measurement"
+				}
+				if rollup.DataType == "" {
+					rollup.DataType = "int(11)"
+				}
+			} else {
+				var childColumn *api2go.ColumnInfo
+				for _, childTable := range config.Tables {
+					if childTable.TableName == relation.child {
+						childColumn, ok = childTable.GetColumnByName(rollup.Column)
+						break
+					}
+				}
+				if childColumn == nil || !ok {
+					log.Errorf("Rollup column [%v] of table [%v] is over an unknown column [%v] of [%v]", rollup.Name, table.TableName, rollup.Column, relation.child)
+					continue
+				}
+				rollup.Column = childColumn.ColumnName
+				if rollup.ColumnType == "" {
+					rollup.ColumnType = childColumn.ColumnType
+				}
+				if rollup.DataType == "" {
+					rollup.DataType = childColumn.DataType
+				}
+			}
+
+			if _, exists := table.GetColumnByName(rollup.Name); !exists {
+				// a column from an earlier start, kept in the world schema, is not added again
+				table.Columns = append(table.Columns, rollup.ColumnInfo())
+			}
+			rollupColumns = append(rollupColumns, rollup)
+		}
+		table.RollupColumns = rollupColumns
+
+		if len(rollupColumns) == 0 || existingActions[table.TableName+".recompute_rollups"] {
+			continue
+		}
+		log.Printf("Add recompute_rollups action for table [%v]", table.TableName)
+		config.Actions = append(config.Actions, actionresponse.Action{
+			Name:             "recompute_rollups",
+			Label:            "Recompute rollup columns of " + table.TableName,
+			OnType:           table.TableName,
+			InstanceOptional: true,
+			Permission:       &adminOnlyActionPermission,
+			AccessGroups:     adminOnlyActionAccessGroups,
+			InFields: []api2go.ColumnInfo{
+				{
+					Name:              "Reference Id",
+					ColumnName:        "reference_id",
+					ColumnType:        "label",
+					IsNullable:        true,
+					ColumnDescription: "Recompute a single row, every row of the table unless set.",
+				},
+			},
+			OutFields: []actionresponse.Outcome{
+				{
+					Type:   "rollup_columns.recompute",
+					Method: "EXECUTE",
+					Attributes: map[string]interface{}{
+						"table_name":   table.TableName,
+						"reference_id": "~reference_id",
+					},
+				},
+			},
+		})
+	}
+}
+
+// RecomputeRollupColumns sets the rollup columns of the rows of the table with the given ids from their
+// related rows, of every row of the table when ids is nil
+func (dbResource *DbResource) RecomputeRollupColumns(ids []int64, transaction *sqlx.Tx) error {
+	if dbResource.tableInfo == nil || len(dbResource.tableInfo.RollupColumns) == 0 || (ids != nil && len(ids) == 0) {
+		return nil
+	}
+	tableName := dbResource.tableInfo.TableName
+
+	values := goqu.Record{}
+	for _, rollup := range dbResource.tableInfo.RollupColumns {
+		relation, ok := findRollupRelation(dbResource.tableInfo, rollup.Relation)
+		if !ok {
+			continue
+		}
+		childSoftDelete := false
+		if child, ok := dbResource.Cruds[relation.child]; ok && child.tableInfo != nil {
+			childSoftDelete = child.tableInfo.SoftDelete
+		}
+		values[rollup.Name] = rollupColumnQuery(tableName, rollup, relation, childSoftDelete)
+	}
+	if len(values) == 0 {
+		return nil
+	}
+
+	update := statementbuilder.Squirrel.Update(tableName).Prepared(true).Set(values)
+	if ids != nil {
+		update = update.Where(goqu.Ex{"id": ids})
+	}
+	query, args, err := update.ToSQL()
+	if err != nil {
+		return err
+	}
+	_, err = transaction.Exec(query, args...)
+	if err != nil {
+		return fmt.Errorf("failed to recompute rollup columns of [%v]: %v", tableName, err)
+	}
+	return nil
+}
+
+// rollupColumnQuery is the subquery computing a rollup column for the row of the table being updated
+func rollupColumnQuery(tableName string, rollup table_info.RollupColumn, relation rollupRelation, childSoftDelete bool) *goqu.SelectDataset {
+	parentId := goqu.I(tableName + ".id")
+	query := statementbuilder.Squirrel.From(goqu.T(relation.child).As(rollupChildAlias))
+	if relation.joinTable == "" {
+		query = query.Where(goqu.I(rollupChildAlias + "." + relation.childColumn).Eq(parentId))
+	} else {
+		query = query.Join(goqu.T(relation.joinTable).As(rollupJoinAlias),
+			goqu.On(goqu.I(rollupJoinAlias+"."+relation.joinChildColumn).Eq(goqu.I(rollupChildAlias+".id")))).
+			Where(goqu.I(rollupJoinAlias + "." + relation.joinParentColumn).Eq(parentId))
+	}
+	if childSoftDelete {
+		query = query.Where(goqu.I(rollupChildAlias + "." + SoftDeleteColumnName).IsNull())
+	}
+
+	column := goqu.I(rollupChildAlias + "." + rollup.Column)
+	switch rollup.Function {
+	case "count":
+		return query.Select(goqu.COUNT(goqu.I(rollupChildAlias + ".id")))
+	case "sum":
+		return query.Select(goqu.COALESCE(goqu.SUM(column), 0))
+	case "min":
+		return query.Select(goqu.MIN(column))
+	case "max":
+		return query.Select(goqu.MAX(column))
+	default:
+		return query.Select(column).
+			Order(goqu.I(rollupChildAlias+".created_at").Desc(), goqu.I(rollupChildAlias+".id").Desc()).
+			Limit(1)
+	}
+}
+
+// rollupParentIds returns the ids of the rows related to the row of the child table with referenceId,
+// whose rollup columns change with it
+func rollupParentIds(childTable string, relation rollupRelation, referenceId daptinid.DaptinReferenceId, transaction *sqlx.Tx) ([]int64, error) {
+	var query *goqu.SelectDataset
+	if relation.joinTable == "" {
+		query = statementbuilder.Squirrel.From(childTable).Select(goqu.C(relation.childColumn)).
+			Where(goqu.Ex{"reference_id": referenceId[:]}, goqu.C(relation.childColumn).IsNotNull())
+	} else {
+		childId := statementbuilder.Squirrel.From(childTable).Select("id").Where(goqu.Ex{"reference_id": referenceId[:]})
+		query = statementbuilder.Squirrel.From(relation.joinTable).Select(goqu.C(relation.joinParentColumn)).
+			Where(goqu.C(relation.joinChildColumn).In(childId))
+	}
+
+	sqlQuery, args, err := query.Prepared(true).ToSQL()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := transaction.Queryx(sqlQuery, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := make([]int64, 0)
+	for rows.Next() {
+		var id int64
+		if err = rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// rollupColumnMiddleware keeps the rollup columns up to date when rows of the tables they are over are
+// written. The rows a write moves away from are captured before it and recomputed after it, along with
+// the rows it moves into, in the transaction of the write.
+type rollupColumnMiddleware struct {
+	pending sync.Map
+}
+
+// pendingRollupRows are the rows captured before a write, by table and id
+type pendingRollupRows struct {
+	capturedAt time.Time
+	rows       map[string]map[int64]bool
+}
+
+func NewRollupColumnMiddleware() DatabaseRequestInterceptor {
+	return &rollupColumnMiddleware{}
+}
+
+func (m *rollupColumnMiddleware) String() string {
+	return "rollupcolumns"
+}
+
+func (m *rollupColumnMiddleware) InterceptBefore(dr *DbResource, req *api2go.Request, rows []map[string]interface{}, transaction *sqlx.Tx) ([]map[string]interface{}, error) {
+	if req.PlainRequest == nil || transaction == nil {
+		return rows, nil
+	}
+	switch strings.ToUpper(req.PlainRequest.Method) {
+	case "PATCH", "DELETE":
+		captured := rollupRowsOf(dr, rows, transaction, false)
+		if len(captured) == 0 {
+			return rows, nil
+		}
+		now := time.Now()
+		m.pending.Range(func(key, value interface{}) bool {
+			if now.Sub(value.(*pendingRollupRows).capturedAt) > rollupPendingTimeout {
+				m.pending.Delete(key)
+			}
+			return true
+		})
+		existing, loaded := m.pending.LoadOrStore(transaction, &pendingRollupRows{capturedAt: now, rows: captured})
+		if loaded {
+			mergeRollupRows(existing.(*pendingRollupRows).rows, captured)
+		}
+	}
+	return rows, nil
+}
+
+func (m *rollupColumnMiddleware) InterceptAfter(dr *DbResource, req *api2go.Request, rows []map[string]interface{}, transaction *sqlx.Tx) ([]map[string]interface{}, error) {
+	if req.PlainRequest == nil || transaction == nil {
+		return rows, nil
+	}
+	method := strings.ToUpper(req.PlainRequest.Method)
+	if method != "POST" && method != "PATCH" && method != "DELETE" {
+		return rows, nil
+	}
+
+	changed := make(map[string]map[int64]bool)
+	if captured, ok := m.pending.LoadAndDelete(transaction); ok {
+		mergeRollupRows(changed, captured.(*pendingRollupRows).rows)
+	}
+	if method != "DELETE" {
+		mergeRollupRows(changed, rollupRowsOf(dr, rows, transaction, true))
+	}
+
+	for tableName, idSet := range changed {
+		table, ok := dr.Cruds[tableName]
+		if !ok {
+			continue
+		}
+		ids := make([]int64, 0, len(idSet))
+		for id := range idSet {
+			ids = append(ids, id)
+		}
+		if err := table.RecomputeRollupColumns(ids, transaction); err != nil {
+			return nil, err
+		}
+		MarkTableChanged(requestContext(*req), tableName)
+	}
+	return rows, nil
+}
+
+// rollupRowsOf returns the rows, by table and id, with a rollup column over the table of dr which are
+// related to rows, and when self is set the rows themselves if their table has rollup columns
+func rollupRowsOf(dr *DbResource, rows []map[string]interface{}, transaction *sqlx.Tx, self bool) map[string]map[int64]bool {
+	result := make(map[string]map[int64]bool)
+	if dr.tableInfo == nil {
+		return result
+	}
+	tableName := dr.tableInfo.TableName
+
+	referenceIds := make([]daptinid.DaptinReferenceId, 0, len(rows))
+	for _, row := range rows {
+		referenceId := daptinid.InterfaceToDIR(row["reference_id"])
+		if referenceId != daptinid.NullReferenceId {
+			referenceIds = append(referenceIds, referenceId)
+		}
+	}
+	if len(referenceIds) == 0 {
+		return result
+	}
+
+	add := func(table string, id int64) {
+		if result[table] == nil {
+			result[table] = make(map[int64]bool)
+		}
+		result[table][id] = true
+	}
+
+	for _, parent := range dr.Cruds {
+		if parent.tableInfo == nil || len(parent.tableInfo.RollupColumns) == 0 {
+			continue
+		}
+		seen := make(map[rollupRelation]bool)
+		for _, rollup := range parent.tableInfo.RollupColumns {
+			relation, ok := findRollupRelation(parent.tableInfo, rollup.Relation)
+			if !ok || relation.child != tableName || seen[relation] {
+				continue
+			}
+			seen[relation] = true
+			for _, referenceId := range referenceIds {
+				ids, err := rollupParentIds(tableName, relation, referenceId, transaction)
+				if err != nil {
+					CheckErr(err, "Failed to read the rows of [%v] related to [%v]", parent.tableInfo.TableName, referenceId)
+					continue
+				}
+				for _, id := range ids {
+					add(parent.tableInfo.TableName, id)
+				}
+			}
+		}
+	}
+
+	if self && len(dr.tableInfo.RollupColumns) > 0 {
+		for _, referenceId := range referenceIds {
+			id, err := GetReferenceIdToIdWithTransaction(tableName, referenceId, transaction)
+			if err != nil {
+				continue
+			}
+			add(tableName, id)
+		}
+	}
+	return result
+}
+
+func mergeRollupRows(into map[string]map[int64]bool, rows map[string]map[int64]bool) {
+	for table, ids := range rows {
+		if into[table] == nil {
+			into[table] = make(map[int64]bool)
+		}
+		for id := range ids {
+			into[table][id] = true
+		}
+	}
+}
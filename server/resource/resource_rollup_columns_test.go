@@ -0,0 +1,205 @@
+package resource
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/artpar/api2go/v2"
+	daptinid "github.com/daptin/daptin/server/id"
+	"github.com/daptin/daptin/server/table_info"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func rollupTestConfig() *CmsConfig {
+	commentOfPost := api2go.NewTableRelation("comment", "belongs_to", "post")
+	postTags := api2go.NewTableRelation("post", "has_many", "tag")
+	return &CmsConfig{
+		Tables: []table_info.TableInfo{
+			{
+				TableName: "post",
+				Columns: []api2go.ColumnInfo{
+					{Name: "title", ColumnName: "title", ColumnType: "label", DataType: "varchar(100)", IsNullable: true},
+				},
+				Relations: []api2go.TableRelation{commentOfPost, postTags},
+				RollupColumns: []table_info.RollupColumn{
+					{Name: "comment_count", Relation: "comment_id", Function: "count"},
+					{Name: "total_votes", Relation: "comment", Function: "SUM", Column: "votes"},
+					{Name: "latest_comment", Relation: "comment", Function: "latest", Column: "body"},
+					{Name: "tag_count", Relation: "tag_id", Function: "count"},
+					{Name: "median_votes", Relation: "comment", Function: "median", Column: "votes"},
+					{Name: "author_count", Relation: "author", Function: "count"},
+					{Name: "max_likes", Relation: "comment", Function: "max", Column: "likes"},
+				},
+			},
+			{
+				TableName: "comment",
+				Columns: []api2go.ColumnInfo{
+					{Name: "body", ColumnName: "body", ColumnType: "label", DataType: "varchar(100)", IsNullable: true},
+					{Name: "votes", ColumnName: "votes", ColumnType: "measurement", DataType: "int(11)", IsNullable: true},
+				},
+				Relations:  []api2go.TableRelation{commentOfPost},
+				SoftDelete: true,
+			},
+			{
+				TableName: "tag",
+				Relations: []api2go.TableRelation{postTags},
+			},
+		},
+	}
+}
+
+func TestCheckRollupColumns(t *testing.T) {
+	config := rollupTestConfig()
+	CheckRollupColumns(config)
+	post := config.Tables[0]
+
+	if len(post.RollupColumns) != 4 {
+		t.Fatalf("expected the four valid rollup columns to be kept, got %+v", post.RollupColumns)
+	}
+	for _, name := range []string{"comment_count", "total_votes", "latest_comment", "tag_count"} {
+		if _, ok := post.GetColumnByName(name); !ok {
+			t.Errorf("expected a column for rollup [%v]", name)
+		}
+	}
+	totalVotes, _ := post.GetRollupColumn("total_votes")
+	if totalVotes.Function != "sum" || totalVotes.DataType != "int(11)" || totalVotes.ColumnType != "measurement" {
+		t.Errorf("expected the function to be lowered and the types taken from votes, got %+v", totalVotes)
+	}
+	commentCount, _ := post.GetRollupColumn("comment_count")
+	if commentCount.DataType != "int(11)" {
+		t.Errorf("expected an integer count, got %+v", commentCount)
+	}
+	if len(config.Actions) != 1 || config.Actions[0].Name != "recompute_rollups" || config.Actions[0].OnType != "post" {
+		t.Errorf("expected a recompute_rollups action on post, got %+v", config.Actions)
+	}
+
+	// a second check, with the columns read back from the world schema, adds nothing
+	CheckRollupColumns(config)
+	if len(config.Tables[0].Columns) != len(post.Columns) || len(config.Actions) != 1 {
+		t.Errorf("columns or actions added again: %v %v", config.Tables[0].Columns, config.Actions)
+	}
+}
+
+func TestRollupColumnsRecompute(t *testing.T) {
+	config := rollupTestConfig()
+	CheckRollupColumns(config)
+
+	db, err := sqlx.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	statements := []string{
+		"create table post (id integer primary key, reference_id blob, title varchar(100), comment_count int, total_votes int, latest_comment varchar(100), tag_count int)",
+		"create table comment (id integer primary key, reference_id blob, body varchar(100), votes int, post_id int, created_at timestamp, deleted_at timestamp)",
+		"create table tag (id integer primary key, reference_id blob)",
+		"create table post_post_id_has_tag_tag_id (id integer primary key, post_id int, tag_id int)",
+		"insert into post (id, title) values (1, 'first'), (2, 'second')",
+		"insert into tag (id) values (1), (2)",
+		"insert into post_post_id_has_tag_tag_id (post_id, tag_id) values (1, 1), (1, 2), (2, 2)",
+	}
+	for _, statement := range statements {
+		if _, err = db.Exec(statement); err != nil {
+			t.Fatalf("%v: %v", statement, err)
+		}
+	}
+	commentReferenceIds := make([]daptinid.DaptinReferenceId, 0)
+	for i, comment := range []struct {
+		body      string
+		votes     int
+		postId    int
+		createdAt string
+	}{
+		{"early", 3, 1, "2024-01-01"},
+		{"late", 4, 1, "2024-02-01"},
+		{"other", 7, 2, "2024-01-15"},
+	} {
+		referenceId := daptinid.DaptinReferenceId(uuid.New())
+		commentReferenceIds = append(commentReferenceIds, referenceId)
+		_, err = db.Exec("insert into comment (id, reference_id, body, votes, post_id, created_at) values (?, ?, ?, ?, ?, ?)",
+			i+1, referenceId[:], comment.body, comment.votes, comment.postId, comment.createdAt)
+		if err != nil {
+			t.Fatalf("insert comment: %v", err)
+		}
+	}
+
+	cruds := make(map[string]*DbResource)
+	for i := range config.Tables {
+		table := config.Tables[i]
+		cruds[table.TableName] = &DbResource{
+			tableInfo: &table,
+			Cruds:     cruds,
+		}
+	}
+
+	type postRollups struct {
+		CommentCount  int    `db:"comment_count"`
+		TotalVotes    int    `db:"total_votes"`
+		LatestComment string `db:"latest_comment"`
+		TagCount      int    `db:"tag_count"`
+	}
+	readPost := func(tx *sqlx.Tx, id int) postRollups {
+		var rollups postRollups
+		err := tx.Get(&rollups, "select comment_count, total_votes, coalesce(latest_comment, '') as latest_comment, tag_count from post where id = ?", id)
+		if err != nil {
+			t.Fatalf("read post: %v", err)
+		}
+		return rollups
+	}
+
+	tx, err := db.Beginx()
+	if err != nil {
+		t.Fatalf("begin: %v", err)
+	}
+	defer tx.Rollback()
+
+	if err = cruds["post"].RecomputeRollupColumns(nil, tx); err != nil {
+		t.Fatalf("recompute: %v", err)
+	}
+	if got := readPost(tx, 1); got != (postRollups{2, 7, "late", 2}) {
+		t.Errorf("unexpected rollups of the first post: %+v", got)
+	}
+	if got := readPost(tx, 2); got != (postRollups{1, 7, "other", 1}) {
+		t.Errorf("unexpected rollups of the second post: %+v", got)
+	}
+
+	// a comment moved to the second post leaves the first one and joins the second one
+	middleware := NewRollupColumnMiddleware()
+	moved := []map[string]interface{}{{"reference_id": commentReferenceIds[1]}}
+	patch := &api2go.Request{PlainRequest: httptest.NewRequest("PATCH", "/api/comment", nil)}
+	if _, err = middleware.InterceptBefore(cruds["comment"], patch, moved, tx); err != nil {
+		t.Fatalf("before update: %v", err)
+	}
+	if _, err = tx.Exec("update comment set post_id = 2 where id = 2"); err != nil {
+		t.Fatalf("update comment: %v", err)
+	}
+	if _, err = middleware.InterceptAfter(cruds["comment"], patch, moved, tx); err != nil {
+		t.Fatalf("after update: %v", err)
+	}
+	if got := readPost(tx, 1); got != (postRollups{1, 3, "early", 2}) {
+		t.Errorf("unexpected rollups of the first post after the move: %+v", got)
+	}
+	if got := readPost(tx, 2); got != (postRollups{2, 11, "late", 1}) {
+		t.Errorf("unexpected rollups of the second post after the move: %+v", got)
+	}
+
+	// a soft deleted comment is not rolled up
+	deleted := []map[string]interface{}{{"reference_id": commentReferenceIds[2]}}
+	deleteRequest := &api2go.Request{PlainRequest: httptest.NewRequest("DELETE", "/api/comment", nil)}
+	if _, err = middleware.InterceptBefore(cruds["comment"], deleteRequest, deleted, tx); err != nil {
+		t.Fatalf("before delete: %v", err)
+	}
+	if _, err = tx.Exec("update comment set deleted_at = '2024-03-01' where id = 3"); err != nil {
+		t.Fatalf("delete comment: %v", err)
+	}
+	if _, err = middleware.InterceptAfter(cruds["comment"], deleteRequest, deleted, tx); err != nil {
+		t.Fatalf("after delete: %v", err)
+	}
+	if got := readPost(tx, 2); got != (postRollups{1, 4, "late", 1}) {
+		t.Errorf("unexpected rollups of the second post after the delete: %+v", got)
+	}
+}
@@ -0,0 +1,204 @@
+package resource
+
+import (
+	"testing"
+	"time"
+
+	"github.com/artpar/api2go/v2"
+	"github.com/daptin/daptin/server/auth"
+	daptinid "github.com/daptin/daptin/server/id"
+	"github.com/daptin/daptin/server/table_info"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestRollupFullAndIncrementalRefresh(t *testing.T) {
+	db, err := sqlx.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	settleDelay := rollupSettleDelay
+	rollupSettleDelay = 0
+	defer func() { rollupSettleDelay = settleDelay }()
+
+	for _, statement := range []string{
+		`create table orders (
+			id integer primary key,
+			reference_id blob not null unique,
+			status text,
+			total integer,
+			permission integer,
+			version integer
+		)`,
+		`create table rollup (
+			id integer primary key,
+			reference_id blob not null unique,
+			name text,
+			definition text,
+			refresh_interval text,
+			incremental integer,
+			last_refreshed_at timestamp,
+			last_refresh_error text,
+			row_count integer,
+			refresh_lease_until timestamp,
+			permission integer,
+			version integer
+		)`,
+	} {
+		if _, err := db.Exec(statement); err != nil {
+			t.Fatalf("setup statement failed: %v", err)
+		}
+	}
+
+	orderRefs := make([]daptinid.DaptinReferenceId, 0)
+	insertOrder := func(tx *sqlx.Tx, status string, total int) daptinid.DaptinReferenceId {
+		ref := daptinid.DaptinReferenceId(uuid.New())
+		if _, err := tx.Exec(`insert into orders (reference_id, status, total, permission, version) values (?, ?, ?, ?, 1)`,
+			ref[:], status, total, int64(auth.DEFAULT_PERMISSION)); err != nil {
+			t.Fatalf("insert order: %v", err)
+		}
+		orderRefs = append(orderRefs, ref)
+		return ref
+	}
+	tx := db.MustBegin()
+	insertOrder(tx, "open", 10)
+	insertOrder(tx, "open", 5)
+	insertOrder(tx, "closed", 7)
+	if _, err := tx.Exec(`insert into rollup (reference_id, name, definition, incremental, row_count) values (?, 'orders_by_status', ?, 1, 0)`,
+		uuid.New().String(), `{"root_entity": "orders", "group": ["status"], "column": ["sum(total) as total", "count"]}`); err != nil {
+		t.Fatalf("insert rollup: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+
+	cruds := map[string]*DbResource{}
+	newCrud := func(tableName string, columns []api2go.ColumnInfo) *DbResource {
+		columns = append(columns,
+			api2go.ColumnInfo{Name: "permission", ColumnName: "permission"},
+			api2go.ColumnInfo{Name: "reference_id", ColumnName: "reference_id"},
+			api2go.ColumnInfo{Name: "version", ColumnName: "version"},
+		)
+		return &DbResource{
+			model: api2go.NewApi2GoModel(tableName, columns, int64(auth.DEFAULT_PERMISSION), nil),
+			tableInfo: &table_info.TableInfo{
+				TableName:         tableName,
+				Columns:           columns,
+				DefaultPermission: auth.DEFAULT_PERMISSION,
+			},
+			connection: db,
+			ms:         &MiddlewareSet{},
+			Cruds:      cruds,
+		}
+	}
+	cruds["orders"] = newCrud("orders", []api2go.ColumnInfo{
+		{Name: "status", ColumnName: "status"},
+		{Name: "total", ColumnName: "total"},
+	})
+	cruds["rollup"] = newCrud("rollup", []api2go.ColumnInfo{
+		{Name: "name", ColumnName: "name"},
+		{Name: "definition", ColumnName: "definition"},
+		{Name: "refresh_interval", ColumnName: "refresh_interval"},
+		{Name: "incremental", ColumnName: "incremental"},
+	})
+	defer func() {
+		rollups.Lock()
+		delete(rollups.byName, "orders_by_status")
+		rollups.Unlock()
+	}()
+
+	read := func() (map[string][2]int64, map[string]interface{}) {
+		tx := db.MustBegin()
+		defer tx.Rollback()
+		data, err := cruds["orders"].DataStats(AggregationRequest{
+			RootEntity: "orders",
+			Rollup:     "orders_by_status",
+			Order:      []string{"status"},
+		}, tx)
+		if err != nil {
+			t.Fatalf("read rollup: %v", err)
+		}
+		groups := make(map[string][2]int64)
+		for _, row := range data.Data {
+			groups[stringColumnValue(row.Attributes["status"])] = [2]int64{toInt64(row.Attributes["total"]), toInt64(row.Attributes["count"])}
+		}
+		return groups, data.Meta
+	}
+
+	RefreshRollups(cruds)
+	groups, meta := read()
+	if len(groups) != 2 || groups["open"] != [2]int64{15, 2} || groups["closed"] != [2]int64{7, 1} {
+		t.Fatalf("unexpected rollup after full refresh %v", groups)
+	}
+	if meta["stale"] != false || meta["rollup"] != "orders_by_status" {
+		t.Fatalf("unexpected rollup meta %v", meta)
+	}
+	var rowCount int64
+	var refreshedAt interface{}
+	if err := db.QueryRow("select row_count, last_refreshed_at from rollup").Scan(&rowCount, &refreshedAt); err != nil || rowCount != 2 || refreshedAt == nil {
+		t.Fatalf("rollup row not updated: %v %v %v", rowCount, refreshedAt, err)
+	}
+
+	// a new closed order and an open order which is closed
+	tx = db.MustBegin()
+	created := insertOrder(tx, "closed", 3)
+	trackRollupChanges(cruds["orders"], []map[string]interface{}{{"reference_id": created}}, tx)
+	moved := []map[string]interface{}{{"reference_id": orderRefs[0]}}
+	trackRollupChanges(cruds["orders"], moved, tx)
+	if _, err := tx.Exec("update orders set status = 'closed' where reference_id = ?", orderRefs[0][:]); err != nil {
+		t.Fatalf("update order: %v", err)
+	}
+	trackRollupChanges(cruds["orders"], moved, tx)
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+
+	groups, meta = read()
+	if meta["stale"] != true || meta["pending_changes"] != 2 || groups["open"] != [2]int64{15, 2} {
+		t.Fatalf("changes should be pending before the refresh, got %v %v", groups, meta)
+	}
+
+	RefreshRollups(cruds)
+	groups, meta = read()
+	if groups["open"] != [2]int64{5, 1} || groups["closed"] != [2]int64{20, 3} {
+		t.Fatalf("unexpected rollup after incremental refresh %v", groups)
+	}
+	if meta["stale"] != false || meta["pending_changes"] != 0 || meta["changes_applied_at"] == nil {
+		t.Fatalf("unexpected rollup meta after incremental refresh %v", meta)
+	}
+
+	// a rollup held by another server is left alone, its changes stay pending
+	rollup, _ := cruds["orders"].rollupForRequest(AggregationRequest{Rollup: "orders_by_status"})
+	if claimed, err := claimRollupRefresh(cruds["rollup"], rollup, time.Now()); err != nil || !claimed {
+		t.Fatalf("expected the released rollup to be claimed: %v", err)
+	}
+	if claimed, err := claimRollupRefresh(cruds["rollup"], rollup, time.Now()); err != nil || claimed {
+		t.Fatalf("a held rollup should not be claimed again: %v", err)
+	}
+	tx = db.MustBegin()
+	created = insertOrder(tx, "open", 1)
+	trackRollupChanges(cruds["orders"], []map[string]interface{}{{"reference_id": created}}, tx)
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+	RefreshRollups(cruds)
+	if groups, meta = read(); groups["open"] != [2]int64{5, 1} || meta["pending_changes"] != 1 {
+		t.Fatalf("a held rollup should not be refreshed, got %v %v", groups, meta)
+	}
+	releaseRollupRefresh(cruds["rollup"], rollup)
+	RefreshRollups(cruds)
+	if groups, meta = read(); groups["open"] != [2]int64{6, 2} || meta["pending_changes"] != 0 {
+		t.Fatalf("unexpected rollup after the lease was released %v %v", groups, meta)
+	}
+
+	tx = db.MustBegin()
+	_, err = cruds["orders"].DataStats(AggregationRequest{RootEntity: "orders", Rollup: "orders_by_status", GroupBy: []string{"total"}}, tx)
+	tx.Rollback()
+	if err == nil {
+		t.Fatalf("group by should not be accepted on a rollup")
+	}
+}
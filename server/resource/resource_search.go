@@ -0,0 +1,554 @@
+package resource
+
+import (
+	"database/sql"
+	"fmt"
+	"html"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/daptin/daptin/server/database"
+	"github.com/daptin/daptin/server/table_info"
+	"github.com/doug-martin/goqu/v9"
+	"github.com/doug-martin/goqu/v9/exp"
+	"github.com/jmoiron/sqlx"
+	log "github.com/sirupsen/logrus"
+)
+
+// SearchVectorColumn is the generated tsvector column of a searchable table on postgres
+const SearchVectorColumn = "daptin_search_vector"
+
+// the fulltext index of a searchable table on mysql
+const mysqlSearchIndexName = "daptin_search"
+
+const (
+	// maxSearchTerms is how many words of the search parameter are used
+	maxSearchTerms = 16
+	// maxFacetValues is how many values of a facet column are counted, the most frequent first
+	maxFacetValues = 100
+	// searchSnippetRunes is about how long a highlighted snippet is
+	searchSnippetRunes = 160
+)
+
+// searchIndexReady has the tables whose full text index was created, searches on other tables fall
+// back to a like match on the searchable columns
+var searchIndexReady sync.Map
+
+// FacetCount is the number of rows with a value of a facet column
+type FacetCount struct {
+	Value interface{} `json:"value"`
+	Count int64       `json:"count"`
+}
+
+// searchFtsTable is the fts5 table which indexes the searchable columns of the table on sqlite
+func searchFtsTable(tableName string) string {
+	return tableName + "_fts"
+}
+
+// SearchableColumns are the Searchable columns of the table which can be indexed, text columns only
+func SearchableColumns(table *table_info.TableInfo) []string {
+	columns := make([]string, 0, len(table.Searchable))
+	for _, name := range table.Searchable {
+		column, ok := table.GetColumnByName(name)
+		if !ok {
+			log.Warnf("Table [%v] searchable column [%v] does not exist", table.TableName, name)
+			continue
+		}
+		dataType := strings.ToLower(column.DataType)
+		if strings.Index(dataType, "char") == -1 && strings.Index(dataType, "text") == -1 {
+			log.Warnf("Table [%v] searchable column [%v] is not a text column [%v]", table.TableName, name, column.DataType)
+			continue
+		}
+		columns = append(columns, column.ColumnName)
+	}
+	return columns
+}
+
+// CreateSearchIndexes creates the full text index of every table with Searchable columns: a fts5
+// table kept in sync by triggers on sqlite, a generated tsvector column with a gin index on postgres
+// and a fulltext index on mysql. An index whose columns changed is built again, and the index of a
+// table which is no longer searchable is dropped
+func CreateSearchIndexes(initConfig *CmsConfig, db database.DatabaseConnection) {
+	for i := range initConfig.Tables {
+		table := &initConfig.Tables[i]
+		err := ensureSearchIndex(db, table.TableName, SearchableColumns(table))
+		if err != nil {
+			log.Warnf("Full text index of [%v] not created, search falls back to like matches: %v", table.TableName, err)
+		}
+	}
+}
+
+func ensureSearchIndex(db database.DatabaseConnection, tableName string, columns []string) error {
+	searchIndexReady.Delete(tableName)
+	driverName := db.DriverName()
+
+	existing, err := existingSearchColumns(db, driverName, tableName)
+	if err != nil {
+		return err
+	}
+	if strings.Join(existing, ",") == strings.Join(columns, ",") {
+		if len(columns) > 0 {
+			searchIndexReady.Store(tableName, true)
+		}
+		return nil
+	}
+
+	if len(existing) > 0 {
+		log.Infof("Drop full text index of [%v] on %v", tableName, existing)
+		for _, statement := range dropSearchIndexStatements(driverName, tableName) {
+			if _, err = db.Exec(statement); err != nil {
+				return err
+			}
+		}
+	}
+	if len(columns) == 0 {
+		return nil
+	}
+
+	log.Infof("Create full text index of [%v] on %v", tableName, columns)
+	statements, err := createSearchIndexStatements(driverName, tableName, columns)
+	if err != nil {
+		return err
+	}
+	transaction, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	for _, statement := range statements {
+		if _, err = transaction.Exec(statement); err != nil {
+			rollbackErr := transaction.Rollback()
+			CheckErr(rollbackErr, "Failed to rollback")
+			return err
+		}
+	}
+	if err = transaction.Commit(); err != nil {
+		return err
+	}
+	searchIndexReady.Store(tableName, true)
+	return nil
+}
+
+// existingSearchColumns reads the columns the full text index of the table was built on
+func existingSearchColumns(db database.DatabaseConnection, driverName string, tableName string) ([]string, error) {
+	columns := make([]string, 0)
+	switch driverName {
+	case "sqlite3", "sqlite":
+		var count int
+		err := db.QueryRow("SELECT count(*) FROM sqlite_master WHERE type = 'table' AND name = ?", searchFtsTable(tableName)).Scan(&count)
+		if err != nil || count == 0 {
+			return columns, err
+		}
+		rows, err := db.Queryx(fmt.Sprintf("PRAGMA table_info(%s)", searchFtsTable(tableName)))
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			row := make(map[string]interface{})
+			if err = rows.MapScan(row); err != nil {
+				return nil, err
+			}
+			columns = append(columns, fmt.Sprintf("%s", row["name"]))
+		}
+		return columns, rows.Err()
+
+	case "postgres":
+		// the columns are kept in the comment of the generated column
+		var comment sql.NullString
+		err := db.QueryRow(`SELECT col_description(c.oid, a.attnum) FROM pg_class c
+			JOIN pg_attribute a ON a.attrelid = c.oid
+			WHERE c.relname = $1 AND a.attname = $2 AND NOT a.attisdropped`, tableName, SearchVectorColumn).Scan(&comment)
+		if err == sql.ErrNoRows {
+			return columns, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if comment.String == "" {
+			// an index whose columns are not known is built again
+			return []string{SearchVectorColumn}, nil
+		}
+		return strings.Split(comment.String, ","), nil
+
+	case "mysql":
+		err := db.Select(&columns, `SELECT COLUMN_NAME FROM INFORMATION_SCHEMA.STATISTICS
+			WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND INDEX_NAME = ? ORDER BY SEQ_IN_INDEX`, tableName, mysqlSearchIndexName)
+		return columns, err
+	}
+	return columns, nil
+}
+
+func dropSearchIndexStatements(driverName string, tableName string) []string {
+	switch driverName {
+	case "sqlite3", "sqlite":
+		ftsTable := searchFtsTable(tableName)
+		return []string{
+			fmt.Sprintf("DROP TRIGGER IF EXISTS %s_ai", ftsTable),
+			fmt.Sprintf("DROP TRIGGER IF EXISTS %s_ad", ftsTable),
+			fmt.Sprintf("DROP TRIGGER IF EXISTS %s_au", ftsTable),
+			fmt.Sprintf("DROP TABLE IF EXISTS %s", ftsTable),
+		}
+	case "postgres":
+		return []string{fmt.Sprintf("ALTER TABLE %s DROP COLUMN IF EXISTS %s", tableName, SearchVectorColumn)}
+	case "mysql":
+		return []string{fmt.Sprintf("ALTER TABLE %s DROP INDEX %s", tableName, mysqlSearchIndexName)}
+	}
+	return nil
+}
+
+func createSearchIndexStatements(driverName string, tableName string, columns []string) ([]string, error) {
+	switch driverName {
+	case "sqlite3", "sqlite":
+		ftsTable := searchFtsTable(tableName)
+		columnList := strings.Join(columns, ", ")
+		newValues := "new." + strings.Join(columns, ", new.")
+		oldValues := "old." + strings.Join(columns, ", old.")
+		insertNew := fmt.Sprintf("INSERT INTO %s(rowid, %s) VALUES (new.id, %s);", ftsTable, columnList, newValues)
+		deleteOld := fmt.Sprintf("INSERT INTO %s(%s, rowid, %s) VALUES ('delete', old.id, %s);", ftsTable, ftsTable, columnList, oldValues)
+		return []string{
+			fmt.Sprintf("CREATE VIRTUAL TABLE %s USING fts5(%s, content='%s', content_rowid='id')", ftsTable, columnList, tableName),
+			fmt.Sprintf("CREATE TRIGGER %s_ai AFTER INSERT ON %s BEGIN %s END", ftsTable, tableName, insertNew),
+			fmt.Sprintf("CREATE TRIGGER %s_ad AFTER DELETE ON %s BEGIN %s END", ftsTable, tableName, deleteOld),
+			fmt.Sprintf("CREATE TRIGGER %s_au AFTER UPDATE ON %s BEGIN %s %s END", ftsTable, tableName, deleteOld, insertNew),
+			fmt.Sprintf("INSERT INTO %s(%s) VALUES ('rebuild')", ftsTable, ftsTable),
+		}, nil
+
+	case "postgres":
+		values := make([]string, 0, len(columns))
+		for _, column := range columns {
+			values = append(values, fmt.Sprintf("coalesce(%s, '')", column))
+		}
+		return []string{
+			fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s tsvector GENERATED ALWAYS AS (to_tsvector('simple', %s)) STORED",
+				tableName, SearchVectorColumn, strings.Join(values, " || ' ' || ")),
+			fmt.Sprintf("CREATE INDEX %s ON %s USING GIN (%s)", "s"+GetMD5HashString("search_"+tableName), tableName, SearchVectorColumn),
+			fmt.Sprintf("COMMENT ON COLUMN %s.%s IS '%s'", tableName, SearchVectorColumn, strings.Join(columns, ",")),
+		}, nil
+
+	case "mysql":
+		return []string{
+			fmt.Sprintf("ALTER TABLE %s ADD FULLTEXT INDEX %s (%s)", tableName, mysqlSearchIndexName, strings.Join(columns, ", ")),
+		}, nil
+	}
+	return nil, fmt.Errorf("full text search is not supported on [%v]", driverName)
+}
+
+// searchTerms splits the search text into lower case words, anything but letters and digits
+// separates words
+func searchTerms(text string) []string {
+	terms := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	if len(terms) > maxSearchTerms {
+		terms = terms[:maxSearchTerms]
+	}
+	return terms
+}
+
+// tableSearch is a search parameter applied to the queries of a table
+type tableSearch struct {
+	tableName string
+	columns   []string
+	terms     []string
+	driver    string
+	// ranked searches use the full text index, and order rows by relevance
+	ranked bool
+}
+
+// newTableSearch prepares the search over the searchable columns of the table
+func (dbResource *DbResource) newTableSearch(text string) (*tableSearch, error) {
+	columns := SearchableColumns(dbResource.tableInfo)
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("table [%v] has no searchable columns", dbResource.tableInfo.TableName)
+	}
+	search := &tableSearch{
+		tableName: dbResource.tableInfo.TableName,
+		columns:   columns,
+		terms:     searchTerms(text),
+		driver:    dbResource.Connection().DriverName(),
+	}
+	_, search.ranked = searchIndexReady.Load(search.tableName)
+	return search, nil
+}
+
+// matchQuery is the search terms in the query syntax of the database, every term has to match and
+// matches words starting with the term
+func (search *tableSearch) matchQuery() string {
+	parts := make([]string, 0, len(search.terms))
+	for _, term := range search.terms {
+		switch search.driver {
+		case "postgres":
+			parts = append(parts, term+":*")
+		case "mysql":
+			parts = append(parts, "+"+term+"*")
+		default:
+			parts = append(parts, `"`+term+`"*`)
+		}
+	}
+	if search.driver == "postgres" {
+		return strings.Join(parts, " & ")
+	}
+	return strings.Join(parts, " ")
+}
+
+// condition selects the rows matching the search
+func (dbResource *DbResource) searchCondition(search *tableSearch, prefix string, transaction *sqlx.Tx) (goqu.Expression, error) {
+	if !search.ranked {
+		return dbResource.processFuzzySearch(Query{
+			ColumnName:   strings.Join(search.columns, ","),
+			Operator:     "fuzzy_all",
+			Value:        strings.Join(search.terms, " "),
+			FuzzyOptions: &FuzzySearchOptions{FallbackMode: "strict"},
+		}, prefix, transaction)
+	}
+	switch search.driver {
+	case "postgres":
+		return goqu.L(fmt.Sprintf("%s%s @@ to_tsquery('simple', ?)", prefix, SearchVectorColumn), search.matchQuery()), nil
+	case "mysql":
+		return goqu.L(fmt.Sprintf("MATCH(%s) AGAINST (? IN BOOLEAN MODE)", prefix+strings.Join(search.columns, ", "+prefix)), search.matchQuery()), nil
+	default:
+		ftsTable := searchFtsTable(search.tableName)
+		return goqu.L(fmt.Sprintf("%sid IN (SELECT rowid FROM %s WHERE %s MATCH ?)", prefix, ftsTable, ftsTable), search.matchQuery()), nil
+	}
+}
+
+// rankColumn is the relevance of a row to the search, selected as search_rank
+func (search *tableSearch) rankColumn(prefix string) exp.Expression {
+	switch search.driver {
+	case "postgres":
+		return goqu.L(fmt.Sprintf("ts_rank(%s%s, to_tsquery('simple', ?))", prefix, SearchVectorColumn), search.matchQuery()).As("search_rank")
+	case "mysql":
+		return goqu.L(fmt.Sprintf("MATCH(%s) AGAINST (? IN BOOLEAN MODE)", prefix+strings.Join(search.columns, ", "+prefix)), search.matchQuery()).As("search_rank")
+	default:
+		// bm25 of fts5 is lower for better matches, it is negated so a higher rank is better on
+		// every database
+		ftsTable := searchFtsTable(search.tableName)
+		return goqu.L(fmt.Sprintf("(SELECT -bm25(%s) FROM %s WHERE %s MATCH ? AND rowid = %sid)", ftsTable, ftsTable, ftsTable, prefix), search.matchQuery()).As("search_rank")
+	}
+}
+
+// idPositionOrder orders rows in the order of ids, to keep the order of the ranked id query
+func idPositionOrder(idColumn string, ids []int64) exp.OrderedExpression {
+	var order strings.Builder
+	order.WriteString("CASE " + idColumn)
+	for i, id := range ids {
+		order.WriteString(fmt.Sprintf(" WHEN %d THEN %d", id, i))
+	}
+	order.WriteString(fmt.Sprintf(" ELSE %d END", len(ids)))
+	return goqu.L(order.String()).Asc()
+}
+
+// searchRank reads the search_rank of the id query
+func searchRank(value interface{}) float64 {
+	switch rank := value.(type) {
+	case float64:
+		return rank
+	case float32:
+		return float64(rank)
+	case int64:
+		return float64(rank)
+	case []byte:
+		number, _ := strconv.ParseFloat(string(rank), 64)
+		return number
+	case string:
+		number, _ := strconv.ParseFloat(rank, 64)
+		return number
+	}
+	return 0
+}
+
+// searchSnippet is the part of the text around the first match of a term, html escaped and with
+// every match wrapped in <mark>. Returns false when no term matches
+func searchSnippet(text string, terms []string) (string, bool) {
+	runes := []rune(text)
+	lower := make([]rune, len(runes))
+	for i, r := range runes {
+		lower[i] = unicode.ToLower(r)
+	}
+
+	// matched marks the runes which are part of a term
+	matched := make([]bool, len(runes))
+	first := -1
+	for _, term := range terms {
+		termRunes := []rune(term)
+		if len(termRunes) == 0 {
+			continue
+		}
+		for i := 0; i+len(termRunes) <= len(lower); i++ {
+			if string(lower[i:i+len(termRunes)]) != term {
+				continue
+			}
+			// terms match the start of words
+			if i > 0 && (unicode.IsLetter(lower[i-1]) || unicode.IsNumber(lower[i-1])) {
+				continue
+			}
+			for j := i; j < i+len(termRunes); j++ {
+				matched[j] = true
+			}
+			if first == -1 || i < first {
+				first = i
+			}
+		}
+	}
+	if first == -1 {
+		return "", false
+	}
+
+	start := first - searchSnippetRunes/4
+	if start < 0 {
+		start = 0
+	}
+	for start > 0 && !unicode.IsSpace(runes[start-1]) && first-start < searchSnippetRunes/2 {
+		start--
+	}
+	end := start + searchSnippetRunes
+	if end > len(runes) {
+		end = len(runes)
+	}
+	for end < len(runes) && !unicode.IsSpace(runes[end]) && end-start < searchSnippetRunes*3/2 {
+		end++
+	}
+
+	var snippet strings.Builder
+	if start > 0 {
+		snippet.WriteString("…")
+	}
+	for i := start; i < end; {
+		j := i
+		for j < end && matched[j] == matched[i] {
+			j++
+		}
+		part := html.EscapeString(string(runes[i:j]))
+		if matched[i] {
+			snippet.WriteString("<mark>" + part + "</mark>")
+		} else {
+			snippet.WriteString(part)
+		}
+		i = j
+	}
+	if end < len(runes) {
+		snippet.WriteString("…")
+	}
+	return snippet.String(), true
+}
+
+// addSearchResults sets the rank and the highlighted snippets of the searchable columns on each row,
+// as __search_rank and __search_snippets
+func (search *tableSearch) addSearchResults(rows []map[string]interface{}, ranks map[int64]float64) {
+	for _, row := range rows {
+		if id, ok := row["id"].(int64); ok && ranks != nil {
+			row["__search_rank"] = ranks[id]
+		}
+		snippets := make(map[string]string)
+		for _, column := range search.columns {
+			var text string
+			switch value := row[column].(type) {
+			case string:
+				text = value
+			case []byte:
+				text = string(value)
+			default:
+				continue
+			}
+			if snippet, ok := searchSnippet(text, search.terms); ok {
+				snippets[column] = snippet
+			}
+		}
+		row["__search_snippets"] = snippets
+	}
+}
+
+// facetColumns are the requested facet columns which can be counted, enum and label columns
+func (dbResource *DbResource) facetColumns(requested []string) ([]string, error) {
+	columns := make([]string, 0)
+	for _, value := range requested {
+		for _, name := range strings.Split(value, ",") {
+			name = strings.TrimSpace(name)
+			if name == "" {
+				continue
+			}
+			column, ok := dbResource.tableInfo.GetColumnByName(name)
+			if !ok || column.ExcludeFromApi {
+				return nil, fmt.Errorf("table [%v] has no column [%v] to count facets of", dbResource.tableInfo.TableName, name)
+			}
+			if column.ColumnType != "enum" && column.ColumnType != "label" && len(column.Options) == 0 {
+				return nil, fmt.Errorf("facets are counted for enum and label columns, [%v] is [%v]", name, column.ColumnType)
+			}
+			columns = append(columns, column.ColumnName)
+		}
+	}
+	return columns, nil
+}
+
+// countFacetsInMemory counts the rows of each value of the facet columns like countFacets, over rows
+// which were filtered in memory
+func countFacetsInMemory(rows []map[string]interface{}, columns []string) map[string][]FacetCount {
+	facets := make(map[string][]FacetCount)
+	for _, column := range columns {
+		counts := make([]FacetCount, 0)
+		positions := make(map[string]int)
+		for _, row := range rows {
+			value := row[column]
+			if bytes, ok := value.([]byte); ok {
+				value = string(bytes)
+			}
+			key := fmt.Sprintf("%T:%v", value, value)
+			if position, ok := positions[key]; ok {
+				counts[position].Count++
+				continue
+			}
+			positions[key] = len(counts)
+			counts = append(counts, FacetCount{Value: value, Count: 1})
+		}
+		sort.SliceStable(counts, func(i, j int) bool {
+			return counts[i].Count > counts[j].Count
+		})
+		if len(counts) > maxFacetValues {
+			counts = counts[:maxFacetValues]
+		}
+		facets[column] = counts
+	}
+	return facets
+}
+
+// countFacets counts the rows of each value of the facet columns, over the rows selected by the
+// count query of the request
+func countFacets(countQueryBuilder *goqu.SelectDataset, tableName string, columns []string, transaction *sqlx.Tx) (map[string][]FacetCount, error) {
+	facets := make(map[string][]FacetCount)
+	for _, column := range columns {
+		facetColumn := goqu.I(tableName + "." + column)
+		query, args, err := countQueryBuilder.
+			Select(facetColumn.As("facet_value"), goqu.L(fmt.Sprintf("count(distinct(%v.id))", tableName)).As("facet_count")).
+			GroupBy(facetColumn).
+			Order(goqu.I("facet_count").Desc()).
+			ClearOffset().
+			Limit(maxFacetValues).
+			ToSQL()
+		if err != nil {
+			return nil, err
+		}
+		rows, err := transaction.Queryx(query, args...)
+		if err != nil {
+			return nil, err
+		}
+		counts := make([]FacetCount, 0)
+		for rows.Next() {
+			var value interface{}
+			var count int64
+			if err = rows.Scan(&value, &count); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			if bytes, ok := value.([]byte); ok {
+				value = string(bytes)
+			}
+			counts = append(counts, FacetCount{Value: value, Count: count})
+		}
+		rows.Close()
+		facets[column] = counts
+	}
+	return facets, nil
+}
@@ -0,0 +1,204 @@
+package resource
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/artpar/api2go/v2"
+	"github.com/daptin/daptin/server/auth"
+	daptinid "github.com/daptin/daptin/server/id"
+	"github.com/daptin/daptin/server/table_info"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestSearchTerms(t *testing.T) {
+	terms := searchTerms(`Café "crème", brûlée-2024!`)
+	if !reflect.DeepEqual(terms, []string{"café", "crème", "brûlée", "2024"}) {
+		t.Errorf("unexpected terms %v", terms)
+	}
+	if len(searchTerms(`'") OR 1=1 --`)) != 3 {
+		t.Errorf("quotes and operators should not be part of terms")
+	}
+}
+
+func TestSearchSnippet(t *testing.T) {
+	snippet, ok := searchSnippet("The <b>quick</b> brown fox jumps over the lazy dog", []string{"quick", "laz"})
+	if !ok {
+		t.Fatalf("expected a match")
+	}
+	expected := "The &lt;b&gt;<mark>quick</mark>&lt;/b&gt; brown fox jumps over the <mark>laz</mark>y dog"
+	if snippet != expected {
+		t.Errorf("expected %q, got %q", expected, snippet)
+	}
+
+	if _, ok := searchSnippet("unquickly", []string{"quick"}); ok {
+		t.Errorf("terms should only match the start of words")
+	}
+
+	long := ""
+	for i := 0; i < 100; i++ {
+		long += "filler "
+	}
+	snippet, _ = searchSnippet(long+"needle "+long, []string{"needle"})
+	if len([]rune(snippet)) > searchSnippetRunes*2 || snippet[:3] != "…" {
+		t.Errorf("snippet of a long text should be cut around the match, got %q", snippet)
+	}
+}
+
+func TestSQLiteSearchAndFacets(t *testing.T) {
+	testSearchAndFacets(t, true)
+}
+
+func TestSQLiteSearchWithoutFullTextIndex(t *testing.T) {
+	testSearchAndFacets(t, false)
+}
+
+// testSearchAndFacets searches articles with the fts5 index, or with the LIKE matching used when
+// there is none. The fts5 run is skipped when sqlite is built without -tags sqlite_fts5
+func testSearchAndFacets(t *testing.T, fullText bool) {
+	db, err := sqlx.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	if fullText {
+		if _, err = db.Exec(`CREATE VIRTUAL TABLE fts5_probe USING fts5(body)`); err != nil {
+			t.Skipf("sqlite is built without fts5, build with -tags sqlite_fts5: %v", err)
+		}
+		db.MustExec(`DROP TABLE fts5_probe`)
+	}
+
+	adminGroupRef := daptinid.DaptinReferenceId(uuid.New())
+	oldUserAccountCrud := CRUD_MAP[USER_ACCOUNT_TABLE_NAME]
+	CRUD_MAP[USER_ACCOUNT_TABLE_NAME] = &DbResource{AdministratorGroupId: adminGroupRef}
+	defer func() {
+		if oldUserAccountCrud == nil {
+			delete(CRUD_MAP, USER_ACCOUNT_TABLE_NAME)
+			return
+		}
+		CRUD_MAP[USER_ACCOUNT_TABLE_NAME] = oldUserAccountCrud
+	}()
+
+	for _, statement := range []string{
+		`create table article (id integer primary key, title varchar(100), body text, status varchar(20),
+			user_account_id integer, permission integer, reference_id blob not null unique, created_at timestamp)`,
+		`create table article_article_id_has_usergroup_usergroup_id (id integer primary key, article_id integer,
+			usergroup_id integer, permission integer, reference_id blob)`,
+	} {
+		if _, err = db.Exec(statement); err != nil {
+			t.Fatalf("create table: %v", err)
+		}
+	}
+	insert := func(id int, title, body, status string) {
+		t.Helper()
+		referenceId := uuid.New()
+		_, err := db.Exec(`insert into article (id, title, body, status, user_account_id, permission, reference_id, created_at)
+			values (?, ?, ?, ?, 1, ?, ?, ?)`, id, title, body, status, int64(auth.ALLOW_ALL_PERMISSIONS), referenceId[:], time.Now())
+		if err != nil {
+			t.Fatalf("insert article: %v", err)
+		}
+	}
+	insert(1, "Gardening tips", "Water the garden in the morning", "published")
+	insert(2, "Garden garden garden", "All about the garden", "draft")
+
+	columns := []api2go.ColumnInfo{
+		{Name: "title", ColumnName: "title", ColumnType: "label", DataType: "varchar(100)"},
+		{Name: "body", ColumnName: "body", ColumnType: "content", DataType: "text"},
+		{Name: "status", ColumnName: "status", ColumnType: "label", DataType: "varchar(20)"},
+		{Name: USER_ACCOUNT_ID_COLUMN, ColumnName: USER_ACCOUNT_ID_COLUMN},
+		{Name: "permission", ColumnName: "permission"},
+		{Name: "reference_id", ColumnName: "reference_id"},
+		{Name: "created_at", ColumnName: "created_at"},
+	}
+	tableInfo := &table_info.TableInfo{
+		TableName:         "article",
+		Columns:           columns,
+		DefaultPermission: auth.DEFAULT_PERMISSION,
+		Searchable:        []string{"title", "body"},
+	}
+	crud := &DbResource{
+		model:      api2go.NewApi2GoModel("article", columns, int64(auth.DEFAULT_PERMISSION), nil),
+		connection: db,
+		tableInfo:  tableInfo,
+		ms:         &MiddlewareSet{},
+	}
+
+	searchIndexReady.Delete("article")
+	if fullText {
+		if err = ensureSearchIndex(db, "article", SearchableColumns(tableInfo)); err != nil {
+			t.Fatalf("create the full text index: %v", err)
+		}
+		defer searchIndexReady.Delete("article")
+	}
+	_, ranked := searchIndexReady.Load("article")
+	if ranked != fullText {
+		t.Fatalf("expected the full text index to be used: %v, got %v", fullText, ranked)
+	}
+
+	// rows written after the index was created are indexed as well
+	insert(3, "Cooking", "Nothing about plants", "published")
+	insert(4, "Garden tools", "A spade for the garden", "published")
+
+	request, _ := http.NewRequest(http.MethodGet, "/api/article", nil)
+	request = request.WithContext(context.WithValue(request.Context(), "user", &auth.SessionUser{
+		UserReferenceId: daptinid.DaptinReferenceId(uuid.New()),
+		Groups:          auth.GroupPermissionList{{GroupReferenceId: adminGroupRef}},
+	}))
+	find := func(params url.Values) ([]map[string]interface{}, *PaginationData) {
+		t.Helper()
+		tx, err := db.Beginx()
+		if err != nil {
+			t.Fatalf("begin: %v", err)
+		}
+		defer tx.Rollback()
+		rows, _, pagination, _, err := crud.PaginatedFindAllWithoutFilters(api2go.Request{PlainRequest: request, QueryParams: params}, tx)
+		if err != nil {
+			t.Fatalf("find all with %v: %v", params, err)
+		}
+		return rows, pagination
+	}
+
+	rows, pagination := find(url.Values{"search": {"garden"}, "facets": {"status"}, "page[size]": {"10"}})
+	if len(rows) != 3 || pagination.TotalCount != 3 {
+		t.Fatalf("expected the 3 garden articles, got %d of %d", len(rows), pagination.TotalCount)
+	}
+	if ranked && rows[0]["title"] != "Garden garden garden" {
+		t.Errorf("the article with the most matches should rank first, got %v", rows[0]["title"])
+	}
+	snippets, _ := rows[0]["__search_snippets"].(map[string]string)
+	if len(snippets) == 0 {
+		t.Errorf("expected highlighted snippets, got %v", rows[0]["__search_snippets"])
+	}
+	expectedFacets := []FacetCount{{Value: "published", Count: 2}, {Value: "draft", Count: 1}}
+	if !reflect.DeepEqual(pagination.Facets["status"], expectedFacets) {
+		t.Errorf("expected facets %v, got %v", expectedFacets, pagination.Facets)
+	}
+
+	rows, _ = find(url.Values{"search": {"garden spade"}})
+	if len(rows) != 1 || rows[0]["title"] != "Garden tools" {
+		t.Errorf("every term should match, got %v", rows)
+	}
+
+	if _, err = db.Exec(`update article set body = 'A spade' where id = 1`); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	rows, _ = find(url.Values{"search": {"spade"}, "sort": {"title"}})
+	if len(rows) != 2 || rows[0]["title"] != "Garden tools" || rows[1]["title"] != "Gardening tips" {
+		t.Errorf("updated rows should be found in the asked order, got %v", rows)
+	}
+
+	tx, _ := db.Beginx()
+	defer tx.Rollback()
+	_, _, _, _, err = crud.PaginatedFindAllWithoutFilters(api2go.Request{PlainRequest: request, QueryParams: url.Values{"facets": {"body"}}}, tx)
+	if err == nil {
+		t.Errorf("facets of a content column should be rejected")
+	}
+}
@@ -0,0 +1,326 @@
+package resource
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/artpar/api2go/v2"
+	"github.com/daptin/daptin/server/actionresponse"
+	"github.com/daptin/daptin/server/auth"
+	daptinid "github.com/daptin/daptin/server/id"
+	"github.com/daptin/daptin/server/statementbuilder"
+	"github.com/doug-martin/goqu/v9"
+	"github.com/jmoiron/sqlx"
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultSoftDeleteRetentionDays is how long soft deleted rows are kept when the table does not set
+// SoftDeleteRetentionDays
+const DefaultSoftDeleteRetentionDays = 30
+
+// includeDeletedRows tells if an administrator asked for soft deleted rows with ?include_deleted=true
+func includeDeletedRows(req api2go.Request, isAdmin bool) bool {
+	if !isAdmin {
+		return false
+	}
+	values := req.QueryParams["include_deleted"]
+	if len(values) == 0 && req.PlainRequest != nil && req.PlainRequest.URL != nil {
+		values = req.PlainRequest.URL.Query()["include_deleted"]
+	}
+	return len(values) > 0 && values[0] == "true"
+}
+
+// checkNotSoftDeleted fails with 404 for a soft deleted row unless an administrator asked to see deleted rows
+func (dbResource *DbResource) checkNotSoftDeleted(data map[string]interface{}, req api2go.Request, transaction *sqlx.Tx) error {
+	if !dbResource.tableInfo.SoftDelete || data == nil || data[SoftDeleteColumnName] == nil {
+		return nil
+	}
+	if includeDeletedRows(req, true) {
+		sessionUser := &auth.SessionUser{}
+		if user := req.PlainRequest.Context().Value("user"); user != nil {
+			sessionUser = user.(*auth.SessionUser)
+		}
+		if IsAdminWithTransaction(sessionUser, transaction) {
+			return nil
+		}
+	}
+	return api2go.NewHTTPError(fmt.Errorf("[%v][%v] is deleted", dbResource.model.GetName(), data["reference_id"]), "object not found", http.StatusNotFound)
+}
+
+// withoutSoftDeleted adds the deleted_at filter to a where clause on typeName when that table keeps deleted rows
+func (dbResource *DbResource) withoutSoftDeleted(typeName string, where goqu.Ex) goqu.Ex {
+	if other, ok := dbResource.Cruds[typeName]; ok && other.tableInfo.SoftDelete {
+		where[SoftDeleteColumnName] = nil
+	}
+	return where
+}
+
+// cascadeRelations are the relations whose subject rows go along with a row of this table, those
+// named in OnDelete with cascade
+func (dbResource *DbResource) cascadeRelations() []api2go.TableRelation {
+	relations := make([]api2go.TableRelation, 0)
+	if len(dbResource.tableInfo.OnDelete) == 0 {
+		return relations
+	}
+	for _, rel := range dbResource.tableInfo.Relations {
+		if dbResource.tableInfo.OnDelete[rel.GetSubjectName()] != "cascade" {
+			continue
+		}
+		if rel.GetObject() != dbResource.tableInfo.TableName || rel.GetSubject() == dbResource.tableInfo.TableName {
+			continue
+		}
+		if rel.Relation != "belongs_to" && rel.Relation != "has_one" {
+			continue
+		}
+		if _, ok := dbResource.Cruds[rel.GetSubject()]; !ok {
+			continue
+		}
+		relations = append(relations, rel)
+	}
+	return relations
+}
+
+func selectReferenceIds(query *goqu.SelectDataset, transaction *sqlx.Tx) ([]daptinid.DaptinReferenceId, error) {
+	sql1, args, err := query.Prepared(true).ToSQL()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := transaction.Queryx(sql1, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := make([]daptinid.DaptinReferenceId, 0)
+	for rows.Next() {
+		var referenceId daptinid.DaptinReferenceId
+		err = rows.Scan(&referenceId)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, referenceId)
+	}
+	return ids, rows.Err()
+}
+
+// deleteCascadeChildren deletes the rows referring to parentId through a cascading relation, soft
+// deleting them with the same deletedAt when their table keeps deleted rows. The children go through
+// the delete interceptors of their own table, so their permissions are checked as for a direct
+// delete. Rows of tables which do not keep deleted rows could not be restored with a soft deleted
+// parent, they are left alone until the parent is purged. A purge only takes the rows which were
+// deleted along with the parent, rows deleted on their own keep their retention.
+func (dbResource *DbResource) deleteCascadeChildren(parentId int64, req api2go.Request, transaction *sqlx.Tx, purge bool, deletedAt time.Time) error {
+	softDelete := dbResource.tableInfo.SoftDelete && !purge
+	parentDeletedAt := statementbuilder.Squirrel.Select(SoftDeleteColumnName).From(dbResource.tableInfo.TableName).Where(goqu.Ex{"id": parentId})
+	for _, rel := range dbResource.cascadeRelations() {
+		child := dbResource.Cruds[rel.GetSubject()]
+		if softDelete && !child.tableInfo.SoftDelete {
+			log.Debugf("Keep [%v] rows of soft deleted [%v][%v] until it is purged", rel.GetSubject(), dbResource.tableInfo.TableName, parentId)
+			continue
+		}
+		query := statementbuilder.Squirrel.Select("reference_id").From(rel.GetSubject()).
+			Where(goqu.Ex{rel.GetObjectName(): parentId})
+		if child.tableInfo.SoftDelete {
+			if purge {
+				query = query.Where(goqu.C(SoftDeleteColumnName).IsNotNull(), goqu.C(SoftDeleteColumnName).Eq(parentDeletedAt))
+			} else {
+				query = query.Where(goqu.C(SoftDeleteColumnName).IsNull())
+			}
+		}
+		childIds, err := selectReferenceIds(query, transaction)
+		if err != nil {
+			return err
+		}
+		for _, childId := range childIds {
+			log.Debugf("Cascade delete [%v][%v] from [%v][%v]", rel.GetSubject(), childId, dbResource.tableInfo.TableName, parentId)
+			if purge {
+				// the purge is run by the server on rows past their retention, not on behalf of a user
+				err = child.deleteWithoutFilters(childId, req, transaction, true, deletedAt)
+			} else {
+				_, err = child.deleteWithTransaction(childId, req, transaction, deletedAt)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// markSoftDeleted sets deleted_at on the row, it stays in the table until restored or purged
+func (dbResource *DbResource) markSoftDeleted(id daptinid.DaptinReferenceId, transaction *sqlx.Tx, deletedAt time.Time) error {
+	sql1, args, err := statementbuilder.Squirrel.Update(dbResource.model.GetTableName()).Prepared(true).
+		Set(goqu.Record{
+			SoftDeleteColumnName: deletedAt,
+			"updated_at":         time.Now(),
+			"version":            goqu.L("version + 1"),
+		}).
+		Where(goqu.Ex{"reference_id": id[:]}).ToSQL()
+	if err != nil {
+		return err
+	}
+	log.Debugf("Soft delete Sql: %v", sql1)
+	_, err = transaction.Exec(sql1, args...)
+	return err
+}
+
+// PurgeWithoutFilters removes the row from the table even when the table keeps deleted rows
+func (dbResource *DbResource) PurgeWithoutFilters(id daptinid.DaptinReferenceId, req api2go.Request, transaction *sqlx.Tx) error {
+	return dbResource.deleteWithoutFilters(id, req, transaction, true, time.Now())
+}
+
+// RestoreWithTransaction clears deleted_at of a soft deleted row, rows removed along with it by a
+// cascading relation are restored as well
+func (dbResource *DbResource) RestoreWithTransaction(id daptinid.DaptinReferenceId, req api2go.Request, transaction *sqlx.Tx) error {
+	tableName := dbResource.model.GetTableName()
+	if !dbResource.tableInfo.SoftDelete {
+		return api2go.NewHTTPError(fmt.Errorf("[%v] does not keep deleted rows", tableName), "soft delete is not enabled", http.StatusBadRequest)
+	}
+
+	sql1, args, err := statementbuilder.Squirrel.Select("id", SoftDeleteColumnName).From(tableName).Prepared(true).
+		Where(goqu.Ex{"reference_id": id[:]}).ToSQL()
+	if err != nil {
+		return err
+	}
+	var rowId int64
+	var deletedAt interface{}
+	err = transaction.QueryRowx(sql1, args...).Scan(&rowId, &deletedAt)
+	if err != nil {
+		return api2go.NewHTTPError(fmt.Errorf("[%v][%v] not found: %v", tableName, id, err), "object not found", http.StatusNotFound)
+	}
+	if deletedAt == nil {
+		return api2go.NewHTTPError(fmt.Errorf("[%v][%v] is not deleted", tableName, id), "object is not deleted", http.StatusBadRequest)
+	}
+
+	// children deleted along with this row carry the same deleted_at, rows deleted on their own before
+	// or after stay deleted
+	parentDeletedAt := statementbuilder.Squirrel.Select(SoftDeleteColumnName).From(tableName).Where(goqu.Ex{"id": rowId})
+	for _, rel := range dbResource.cascadeRelations() {
+		child := dbResource.Cruds[rel.GetSubject()]
+		if !child.tableInfo.SoftDelete {
+			continue
+		}
+		childIds, err := selectReferenceIds(statementbuilder.Squirrel.Select("reference_id").From(rel.GetSubject()).
+			Where(goqu.Ex{rel.GetObjectName(): rowId}).
+			Where(goqu.C(SoftDeleteColumnName).Eq(parentDeletedAt)), transaction)
+		if err != nil {
+			return err
+		}
+		for _, childId := range childIds {
+			err = child.RestoreWithTransaction(childId, req, transaction)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	sql1, args, err = statementbuilder.Squirrel.Update(tableName).Prepared(true).
+		Set(goqu.Record{
+			SoftDeleteColumnName: nil,
+			"updated_at":         time.Now(),
+			"version":            goqu.L("version + 1"),
+		}).
+		Where(goqu.Ex{"id": rowId}).ToSQL()
+	if err != nil {
+		return err
+	}
+	log.Infof("Restore [%v][%v]", tableName, id)
+	_, err = transaction.Exec(sql1, args...)
+	MarkTableChanged(requestContext(req), tableName)
+	return err
+}
+
+// CheckSoftDeleteTables adds a restore action to every table which keeps deleted rows
+func CheckSoftDeleteTables(config *CmsConfig) {
+	existingActions := make(map[string]bool)
+	for _, action := range config.Actions {
+		existingActions[action.OnType+"."+action.Name] = true
+	}
+
+	for _, table := range config.Tables {
+		if !table.SoftDelete || existingActions[table.TableName+".restore"] {
+			continue
+		}
+		log.Printf("Add restore action for soft delete table [%v]", table.TableName)
+		config.Actions = append(config.Actions, actionresponse.Action{
+			Name:             "restore",
+			Label:            "Restore deleted " + table.TableName,
+			OnType:           table.TableName,
+			InstanceOptional: true,
+			Permission:       &adminOnlyActionPermission,
+			AccessGroups:     adminOnlyActionAccessGroups,
+			InFields: []api2go.ColumnInfo{
+				{
+					Name:       "Reference Id",
+					ColumnName: "reference_id",
+					ColumnType: "label",
+				},
+			},
+			OutFields: []actionresponse.Outcome{
+				{
+					Type:   "row.restore",
+					Method: "EXECUTE",
+					Attributes: map[string]interface{}{
+						"table_name":   table.TableName,
+						"reference_id": "~reference_id",
+					},
+				},
+			},
+		})
+	}
+}
+
+// PurgeSoftDeletedRows removes rows deleted longer ago than the retention period of their table
+func PurgeSoftDeletedRows(cruds map[string]*DbResource) {
+	for tableName, dbResource := range cruds {
+		if dbResource.tableInfo == nil || !dbResource.tableInfo.SoftDelete {
+			continue
+		}
+		retentionDays := dbResource.tableInfo.SoftDeleteRetentionDays
+		if retentionDays <= 0 {
+			retentionDays = DefaultSoftDeleteRetentionDays
+		}
+		cutoff := time.Now().AddDate(0, 0, -retentionDays)
+
+		transaction, err := dbResource.Connection().Beginx()
+		if err != nil {
+			CheckErr(err, "Failed to begin transaction to purge [%v]", tableName)
+			continue
+		}
+		ids, err := selectReferenceIds(statementbuilder.Squirrel.Select("reference_id").From(tableName).
+			Where(goqu.C(SoftDeleteColumnName).Lt(cutoff)), transaction)
+		if err != nil {
+			CheckErr(err, "Failed to list deleted rows of [%v]", tableName)
+			rollbackErr := transaction.Rollback()
+			CheckErr(rollbackErr, "Failed to rollback")
+			continue
+		}
+
+		changes := WithTableChanges(context.Background())
+		pr := &http.Request{Method: "DELETE", URL: &url.URL{Path: "/" + tableName}}
+		req := api2go.Request{PlainRequest: pr.WithContext(changes)}
+		purged := 0
+		for _, id := range ids {
+			err = dbResource.PurgeWithoutFilters(id, req, transaction)
+			if err != nil {
+				break
+			}
+			purged += 1
+		}
+		if err != nil {
+			CheckErr(err, "Failed to purge deleted rows of [%v]", tableName)
+			rollbackErr := transaction.Rollback()
+			CheckErr(rollbackErr, "Failed to rollback")
+			continue
+		}
+		err = transaction.Commit()
+		CheckErr(err, "Failed to commit purge of [%v]", tableName)
+		BumpChangedTables(changes)
+		if purged > 0 {
+			log.Infof("Purged %d rows of [%v] deleted before %v", purged, tableName, cutoff)
+		}
+	}
+}
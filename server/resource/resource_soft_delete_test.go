@@ -0,0 +1,270 @@
+package resource
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/artpar/api2go/v2"
+	"github.com/daptin/daptin/server/auth"
+	daptinid "github.com/daptin/daptin/server/id"
+	"github.com/daptin/daptin/server/table_info"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestSoftDeleteCascadeRestoreAndPurge(t *testing.T) {
+	db, err := sqlx.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	for _, statement := range []string{
+		`create table project (
+			id integer primary key,
+			reference_id blob not null unique,
+			name text,
+			permission integer,
+			version integer,
+			updated_at timestamp,
+			deleted_at timestamp
+		)`,
+		`create table task (
+			id integer primary key,
+			reference_id blob not null unique,
+			project_id integer,
+			permission integer,
+			version integer,
+			updated_at timestamp,
+			deleted_at timestamp
+		)`,
+		`create table note (
+			id integer primary key,
+			reference_id blob not null unique,
+			project_id integer,
+			permission integer,
+			version integer,
+			updated_at timestamp
+		)`,
+	} {
+		if _, err := db.Exec(statement); err != nil {
+			t.Fatalf("setup statement failed: %v", err)
+		}
+	}
+
+	projectRef := daptinid.DaptinReferenceId(uuid.New())
+	taskRef := daptinid.DaptinReferenceId(uuid.New())
+	trashedTaskRef := daptinid.DaptinReferenceId(uuid.New())
+	noteRef := daptinid.DaptinReferenceId(uuid.New())
+	earlier := time.Now().Add(-time.Hour)
+	for _, seed := range []struct {
+		query string
+		args  []interface{}
+	}{
+		{`insert into project (id, reference_id, name, permission, version) values (1, ?, 'apollo', ?, 1)`, []interface{}{projectRef[:], int64(auth.DEFAULT_PERMISSION)}},
+		{`insert into task (id, reference_id, project_id, permission, version) values (1, ?, 1, ?, 1)`, []interface{}{taskRef[:], int64(auth.DEFAULT_PERMISSION)}},
+		{`insert into task (id, reference_id, project_id, permission, version, deleted_at) values (2, ?, 1, ?, 1, ?)`, []interface{}{trashedTaskRef[:], int64(auth.DEFAULT_PERMISSION), earlier}},
+		{`insert into note (id, reference_id, project_id, permission, version) values (1, ?, 1, ?, 1)`, []interface{}{noteRef[:], int64(auth.DEFAULT_PERMISSION)}},
+	} {
+		if _, err := db.Exec(seed.query, seed.args...); err != nil {
+			t.Fatalf("seed failed: %v", err)
+		}
+	}
+
+	relations := []api2go.TableRelation{
+		api2go.NewTableRelation("task", "belongs_to", "project"),
+		api2go.NewTableRelation("note", "belongs_to", "project"),
+	}
+	cruds := map[string]*DbResource{}
+	newCrud := func(tableName string, columns []api2go.ColumnInfo, softDelete bool) *DbResource {
+		columns = append(columns,
+			api2go.ColumnInfo{Name: "permission", ColumnName: "permission"},
+			api2go.ColumnInfo{Name: "reference_id", ColumnName: "reference_id"},
+			api2go.ColumnInfo{Name: "version", ColumnName: "version"},
+			api2go.ColumnInfo{Name: "updated_at", ColumnName: "updated_at"},
+		)
+		if softDelete {
+			columns = append(columns, SoftDeleteColumn)
+		}
+		return &DbResource{
+			model: api2go.NewApi2GoModel(tableName, columns, int64(auth.DEFAULT_PERMISSION), relations),
+			tableInfo: &table_info.TableInfo{
+				TableName:         tableName,
+				Columns:           columns,
+				Relations:         relations,
+				DefaultPermission: auth.DEFAULT_PERMISSION,
+				SoftDelete:        softDelete,
+			},
+			connection: db,
+			ms:         &MiddlewareSet{},
+			Cruds:      cruds,
+		}
+	}
+	cruds["project"] = newCrud("project", []api2go.ColumnInfo{{Name: "name", ColumnName: "name", ColumnType: "label"}}, true)
+	cruds["project"].tableInfo.OnDelete = map[string]string{"task_id": "cascade", "note_id": "cascade"}
+	cruds["task"] = newCrud("task", []api2go.ColumnInfo{{Name: "project_id", ColumnName: "project_id"}}, true)
+	cruds["note"] = newCrud("note", []api2go.ColumnInfo{{Name: "project_id", ColumnName: "project_id"}}, false)
+
+	req := api2go.Request{
+		PlainRequest: (&http.Request{
+			Method: http.MethodDelete,
+			URL:    &url.URL{Path: "/api/project/" + projectRef.String()},
+		}).WithContext(context.Background()),
+	}
+
+	noteCount := func() int {
+		var count int
+		if err := db.Get(&count, "select count(*) from note"); err != nil {
+			t.Fatalf("count notes: %v", err)
+		}
+		return count
+	}
+	deletedAt := func(table string, id int) interface{} {
+		var value interface{}
+		if err := db.QueryRow("select deleted_at from "+table+" where id = ?", id).Scan(&value); err != nil {
+			t.Fatalf("read deleted_at of %s %d: %v", table, id, err)
+		}
+		return value
+	}
+
+	tx := db.MustBegin()
+	if err := cruds["project"].DeleteWithoutFilters(projectRef, req, tx); err != nil {
+		t.Fatalf("soft delete failed: %v", err)
+	}
+	if err := cruds["project"].DeleteWithoutFilters(projectRef, req, tx); err == nil {
+		t.Fatalf("deleting a deleted row should fail")
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+	if deletedAt("project", 1) == nil || deletedAt("task", 1) == nil {
+		t.Fatalf("project and its task should be marked deleted")
+	}
+	if noteCount() != 1 {
+		t.Fatalf("a note cannot be restored, it should be kept until the project is purged")
+	}
+
+	tx = db.MustBegin()
+	changes := WithTableChanges(context.Background())
+	restoreRequest := api2go.Request{PlainRequest: (&http.Request{Method: "EXECUTE", URL: &url.URL{Path: "/action/project/restore"}}).WithContext(changes)}
+	if err := cruds["project"].RestoreWithTransaction(projectRef, restoreRequest, tx); err != nil {
+		t.Fatalf("restore failed: %v", err)
+	}
+	restored := changes.Value(TableChangesContextKey).(*TableChanges).tables
+	if !restored["project"] || !restored["task"] {
+		t.Errorf("expected the restore to change project and task, got %v", restored)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+	if deletedAt("project", 1) != nil || deletedAt("task", 1) != nil {
+		t.Fatalf("project and the task deleted with it should be restored")
+	}
+	if deletedAt("task", 2) == nil {
+		t.Fatalf("task deleted on its own should stay deleted")
+	}
+
+	tx = db.MustBegin()
+	if err := cruds["project"].DeleteWithoutFilters(projectRef, req, tx); err != nil {
+		t.Fatalf("soft delete failed: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+	aged := time.Now().AddDate(0, 0, -DefaultSoftDeleteRetentionDays-1)
+	if _, err := db.Exec("update task set deleted_at = ? where deleted_at = (select deleted_at from project where id = 1)", aged); err != nil {
+		t.Fatalf("age deleted task: %v", err)
+	}
+	if _, err := db.Exec("update project set deleted_at = ?", aged); err != nil {
+		t.Fatalf("age deleted row: %v", err)
+	}
+
+	PurgeSoftDeletedRows(cruds)
+
+	var remaining []int
+	if err := db.Select(&remaining, "select id from project union all select id from task"); err != nil {
+		t.Fatalf("list rows: %v", err)
+	}
+	if len(remaining) != 1 || remaining[0] != 2 {
+		t.Fatalf("purge should remove the project with the task deleted along with it and keep the task deleted on its own, left %v", remaining)
+	}
+	if noteCount() != 0 {
+		t.Fatalf("purging the project should remove its notes")
+	}
+}
+
+// denyDelete refuses every delete, as the permission interceptors do for a user who cannot delete
+type denyDelete struct{}
+
+func (d denyDelete) String() string {
+	return "deny_delete"
+}
+
+func (d denyDelete) InterceptBefore(dr *DbResource, req *api2go.Request, objects []map[string]interface{}, transaction *sqlx.Tx) ([]map[string]interface{}, error) {
+	return nil, api2go.NewHTTPError(nil, "delete denied", http.StatusForbidden)
+}
+
+func (d denyDelete) InterceptAfter(dr *DbResource, req *api2go.Request, results []map[string]interface{}, transaction *sqlx.Tx) ([]map[string]interface{}, error) {
+	return results, nil
+}
+
+func TestSoftDeleteCascadeRunsTheChildInterceptors(t *testing.T) {
+	db, err := sqlx.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	db.MustExec(`create table project (id integer primary key, reference_id blob not null unique, permission integer, version integer, updated_at timestamp, deleted_at timestamp)`)
+	db.MustExec(`create table task (id integer primary key, reference_id blob not null unique, project_id integer, permission integer, version integer, updated_at timestamp, deleted_at timestamp)`)
+	projectRef := daptinid.DaptinReferenceId(uuid.New())
+	taskRef := daptinid.DaptinReferenceId(uuid.New())
+	db.MustExec(`insert into project (id, reference_id, permission, version) values (1, ?, ?, 1)`, projectRef[:], int64(auth.DEFAULT_PERMISSION))
+	db.MustExec(`insert into task (id, reference_id, project_id, permission, version) values (1, ?, 1, ?, 1)`, taskRef[:], int64(auth.DEFAULT_PERMISSION))
+
+	relations := []api2go.TableRelation{api2go.NewTableRelation("task", "belongs_to", "project")}
+	cruds := map[string]*DbResource{}
+	for _, tableName := range []string{"project", "task"} {
+		columns := []api2go.ColumnInfo{
+			{Name: "project_id", ColumnName: "project_id"},
+			{Name: "permission", ColumnName: "permission"},
+			{Name: "reference_id", ColumnName: "reference_id"},
+			{Name: "version", ColumnName: "version"},
+			{Name: "updated_at", ColumnName: "updated_at"},
+			SoftDeleteColumn,
+		}
+		cruds[tableName] = &DbResource{
+			model: api2go.NewApi2GoModel(tableName, columns, int64(auth.DEFAULT_PERMISSION), relations),
+			tableInfo: &table_info.TableInfo{
+				TableName:         tableName,
+				Columns:           columns,
+				Relations:         relations,
+				DefaultPermission: auth.DEFAULT_PERMISSION,
+				SoftDelete:        true,
+			},
+			connection: db,
+			ms:         &MiddlewareSet{},
+			Cruds:      cruds,
+		}
+	}
+	cruds["project"].tableInfo.OnDelete = map[string]string{"task_id": "cascade"}
+	cruds["task"].ms.BeforeDelete = []DatabaseRequestInterceptor{denyDelete{}}
+
+	req := api2go.Request{
+		PlainRequest: (&http.Request{
+			Method: http.MethodDelete,
+			URL:    &url.URL{Path: "/api/project/" + projectRef.String()},
+		}).WithContext(context.Background()),
+	}
+	tx := db.MustBegin()
+	defer tx.Rollback()
+	if _, err := cruds["project"].DeleteWithTransaction(projectRef, req, tx); err == nil {
+		t.Fatalf("the project should not be deleted when one of its tasks cannot be")
+	}
+}
@@ -0,0 +1,450 @@
+package resource
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/artpar/api2go/v2"
+	"github.com/daptin/daptin/server/actionresponse"
+	"github.com/daptin/daptin/server/auth"
+	"github.com/daptin/daptin/server/fsm"
+	daptinid "github.com/daptin/daptin/server/id"
+	"github.com/daptin/daptin/server/statementbuilder"
+	"github.com/doug-martin/goqu/v9"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// StateTransitionFiredByUser marks transitions requested on /track/event
+	StateTransitionFiredByUser = "user"
+	// StateTransitionFiredByTimer marks transitions fired by an event with After set
+	StateTransitionFiredByTimer = "timer"
+	// stateTrackingStartEvent is the event name recorded in the history when tracking starts
+	stateTrackingStartEvent = "start"
+)
+
+// StateMachineDescriptionFromRow reads a row of the smd table
+func StateMachineDescriptionFromRow(row map[string]interface{}) (fsm.LoopbookFsmDescription, error) {
+	machine := fsm.LoopbookFsmDescription{
+		Name:         stringColumnValue(row["name"]),
+		Label:        stringColumnValue(row["label"]),
+		InitialState: stringColumnValue(row["initial_state"]),
+	}
+	if events := stringColumnValue(row["events"]); events != "" {
+		err := json.Unmarshal([]byte(events), &machine.Events)
+		if err != nil {
+			return machine, fmt.Errorf("invalid events in state machine [%v]: %v", machine.Name, err)
+		}
+	}
+	if states := stringColumnValue(row["states"]); states != "" {
+		err := json.Unmarshal([]byte(states), &machine.States)
+		if err != nil {
+			return machine, fmt.Errorf("invalid states in state machine [%v]: %v", machine.Name, err)
+		}
+	}
+	return machine, nil
+}
+
+func stringColumnValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+// trackedState is a <type>_state row with the object and the machine it belongs to
+type trackedState struct {
+	typeName    string
+	id          int64
+	referenceId daptinid.DaptinReferenceId
+	current     string
+	version     int64
+	permission  int64
+	owner       interface{}
+	subject     map[string]interface{}
+	machine     fsm.LoopbookFsmDescription
+}
+
+func (dbResource *DbResource) loadTrackedState(typeName string, stateReferenceId daptinid.DaptinReferenceId, transaction *sqlx.Tx) (*trackedState, error) {
+	s, v, err := statementbuilder.Squirrel.
+		Select("id", "current_state", "version", "permission", USER_ACCOUNT_ID_COLUMN, "is_state_of_"+typeName, typeName+"_smd").
+		Prepared(true).From(typeName + "_state").
+		Where(goqu.Ex{"reference_id": stateReferenceId[:]}).ToSQL()
+	if err != nil {
+		return nil, err
+	}
+	row := make(map[string]interface{})
+	err = transaction.QueryRowx(s, v...).MapScan(row)
+	if err != nil {
+		return nil, api2go.NewHTTPError(err, "object state not found", http.StatusNotFound)
+	}
+
+	state := &trackedState{
+		typeName:    typeName,
+		id:          toInt64(row["id"]),
+		referenceId: stateReferenceId,
+		current:     stringColumnValue(row["current_state"]),
+		version:     toInt64(row["version"]),
+		permission:  toInt64(row["permission"]),
+		owner:       row[USER_ACCOUNT_ID_COLUMN],
+	}
+
+	state.subject, err = dbResource.GetIdToObject(typeName, toInt64(row["is_state_of_"+typeName]), transaction)
+	if err != nil {
+		return nil, err
+	}
+	if state.subject == nil {
+		return nil, api2go.NewHTTPError(fmt.Errorf("object of state [%v] not found", stateReferenceId), "object not found", http.StatusNotFound)
+	}
+
+	machineRow, err := dbResource.GetIdToObject("smd", toInt64(row[typeName+"_smd"]), transaction)
+	if err != nil {
+		return nil, err
+	}
+	if machineRow == nil {
+		return nil, fmt.Errorf("state machine of state [%v] not found", stateReferenceId)
+	}
+	state.machine, err = StateMachineDescriptionFromRow(machineRow)
+	return state, err
+}
+
+// ApplyStateTransition moves the <type>_state row stateReferenceId along eventName. The guard of the
+// event is checked against the object, the OnExit, event and OnEnter actions are invoked and the
+// transition is recorded in <type>_state_transition, all in the given transaction. It returns the
+// new state.
+func (dbResource *DbResource) ApplyStateTransition(typeName string, stateReferenceId daptinid.DaptinReferenceId,
+	eventName string, firedBy string, req api2go.Request, transaction *sqlx.Tx) (string, error) {
+
+	state, err := dbResource.loadTrackedState(typeName, stateReferenceId, transaction)
+	if err != nil {
+		return "", err
+	}
+
+	from := state.current
+	event, ok := state.machine.Event(from, eventName)
+	if !ok {
+		return from, api2go.NewHTTPError(fmt.Errorf("cannot apply event %s at this state [%v]", eventName, from),
+			"event not allowed in current state", http.StatusBadRequest)
+	}
+	to, err := state.machine.NextState(from, eventName)
+	if err != nil {
+		return from, api2go.NewHTTPError(err, "event not allowed in current state", http.StatusBadRequest)
+	}
+
+	env := map[string]interface{}{
+		"subject": state.subject,
+		"from":    from,
+		"to":      to,
+		"event":   eventName,
+	}
+
+	if event.Guard != "" {
+		allowed, err := evaluateTransitionGuard(event.Guard, env)
+		if err != nil {
+			return from, api2go.NewHTTPError(err, "failed to evaluate transition guard", http.StatusBadRequest)
+		}
+		if !allowed {
+			return from, api2go.NewHTTPError(fmt.Errorf("guard refused event %s at state [%v]", eventName, from),
+				"transition not allowed", http.StatusBadRequest)
+		}
+	}
+
+	actions := make([]fsm.LoopbackActionDesc, 0)
+	if from != to {
+		actions = append(actions, state.machine.State(from).OnExit...)
+	}
+	actions = append(actions, event.Actions...)
+	if from != to {
+		actions = append(actions, state.machine.State(to).OnEnter...)
+	}
+	for _, action := range actions {
+		err = dbResource.invokeStateAction(action, env, req, transaction)
+		if err != nil {
+			return from, err
+		}
+	}
+
+	s, v, err := statementbuilder.Squirrel.Update(typeName + "_state").Prepared(true).
+		Set(goqu.Record{
+			"current_state": to,
+			"version":       state.version + 1,
+			"updated_at":    time.Now(),
+		}).
+		Where(goqu.Ex{"id": state.id}).
+		Where(goqu.L("COALESCE(version, 0)").Eq(state.version)).ToSQL()
+	if err != nil {
+		return from, err
+	}
+	result, err := transaction.Exec(s, v...)
+	if err != nil {
+		return from, err
+	}
+	if updated, err := result.RowsAffected(); err == nil && updated == 0 {
+		return from, api2go.NewHTTPError(fmt.Errorf("state [%v] changed during transition", stateReferenceId),
+			"state changed, retry the event", http.StatusConflict)
+	}
+
+	err = state.recordTransition(eventName, from, to, firedBy, transaction)
+	MarkTableChanged(requestContext(req), typeName+"_state")
+	MarkTableChanged(requestContext(req), typeName+"_state_transition")
+	return to, err
+}
+
+// EnterInitialState runs the OnEnter actions of the initial state for a newly tracked object and
+// records the start of tracking in its history
+func (dbResource *DbResource) EnterInitialState(typeName string, stateReferenceId daptinid.DaptinReferenceId,
+	req api2go.Request, transaction *sqlx.Tx) error {
+
+	state, err := dbResource.loadTrackedState(typeName, stateReferenceId, transaction)
+	if err != nil {
+		return err
+	}
+	env := map[string]interface{}{
+		"subject": state.subject,
+		"from":    "",
+		"to":      state.current,
+		"event":   stateTrackingStartEvent,
+	}
+	for _, action := range state.machine.State(state.current).OnEnter {
+		err = dbResource.invokeStateAction(action, env, req, transaction)
+		if err != nil {
+			return err
+		}
+	}
+	MarkTableChanged(requestContext(req), typeName+"_state_transition")
+	return state.recordTransition(stateTrackingStartEvent, "", state.current, StateTransitionFiredByUser, transaction)
+}
+
+// recordTransition adds a row to <type>_state_transition, owned like the state row it belongs to
+func (state *trackedState) recordTransition(eventName string, from string, to string, firedBy string, transaction *sqlx.Tx) error {
+	u, _ := uuid.NewV7()
+	record := goqu.Record{
+		"reference_id":               u[:],
+		"event_name":                 eventName,
+		"to_state":                   to,
+		"fired_by":                   firedBy,
+		state.typeName + "_state_id": state.id,
+		USER_ACCOUNT_ID_COLUMN:       state.owner,
+		"permission":                 state.permission,
+		"created_at":                 time.Now(),
+		"version":                    1,
+	}
+	if from != "" {
+		record["from_state"] = from
+	}
+	s, v, err := statementbuilder.Squirrel.Insert(state.typeName + "_state_transition").Prepared(true).Rows(record).ToSQL()
+	if err != nil {
+		return err
+	}
+	_, err = transaction.Exec(s, v...)
+	return err
+}
+
+// evaluateTransitionGuard runs the guard as javascript, it passes when the result is true or "1"
+func evaluateTransitionGuard(guard string, env map[string]interface{}) (bool, error) {
+	result, err := EvaluateString("!"+guard, env)
+	if err != nil {
+		return false, err
+	}
+	if boolValue, ok := result.(bool); ok {
+		return boolValue, nil
+	}
+	strVal := strings.ToLower(strings.TrimSpace(fmt.Sprintf("%v", result)))
+	return strVal == "1" || strVal == "true", nil
+}
+
+// invokeStateAction invokes an action as the user of the request, inside the transaction of the transition
+func (dbResource *DbResource) invokeStateAction(action fsm.LoopbackActionDesc, env map[string]interface{},
+	req api2go.Request, transaction *sqlx.Tx) error {
+
+	actionCrud, ok := dbResource.Cruds[action.Type]
+	if !ok {
+		return fmt.Errorf("no such entity [%v] for state machine action [%v]", action.Type, action.Action)
+	}
+
+	attributes := make(map[string]interface{})
+	for key, value := range action.Attributes {
+		if stringValue, isString := value.(string); isString {
+			evaluated, err := EvaluateString(stringValue, env)
+			if err != nil {
+				return err
+			}
+			attributes[key] = evaluated
+		} else {
+			attributes[key] = value
+		}
+	}
+
+	actionUrl, _ := url.Parse("/action/" + action.Type + "/" + action.Action)
+	pr := (&http.Request{
+		Method: "EXECUTE",
+		URL:    actionUrl,
+	}).WithContext(req.PlainRequest.Context())
+
+	log.Debugf("Invoke state machine action [%v][%v]", action.Type, action.Action)
+	_, err := actionCrud.HandleActionRequest(actionresponse.ActionRequest{
+		Type:       action.Type,
+		Action:     action.Action,
+		Attributes: attributes,
+	}, api2go.Request{PlainRequest: pr}, transaction)
+	return err
+}
+
+// FireStateTimers applies the events which have After set to objects that have been in a source
+// state of the event for at least that long. Each transition runs in its own transaction as the
+// owner of the state row.
+func FireStateTimers(cruds map[string]*DbResource) {
+	smdCrud, ok := cruds["smd"]
+	if !ok {
+		return
+	}
+
+	transaction, err := smdCrud.Connection().Beginx()
+	if err != nil {
+		CheckErr(err, "Failed to begin transaction to list state machines")
+		return
+	}
+	machineRows, err := smdCrud.GetAllRawObjectsWithTransaction("smd", transaction)
+	rollbackErr := transaction.Rollback()
+	CheckErr(rollbackErr, "Failed to rollback")
+	if err != nil {
+		CheckErr(err, "Failed to list state machines")
+		return
+	}
+
+	for _, machineRow := range machineRows {
+		machine, err := StateMachineDescriptionFromRow(machineRow)
+		if err != nil {
+			CheckErr(err, "Failed to read state machine [%v]", machineRow["name"])
+			continue
+		}
+		for _, event := range machine.Events {
+			after, err := event.AfterDuration()
+			if err != nil {
+				CheckErr(err, "Invalid After [%v] on event [%v] of [%v]", event.After, event.Name, machine.Name)
+				continue
+			}
+			if after <= 0 {
+				continue
+			}
+			for typeName, dbResource := range cruds {
+				if dbResource.tableInfo == nil || !dbResource.tableInfo.IsStateTrackingEnabled {
+					continue
+				}
+				fireStateTimer(dbResource, typeName, toInt64(machineRow["id"]), event, time.Now().Add(-after))
+			}
+		}
+	}
+}
+
+func fireStateTimer(dbResource *DbResource, typeName string, machineId int64, event fsm.LoopbackEventDesc, cutoff time.Time) {
+	transaction, err := dbResource.Connection().Beginx()
+	if err != nil {
+		CheckErr(err, "Failed to begin transaction to list due states of [%v]", typeName)
+		return
+	}
+	ids, err := selectReferenceIds(statementbuilder.Squirrel.Select("reference_id").From(typeName+"_state").
+		Where(goqu.Ex{
+			typeName + "_smd": machineId,
+			"current_state":   event.Src,
+		}).
+		Where(goqu.COALESCE(goqu.C("updated_at"), goqu.C("created_at")).Lte(cutoff)), transaction)
+	rollbackErr := transaction.Rollback()
+	CheckErr(rollbackErr, "Failed to rollback")
+	if err != nil {
+		CheckErr(err, "Failed to list due states of [%v]", typeName)
+		return
+	}
+
+	for _, id := range ids {
+		transaction, err := dbResource.Connection().Beginx()
+		if err != nil {
+			CheckErr(err, "Failed to begin transaction to fire [%v] on [%v]", event.Name, id)
+			return
+		}
+		req := api2go.Request{PlainRequest: stateOwnerRequest(dbResource, typeName, id, transaction)}
+		nextState, err := dbResource.ApplyStateTransition(typeName, id, event.Name, StateTransitionFiredByTimer, req, transaction)
+		if err != nil {
+			log.Infof("Timed event [%v] on [%v][%v] not applied: %v", event.Name, typeName, id, err)
+			rollbackErr := transaction.Rollback()
+			CheckErr(rollbackErr, "Failed to rollback")
+			continue
+		}
+		err = transaction.Commit()
+		CheckErr(err, "Failed to commit timed event [%v] on [%v]", event.Name, id)
+		BumpChangedTables(req.PlainRequest.Context())
+		log.Infof("Timed event [%v] moved [%v][%v] to [%v]", event.Name, typeName, id, nextState)
+	}
+}
+
+// stateOwnerRequest is a request made by the owner of a state row, the actions of timed
+// transitions run as that user
+func stateOwnerRequest(dbResource *DbResource, typeName string, stateReferenceId daptinid.DaptinReferenceId, transaction *sqlx.Tx) *http.Request {
+	sessionUser := &auth.SessionUser{}
+	s, v, err := statementbuilder.Squirrel.Select(USER_ACCOUNT_ID_COLUMN).Prepared(true).From(typeName + "_state").
+		Where(goqu.Ex{"reference_id": stateReferenceId[:]}).ToSQL()
+	if err == nil {
+		var ownerId interface{}
+		err = transaction.QueryRowx(s, v...).Scan(&ownerId)
+		if err == nil && ownerId != nil {
+			owner, err := dbResource.GetObjectByWhereClause(USER_ACCOUNT_TABLE_NAME, "id", toInt64(ownerId), transaction)
+			if err == nil && owner["reference_id"] != nil {
+				ownerReferenceId := daptinid.InterfaceToDIR(owner["reference_id"])
+				sessionUser.UserReferenceId = ownerReferenceId
+				sessionUser.UserId = toInt64(ownerId)
+				sessionUser.Groups = dbResource.GetObjectUserGroupsByWhereWithTransaction(USER_ACCOUNT_TABLE_NAME, transaction, "reference_id", ownerReferenceId[:])
+			}
+		}
+	}
+	CheckErr(err, "Failed to load owner of [%v][%v]", typeName, stateReferenceId)
+
+	pr := &http.Request{Method: "EXECUTE", URL: &url.URL{Path: "/track/event/" + typeName}}
+	return pr.WithContext(context.WithValue(WithTableChanges(context.Background()), "user", sessionUser))
+}
+
+// StateCounts counts the objects of typeName in each state of the machine machineId, states of
+// the machine without objects are counted as 0
+func (dbResource *DbResource) StateCounts(typeName string, machine fsm.LoopbookFsmDescription, machineId int64, transaction *sqlx.Tx) (map[string]int64, error) {
+	counts := make(map[string]int64)
+	for _, name := range machine.StateNames() {
+		counts[name] = 0
+	}
+
+	query := statementbuilder.Squirrel.Select(goqu.C("current_state"), goqu.COUNT("*")).Prepared(true).
+		From(typeName + "_state").Where(goqu.Ex{typeName + "_smd": machineId})
+	if subject, ok := dbResource.Cruds[typeName]; ok && subject.tableInfo != nil && subject.tableInfo.SoftDelete {
+		query = query.Where(goqu.C("is_state_of_" + typeName).In(
+			statementbuilder.Squirrel.Select("id").From(typeName).Where(goqu.Ex{SoftDeleteColumnName: nil})))
+	}
+	s, v, err := query.GroupBy(goqu.C("current_state")).ToSQL()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := transaction.Queryx(s, v...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var state interface{}
+		var count int64
+		err = rows.Scan(&state, &count)
+		if err != nil {
+			return nil, err
+		}
+		counts[stringColumnValue(state)] += count
+	}
+	return counts, rows.Err()
+}
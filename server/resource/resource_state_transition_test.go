@@ -0,0 +1,233 @@
+package resource
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/artpar/api2go/v2"
+	"github.com/daptin/daptin/server/auth"
+	daptinid "github.com/daptin/daptin/server/id"
+	"github.com/daptin/daptin/server/table_info"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestStateTransitionGuardActionsAndTimers(t *testing.T) {
+	db, err := sqlx.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	for _, statement := range []string{
+		`create table smd (
+			id integer primary key,
+			reference_id blob not null unique,
+			name text,
+			label text,
+			initial_state text,
+			events text,
+			states text
+		)`,
+		`create table ticket (
+			id integer primary key,
+			reference_id blob not null unique,
+			priority integer,
+			permission integer,
+			version integer
+		)`,
+		`create table ticket_state (
+			id integer primary key,
+			reference_id blob not null unique,
+			current_state text,
+			version integer,
+			permission integer,
+			user_account_id integer,
+			is_state_of_ticket integer,
+			ticket_smd integer,
+			created_at timestamp,
+			updated_at timestamp
+		)`,
+		`create table ticket_state_transition (
+			id integer primary key,
+			reference_id blob not null unique,
+			event_name text,
+			from_state text,
+			to_state text,
+			fired_by text,
+			ticket_state_id integer,
+			user_account_id integer,
+			permission integer,
+			created_at timestamp,
+			version integer
+		)`,
+	} {
+		if _, err := db.Exec(statement); err != nil {
+			t.Fatalf("setup statement failed: %v", err)
+		}
+	}
+
+	events := `[
+		{"Name": "approve", "Src": ["open"], "Dst": "approved", "Guard": "subject.priority > 2"},
+		{"Name": "reject", "Src": ["open"], "Dst": "rejected", "Actions": [{"Type": "missing", "Action": "notify"}]},
+		{"Name": "expire", "Src": ["approved"], "Dst": "expired", "After": "1h"}
+	]`
+	ticketRef := daptinid.DaptinReferenceId(uuid.New())
+	stateRef := daptinid.DaptinReferenceId(uuid.New())
+	for _, seed := range []struct {
+		query string
+		args  []interface{}
+	}{
+		{`insert into smd (id, reference_id, name, label, initial_state, events, states) values (1, ?, 'review', 'Review', 'open', ?, '[]')`, []interface{}{uuid.New().String(), events}},
+		{`insert into ticket (id, reference_id, priority, permission, version) values (1, ?, 1, ?, 1)`, []interface{}{ticketRef[:], int64(auth.DEFAULT_PERMISSION)}},
+		{`insert into ticket_state (id, reference_id, current_state, version, permission, is_state_of_ticket, ticket_smd, created_at) values (1, ?, 'open', 1, ?, 1, 1, ?)`, []interface{}{stateRef[:], int64(auth.DEFAULT_PERMISSION), time.Now()}},
+	} {
+		if _, err := db.Exec(seed.query, seed.args...); err != nil {
+			t.Fatalf("seed failed: %v", err)
+		}
+	}
+
+	cruds := map[string]*DbResource{}
+	newCrud := func(tableName string, columns []api2go.ColumnInfo, stateTracking bool) *DbResource {
+		columns = append(columns,
+			api2go.ColumnInfo{Name: "permission", ColumnName: "permission"},
+			api2go.ColumnInfo{Name: "reference_id", ColumnName: "reference_id"},
+			api2go.ColumnInfo{Name: "version", ColumnName: "version"},
+		)
+		return &DbResource{
+			model: api2go.NewApi2GoModel(tableName, columns, int64(auth.DEFAULT_PERMISSION), nil),
+			tableInfo: &table_info.TableInfo{
+				TableName:              tableName,
+				Columns:                columns,
+				DefaultPermission:      auth.DEFAULT_PERMISSION,
+				IsStateTrackingEnabled: stateTracking,
+			},
+			connection: db,
+			ms:         &MiddlewareSet{},
+			Cruds:      cruds,
+		}
+	}
+	cruds["smd"] = newCrud("smd", []api2go.ColumnInfo{
+		{Name: "name", ColumnName: "name"},
+		{Name: "label", ColumnName: "label"},
+		{Name: "initial_state", ColumnName: "initial_state"},
+		{Name: "events", ColumnName: "events"},
+		{Name: "states", ColumnName: "states"},
+	}, false)
+	cruds["ticket"] = newCrud("ticket", []api2go.ColumnInfo{{Name: "priority", ColumnName: "priority"}}, true)
+	cruds["ticket_state"] = newCrud("ticket_state", []api2go.ColumnInfo{{Name: "current_state", ColumnName: "current_state"}}, false)
+
+	req := api2go.Request{
+		PlainRequest: (&http.Request{
+			Method: http.MethodPost,
+			URL:    &url.URL{Path: "/track/event/ticket"},
+		}).WithContext(context.WithValue(context.Background(), "user", &auth.SessionUser{})),
+	}
+
+	apply := func(eventName string) (string, error) {
+		tx := db.MustBegin()
+		nextState, err := cruds["ticket_state"].ApplyStateTransition("ticket", stateRef, eventName, StateTransitionFiredByUser, req, tx)
+		if err != nil {
+			if rollbackErr := tx.Rollback(); rollbackErr != nil {
+				t.Fatalf("rollback: %v", rollbackErr)
+			}
+			return nextState, err
+		}
+		if err := tx.Commit(); err != nil {
+			t.Fatalf("commit: %v", err)
+		}
+		return nextState, nil
+	}
+	currentState := func() string {
+		var state string
+		if err := db.QueryRow("select current_state from ticket_state where id = 1").Scan(&state); err != nil {
+			t.Fatalf("read state: %v", err)
+		}
+		return state
+	}
+
+	if _, err := apply("approve"); err == nil {
+		t.Fatalf("guard should refuse approving a low priority ticket")
+	} else if httpErr, ok := err.(api2go.HTTPError); !ok || httpErr.Status() != http.StatusBadRequest {
+		t.Fatalf("guard refusal should be a 400, got %v", err)
+	}
+	if _, err := apply("reject"); err == nil {
+		t.Fatalf("a failing action should fail the transition")
+	}
+	if state := currentState(); state != "open" {
+		t.Fatalf("refused transitions should not move the state, found %v", state)
+	}
+
+	if _, err := db.Exec("update ticket set priority = 5 where id = 1"); err != nil {
+		t.Fatalf("raise priority: %v", err)
+	}
+	nextState, err := apply("approve")
+	if err != nil || nextState != "approved" || currentState() != "approved" {
+		t.Fatalf("approve should pass the guard, got %v %v", nextState, err)
+	}
+
+	FireStateTimers(cruds)
+	if state := currentState(); state != "approved" {
+		t.Fatalf("timer fired before its time, state %v", state)
+	}
+	if _, err := db.Exec("update ticket_state set updated_at = ? where id = 1", time.Now().Add(-2*time.Hour)); err != nil {
+		t.Fatalf("age state: %v", err)
+	}
+	FireStateTimers(cruds)
+	if state := currentState(); state != "expired" {
+		t.Fatalf("timer should expire the ticket, state %v", state)
+	}
+
+	rows, err := db.Query("select event_name, from_state, to_state, fired_by from ticket_state_transition where ticket_state_id = 1 order by id")
+	if err != nil {
+		t.Fatalf("read history: %v", err)
+	}
+	defer rows.Close()
+	history := make([]string, 0)
+	for rows.Next() {
+		var eventName, from, to, firedBy string
+		if err := rows.Scan(&eventName, &from, &to, &firedBy); err != nil {
+			t.Fatalf("scan history: %v", err)
+		}
+		history = append(history, eventName+":"+from+">"+to+":"+firedBy)
+	}
+	if len(history) != 2 || history[0] != "approve:open>approved:user" || history[1] != "expire:approved>expired:timer" {
+		t.Fatalf("unexpected transition history %v", history)
+	}
+
+	machine, err := StateMachineDescriptionFromRow(map[string]interface{}{"name": "review", "initial_state": "open", "events": []byte(events)})
+	if err != nil {
+		t.Fatalf("read machine: %v", err)
+	}
+	tx := db.MustBegin()
+	counts, err := cruds["ticket_state"].StateCounts("ticket", machine, 1, tx)
+	if rollbackErr := tx.Rollback(); rollbackErr != nil {
+		t.Fatalf("rollback: %v", rollbackErr)
+	}
+	if err != nil || len(counts) != 4 || counts["expired"] != 1 || counts["open"] != 0 {
+		t.Fatalf("unexpected state counts %v %v", counts, err)
+	}
+}
+
+func TestEvaluateTransitionGuard(t *testing.T) {
+	env := map[string]interface{}{"subject": map[string]interface{}{"priority": 5, "closed": false}}
+	for guard, expected := range map[string]bool{
+		"subject.priority > 2":    true,
+		"subject.priority < 2":    false,
+		"!subject.closed":         true,
+		"!(subject.priority > 2)": false,
+	} {
+		passed, err := evaluateTransitionGuard(guard, env)
+		if err != nil {
+			t.Fatalf("guard [%v] failed: %v", guard, err)
+		}
+		if passed != expected {
+			t.Errorf("guard [%v] should give %v, got %v", guard, expected, passed)
+		}
+	}
+}
@@ -0,0 +1,290 @@
+package resource
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/artpar/api2go/v2"
+	"github.com/daptin/daptin/server/auth"
+	"github.com/daptin/daptin/server/database"
+	daptinid "github.com/daptin/daptin/server/id"
+	"github.com/daptin/daptin/server/statementbuilder"
+	"github.com/daptin/daptin/server/tenant"
+	"github.com/doug-martin/goqu/v9"
+	"github.com/jmoiron/sqlx"
+	log "github.com/sirupsen/logrus"
+)
+
+// tenantAdminPermission is what the administrator group of a tenant can do on the rows created in the
+// tenant
+var tenantAdminPermission = auth.GroupCRUD | auth.GroupExecute
+
+// requestTenant is the tenant the request is served for, nil on hostnames which are not a tenant
+func requestTenant(req api2go.Request) *tenant.Tenant {
+	return tenant.FromContext(requestContext(req))
+}
+
+// tenantRowId is the tenant_id a row of a tenant scoped table gets for the tenant, null outside tenants
+func tenantRowId(t *tenant.Tenant) interface{} {
+	if t == nil {
+		return nil
+	}
+	return t.Id
+}
+
+// tenantFilter limits the rows of a tenant scoped table to the rows of the tenant, or to the rows of
+// no tenant outside tenants. It is nil for tables which are not tenant scoped.
+func (dbResource *DbResource) tenantFilter(tableName string, t *tenant.Tenant) goqu.Expression {
+	if dbResource.tableInfo == nil || !dbResource.tableInfo.TenantScoped {
+		return nil
+	}
+	column := goqu.I(tableName + "." + TenantColumnName)
+	if t == nil {
+		return column.IsNull()
+	}
+	return column.Eq(t.Id)
+}
+
+// checkTenant fails with 404 for a row of another tenant, so a tenant cannot tell the row exists
+func (dbResource *DbResource) checkTenant(rowTenantId interface{}, referenceId interface{}, req api2go.Request) error {
+	if dbResource.tableInfo == nil || !dbResource.tableInfo.TenantScoped {
+		return nil
+	}
+	var tenantId int64
+	if t := requestTenant(req); t != nil {
+		tenantId = t.Id
+	}
+	if toInt64(rowTenantId) == tenantId {
+		return nil
+	}
+	return api2go.NewHTTPError(fmt.Errorf("[%v][%v] belongs to another tenant", dbResource.model.GetName(), referenceId), "object not found", http.StatusNotFound)
+}
+
+// RowInTenant tells whether a row of the table, like the data of a row event, belongs to the tenant.
+// Rows of tables which are not tenant scoped belong to every tenant.
+func (dbResource *DbResource) RowInTenant(row map[string]interface{}, t *tenant.Tenant) bool {
+	if dbResource.tableInfo == nil || !dbResource.tableInfo.TenantScoped {
+		return true
+	}
+	var tenantId int64
+	if t != nil {
+		tenantId = t.Id
+	}
+	return toInt64(row[TenantColumnName]) == tenantId
+}
+
+// tenantReferenceIdToId is the id of a row referenced by a write. A row of a tenant scoped table can
+// only be referenced from the same tenant, rows of other tenants are not found.
+func (dbResource *DbResource) tenantReferenceIdToId(typeName string, referenceId daptinid.DaptinReferenceId, req api2go.Request, transaction *sqlx.Tx) (int64, error) {
+	target, ok := dbResource.Cruds[typeName]
+	if !ok {
+		return GetReferenceIdToIdWithTransaction(typeName, referenceId, transaction)
+	}
+	tenantFilter := target.tenantFilter(typeName, requestTenant(req))
+	if tenantFilter == nil {
+		return GetReferenceIdToIdWithTransaction(typeName, referenceId, transaction)
+	}
+
+	s, v, err := statementbuilder.Squirrel.Select("id").Prepared(true).From(typeName).
+		Where(goqu.Ex{"reference_id": referenceId[:]}, tenantFilter).ToSQL()
+	if err != nil {
+		return 0, err
+	}
+	var id int64
+	err = transaction.QueryRowx(s, v...).Scan(&id)
+	return id, err
+}
+
+// CheckTenantTables makes user accounts tenant scoped when any table is, so every user belongs to the
+// tenant it signed up on and can be kept to the hostnames of that tenant
+func CheckTenantTables(config *CmsConfig) {
+	tenantScoped := false
+	for _, table := range config.Tables {
+		if table.TenantScoped {
+			tenantScoped = true
+			break
+		}
+	}
+	if !tenantScoped {
+		return
+	}
+	for i := range config.Tables {
+		if config.Tables[i].TableName == USER_ACCOUNT_TABLE_NAME && !config.Tables[i].TenantScoped {
+			log.Printf("Make [%v] tenant scoped for the tenant scoped tables", USER_ACCOUNT_TABLE_NAME)
+			config.Tables[i].TenantScoped = true
+		}
+	}
+}
+
+// UserTenantId is the internal id of the tenant of the user, zero for users of no tenant or when user
+// accounts are not tenant scoped
+func (dbResource *DbResource) UserTenantId(userReferenceId daptinid.DaptinReferenceId, transaction *sqlx.Tx) (int64, error) {
+	userAccount, ok := dbResource.Cruds[USER_ACCOUNT_TABLE_NAME]
+	if !ok || !userAccount.tableInfo.TenantScoped {
+		return 0, nil
+	}
+	rows, err := GetObjectByWhereClauseWithTransaction(USER_ACCOUNT_TABLE_NAME, transaction, goqu.Ex{"reference_id": userReferenceId[:]})
+	if err != nil {
+		return 0, err
+	}
+	if len(rows) == 0 {
+		return 0, nil
+	}
+	return toInt64(rows[0][TenantColumnName]), nil
+}
+
+// GetAllTenants reads the enabled tenants with their hostnames and administrator group
+func GetAllTenants(transaction *sqlx.Tx) ([]*tenant.Tenant, error) {
+	s, v, err := statementbuilder.Squirrel.Select(
+		goqu.I("t.id"), goqu.I("t.reference_id"), goqu.I("t.name"), goqu.I("t.hostnames"),
+		goqu.I("g.id").As("admin_group_id"), goqu.I("g.reference_id").As("admin_group_reference_id")).Prepared(true).
+		From(goqu.T("tenant").As("t")).
+		LeftJoin(goqu.T("usergroup").As("g"), goqu.On(goqu.I("g.id").Eq(goqu.I("t.admin_group_id")))).
+		Where(goqu.I("t.enable").IsTrue()).ToSQL()
+	if err != nil {
+		return nil, err
+	}
+
+	stmt1, err := transaction.Preparex(s)
+	if err != nil {
+		log.Errorf("[tenant] failed to prepare statement: %v", err)
+		return nil, err
+	}
+	defer stmt1.Close()
+
+	rows, err := stmt1.Queryx(v...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tenants := make([]*tenant.Tenant, 0)
+	for rows.Next() {
+		var id int64
+		var adminGroupId *int64
+		var referenceId, adminGroupReferenceId []byte
+		var name, hostnames *string
+		err = rows.Scan(&id, &referenceId, &name, &hostnames, &adminGroupId, &adminGroupReferenceId)
+		if err != nil {
+			log.Errorf("Failed to scan tenant: %v", err)
+			continue
+		}
+		t := &tenant.Tenant{
+			Id:          id,
+			ReferenceId: daptinid.InterfaceToDIR(referenceId),
+		}
+		if adminGroupId != nil && len(adminGroupReferenceId) > 0 {
+			t.AdminGroupId = *adminGroupId
+			t.AdminGroupReferenceId = daptinid.InterfaceToDIR(adminGroupReferenceId)
+		}
+		if name != nil {
+			t.Name = *name
+		}
+		if hostnames != nil {
+			t.Hostnames = tenant.ParseHostnames(*hostnames)
+		}
+		tenants = append(tenants, t)
+	}
+	return tenants, rows.Err()
+}
+
+const (
+	// tenantCheckInterval is how often the tenants are checked for changes made through the api
+	tenantCheckInterval = 5 * time.Second
+	// tenantReloadInterval is how often the tenants are read again anyway, for changes made around
+	// the api and changes to their administrator groups
+	tenantReloadInterval = time.Minute
+)
+
+// TenantRegistry resolves the tenants of hostnames from the tenant table. It reads the tenants again
+// when the table changed on any node of the cluster, so tenants are served without a restart.
+type TenantRegistry struct {
+	connection database.DatabaseConnection
+	lock       sync.RWMutex
+	hosts      tenant.HostMap
+	generation int
+	checkedAt  time.Time
+	loadedAt   time.Time
+}
+
+// NewTenantRegistry reads the enabled tenants
+func NewTenantRegistry(connection database.DatabaseConnection) (*TenantRegistry, error) {
+	registry := &TenantRegistry{
+		connection: connection,
+	}
+	return registry, registry.Reload()
+}
+
+// Reload reads the enabled tenants again
+func (registry *TenantRegistry) Reload() error {
+	generation, err := registry.tenantGeneration()
+	if err != nil {
+		log.Warnf("[tenant] failed to read the generation of the tenants: %v", err)
+	}
+	transaction, err := registry.connection.Beginx()
+	if err != nil {
+		return err
+	}
+	tenants, err := GetAllTenants(transaction)
+	transaction.Rollback()
+	if err != nil {
+		return err
+	}
+
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+	registry.hosts = tenant.NewHostMap(tenants)
+	registry.generation = generation
+	registry.loadedAt = time.Now()
+	registry.checkedAt = registry.loadedAt
+	return nil
+}
+
+// TenantForHost resolves the tenant of the hostname, after reading the tenants again if they changed
+func (registry *TenantRegistry) TenantForHost(hostName string) (*tenant.Tenant, bool) {
+	if registry.stale() {
+		CheckErr(registry.Reload(), "[tenant] failed to read the tenants")
+	}
+	registry.lock.RLock()
+	defer registry.lock.RUnlock()
+	return registry.hosts.TenantForHost(hostName)
+}
+
+// Count is the number of hostnames served for tenants
+func (registry *TenantRegistry) Count() int {
+	registry.lock.RLock()
+	defer registry.lock.RUnlock()
+	return len(registry.hosts)
+}
+
+// stale tells one caller per check interval whether the tenants changed since they were read
+func (registry *TenantRegistry) stale() bool {
+	registry.lock.Lock()
+	now := time.Now()
+	if now.Sub(registry.checkedAt) < tenantCheckInterval {
+		registry.lock.Unlock()
+		return false
+	}
+	registry.checkedAt = now
+	generation, loadedAt := registry.generation, registry.loadedAt
+	registry.lock.Unlock()
+
+	if now.Sub(loadedAt) >= tenantReloadInterval {
+		return true
+	}
+	current, err := registry.tenantGeneration()
+	return err == nil && current != generation
+}
+
+// tenantGeneration is the result cache generation of the tenant table, bumped by every write to it
+func (registry *TenantRegistry) tenantGeneration() (int, error) {
+	if OlricCache == nil {
+		return 0, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	return tableGeneration(ctx, "tenant")
+}
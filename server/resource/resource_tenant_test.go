@@ -0,0 +1,196 @@
+package resource
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/artpar/api2go/v2"
+	"github.com/daptin/daptin/server/auth"
+	daptinid "github.com/daptin/daptin/server/id"
+	"github.com/daptin/daptin/server/table_info"
+	"github.com/daptin/daptin/server/tenant"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestTenantScopedRows(t *testing.T) {
+	db, err := sqlx.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	acmeRef := daptinid.DaptinReferenceId(uuid.New())
+	globexRef := daptinid.DaptinReferenceId(uuid.New())
+	adminsRef := daptinid.DaptinReferenceId(uuid.New())
+	acmeNoteRef := daptinid.DaptinReferenceId(uuid.New())
+	globexNoteRef := daptinid.DaptinReferenceId(uuid.New())
+	sharedNoteRef := daptinid.DaptinReferenceId(uuid.New())
+	initechRef := daptinid.DaptinReferenceId(uuid.New())
+
+	for _, statement := range []struct {
+		query string
+		args  []interface{}
+	}{
+		{`create table usergroup (id integer primary key, reference_id blob not null unique, name text)`, nil},
+		{`create table tenant (id integer primary key, reference_id blob not null unique, name text, hostnames text, enable bool, admin_group_id integer)`, nil},
+		{`create table note (id integer primary key, reference_id blob not null unique, title text, permission integer, version integer, updated_at timestamp, tenant_id integer)`, nil},
+		{`insert into usergroup (id, reference_id, name) values (1, ?, 'acme admins')`, []interface{}{adminsRef[:]}},
+		{`insert into tenant (id, reference_id, name, hostnames, enable, admin_group_id) values (1, ?, 'acme', 'acme.example.com, www.acme.example.com', 1, 1)`, []interface{}{acmeRef[:]}},
+		{`insert into tenant (id, reference_id, name, hostnames, enable) values (2, ?, 'globex', 'globex.example.com', 1)`, []interface{}{globexRef[:]}},
+		{`insert into tenant (id, reference_id, name, hostnames, enable) values (3, ?, 'initech', 'initech.example.com', 0)`, []interface{}{initechRef[:]}},
+		{`insert into note (id, reference_id, title, permission, version, tenant_id) values (1, ?, 'acme', ?, 1, 1)`, []interface{}{acmeNoteRef[:], int64(auth.DEFAULT_PERMISSION)}},
+		{`insert into note (id, reference_id, title, permission, version, tenant_id) values (2, ?, 'globex', ?, 1, 2)`, []interface{}{globexNoteRef[:], int64(auth.DEFAULT_PERMISSION)}},
+		{`insert into note (id, reference_id, title, permission, version) values (3, ?, 'shared', ?, 1)`, []interface{}{sharedNoteRef[:], int64(auth.DEFAULT_PERMISSION)}},
+	} {
+		if _, err := db.Exec(statement.query, statement.args...); err != nil {
+			t.Fatalf("setup statement failed: %v", err)
+		}
+	}
+
+	tx := db.MustBegin()
+	tenants, err := GetAllTenants(tx)
+	tx.Rollback()
+	if err != nil {
+		t.Fatalf("get all tenants: %v", err)
+	}
+	if len(tenants) != 2 {
+		t.Fatalf("expected the 2 enabled tenants, got %d", len(tenants))
+	}
+	hosts := tenant.NewHostMap(tenants)
+	acme, ok := hosts.TenantForHost("WWW.acme.example.com")
+	if !ok || acme.ReferenceId != acmeRef || acme.AdminGroupId != 1 || acme.AdminGroupReferenceId != adminsRef {
+		t.Fatalf("expected acme with its admin group for its second hostname, got %+v", acme)
+	}
+	globex, _ := hosts.TenantForHost("globex.example.com")
+
+	// tenants added after the start are served once the registry reads them again
+	registry, err := NewTenantRegistry(db)
+	if err != nil {
+		t.Fatalf("new tenant registry: %v", err)
+	}
+	if _, ok := registry.TenantForHost("hooli.example.com"); ok {
+		t.Fatalf("hooli is not a tenant yet")
+	}
+	hooliRef := daptinid.DaptinReferenceId(uuid.New())
+	if _, err = db.Exec(`insert into tenant (id, reference_id, name, hostnames, enable) values (4, ?, 'hooli', 'hooli.example.com', 1)`, hooliRef[:]); err != nil {
+		t.Fatalf("insert tenant: %v", err)
+	}
+	if err = registry.Reload(); err != nil {
+		t.Fatalf("reload tenants: %v", err)
+	}
+	if hooli, ok := registry.TenantForHost("hooli.example.com"); !ok || hooli.ReferenceId != hooliRef {
+		t.Errorf("expected hooli after the reload, got %+v", hooli)
+	}
+
+	columns := []api2go.ColumnInfo{
+		{Name: "title", ColumnName: "title", ColumnType: "label"},
+		{Name: "permission", ColumnName: "permission"},
+		{Name: "reference_id", ColumnName: "reference_id"},
+		{Name: "version", ColumnName: "version"},
+		{Name: "updated_at", ColumnName: "updated_at"},
+		TenantColumn,
+	}
+	cruds := map[string]*DbResource{}
+	cruds["note"] = &DbResource{
+		model: api2go.NewApi2GoModel("note", columns, int64(auth.DEFAULT_PERMISSION), nil),
+		tableInfo: &table_info.TableInfo{
+			TableName:         "note",
+			Columns:           columns,
+			DefaultPermission: auth.DEFAULT_PERMISSION,
+			TenantScoped:      true,
+		},
+		connection: db,
+		ms:         &MiddlewareSet{},
+		Cruds:      cruds,
+	}
+	note := cruds["note"]
+
+	requestFor := func(requestTenant *tenant.Tenant) api2go.Request {
+		ctx := context.Background()
+		if requestTenant != nil {
+			ctx = tenant.WithTenant(ctx, requestTenant)
+		}
+		return api2go.Request{
+			PlainRequest: (&http.Request{
+				Method: http.MethodDelete,
+				URL:    &url.URL{Path: "/api/note"},
+			}).WithContext(ctx),
+		}
+	}
+
+	tx = db.MustBegin()
+	defer tx.Rollback()
+	for _, c := range []struct {
+		tenant *tenant.Tenant
+		ref    daptinid.DaptinReferenceId
+		found  bool
+	}{
+		{acme, acmeNoteRef, true},
+		{acme, globexNoteRef, false},
+		{acme, sharedNoteRef, false},
+		{globex, globexNoteRef, true},
+		{nil, sharedNoteRef, true},
+		{nil, acmeNoteRef, false},
+	} {
+		_, err := note.tenantReferenceIdToId("note", c.ref, requestFor(c.tenant), tx)
+		if (err == nil) != c.found {
+			t.Errorf("reference to [%v] from tenant %+v: expected found %v, got error %v", c.ref, c.tenant, c.found, err)
+		}
+	}
+
+	for _, c := range []struct {
+		tenant *tenant.Tenant
+		title  string
+	}{
+		{acme, "acme"},
+		{globex, "globex"},
+		{nil, "shared"},
+	} {
+		rows, err := note.GetRawObjectsPage("note", 10, 0, requestFor(c.tenant), tx)
+		if err != nil {
+			t.Fatalf("raw page: %v", err)
+		}
+		if len(rows) != 1 || rows[0]["title"] != c.title {
+			t.Errorf("expected only the %v note in the raw page of tenant %+v, got %v", c.title, c.tenant, rows)
+		}
+	}
+
+	// row events carry the tenant as a json number
+	if !note.RowInTenant(map[string]interface{}{TenantColumnName: float64(1)}, acme) ||
+		note.RowInTenant(map[string]interface{}{TenantColumnName: float64(1)}, globex) ||
+		note.RowInTenant(map[string]interface{}{TenantColumnName: float64(1)}, nil) ||
+		!note.RowInTenant(map[string]interface{}{TenantColumnName: nil}, nil) {
+		t.Errorf("expected row events to belong to their tenant only")
+	}
+
+	if err := note.DeleteWithoutFilters(globexNoteRef, requestFor(acme), tx); err == nil {
+		t.Errorf("a tenant should not delete the rows of another tenant")
+	}
+	if err := note.DeleteWithoutFilters(acmeNoteRef, requestFor(acme), tx); err != nil {
+		t.Errorf("a tenant should delete its own rows: %v", err)
+	}
+}
+
+func TestCheckTenantTables(t *testing.T) {
+	config := &CmsConfig{
+		Tables: []table_info.TableInfo{
+			{TableName: USER_ACCOUNT_TABLE_NAME},
+			{TableName: "note"},
+		},
+	}
+	CheckTenantTables(config)
+	if config.Tables[0].TenantScoped {
+		t.Errorf("user accounts should not be tenant scoped without tenant scoped tables")
+	}
+
+	config.Tables[1].TenantScoped = true
+	CheckTenantTables(config)
+	if !config.Tables[0].TenantScoped {
+		t.Errorf("user accounts should be tenant scoped with a tenant scoped table")
+	}
+}
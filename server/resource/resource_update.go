@@ -3,6 +3,7 @@ package resource
 import (
 	"encoding/base64"
 	"github.com/daptin/daptin/server/actionresponse"
+	"github.com/daptin/daptin/server/database"
 	daptinid "github.com/daptin/daptin/server/id"
 	jwtmiddleware "github.com/daptin/daptin/server/jwt"
 	"github.com/jmoiron/sqlx"
@@ -70,6 +71,20 @@ func (dbResource *DbResource) UpdateWithoutFilters(obj interface{}, req api2go.R
 		data.SetAttributes(attrs)
 	}
 
+	if dbResource.tableInfo.SoftDelete && data.GetColumnOriginalValue(SoftDeleteColumnName) != nil {
+		return nil, api2go.NewHTTPError(fmt.Errorf("[%v][%v] is deleted", dbResource.model.GetName(), updateObjectReferenceId), "object not found", http.StatusNotFound)
+	}
+	err = dbResource.checkTenant(data.GetColumnOriginalValue(TenantColumnName), updateObjectReferenceId, req)
+	if err != nil {
+		return nil, err
+	}
+
+	err = CheckVersionPreconditions(req, daptinid.DaptinReferenceId(updateObjectReferenceId), data.GetCurrentVersion())
+	if err != nil {
+		return nil, err
+	}
+	RecordEntityVersion(req, daptinid.DaptinReferenceId(updateObjectReferenceId), data.GetCurrentVersion())
+
 	allChanges := data.GetChanges()
 	allColumns := dbResource.model.GetColumns()
 	//log.Printf("Update object request with changes: %v", allChanges)
@@ -86,6 +101,7 @@ func (dbResource *DbResource) UpdateWithoutFilters(obj interface{}, req api2go.R
 
 	var colsList []string
 	var valsList []interface{}
+	var storedValues map[string]interface{}
 	passwordChanged := false
 	if len(allChanges) > 0 {
 		for _, col := range allColumns {
@@ -111,10 +127,22 @@ func (dbResource *DbResource) UpdateWithoutFilters(obj interface{}, req api2go.R
 				continue
 			}
 
+			if col.ColumnName == SoftDeleteColumnName {
+				continue
+			}
+
+			if col.ColumnName == TenantColumnName {
+				continue
+			}
+
 			if col.ColumnName == auth.AuthVersionColumn {
 				continue
 			}
 
+			if dbResource.isReadOnlyColumn(col.ColumnName) {
+				continue
+			}
+
 			change, ok := allChanges[col.ColumnName]
 			if !ok {
 				continue
@@ -134,7 +162,7 @@ func (dbResource *DbResource) UpdateWithoutFilters(obj interface{}, req api2go.R
 
 						valAsDir := daptinid.InterfaceToDIR(val)
 
-						foreignObjectId, err := GetReferenceIdToIdWithTransaction(col.ForeignKeyData.Namespace, valAsDir, updateTransaction)
+						foreignObjectId, err := dbResource.tenantReferenceIdToId(col.ForeignKeyData.Namespace, valAsDir, req, updateTransaction)
 						if err != nil {
 							return nil, err
 						}
@@ -418,6 +446,12 @@ func (dbResource *DbResource) UpdateWithoutFilters(obj interface{}, req api2go.R
 
 		}
 
+		storedValues = dbResource.storedComputedValues(data.GetAllAsAttributes())
+		for columnName, value := range storedValues {
+			colsList = append(colsList, columnName)
+			valsList = append(valsList, value)
+		}
+
 		colsList = append(colsList, "updated_at")
 		valsList = append(valsList, time.Now())
 
@@ -456,10 +490,17 @@ func (dbResource *DbResource) UpdateWithoutFilters(obj interface{}, req api2go.R
 			if err != nil {
 				log.Warnf("[464] Failed to inspect update rows affected [%s] [%v]: %v", query, vals, err)
 			} else if rowsAffected == 0 {
-				return nil, fmt.Errorf("failed to update %s [%s]: no rows matched current version", dbResource.model.GetName(), updateObjectReferenceId.String())
+				err = fmt.Errorf("failed to update %s [%s]: no rows matched current version", dbResource.model.GetName(), updateObjectReferenceId.String())
+				if isConditionalRequest(req, daptinid.DaptinReferenceId(updateObjectReferenceId)) {
+					return nil, api2go.NewHTTPError(err, "precondition failed", http.StatusPreconditionFailed)
+				}
+				return nil, err
 			}
+			RecordEntityVersion(req, daptinid.DaptinReferenceId(updateObjectReferenceId), data.GetNextVersion())
+			MarkTableChanged(requestContext(req), dbResource.model.GetName())
 
 		} else if len(languagePreferences) > 0 {
+			MarkTableChanged(requestContext(req), dbResource.model.GetName()+"_i18n")
 
 			for _, lang := range languagePreferences {
 
@@ -587,6 +628,7 @@ func (dbResource *DbResource) UpdateWithoutFilters(obj interface{}, req api2go.R
 				}
 				auditModel.Set("source_reference_id", updateObjectReferenceId.String())
 				auditModel.Set("operation", AuditOperationUpdate)
+				setAuditContext(&auditModel, auditAttrs["created_at"], req)
 				pr := &http.Request{
 					URL:    req.PlainRequest.URL,
 					Method: "POST",
@@ -701,7 +743,7 @@ func (dbResource *DbResource) UpdateWithoutFilters(obj interface{}, req api2go.R
 					}
 
 					subjectId := data.GetColumnOriginalValue("id")
-					objectId, err := GetReferenceIdToIdWithTransaction(rel.GetObject(), daptinid.InterfaceToDIR(item[rel.GetObjectName()]), updateTransaction)
+					objectId, err := dbResource.tenantReferenceIdToId(rel.GetObject(), daptinid.InterfaceToDIR(item[rel.GetObjectName()]), req, updateTransaction)
 					if err != nil {
 						return nil, fmt.Errorf("object not found [%v][%v]", rel.GetObject(), item[rel.GetObjectName()])
 					}
@@ -919,8 +961,8 @@ func (dbResource *DbResource) UpdateWithoutFilters(obj interface{}, req api2go.R
 						delete(item, "attributes")
 					}
 
-					subjectId, err := GetReferenceIdToIdWithTransaction(rel.GetSubject(),
-						daptinid.InterfaceToDIR(item[rel.GetSubjectName()]), updateTransaction)
+					subjectId, err := dbResource.tenantReferenceIdToId(rel.GetSubject(),
+						daptinid.InterfaceToDIR(item[rel.GetSubjectName()]), req, updateTransaction)
 					if err != nil {
 						return nil, fmt.Errorf("subject not found [%v][%v]", rel.GetSubject(), item[rel.GetSubjectName()])
 					}
@@ -1107,11 +1149,23 @@ func (dbResource *DbResource) UpdateWithoutFilters(obj interface{}, req api2go.R
 		}
 	}
 
-	return data.GetAllAsAttributes(), nil
+	updatedResource := data.GetAllAsAttributes()
+	for columnName, value := range storedValues {
+		updatedResource[columnName] = value
+	}
+	dbResource.addVirtualColumns(updatedResource)
+	return updatedResource, nil
 
 }
 
 func (dbResource *DbResource) Update(obj interface{}, req api2go.Request) (api2go.Responder, error) {
+	finish := dbResource.startOperation(&req, "update")
+	responder, err := dbResource.update(obj, req)
+	finish(err)
+	return responder, err
+}
+
+func (dbResource *DbResource) update(obj interface{}, req api2go.Request) (api2go.Responder, error) {
 	data, _ := obj.(api2go.Api2GoModel)
 	//log.Printf("Update object request: [%v][%v]", dbResource.model.GetTableName(), data.GetID())
 
@@ -1121,6 +1175,7 @@ func (dbResource *DbResource) Update(obj interface{}, req api2go.Request) (api2g
 	}
 	updateRequest = updateRequest.WithContext(req.PlainRequest.Context())
 
+	database.MarkWrite(req.PlainRequest.Context())
 	transaction, err := dbResource.Connection().Beginx()
 	defer func() {
 		err = transaction.Rollback()
@@ -1294,16 +1349,16 @@ func (dbResource *DbResource) UpdateWithTransaction(obj interface{}, req api2go.
 			Header:       req.Header,
 			Pagination:   req.Pagination,
 		}, []map[string]interface{}{updatedResource}, transaction)
+		if err != nil {
+			log.Errorf("Error from AfterUpdate middleware: %v", err)
+			return nil, err
+		}
 		if len(results) != 0 {
 			updatedResource = results[0]
 
 		} else {
 			updatedResource = nil
 		}
-
-		if err != nil {
-			log.Errorf("Error from AfterUpdate middleware: %v", err)
-		}
 	}
 	delete(updatedResource, "id")
 
@@ -0,0 +1,162 @@
+package resource
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/artpar/api2go/v2"
+	daptinid "github.com/daptin/daptin/server/id"
+)
+
+// EntityVersionContextKey is the request context key under which the http layer places an
+// *EntityVersion for FindOne and Update to record the version of the row they served
+const EntityVersionContextKey = "entity_version"
+
+// EntityVersion is the version of the row a request read or wrote, used for the ETag header
+type EntityVersion struct {
+	Version int64
+	Found   bool
+}
+
+// VersionETag is the entity tag for a row version
+func VersionETag(version int64) string {
+	return fmt.Sprintf("\"%d\"", version)
+}
+
+// RepresentationETag is the entity tag of a row version served for the query. The fields and
+// include parameters change what is served for the same version, a hash of them follows the
+// version so a response with other fields or included rows does not carry the same tag
+func RepresentationETag(version int64, query url.Values) string {
+	selection := make([]string, 0)
+	for key, values := range query {
+		if key != "include" && key != "fields" && !strings.HasPrefix(key, "fields[") {
+			continue
+		}
+		names := make([]string, 0)
+		for _, value := range values {
+			for _, name := range strings.Split(value, ",") {
+				if name = strings.TrimSpace(name); name != "" {
+					names = append(names, name)
+				}
+			}
+		}
+		sort.Strings(names)
+		selection = append(selection, key+"="+strings.Join(names, ","))
+	}
+	if len(selection) == 0 {
+		return VersionETag(version)
+	}
+	sort.Strings(selection)
+	hash := fnv.New64a()
+	hash.Write([]byte(strings.Join(selection, "&")))
+	return fmt.Sprintf("\"%d-%x\"", version, hash.Sum64())
+}
+
+// ETagMatches checks a If-Match or If-None-Match header value against a row version. The tag of
+// the version served with selected fields is a tag of the same row version and matches too. Weak
+// tags only match when weak comparison is asked for, as for If-None-Match.
+func ETagMatches(header string, version int64, weak bool) bool {
+	current := VersionETag(version)
+	representation := fmt.Sprintf("\"%d-", version)
+	return etagListMatches(header, weak, func(tag string) bool {
+		return tag == current || strings.HasPrefix(tag, representation)
+	})
+}
+
+// RepresentationETagMatches checks a If-None-Match header value against the tag of a response,
+// with weak comparison
+func RepresentationETagMatches(header string, etag string) bool {
+	return etagListMatches(header, true, func(tag string) bool {
+		return tag == etag
+	})
+}
+
+func etagListMatches(header string, weak bool, matches func(tag string) bool) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" {
+			return true
+		}
+		if strings.HasPrefix(tag, "W/") {
+			if !weak {
+				continue
+			}
+			tag = tag[2:]
+		}
+		if matches(tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// isRequestTarget tells if the row is the one named by the request url. Writes cascade to other
+// rows with the same request, the conditional headers only apply to the row the client asked for.
+func isRequestTarget(req api2go.Request, referenceId daptinid.DaptinReferenceId) bool {
+	return req.PlainRequest != nil && req.PlainRequest.URL != nil &&
+		path.Base(req.PlainRequest.URL.Path) == referenceId.String()
+}
+
+// CheckVersionPreconditions fails with 412 when the If-Match or If-None-Match header of a write
+// request does not hold for the current version of the row
+func CheckVersionPreconditions(req api2go.Request, referenceId daptinid.DaptinReferenceId, version int64) error {
+	if !isRequestTarget(req, referenceId) {
+		return nil
+	}
+	ifMatch := req.PlainRequest.Header.Get("If-Match")
+	if ifMatch != "" && !ETagMatches(ifMatch, version, false) {
+		return api2go.NewHTTPError(fmt.Errorf("current version is %d", version), "precondition failed", http.StatusPreconditionFailed)
+	}
+	ifNoneMatch := req.PlainRequest.Header.Get("If-None-Match")
+	if ifNoneMatch != "" && ETagMatches(ifNoneMatch, version, true) {
+		return api2go.NewHTTPError(fmt.Errorf("current version is %d", version), "precondition failed", http.StatusPreconditionFailed)
+	}
+	return nil
+}
+
+// isConditionalRequest tells if the write was made against a known version of the row
+func isConditionalRequest(req api2go.Request, referenceId daptinid.DaptinReferenceId) bool {
+	return isRequestTarget(req, referenceId) && (req.PlainRequest.Header.Get("If-Match") != "" || req.PlainRequest.Header.Get("If-None-Match") != "")
+}
+
+// RecordEntityVersion hands the version of the row served by this request to the http layer
+func RecordEntityVersion(req api2go.Request, referenceId daptinid.DaptinReferenceId, version interface{}) {
+	if !isRequestTarget(req, referenceId) {
+		return
+	}
+	entityVersion, ok := req.PlainRequest.Context().Value(EntityVersionContextKey).(*EntityVersion)
+	if !ok || entityVersion == nil {
+		return
+	}
+	versionNumber, err := rowVersion(version)
+	if err != nil {
+		return
+	}
+	entityVersion.Version = versionNumber
+	entityVersion.Found = true
+}
+
+func rowVersion(version interface{}) (int64, error) {
+	switch v := version.(type) {
+	case int64:
+		return v, nil
+	case int:
+		return int64(v), nil
+	case int32:
+		return int64(v), nil
+	case float64:
+		return int64(v), nil
+	case []byte:
+		return strconv.ParseInt(string(v), 10, 64)
+	case string:
+		return strconv.ParseInt(v, 10, 64)
+	}
+	return 0, errors.New("row has no version")
+}
@@ -0,0 +1,75 @@
+package resource
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/artpar/api2go/v2"
+	daptinid "github.com/daptin/daptin/server/id"
+	"github.com/google/uuid"
+)
+
+func TestCheckVersionPreconditions(t *testing.T) {
+	referenceId := daptinid.DaptinReferenceId(uuid.MustParse("0191f0a4-0000-7000-8000-000000000001"))
+	otherRow := daptinid.DaptinReferenceId(uuid.MustParse("0191f0a4-0000-7000-8000-000000000002"))
+
+	request := func(method string, header string, value string) api2go.Request {
+		plainRequest := httptest.NewRequest(method, "/api/todo/"+referenceId.String(), nil)
+		if header != "" {
+			plainRequest.Header.Set(header, value)
+		}
+		return api2go.Request{PlainRequest: plainRequest}
+	}
+
+	for _, tc := range []struct {
+		header string
+		value  string
+		fail   bool
+	}{
+		{"", "", false},
+		{"If-Match", `"3"`, false},
+		{"If-Match", `"2", "3"`, false},
+		{"If-Match", "*", false},
+		{"If-Match", `"2"`, true},
+		{"If-Match", `"3-9a0f3c1e2b4d5a6f"`, false},
+		{"If-Match", `"33"`, true},
+		{"If-Match", `"2-9a0f3c1e2b4d5a6f"`, true},
+		{"If-Match", `W/"3"`, true},
+		{"If-None-Match", `"2"`, false},
+		{"If-None-Match", `W/"3"`, true},
+		{"If-None-Match", "*", true},
+	} {
+		err := CheckVersionPreconditions(request(http.MethodPatch, tc.header, tc.value), referenceId, 3)
+		if tc.fail {
+			httpError, ok := err.(api2go.HTTPError)
+			if !ok || httpError.Status() != http.StatusPreconditionFailed {
+				t.Fatalf("%s: %s expected 412, got %v", tc.header, tc.value, err)
+			}
+		} else if err != nil {
+			t.Fatalf("%s: %s unexpected error %v", tc.header, tc.value, err)
+		}
+	}
+
+	// rows changed as a side effect of the request are not checked
+	if err := CheckVersionPreconditions(request(http.MethodPatch, "If-Match", `"2"`), otherRow, 3); err != nil {
+		t.Fatalf("precondition checked on a row the request does not name: %v", err)
+	}
+}
+
+func TestRecordEntityVersion(t *testing.T) {
+	referenceId := daptinid.DaptinReferenceId(uuid.MustParse("0191f0a4-0000-7000-8000-000000000001"))
+	entityVersion := &EntityVersion{}
+	plainRequest := httptest.NewRequest(http.MethodGet, "/api/todo/"+referenceId.String(), nil)
+	req := api2go.Request{PlainRequest: plainRequest.WithContext(context.WithValue(plainRequest.Context(), EntityVersionContextKey, entityVersion))}
+
+	RecordEntityVersion(req, referenceId, []byte("7"))
+	if !entityVersion.Found || entityVersion.Version != 7 {
+		t.Fatalf("version not recorded: %+v", entityVersion)
+	}
+	RecordEntityVersion(req, daptinid.DaptinReferenceId(uuid.MustParse("0191f0a4-0000-7000-8000-000000000002")), int64(9))
+	if entityVersion.Version != 7 {
+		t.Fatalf("version of another row recorded: %+v", entityVersion)
+	}
+}
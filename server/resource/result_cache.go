@@ -0,0 +1,222 @@
+package resource
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/buraksezer/olric"
+	"github.com/daptin/daptin/server/auth"
+	"github.com/daptin/daptin/server/telemetry"
+	"github.com/daptin/daptin/server/tenant"
+	log "github.com/sirupsen/logrus"
+)
+
+// defaultResultCacheTtl is how long a cached result is kept when the table does not set it
+const defaultResultCacheTtl = 60 * time.Second
+
+// MaxCachedResultBytes is the size of the largest response which is cached
+const MaxCachedResultBytes = 1 << 20
+
+// TableChangesContextKey holds the tables a request wrote to, their generation is bumped again once
+// the request is done and its transaction committed
+const TableChangesContextKey = "table_changes"
+
+// TableChanges are the tables written to by a request
+type TableChanges struct {
+	lock   sync.Mutex
+	tables map[string]bool
+}
+
+// WithTableChanges returns a context collecting the tables written to
+func WithTableChanges(ctx context.Context) context.Context {
+	return context.WithValue(ctx, TableChangesContextKey, &TableChanges{tables: make(map[string]bool)})
+}
+
+// tableGenerationKey is the olric key of the generation counter of a table. The counter is bumped
+// on every write to the table, cached results carry the generations they were read at.
+func tableGenerationKey(tableName string) string {
+	return "qgen-" + tableName
+}
+
+// MarkTableChanged bumps the generation of the table, so no cached result read from it before is
+// used again. A request collecting its changes bumps it again when it is done, since a result
+// cached between the write and the commit still has the rows before the write.
+func MarkTableChanged(ctx context.Context, tableName string) {
+	bumpTableGeneration(tableName)
+	if changes, ok := ctx.Value(TableChangesContextKey).(*TableChanges); ok {
+		changes.lock.Lock()
+		changes.tables[tableName] = true
+		changes.lock.Unlock()
+	}
+}
+
+// BumpChangedTables bumps the generations of the tables the request wrote to
+func BumpChangedTables(ctx context.Context) {
+	changes, ok := ctx.Value(TableChangesContextKey).(*TableChanges)
+	if !ok {
+		return
+	}
+	changes.lock.Lock()
+	defer changes.lock.Unlock()
+	for tableName := range changes.tables {
+		bumpTableGeneration(tableName)
+	}
+}
+
+func bumpTableGeneration(tableName string) {
+	if OlricCache == nil {
+		return
+	}
+	_, err := OlricCache.Incr(context.Background(), tableGenerationKey(tableName), 1)
+	CheckErr(err, "Failed to bump the generation of [%v]", tableName)
+}
+
+// TableGeneration is the generation of the table, bumped by every write to it on any node. It is
+// always 0 without the cache.
+func TableGeneration(tableName string) (int, error) {
+	if OlricCache == nil {
+		return 0, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	return tableGeneration(ctx, tableName)
+}
+
+func tableGeneration(ctx context.Context, tableName string) (int, error) {
+	value, err := OlricCache.Get(ctx, tableGenerationKey(tableName))
+	if errors.Is(err, olric.ErrKeyNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return value.Int()
+}
+
+// ResultCacheTtl is how long the list and aggregate results of the table are cached, zero when the
+// table does not cache its results
+func (dbResource *DbResource) ResultCacheTtl() time.Duration {
+	if dbResource.tableInfo == nil || dbResource.tableInfo.ResultCache == nil || !dbResource.tableInfo.ResultCache.Enabled {
+		return 0
+	}
+	if dbResource.tableInfo.ResultCache.TtlSeconds > 0 {
+		return time.Duration(dbResource.tableInfo.ResultCache.TtlSeconds) * time.Second
+	}
+	return defaultResultCacheTtl
+}
+
+// resultCacheDependencies are the tables a result of the table can be read from: the table, its
+// translations, the related tables and the join tables of its relations, and the world table
+// holding the table permissions
+func (dbResource *DbResource) resultCacheDependencies() []string {
+	tableName := dbResource.model.GetName()
+	dependencies := map[string]bool{
+		tableName:           true,
+		tableName + "_i18n": true,
+		"world":             true,
+	}
+	for _, relation := range dbResource.model.GetRelations() {
+		dependencies[relation.GetSubject()] = true
+		dependencies[relation.GetObject()] = true
+		switch relation.GetRelation() {
+		case "has_many", "has_many_and_belongs_to_many":
+			dependencies[relation.GetJoinTableName()] = true
+		}
+	}
+
+	tables := make([]string, 0, len(dependencies))
+	for dependency := range dependencies {
+		tables = append(tables, dependency)
+	}
+	sort.Strings(tables)
+	return tables
+}
+
+// ResultCacheKey is the key of a cached result of the table: the kind of request, its query
+// parameters in a stable order, the tenant, the user and the groups it reads as, and the generation
+// of every table the result depends on. The second value is false when results cannot be cached.
+func (dbResource *DbResource) ResultCacheKey(ctx context.Context, kind string, query url.Values, sessionUser *auth.SessionUser) (string, bool) {
+	if OlricCache == nil {
+		return "", false
+	}
+
+	var key strings.Builder
+	key.WriteString(kind + "|" + dbResource.model.GetName() + "|" + query.Encode() + "|")
+	if t := tenant.FromContext(ctx); t != nil {
+		key.WriteString(fmt.Sprintf("tenant=%d|", t.Id))
+	}
+
+	if sessionUser != nil {
+		key.WriteString(sessionUser.UserReferenceId.String())
+		groups := make([]string, 0, len(sessionUser.Groups))
+		for _, group := range sessionUser.Groups {
+			groups = append(groups, fmt.Sprintf("%s:%d", group.GroupReferenceId.String(), group.Permission))
+		}
+		sort.Strings(groups)
+		key.WriteString("|" + strings.Join(groups, ","))
+	}
+
+	for _, tableName := range dbResource.resultCacheDependencies() {
+		generation, err := tableGeneration(ctx, tableName)
+		if err != nil {
+			log.Warnf("Failed to read the generation of [%v], result not cached: %v", tableName, err)
+			return "", false
+		}
+		key.WriteString(fmt.Sprintf("|%s=%d", tableName, generation))
+	}
+
+	return "qres-" + GetMD5HashString(key.String()), true
+}
+
+// CachedResult is a response kept in the result cache
+type CachedResult struct {
+	Status      int
+	ContentType string
+	Body        []byte
+	StoredAt    time.Time
+}
+
+// GetCachedResult looks up a cached result of the table
+func GetCachedResult(ctx context.Context, tableName string, key string) (*CachedResult, bool) {
+	value, err := OlricCache.Get(ctx, key)
+	if err != nil {
+		if !errors.Is(err, olric.ErrKeyNotFound) {
+			log.Warnf("Failed to read cached result of [%v]: %v", tableName, err)
+		}
+		telemetry.ResultCacheLookup(tableName, "miss")
+		return nil, false
+	}
+
+	var result CachedResult
+	encoded, err := value.Byte()
+	if err == nil {
+		err = json.Unmarshal(encoded, &result)
+	}
+	if err != nil {
+		log.Warnf("Failed to read cached result of [%v]: %v", tableName, err)
+		telemetry.ResultCacheLookup(tableName, "miss")
+		return nil, false
+	}
+	telemetry.ResultCacheLookup(tableName, "hit")
+	return &result, true
+}
+
+// PutCachedResult keeps a result for the ttl of its table
+func PutCachedResult(ctx context.Context, key string, result CachedResult, ttl time.Duration) {
+	if len(result.Body) > MaxCachedResultBytes {
+		return
+	}
+	encoded, err := json.Marshal(result)
+	if err != nil {
+		CheckErr(err, "Failed to encode result to cache")
+		return
+	}
+	err = OlricCache.Put(ctx, key, encoded, olric.EX(ttl))
+	CheckErr(err, "Failed to cache result")
+}
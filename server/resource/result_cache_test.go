@@ -0,0 +1,116 @@
+package resource
+
+import (
+	"context"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/artpar/api2go/v2"
+	"github.com/daptin/daptin/server/auth"
+	daptinid "github.com/daptin/daptin/server/id"
+	"github.com/daptin/daptin/server/table_info"
+	"github.com/google/uuid"
+)
+
+func resultCacheTestResource() *DbResource {
+	relations := []api2go.TableRelation{
+		api2go.NewTableRelation("book", "belongs_to", "author"),
+		api2go.NewTableRelation("book", "has_many", "tag"),
+	}
+	return &DbResource{
+		model: api2go.NewApi2GoModel("book", nil, int64(auth.DEFAULT_PERMISSION), relations),
+		tableInfo: &table_info.TableInfo{
+			TableName:   "book",
+			ResultCache: &table_info.ResultCacheConfig{Enabled: true},
+		},
+	}
+}
+
+func TestResultCacheTtl(t *testing.T) {
+	dbResource := resultCacheTestResource()
+	if ttl := dbResource.ResultCacheTtl(); ttl != defaultResultCacheTtl {
+		t.Errorf("expected the default ttl, got %v", ttl)
+	}
+	dbResource.tableInfo.ResultCache.TtlSeconds = 5
+	if ttl := dbResource.ResultCacheTtl(); ttl != 5*time.Second {
+		t.Errorf("expected a ttl of 5s, got %v", ttl)
+	}
+	dbResource.tableInfo.ResultCache = nil
+	if ttl := dbResource.ResultCacheTtl(); ttl != 0 {
+		t.Errorf("a table without a result cache should not cache, got %v", ttl)
+	}
+}
+
+func TestResultCacheKeyWithoutCache(t *testing.T) {
+	restore := swapOlricCache(nil)
+	defer restore()
+
+	if _, ok := resultCacheTestResource().ResultCacheKey(context.Background(), "list", url.Values{}, nil); ok {
+		t.Errorf("results should not be cached without olric")
+	}
+}
+
+func TestResultCacheKeyFollowsWritesAndUsers(t *testing.T) {
+	dm, cleanup := testOlric(t, "test-result-cache")
+	defer cleanup()
+	restore := swapOlricCache(dm)
+	defer restore()
+
+	ctx := context.Background()
+	dbResource := resultCacheTestResource()
+	query := url.Values{"page[size]": []string{"10"}}
+	user := &auth.SessionUser{UserReferenceId: daptinid.DaptinReferenceId(uuid.New())}
+	otherUser := &auth.SessionUser{UserReferenceId: daptinid.DaptinReferenceId(uuid.New())}
+
+	key, ok := dbResource.ResultCacheKey(ctx, "list", query, user)
+	if !ok {
+		t.Fatalf("expected a result cache key")
+	}
+	if again, _ := dbResource.ResultCacheKey(ctx, "list", query, user); again != key {
+		t.Errorf("the same request should have the same key")
+	}
+	if other, _ := dbResource.ResultCacheKey(ctx, "list", query, otherUser); other == key {
+		t.Errorf("another user should not share the key")
+	}
+	if aggregate, _ := dbResource.ResultCacheKey(ctx, "aggregate", query, user); aggregate == key {
+		t.Errorf("an aggregate should not share the key of a list")
+	}
+
+	PutCachedResult(ctx, key, CachedResult{Status: 200, ContentType: "application/json", Body: []byte(`{"data":[]}`), StoredAt: time.Now()}, time.Minute)
+	cached, found := GetCachedResult(ctx, "book", key)
+	if !found || string(cached.Body) != `{"data":[]}` || cached.ContentType != "application/json" {
+		t.Fatalf("expected the cached result back, got %v %v", cached, found)
+	}
+
+	joinTable := api2go.NewTableRelation("book", "has_many", "tag")
+	for _, tableName := range []string{"book", "author", joinTable.GetJoinTableName(), "world"} {
+		MarkTableChanged(ctx, tableName)
+		changed, _ := dbResource.ResultCacheKey(ctx, "list", query, user)
+		if changed == key {
+			t.Errorf("a write to [%s] should change the key", tableName)
+		}
+		key = changed
+	}
+
+	MarkTableChanged(ctx, "unrelated")
+	if unchanged, _ := dbResource.ResultCacheKey(ctx, "list", query, user); unchanged != key {
+		t.Errorf("a write to an unrelated table should not change the key")
+	}
+}
+
+func TestBumpChangedTables(t *testing.T) {
+	dm, cleanup := testOlric(t, "test-result-cache-changes")
+	defer cleanup()
+	restore := swapOlricCache(dm)
+	defer restore()
+
+	ctx := WithTableChanges(context.Background())
+	MarkTableChanged(ctx, "book")
+	before, _ := tableGeneration(ctx, "book")
+	BumpChangedTables(ctx)
+	after, _ := tableGeneration(ctx, "book")
+	if after != before+1 {
+		t.Errorf("the changed table should be bumped again once the request is done, got %d then %d", before, after)
+	}
+}
@@ -0,0 +1,495 @@
+package resource
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/artpar/api2go/v2"
+	"github.com/go-gota/gota/dataframe"
+	"github.com/jmoiron/sqlx"
+)
+
+// default size of the pages the right hand side of a join transformation is read in
+const streamJoinPageSize = 1000
+
+// streamStringList reads a list of column names from a transformation attribute, which is a
+// []string when the contract is defined in code and a []interface{} once it went through json
+func streamStringList(value interface{}) []string {
+	switch v := value.(type) {
+	case []string:
+		return v
+	case []interface{}:
+		names := make([]string, 0, len(v))
+		for _, item := range v {
+			names = append(names, fmt.Sprintf("%v", item))
+		}
+		return names
+	case string:
+		names := make([]string, 0)
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, name)
+			}
+		}
+		return names
+	}
+	return []string{}
+}
+
+func streamStringAttribute(attributes map[string]interface{}, name string) string {
+	value, ok := attributes[name].(string)
+	if !ok {
+		return ""
+	}
+	return strings.TrimSpace(value)
+}
+
+// reloadStreamRows builds a dataframe again after a transformation worked on the rows directly.
+// LoadMaps refuses an empty list, an empty result keeps the (now empty) frame it came from
+func reloadStreamRows(df dataframe.DataFrame, rows []map[string]interface{}) dataframe.DataFrame {
+	if len(rows) == 0 {
+		return df.Subset([]int{})
+	}
+	return dataframe.LoadMaps(rows)
+}
+
+// joinStreamRows joins the rows with the rows of another entity or stream. The "LocalColumn" of
+// the stream is matched against the "ForeignColumn" (reference_id by default) of the other side,
+// every other column of the other side is added with a prefix ("<entity>_" by default)
+func (dr *StreamProcessor) joinStreamRows(df dataframe.DataFrame, attributes map[string]interface{},
+	req api2go.Request, transaction *sqlx.Tx) (dataframe.DataFrame, error) {
+
+	entityName := streamStringAttribute(attributes, "Entity")
+	streamName := streamStringAttribute(attributes, "Stream")
+	localColumn := streamStringAttribute(attributes, "LocalColumn")
+	foreignColumn := streamStringAttribute(attributes, "ForeignColumn")
+	if foreignColumn == "" {
+		foreignColumn = "reference_id"
+	}
+	if localColumn == "" {
+		return df, fmt.Errorf("join in stream [%v] needs a LocalColumn", dr.contract.StreamName)
+	}
+	joinType := strings.ToLower(streamStringAttribute(attributes, "Type"))
+	if joinType == "" {
+		joinType = "inner"
+	}
+	if joinType != "inner" && joinType != "left" {
+		return df, fmt.Errorf("join type [%v] in stream [%v] is not supported, use inner or left", joinType, dr.contract.StreamName)
+	}
+	pageSize := streamJoinPageSize
+	if size := toInt64(attributes["PageSize"]); size > 0 {
+		pageSize = int(size)
+	}
+
+	if df.Nrow() == 0 {
+		return df, nil
+	}
+	hasLocalColumn := false
+	for _, name := range df.Names() {
+		hasLocalColumn = hasLocalColumn || name == localColumn
+	}
+	if !hasLocalColumn {
+		return df, fmt.Errorf("join in stream [%v]: no column [%v] to join on", dr.contract.StreamName, localColumn)
+	}
+
+	var find func(api2go.Request, *sqlx.Tx) (uint, api2go.Responder, error)
+	var otherName string
+	query := ""
+	switch {
+	case entityName != "" && streamName != "":
+		return df, fmt.Errorf("join in stream [%v] can use either an Entity or a Stream, not both", dr.contract.StreamName)
+	case entityName != "":
+		crud, ok := dr.cruds[entityName]
+		if !ok {
+			return df, fmt.Errorf("join in stream [%v] refers to unknown entity [%v]", dr.contract.StreamName, entityName)
+		}
+		otherName = entityName
+		find = crud.PaginatedFindAllWithTransaction
+		// an entity is read for the keys of these rows only, foreign key columns are filtered by a
+		// single reference id so the other side is read in full for those
+		column, ok := crud.TableInfo().GetColumnByName(foreignColumn)
+		if ok && !column.IsForeignKey {
+			var err error
+			if query, err = streamJoinKeysQuery(df, localColumn, foreignColumn); err != nil {
+				return df, err
+			}
+		}
+	case streamName != "":
+		other, ok := dr.streams[streamName]
+		if !ok {
+			return df, fmt.Errorf("join in stream [%v] refers to unknown stream [%v]", dr.contract.StreamName, streamName)
+		}
+		if other.joinsStream(dr.contract.StreamName, map[string]bool{}) {
+			return df, fmt.Errorf("stream [%v] and [%v] join each other", dr.contract.StreamName, streamName)
+		}
+		otherName = streamName
+		find = other.PaginatedFindAllWithTransaction
+	default:
+		return df, fmt.Errorf("join in stream [%v] needs an Entity or a Stream", dr.contract.StreamName)
+	}
+
+	results, err := findAllStreamJoinRows(find, req, query, pageSize, transaction)
+	if err != nil {
+		return df, err
+	}
+
+	prefix, ok := attributes["Prefix"].(string)
+	if !ok {
+		prefix = otherName + "_"
+	}
+	columns := streamStringList(attributes["Columns"])
+
+	otherRows := make([]map[string]interface{}, 0)
+	for _, result := range results {
+		attrs := result.GetAttributes()
+		key, ok := attrs[foreignColumn]
+		if !ok {
+			return df, fmt.Errorf("join in stream [%v]: [%v] has no column [%v]", dr.contract.StreamName, otherName, foreignColumn)
+		}
+		row := map[string]interface{}{
+			localColumn: key,
+		}
+		if len(columns) > 0 {
+			for _, column := range columns {
+				row[prefix+column] = attrs[column]
+			}
+		} else {
+			for column, value := range attrs {
+				if column == foreignColumn || strings.HasPrefix(column, "__") {
+					continue
+				}
+				row[prefix+column] = value
+			}
+		}
+		otherRows = append(otherRows, row)
+	}
+
+	if len(otherRows) == 0 {
+		if joinType == "left" {
+			return df, nil
+		}
+		return df.Subset([]int{}), nil
+	}
+
+	other := dataframe.LoadMaps(otherRows)
+	if joinType == "left" {
+		df = df.LeftJoin(other, localColumn)
+	} else {
+		df = df.InnerJoin(other, localColumn)
+	}
+	return df, df.Err
+}
+
+// streamJoinKeysQuery builds the query selecting the rows of the other side of a join whose
+// foreignColumn holds one of the values of localColumn
+func streamJoinKeysQuery(df dataframe.DataFrame, localColumn string, foreignColumn string) (string, error) {
+	keys := make([]interface{}, 0)
+	seen := make(map[string]bool)
+	for _, key := range df.Col(localColumn).Records() {
+		if key == "" || key == "NaN" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		keys = append(keys, key)
+	}
+	query, err := json.Marshal([]Query{{ColumnName: foreignColumn, Operator: "in", Value: keys}})
+	return string(query), err
+}
+
+// findAllStreamJoinRows reads every page of the other side of a join. The page count follows the
+// total of the other side, a stream can return fewer rows than the page size before its last page
+func findAllStreamJoinRows(find func(api2go.Request, *sqlx.Tx) (uint, api2go.Responder, error),
+	req api2go.Request, query string, pageSize int, transaction *sqlx.Tx) ([]api2go.Api2GoModel, error) {
+
+	rows := make([]api2go.Api2GoModel, 0)
+	for pageNumber := 1; ; pageNumber++ {
+		queryParams := map[string][]string{
+			"page[size]":   {strconv.Itoa(pageSize)},
+			"page[number]": {strconv.Itoa(pageNumber)},
+		}
+		if query != "" {
+			queryParams["query"] = []string{query}
+		}
+		totalCount, responder, err := find(api2go.Request{
+			PlainRequest: req.PlainRequest,
+			QueryParams:  queryParams,
+		}, transaction)
+		if err != nil {
+			return nil, err
+		}
+		results, _ := responder.Result().([]api2go.Api2GoModel)
+		rows = append(rows, results...)
+		if uint(pageNumber*pageSize) >= totalCount {
+			return rows, nil
+		}
+	}
+}
+
+// joinsStream reports if this stream, directly or through other streams, joins the named stream
+func (dr *StreamProcessor) joinsStream(name string, seen map[string]bool) bool {
+	if dr.contract.StreamName == name {
+		return true
+	}
+	if seen[dr.contract.StreamName] {
+		return false
+	}
+	seen[dr.contract.StreamName] = true
+	for _, transformation := range dr.contract.Transformations {
+		if transformation.Operation != "join" {
+			continue
+		}
+		other, ok := dr.streams[streamStringAttribute(transformation.Attributes, "Stream")]
+		if ok && other.joinsStream(name, seen) {
+			return true
+		}
+	}
+	return false
+}
+
+// computeStreamColumn adds the column "ColumnName" by evaluating "Expression" for every row. The
+// expression is evaluated like an action outcome attribute: "!price * quantity" runs javascript
+// with the columns of the row as variables, "$first_name $last_name" fills in the values
+func computeStreamColumn(df dataframe.DataFrame, attributes map[string]interface{}) (dataframe.DataFrame, error) {
+	columnName := streamStringAttribute(attributes, "ColumnName")
+	expression, _ := attributes["Expression"].(string)
+	if columnName == "" || expression == "" {
+		return df, fmt.Errorf("computed column needs a ColumnName and an Expression")
+	}
+
+	rows := df.Maps()
+	for _, row := range rows {
+		env := make(map[string]interface{}, len(row)+1)
+		for key, value := range row {
+			env[key] = value
+		}
+		env["row"] = row
+		value, err := EvaluateString(expression, env)
+		if err != nil {
+			return df, fmt.Errorf("failed to compute column [%v]: %v", columnName, err)
+		}
+		row[columnName] = value
+	}
+	return reloadStreamRows(df, rows), nil
+}
+
+// streamAggregation is one entry of the "Aggregations" list of a groupby transformation
+type streamAggregation struct {
+	column   string
+	function string
+	as       string
+}
+
+var streamAggregationFunctions = map[string]bool{
+	"count": true, "sum": true, "avg": true, "min": true, "max": true, "first": true, "last": true,
+}
+
+func parseStreamAggregations(value interface{}) ([]streamAggregation, error) {
+	list, ok := value.([]interface{})
+	if !ok {
+		if maps, isMaps := value.([]map[string]interface{}); isMaps {
+			for _, item := range maps {
+				list = append(list, item)
+			}
+		}
+	}
+	aggregations := make([]streamAggregation, 0, len(list))
+	for _, item := range list {
+		spec, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("invalid aggregation [%v]", item)
+		}
+		aggregation := streamAggregation{
+			column:   streamStringAttribute(spec, "Column"),
+			function: strings.ToLower(streamStringAttribute(spec, "Function")),
+			as:       streamStringAttribute(spec, "As"),
+		}
+		if !streamAggregationFunctions[aggregation.function] {
+			return nil, fmt.Errorf("unknown aggregation function [%v]", aggregation.function)
+		}
+		if aggregation.column == "" && aggregation.function != "count" {
+			return nil, fmt.Errorf("aggregation [%v] needs a Column", aggregation.function)
+		}
+		if aggregation.as == "" {
+			aggregation.as = aggregation.function
+			if aggregation.column != "" {
+				aggregation.as = aggregation.function + "_" + aggregation.column
+			}
+		}
+		aggregations = append(aggregations, aggregation)
+	}
+	return aggregations, nil
+}
+
+// groupStreamRows collapses the rows sharing the values of "Columns" into one row holding those
+// columns and one column for each of the "Aggregations"
+func groupStreamRows(df dataframe.DataFrame, attributes map[string]interface{}) (dataframe.DataFrame, error) {
+	groupColumns := streamStringList(attributes["Columns"])
+	aggregations, err := parseStreamAggregations(attributes["Aggregations"])
+	if err != nil {
+		return df, err
+	}
+	if len(aggregations) == 0 {
+		aggregations = []streamAggregation{{function: "count", as: "count"}}
+	}
+
+	type group struct {
+		row    map[string]interface{}
+		counts []int
+		sums   []float64
+	}
+	groups := make([]*group, 0)
+	groupsByKey := make(map[string]*group)
+
+	for _, row := range df.Maps() {
+		values := make([]interface{}, len(groupColumns))
+		for i, column := range groupColumns {
+			values[i] = row[column]
+		}
+		key := aggregateGroupKey(values)
+		current, ok := groupsByKey[key]
+		if !ok {
+			current = &group{
+				row:    make(map[string]interface{}),
+				counts: make([]int, len(aggregations)),
+				sums:   make([]float64, len(aggregations)),
+			}
+			for i, column := range groupColumns {
+				current.row[column] = values[i]
+			}
+			groupsByKey[key] = current
+			groups = append(groups, current)
+		}
+
+		for i, aggregation := range aggregations {
+			if aggregation.function == "count" && aggregation.column == "" {
+				current.counts[i]++
+				continue
+			}
+			value, ok := row[aggregation.column]
+			if !ok {
+				return df, fmt.Errorf("cannot aggregate unknown column [%v]", aggregation.column)
+			}
+			if value == nil {
+				continue
+			}
+			seen := current.counts[i] > 0
+			current.counts[i]++
+			switch aggregation.function {
+			case "sum", "avg":
+				number, isNumber := aggregateNumber(value)
+				if !isNumber {
+					return df, fmt.Errorf("cannot %v non numeric value [%v] of column [%v]", aggregation.function, value, aggregation.column)
+				}
+				current.sums[i] += number
+			case "min":
+				if !seen || compareAggregateValues(value, current.row[aggregation.as]) < 0 {
+					current.row[aggregation.as] = value
+				}
+			case "max":
+				if !seen || compareAggregateValues(value, current.row[aggregation.as]) > 0 {
+					current.row[aggregation.as] = value
+				}
+			case "first":
+				if !seen {
+					current.row[aggregation.as] = value
+				}
+			case "last":
+				current.row[aggregation.as] = value
+			}
+		}
+	}
+
+	rows := make([]map[string]interface{}, 0, len(groups))
+	for _, current := range groups {
+		for i, aggregation := range aggregations {
+			switch aggregation.function {
+			case "count":
+				current.row[aggregation.as] = current.counts[i]
+			case "sum":
+				current.row[aggregation.as] = current.sums[i]
+			case "avg":
+				if current.counts[i] > 0 {
+					current.row[aggregation.as] = current.sums[i] / float64(current.counts[i])
+				} else {
+					current.row[aggregation.as] = nil
+				}
+			}
+		}
+		rows = append(rows, current.row)
+	}
+	return reloadStreamRows(df, rows), nil
+}
+
+// sortStreamRows orders the rows by "Columns", a column prefixed with "-" sorts descending. The
+// dataframe holds every value as text, so the rows are compared as numbers where they parse as one
+func sortStreamRows(df dataframe.DataFrame, attributes map[string]interface{}) (dataframe.DataFrame, error) {
+	names := make(map[string]bool)
+	for _, name := range df.Names() {
+		names[name] = true
+	}
+	keys := make([]aggregateSortKey, 0)
+	for _, column := range streamStringList(attributes["Columns"]) {
+		if strings.HasPrefix(column, "-") {
+			keys = append(keys, aggregateSortKey{column: column[1:], descending: true})
+		} else {
+			keys = append(keys, aggregateSortKey{column: strings.TrimPrefix(column, "+")})
+		}
+	}
+	if len(keys) == 0 || df.Nrow() == 0 {
+		return df, nil
+	}
+	for _, key := range keys {
+		if !names[key.column] {
+			return df, fmt.Errorf("cannot sort on unknown column [%v]", key.column)
+		}
+	}
+	rows := df.Maps()
+	sort.SliceStable(rows, func(i, j int) bool {
+		return compareAggregateRows(rows[i], rows[j], keys) < 0
+	})
+	return reloadStreamRows(df, rows), nil
+}
+
+// limitStreamRows keeps at most "Count" rows, after skipping "Offset" rows
+func limitStreamRows(df dataframe.DataFrame, attributes map[string]interface{}) dataframe.DataFrame {
+	offset := int(toInt64(attributes["Offset"]))
+	if offset < 0 {
+		offset = 0
+	}
+	end := df.Nrow()
+	if count, ok := attributes["Count"]; ok && offset+int(toInt64(count)) < end {
+		end = offset + int(toInt64(count))
+	}
+	indexes := make([]int, 0)
+	for i := offset; i < end; i++ {
+		indexes = append(indexes, i)
+	}
+	return df.Subset(indexes)
+}
+
+// distinctStreamRows drops the rows repeating the values of "Columns" (all columns when not set),
+// the first row of every combination is kept
+func distinctStreamRows(df dataframe.DataFrame, attributes map[string]interface{}) dataframe.DataFrame {
+	columns := streamStringList(attributes["Columns"])
+	if len(columns) == 0 {
+		columns = df.Names()
+		sort.Strings(columns)
+	}
+	seen := make(map[string]bool)
+	indexes := make([]int, 0)
+	for i, row := range df.Maps() {
+		values := make([]interface{}, len(columns))
+		for j, column := range columns {
+			values[j] = row[column]
+		}
+		key := aggregateGroupKey(values)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		indexes = append(indexes, i)
+	}
+	return df.Subset(indexes)
+}
@@ -0,0 +1,137 @@
+package resource
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/artpar/api2go/v2"
+	"github.com/go-gota/gota/dataframe"
+	"github.com/jmoiron/sqlx"
+)
+
+func TestStreamTransformations(t *testing.T) {
+	df := dataframe.LoadMaps([]map[string]interface{}{
+		{"region": "north", "product": "apple", "price": 2, "quantity": 10},
+		{"region": "north", "product": "pear", "price": 3, "quantity": 5},
+		{"region": "south", "product": "apple", "price": 2, "quantity": 4},
+		{"region": "south", "product": "apple", "price": 2, "quantity": 4},
+		{"region": "east", "product": "plum", "price": 5, "quantity": 1},
+	})
+
+	df, err := computeStreamColumn(df, map[string]interface{}{"ColumnName": "revenue", "Expression": "!price * quantity"})
+	if err != nil {
+		t.Fatalf("computed column: %v", err)
+	}
+	if revenue := df.Col("revenue").Float(); revenue[0] != 20 || revenue[4] != 5 {
+		t.Fatalf("unexpected computed revenue %v", revenue)
+	}
+
+	if distinct := distinctStreamRows(df, map[string]interface{}{}); distinct.Nrow() != 4 {
+		t.Fatalf("expected 4 distinct rows, got %v", distinct.Nrow())
+	}
+	if distinct := distinctStreamRows(df, map[string]interface{}{"Columns": []interface{}{"product"}}); distinct.Nrow() != 3 {
+		t.Fatalf("expected 3 distinct products, got %v", distinct.Nrow())
+	}
+
+	grouped, err := groupStreamRows(df, map[string]interface{}{
+		"Columns": []interface{}{"region"},
+		"Aggregations": []interface{}{
+			map[string]interface{}{"Column": "revenue", "Function": "sum", "As": "total"},
+			map[string]interface{}{"Function": "count"},
+			map[string]interface{}{"Column": "product", "Function": "max"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("groupby: %v", err)
+	}
+	// the dataframe holds the values as text, "35" has to sort above "16" and "5" all the same
+	grouped, err = sortStreamRows(grouped, map[string]interface{}{"Columns": []interface{}{"-total"}})
+	if err != nil {
+		t.Fatalf("sort: %v", err)
+	}
+	rows := grouped.Maps()
+	if len(rows) != 3 || rows[0]["region"] != "north" || rows[0]["total"] != "35" || rows[0]["count"] != "2" ||
+		rows[0]["max_product"] != "pear" || rows[1]["region"] != "south" || rows[2]["total"] != "5" {
+		t.Fatalf("unexpected grouped rows %v", rows)
+	}
+	if _, err := sortStreamRows(grouped, map[string]interface{}{"Columns": "missing"}); err == nil {
+		t.Errorf("sorting on an unknown column should fail")
+	}
+
+	limited := limitStreamRows(grouped, map[string]interface{}{"Offset": float64(1), "Count": float64(1)}).Maps()
+	if len(limited) != 1 || limited[0]["region"] != "south" {
+		t.Fatalf("unexpected limited rows %v", limited)
+	}
+	if empty := limitStreamRows(grouped, map[string]interface{}{"Count": 0}); empty.Nrow() != 0 {
+		t.Fatalf("expected no rows with a zero count, got %v", empty.Nrow())
+	}
+
+	for _, invalid := range []map[string]interface{}{
+		{"Aggregations": []interface{}{map[string]interface{}{"Column": "revenue", "Function": "median"}}},
+		{"Aggregations": []interface{}{map[string]interface{}{"Function": "sum"}}},
+		{"Aggregations": []interface{}{map[string]interface{}{"Column": "product", "Function": "sum"}}},
+	} {
+		if _, err := groupStreamRows(df, invalid); err == nil {
+			t.Errorf("groupby %v should be rejected", invalid)
+		}
+	}
+}
+
+func TestStreamJoinCycle(t *testing.T) {
+	joining := func(name, other string) StreamContract {
+		return StreamContract{
+			StreamName: name,
+			Transformations: []Transformation{
+				{Operation: "join", Attributes: map[string]interface{}{"Stream": other, "LocalColumn": "id"}},
+			},
+		}
+	}
+	processors := NewStreamProcessors([]StreamContract{
+		joining("orders", "customers"),
+		joining("customers", "regions"),
+		joining("regions", "orders"),
+		{StreamName: "products"},
+	}, nil)
+
+	if !processors[1].joinsStream("orders", map[string]bool{}) {
+		t.Errorf("customers joins orders through regions")
+	}
+	if processors[0].joinsStream("products", map[string]bool{}) {
+		t.Errorf("orders does not join products")
+	}
+}
+
+func TestStreamJoinReadsEveryPage(t *testing.T) {
+	df := dataframe.LoadMaps([]map[string]interface{}{
+		{"id": "1", "customer": "c1"},
+		{"id": "2", "customer": "c2"},
+		{"id": "3", "customer": "c1"},
+	})
+	query, err := streamJoinKeysQuery(df, "customer", "code")
+	if err != nil {
+		t.Fatalf("keys query: %v", err)
+	}
+	if query != `[{"column":"code","operator":"in","value":["c1","c2"]}]` {
+		t.Errorf("unexpected keys query %v", query)
+	}
+
+	requested := make([]string, 0)
+	find := func(req api2go.Request, transaction *sqlx.Tx) (uint, api2go.Responder, error) {
+		requested = append(requested, req.QueryParams["page[number]"][0])
+		if req.QueryParams["query"][0] != query {
+			t.Errorf("page %v was read without the keys query", req.QueryParams["page[number]"][0])
+		}
+		page := []api2go.Api2GoModel{
+			api2go.NewApi2GoModelWithData("customer", nil, 0, nil, map[string]interface{}{"code": "c1"}),
+			api2go.NewApi2GoModelWithData("customer", nil, 0, nil, map[string]interface{}{"code": "c2"}),
+		}
+		return 5, NewResponse(nil, page, 200, nil), nil
+	}
+	rows, err := findAllStreamJoinRows(find, api2go.Request{}, query, 2, nil)
+	if err != nil {
+		t.Fatalf("find join rows: %v", err)
+	}
+	if len(rows) != 6 || strings.Join(requested, ",") != "1,2,3" {
+		t.Errorf("expected pages 1 to 3 to be read, read %v with %v rows", requested, len(rows))
+	}
+}
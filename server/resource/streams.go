@@ -5,11 +5,14 @@ import (
 	"github.com/artpar/api2go/v2"
 	"github.com/go-gota/gota/dataframe"
 	"github.com/go-gota/gota/series"
+	"github.com/jmoiron/sqlx"
+	log "github.com/sirupsen/logrus"
 )
 
 // StreamProcess handles the Read operations, and applies transformations on the data the create a new view
 type StreamProcessor struct {
 	cruds    map[string]*DbResource
+	streams  map[string]*StreamProcessor
 	contract StreamContract
 }
 
@@ -70,6 +73,31 @@ func (dr *StreamProcessor) Update(obj interface{}, req api2go.Request) (api2go.R
 // FindAll does the initial query to the database and applites the transformation contract on the result rows
 func (dr *StreamProcessor) PaginatedFindAll(req api2go.Request) (totalCount uint, response api2go.Responder, err error) {
 
+	rootEntity, ok := dr.cruds[dr.contract.RootEntityName]
+	if !ok {
+		return 0, nil, fmt.Errorf("unknown root entity [%v] for stream [%v]", dr.contract.RootEntityName, dr.contract.StreamName)
+	}
+
+	transaction, err := rootEntity.BeginReadTransaction(requestContext(req))
+	if err != nil {
+		CheckErr(err, "Failed to begin transaction [%v]", dr.contract.StreamName)
+		return 0, nil, err
+	}
+
+	totalCount, response, err = dr.PaginatedFindAllWithTransaction(req, transaction)
+	if err != nil {
+		rollbackErr := transaction.Rollback()
+		log.Tracef("Rollback stream transaction [%v]: %v", dr.contract.StreamName, rollbackErr)
+		return totalCount, response, err
+	}
+
+	return totalCount, response, transaction.Commit()
+}
+
+// PaginatedFindAllWithTransaction reads the root entity and the joined entities and streams using the
+// given transaction, so a stream can be read from inside an action
+func (dr *StreamProcessor) PaginatedFindAllWithTransaction(req api2go.Request, transaction *sqlx.Tx) (totalCount uint, response api2go.Responder, err error) {
+
 	contract := dr.contract
 	queryParams := make(map[string]interface{})
 
@@ -84,6 +112,9 @@ func (dr *StreamProcessor) PaginatedFindAll(req api2go.Request) (totalCount uint
 	}
 
 	queryParameters, err := BuildActionContext(queryParams, userParams)
+	if req.QueryParams == nil {
+		req.QueryParams = make(map[string][]string)
+	}
 
 	if err != nil {
 		return 0, nil, err
@@ -106,7 +137,11 @@ func (dr *StreamProcessor) PaginatedFindAll(req api2go.Request) (totalCount uint
 		req.QueryParams[key] = arrayString
 	}
 
-	totalCount, responder1, err := dr.cruds[dr.contract.RootEntityName].PaginatedFindAll(req)
+	rootEntity, ok := dr.cruds[dr.contract.RootEntityName]
+	if !ok {
+		return 0, nil, fmt.Errorf("unknown root entity [%v] for stream [%v]", dr.contract.RootEntityName, dr.contract.StreamName)
+	}
+	totalCount, responder1, err := rootEntity.PaginatedFindAllWithTransaction(req, transaction)
 	if err != nil {
 		return 0, nil, err
 	}
@@ -197,8 +232,27 @@ func (dr *StreamProcessor) PaginatedFindAll(req api2go.Request) (totalCount uint
 
 			df = df.Filter(filter)
 
+		case "join":
+			df, err = dr.joinStreamRows(df, transformation.Attributes, req, transaction)
+		case "computed":
+			df, err = computeStreamColumn(df, transformation.Attributes)
+		case "groupby":
+			df, err = groupStreamRows(df, transformation.Attributes)
+		case "sort":
+			df, err = sortStreamRows(df, transformation.Attributes)
+		case "limit":
+			df = limitStreamRows(df, transformation.Attributes)
+		case "distinct":
+			df = distinctStreamRows(df, transformation.Attributes)
+		}
+
+		if err != nil {
+			return 0, nil, fmt.Errorf("stream [%v] %v: %v", contract.StreamName, transformation.Operation, err)
 		}
+	}
 
+	if len(items) > 0 && df.Err != nil {
+		return 0, nil, fmt.Errorf("stream [%v]: %v", contract.StreamName, df.Err)
 	}
 
 	newList := make([]api2go.Api2GoModel, 0)
@@ -260,6 +314,23 @@ func makeIndexArray(indexes []interface{}) interface{} {
 func NewStreamProcessor(stream StreamContract, cruds map[string]*DbResource) *StreamProcessor {
 	return &StreamProcessor{
 		cruds:    cruds,
+		streams:  map[string]*StreamProcessor{},
 		contract: stream,
 	}
 }
+
+// Creates the stream processors for all the contracts, the processors can join each other by name
+func NewStreamProcessors(streams []StreamContract, cruds map[string]*DbResource) []*StreamProcessor {
+	processors := make([]*StreamProcessor, 0, len(streams))
+	processorMap := make(map[string]*StreamProcessor)
+	for _, stream := range streams {
+		processor := &StreamProcessor{
+			cruds:    cruds,
+			streams:  processorMap,
+			contract: stream,
+		}
+		processorMap[stream.StreamName] = processor
+		processors = append(processors, processor)
+	}
+	return processors
+}
@@ -9,10 +9,12 @@ import (
 	daptinid "github.com/daptin/daptin/server/id"
 	"github.com/daptin/daptin/server/task"
 	"github.com/daptin/daptin/server/task_scheduler"
+	"github.com/daptin/daptin/server/telemetry"
 	"github.com/robfig/cron/v3"
 	log "github.com/sirupsen/logrus"
 	"net/http"
 	"net/url"
+	"time"
 )
 
 type DefaultTaskScheduler struct {
@@ -57,6 +59,9 @@ func (dts *DefaultTaskScheduler) StartTasks() {
 
 }
 
+// ScheduledTaskContextKey holds the reference id of the task when an action is run by the scheduler
+const ScheduledTaskContextKey = "scheduled_task"
+
 type ActiveTaskInstance struct {
 	Task          task.Task
 	ActionRequest actionresponse.ActionRequest
@@ -66,12 +71,21 @@ type ActiveTaskInstance struct {
 func (ati *ActiveTaskInstance) Run() {
 	log.Printf("[82] Execute task [%v][%v] as user [%v]", ati.Task.ReferenceId, ati.Task.ActionName, ati.Task.AsUserEmail)
 
+	start := time.Now()
+	ctx, span := telemetry.StartSpan(context.Background(), "task "+ati.Task.ActionName)
+	var runErr error
+	defer func() {
+		telemetry.SchedulerRun(ati.Task.ActionName, start, runErr)
+		telemetry.EndSpan(span, runErr)
+	}()
+
 	sessionUser := &auth.SessionUser{}
 	transaction, err := ati.DbResource.Connection().Beginx()
 	if err != nil {
 		CheckErr(err, "Failed to begin transaction for ATI.run [88]")
 	}
 	if transaction == nil {
+		runErr = err
 		return
 	}
 	defer transaction.Commit()
@@ -97,11 +111,13 @@ func (ati *ActiveTaskInstance) Run() {
 		URL:    ur,
 	}
 
-	pr := pr1.WithContext(context.WithValue(context.Background(), "user", sessionUser))
+	ctx = context.WithValue(ctx, ScheduledTaskContextKey, ati.Task.ReferenceId)
+	pr := pr1.WithContext(context.WithValue(ctx, "user", sessionUser))
 	req := api2go.Request{
 		PlainRequest: pr,
 	}
 	res, err := ati.DbResource.Cruds[ati.ActionRequest.Type].HandleActionRequest(ati.ActionRequest, req, transaction)
+	runErr = err
 
 	if err != nil {
 		transaction.Rollback()
@@ -0,0 +1,119 @@
+package resource
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/artpar/api2go/v2"
+	"github.com/buraksezer/olric"
+	"github.com/daptin/daptin/server/actionresponse"
+	"github.com/daptin/daptin/server/telemetry"
+	"github.com/jmoiron/sqlx"
+)
+
+func init() {
+	telemetry.RegisterEventPool(func() (int, uint64, uint64, uint64) {
+		if globalEventPool == nil {
+			return 0, 0, 0, 0
+		}
+		globalEventPool.metrics.mu.RLock()
+		defer globalEventPool.metrics.mu.RUnlock()
+		return len(globalEventPool.eventQueue), globalEventPool.metrics.published,
+			globalEventPool.metrics.dropped, globalEventPool.metrics.errors
+	})
+}
+
+// startOperation starts the span and the metrics of a call on this resource. The http request of
+// req is replaced by one carrying the span, so the work done for the call is traced under it.
+func (dbResource *DbResource) startOperation(req *api2go.Request, operation string) func(err error) {
+	ctx, finish := telemetry.StartOperation(requestContext(*req), dbResource.model.GetName(), operation)
+	if req.PlainRequest != nil {
+		req.PlainRequest = req.PlainRequest.WithContext(ctx)
+	}
+	return finish
+}
+
+// performAction runs the performer of an outcome in a span of its own, the http request handed to
+// the performer carries the span
+func performAction(performer actionresponse.ActionPerformerInterface, outcome actionresponse.Outcome,
+	performerFields map[string]interface{}, req api2go.Request, transaction *sqlx.Tx) (api2go.Responder, []actionresponse.ActionResponse, []error) {
+
+	ctx, finish := telemetry.StartPerformer(requestContext(req), performer.Name())
+	if req.PlainRequest != nil {
+		performerFields["httpRequest"] = req.PlainRequest.WithContext(ctx)
+	}
+	responder, responses, errs := performer.DoAction(outcome, performerFields, transaction)
+	var err error
+	if len(errs) > 0 {
+		err = errs[0]
+	}
+	finish(err)
+	return responder, responses, errs
+}
+
+// timedInterceptor records the time taken by an interceptor in the interceptor metrics
+type timedInterceptor struct {
+	DatabaseRequestInterceptor
+	operation string
+}
+
+func (ti timedInterceptor) InterceptBefore(dr *DbResource, req *api2go.Request, objects []map[string]interface{}, transaction *sqlx.Tx) ([]map[string]interface{}, error) {
+	defer telemetry.ObserveInterceptor(ti.String(), ti.operation, "before", time.Now())
+	return ti.DatabaseRequestInterceptor.InterceptBefore(dr, req, objects, transaction)
+}
+
+func (ti timedInterceptor) InterceptAfter(dr *DbResource, req *api2go.Request, objects []map[string]interface{}, transaction *sqlx.Tx) ([]map[string]interface{}, error) {
+	defer telemetry.ObserveInterceptor(ti.String(), ti.operation, "after", time.Now())
+	return ti.DatabaseRequestInterceptor.InterceptAfter(dr, req, objects, transaction)
+}
+
+func timeInterceptors(operation string, interceptors []DatabaseRequestInterceptor) []DatabaseRequestInterceptor {
+	timed := make([]DatabaseRequestInterceptor, 0, len(interceptors))
+	for _, interceptor := range interceptors {
+		timed = append(timed, timedInterceptor{DatabaseRequestInterceptor: interceptor, operation: operation})
+	}
+	return timed
+}
+
+// TimedMiddlewareSet returns the middleware set with every interceptor recording its timings
+func TimedMiddlewareSet(ms MiddlewareSet) MiddlewareSet {
+	return MiddlewareSet{
+		BeforeCreate:  timeInterceptors("create", ms.BeforeCreate),
+		BeforeFindAll: timeInterceptors("find_all", ms.BeforeFindAll),
+		BeforeFindOne: timeInterceptors("find_one", ms.BeforeFindOne),
+		BeforeUpdate:  timeInterceptors("update", ms.BeforeUpdate),
+		BeforeDelete:  timeInterceptors("delete", ms.BeforeDelete),
+		AfterCreate:   timeInterceptors("create", ms.AfterCreate),
+		AfterFindAll:  timeInterceptors("find_all", ms.AfterFindAll),
+		AfterFindOne:  timeInterceptors("find_one", ms.AfterFindOne),
+		AfterUpdate:   timeInterceptors("update", ms.AfterUpdate),
+		AfterDelete:   timeInterceptors("delete", ms.AfterDelete),
+	}
+}
+
+// meteredCache counts the hits and misses of the lookups in a cache
+type meteredCache struct {
+	olric.DMap
+}
+
+func (mc meteredCache) Get(ctx context.Context, key string) (*olric.GetResponse, error) {
+	response, err := mc.DMap.Get(ctx, key)
+	switch {
+	case err == nil:
+		telemetry.CacheLookup(mc.Name(), "hit")
+	case errors.Is(err, olric.ErrKeyNotFound):
+		telemetry.CacheLookup(mc.Name(), "miss")
+	default:
+		telemetry.CacheLookup(mc.Name(), "error")
+	}
+	return response, err
+}
+
+// MeteredCache returns the cache counting its hits and misses, nil stays nil
+func MeteredCache(dmap olric.DMap) olric.DMap {
+	if dmap == nil {
+		return nil
+	}
+	return meteredCache{DMap: dmap}
+}
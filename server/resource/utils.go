@@ -6,11 +6,35 @@ import (
 	"github.com/artpar/xlsx/v2"
 	jsoniter "github.com/json-iterator/go"
 	log "github.com/sirupsen/logrus"
+	"strconv"
 	"strings"
 )
 
 var json = jsoniter.ConfigCompatibleWithStandardLibrary
 
+// ValueToBool reads a flag as stored in a column, sent in json or in a form: a bool, a number other
+// than zero, or text strconv.ParseBool reads as true
+func ValueToBool(value interface{}) bool {
+	switch v := value.(type) {
+	case bool:
+		return v
+	case int:
+		return v != 0
+	case int32:
+		return v != 0
+	case int64:
+		return v != 0
+	case float64:
+		return v != 0
+	case []byte:
+		return ValueToBool(string(v))
+	case string:
+		parsed, err := strconv.ParseBool(strings.TrimSpace(v))
+		return err == nil && parsed
+	}
+	return false
+}
+
 func EndsWith(str string, endsWith string) (string, bool) {
 	if len(endsWith) > len(str) {
 		return "", false
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/artpar/api2go/v2"
+	daptinid "github.com/daptin/daptin/server/id"
+	"github.com/daptin/daptin/server/resource"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+// InitializeRowHistoryResources adds /api/<entity>/:id/history to every audited table
+func InitializeRowHistoryResources(cruds map[string]*resource.DbResource, defaultRouter *gin.Engine) {
+	existingRoutes := make(map[string]bool)
+	for _, route := range defaultRouter.Routes() {
+		existingRoutes[route.Method+" "+route.Path] = true
+	}
+
+	for tableName, dbResource := range cruds {
+		if dbResource.TableInfo() == nil || !dbResource.TableInfo().IsAuditEnabled {
+			continue
+		}
+		path := "/api/" + tableName + "/:id/history"
+		if existingRoutes["GET "+path] {
+			log.Warnf("[%v] has a relation named history, not adding its history endpoint", tableName)
+			continue
+		}
+		defaultRouter.GET(path, CreateRowHistoryHandler(dbResource))
+	}
+}
+
+// CreateRowHistoryHandler lists the changes made to a row of an audited table, oldest first, with
+// who made each change, when, in which request, and the values of the columns before and after it
+func CreateRowHistoryHandler(dbResource *resource.DbResource) func(*gin.Context) {
+	return func(c *gin.Context) {
+		referenceId, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid reference id"})
+			return
+		}
+
+		transaction, err := dbResource.BeginReadTransaction(c.Request.Context())
+		if err != nil {
+			resource.CheckErr(err, "Failed to begin transaction [history]")
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		defer transaction.Rollback()
+
+		history, err := dbResource.RowHistoryWithTransaction(daptinid.DaptinReferenceId(referenceId),
+			api2go.Request{PlainRequest: c.Request}, transaction)
+		if err != nil {
+			abortWithHTTPError(c, err, http.StatusInternalServerError)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"data": history,
+		})
+	}
+}
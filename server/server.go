@@ -15,6 +15,7 @@ import (
 	"github.com/daptin/daptin/server/table_info"
 	"github.com/daptin/daptin/server/task"
 	"github.com/daptin/daptin/server/task_scheduler"
+	"github.com/daptin/daptin/server/telemetry"
 	"github.com/go-redis/redis/v8"
 	"github.com/hashicorp/golang-lru"
 	"github.com/sadlil/go-trigger"
@@ -70,7 +71,7 @@ var (
 
 func Main(boxRoot http.FileSystem, db database.DatabaseConnection, localStoragePath string, olricDb *olric.EmbeddedClient, localOlricAddr string) (
 	hostswitch.HostSwitch, *guerrilla.Daemon, task_scheduler.TaskScheduler, *resource.ConfigStore, *resource.CertificateManager,
-	*server2.FtpServer, *server.Server, *olric.EmbeddedClient) {
+	*server2.FtpServer, *DaptinSftpServer, *server.Server, *olric.EmbeddedClient) {
 
 	PrintCliBanner()
 
@@ -157,6 +158,13 @@ func Main(boxRoot http.FileSystem, db database.DatabaseConnection, localStorageP
 			Stats.End(beginning, stats.WithRecorder(recorder))
 		}
 	}())
+	cruds := make(map[string]*resource.DbResource)
+	// cruds is filled in below, these middlewares look tables up in it when serving requests
+	defaultRouter.Use(telemetry.Middleware(func(tableName string) bool {
+		_, ok := cruds[tableName]
+		return ok
+	}))
+	defaultRouter.Use(RequestIdMiddleware)
 
 	transaction, err = db.Beginx()
 	if err != nil {
@@ -205,6 +213,7 @@ func Main(boxRoot http.FileSystem, db database.DatabaseConnection, localStorageP
 	defaultRouter.Use(rateLimiter)
 
 	defaultRouter.GET("/statistics", CreateStatisticsHandler(db))
+	defaultRouter.GET("/metrics", telemetry.MetricsHandler())
 
 	defaultRouter.StaticFS("/static", NewSubPathFs(boxRoot, "/static"))
 	defaultRouter.StaticFS("/statics", NewSubPathFs(boxRoot, "/statics"))
@@ -263,8 +272,11 @@ func Main(boxRoot http.FileSystem, db database.DatabaseConnection, localStorageP
 	authMiddleware := auth.NewAuthMiddlewareBuilder(db, jwtTokenIssuer, olricDb)
 	auth.InitJwtMiddleware([]byte(jwtSecret), jwtTokenIssuer, olricDb)
 	defaultRouter.Use(authMiddleware.AuthCheckMiddleware)
+	defaultRouter.Use(ConditionalRequestMiddleware)
+	defaultRouter.Use(ReadConsistencyMiddleware)
 
-	cruds := make(map[string]*resource.DbResource)
+	defaultRouter.Use(TenantMiddleware(cruds))
+	defaultRouter.Use(ResultCacheMiddleware(cruds))
 	crudsInterface := make(map[string]dbresourceinterface.DbResourceInterface)
 	defaultRouter.GET("/actions", resource.CreateGuestActionListHandler(&initConfig))
 
@@ -429,7 +441,22 @@ func Main(boxRoot http.FileSystem, db database.DatabaseConnection, localStorageP
 		resource.CheckErr(err, "Failed to begin transaction [559]")
 	}
 
-	hostSwitch, subsiteCacheFolders := CreateSubSites(&initConfig, transaction, cruds, authMiddleware, rateConfig, maxConnections, olricDb, enableGzip == "true")
+	var deploymentPublisher pubSubPublisher
+	if tablesPubSub != nil {
+		deploymentPublisher = tablesPubSub
+	}
+	siteDeployments := NewSiteDeploymentManager(db, localStoragePath+"/site-deployments", deploymentPublisher, uuid.NewString())
+	if tablesPubSub != nil {
+		go siteDeployments.Listen(tablesPubSub.Subscribe(context.Background(), SiteDeploymentTopic))
+	}
+	deploymentRetention, err := configStore.GetConfigIntValueFor("site.deployment.retention", "backend", transaction)
+	if err != nil {
+		deploymentRetention = 10
+		err = configStore.SetConfigIntValueFor("site.deployment.retention", deploymentRetention, "backend", transaction)
+		resource.CheckErr(err, "Failed to store default value for site.deployment.retention")
+	}
+	go siteDeployments.CollectGarbagePeriodically(siteDeploymentGarbageInterval, deploymentRetention)
+	hostSwitch, subsiteCacheFolders := CreateSubSites(&initConfig, transaction, cruds, authMiddleware, rateConfig, maxConnections, olricDb, siteDeployments, enableGzip == "true")
 	transaction.Commit()
 
 	log.Printf("[CALDAV INIT] Checking if CalDAV should be enabled: enableCaldav='%s'", enableCaldav)
@@ -452,6 +479,16 @@ func Main(boxRoot http.FileSystem, db database.DatabaseConnection, localStorageP
 
 	integrationRuntimeInstanceID := uuid.NewString()
 	actionPerformers := action_provider.GetActionPerformers(&initConfig, configStore, cruds, mailDaemon, hostSwitch, certificateManager, integrationRuntimeInstanceID)
+	if databaseStore, ok := store.(*YjsDatabaseStore); ok {
+		yjsSnapshotRestorePerformer, err := NewYjsSnapshotRestorePerformer(cruds, databaseStore)
+		resource.CheckErr(err, "Failed to create yjs snapshot restore performer")
+		resource.RegisterGlobalActionHandler(yjsSnapshotRestorePerformer.Name(), yjsSnapshotRestorePerformer)
+		actionPerformers = append(actionPerformers, yjsSnapshotRestorePerformer)
+	}
+	for _, deploymentPerformer := range NewSiteDeploymentPerformers(cruds, siteDeployments) {
+		resource.RegisterGlobalActionHandler(deploymentPerformer.Name(), deploymentPerformer)
+		actionPerformers = append(actionPerformers, deploymentPerformer)
+	}
 	initConfig.ActionPerformers = actionPerformers
 	transaction, err = db.Beginx()
 	encryptionSecret, _ := configStore.GetConfigValueFor("encryption.secret", "backend", transaction)
@@ -494,6 +531,30 @@ func Main(boxRoot http.FileSystem, db database.DatabaseConnection, localStorageP
 		AsUserEmail: cruds[resource.USER_ACCOUNT_TABLE_NAME].GetAdminEmailId(transaction),
 		Schedule:    "@every 5m",
 	})
+
+	err = TaskScheduler.AddTask(task.Task{
+		EntityName:  "smd",
+		ActionName:  "fire_state_timers",
+		Attributes:  map[string]interface{}{},
+		AsUserEmail: cruds[resource.USER_ACCOUNT_TABLE_NAME].GetAdminEmailId(transaction),
+		Schedule:    "@every 1m",
+	})
+
+	err = TaskScheduler.AddTask(task.Task{
+		EntityName:  "world",
+		ActionName:  "purge_deleted_rows",
+		Attributes:  map[string]interface{}{},
+		AsUserEmail: cruds[resource.USER_ACCOUNT_TABLE_NAME].GetAdminEmailId(transaction),
+		Schedule:    "@every 1h",
+	})
+
+	err = TaskScheduler.AddTask(task.Task{
+		EntityName:  "rollup",
+		ActionName:  "refresh_rollups",
+		Attributes:  map[string]interface{}{},
+		AsUserEmail: cruds[resource.USER_ACCOUNT_TABLE_NAME].GetAdminEmailId(transaction),
+		Schedule:    "@every 10s",
+	})
 	transaction.Rollback()
 
 	TaskScheduler.StartTasks()
@@ -505,6 +566,22 @@ func Main(boxRoot http.FileSystem, db database.DatabaseConnection, localStorageP
 		cruds[k].AssetFolderCache = assetColumnFolders
 	}
 
+	// webdav serves the asset column folders, so it starts once they are set up
+	transaction, err = db.Beginx()
+	if err != nil {
+		resource.CheckErr(err, "Failed to begin transaction [webdav]")
+	}
+	enableWebdav, err := configStore.GetConfigValueFor("webdav.enable", "backend", transaction)
+	if err != nil {
+		enableWebdav = "false"
+		err = configStore.SetConfigValueFor("webdav.enable", enableWebdav, "backend", transaction)
+		resource.CheckErr(err, "Failed to store webdav.enable in _config")
+	}
+	if enableWebdav == "true" {
+		InitializeWebdavResources(authMiddleware, cruds, crudsInterface, transaction, defaultRouter)
+	}
+	transaction.Commit()
+
 	authMiddleware.SetUserCrud(cruds[resource.USER_ACCOUNT_TABLE_NAME])
 	authMiddleware.SetUserGroupCrud(cruds["usergroup"])
 	authMiddleware.SetUserUserGroupCrud(cruds["user_account_user_account_id_has_usergroup_usergroup_id"])
@@ -525,6 +602,18 @@ func Main(boxRoot http.FileSystem, db database.DatabaseConnection, localStorageP
 		ftpServer = InitializeFtpResources(configStore, transaction, ftpServer, cruds, crudsInterface, certificateManager)
 	}
 
+	enableSftp, err := configStore.GetConfigValueFor("sftp.enable", "backend", transaction)
+	if err != nil {
+		enableSftp = "false"
+		err = configStore.SetConfigValueFor("sftp.enable", enableSftp, "backend", transaction)
+		auth.CheckErr(err, "Failed to store default value for sftp.enable")
+	}
+
+	var sftpServer *DaptinSftpServer
+	if enableSftp == "true" {
+		sftpServer = InitializeSftpResources(configStore, transaction, cruds, crudsInterface, certificateManager)
+	}
+
 	// Register OpenAI-compatible LLM endpoints (drop-in replacement)
 	goaiProvider := llm.NewGoAIProvider(cruds)
 	RegisterLLMEndpoints(defaultRouter, goaiProvider, cruds)
@@ -567,6 +656,9 @@ func Main(boxRoot http.FileSystem, db database.DatabaseConnection, localStorageP
 	defaultRouter.PUT("/_config/:end/:key", configHandler)
 	defaultRouter.DELETE("/_config/:end/:key", configHandler)
 
+	defaultRouter.GET("/_indexes/advice", CreateIndexAdvisorHandler(cruds))
+	InitializeRowHistoryResources(cruds, defaultRouter)
+
 	InitializeOAuthResources(cruds, configStore, defaultRouter)
 
 	resource.RegisterTranslations()
@@ -574,6 +666,7 @@ func Main(boxRoot http.FileSystem, db database.DatabaseConnection, localStorageP
 	if initConfig.EnableGraphQL {
 		InitializeGraphqlResource(initConfig, cruds, defaultRouter)
 	}
+	InitializeAtomicOperationsResource(cruds, defaultRouter)
 
 	defaultRouter.GET("/jsmodel/:typename", jsModelHandler)
 	defaultRouter.GET("/aggregate/:typename", statsHandler)
@@ -596,6 +689,8 @@ func Main(boxRoot http.FileSystem, db database.DatabaseConnection, localStorageP
 
 	defaultRouter.POST("/track/start/:stateMachineId", CreateEventStartHandler(fsmManager, cruds, db))
 	defaultRouter.POST("/track/event/:typename/:objectStateId/:eventName", CreateEventHandler(&initConfig, fsmManager, cruds, db))
+	defaultRouter.GET("/track/machine/:stateMachineId/:format", CreateStateMachineDescribeHandler(cruds))
+	defaultRouter.GET("/track/stats/:typename/:smd", CreateStateMachineStatsHandler(cruds))
 
 	websocketServer := websockets.NewServer("/live", &dtopicMap, cruds, tablesPubSub)
 
@@ -626,6 +721,6 @@ func Main(boxRoot http.FileSystem, db database.DatabaseConnection, localStorageP
 	}
 	log.Printf("Our admin is [%v]", adminEmail)
 
-	return hostSwitch, mailDaemon, TaskScheduler, configStore, certificateManager, ftpServer, imapServer, olricDb
+	return hostSwitch, mailDaemon, TaskScheduler, configStore, certificateManager, ftpServer, sftpServer, imapServer, olricDb
 
 }
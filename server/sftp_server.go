@@ -0,0 +1,406 @@
+package server
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+
+	"github.com/daptin/daptin/server/resource"
+	"github.com/doug-martin/goqu/v9"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/sftp"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+)
+
+// DaptinSftpServer serves the ftp enabled sites over SFTP. Authentication, path sandboxing and
+// site permissions are shared with DaptinFtpDriver, so a site behaves the same over both protocols.
+type DaptinSftpServer struct {
+	ListenAddr string
+	FtpDriver  *DaptinFtpDriver
+	sshConfig  *ssh.ServerConfig
+	listener   net.Listener
+	closed     int32
+	lock       sync.Mutex
+}
+
+const sftpUserEmailExtension = "daptin-user-email"
+
+// NewDaptinSftpServer creates an SFTP server for the sites known to ftpDriver, signing with hostKey
+func NewDaptinSftpServer(ftpDriver *DaptinFtpDriver, listenAddr string, hostKey ssh.Signer) *DaptinSftpServer {
+	sftpServer := &DaptinSftpServer{
+		ListenAddr: listenAddr,
+		FtpDriver:  ftpDriver,
+	}
+
+	sshConfig := &ssh.ServerConfig{
+		PasswordCallback:  sftpServer.passwordCallback,
+		PublicKeyCallback: sftpServer.publicKeyCallback,
+		ServerVersion:     "SSH-2.0-daptin",
+	}
+	sshConfig.AddHostKey(hostKey)
+	sftpServer.sshConfig = sshConfig
+
+	return sftpServer
+}
+
+// LoadSftpHostKey reads the SSH host key from the certificate table, generating it on first start
+func LoadSftpHostKey(certManager *resource.CertificateManager, hostKeyName string, transaction *sqlx.Tx) (ssh.Signer, error) {
+	certificate, err := certManager.GetTLSConfig(hostKeyName, true, transaction)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(certificate.PrivatePEMDecrypted)
+	if block == nil {
+		return nil, fmt.Errorf("failed to decode host key PEM block for [%v]", hostKeyName)
+	}
+
+	// keys generated by the certificate manager are PKCS1 wrapped in a "PRIVATE KEY" block
+	privateKey, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	if err != nil {
+		pkcs8Key, pkcs8Err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if pkcs8Err != nil {
+			return nil, err
+		}
+		return ssh.NewSignerFromKey(pkcs8Key)
+	}
+	return ssh.NewSignerFromKey(privateKey)
+}
+
+func (s *DaptinSftpServer) passwordCallback(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+	_, err := s.FtpDriver.authenticateUser(conn.User(), func(userAccount map[string]interface{}, transaction *sqlx.Tx) error {
+		passwordHash, ok := userAccount["password"].(string)
+		if !ok || !resource.BcryptCheckStringHash(string(password), passwordHash) {
+			return fmt.Errorf("could not authenticate you")
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &ssh.Permissions{Extensions: map[string]string{sftpUserEmailExtension: conn.User()}}, nil
+}
+
+func (s *DaptinSftpServer) publicKeyCallback(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+	_, err := s.FtpDriver.authenticateUser(conn.User(), func(userAccount map[string]interface{}, transaction *sqlx.Tx) error {
+		userKeys, err := s.FtpDriver.cruds["user_ssh_key"].GetAllObjectsWithWhereWithTransaction("user_ssh_key", transaction,
+			goqu.Ex{resource.USER_ACCOUNT_ID_COLUMN: userAccount["id"]})
+		if err != nil {
+			return err
+		}
+		offeredKey := key.Marshal()
+		for _, userKey := range userKeys {
+			authorizedKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(resource.AsStringOrEmpty(userKey["public_key"])))
+			if err != nil {
+				log.Warnf("Ignoring invalid ssh key [%v] of user [%v]: %v", userKey["reference_id"], conn.User(), err)
+				continue
+			}
+			if string(authorizedKey.Marshal()) == string(offeredKey) {
+				return nil
+			}
+		}
+		return fmt.Errorf("unknown public key for %v", conn.User())
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &ssh.Permissions{Extensions: map[string]string{sftpUserEmailExtension: conn.User()}}, nil
+}
+
+// ListenAndServe accepts SSH connections until Stop is called
+func (s *DaptinSftpServer) ListenAndServe() error {
+	listener, err := net.Listen("tcp", s.ListenAddr)
+	if err != nil {
+		return err
+	}
+	s.lock.Lock()
+	s.listener = listener
+	s.lock.Unlock()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if atomic.LoadInt32(&s.closed) == 1 {
+				return nil
+			}
+			return err
+		}
+		go s.handleConnection(conn)
+	}
+}
+
+// Stop closes the listener, connections already established are left to finish
+func (s *DaptinSftpServer) Stop() {
+	atomic.StoreInt32(&s.closed, 1)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.listener != nil {
+		err := s.listener.Close()
+		resource.CheckErr(err, "Failed to close sftp listener")
+	}
+}
+
+func (s *DaptinSftpServer) handleConnection(netConn net.Conn) {
+	serverConn, channels, requests, err := ssh.NewServerConn(netConn, s.sshConfig)
+	if err != nil {
+		log.Printf("SFTP handshake failed from [%v]: %v", netConn.RemoteAddr(), err)
+		_ = netConn.Close()
+		return
+	}
+	defer serverConn.Close()
+	go ssh.DiscardRequests(requests)
+
+	email := serverConn.Permissions.Extensions[sftpUserEmailExtension]
+	sessionUser, err := s.FtpDriver.authenticateUser(email, func(map[string]interface{}, *sqlx.Tx) error {
+		return nil
+	})
+	if err != nil {
+		log.Errorf("Failed to load SFTP session user [%v]: %v", email, err)
+		return
+	}
+	log.Infof("SFTP Login [%s][%s]", email, serverConn.RemoteAddr())
+
+	for newChannel := range channels {
+		if newChannel.ChannelType() != "session" {
+			_ = newChannel.Reject(ssh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+		channel, channelRequests, err := newChannel.Accept()
+		if err != nil {
+			log.Errorf("Failed to accept SFTP channel: %v", err)
+			continue
+		}
+
+		// only the sftp subsystem is offered, no shell or exec
+		go func(in <-chan *ssh.Request) {
+			for req := range in {
+				isSftp := req.Type == "subsystem" && len(req.Payload) > 4 && string(req.Payload[4:]) == "sftp"
+				_ = req.Reply(isSftp, nil)
+			}
+		}(channelRequests)
+
+		handler := &sftpHandler{
+			client: &ClientDriver{
+				BaseDir:     "/",
+				CurrentDir:  "/",
+				FtpDriver:   s.FtpDriver,
+				sessionUser: sessionUser,
+			},
+		}
+		requestServer := sftp.NewRequestServer(channel, sftp.Handlers{
+			FileGet:  handler,
+			FilePut:  handler,
+			FileCmd:  handler,
+			FileList: handler,
+		})
+		go func() {
+			if err := requestServer.Serve(); err != nil && err != io.EOF {
+				log.Errorf("SFTP session for [%v] ended: %v", email, err)
+			}
+			_ = requestServer.Close()
+		}()
+	}
+}
+
+// sftpHandler maps SFTP requests onto the ClientDriver used by the FTP server
+type sftpHandler struct {
+	client *ClientDriver
+}
+
+func (h *sftpHandler) Fileread(r *sftp.Request) (io.ReaderAt, error) {
+	file, err := h.client.OpenFile(nil, r.Filepath, os.O_RDONLY)
+	if err != nil {
+		return nil, err
+	}
+	readerAt, ok := file.(io.ReaderAt)
+	if !ok {
+		_ = file.Close()
+		return nil, sftp.ErrSSHFxOpUnsupported
+	}
+	return readerAt, nil
+}
+
+func (h *sftpHandler) Filewrite(r *sftp.Request) (io.WriterAt, error) {
+	_, site, fullPath, err := h.client.resolveSitePath(r.Filepath)
+	if err != nil {
+		return nil, err
+	}
+	if err = h.client.checkWritePermission(site, fullPath); err != nil {
+		return nil, err
+	}
+
+	// writes arrive through WriteAt, so the file is never opened with O_APPEND
+	flag := os.O_WRONLY | os.O_CREATE
+	pflags := r.Pflags()
+	if pflags.Trunc {
+		flag |= os.O_TRUNC
+	}
+	if pflags.Excl {
+		flag |= os.O_EXCL
+	}
+	file, err := os.OpenFile(fullPath, flag, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &sftpWriteThroughFile{File: file, client: h.client, site: site, fullPath: fullPath}, nil
+}
+
+func (h *sftpHandler) Filecmd(r *sftp.Request) error {
+	switch r.Method {
+	case "Setstat":
+		return h.setStat(r)
+	case "Rename":
+		fromSiteName, fromSite, fromPath, err := h.client.resolveSitePath(r.Filepath)
+		if err != nil {
+			return err
+		}
+		toSiteName, _, toPath, err := h.client.resolveSitePath(r.Target)
+		if err != nil {
+			return err
+		}
+		// the move is written through to the cloud store of the source site only
+		if fromSiteName != toSiteName {
+			return errCrossSiteRename
+		}
+		if err = h.client.RenameFile(nil, r.Filepath, r.Target); err != nil {
+			return err
+		}
+		h.client.moveInCloudStore(fromSite, fromPath, toPath)
+		return nil
+	case "Rmdir", "Remove":
+		_, site, fullPath, err := h.client.resolveSitePath(r.Filepath)
+		if err != nil {
+			return err
+		}
+		if err = h.client.DeleteFile(nil, r.Filepath); err != nil {
+			return err
+		}
+		h.client.deleteFromCloudStore(site, fullPath, r.Method == "Rmdir")
+		return nil
+	case "Mkdir":
+		return h.client.MakeDirectory(nil, r.Filepath)
+	case "Link", "Symlink":
+		// links could point outside of the site root
+		return sftp.ErrSSHFxOpUnsupported
+	}
+	return sftp.ErrSSHFxOpUnsupported
+}
+
+func (h *sftpHandler) setStat(r *sftp.Request) error {
+	attrFlags := r.AttrFlags()
+	attributes := r.Attributes()
+	if attrFlags.Permissions {
+		if err := h.client.ChmodFile(nil, r.Filepath, attributes.FileMode().Perm()); err != nil {
+			return err
+		}
+	}
+	if attrFlags.Acmodtime {
+		if err := h.client.SetFileMtime(nil, r.Filepath, attributes.ModTime()); err != nil {
+			return err
+		}
+	}
+	if attrFlags.Size {
+		_, site, fullPath, err := h.client.resolveSitePath(r.Filepath)
+		if err != nil {
+			return err
+		}
+		if err = h.client.checkWritePermission(site, fullPath); err != nil {
+			return err
+		}
+		return os.Truncate(fullPath, int64(attributes.Size))
+	}
+	return nil
+}
+
+func (h *sftpHandler) Filelist(r *sftp.Request) (sftp.ListerAt, error) {
+	switch r.Method {
+	case "List":
+		files, err := h.client.ListFiles(nil, r.Filepath)
+		if err != nil {
+			return nil, err
+		}
+		return sftpFileList(files), nil
+	case "Stat":
+		if r.Filepath == "/" {
+			return sftpFileList{virtualFileInfo{name: "/", mode: os.FileMode(0755) | os.ModeDir}}, nil
+		}
+		fileInfo, err := h.client.GetFileInfo(nil, r.Filepath)
+		if err != nil {
+			return nil, err
+		}
+		return sftpFileList{fileInfo}, nil
+	}
+	return nil, sftp.ErrSSHFxOpUnsupported
+}
+
+type sftpFileList []os.FileInfo
+
+func (l sftpFileList) ListAt(buffer []os.FileInfo, offset int64) (int, error) {
+	if offset >= int64(len(l)) {
+		return 0, io.EOF
+	}
+	n := copy(buffer, l[offset:])
+	if n+int(offset) >= len(l) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// sftpWriteThroughFile uploads the file to the cloud store of the site once the client closes it
+type sftpWriteThroughFile struct {
+	*os.File
+	client   *ClientDriver
+	site     SubSiteAssetCache
+	fullPath string
+}
+
+func (f *sftpWriteThroughFile) Close() error {
+	if err := f.File.Close(); err != nil {
+		return err
+	}
+	f.client.uploadToCloudStore(f.site, f.fullPath)
+	return nil
+}
+
+// siteRelativePath returns fullPath relative to the local folder of the site
+func siteRelativePath(site SubSiteAssetCache, fullPath string) (string, error) {
+	if site.AssetFolderCache == nil {
+		return "", errors.New("site has no asset folder")
+	}
+	return localRelativePath(site.LocalSyncPath, fullPath)
+}
+
+func (driver *ClientDriver) uploadToCloudStore(site SubSiteAssetCache, fullPath string) {
+	relativePath, err := siteRelativePath(site, fullPath)
+	if err != nil {
+		return
+	}
+	uploadToCloudStore(driver.FtpDriver.cruds, site.AssetFolderCache, relativePath, fullPath)
+}
+
+func (driver *ClientDriver) deleteFromCloudStore(site SubSiteAssetCache, fullPath string, isDir bool) {
+	relativePath, err := siteRelativePath(site, fullPath)
+	if err != nil {
+		return
+	}
+	deleteFromCloudStore(driver.FtpDriver.cruds, site.AssetFolderCache, relativePath, isDir)
+}
+
+func (driver *ClientDriver) moveInCloudStore(site SubSiteAssetCache, fromPath string, toPath string) {
+	fromRelative, err := siteRelativePath(site, fromPath)
+	if err != nil {
+		return
+	}
+	toRelative, err := siteRelativePath(site, toPath)
+	if err != nil {
+		return
+	}
+	moveInCloudStore(driver.FtpDriver.cruds, site.AssetFolderCache, fromRelative, toRelative)
+}
@@ -0,0 +1,189 @@
+package server
+
+import (
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/daptin/daptin/server/auth"
+	"github.com/daptin/daptin/server/permission"
+	"github.com/pkg/sftp"
+)
+
+func sftpTestClient(t *testing.T, client *ClientDriver) *sftp.Client {
+	serverConn, clientConn := net.Pipe()
+	handler := &sftpHandler{client: client}
+	requestServer := sftp.NewRequestServer(serverConn, sftp.Handlers{
+		FileGet:  handler,
+		FilePut:  handler,
+		FileCmd:  handler,
+		FileList: handler,
+	})
+	go requestServer.Serve()
+
+	sftpClient, err := sftp.NewClientPipe(clientConn, clientConn)
+	if err != nil {
+		t.Fatalf("failed to start sftp client: %v", err)
+	}
+	t.Cleanup(func() {
+		sftpClient.Close()
+		requestServer.Close()
+	})
+	return sftpClient
+}
+
+func TestSftpFileOperationsUseSitePermissions(t *testing.T) {
+	ownerReferenceId := ftpTestReferenceId()
+	readerReferenceId := ftpTestReferenceId()
+	groupReferenceId := ftpTestReferenceId()
+	adminGroupId := ftpTestReferenceId()
+	root := t.TempDir()
+
+	site := ftpTestSite("sftp.example", root, permission.PermissionInstance{
+		UserId:     ownerReferenceId,
+		Permission: auth.UserCRUD,
+		UserGroupId: auth.GroupPermissionList{{
+			GroupReferenceId: groupReferenceId,
+			Permission:       auth.GroupPeek | auth.GroupRead,
+		}},
+	})
+
+	owner := sftpTestClient(t, ftpTestClient(&auth.SessionUser{UserReferenceId: ownerReferenceId}, adminGroupId, site))
+
+	if err := owner.Mkdir("/sftp.example/docs"); err != nil {
+		t.Fatalf("owner could not create a directory: %v", err)
+	}
+	file, err := owner.Create("/sftp.example/docs/readme.txt")
+	if err != nil {
+		t.Fatalf("owner could not create a file: %v", err)
+	}
+	if _, err = file.Write([]byte("hello over sftp")); err != nil {
+		t.Fatalf("owner could not write a file: %v", err)
+	}
+	if err = file.Close(); err != nil {
+		t.Fatalf("failed to close uploaded file: %v", err)
+	}
+	contents, err := os.ReadFile(filepath.Join(root, "docs", "readme.txt"))
+	if err != nil || string(contents) != "hello over sftp" {
+		t.Fatalf("upload did not reach the site folder: %q %v", contents, err)
+	}
+
+	entries, err := owner.ReadDir("/")
+	if err != nil || len(entries) != 1 || entries[0].Name() != "sftp.example" {
+		t.Fatalf("expected root listing to contain the site, got %v %v", entries, err)
+	}
+
+	if err = owner.Rename("/sftp.example/docs/readme.txt", "/sftp.example/docs/moved.txt"); err != nil {
+		t.Fatalf("owner could not rename a file: %v", err)
+	}
+
+	reader := sftpTestClient(t, ftpTestClient(&auth.SessionUser{
+		UserReferenceId: readerReferenceId,
+		Groups:          auth.GroupPermissionList{{GroupReferenceId: groupReferenceId}},
+	}, adminGroupId, site))
+
+	readFile, err := reader.Open("/sftp.example/docs/moved.txt")
+	if err != nil {
+		t.Fatalf("group reader could not open a file: %v", err)
+	}
+	readContents, err := io.ReadAll(readFile)
+	readFile.Close()
+	if err != nil || string(readContents) != "hello over sftp" {
+		t.Fatalf("group reader got %q %v", readContents, err)
+	}
+	if _, err = reader.Create("/sftp.example/docs/new.txt"); err == nil {
+		t.Fatal("group reader without create permission uploaded a file")
+	}
+	if err = reader.Remove("/sftp.example/docs/moved.txt"); err == nil {
+		t.Fatal("group reader without delete permission removed a file")
+	}
+
+	if err = owner.Remove("/sftp.example/docs/moved.txt"); err != nil {
+		t.Fatalf("owner could not remove a file: %v", err)
+	}
+	if err = owner.RemoveDirectory("/sftp.example/docs"); err != nil {
+		t.Fatalf("owner could not remove a directory: %v", err)
+	}
+}
+
+func TestSftpRenameAcrossSitesIsRejected(t *testing.T) {
+	ownerReferenceId := ftpTestReferenceId()
+	adminGroupId := ftpTestReferenceId()
+	fromRoot := t.TempDir()
+	toRoot := t.TempDir()
+	if err := os.WriteFile(filepath.Join(fromRoot, "page.html"), []byte("page"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	sitePermission := permission.PermissionInstance{
+		UserId:     ownerReferenceId,
+		Permission: auth.UserCRUD,
+	}
+	client := sftpTestClient(t, ftpTestClient(&auth.SessionUser{UserReferenceId: ownerReferenceId}, adminGroupId,
+		ftpTestSite("from.example", fromRoot, sitePermission), ftpTestSite("to.example", toRoot, sitePermission)))
+
+	if err := client.Rename("/from.example/page.html", "/to.example/page.html"); err == nil {
+		t.Fatal("renamed a file to another site")
+	}
+	if _, err := os.Stat(filepath.Join(fromRoot, "page.html")); err != nil {
+		t.Errorf("the file should stay in its site: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(toRoot, "page.html")); !os.IsNotExist(err) {
+		t.Errorf("the file should not reach the other site: %v", err)
+	}
+}
+
+func TestSftpPathsCannotEscapeSiteRoot(t *testing.T) {
+	ownerReferenceId := ftpTestReferenceId()
+	adminGroupId := ftpTestReferenceId()
+	parent := t.TempDir()
+	root := filepath.Join(parent, "site")
+	if err := os.Mkdir(root, 0750); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(parent, "secret.txt"), []byte("secret"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(parent, filepath.Join(root, "escape")); err != nil {
+		t.Skipf("symlinks not supported: %v", err)
+	}
+
+	site := ftpTestSite("contained.example", root, permission.PermissionInstance{
+		UserId:     ownerReferenceId,
+		Permission: auth.UserCRUD,
+	})
+	client := sftpTestClient(t, ftpTestClient(&auth.SessionUser{UserReferenceId: ownerReferenceId}, adminGroupId, site))
+
+	if _, err := client.Open("/contained.example/escape/secret.txt"); err == nil {
+		t.Fatal("read through a symlink outside of the site root")
+	}
+	if err := client.Symlink("/contained.example/escape", "/contained.example/link"); err == nil {
+		t.Fatal("created a symlink inside the site")
+	}
+	if _, err := client.Open("/unknown.example/secret.txt"); err == nil {
+		t.Fatal("opened a file on a site that is not served")
+	}
+}
+
+func TestSftpFileListPaging(t *testing.T) {
+	files := sftpFileList{
+		virtualFileInfo{name: "a"},
+		virtualFileInfo{name: "b"},
+		virtualFileInfo{name: "c"},
+	}
+	buffer := make([]os.FileInfo, 2)
+
+	n, err := files.ListAt(buffer, 0)
+	if n != 2 || err != nil {
+		t.Fatalf("expected first page of 2 without EOF, got %d %v", n, err)
+	}
+	n, err = files.ListAt(buffer, 2)
+	if n != 1 || err != io.EOF {
+		t.Fatalf("expected last entry with EOF, got %d %v", n, err)
+	}
+	if n, err = files.ListAt(buffer, 3); n != 0 || err != io.EOF {
+		t.Fatalf("expected EOF past the end, got %d %v", n, err)
+	}
+}
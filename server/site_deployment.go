@@ -0,0 +1,856 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	iofs "io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/artpar/rclone/fs"
+	"github.com/artpar/rclone/fs/operations"
+	"github.com/daptin/daptin/server/assetcachepojo"
+	"github.com/daptin/daptin/server/auth"
+	"github.com/daptin/daptin/server/database"
+	daptinid "github.com/daptin/daptin/server/id"
+	"github.com/daptin/daptin/server/rootpojo"
+	"github.com/daptin/daptin/server/statementbuilder"
+	"github.com/daptin/daptin/server/subsite"
+	"github.com/doug-martin/goqu/v9"
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	log "github.com/sirupsen/logrus"
+)
+
+// SiteDeploymentTopic tells the other nodes of a cluster which deployment a site is served from
+const SiteDeploymentTopic = "daptin.site.deployment"
+
+const (
+	SiteDeploymentSourceSync     = "sync"
+	SiteDeploymentSourceUpload   = "upload"
+	SiteDeploymentSourceSnapshot = "snapshot"
+)
+
+// deployments belong to the user who created them, administrators can reach all of them
+const siteDeploymentPermission = auth.UserCRUD | auth.UserExecute
+
+// previews are built again after this long, previews of hostnames not visited since are dropped
+const siteDeploymentPreviewLifetime = 10 * time.Minute
+
+// objects are stored under this folder of the cloud store of a site, next to the site folders
+const siteDeploymentObjectsFolder = ".daptin-deployments/objects"
+
+// garbage collection keeps objects changed more recently than this, they can belong to a deployment
+// which is still being created
+const siteDeploymentObjectGracePeriod = time.Hour
+
+// how often expired deployments and unused objects are removed
+const siteDeploymentGarbageInterval = time.Hour
+
+var ErrSiteDeploymentNotFound = errors.New("deployment not found")
+
+type SiteDeploymentFile struct {
+	Path string `json:"path"`
+	Hash string `json:"hash"`
+	Size int64  `json:"size"`
+}
+
+type SiteDeployment struct {
+	Id            int64
+	ReferenceId   daptinid.DaptinReferenceId
+	DeploymentKey string
+	SiteId        int64
+	ContentHash   string
+	Manifest      []SiteDeploymentFile
+	IsActive      bool
+}
+
+type siteDeploymentMessage struct {
+	SiteId           int64  `json:"site_id"`
+	DeploymentKey    string `json:"deployment_key"`
+	SourceInstanceID string `json:"source_instance_id"`
+}
+
+type servedDeployment struct {
+	deploymentKey string
+	handler       gin.HandlerFunc
+}
+
+type previewDeployment struct {
+	engine    *gin.Engine
+	site      subsite.SubSite
+	expiresAt time.Time
+}
+
+// SiteDeploymentManager keeps immutable deployments of sites. Every file is stored once under objects/
+// by its SHA-256 and a deployment is a folder of hard links to those objects, so switching a site to
+// another deployment swaps the folder it is served from and rolling back does not copy anything.
+// The objects are also uploaded to the cloud store of the site, nodes which do not have an object
+// download it from there when they build the folder of a deployment.
+type SiteDeploymentManager struct {
+	root        string
+	connection  database.DatabaseConnection
+	publisher   pubSubPublisher
+	instanceID  string
+	middlewares []gin.HandlerFunc
+	enableGzip  bool
+
+	sitesLock sync.RWMutex
+	sites     map[int64]subsite.SubSite
+	stores    map[int64]*assetcachepojo.AssetFolderCache
+	hostnames map[string]subsite.SubSite
+
+	active    sync.Map // site id -> *servedDeployment
+	previews  sync.Map // preview hostname -> *previewDeployment
+	buildLock sync.Mutex
+}
+
+func NewSiteDeploymentManager(connection database.DatabaseConnection, root string, publisher pubSubPublisher, instanceID string) *SiteDeploymentManager {
+	return &SiteDeploymentManager{
+		root:       root,
+		connection: connection,
+		publisher:  publisher,
+		instanceID: instanceID,
+		enableGzip: true,
+		sites:      make(map[int64]subsite.SubSite),
+		stores:     make(map[int64]*assetcachepojo.AssetFolderCache),
+		hostnames:  make(map[string]subsite.SubSite),
+	}
+}
+
+// Track registers a site with the cloud store its deployment objects are kept in and starts serving
+// it from its active deployment, if it has one
+func (m *SiteDeploymentManager) Track(site subsite.SubSite, store *assetcachepojo.AssetFolderCache, transaction *sqlx.Tx) {
+	m.sitesLock.Lock()
+	m.sites[site.Id] = site
+	m.stores[site.Id] = store
+	for _, hostname := range strings.Split(site.Hostname, ",") {
+		m.hostnames[hostname] = site
+	}
+	m.sitesLock.Unlock()
+
+	deployment, err := m.deploymentWhere(goqu.Ex{"site_id": site.Id, "is_active": true}, transaction)
+	if errors.Is(err, ErrSiteDeploymentNotFound) {
+		return
+	}
+	if err == nil {
+		err = m.serve(site, deployment)
+	}
+	if err != nil {
+		log.Warnf("Site [%v] is served from its synced folder, active deployment not available: %v", site.Hostname, err)
+	}
+}
+
+// Site returns a tracked site by its reference id
+func (m *SiteDeploymentManager) Site(referenceId daptinid.DaptinReferenceId) (subsite.SubSite, bool) {
+	m.sitesLock.RLock()
+	defer m.sitesLock.RUnlock()
+	for _, site := range m.sites {
+		if site.ReferenceId == referenceId {
+			return site, true
+		}
+	}
+	return subsite.SubSite{}, false
+}
+
+func (m *SiteDeploymentManager) siteById(siteId int64) (subsite.SubSite, bool) {
+	m.sitesLock.RLock()
+	defer m.sitesLock.RUnlock()
+	site, ok := m.sites[siteId]
+	return site, ok
+}
+
+// HasActiveDeployment is true once a site is served from a deployment instead of its synced folder
+func (m *SiteDeploymentManager) HasActiveDeployment(siteId int64) bool {
+	_, ok := m.active.Load(siteId)
+	return ok
+}
+
+// RequestHandler serves a site from its active deployment and falls back to the synced folder for
+// sites which were never deployed
+func (m *SiteDeploymentManager) RequestHandler(site subsite.SubSite, fallback gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if served, ok := m.active.Load(site.Id); ok {
+			served.(*servedDeployment).handler(c)
+			return
+		}
+		fallback(c)
+	}
+}
+
+// PreviewRouter serves <deployment key>.<site hostname> from that deployment of the site
+func (m *SiteDeploymentManager) PreviewRouter(hostName string) (*gin.Engine, subsite.SubSite, bool) {
+	now := time.Now()
+	if value, ok := m.previews.Load(hostName); ok {
+		preview := value.(*previewDeployment)
+		if now.Before(preview.expiresAt) {
+			return preview.engine, preview.site, true
+		}
+	}
+	m.dropExpiredPreviews(now)
+
+	parts := strings.SplitN(hostName, ".", 2)
+	if len(parts) != 2 {
+		return nil, subsite.SubSite{}, false
+	}
+	m.sitesLock.RLock()
+	site, ok := m.hostnames[parts[1]]
+	m.sitesLock.RUnlock()
+	if !ok {
+		return nil, subsite.SubSite{}, false
+	}
+
+	deployment, err := m.deploymentWhere(goqu.Ex{"deployment_key": parts[0], "site_id": site.Id}, m.connection)
+	if err != nil {
+		return nil, subsite.SubSite{}, false
+	}
+	deploymentPath, err := m.materialize(deployment)
+	if err != nil {
+		log.Warnf("Preview of deployment [%v] not available: %v", deployment.DeploymentKey, err)
+		return nil, subsite.SubSite{}, false
+	}
+
+	engine := createSubsiteEngine(SubsiteRequestHandler(site, m.deploymentCache(deploymentPath), m.enableGzip), m.middlewares)
+	m.previews.Store(hostName, &previewDeployment{
+		engine:    engine,
+		site:      site,
+		expiresAt: now.Add(siteDeploymentPreviewLifetime),
+	})
+	return engine, site, true
+}
+
+// dropExpiredPreviews forgets the previews built more than siteDeploymentPreviewLifetime ago
+func (m *SiteDeploymentManager) dropExpiredPreviews(now time.Time) {
+	m.previews.Range(func(hostName, value interface{}) bool {
+		if !now.Before(value.(*previewDeployment).expiresAt) {
+			m.previews.Delete(hostName)
+		}
+		return true
+	})
+}
+
+// CreateDeployment stores the files under sourcePath and records a deployment for them. Deploying
+// the same files again returns the existing deployment.
+func (m *SiteDeploymentManager) CreateDeployment(site subsite.SubSite, sourcePath string, source string, userId *int64, transaction *sqlx.Tx) (*SiteDeployment, error) {
+	files, err := m.storeFiles(site.Id, sourcePath)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, errors.New("no files to deploy")
+	}
+
+	manifest, err := json.Marshal(files)
+	if err != nil {
+		return nil, err
+	}
+	manifestHash := sha256.Sum256(manifest)
+	contentHash := hex.EncodeToString(manifestHash[:])
+
+	existing, err := m.deploymentWhere(goqu.Ex{"site_id": site.Id, "content_hash": contentHash}, transaction)
+	if err == nil {
+		return existing, nil
+	}
+	if !errors.Is(err, ErrSiteDeploymentNotFound) {
+		return nil, err
+	}
+
+	var totalSize int64
+	for _, file := range files {
+		totalSize += file.Size
+	}
+	deploymentKey, err := newSiteDeploymentKey()
+	if err != nil {
+		return nil, err
+	}
+	referenceId := uuid.New()
+	query, args, err := statementbuilder.Squirrel.Insert("site_deployment").Prepared(true).
+		Cols("reference_id", "deployment_key", "site_id", "content_hash", "manifest", "file_count",
+			"total_size", "source", "is_active", "user_account_id", "permission", "version", "created_at").
+		Vals([]interface{}{referenceId[:], deploymentKey, site.Id, contentHash, string(manifest), len(files),
+			totalSize, source, false, userId, siteDeploymentPermission, 1, time.Now()}).
+		ToSQL()
+	if err != nil {
+		return nil, err
+	}
+	_, err = transaction.Exec(query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Infof("Created deployment [%v] of site [%v] with %d files", deploymentKey, site.Hostname, len(files))
+	return m.deploymentWhere(goqu.Ex{"deployment_key": deploymentKey}, transaction)
+}
+
+// Deployment returns a deployment by its reference id
+func (m *SiteDeploymentManager) Deployment(referenceId daptinid.DaptinReferenceId, transaction *sqlx.Tx) (*SiteDeployment, error) {
+	return m.deploymentWhere(goqu.Ex{"reference_id": referenceId[:]}, transaction)
+}
+
+// Activate points a site at a deployment on this node and tells the other nodes to do the same
+func (m *SiteDeploymentManager) Activate(site subsite.SubSite, deployment *SiteDeployment, transaction *sqlx.Tx) error {
+	if deployment.SiteId != site.Id {
+		return errors.New("deployment belongs to another site")
+	}
+
+	query, args, err := statementbuilder.Squirrel.Update("site_deployment").Prepared(true).
+		Set(goqu.Record{"is_active": goqu.L("id = ?", deployment.Id), "updated_at": time.Now()}).
+		Where(goqu.Ex{"site_id": site.Id}).ToSQL()
+	if err != nil {
+		return err
+	}
+	_, err = transaction.Exec(query, args...)
+	if err != nil {
+		return err
+	}
+
+	err = m.serve(site, deployment)
+	if err != nil {
+		return err
+	}
+	deployment.IsActive = true
+
+	if m.publisher != nil {
+		payload, err := json.Marshal(siteDeploymentMessage{
+			SiteId:           site.Id,
+			DeploymentKey:    deployment.DeploymentKey,
+			SourceInstanceID: m.instanceID,
+		})
+		if err == nil {
+			_, err = m.publisher.Publish(context.Background(), SiteDeploymentTopic, string(payload))
+		}
+		CheckErr(err, "Failed to announce deployment [%v] of site [%v]", deployment.DeploymentKey, site.Hostname)
+	}
+	return nil
+}
+
+// Listen switches sites to the deployments activated on other nodes until the subscription is closed
+func (m *SiteDeploymentManager) Listen(subscription *redis.PubSub) {
+	channel := subscription.Channel()
+	for msg := range channel {
+		var message siteDeploymentMessage
+		if err := json.Unmarshal([]byte(msg.Payload), &message); err != nil {
+			log.Warnf("Ignoring invalid site deployment message: %v", err)
+			continue
+		}
+		if message.SourceInstanceID == m.instanceID {
+			continue
+		}
+		site, ok := m.siteById(message.SiteId)
+		if !ok {
+			continue
+		}
+		deployment, err := m.deploymentWhere(goqu.Ex{"deployment_key": message.DeploymentKey}, m.connection)
+		if err == nil {
+			err = m.serve(site, deployment)
+		}
+		CheckErr(err, "Failed to switch site [%v] to deployment [%v]", site.Hostname, message.DeploymentKey)
+	}
+}
+
+func (m *SiteDeploymentManager) serve(site subsite.SubSite, deployment *SiteDeployment) error {
+	deploymentPath, err := m.materialize(deployment)
+	if err != nil {
+		return err
+	}
+	handler := SubsiteRequestHandler(site, m.deploymentCache(deploymentPath), m.enableGzip)
+	m.active.Store(site.Id, &servedDeployment{
+		deploymentKey: deployment.DeploymentKey,
+		handler:       handler,
+	})
+	invalidateSubsiteHostCaches(strings.Split(site.Hostname, ","))
+	log.Infof("Site [%v] is served from deployment [%v]", site.Hostname, deployment.DeploymentKey)
+	return nil
+}
+
+// deploymentCache serves a deployment folder as a local store, files missing from the deployment are
+// not fetched from the cloud store. Compressed variants are kept next to the folder, not inside it.
+func (m *SiteDeploymentManager) deploymentCache(deploymentPath string) *assetcachepojo.AssetFolderCache {
+	return &assetcachepojo.AssetFolderCache{
+		LocalSyncPath: deploymentPath + ".cache",
+		CloudStore: rootpojo.CloudStore{
+			StoreProvider: "local",
+			RootPath:      deploymentPath,
+		},
+	}
+}
+
+func (m *SiteDeploymentManager) objectPath(hash string) string {
+	return filepath.Join(m.root, "objects", hash[:2], hash)
+}
+
+func siteDeploymentObjectName(hash string) string {
+	return hash[:2] + "/" + hash
+}
+
+// objectStore returns the folder of the cloud store of a site the deployment objects are kept in
+func (m *SiteDeploymentManager) objectStore(siteId int64) (fs.Fs, error) {
+	m.sitesLock.RLock()
+	store := m.stores[siteId]
+	m.sitesLock.RUnlock()
+	if store == nil {
+		return nil, fmt.Errorf("site [%v] has no cloud store for its deployments", siteId)
+	}
+	objects := &assetcachepojo.AssetFolderCache{
+		Keyname:     siteDeploymentObjectsFolder,
+		CloudStore:  store.CloudStore,
+		Credentials: store.Credentials,
+	}
+	return objects.CloudFilesystem(context.Background())
+}
+
+// storeFiles copies every file under sourcePath into the object store and the cloud store of the
+// site, and returns the manifest
+func (m *SiteDeploymentManager) storeFiles(siteId int64, sourcePath string) ([]SiteDeploymentFile, error) {
+	objects, err := m.objectStore(siteId)
+	if err != nil {
+		return nil, err
+	}
+	files := make([]SiteDeploymentFile, 0)
+	err = filepath.WalkDir(sourcePath, func(filePath string, entry iofs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			if entry.Name() == subsiteCompressedCacheDirectory || entry.Name() == path.Dir(siteDeploymentObjectsFolder) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		relativePath, err := filepath.Rel(sourcePath, filePath)
+		if err != nil {
+			return err
+		}
+		source, err := os.Open(filePath)
+		if err != nil {
+			return err
+		}
+		hash, size, err := m.storeObject(source)
+		source.Close()
+		if err != nil {
+			return err
+		}
+		err = m.shareObject(objects, hash, size)
+		if err != nil {
+			return err
+		}
+		files = append(files, SiteDeploymentFile{
+			Path: filepath.ToSlash(relativePath),
+			Hash: hash,
+			Size: size,
+		})
+		return nil
+	})
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Path < files[j].Path
+	})
+	return files, err
+}
+
+// storeObject writes the contents of source into the local object store
+func (m *SiteDeploymentManager) storeObject(source io.Reader) (string, int64, error) {
+	objectsPath := filepath.Join(m.root, "objects")
+	err := os.MkdirAll(objectsPath, 0750)
+	if err != nil {
+		return "", 0, err
+	}
+	temporary, err := os.CreateTemp(objectsPath, ".object-*")
+	if err != nil {
+		return "", 0, err
+	}
+	defer os.Remove(temporary.Name())
+
+	hasher := sha256.New()
+	size, err := io.Copy(io.MultiWriter(temporary, hasher), source)
+	closeErr := temporary.Close()
+	if err != nil {
+		return "", 0, err
+	}
+	if closeErr != nil {
+		return "", 0, closeErr
+	}
+
+	hash := hex.EncodeToString(hasher.Sum(nil))
+	objectPath := m.objectPath(hash)
+	if _, err = os.Stat(objectPath); err == nil {
+		// touched so garbage collection does not take it from the deployment being created
+		now := time.Now()
+		return hash, size, os.Chtimes(objectPath, now, now)
+	}
+	err = os.MkdirAll(filepath.Dir(objectPath), 0750)
+	if err != nil {
+		return "", 0, err
+	}
+	// objects are shared between deployments and must never change
+	err = os.Chmod(temporary.Name(), 0440)
+	if err != nil {
+		return "", 0, err
+	}
+	return hash, size, os.Rename(temporary.Name(), objectPath)
+}
+
+// shareObject uploads a local object to the cloud store of the site unless it is stored there
+// already, the file is streamed from disk
+func (m *SiteDeploymentManager) shareObject(objects fs.Fs, hash string, size int64) error {
+	ctx := context.Background()
+	remote := siteDeploymentObjectName(hash)
+	if stored, err := objects.NewObject(ctx, remote); err == nil && stored.Size() == size {
+		// a stored object is touched so garbage collection does not take it from this deployment
+		_ = stored.SetModTime(ctx, time.Now())
+		return nil
+	}
+
+	source, err := os.Open(m.objectPath(hash))
+	if err != nil {
+		return err
+	}
+	_, err = operations.Rcat(ctx, objects, remote, source, time.Now(), nil)
+	if err != nil {
+		return fmt.Errorf("failed to upload object [%v]: %w", hash, err)
+	}
+	return nil
+}
+
+// fetchObject downloads an object stored by another node from the cloud store of the site into the
+// local object store
+func (m *SiteDeploymentManager) fetchObject(objects fs.Fs, hash string) error {
+	ctx := context.Background()
+	object, err := objects.NewObject(ctx, siteDeploymentObjectName(hash))
+	if err != nil {
+		return fmt.Errorf("object [%v] is not stored: %w", hash, err)
+	}
+	content, err := object.Open(ctx)
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+
+	storedHash, _, err := m.storeObject(content)
+	if err != nil {
+		return err
+	}
+	if storedHash != hash {
+		return fmt.Errorf("stored object [%v] does not match its hash", hash)
+	}
+	return nil
+}
+
+// materialize builds the folder of a deployment from the object store the first time it is served,
+// objects missing on this node are downloaded from the cloud store of the site. The folder is
+// assembled under a temporary name and renamed into place, so it is complete or absent.
+func (m *SiteDeploymentManager) materialize(deployment *SiteDeployment) (string, error) {
+	deploymentsPath := filepath.Join(m.root, "deployments")
+	deploymentPath := filepath.Join(deploymentsPath, deployment.DeploymentKey)
+	if PathExistsAndIsFolder(deploymentPath) {
+		return deploymentPath, nil
+	}
+
+	m.buildLock.Lock()
+	defer m.buildLock.Unlock()
+	if PathExistsAndIsFolder(deploymentPath) {
+		return deploymentPath, nil
+	}
+
+	err := os.MkdirAll(deploymentsPath, 0750)
+	if err != nil {
+		return "", err
+	}
+	temporaryPath, err := os.MkdirTemp(deploymentsPath, deployment.DeploymentKey+".tmp-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(temporaryPath)
+
+	var objects fs.Fs
+	for _, file := range deployment.Manifest {
+		if !filepath.IsLocal(filepath.FromSlash(file.Path)) || len(file.Hash) < 2 {
+			return "", fmt.Errorf("invalid file [%v] in deployment manifest", file.Path)
+		}
+		objectPath := m.objectPath(file.Hash)
+		if _, err = os.Stat(objectPath); err != nil {
+			if objects == nil {
+				objects, err = m.objectStore(deployment.SiteId)
+				if err != nil {
+					return "", err
+				}
+			}
+			err = m.fetchObject(objects, file.Hash)
+			if err != nil {
+				return "", fmt.Errorf("file [%v] of deployment [%v] is not available: %v", file.Path, deployment.DeploymentKey, err)
+			}
+		}
+		targetPath := filepath.Join(temporaryPath, filepath.FromSlash(file.Path))
+		err = os.MkdirAll(filepath.Dir(targetPath), 0750)
+		if err != nil {
+			return "", err
+		}
+		if err = os.Link(objectPath, targetPath); err != nil {
+			err = copySiteDeploymentObject(objectPath, targetPath)
+			if err != nil {
+				return "", err
+			}
+		}
+	}
+
+	return deploymentPath, os.Rename(temporaryPath, deploymentPath)
+}
+
+func copySiteDeploymentObject(objectPath string, targetPath string) error {
+	source, err := os.Open(objectPath)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+	target, err := os.OpenFile(targetPath, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0440)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(target, source)
+	closeErr := target.Close()
+	if err != nil {
+		return err
+	}
+	return closeErr
+}
+
+// CollectGarbage removes the inactive deployments of every site beyond the newest retention ones, the
+// objects no remaining deployment refers to, from the cloud stores of the sites and from this node,
+// and the folders of removed deployments on this node. A retention below 1 keeps every deployment.
+// Anything changed in the last siteDeploymentObjectGracePeriod is kept.
+func (m *SiteDeploymentManager) CollectGarbage(retention int) error {
+	deployments, err := m.deploymentList()
+	if err != nil {
+		return err
+	}
+
+	expiredIds := make([]int64, 0)
+	inactive := make(map[int64]int)
+	kept := make(map[string]bool)
+	referenced := make(map[string]bool)
+	for _, deployment := range deployments {
+		if !deployment.IsActive && retention > 0 {
+			inactive[deployment.SiteId]++
+			if inactive[deployment.SiteId] > retention {
+				expiredIds = append(expiredIds, deployment.Id)
+				continue
+			}
+		}
+		kept[deployment.DeploymentKey] = true
+		for _, file := range deployment.Manifest {
+			referenced[file.Hash] = true
+		}
+	}
+
+	if len(expiredIds) > 0 {
+		query, args, err := statementbuilder.Squirrel.Delete("site_deployment").Prepared(true).
+			Where(goqu.Ex{"id": expiredIds}).ToSQL()
+		if err != nil {
+			return err
+		}
+		_, err = m.connection.Exec(query, args...)
+		if err != nil {
+			return err
+		}
+		log.Infof("Removed %d expired site deployments", len(expiredIds))
+	}
+
+	cutoff := time.Now().Add(-siteDeploymentObjectGracePeriod)
+	m.sitesLock.RLock()
+	siteIds := make([]int64, 0, len(m.stores))
+	for siteId := range m.stores {
+		siteIds = append(siteIds, siteId)
+	}
+	m.sitesLock.RUnlock()
+
+	// sites sharing a cloud store share its objects
+	collected := make(map[string]bool)
+	for _, siteId := range siteIds {
+		objects, err := m.objectStore(siteId)
+		if CheckErr(err, "Failed to open the deployment objects of site [%v]", siteId) || collected[fs.ConfigString(objects)] {
+			continue
+		}
+		collected[fs.ConfigString(objects)] = true
+		err = collectSiteDeploymentObjects(objects, referenced, cutoff)
+		CheckErr(err, "Failed to remove unused deployment objects of site [%v]", siteId)
+	}
+
+	err = m.collectLocalDeployments(kept, cutoff)
+	if err != nil {
+		return err
+	}
+	return m.collectLocalObjects(referenced, cutoff)
+}
+
+// CollectGarbagePeriodically runs CollectGarbage every interval, it does not return
+func (m *SiteDeploymentManager) CollectGarbagePeriodically(interval time.Duration, retention int) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		err := m.CollectGarbage(retention)
+		CheckErr(err, "Failed to collect site deployment garbage")
+	}
+}
+
+// collectSiteDeploymentObjects removes the objects of a cloud store no deployment refers to
+func collectSiteDeploymentObjects(objects fs.Fs, referenced map[string]bool, cutoff time.Time) error {
+	ctx := context.Background()
+	var unusedLock sync.Mutex
+	unused := make([]fs.Object, 0)
+	err := operations.ListFn(ctx, objects, func(object fs.Object) {
+		if referenced[path.Base(object.Remote())] || object.ModTime(ctx).After(cutoff) {
+			return
+		}
+		unusedLock.Lock()
+		unused = append(unused, object)
+		unusedLock.Unlock()
+	})
+	if errors.Is(err, fs.ErrorDirNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, object := range unused {
+		err = object.Remove(ctx)
+		if err != nil {
+			return err
+		}
+	}
+	if len(unused) > 0 {
+		log.Infof("Removed %d unused deployment objects from [%v]", len(unused), objects)
+	}
+	return nil
+}
+
+// collectLocalDeployments removes the folders, and their compressed variants, of deployments which no
+// longer exist, unless this node still serves them
+func (m *SiteDeploymentManager) collectLocalDeployments(kept map[string]bool, cutoff time.Time) error {
+	m.active.Range(func(_, value interface{}) bool {
+		kept[value.(*servedDeployment).deploymentKey] = true
+		return true
+	})
+
+	deploymentsPath := filepath.Join(m.root, "deployments")
+	entries, err := os.ReadDir(deploymentsPath)
+	if errors.Is(err, iofs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if kept[strings.TrimSuffix(entry.Name(), ".cache")] {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || info.ModTime().After(cutoff) {
+			continue
+		}
+		err = os.RemoveAll(filepath.Join(deploymentsPath, entry.Name()))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// collectLocalObjects removes the objects of this node no deployment refers to
+func (m *SiteDeploymentManager) collectLocalObjects(referenced map[string]bool, cutoff time.Time) error {
+	err := filepath.WalkDir(filepath.Join(m.root, "objects"), func(filePath string, entry iofs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") || referenced[entry.Name()] {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil || info.ModTime().After(cutoff) {
+			return err
+		}
+		return os.Remove(filePath)
+	})
+	if errors.Is(err, iofs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// deploymentList returns every deployment, newest first
+func (m *SiteDeploymentManager) deploymentList() ([]*SiteDeployment, error) {
+	query, args, err := statementbuilder.Squirrel.Select("id", "deployment_key", "site_id", "manifest", "is_active").
+		Prepared(true).From("site_deployment").Order(goqu.C("id").Desc()).ToSQL()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := m.connection.Queryx(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deployments := make([]*SiteDeployment, 0)
+	for rows.Next() {
+		var deployment SiteDeployment
+		var manifest string
+		err = rows.Scan(&deployment.Id, &deployment.DeploymentKey, &deployment.SiteId, &manifest, &deployment.IsActive)
+		if err != nil {
+			return nil, err
+		}
+		err = json.Unmarshal([]byte(manifest), &deployment.Manifest)
+		if err != nil {
+			return nil, err
+		}
+		deployments = append(deployments, &deployment)
+	}
+	return deployments, rows.Err()
+}
+
+func (m *SiteDeploymentManager) deploymentWhere(where goqu.Ex, queryer sqlx.Queryer) (*SiteDeployment, error) {
+	query, args, err := statementbuilder.Squirrel.Select("id", "reference_id", "deployment_key", "site_id",
+		"content_hash", "manifest", "is_active").Prepared(true).
+		From("site_deployment").Where(where).Order(goqu.C("id").Desc()).Limit(1).ToSQL()
+	if err != nil {
+		return nil, err
+	}
+
+	var deployment SiteDeployment
+	var manifest string
+	err = queryer.QueryRowx(query, args...).Scan(&deployment.Id, &deployment.ReferenceId, &deployment.DeploymentKey,
+		&deployment.SiteId, &deployment.ContentHash, &manifest, &deployment.IsActive)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrSiteDeploymentNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal([]byte(manifest), &deployment.Manifest)
+	return &deployment, err
+}
+
+// newSiteDeploymentKey returns a random key which is also a valid hostname label
+func newSiteDeploymentKey() (string, error) {
+	key := make([]byte, 6)
+	_, err := rand.Read(key)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(key), nil
+}
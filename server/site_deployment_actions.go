@@ -0,0 +1,177 @@
+package server
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+
+	"github.com/artpar/api2go/v2"
+	"github.com/daptin/daptin/server/actionresponse"
+	"github.com/daptin/daptin/server/assetcachepojo"
+	"github.com/daptin/daptin/server/auth"
+	daptinid "github.com/daptin/daptin/server/id"
+	"github.com/daptin/daptin/server/resource"
+	"github.com/daptin/daptin/server/subsite"
+	"github.com/jmoiron/sqlx"
+	log "github.com/sirupsen/logrus"
+)
+
+// siteDeploymentCreatePerformer records a deployment of a site from uploaded files, from a synced
+// folder or from the files the site currently serves, and activates it unless asked not to
+type siteDeploymentCreatePerformer struct {
+	cruds       map[string]*resource.DbResource
+	deployments *SiteDeploymentManager
+}
+
+func (d *siteDeploymentCreatePerformer) Name() string {
+	return "site.deployment.create"
+}
+
+func (d *siteDeploymentCreatePerformer) DoAction(request actionresponse.Outcome, inFields map[string]interface{}, transaction *sqlx.Tx) (api2go.Responder, []actionresponse.ActionResponse, []error) {
+
+	site, err := d.site(inFields, transaction)
+	if err != nil {
+		return nil, nil, []error{err}
+	}
+
+	// sync keeps serving the synced folder until the site is deployed for the first time
+	if onlyIfDeployed, _ := inFields["only_if_deployed"].(bool); onlyIfDeployed && !d.deployments.HasActiveDeployment(site.Id) {
+		return nil, nil, nil
+	}
+
+	source := SiteDeploymentSourceSnapshot
+	sourcePath := ""
+	if files, ok := inFields["files"].([]interface{}); ok && len(files) > 0 {
+		source = SiteDeploymentSourceUpload
+		sourcePath, err = os.MkdirTemp(os.Getenv("DAPTIN_CACHE_FOLDER"), "site-deployment-")
+		if err != nil {
+			return nil, nil, []error{err}
+		}
+		defer os.RemoveAll(sourcePath)
+		err = (&assetcachepojo.AssetFolderCache{LocalSyncPath: sourcePath}).UploadFiles(files)
+		if err != nil {
+			return nil, nil, []error{err}
+		}
+	} else if syncedPath, ok := inFields["source_path"].(string); ok && syncedPath != "" {
+		source = SiteDeploymentSourceSync
+		sourcePath = syncedPath
+	} else {
+		siteCacheFolder, ok := d.cruds["site"].SubsiteFolderCache(site.ReferenceId)
+		if !ok || siteCacheFolder == nil {
+			return nil, nil, []error{errors.New("site files are not available on this node")}
+		}
+		sourcePath = siteCacheFolder.LocalSyncPath
+		if siteCacheFolder.CloudStore.StoreProvider == "local" {
+			sourcePath = filepath.Join(siteCacheFolder.CloudStore.RootPath, siteCacheFolder.Keyname)
+		}
+	}
+
+	var userId *int64
+	if sessionUser, ok := inFields["sessionUser"].(*auth.SessionUser); ok && sessionUser != nil && sessionUser.UserId > 0 {
+		userId = &sessionUser.UserId
+	}
+	deployment, err := d.deployments.CreateDeployment(site, sourcePath, source, userId, transaction)
+	if err != nil {
+		return nil, nil, []error{err}
+	}
+
+	message := "Deployment " + deployment.DeploymentKey + " created"
+	if activate, ok := inFields["activate"].(bool); !ok || activate {
+		err = d.deployments.Activate(site, deployment, transaction)
+		if err != nil {
+			return nil, nil, []error{err}
+		}
+		message = "Deployment " + deployment.DeploymentKey + " is live"
+	}
+
+	return nil, []actionresponse.ActionResponse{
+		resource.NewActionResponse("client.notify", map[string]interface{}{
+			"type":    "success",
+			"title":   "Success",
+			"message": message,
+		}),
+	}, nil
+}
+
+func (d *siteDeploymentCreatePerformer) site(inFields map[string]interface{}, transaction *sqlx.Tx) (subsite.SubSite, error) {
+	siteId := daptinid.InterfaceToDIR(inFields["site_id"])
+	if siteId == daptinid.NullReferenceId {
+		return subsite.SubSite{}, errors.New("invalid site_id")
+	}
+	site, ok := d.deployments.Site(siteId)
+	if !ok {
+		return subsite.SubSite{}, errors.New("site is not served on this node")
+	}
+	return site, checkSiteDeploymentPermission(d.cruds, inFields, site, transaction)
+}
+
+// siteDeploymentActivatePerformer points a site at one of its earlier deployments
+type siteDeploymentActivatePerformer struct {
+	cruds       map[string]*resource.DbResource
+	deployments *SiteDeploymentManager
+}
+
+func (d *siteDeploymentActivatePerformer) Name() string {
+	return "site.deployment.activate"
+}
+
+func (d *siteDeploymentActivatePerformer) DoAction(request actionresponse.Outcome, inFields map[string]interface{}, transaction *sqlx.Tx) (api2go.Responder, []actionresponse.ActionResponse, []error) {
+
+	deploymentId := daptinid.InterfaceToDIR(inFields["deployment_id"])
+	if deploymentId == daptinid.NullReferenceId {
+		return nil, nil, []error{errors.New("invalid deployment_id")}
+	}
+	deployment, err := d.deployments.Deployment(deploymentId, transaction)
+	if err != nil {
+		return nil, nil, []error{err}
+	}
+	site, ok := d.deployments.siteById(deployment.SiteId)
+	if !ok {
+		return nil, nil, []error{errors.New("site is not served on this node")}
+	}
+	err = checkSiteDeploymentPermission(d.cruds, inFields, site, transaction)
+	if err != nil {
+		return nil, nil, []error{err}
+	}
+
+	err = d.deployments.Activate(site, deployment, transaction)
+	if err != nil {
+		return nil, nil, []error{err}
+	}
+	log.Infof("Site [%v] rolled to deployment [%v]", site.Hostname, deployment.DeploymentKey)
+
+	return nil, []actionresponse.ActionResponse{
+		resource.NewActionResponse("client.notify", map[string]interface{}{
+			"type":    "success",
+			"title":   "Success",
+			"message": "Deployment " + deployment.DeploymentKey + " is live",
+		}),
+	}, nil
+}
+
+// checkSiteDeploymentPermission needs update permission on the site. Calls without a session user
+// come from the sync task and are trusted.
+func checkSiteDeploymentPermission(cruds map[string]*resource.DbResource, inFields map[string]interface{}, site subsite.SubSite, transaction *sqlx.Tx) error {
+	sessionUser, ok := inFields["sessionUser"].(*auth.SessionUser)
+	if !ok || sessionUser == nil {
+		return nil
+	}
+	sitePermission := resource.GetObjectPermissionByReferenceIdWithTransaction("site", site.ReferenceId, transaction)
+	if !sitePermission.CanUpdate(sessionUser.UserReferenceId, sessionUser.Groups, cruds["site"].AdministratorGroupId) {
+		return errors.New("permission denied")
+	}
+	return nil
+}
+
+func NewSiteDeploymentPerformers(cruds map[string]*resource.DbResource, deployments *SiteDeploymentManager) []actionresponse.ActionPerformerInterface {
+	return []actionresponse.ActionPerformerInterface{
+		&siteDeploymentCreatePerformer{
+			cruds:       cruds,
+			deployments: deployments,
+		},
+		&siteDeploymentActivatePerformer{
+			cruds:       cruds,
+			deployments: deployments,
+		},
+	}
+}
@@ -0,0 +1,219 @@
+package server
+
+import (
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/daptin/daptin/server/assetcachepojo"
+	"github.com/daptin/daptin/server/rootpojo"
+	"github.com/daptin/daptin/server/subsite"
+	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func siteDeploymentTestDatabase(t *testing.T) *sqlx.DB {
+	db, err := sqlx.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	_, err = db.Exec(`create table site_deployment (id integer primary key autoincrement, reference_id blob,
+		deployment_key varchar(20) unique, site_id int, content_hash varchar(64), manifest text, file_count int,
+		total_size bigint, source varchar(20), is_active bool default false, user_account_id int, permission int,
+		version int default 1, created_at timestamp default current_timestamp, updated_at timestamp)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func siteDeploymentTestFiles(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, contents := range files {
+		filePath := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(filePath), 0750); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filePath, []byte(contents), 0640); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func siteDeploymentTestGet(handler http.Handler, host string, path string) string {
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodGet, path, nil)
+	request.Host = host
+	handler.ServeHTTP(recorder, request)
+	return recorder.Body.String()
+}
+
+func TestSiteDeploymentActivationAndRollback(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db := siteDeploymentTestDatabase(t)
+	publisher := &yjsTestPublisher{}
+	manager := NewSiteDeploymentManager(db, t.TempDir(), publisher, "node-a")
+	site := subsite.SubSite{Id: 1, Hostname: "deploy.example.test"}
+	cloudStorePath := t.TempDir()
+	store := &assetcachepojo.AssetFolderCache{
+		CloudStore: rootpojo.CloudStore{StoreProvider: "local", RootPath: cloudStorePath},
+	}
+	storedObjects := func() []string {
+		objects := make([]string, 0)
+		filepath.WalkDir(filepath.Join(cloudStorePath, siteDeploymentObjectsFolder), func(filePath string, entry fs.DirEntry, err error) error {
+			if err == nil && !entry.IsDir() {
+				objects = append(objects, entry.Name())
+			}
+			return nil
+		})
+		return objects
+	}
+
+	router := gin.New()
+	router.NoRoute(manager.RequestHandler(site, func(c *gin.Context) {
+		c.String(http.StatusOK, "synced folder")
+	}))
+	track := func(manager *SiteDeploymentManager) {
+		transaction, err := db.Beginx()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer transaction.Rollback()
+		manager.Track(site, store, transaction)
+	}
+	track(manager)
+	if body := siteDeploymentTestGet(router, site.Hostname, "/"); body != "synced folder" {
+		t.Fatalf("site without deployments served %q", body)
+	}
+
+	deploy := func(files map[string]string) *SiteDeployment {
+		transaction, err := db.Beginx()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer transaction.Commit()
+		deployment, err := manager.CreateDeployment(site, siteDeploymentTestFiles(t, files), SiteDeploymentSourceUpload, nil, transaction)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = manager.Activate(site, deployment, transaction); err != nil {
+			t.Fatal(err)
+		}
+		return deployment
+	}
+
+	first := deploy(map[string]string{"index.html": "<html>first</html>", "css/site.css": "body{}"})
+	if body := siteDeploymentTestGet(router, site.Hostname, "/"); !strings.Contains(body, "first") {
+		t.Fatalf("first deployment not served, got %q", body)
+	}
+	second := deploy(map[string]string{"index.html": "<html>second</html>", "css/site.css": "body{}"})
+	if body := siteDeploymentTestGet(router, site.Hostname, "/"); !strings.Contains(body, "second") {
+		t.Fatalf("second deployment not served, got %q", body)
+	}
+	if first.DeploymentKey == second.DeploymentKey || len(second.Manifest) != 2 {
+		t.Fatalf("unexpected deployments %v %v", first, second)
+	}
+	if first.Manifest[0].Hash != second.Manifest[0].Hash {
+		t.Fatal("unchanged file stored twice")
+	}
+
+	// deploying the same files again reuses the deployment, activating it is the rollback
+	again := deploy(map[string]string{"index.html": "<html>first</html>", "css/site.css": "body{}"})
+	if again.Id != first.Id {
+		t.Fatalf("identical files created deployment %v instead of reusing %v", again.DeploymentKey, first.DeploymentKey)
+	}
+	if body := siteDeploymentTestGet(router, site.Hostname, "/"); !strings.Contains(body, "first") {
+		t.Fatalf("rollback not served, got %q", body)
+	}
+	var active []string
+	if err := db.Select(&active, "select deployment_key from site_deployment where is_active = 1"); err != nil {
+		t.Fatal(err)
+	}
+	if len(active) != 1 || active[0] != first.DeploymentKey {
+		t.Fatalf("expected only %v to be active, got %v", first.DeploymentKey, active)
+	}
+	if len(publisher.payloads) != 3 {
+		t.Fatalf("expected every activation to be announced, got %d", len(publisher.payloads))
+	}
+
+	if objects := storedObjects(); len(objects) != 3 {
+		t.Fatalf("expected the 3 distinct files in the cloud store, got %v", objects)
+	}
+
+	// another node, without the files of this one, serves the active deployment from the cloud store
+	other := NewSiteDeploymentManager(db, t.TempDir(), nil, "node-b")
+	track(other)
+	if !other.HasActiveDeployment(site.Id) {
+		t.Fatal("active deployment not loaded on start")
+	}
+	otherRouter := gin.New()
+	otherRouter.NoRoute(other.RequestHandler(site, func(c *gin.Context) {
+		c.String(http.StatusOK, "synced folder")
+	}))
+	if body := siteDeploymentTestGet(otherRouter, site.Hostname, "/"); !strings.Contains(body, "first") {
+		t.Fatalf("other node served %q", body)
+	}
+
+	preview, previewSite, ok := manager.PreviewRouter(second.DeploymentKey + "." + site.Hostname)
+	if !ok || previewSite.Id != site.Id {
+		t.Fatalf("preview of %v not available", second.DeploymentKey)
+	}
+	if body := siteDeploymentTestGet(preview, second.DeploymentKey+"."+site.Hostname, "/css/site.css"); body != "body{}" {
+		t.Fatalf("preview served %q", body)
+	}
+	if _, _, ok = manager.PreviewRouter("000000000000." + site.Hostname); ok {
+		t.Fatal("preview served for an unknown deployment")
+	}
+
+	// expired previews are dropped when another preview is looked up
+	value, _ := manager.previews.Load(second.DeploymentKey + "." + site.Hostname)
+	value.(*previewDeployment).expiresAt = time.Now().Add(-time.Second)
+	manager.PreviewRouter("000000000000." + site.Hostname)
+	if _, ok = manager.previews.Load(second.DeploymentKey + "." + site.Hostname); ok {
+		t.Fatal("expired preview kept")
+	}
+
+	// only the newest inactive deployment is kept, with the objects it refers to
+	third := deploy(map[string]string{"index.html": "<html>third</html>", "css/site.css": "body{}"})
+	aged := time.Now().Add(-2 * siteDeploymentObjectGracePeriod)
+	for _, folder := range []string{cloudStorePath, manager.root} {
+		filepath.WalkDir(folder, func(filePath string, entry fs.DirEntry, err error) error {
+			if err == nil {
+				os.Chtimes(filePath, aged, aged)
+			}
+			return nil
+		})
+	}
+	if err := manager.CollectGarbage(1); err != nil {
+		t.Fatal(err)
+	}
+	var kept []string
+	if err := db.Select(&kept, "select deployment_key from site_deployment order by id"); err != nil {
+		t.Fatal(err)
+	}
+	if len(kept) != 2 || kept[0] != second.DeploymentKey || kept[1] != third.DeploymentKey {
+		t.Fatalf("expected %v and %v to be kept, got %v", second.DeploymentKey, third.DeploymentKey, kept)
+	}
+	if objects := storedObjects(); len(objects) != 3 {
+		t.Fatalf("expected the objects of the first deployment to be removed, got %v", objects)
+	}
+	if _, err := os.Stat(manager.objectPath(first.Manifest[1].Hash)); !os.IsNotExist(err) {
+		t.Fatalf("expected the local object of the first deployment to be removed, got %v", err)
+	}
+	if PathExistsAndIsFolder(filepath.Join(manager.root, "deployments", first.DeploymentKey)) {
+		t.Fatal("expected the folder of the first deployment to be removed")
+	}
+	if body := siteDeploymentTestGet(router, site.Hostname, "/"); !strings.Contains(body, "third") {
+		t.Fatalf("active deployment not served after garbage collection, got %q", body)
+	}
+}
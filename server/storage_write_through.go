@@ -0,0 +1,125 @@
+package server
+
+import (
+	"errors"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/daptin/daptin/server/actionresponse"
+	"github.com/daptin/daptin/server/assetcachepojo"
+	"github.com/daptin/daptin/server/resource"
+	log "github.com/sirupsen/logrus"
+)
+
+// Files written over SFTP and WebDAV land in a local folder first and are then pushed to the cloud store
+// using the same actions the dashboard uses. Failures are logged, the local copy is already updated and
+// the next storage sync retries.
+
+// storeRootPath returns the rclone root of an asset folder, the store root path followed by the key name
+func storeRootPath(cache *assetcachepojo.AssetFolderCache) (string, error) {
+	if cache == nil || cache.CloudStore.RootPath == "" {
+		return "", errors.New("no cloud store")
+	}
+	rootPath := cache.CloudStore.RootPath
+	if cache.Keyname != "" {
+		rootPath = rootPath + "/" + cache.Keyname
+	}
+	return rootPath, nil
+}
+
+// localRelativePath returns fullPath relative to localRoot in slash form
+func localRelativePath(localRoot string, fullPath string) (string, error) {
+	localRoot, err := filepath.Abs(localRoot)
+	if err != nil {
+		return "", err
+	}
+	relativePath, err := filepath.Rel(filepath.Clean(localRoot), fullPath)
+	if err != nil {
+		return "", err
+	}
+	return filepath.ToSlash(relativePath), nil
+}
+
+func runStorageAction(cruds map[string]*resource.DbResource, actionName string, cache *assetcachepojo.AssetFolderCache, inFields map[string]interface{}) {
+	rootPath, err := storeRootPath(cache)
+	if err != nil {
+		return
+	}
+	performer, ok := resource.GetGlobalActionHandler(actionName)
+	if !ok {
+		log.Errorf("Action [%v] is not registered, cannot write through to cloud store", actionName)
+		return
+	}
+	inFields["root_path"] = rootPath
+	inFields["credential_name"] = cache.CloudStore.CredentialName
+
+	transaction, err := cruds["cloud_store"].Connection().Beginx()
+	if err != nil {
+		resource.CheckErr(err, "Failed to begin transaction for [%v]", actionName)
+		return
+	}
+	_, _, errs := performer.DoAction(actionresponse.Outcome{}, inFields, transaction)
+	if len(errs) > 0 {
+		_ = transaction.Rollback()
+		log.Errorf("[%v] failed for [%v]: %v", actionName, rootPath, errs)
+		return
+	}
+	err = transaction.Commit()
+	resource.CheckErr(err, "Failed to commit transaction for [%v]", actionName)
+}
+
+// uploadToCloudStore uploads the local file to relativePath in the cloud store with the
+// cloudstore.file.upload action, in the background. The action reads the file as it copies it, so
+// large files are not held in memory.
+func uploadToCloudStore(cruds map[string]*resource.DbResource, cache *assetcachepojo.AssetFolderCache, relativePath string, localPath string) {
+	relativeDir := path.Dir(relativePath)
+	if relativeDir == "." {
+		relativeDir = ""
+	}
+
+	go func() {
+		localFile, err := os.Open(localPath)
+		if resource.CheckErr(err, "Failed to open [%v] for upload", localPath) {
+			return
+		}
+		defer localFile.Close()
+		runStorageAction(cruds, "cloudstore.file.upload", cache, map[string]interface{}{
+			"path": relativeDir,
+			"file": []interface{}{
+				map[string]interface{}{
+					"name":   path.Base(relativePath),
+					"reader": localFile,
+				},
+			},
+		})
+	}()
+}
+
+func deleteFromCloudStore(cruds map[string]*resource.DbResource, cache *assetcachepojo.AssetFolderCache, relativePath string, isDir bool) {
+	// site.file.delete purges remote paths ending with a slash as directories
+	if isDir {
+		relativePath = relativePath + "/"
+	}
+	runStorageAction(cruds, "site.file.delete", cache, map[string]interface{}{
+		"path": relativePath,
+	})
+}
+
+func moveInCloudStore(cruds map[string]*resource.DbResource, cache *assetcachepojo.AssetFolderCache, fromRelative string, toRelative string) {
+	runStorageAction(cruds, "cloudstore.path.move", cache, map[string]interface{}{
+		"source":      fromRelative,
+		"destination": toRelative,
+	})
+}
+
+func createFolderInCloudStore(cruds map[string]*resource.DbResource, cache *assetcachepojo.AssetFolderCache, relativePath string) {
+	parentDir := path.Dir(relativePath)
+	if parentDir == "." {
+		parentDir = ""
+	}
+	runStorageAction(cruds, "cloudstore.folder.create", cache, map[string]interface{}{
+		"path": parentDir,
+		"name": path.Base(relativePath),
+	})
+}
@@ -0,0 +1,34 @@
+package server
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/daptin/daptin/server/assetcachepojo"
+	"github.com/daptin/daptin/server/rootpojo"
+)
+
+func TestStoreRootPath(t *testing.T) {
+	if _, err := storeRootPath(nil); err == nil {
+		t.Fatal("expected a missing asset folder to be rejected")
+	}
+	if _, err := storeRootPath(&assetcachepojo.AssetFolderCache{}); err == nil {
+		t.Fatal("expected an asset folder without cloud store to be rejected")
+	}
+
+	rootPath, err := storeRootPath(&assetcachepojo.AssetFolderCache{
+		Keyname:    "sites/cloud",
+		CloudStore: rootpojo.CloudStore{RootPath: "s3:bucket", CredentialName: "s3-credential"},
+	})
+	if err != nil || rootPath != "s3:bucket/sites/cloud" {
+		t.Fatalf("unexpected root path %q %v", rootPath, err)
+	}
+}
+
+func TestLocalRelativePath(t *testing.T) {
+	root := t.TempDir()
+	relativePath, err := localRelativePath(root, filepath.Join(root, "assets", "app.js"))
+	if err != nil || relativePath != "assets/app.js" {
+		t.Fatalf("unexpected relative path %q %v", relativePath, err)
+	}
+}
@@ -11,9 +11,15 @@ import (
 )
 
 func CreateSubsiteEngine(site subsite.SubSite, assetCache *assetcachepojo.AssetFolderCache, middlewares []gin.HandlerFunc, gzipEnabled ...bool) *gin.Engine {
+	enableGzip := len(gzipEnabled) == 0 || gzipEnabled[0]
+	log.Tracef("Serve subsite[%s] from source [%s]", site.Name, assetCache.LocalSyncPath)
+	return createSubsiteEngine(SubsiteRequestHandler(site, assetCache, enableGzip), middlewares)
+}
+
+// createSubsiteEngine builds the router of a site around the handler serving its files
+func createSubsiteEngine(requestHandler gin.HandlerFunc, middlewares []gin.HandlerFunc) *gin.Engine {
 	subsiteStats := stats.New()
 	hostRouter := gin.New()
-	enableGzip := len(gzipEnabled) == 0 || gzipEnabled[0]
 
 	hostRouter.Use(func() gin.HandlerFunc {
 		return func(c *gin.Context) {
@@ -33,11 +39,9 @@ func CreateSubsiteEngine(site subsite.SubSite, assetCache *assetcachepojo.AssetF
 		c.JSON(200, subsiteStats.Data())
 	})
 
-	log.Tracef("Serve subsite[%s] from source [%s]", site.Name, assetCache.LocalSyncPath)
-
 	// Create a custom middleware for serving static files with aggressive caching
 	//hostRouter.Any("/", SubsiteRequestHandler(site, tempDirectoryPath))
-	hostRouter.NoRoute(SubsiteRequestHandler(site, assetCache, enableGzip))
+	hostRouter.NoRoute(requestHandler)
 
 	hostRouter.Handle("GET", "/statistics", func(c *gin.Context) {
 		c.JSON(http.StatusOK, Stats.Data())
@@ -334,3 +334,34 @@ func abortSubsiteStorageError(c *gin.Context, err error) {
 	}
 	c.Status(http.StatusBadGateway)
 }
+
+// invalidateSubsiteHostCaches drops the cached index.html and missing file entries of a site, so the
+// next request reads the files the site is now served from
+func invalidateSubsiteHostCaches(hostnames []string) {
+	matchesHost := func(host string) bool {
+		host = strings.Split(host, ":")[0]
+		for _, hostname := range hostnames {
+			if host == hostname {
+				return true
+			}
+		}
+		return false
+	}
+	indexCache.Range(func(key, value interface{}) bool {
+		if matchesHost(key.(string)) {
+			indexCache.Delete(key)
+		}
+		return true
+	})
+	negativeCache.Range(func(key, value interface{}) bool {
+		// negative cache keys are host:path, the host may carry a port
+		keyString := key.(string)
+		for _, hostname := range hostnames {
+			if strings.HasPrefix(keyString, hostname+":") {
+				negativeCache.Delete(key)
+				break
+			}
+		}
+		return true
+	})
+}
@@ -24,7 +24,7 @@ import (
 
 func CreateSubSites(cmsConfig *resource.CmsConfig, transaction *sqlx.Tx,
 	cruds map[string]*resource.DbResource, authMiddleware *auth.AuthMiddleware,
-	rateConfig RateConfig, max_connections int, olricClient *olric.EmbeddedClient, gzipEnabled ...bool) (hostswitch.HostSwitch, map[daptinid.DaptinReferenceId]*assetcachepojo.AssetFolderCache) {
+	rateConfig RateConfig, max_connections int, olricClient *olric.EmbeddedClient, deployments *SiteDeploymentManager, gzipEnabled ...bool) (hostswitch.HostSwitch, map[daptinid.DaptinReferenceId]*assetcachepojo.AssetFolderCache) {
 	enableGzip := len(gzipEnabled) == 0 || gzipEnabled[0]
 
 	hs := hostswitch.HostSwitch{
@@ -34,6 +34,17 @@ func CreateSubSites(cmsConfig *resource.CmsConfig, transaction *sqlx.Tx,
 	hs.HandlerMap = make(map[string]*gin.Engine)
 	hs.SiteMap = make(map[string]subsite.SubSite)
 	hs.AuthMiddleware = authMiddleware
+	if deployments != nil {
+		hs.Previews = deployments
+	}
+
+	tenants, err := resource.NewTenantRegistry(cruds["tenant"].Connection())
+	if err != nil {
+		log.Errorf("Failed to get all tenants: %v", err)
+	} else {
+		log.Infof("Serving %d tenant hostnames", tenants.Count())
+	}
+	hs.Tenants = tenants
 
 	// Initialize the subsite cache with Olric client
 	if olricClient != nil {
@@ -78,6 +89,10 @@ func CreateSubSites(cmsConfig *resource.CmsConfig, transaction *sqlx.Tx,
 	rateLimiter := CreateSubsiteRateLimiterMiddleware(rateConfig, olricClient)
 	maxLimiter := limit.MaxAllowed(max_connections)
 	middlewares := []gin.HandlerFunc{rateLimiter, maxLimiter, authMiddleware.AuthCheckMiddleware}
+	if deployments != nil {
+		deployments.middlewares = middlewares
+		deployments.enableGzip = enableGzip
+	}
 
 	for _, site := range sites {
 
@@ -158,7 +173,14 @@ func CreateSubSites(cmsConfig *resource.CmsConfig, transaction *sqlx.Tx,
 
 		resource.CheckErr(err, "Failed to register task to sync storage")
 
-		hostRouter := CreateSubsiteEngine(site, subsiteAssetCache, middlewares, enableGzip)
+		var hostRouter *gin.Engine
+		if deployments != nil {
+			// sites with an active deployment are served from it, the synced folder is the fallback
+			deployments.Track(site, subsiteAssetCache, transaction)
+			hostRouter = createSubsiteEngine(deployments.RequestHandler(site, SubsiteRequestHandler(site, subsiteAssetCache, enableGzip)), middlewares)
+		} else {
+			hostRouter = CreateSubsiteEngine(site, subsiteAssetCache, middlewares, enableGzip)
+		}
 
 		hs.HandlerMap[site.Hostname] = hostRouter
 		siteMap[subSiteInformation.SubSite.Hostname] = subSiteInformation
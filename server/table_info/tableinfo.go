@@ -104,32 +104,116 @@ type MeteringConfig struct {
 	OnActions          map[string]MeteringConfig `json:"on_actions,omitempty"`
 }
 
+// ResultCacheConfig turns on the caching of the list and aggregate results of a table, a result is
+// kept for TtlSeconds unless the table or a related table is written to before
+type ResultCacheConfig struct {
+	Enabled    bool `json:"enabled,omitempty"`
+	TtlSeconds int  `json:"ttl_seconds,omitempty"`
+}
+
+// TableIndex is a secondary index declared in the schema of a table. Columns are indexed in order,
+// Expression is indexed instead of the columns when set. Where makes a partial index on sqlite and
+// postgres, Method picks the postgres index type (btree, hash, gin, gist, brin).
+type TableIndex struct {
+	Columns    []string `json:"columns,omitempty"`
+	Expression string   `json:"expression,omitempty"`
+	Unique     bool     `json:"unique,omitempty"`
+	Where      string   `json:"where,omitempty"`
+	Method     string   `json:"method,omitempty"`
+}
+
+// ComputedColumn is a column derived from the other columns of its row. Expression is javascript
+// evaluated by daptin with the values of the row as variables, Sql is an expression evaluated by the
+// database as a generated column. Stored expression columns are written on every create and update,
+// other expression columns are computed when read and cannot be filtered or sorted on.
+type ComputedColumn struct {
+	Name              string `json:"name"`
+	ColumnType        string `json:"column_type,omitempty"`
+	DataType          string `json:"data_type,omitempty"`
+	ColumnDescription string `json:"column_description,omitempty"`
+	Expression        string `json:"expression,omitempty"`
+	Sql               string `json:"sql,omitempty"`
+	Stored            bool   `json:"stored,omitempty"`
+}
+
+// IsVirtual is true for an expression column which has no column in the table
+func (c ComputedColumn) IsVirtual() bool {
+	return c.Sql == "" && !c.Stored
+}
+
+// ColumnInfo is the column of the table which holds a stored or generated computed column
+func (c ComputedColumn) ColumnInfo() api2go.ColumnInfo {
+	return api2go.ColumnInfo{
+		Name:              c.Name,
+		ColumnName:        c.Name,
+		ColumnType:        c.ColumnType,
+		DataType:          c.DataType,
+		ColumnDescription: c.ColumnDescription,
+		IsNullable:        true,
+	}
+}
+
+// RollupColumn is a column holding an aggregate of the rows related to each row through Relation, the
+// name of a has_many relation of the table or of a belongs_to relation pointing at it. Function is one
+// of count, sum, min, max and latest, the value of Column in the related row created last. The column
+// is kept up to date when related rows are created, updated or deleted.
+type RollupColumn struct {
+	Name              string `json:"name"`
+	Relation          string `json:"relation"`
+	Function          string `json:"function"`
+	Column            string `json:"column,omitempty"`
+	ColumnType        string `json:"column_type,omitempty"`
+	DataType          string `json:"data_type,omitempty"`
+	ColumnDescription string `json:"column_description,omitempty"`
+}
+
+// ColumnInfo is the column of the table which holds the rollup
+func (c RollupColumn) ColumnInfo() api2go.ColumnInfo {
+	return api2go.ColumnInfo{
+		Name:              c.Name,
+		ColumnName:        c.Name,
+		ColumnType:        c.ColumnType,
+		DataType:          c.DataType,
+		ColumnDescription: c.ColumnDescription,
+		IsNullable:        true,
+	}
+}
+
 type TableInfo struct {
-	TableName              string `db:"table_name"`
-	TableId                int
-	TableDescription       string
-	DefaultPermission      auth.AuthPermission
-	Columns                []api2go.ColumnInfo
-	StateMachines          []fsm.LoopbookFsmDescription
-	Relations              []api2go.TableRelation
-	IsTopLevel             bool `db:"is_top_level"`
-	Permission             auth.AuthPermission
-	UserId                 uint64              `db:"user_account_id"`
-	IsHidden               bool                `db:"is_hidden"`
-	IsJoinTable            bool                `db:"is_join_table"`
-	IsStateTrackingEnabled bool                `db:"is_state_tracking_enabled"`
-	IsAuditEnabled         bool                `db:"is_audit_enabled"`
-	TranslationsEnabled    bool                `db:"translation_enabled"`
-	DefaultGroups          DefaultGroupList    `db:"default_groups"`
-	AccessGroups           DefaultGroupList    `db:"access_groups"`
-	DefaultRelations       map[string][]string `db:"default_relations"`
-	Validations            []columns.ColumnTag
-	Conformations          []columns.ColumnTag
-	DefaultOrder           string
-	Icon                   string
-	CompositeKeys          [][]string
-	Metering               *MeteringConfig `json:"metering,omitempty"`
-	ExplicitFields         map[string]bool `json:"-" db:"-"`
+	TableName               string `db:"table_name"`
+	TableId                 int
+	TableDescription        string
+	DefaultPermission       auth.AuthPermission
+	Columns                 []api2go.ColumnInfo
+	StateMachines           []fsm.LoopbookFsmDescription
+	Relations               []api2go.TableRelation
+	IsTopLevel              bool `db:"is_top_level"`
+	Permission              auth.AuthPermission
+	UserId                  uint64              `db:"user_account_id"`
+	IsHidden                bool                `db:"is_hidden"`
+	IsJoinTable             bool                `db:"is_join_table"`
+	IsStateTrackingEnabled  bool                `db:"is_state_tracking_enabled"`
+	IsAuditEnabled          bool                `db:"is_audit_enabled"`
+	TranslationsEnabled     bool                `db:"translation_enabled"`
+	DefaultGroups           DefaultGroupList    `db:"default_groups"`
+	AccessGroups            DefaultGroupList    `db:"access_groups"`
+	DefaultRelations        map[string][]string `db:"default_relations"`
+	Validations             []columns.ColumnTag
+	Conformations           []columns.ColumnTag
+	DefaultOrder            string
+	Icon                    string
+	CompositeKeys           [][]string
+	Indexes                 []TableIndex
+	Metering                *MeteringConfig    `json:"metering,omitempty"`
+	ResultCache             *ResultCacheConfig `json:"result_cache,omitempty"`
+	SoftDelete              bool
+	SoftDeleteRetentionDays int
+	TenantScoped            bool
+	OnDelete                map[string]string
+	Searchable              []string
+	ComputedColumns         []ComputedColumn `json:"computed_columns,omitempty"`
+	RollupColumns           []RollupColumn   `json:"rollup_columns,omitempty"`
+	ExplicitFields          map[string]bool  `json:"-" db:"-"`
 }
 
 func (ti *TableInfo) GetColumnByName(name string) (*api2go.ColumnInfo, bool) {
@@ -143,6 +227,33 @@ func (ti *TableInfo) GetColumnByName(name string) (*api2go.ColumnInfo, bool) {
 	return nil, false
 
 }
+
+// GetComputedColumn returns the computed column named name
+func (ti *TableInfo) GetComputedColumn(name string) (*ComputedColumn, bool) {
+
+	for _, col := range ti.ComputedColumns {
+		if col.Name == name {
+			return &col, true
+		}
+	}
+
+	return nil, false
+
+}
+
+// GetRollupColumn returns the rollup column named name
+func (ti *TableInfo) GetRollupColumn(name string) (*RollupColumn, bool) {
+
+	for _, col := range ti.RollupColumns {
+		if col.Name == name {
+			return &col, true
+		}
+	}
+
+	return nil, false
+
+}
+
 func (ti *TableInfo) GetRelationByName(name string) (*api2go.TableRelation, bool) {
 
 	for _, relation := range ti.Relations {
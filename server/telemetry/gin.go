@@ -0,0 +1,83 @@
+package telemetry
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// unknownTable is the table label of requests naming a table knownTable does not know
+const unknownTable = "unknown"
+
+// Middleware records the latency of every request by route and table, and wraps the request in a
+// server span continuing the trace context of the caller. Tables are labelled only when knownTable
+// knows them, so requests for made up tables do not add series
+func Middleware(knownTable func(tableName string) bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		record(c, knownTable)
+	}
+}
+
+func record(c *gin.Context, knownTable func(tableName string) bool) {
+	start := time.Now()
+	route := c.FullPath()
+	if route == "" {
+		// subsites, assets and unknown paths, kept out of the labels
+		route = "unmatched"
+	}
+	table := routeTable(c, route)
+	if table != "" && !knownTable(table) {
+		table = unknownTable
+	}
+
+	ctx := otel.GetTextMapPropagator().Extract(c.Request.Context(), propagation.HeaderCarrier(c.Request.Header))
+	ctx, span := otel.Tracer(tracerName).Start(ctx, c.Request.Method+" "+route,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("http.request.method", c.Request.Method),
+			attribute.String("http.route", route),
+			attribute.String("url.path", c.Request.URL.Path),
+		))
+	c.Request = c.Request.WithContext(ctx)
+
+	c.Next()
+
+	status := c.Writer.Status()
+	span.SetAttributes(attribute.Int("http.response.status_code", status))
+	if status >= 500 {
+		span.SetStatus(codes.Error, http.StatusText(status))
+	}
+	if table != "" {
+		span.SetAttributes(attribute.String("db.collection.name", table))
+	}
+	span.End()
+
+	httpRequests.WithLabelValues(c.Request.Method, route, table, strconv.Itoa(status)).Inc()
+	httpRequestDuration.WithLabelValues(c.Request.Method, route, table).Observe(time.Since(start).Seconds())
+}
+
+// routeTable is the table a request works on, the typename parameter of the route or the entity of
+// an /api path
+func routeTable(c *gin.Context, route string) string {
+	if table := c.Param("typename"); table != "" && strings.Contains(route, ":typename") {
+		return table
+	}
+	if strings.HasPrefix(route, "/api/") {
+		return strings.Split(route, "/")[2]
+	}
+	return ""
+}
+
+// MetricsHandler serves the metrics in the Prometheus text format
+func MetricsHandler() gin.HandlerFunc {
+	return gin.WrapH(promhttp.HandlerFor(Registry, promhttp.HandlerOpts{}))
+}
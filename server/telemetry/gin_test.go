@@ -0,0 +1,84 @@
+package telemetry
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestMiddlewareRecordsRouteAndTable(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	router := gin.New()
+	router.Use(Middleware(func(tableName string) bool {
+		return tableName == "book"
+	}))
+	router.GET("/metrics", MetricsHandler())
+
+	var traceId string
+	router.GET("/api/:typename", func(c *gin.Context) {
+		traceId = trace.SpanContextFromContext(c.Request.Context()).TraceID().String()
+		c.Status(http.StatusOK)
+	})
+
+	request := httptest.NewRequest("GET", "/api/book", nil)
+	request.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	router.ServeHTTP(httptest.NewRecorder(), request)
+
+	if traceId != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("the trace of the caller should be continued, got trace id [%s]", traceId)
+	}
+	if headers := PropagationHeaders(request.Context()); len(headers) != 0 {
+		t.Errorf("a context without a span should not propagate headers, got %v", headers)
+	}
+
+	for _, madeUp := range []string{"/api/a1", "/api/a2", "/api/a3"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", madeUp, nil))
+	}
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := io.ReadAll(recorder.Body)
+	for _, expected := range []string{
+		`daptin_http_requests_total{method="GET",route="/api/:typename",status="200",table="book"} 1`,
+		`daptin_http_requests_total{method="GET",route="/api/:typename",status="200",table="unknown"} 3`,
+	} {
+		if !strings.Contains(string(body), expected) {
+			t.Errorf("metrics should contain [%s], got\n%s", expected, body)
+		}
+	}
+	if strings.Contains(string(body), `table="a1"`) {
+		t.Errorf("tables which do not exist should not be labelled")
+	}
+}
+
+func TestMailSessionOutcome(t *testing.T) {
+	MailSession("imap", nil)
+	MailSession("imap", io.EOF)
+
+	recorder := httptest.NewRecorder()
+	MetricsHandler()(newContext(recorder))
+	body := recorder.Body.String()
+	for _, expected := range []string{
+		`daptin_mail_sessions_total{outcome="success",protocol="imap"} 1`,
+		`daptin_mail_sessions_total{outcome="error",protocol="imap"} 1`,
+	} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("metrics should contain [%s]", expected)
+		}
+	}
+}
+
+func newContext(recorder *httptest.ResponseRecorder) *gin.Context {
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest("GET", "/metrics", nil)
+	return c
+}
@@ -0,0 +1,188 @@
+package telemetry
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+)
+
+// Registry holds the metrics served on /metrics
+var Registry = prometheus.NewRegistry()
+
+var (
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "daptin",
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by route, table and status code.",
+	}, []string{"method", "route", "table", "status"})
+	httpRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "daptin",
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by route and table.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route", "table"})
+
+	dbOperations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "daptin",
+		Name:      "db_operations_total",
+		Help:      "Find all, find one, create, update and delete calls on a table resource.",
+	}, []string{"table", "operation", "outcome"})
+	dbOperationDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "daptin",
+		Name:      "db_operation_duration_seconds",
+		Help:      "Duration of the calls on a table resource, interceptors included.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"table", "operation"})
+
+	interceptorDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "daptin",
+		Name:      "interceptor_duration_seconds",
+		Help:      "Duration of the database request interceptors.",
+		Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+	}, []string{"interceptor", "operation", "phase"})
+
+	actionExecutions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "daptin",
+		Name:      "action_executions_total",
+		Help:      "Action outcomes executed by performer.",
+	}, []string{"performer", "outcome"})
+	actionDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "daptin",
+		Name:      "action_duration_seconds",
+		Help:      "Duration of the action performers.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"performer"})
+
+	cacheRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "daptin",
+		Name:      "cache_requests_total",
+		Help:      "Olric cache lookups by result: hit, miss or error.",
+	}, []string{"cache", "result"})
+
+	resultCacheRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "daptin",
+		Name:      "result_cache_requests_total",
+		Help:      "List and aggregate result cache lookups by table and result: hit or miss.",
+	}, []string{"table", "result"})
+
+	websocketConnections = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "daptin",
+		Name:      "websocket_connections",
+		Help:      "Open websocket connections.",
+	})
+
+	mailSessions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "daptin",
+		Name:      "mail_sessions_total",
+		Help:      "IMAP logins and SMTP mail transactions by outcome.",
+	}, []string{"protocol", "outcome"})
+
+	schedulerRuns = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "daptin",
+		Name:      "scheduler_runs_total",
+		Help:      "Scheduled task runs by task and outcome.",
+	}, []string{"task", "outcome"})
+	schedulerRunDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "daptin",
+		Name:      "scheduler_run_duration_seconds",
+		Help:      "Duration of the scheduled task runs.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"task"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		httpRequests, httpRequestDuration,
+		dbOperations, dbOperationDuration,
+		interceptorDuration,
+		actionExecutions, actionDuration,
+		cacheRequests,
+		resultCacheRequests,
+		websocketConnections,
+		mailSessions,
+		schedulerRuns, schedulerRunDuration,
+	)
+}
+
+// outcome is the outcome label of an error
+func outcome(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "success"
+}
+
+// ObserveInterceptor records the time an interceptor took since start
+func ObserveInterceptor(interceptor string, operation string, phase string, start time.Time) {
+	interceptorDuration.WithLabelValues(interceptor, operation, phase).Observe(time.Since(start).Seconds())
+}
+
+// CacheLookup records a cache hit, a miss or an error
+func CacheLookup(cache string, result string) {
+	cacheRequests.WithLabelValues(cache, result).Inc()
+}
+
+// ResultCacheLookup records a hit or a miss of the result cache of a table
+func ResultCacheLookup(table string, result string) {
+	resultCacheRequests.WithLabelValues(table, result).Inc()
+}
+
+// SetWebsocketConnections records the number of open websocket connections
+func SetWebsocketConnections(count int) {
+	websocketConnections.Set(float64(count))
+}
+
+// MailSession records an IMAP login or an SMTP mail transaction
+func MailSession(protocol string, err error) {
+	mailSessions.WithLabelValues(protocol, outcome(err)).Inc()
+}
+
+// SchedulerRun records a run of a scheduled task which started at start
+func SchedulerRun(task string, start time.Time, err error) {
+	schedulerRuns.WithLabelValues(task, outcome(err)).Inc()
+	schedulerRunDuration.WithLabelValues(task).Observe(time.Since(start).Seconds())
+}
+
+// EventPoolStats reports the event worker pool counters
+type EventPoolStats func() (queued int, published uint64, dropped uint64, failed uint64)
+
+// RegisterEventPool exposes the queue depth and the counters of the event worker pool
+func RegisterEventPool(stats EventPoolStats) {
+	Registry.MustRegister(
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: "daptin",
+			Name:      "event_queue_depth",
+			Help:      "Events waiting in the event worker pool queue.",
+		}, func() float64 {
+			queued, _, _, _ := stats()
+			return float64(queued)
+		}),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace: "daptin",
+			Name:      "events_published_total",
+			Help:      "Events published by the event worker pool.",
+		}, func() float64 {
+			_, published, _, _ := stats()
+			return float64(published)
+		}),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace: "daptin",
+			Name:      "events_dropped_total",
+			Help:      "Events dropped because the event queue was full.",
+		}, func() float64 {
+			_, _, dropped, _ := stats()
+			return float64(dropped)
+		}),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace: "daptin",
+			Name:      "events_failed_total",
+			Help:      "Events which failed to publish.",
+		}, func() float64 {
+			_, _, _, failed := stats()
+			return float64(failed)
+		}),
+	)
+}
@@ -0,0 +1,103 @@
+package telemetry
+
+import (
+	"context"
+	"os"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	sdkresource "go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/daptin/daptin"
+
+// InitTracing exports spans to the OTLP collector set by OTEL_EXPORTER_OTLP_ENDPOINT or
+// OTEL_EXPORTER_OTLP_TRACES_ENDPOINT, over http. Without either spans are not recorded, the trace
+// context of incoming requests is still passed on. The returned function flushes the spans left.
+func InitTracing(ctx context.Context, version string) func(context.Context) error {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	if os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") == "" && os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") == "" {
+		return func(context.Context) error { return nil }
+	}
+
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		log.Errorf("Failed to create OTLP trace exporter, spans are not exported: %v", err)
+		return func(context.Context) error { return nil }
+	}
+
+	// OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES override the defaults
+	serviceResource, err := sdkresource.Merge(sdkresource.Default(), sdkresource.NewSchemaless(
+		attribute.String("service.name", "daptin"),
+		attribute.String("service.version", version),
+	))
+	if err == nil {
+		serviceResource, err = sdkresource.Merge(serviceResource, sdkresource.Environment())
+	}
+	if err != nil {
+		log.Warnf("Failed to build trace resource: %v", err)
+		serviceResource = sdkresource.Default()
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(serviceResource),
+	)
+	otel.SetTracerProvider(provider)
+	log.Infof("Exporting traces to the OTLP collector")
+	return provider.Shutdown
+}
+
+// StartSpan starts a span as a child of the span in ctx
+func StartSpan(ctx context.Context, name string, attributes ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, name, trace.WithAttributes(attributes...))
+}
+
+// EndSpan ends the span, marking it failed when err is set
+func EndSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// StartOperation starts the span of a call on a table resource. The returned function ends it and
+// records the call in the db operation metrics.
+func StartOperation(ctx context.Context, table string, operation string) (context.Context, func(err error)) {
+	start := time.Now()
+	ctx, span := StartSpan(ctx, operation+" "+table,
+		attribute.String("db.collection.name", table), attribute.String("daptin.operation", operation))
+	return ctx, func(err error) {
+		dbOperations.WithLabelValues(table, operation, outcome(err)).Inc()
+		dbOperationDuration.WithLabelValues(table, operation).Observe(time.Since(start).Seconds())
+		EndSpan(span, err)
+	}
+}
+
+// StartPerformer starts the span of an action performer. The returned function ends it and records
+// the execution in the action metrics.
+func StartPerformer(ctx context.Context, performer string) (context.Context, func(err error)) {
+	start := time.Now()
+	ctx, span := StartSpan(ctx, "perform "+performer, attribute.String("daptin.performer", performer))
+	return ctx, func(err error) {
+		actionExecutions.WithLabelValues(performer, outcome(err)).Inc()
+		actionDuration.WithLabelValues(performer).Observe(time.Since(start).Seconds())
+		EndSpan(span, err)
+	}
+}
+
+// PropagationHeaders are the headers carrying the trace context of ctx to an outgoing request
+func PropagationHeaders(ctx context.Context) map[string]string {
+	carrier := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+	return carrier
+}
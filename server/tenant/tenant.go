@@ -0,0 +1,93 @@
+package tenant
+
+import (
+	"context"
+	"strings"
+
+	"github.com/daptin/daptin/server/auth"
+	daptinid "github.com/daptin/daptin/server/id"
+)
+
+// ContextKey holds the tenant a request is served for, resolved from its hostname
+const ContextKey = "tenant"
+
+// Tenant is a customer served by the deployment. Rows of tenant scoped tables belong to one tenant
+// and are only read and written on the hostnames of that tenant.
+type Tenant struct {
+	Id                    int64
+	ReferenceId           daptinid.DaptinReferenceId
+	Name                  string
+	Hostnames             []string
+	AdminGroupId          int64
+	AdminGroupReferenceId daptinid.DaptinReferenceId
+}
+
+// IsAdmin tells if the user is in the administrator group of the tenant
+func (t *Tenant) IsAdmin(user *auth.SessionUser) bool {
+	if t == nil || user == nil || t.AdminGroupReferenceId == daptinid.NullReferenceId {
+		return false
+	}
+	for _, group := range user.Groups {
+		if group.GroupReferenceId == t.AdminGroupReferenceId {
+			return true
+		}
+	}
+	return false
+}
+
+// FromContext is the tenant of the request, nil when the request is not served for a tenant
+func FromContext(ctx context.Context) *Tenant {
+	if ctx == nil {
+		return nil
+	}
+	t, _ := ctx.Value(ContextKey).(*Tenant)
+	return t
+}
+
+// WithTenant returns a context of a request served for the tenant
+func WithTenant(ctx context.Context, t *Tenant) context.Context {
+	return context.WithValue(ctx, ContextKey, t)
+}
+
+// Resolver finds the tenant a hostname belongs to
+type Resolver interface {
+	TenantForHost(hostName string) (*Tenant, bool)
+}
+
+// HostMap resolves tenants by their hostnames
+type HostMap map[string]*Tenant
+
+// NewHostMap maps the hostnames of the tenants to them, a hostname listed by two tenants goes to the
+// first one
+func NewHostMap(tenants []*Tenant) HostMap {
+	hostMap := make(HostMap)
+	for _, t := range tenants {
+		for _, hostName := range t.Hostnames {
+			hostName = strings.ToLower(strings.TrimSpace(hostName))
+			if hostName == "" {
+				continue
+			}
+			if _, ok := hostMap[hostName]; !ok {
+				hostMap[hostName] = t
+			}
+		}
+	}
+	return hostMap
+}
+
+func (hostMap HostMap) TenantForHost(hostName string) (*Tenant, bool) {
+	t, ok := hostMap[strings.ToLower(hostName)]
+	return t, ok
+}
+
+// ParseHostnames splits the comma separated hostnames of a tenant row
+func ParseHostnames(value string) []string {
+	hostnames := make([]string, 0)
+	for _, hostName := range strings.Split(value, ",") {
+		hostName = strings.ToLower(strings.TrimSpace(hostName))
+		if hostName != "" {
+			hostnames = append(hostnames, hostName)
+		}
+	}
+	return hostnames
+}
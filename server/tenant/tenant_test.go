@@ -0,0 +1,44 @@
+package tenant
+
+import (
+	"testing"
+
+	"github.com/daptin/daptin/server/auth"
+	daptinid "github.com/daptin/daptin/server/id"
+	"github.com/google/uuid"
+)
+
+func TestTenantIsAdmin(t *testing.T) {
+	adminGroup := daptinid.DaptinReferenceId(uuid.New())
+	acme := &Tenant{Id: 1, AdminGroupReferenceId: adminGroup}
+
+	admin := &auth.SessionUser{Groups: auth.GroupPermissionList{{GroupReferenceId: adminGroup}}}
+	member := &auth.SessionUser{Groups: auth.GroupPermissionList{{GroupReferenceId: daptinid.DaptinReferenceId(uuid.New())}}}
+
+	if !acme.IsAdmin(admin) {
+		t.Errorf("a member of the administrator group should administer the tenant")
+	}
+	if acme.IsAdmin(member) || acme.IsAdmin(nil) {
+		t.Errorf("only members of the administrator group should administer the tenant")
+	}
+	var none *Tenant
+	if none.IsAdmin(admin) || (&Tenant{Id: 2}).IsAdmin(&auth.SessionUser{}) {
+		t.Errorf("no one administers a missing tenant or a tenant without administrator group")
+	}
+}
+
+func TestHostMap(t *testing.T) {
+	acme := &Tenant{Id: 1, Hostnames: ParseHostnames(" Acme.example.com, ,www.acme.example.com")}
+	other := &Tenant{Id: 2, Hostnames: []string{"acme.example.com", "other.example.com"}}
+	hosts := NewHostMap([]*Tenant{acme, other})
+
+	if found, ok := hosts.TenantForHost("ACME.example.com"); !ok || found != acme {
+		t.Errorf("a hostname listed by two tenants should go to the first one, got %+v", found)
+	}
+	if found, ok := hosts.TenantForHost("other.example.com"); !ok || found != other {
+		t.Errorf("expected the other tenant, got %+v", found)
+	}
+	if _, ok := hosts.TenantForHost("example.com"); ok {
+		t.Errorf("a hostname of no tenant should not resolve")
+	}
+}
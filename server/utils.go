@@ -239,6 +239,7 @@ func BuildMiddlewareSet(cmsConfig *resource.CmsConfig,
 	objectPermissionChecker := &resource.ObjectAccessPermissionChecker{}
 	dataValidationMiddleware := resource.NewDataValidationMiddleware(cmsConfig, cruds)
 	meteringMiddleware := resource.NewMeteringMiddleware(cruds)
+	rollupColumnMiddleware := resource.NewRollupColumnMiddleware()
 
 	createEventHandler := resource.NewCreateEventHandler(cruds, dtopicMap)
 	updateEventHandler := resource.NewUpdateEventHandler(cruds, dtopicMap)
@@ -279,6 +280,7 @@ func BuildMiddlewareSet(cmsConfig *resource.CmsConfig,
 		createEventHandler,
 		exchangeMiddleware,
 		meteringMiddleware,
+		rollupColumnMiddleware,
 	}
 
 	ms.BeforeDelete = []resource.DatabaseRequestInterceptor{
@@ -287,6 +289,7 @@ func BuildMiddlewareSet(cmsConfig *resource.CmsConfig,
 		meteringMiddleware,
 		deleteEventHandler,
 		exchangeMiddleware,
+		rollupColumnMiddleware,
 	}
 	ms.AfterDelete = []resource.DatabaseRequestInterceptor{
 		tablePermissionChecker,
@@ -294,6 +297,7 @@ func BuildMiddlewareSet(cmsConfig *resource.CmsConfig,
 		deleteEventHandler,
 		exchangeMiddleware,
 		meteringMiddleware,
+		rollupColumnMiddleware,
 	}
 
 	if yhsHandler != nil {
@@ -305,6 +309,7 @@ func BuildMiddlewareSet(cmsConfig *resource.CmsConfig,
 			yhsHandler,
 			updateEventHandler,
 			exchangeMiddleware,
+			rollupColumnMiddleware,
 		}
 	} else {
 		ms.BeforeUpdate = []resource.DatabaseRequestInterceptor{
@@ -314,6 +319,7 @@ func BuildMiddlewareSet(cmsConfig *resource.CmsConfig,
 			dataValidationMiddleware,
 			updateEventHandler,
 			exchangeMiddleware,
+			rollupColumnMiddleware,
 		}
 	}
 
@@ -323,6 +329,7 @@ func BuildMiddlewareSet(cmsConfig *resource.CmsConfig,
 		updateEventHandler,
 		exchangeMiddleware,
 		meteringMiddleware,
+		rollupColumnMiddleware,
 	}
 
 	ms.BeforeFindOne = []resource.DatabaseRequestInterceptor{
@@ -337,7 +344,7 @@ func BuildMiddlewareSet(cmsConfig *resource.CmsConfig,
 		exchangeMiddleware,
 		meteringMiddleware,
 	}
-	return ms
+	return resource.TimedMiddlewareSet(ms)
 }
 
 func CleanUpConfigFiles() {
@@ -410,15 +417,6 @@ func AddStreamsToApi2Go(api *api2go.API, processors []*resource.StreamProcessor,
 func GetStreamProcessors(config *resource.CmsConfig, store *resource.ConfigStore,
 	cruds map[string]*resource.DbResource) []*resource.StreamProcessor {
 
-	allProcessors := make([]*resource.StreamProcessor, 0)
-
-	for _, streamContract := range config.Streams {
-
-		streamProcessor := resource.NewStreamProcessor(streamContract, cruds)
-		allProcessors = append(allProcessors, streamProcessor)
-
-	}
-
-	return allProcessors
+	return resource.NewStreamProcessors(config.Streams, cruds)
 
 }
@@ -0,0 +1,579 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/artpar/rclone/fs"
+	"github.com/daptin/daptin/server/assetcachepojo"
+	"github.com/daptin/daptin/server/auth"
+	daptinid "github.com/daptin/daptin/server/id"
+	"github.com/daptin/daptin/server/permission"
+	"github.com/daptin/daptin/server/resource"
+	"golang.org/x/net/webdav"
+)
+
+const (
+	WebdavPrefix           = "/webdav"
+	WebdavSiteFolder       = "site"
+	WebdavCloudStoreFolder = "cloudstore"
+	WebdavAssetFolder      = "asset"
+
+	// files written to a remote store are uploaded asynchronously, until then they are listed from the
+	// local cache so clients see their own writes
+	webdavPendingUploadWindow = 5 * time.Minute
+)
+
+// WebdavMount is a site, a cloud store or the folder of an asset column exposed as a folder under /webdav/
+type WebdavMount struct {
+	Name                 string
+	Permission           permission.PermissionInstance
+	AdministratorGroupId daptinid.DaptinReferenceId
+	Cache                *assetcachepojo.AssetFolderCache
+	// LocalPath holds the complete tree on disk. It is empty for remote cloud stores, those are listed
+	// through rclone and files are served from the download cache in Cache.LocalSyncPath
+	LocalPath string
+	// WriteThrough pushes every change to the cloud store using the cloud store actions
+	WriteThrough bool
+
+	cloudFs     fs.Fs
+	cloudFsLock sync.Mutex
+}
+
+func (mount *WebdavMount) isRemote() bool {
+	return mount.LocalPath == ""
+}
+
+func (mount *WebdavMount) localRoot() string {
+	if mount.isRemote() {
+		return mount.Cache.LocalSyncPath
+	}
+	return mount.LocalPath
+}
+
+func (mount *WebdavMount) cloudFilesystem(ctx context.Context) (fs.Fs, error) {
+	mount.cloudFsLock.Lock()
+	defer mount.cloudFsLock.Unlock()
+	if mount.cloudFs != nil {
+		return mount.cloudFs, nil
+	}
+	cloudFs, err := mount.Cache.CloudFilesystem(ctx)
+	if err != nil {
+		return nil, err
+	}
+	mount.cloudFs = cloudFs
+	return cloudFs, nil
+}
+
+// WebdavMounts holds the mounts of each top level folder, read by the WebdavMountRegistry
+type WebdavMounts struct {
+	Folders  map[string]map[string]*WebdavMount
+	LoadedAt time.Time
+}
+
+// DaptinWebdavFileSystem is the webdav.FileSystem of one user, every operation is checked against the
+// permission of the site, cloud store or table it touches
+type DaptinWebdavFileSystem struct {
+	mounts      *WebdavMounts
+	cruds       map[string]*resource.DbResource
+	sessionUser *auth.SessionUser
+}
+
+func NewWebdavFileSystem(cruds map[string]*resource.DbResource, mounts *WebdavMounts, sessionUser *auth.SessionUser) *DaptinWebdavFileSystem {
+	return &DaptinWebdavFileSystem{
+		mounts:      mounts,
+		cruds:       cruds,
+		sessionUser: sessionUser,
+	}
+}
+
+// resolve splits a webdav path into the top level folder, the mount and the slash separated path inside it
+func (fsys *DaptinWebdavFileSystem) resolve(name string) (string, *WebdavMount, string, error) {
+	if strings.ContainsRune(name, '\x00') {
+		return "", nil, "", os.ErrInvalid
+	}
+	cleanPath := strings.TrimPrefix(path.Clean("/"+name), "/")
+	if cleanPath == "" {
+		return "", nil, "", nil
+	}
+	pathParts := strings.SplitN(cleanPath, "/", 3)
+	folderMounts, ok := fsys.mounts.Folders[pathParts[0]]
+	if !ok {
+		return "", nil, "", os.ErrNotExist
+	}
+	if len(pathParts) == 1 {
+		return pathParts[0], nil, "", nil
+	}
+	mount, ok := folderMounts[pathParts[1]]
+	if !ok || !fsys.permitted(mount, mount.Permission.CanPeek) {
+		return "", nil, "", os.ErrNotExist
+	}
+	relativePath := ""
+	if len(pathParts) == 3 {
+		relativePath = pathParts[2]
+	}
+	return pathParts[0], mount, relativePath, nil
+}
+
+func (fsys *DaptinWebdavFileSystem) permitted(mount *WebdavMount,
+	check func(daptinid.DaptinReferenceId, auth.GroupPermissionList, daptinid.DaptinReferenceId) bool) bool {
+	return check(fsys.sessionUser.UserReferenceId, fsys.sessionUser.Groups, mount.AdministratorGroupId)
+}
+
+func (fsys *DaptinWebdavFileSystem) localPath(mount *WebdavMount, relativePath string) (string, error) {
+	return containedPath(mount.localRoot(), filepath.FromSlash(relativePath))
+}
+
+func (fsys *DaptinWebdavFileSystem) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	_, mount, relativePath, err := fsys.resolve(name)
+	if err != nil {
+		return err
+	}
+	if mount == nil || relativePath == "" {
+		return os.ErrExist
+	}
+	if !fsys.permitted(mount, mount.Permission.CanCreate) {
+		return os.ErrPermission
+	}
+	fullPath, err := fsys.localPath(mount, relativePath)
+	if err != nil {
+		return err
+	}
+	if mount.isRemote() {
+		err = os.MkdirAll(fullPath, 0750)
+	} else {
+		err = os.Mkdir(fullPath, 0750)
+	}
+	if err != nil {
+		return err
+	}
+	if mount.WriteThrough {
+		createFolderInCloudStore(fsys.cruds, mount.Cache, relativePath)
+	}
+	return nil
+}
+
+func (fsys *DaptinWebdavFileSystem) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	folder, mount, relativePath, err := fsys.resolve(name)
+	if err != nil {
+		return nil, err
+	}
+	isWrite := flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC) != 0
+	if mount == nil || relativePath == "" {
+		if isWrite {
+			return nil, os.ErrPermission
+		}
+		return fsys.openVirtualDirectory(folder, mount)
+	}
+	if isWrite {
+		return fsys.openForWrite(ctx, mount, relativePath, flag)
+	}
+
+	if !fsys.permitted(mount, mount.Permission.CanRead) {
+		return nil, os.ErrPermission
+	}
+	if !mount.isRemote() {
+		fullPath, err := fsys.localPath(mount, relativePath)
+		if err != nil {
+			return nil, err
+		}
+		return os.Open(fullPath)
+	}
+
+	fileInfo, err := fsys.statRemote(ctx, mount, relativePath)
+	if err != nil {
+		return nil, err
+	}
+	if fileInfo.IsDir() {
+		entries, err := fsys.listRemote(ctx, mount, relativePath)
+		if err != nil {
+			return nil, err
+		}
+		return &webdavDirectory{info: fileInfo, entries: entries}, nil
+	}
+	return mount.Cache.GetFileByNameContext(ctx, relativePath)
+}
+
+func (fsys *DaptinWebdavFileSystem) openForWrite(ctx context.Context, mount *WebdavMount, relativePath string, flag int) (webdav.File, error) {
+	fullPath, err := fsys.localPath(mount, relativePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var statErr error
+	if mount.isRemote() {
+		_, statErr = fsys.statRemote(ctx, mount, relativePath)
+	} else {
+		_, statErr = os.Stat(fullPath)
+	}
+	if errors.Is(statErr, os.ErrNotExist) {
+		if !fsys.permitted(mount, mount.Permission.CanCreate) {
+			return nil, os.ErrPermission
+		}
+	} else if statErr != nil {
+		return nil, statErr
+	} else if !fsys.permitted(mount, mount.Permission.CanUpdate) {
+		return nil, os.ErrPermission
+	}
+
+	if mount.isRemote() {
+		if err = os.MkdirAll(filepath.Dir(fullPath), 0750); err != nil {
+			return nil, err
+		}
+	}
+	file, err := os.OpenFile(fullPath, flag, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if !mount.WriteThrough {
+		return file, nil
+	}
+	return &webdavWriteThroughFile{File: file, fsys: fsys, mount: mount, relativePath: relativePath}, nil
+}
+
+func (fsys *DaptinWebdavFileSystem) RemoveAll(ctx context.Context, name string) error {
+	_, mount, relativePath, err := fsys.resolve(name)
+	if err != nil {
+		return err
+	}
+	if mount == nil || relativePath == "" {
+		return os.ErrPermission
+	}
+	if !fsys.permitted(mount, mount.Permission.CanDelete) {
+		return os.ErrPermission
+	}
+	fullPath, err := fsys.localPath(mount, relativePath)
+	if err != nil {
+		return err
+	}
+
+	var fileInfo os.FileInfo
+	if mount.isRemote() {
+		fileInfo, err = fsys.statRemote(ctx, mount, relativePath)
+	} else {
+		fileInfo, err = os.Stat(fullPath)
+	}
+	if err != nil {
+		return err
+	}
+	err = os.RemoveAll(fullPath)
+	if err != nil {
+		return err
+	}
+	if mount.WriteThrough {
+		deleteFromCloudStore(fsys.cruds, mount.Cache, relativePath, fileInfo.IsDir())
+	}
+	return nil
+}
+
+func (fsys *DaptinWebdavFileSystem) Rename(ctx context.Context, oldName, newName string) error {
+	_, fromMount, fromPath, err := fsys.resolve(oldName)
+	if err != nil {
+		return err
+	}
+	_, toMount, toPath, err := fsys.resolve(newName)
+	if err != nil {
+		return err
+	}
+	if fromMount == nil || toMount != fromMount || fromPath == "" || toPath == "" {
+		return os.ErrPermission
+	}
+	if !fsys.permitted(fromMount, fromMount.Permission.CanUpdate) {
+		return os.ErrPermission
+	}
+	fromFullPath, err := fsys.localPath(fromMount, fromPath)
+	if err != nil {
+		return err
+	}
+	toFullPath, err := fsys.localPath(fromMount, toPath)
+	if err != nil {
+		return err
+	}
+
+	if fromMount.isRemote() {
+		// the cached copy is optional, the store itself is moved below
+		if _, statErr := os.Stat(fromFullPath); statErr == nil {
+			_ = os.MkdirAll(filepath.Dir(toFullPath), 0750)
+			resource.InfoErr(os.Rename(fromFullPath, toFullPath), "Failed to move cached copy of [%v]", fromPath)
+		}
+	} else if err = os.Rename(fromFullPath, toFullPath); err != nil {
+		return err
+	}
+	if fromMount.WriteThrough {
+		moveInCloudStore(fsys.cruds, fromMount.Cache, fromPath, toPath)
+	}
+	return nil
+}
+
+func (fsys *DaptinWebdavFileSystem) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	folder, mount, relativePath, err := fsys.resolve(name)
+	if err != nil {
+		return nil, err
+	}
+	if mount == nil {
+		if folder == "" {
+			return fsys.directoryInfo("/"), nil
+		}
+		return fsys.directoryInfo(folder), nil
+	}
+	if relativePath == "" {
+		return fsys.directoryInfo(mount.Name), nil
+	}
+	if !fsys.permitted(mount, mount.Permission.CanRead) {
+		return nil, os.ErrPermission
+	}
+	if mount.isRemote() {
+		return fsys.statRemote(ctx, mount, relativePath)
+	}
+	fullPath, err := fsys.localPath(mount, relativePath)
+	if err != nil {
+		return nil, err
+	}
+	return os.Stat(fullPath)
+}
+
+func (fsys *DaptinWebdavFileSystem) directoryInfo(name string) webdavFileInfo {
+	return webdavFileInfo{
+		name:    name,
+		mode:    os.FileMode(0755) | os.ModeDir,
+		modTime: fsys.mounts.LoadedAt,
+	}
+}
+
+func (fsys *DaptinWebdavFileSystem) openVirtualDirectory(folder string, mount *WebdavMount) (webdav.File, error) {
+	entries := make([]os.FileInfo, 0)
+	switch {
+	case folder == "":
+		for folderName := range fsys.mounts.Folders {
+			entries = append(entries, fsys.directoryInfo(folderName))
+		}
+		return &webdavDirectory{info: fsys.directoryInfo("/"), entries: entries}, nil
+	case mount == nil:
+		for _, folderMount := range fsys.mounts.Folders[folder] {
+			if fsys.permitted(folderMount, folderMount.Permission.CanPeek) {
+				entries = append(entries, fsys.directoryInfo(folderMount.Name))
+			}
+		}
+		return &webdavDirectory{info: fsys.directoryInfo(folder), entries: entries}, nil
+	}
+
+	if !fsys.permitted(mount, mount.Permission.CanRead) {
+		return nil, os.ErrPermission
+	}
+	if mount.isRemote() {
+		entries, err := fsys.listRemote(context.Background(), mount, "")
+		if err != nil {
+			return nil, err
+		}
+		return &webdavDirectory{info: fsys.directoryInfo(mount.Name), entries: entries}, nil
+	}
+	dirEntries, err := os.ReadDir(mount.LocalPath)
+	if err != nil {
+		return nil, err
+	}
+	for _, dirEntry := range dirEntries {
+		entryInfo, err := dirEntry.Info()
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entryInfo)
+	}
+	return &webdavDirectory{info: fsys.directoryInfo(mount.Name), entries: entries}, nil
+}
+
+// statRemote looks the path up in the cloud store, falling back to files written recently which may still
+// be on their way to the store
+func (fsys *DaptinWebdavFileSystem) statRemote(ctx context.Context, mount *WebdavMount, relativePath string) (os.FileInfo, error) {
+	cloudFs, err := mount.cloudFilesystem(ctx)
+	if err != nil {
+		return nil, err
+	}
+	object, err := cloudFs.NewObject(ctx, relativePath)
+	if err == nil {
+		return webdavFileInfo{
+			name:    path.Base(relativePath),
+			size:    object.Size(),
+			mode:    os.FileMode(0644),
+			modTime: object.ModTime(ctx),
+		}, nil
+	}
+	// object stores have no directories, a prefix exists as long as something is stored below it
+	entries, err := cloudFs.List(ctx, relativePath)
+	if err == nil && len(entries) > 0 {
+		return webdavFileInfo{
+			name:    path.Base(relativePath),
+			mode:    os.FileMode(0755) | os.ModeDir,
+			modTime: fsys.mounts.LoadedAt,
+		}, nil
+	}
+
+	fullPath, err := fsys.localPath(mount, relativePath)
+	if err != nil {
+		return nil, err
+	}
+	localInfo, err := os.Stat(fullPath)
+	if err == nil && time.Since(localInfo.ModTime()) < webdavPendingUploadWindow {
+		return localInfo, nil
+	}
+	return nil, os.ErrNotExist
+}
+
+func (fsys *DaptinWebdavFileSystem) listRemote(ctx context.Context, mount *WebdavMount, relativePath string) ([]os.FileInfo, error) {
+	cloudFs, err := mount.cloudFilesystem(ctx)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := cloudFs.List(ctx, relativePath)
+	if err != nil && !errors.Is(err, fs.ErrorDirNotFound) {
+		return nil, err
+	}
+
+	files := make([]os.FileInfo, 0, len(entries))
+	listed := make(map[string]bool)
+	for _, entry := range entries {
+		entryName := path.Base(entry.Remote())
+		listed[entryName] = true
+		switch item := entry.(type) {
+		case fs.Object:
+			files = append(files, webdavFileInfo{
+				name:    entryName,
+				size:    item.Size(),
+				mode:    os.FileMode(0644),
+				modTime: item.ModTime(ctx),
+			})
+		case fs.Directory:
+			files = append(files, webdavFileInfo{
+				name:    entryName,
+				mode:    os.FileMode(0755) | os.ModeDir,
+				modTime: item.ModTime(ctx),
+			})
+		}
+	}
+
+	fullPath, err := fsys.localPath(mount, relativePath)
+	if err != nil {
+		return nil, err
+	}
+	localEntries, err := os.ReadDir(fullPath)
+	if err != nil {
+		return files, nil
+	}
+	for _, localEntry := range localEntries {
+		if listed[localEntry.Name()] {
+			continue
+		}
+		localInfo, err := localEntry.Info()
+		if err != nil || time.Since(localInfo.ModTime()) >= webdavPendingUploadWindow {
+			continue
+		}
+		files = append(files, localInfo)
+	}
+	return files, nil
+}
+
+// webdavWriteThroughFile uploads the file to the cloud store once the client closes it
+type webdavWriteThroughFile struct {
+	*os.File
+	fsys         *DaptinWebdavFileSystem
+	mount        *WebdavMount
+	relativePath string
+}
+
+func (f *webdavWriteThroughFile) Close() error {
+	if err := f.File.Close(); err != nil {
+		return err
+	}
+	uploadToCloudStore(f.fsys.cruds, f.mount.Cache, f.relativePath, f.File.Name())
+	return nil
+}
+
+// webdavDirectory is a directory listing which is not backed by a local folder
+type webdavDirectory struct {
+	info    os.FileInfo
+	entries []os.FileInfo
+	offset  int
+}
+
+func (d *webdavDirectory) Close() error {
+	return nil
+}
+
+func (d *webdavDirectory) Read(buffer []byte) (int, error) {
+	return 0, errors.New("is a directory")
+}
+
+func (d *webdavDirectory) Seek(offset int64, whence int) (int64, error) {
+	return 0, nil
+}
+
+func (d *webdavDirectory) Write(buffer []byte) (int, error) {
+	return 0, os.ErrPermission
+}
+
+func (d *webdavDirectory) Stat() (os.FileInfo, error) {
+	return d.info, nil
+}
+
+func (d *webdavDirectory) Readdir(count int) ([]os.FileInfo, error) {
+	remaining := d.entries[d.offset:]
+	if count <= 0 {
+		d.offset = len(d.entries)
+		return remaining, nil
+	}
+	if len(remaining) == 0 {
+		return nil, io.EOF
+	}
+	if count > len(remaining) {
+		count = len(remaining)
+	}
+	d.offset += count
+	return remaining[:count], nil
+}
+
+type webdavFileInfo struct {
+	name    string
+	size    int64
+	mode    os.FileMode
+	modTime time.Time
+}
+
+func (f webdavFileInfo) Name() string {
+	return f.name
+}
+
+func (f webdavFileInfo) Size() int64 {
+	return f.size
+}
+
+func (f webdavFileInfo) Mode() os.FileMode {
+	return f.mode
+}
+
+func (f webdavFileInfo) ModTime() time.Time {
+	return f.modTime
+}
+
+func (f webdavFileInfo) IsDir() bool {
+	return f.mode.IsDir()
+}
+
+func (f webdavFileInfo) Sys() interface{} {
+	return nil
+}
+
+// ContentType is guessed from the extension so listing a remote folder does not download every file
+func (f webdavFileInfo) ContentType(ctx context.Context) (string, error) {
+	contentType := mime.TypeByExtension(path.Ext(f.name))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	return contentType, nil
+}
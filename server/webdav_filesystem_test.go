@@ -0,0 +1,229 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/daptin/daptin/server/assetcachepojo"
+	"github.com/daptin/daptin/server/auth"
+	daptinid "github.com/daptin/daptin/server/id"
+	"github.com/daptin/daptin/server/permission"
+	"github.com/daptin/daptin/server/rootpojo"
+	"golang.org/x/net/webdav"
+)
+
+func webdavTestServer(t *testing.T, mounts *WebdavMounts, sessionUser *auth.SessionUser) *httptest.Server {
+	handler := &webdav.Handler{
+		Prefix:     WebdavPrefix,
+		FileSystem: NewWebdavFileSystem(nil, mounts, sessionUser),
+		LockSystem: webdav.NewMemLS(),
+	}
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func webdavTestRequest(t *testing.T, server *httptest.Server, method, path string, body string, headers map[string]string) (int, string) {
+	request, err := http.NewRequest(method, server.URL+WebdavPrefix+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, value := range headers {
+		request.Header.Set(key, value)
+	}
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	responseBody, _ := io.ReadAll(response.Body)
+	return response.StatusCode, string(responseBody)
+}
+
+func webdavTestMounts(adminGroupId daptinid.DaptinReferenceId, storeRoot string, storePermission permission.PermissionInstance) *WebdavMounts {
+	return &WebdavMounts{
+		Folders: map[string]map[string]*WebdavMount{
+			WebdavSiteFolder: {},
+			WebdavCloudStoreFolder: {
+				"documents": {
+					Name:                 "documents",
+					Permission:           storePermission,
+					AdministratorGroupId: adminGroupId,
+					LocalPath:            storeRoot,
+				},
+			},
+		},
+		LoadedAt: time.Now(),
+	}
+}
+
+func TestWebdavFileOperationsUseStorePermissions(t *testing.T) {
+	ownerReferenceId := ftpTestReferenceId()
+	readerReferenceId := ftpTestReferenceId()
+	groupReferenceId := ftpTestReferenceId()
+	adminGroupId := ftpTestReferenceId()
+	storeRoot := t.TempDir()
+
+	mounts := webdavTestMounts(adminGroupId, storeRoot, permission.PermissionInstance{
+		UserId:     ownerReferenceId,
+		Permission: auth.UserCRUD,
+		UserGroupId: auth.GroupPermissionList{{
+			GroupReferenceId: groupReferenceId,
+			Permission:       auth.GroupPeek | auth.GroupRead,
+		}},
+	})
+	owner := webdavTestServer(t, mounts, &auth.SessionUser{UserReferenceId: ownerReferenceId})
+	reader := webdavTestServer(t, mounts, &auth.SessionUser{
+		UserReferenceId: readerReferenceId,
+		Groups:          auth.GroupPermissionList{{GroupReferenceId: groupReferenceId}},
+	})
+	stranger := webdavTestServer(t, mounts, &auth.SessionUser{UserReferenceId: ftpTestReferenceId()})
+
+	if status, _ := webdavTestRequest(t, owner, "MKCOL", "/cloudstore/documents/reports", "", nil); status != http.StatusCreated {
+		t.Fatalf("owner could not create a folder: %d", status)
+	}
+	if status, _ := webdavTestRequest(t, owner, "PUT", "/cloudstore/documents/reports/q1.txt", "first quarter", nil); status != http.StatusCreated {
+		t.Fatalf("owner could not upload a file: %d", status)
+	}
+	contents, err := os.ReadFile(filepath.Join(storeRoot, "reports", "q1.txt"))
+	if err != nil || string(contents) != "first quarter" {
+		t.Fatalf("upload did not reach the store: %q %v", contents, err)
+	}
+
+	status, body := webdavTestRequest(t, reader, "GET", "/cloudstore/documents/reports/q1.txt", "", nil)
+	if status != http.StatusOK || body != "first quarter" {
+		t.Fatalf("group reader could not download: %d %q", status, body)
+	}
+	if status, _ := webdavTestRequest(t, reader, "PUT", "/cloudstore/documents/reports/q2.txt", "second quarter", nil); status < 400 {
+		t.Fatalf("group reader without create permission uploaded a file: %d", status)
+	}
+	if status, _ := webdavTestRequest(t, reader, "DELETE", "/cloudstore/documents/reports/q1.txt", "", nil); status < 400 {
+		t.Fatalf("group reader without delete permission removed a file: %d", status)
+	}
+
+	status, body = webdavTestRequest(t, stranger, "PROPFIND", "/cloudstore/", "", map[string]string{"Depth": "1"})
+	if status != http.StatusMultiStatus || strings.Contains(body, "documents") {
+		t.Fatalf("store listed for a user without peek permission: %d %s", status, body)
+	}
+	status, body = webdavTestRequest(t, reader, "PROPFIND", "/cloudstore/", "", map[string]string{"Depth": "1"})
+	if status != http.StatusMultiStatus || !strings.Contains(body, "/webdav/cloudstore/documents/") {
+		t.Fatalf("store not listed for a permitted user: %d %s", status, body)
+	}
+
+	status, _ = webdavTestRequest(t, owner, "MOVE", "/cloudstore/documents/reports/q1.txt", "", map[string]string{
+		"Destination": owner.URL + WebdavPrefix + "/cloudstore/documents/reports/renamed.txt",
+	})
+	if status != http.StatusCreated {
+		t.Fatalf("owner could not move a file: %d", status)
+	}
+	if _, err := os.Stat(filepath.Join(storeRoot, "reports", "renamed.txt")); err != nil {
+		t.Fatalf("moved file is missing: %v", err)
+	}
+	if status, _ := webdavTestRequest(t, owner, "DELETE", "/cloudstore/documents/reports", "", nil); status != http.StatusNoContent {
+		t.Fatalf("owner could not delete a folder: %d", status)
+	}
+}
+
+func TestWebdavPathsCannotEscapeMountRoot(t *testing.T) {
+	ownerReferenceId := ftpTestReferenceId()
+	adminGroupId := ftpTestReferenceId()
+	parent := t.TempDir()
+	storeRoot := filepath.Join(parent, "store")
+	if err := os.Mkdir(storeRoot, 0750); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(parent, "secret.txt"), []byte("secret"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(parent, filepath.Join(storeRoot, "escape")); err != nil {
+		t.Skipf("symlinks not supported: %v", err)
+	}
+
+	mounts := webdavTestMounts(adminGroupId, storeRoot, permission.PermissionInstance{
+		UserId:     ownerReferenceId,
+		Permission: auth.UserCRUD,
+	})
+	fileSystem := NewWebdavFileSystem(nil, mounts, &auth.SessionUser{UserReferenceId: ownerReferenceId})
+
+	if _, err := fileSystem.OpenFile(context.Background(), "/cloudstore/documents/escape/secret.txt", os.O_RDONLY, 0); err == nil {
+		t.Fatal("read through a symlink outside of the store root")
+	}
+	if _, err := fileSystem.OpenFile(context.Background(), "/cloudstore/documents/../../secret.txt", os.O_RDONLY, 0); err == nil {
+		t.Fatal("read outside of the mounts")
+	}
+	if err := fileSystem.RemoveAll(context.Background(), "/cloudstore/documents"); err == nil {
+		t.Fatal("removed the root of a mount")
+	}
+	if err := fileSystem.Rename(context.Background(), "/cloudstore/documents/escape", "/site/escape"); err == nil {
+		t.Fatal("moved a path out of its mount")
+	}
+}
+
+func TestWebdavMountRegistryReloadsChangedMounts(t *testing.T) {
+	adminGroupId := ftpTestReferenceId()
+	loads := 0
+	registry := NewWebdavMountRegistry(webdavTestMounts(adminGroupId, t.TempDir(), permission.PermissionInstance{}),
+		func() (*WebdavMounts, error) {
+			loads++
+			mounts := webdavTestMounts(adminGroupId, t.TempDir(), permission.PermissionInstance{})
+			mounts.Folders[WebdavCloudStoreFolder]["reports"] = &WebdavMount{Name: "reports"}
+			return mounts, nil
+		})
+
+	if _, ok := registry.Mounts().Folders[WebdavCloudStoreFolder]["reports"]; ok || loads != 0 {
+		t.Fatalf("mounts read again before they changed, %d loads", loads)
+	}
+
+	registry.checkedAt = time.Now().Add(-webdavCheckInterval)
+	registry.mounts.LoadedAt = time.Now().Add(-webdavReloadInterval)
+	if _, ok := registry.Mounts().Folders[WebdavCloudStoreFolder]["reports"]; !ok || loads != 1 {
+		t.Fatalf("expected the new cloud store after the reload interval, %d loads", loads)
+	}
+	registry.Mounts()
+	if loads != 1 {
+		t.Fatalf("mounts read again within the check interval, %d loads", loads)
+	}
+}
+
+func TestAssetColumnMount(t *testing.T) {
+	adminGroupId := ftpTestReferenceId()
+	tablePermission := permission.PermissionInstance{Permission: auth.GuestPeek | auth.GuestRead}
+
+	local := assetColumnMount("document", "file", &assetcachepojo.AssetFolderCache{
+		Keyname:    "documents",
+		CloudStore: rootpojo.CloudStore{RootPath: "/data/store", StoreProvider: "local"},
+	}, tablePermission, adminGroupId)
+	if local == nil || local.Name != "document.file" || local.LocalPath != filepath.Join("/data/store", "documents") || local.WriteThrough {
+		t.Fatalf("expected a local column to be changed in place, got %+v", local)
+	}
+	if local.Permission.Permission != tablePermission.Permission || local.AdministratorGroupId != adminGroupId {
+		t.Fatalf("expected the permission of the table, got %+v", local)
+	}
+
+	cached := assetColumnMount("document", "file", &assetcachepojo.AssetFolderCache{
+		LocalSyncPath: "/tmp/document_file",
+		CloudStore:    rootpojo.CloudStore{RootPath: "s3:bucket", StoreProvider: "s3", StoreType: "cached"},
+	}, tablePermission, adminGroupId)
+	if cached == nil || cached.LocalPath != "/tmp/document_file" || !cached.WriteThrough {
+		t.Fatalf("expected a cached column to be served from its synced folder, got %+v", cached)
+	}
+
+	remote := assetColumnMount("document", "file", &assetcachepojo.AssetFolderCache{
+		LocalSyncPath: "/tmp/document_file",
+		CloudStore:    rootpojo.CloudStore{RootPath: "s3:bucket", StoreProvider: "s3"},
+	}, tablePermission, adminGroupId)
+	if remote == nil || !remote.isRemote() || !remote.WriteThrough {
+		t.Fatalf("expected a remote column to be served through rclone, got %+v", remote)
+	}
+
+	if assetColumnMount("document", "file", &assetcachepojo.AssetFolderCache{}, tablePermission, adminGroupId) != nil {
+		t.Fatalf("expected a column without a cloud store to be left out")
+	}
+}
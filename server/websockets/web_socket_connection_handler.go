@@ -495,7 +495,7 @@ func (wsch *WebSocketConnectionHandlerImpl) MessageFromClient(message WebSocketP
 	}
 }
 
-// systemTopicListener handles messages for system topics with per-row CanRead checks
+// systemTopicListener handles messages for system topics with per-row tenant and CanRead checks
 func (wsch *WebSocketConnectionHandlerImpl) systemTopicListener(
 	pubsub *redis.PubSub, eventType string, filtersMap map[string]interface{}, client *Client, ready chan struct{},
 ) {
@@ -524,6 +524,11 @@ func (wsch *WebSocketConnectionHandlerImpl) systemTopicListener(
 				_, tableExists = wsch.cruds[typeStr]
 			}
 		}
+		// rows of a tenant scoped table only go to the subscribers of their tenant, the topic of
+		// a row event is its table, also for deletes which carry no type
+		if table, ok := wsch.cruds[eventMessage.Topic]; ok && !table.RowInTenant(eventDataMap, client.tenant) {
+			continue
+		}
 
 		perm := permission.PermissionInstance{Permission: auth.ALLOW_ALL_PERMISSIONS}
 		if tableExists {
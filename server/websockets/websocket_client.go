@@ -10,6 +10,7 @@ import (
 
 	"github.com/daptin/daptin/server/auth"
 	"github.com/daptin/daptin/server/resource"
+	"github.com/daptin/daptin/server/tenant"
 	log "github.com/sirupsen/logrus"
 	"golang.org/x/net/websocket"
 )
@@ -27,6 +28,7 @@ type Client struct {
 	ch                         chan resource.WsOutMessage
 	doneCh                     chan bool
 	user                       *auth.SessionUser
+	tenant                     *tenant.Tenant
 	webSocketConnectionHandler WebSocketConnectionHandler
 }
 
@@ -67,6 +69,7 @@ func NewClient(ws *websocket.Conn, server *Server) (*Client, error) {
 		ch:                         ch,
 		doneCh:                     doneCh,
 		user:                       user,
+		tenant:                     tenant.FromContext(ws.Request().Context()),
 		webSocketConnectionHandler: webSocketConnectionHandler,
 	}
 
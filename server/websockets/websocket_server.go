@@ -6,6 +6,7 @@ import (
 
 	"github.com/buraksezer/olric"
 	"github.com/daptin/daptin/server/resource"
+	"github.com/daptin/daptin/server/telemetry"
 	"github.com/gin-gonic/gin"
 	log "github.com/sirupsen/logrus"
 	"golang.org/x/net/websocket"
@@ -121,12 +122,14 @@ func (s *Server) Listen(router *gin.Engine) {
 		case c := <-s.addCh:
 			s.clients[c.id] = c
 			log.Infof("Added new client, %d clients connected", len(s.clients))
+			telemetry.SetWebsocketConnections(len(s.clients))
 
 			// del a client
 		case c := <-s.delCh:
 			log.Infof("[126] delete client")
 			c.Close()
 			delete(s.clients, c.id)
+			telemetry.SetWebsocketConnections(len(s.clients))
 
 		case err := <-s.errCh:
 			log.Infof("[136] error: %s", err.Error())
@@ -0,0 +1,83 @@
+package server
+
+import (
+	"context"
+
+	"github.com/artpar/ydb"
+	"github.com/go-redis/redis/v8"
+	"github.com/sirupsen/logrus"
+)
+
+// YjsRelayTopic carries yjs updates between the nodes of a cluster
+const YjsRelayTopic = "daptin.yjs.relay"
+
+type yjsRelayMessage struct {
+	Room             string `json:"room"`
+	Data             []byte `json:"data"`
+	SourceInstanceID string `json:"source_instance_id"`
+}
+
+type pubSubPublisher interface {
+	Publish(ctx context.Context, channel string, message interface{}) (int64, error)
+}
+
+// YjsClusterBroadcaster fans updates out to the sessions on this node and relays them over olric
+// pubsub to the sessions connected to the same room on other nodes
+type YjsClusterBroadcaster struct {
+	local      ydb.Broadcaster
+	publisher  pubSubPublisher
+	instanceID string
+}
+
+func NewYjsClusterBroadcaster(local ydb.Broadcaster, publisher pubSubPublisher, instanceID string) *YjsClusterBroadcaster {
+	return &YjsClusterBroadcaster{
+		local:      local,
+		publisher:  publisher,
+		instanceID: instanceID,
+	}
+}
+
+func (b *YjsClusterBroadcaster) Publish(room ydb.YjsRoomName, senderSessionID uint64, data []byte) {
+	b.local.Publish(room, senderSessionID, data)
+
+	payload, err := json.Marshal(yjsRelayMessage{
+		Room:             string(room),
+		Data:             data,
+		SourceInstanceID: b.instanceID,
+	})
+	if CheckErr(err, "Failed to encode yjs relay message for room [%v]", room) {
+		return
+	}
+	_, err = b.publisher.Publish(context.Background(), YjsRelayTopic, string(payload))
+	CheckErr(err, "Failed to relay yjs update for room [%v]", room)
+}
+
+func (b *YjsClusterBroadcaster) Subscribe(room ydb.YjsRoomName, sessionID uint64) (<-chan []byte, error) {
+	return b.local.Subscribe(room, sessionID)
+}
+
+func (b *YjsClusterBroadcaster) Unsubscribe(room ydb.YjsRoomName, sessionID uint64) {
+	b.local.Unsubscribe(room, sessionID)
+}
+
+// handleRelayMessage delivers an update published on another node to the local sessions of the room
+func (b *YjsClusterBroadcaster) handleRelayMessage(payload string) {
+	var message yjsRelayMessage
+	if err := json.Unmarshal([]byte(payload), &message); err != nil {
+		logrus.Warnf("Ignoring invalid yjs relay message: %v", err)
+		return
+	}
+	if message.SourceInstanceID == b.instanceID || message.Room == "" {
+		return
+	}
+	// session ids are random per node, 0 makes sure no local session is skipped as the sender
+	b.local.Publish(ydb.YjsRoomName(message.Room), 0, message.Data)
+}
+
+// Listen relays updates from other nodes until the subscription is closed
+func (b *YjsClusterBroadcaster) Listen(subscription *redis.PubSub) {
+	channel := subscription.Channel()
+	for msg := range channel {
+		b.handleRelayMessage(msg.Payload)
+	}
+}
@@ -0,0 +1,569 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/artpar/ydb"
+	"github.com/daptin/daptin/server/auth"
+	"github.com/daptin/daptin/server/database"
+	daptinid "github.com/daptin/daptin/server/id"
+	"github.com/daptin/daptin/server/resource"
+	"github.com/daptin/daptin/server/statementbuilder"
+	"github.com/doug-martin/goqu/v9"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	yjsDocumentTable         = "yjs_document"
+	yjsDocumentUpdateTable   = "yjs_document_update"
+	yjsDocumentSnapshotTable = "yjs_document_snapshot"
+
+	YjsSnapshotReasonCheckpoint    = "checkpoint"
+	YjsSnapshotReasonBeforeRestore = "before_restore"
+)
+
+// rows written by the store are owned by nobody, only administrators can reach them through the api
+const yjsRowPermission = auth.UserCRUD | auth.UserExecute
+
+// YjsDatabaseStore is a ydb.Store which keeps rooms in the database instead of on the local disk, so every
+// node of a cluster serves the same copy of a document. A room is the document_state on yjs_document
+// followed by the updates appended to yjs_document_update since the last checkpoint.
+//
+// Offsets are positions in the room log as it was appended. A checkpoint compacts the document state,
+// the log offset it ended at is kept on yjs_document as log_offset so the offsets handed out before the
+// checkpoint still point at the same updates. Size is what the room takes in the database.
+type YjsDatabaseStore struct {
+	connection     database.DatabaseConnection
+	initialContent func(roomName string) []byte
+	roomLocks      sync.Map
+}
+
+// yjsDocumentState is the checkpointed part of a room
+type yjsDocumentState struct {
+	State     []byte
+	LogOffset int64
+	Version   int64
+}
+
+// stateEnd is the log offset the pending updates start at. Rows which were never compacted keep a
+// log_offset of 0, their state is the log as it was appended.
+func (d yjsDocumentState) stateEnd() int64 {
+	if d.LogOffset > int64(len(d.State)) {
+		return d.LogOffset
+	}
+	return int64(len(d.State))
+}
+
+type yjsDocumentUpdate struct {
+	Id   int64
+	Data []byte
+}
+
+func NewYjsDatabaseStore(connection database.DatabaseConnection, initialContent func(roomName string) []byte) *YjsDatabaseStore {
+	return &YjsDatabaseStore{
+		connection:     connection,
+		initialContent: initialContent,
+	}
+}
+
+func (s *YjsDatabaseStore) roomLock(room ydb.YjsRoomName) *sync.Mutex {
+	lock, _ := s.roomLocks.LoadOrStore(room, &sync.Mutex{})
+	return lock.(*sync.Mutex)
+}
+
+// ensureRoom creates the yjs_document row of a room the first time it is used, filled from the
+// initial content provider. The provider is called outside of a transaction since it reads the row
+// the room is bound to.
+func (s *YjsDatabaseStore) ensureRoom(room ydb.YjsRoomName) error {
+	_, err := s.documentState(room, s.connection)
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	content := []byte{}
+	if s.initialContent != nil {
+		content = s.initialContent(string(room))
+	}
+
+	transaction, err := s.connection.Beginx()
+	if err != nil {
+		return err
+	}
+	defer transaction.Rollback()
+
+	err = s.insertDocument(room, content, transaction)
+	if err != nil {
+		// another node created the room first
+		_ = transaction.Rollback()
+		if _, existsErr := s.documentState(room, s.connection); existsErr == nil {
+			return nil
+		}
+		return err
+	}
+	return transaction.Commit()
+}
+
+func (s *YjsDatabaseStore) insertDocument(room ydb.YjsRoomName, content []byte, transaction *sqlx.Tx) error {
+	referenceId := uuid.New()
+	query, args, err := statementbuilder.Squirrel.Insert(yjsDocumentTable).Prepared(true).
+		Cols("reference_id", "room_name", "document_state", "document_size", "version", "permission").
+		Vals([]interface{}{referenceId[:], string(room), base64.StdEncoding.EncodeToString(content), len(content), 1, yjsRowPermission}).
+		ToSQL()
+	if err != nil {
+		return err
+	}
+	_, err = transaction.Exec(query, args...)
+	return err
+}
+
+// documentState returns the checkpointed state of a room along with its version, which changes every
+// time the state is rewritten
+func (s *YjsDatabaseStore) documentState(room ydb.YjsRoomName, queryer sqlx.Queryer) (yjsDocumentState, error) {
+	query, args, err := statementbuilder.Squirrel.Select("document_state", "log_offset", "version").
+		Prepared(true).From(yjsDocumentTable).
+		Where(goqu.Ex{"room_name": string(room)}).ToSQL()
+	if err != nil {
+		return yjsDocumentState{}, err
+	}
+
+	var encodedState sql.NullString
+	var logOffset, version sql.NullInt64
+	err = queryer.QueryRowx(query, args...).Scan(&encodedState, &logOffset, &version)
+	if err != nil {
+		return yjsDocumentState{}, err
+	}
+	state, err := base64.StdEncoding.DecodeString(encodedState.String)
+	return yjsDocumentState{State: state, LogOffset: logOffset.Int64, Version: version.Int64}, err
+}
+
+func (s *YjsDatabaseStore) pendingUpdates(room ydb.YjsRoomName, queryer sqlx.Queryer) ([]yjsDocumentUpdate, error) {
+	query, args, err := statementbuilder.Squirrel.Select("id", "update_data").
+		Prepared(true).From(yjsDocumentUpdateTable).
+		Where(goqu.Ex{"room_name": string(room)}).Order(goqu.C("id").Asc()).ToSQL()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := queryer.Queryx(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	updates := make([]yjsDocumentUpdate, 0)
+	for rows.Next() {
+		var id int64
+		var encodedUpdate string
+		err = rows.Scan(&id, &encodedUpdate)
+		if err != nil {
+			return nil, err
+		}
+		data, err := base64.StdEncoding.DecodeString(encodedUpdate)
+		if err != nil {
+			return nil, err
+		}
+		updates = append(updates, yjsDocumentUpdate{Id: id, Data: data})
+	}
+	return updates, rows.Err()
+}
+
+// readRoom returns the checkpointed state of a room and its pending updates. The state is read again
+// after the updates so a checkpoint running on another node between the two reads is noticed and the
+// read is retried.
+func (s *YjsDatabaseStore) readRoom(room ydb.YjsRoomName) (yjsDocumentState, []byte, error) {
+	for attempt := 0; attempt < 3; attempt++ {
+		document, err := s.documentState(room, s.connection)
+		if err != nil {
+			return yjsDocumentState{}, nil, err
+		}
+		updates, err := s.pendingUpdates(room, s.connection)
+		if err != nil {
+			return yjsDocumentState{}, nil, err
+		}
+		documentAfter, err := s.documentState(room, s.connection)
+		if err != nil {
+			return yjsDocumentState{}, nil, err
+		}
+		if document.Version != documentAfter.Version {
+			continue
+		}
+
+		pending := make([]byte, 0)
+		for _, update := range updates {
+			pending = append(pending, update.Data...)
+		}
+		return document, pending, nil
+	}
+	return yjsDocumentState{}, nil, errors.New("yjs room " + string(room) + " kept changing while being read")
+}
+
+func (s *YjsDatabaseStore) Append(room ydb.YjsRoomName, data []byte) (uint32, error) {
+	lock := s.roomLock(room)
+	lock.Lock()
+	defer lock.Unlock()
+
+	err := s.ensureRoom(room)
+	if err != nil {
+		return 0, err
+	}
+
+	referenceId := uuid.New()
+	query, args, err := statementbuilder.Squirrel.Insert(yjsDocumentUpdateTable).Prepared(true).
+		Cols("reference_id", "room_name", "update_data", "update_size", "version", "permission").
+		Vals([]interface{}{referenceId[:], string(room), base64.StdEncoding.EncodeToString(data), len(data), 1, yjsRowPermission}).
+		ToSQL()
+	if err != nil {
+		return 0, err
+	}
+	_, err = s.connection.Exec(query, args...)
+	if err != nil {
+		return 0, err
+	}
+
+	document, err := s.documentState(room, s.connection)
+	if err != nil {
+		return 0, err
+	}
+	updatesSize, err := s.updatesSize(room)
+	if err != nil {
+		return 0, err
+	}
+	return uint32(document.stateEnd() + updatesSize), nil
+}
+
+func (s *YjsDatabaseStore) ReadFrom(room ydb.YjsRoomName, offset uint32) ([]byte, uint32, error) {
+	lock := s.roomLock(room)
+	lock.Lock()
+	defer lock.Unlock()
+
+	err := s.ensureRoom(room)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	document, updates, err := s.readRoom(room)
+	if err != nil {
+		return nil, 0, err
+	}
+	stateEnd := document.stateEnd()
+	size := uint32(stateEnd + int64(len(updates)))
+	if offset >= size {
+		return nil, size, nil
+	}
+	if int64(offset) >= stateEnd {
+		return updates[int64(offset)-stateEnd:], size, nil
+	}
+	// the log before stateEnd was compacted into the state, an offset inside it gets the whole room.
+	// Applying a yjs update twice changes nothing.
+	if int64(len(document.State)) == stateEnd {
+		return append(document.State[offset:], updates...), size, nil
+	}
+	return append(document.State, updates...), size, nil
+}
+
+func (s *YjsDatabaseStore) Size(room ydb.YjsRoomName) (uint32, error) {
+	lock := s.roomLock(room)
+	lock.Lock()
+	defer lock.Unlock()
+
+	err := s.ensureRoom(room)
+	if err != nil {
+		return 0, err
+	}
+	return s.size(room)
+}
+
+func (s *YjsDatabaseStore) updatesSize(room ydb.YjsRoomName) (int64, error) {
+	query, args, err := statementbuilder.Squirrel.Select(
+		goqu.COALESCE(goqu.SUM("update_size"), 0)).Prepared(true).From(yjsDocumentUpdateTable).
+		Where(goqu.Ex{"room_name": string(room)}).ToSQL()
+	if err != nil {
+		return 0, err
+	}
+	var updatesSize int64
+	err = s.connection.QueryRowx(query, args...).Scan(&updatesSize)
+	return updatesSize, err
+}
+
+// size is the size of the room in the database, ydb checks it against its maximum room size. It
+// shrinks when a checkpoint compacts the room.
+func (s *YjsDatabaseStore) size(room ydb.YjsRoomName) (uint32, error) {
+	updatesSize, err := s.updatesSize(room)
+	if err != nil {
+		return 0, err
+	}
+
+	query, args, err := statementbuilder.Squirrel.Select("document_size").Prepared(true).
+		From(yjsDocumentTable).Where(goqu.Ex{"room_name": string(room)}).ToSQL()
+	if err != nil {
+		return 0, err
+	}
+	var documentSize int64
+	err = s.connection.QueryRowx(query, args...).Scan(&documentSize)
+	if err != nil {
+		return 0, err
+	}
+
+	return uint32(documentSize + updatesSize), nil
+}
+
+// SetInitialContent replaces the whole room, it is called when the file column a room is bound to is
+// updated through the api
+func (s *YjsDatabaseStore) SetInitialContent(room ydb.YjsRoomName, data []byte) error {
+	lock := s.roomLock(room)
+	lock.Lock()
+	defer lock.Unlock()
+
+	transaction, err := s.connection.Beginx()
+	if err != nil {
+		return err
+	}
+	defer transaction.Rollback()
+
+	err = s.replaceDocument(room, data, transaction)
+	if err != nil {
+		return err
+	}
+	return transaction.Commit()
+}
+
+func (s *YjsDatabaseStore) replaceDocument(room ydb.YjsRoomName, data []byte, transaction *sqlx.Tx) error {
+	query, args, err := statementbuilder.Squirrel.Delete(yjsDocumentUpdateTable).Prepared(true).
+		Where(goqu.Ex{"room_name": string(room)}).ToSQL()
+	if err != nil {
+		return err
+	}
+	_, err = transaction.Exec(query, args...)
+	if err != nil {
+		return err
+	}
+
+	query, args, err = statementbuilder.Squirrel.Update(yjsDocumentTable).Prepared(true).
+		Set(goqu.Record{
+			"document_state": base64.StdEncoding.EncodeToString(data),
+			"document_size":  len(data),
+			"log_offset":     0,
+			"version":        goqu.L("version + 1"),
+			"updated_at":     time.Now(),
+		}).Where(goqu.Ex{"room_name": string(room)}).ToSQL()
+	if err != nil {
+		return err
+	}
+	result, err := transaction.Exec(query, args...)
+	if err != nil {
+		return err
+	}
+	if updatedRows, _ := result.RowsAffected(); updatedRows > 0 {
+		return nil
+	}
+	return s.insertDocument(room, data, transaction)
+}
+
+// Checkpoint moves the pending updates of a room into its document state and keeps the result as a
+// snapshot. The state and the updates are compacted into a single merged yjs update, the log offset
+// they ended at is kept as the log_offset of the room. Rooms whose log cannot be read as yjs sync
+// messages are appended byte for byte instead. Snapshots beyond historySize are removed, oldest first.
+func (s *YjsDatabaseStore) Checkpoint(room ydb.YjsRoomName, historySize int) (bool, error) {
+	lock := s.roomLock(room)
+	lock.Lock()
+	defer lock.Unlock()
+
+	transaction, err := s.connection.Beginx()
+	if err != nil {
+		return false, err
+	}
+	defer transaction.Rollback()
+
+	document, err := s.documentState(room, transaction)
+	if err != nil {
+		return false, err
+	}
+	updates, err := s.pendingUpdates(room, transaction)
+	if err != nil || len(updates) == 0 {
+		return false, err
+	}
+
+	logOffset := document.stateEnd()
+	state := document.State
+	mergedIds := make([]int64, 0, len(updates))
+	for _, update := range updates {
+		state = append(state, update.Data...)
+		logOffset += int64(len(update.Data))
+		mergedIds = append(mergedIds, update.Id)
+	}
+
+	compacted, err := compactYjsLog(state)
+	if !resource.InfoErr(err, "Keeping the update log of yjs room [%v] as it is", room) {
+		state = compacted
+	}
+
+	// the version check makes a concurrent checkpoint on another node lose instead of overwriting
+	query, args, err := statementbuilder.Squirrel.Update(yjsDocumentTable).Prepared(true).
+		Set(goqu.Record{
+			"document_state": base64.StdEncoding.EncodeToString(state),
+			"document_size":  len(state),
+			"log_offset":     logOffset,
+			"version":        document.Version + 1,
+			"updated_at":     time.Now(),
+		}).Where(goqu.Ex{"room_name": string(room), "version": document.Version}).ToSQL()
+	if err != nil {
+		return false, err
+	}
+	result, err := transaction.Exec(query, args...)
+	if err != nil {
+		return false, err
+	}
+	if updatedRows, _ := result.RowsAffected(); updatedRows == 0 {
+		return false, nil
+	}
+
+	query, args, err = statementbuilder.Squirrel.Delete(yjsDocumentUpdateTable).Prepared(true).
+		Where(goqu.Ex{"id": mergedIds}).ToSQL()
+	if err != nil {
+		return false, err
+	}
+	_, err = transaction.Exec(query, args...)
+	if err != nil {
+		return false, err
+	}
+
+	err = s.insertSnapshot(room, state, YjsSnapshotReasonCheckpoint, transaction)
+	if err != nil {
+		return false, err
+	}
+	err = s.trimSnapshots(room, historySize, transaction)
+	if err != nil {
+		return false, err
+	}
+
+	return true, transaction.Commit()
+}
+
+func (s *YjsDatabaseStore) insertSnapshot(room ydb.YjsRoomName, state []byte, reason string, transaction *sqlx.Tx) error {
+	referenceId := uuid.New()
+	query, args, err := statementbuilder.Squirrel.Insert(yjsDocumentSnapshotTable).Prepared(true).
+		Cols("reference_id", "room_name", "snapshot_reason", "document_state", "document_size", "version", "permission", "created_at").
+		Vals([]interface{}{referenceId[:], string(room), reason, base64.StdEncoding.EncodeToString(state), len(state), 1, yjsRowPermission, time.Now()}).
+		ToSQL()
+	if err != nil {
+		return err
+	}
+	_, err = transaction.Exec(query, args...)
+	return err
+}
+
+func (s *YjsDatabaseStore) trimSnapshots(room ydb.YjsRoomName, historySize int, transaction *sqlx.Tx) error {
+	if historySize < 1 {
+		return nil
+	}
+	query, args, err := statementbuilder.Squirrel.Select("id").Prepared(true).
+		From(yjsDocumentSnapshotTable).Where(goqu.Ex{"room_name": string(room)}).
+		Order(goqu.C("id").Desc()).ToSQL()
+	if err != nil {
+		return err
+	}
+	snapshotIds := make([]int64, 0)
+	err = transaction.Select(&snapshotIds, query, args...)
+	if err != nil || len(snapshotIds) <= historySize {
+		return err
+	}
+	expiredIds := snapshotIds[historySize:]
+
+	query, args, err = statementbuilder.Squirrel.Delete(yjsDocumentSnapshotTable).Prepared(true).
+		Where(goqu.Ex{"id": expiredIds}).ToSQL()
+	if err != nil {
+		return err
+	}
+	_, err = transaction.Exec(query, args...)
+	return err
+}
+
+// CheckpointRooms checkpoints every room which received updates since its last checkpoint
+func (s *YjsDatabaseStore) CheckpointRooms(historySize int) error {
+	query, args, err := statementbuilder.Squirrel.Select("room_name").Distinct().Prepared(true).
+		From(yjsDocumentUpdateTable).ToSQL()
+	if err != nil {
+		return err
+	}
+	rooms := make([]string, 0)
+	err = s.connection.Select(&rooms, query, args...)
+	if err != nil {
+		return err
+	}
+
+	for _, room := range rooms {
+		checkpointed, err := s.Checkpoint(ydb.YjsRoomName(room), historySize)
+		if resource.CheckErr(err, "Failed to checkpoint yjs room [%v]", room) {
+			continue
+		}
+		if checkpointed {
+			logrus.Debugf("Checkpointed yjs room [%v]", room)
+		}
+	}
+	return nil
+}
+
+// CheckpointPeriodically runs CheckpointRooms every interval, it does not return
+func (s *YjsDatabaseStore) CheckpointPeriodically(interval time.Duration, historySize int) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		err := s.CheckpointRooms(historySize)
+		resource.CheckErr(err, "Failed to checkpoint yjs rooms")
+	}
+}
+
+// Snapshot returns the room and document state of a snapshot
+func (s *YjsDatabaseStore) Snapshot(snapshotId daptinid.DaptinReferenceId, transaction *sqlx.Tx) (ydb.YjsRoomName, []byte, error) {
+	query, args, err := statementbuilder.Squirrel.Select("room_name", "document_state").Prepared(true).
+		From(yjsDocumentSnapshotTable).Where(goqu.Ex{"reference_id": snapshotId[:]}).ToSQL()
+	if err != nil {
+		return "", nil, err
+	}
+
+	var roomName string
+	var encodedState sql.NullString
+	err = transaction.QueryRowx(query, args...).Scan(&roomName, &encodedState)
+	if err != nil {
+		return "", nil, err
+	}
+	state, err := base64.StdEncoding.DecodeString(encodedState.String)
+	return ydb.YjsRoomName(roomName), state, err
+}
+
+// RestoreSnapshot replaces a room with the state of a snapshot. The current state is kept as a
+// before_restore snapshot first so the restore itself can be undone.
+func (s *YjsDatabaseStore) RestoreSnapshot(room ydb.YjsRoomName, state []byte, transaction *sqlx.Tx) error {
+	lock := s.roomLock(room)
+	lock.Lock()
+	defer lock.Unlock()
+
+	document, err := s.documentState(room, transaction)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	updates, err := s.pendingUpdates(room, transaction)
+	if err != nil {
+		return err
+	}
+	currentState := document.State
+	for _, update := range updates {
+		currentState = append(currentState, update.Data...)
+	}
+
+	err = s.insertSnapshot(room, currentState, YjsSnapshotReasonBeforeRestore, transaction)
+	if err != nil {
+		return err
+	}
+	return s.replaceDocument(room, state, transaction)
+}
@@ -0,0 +1,266 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/artpar/ydb"
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func yjsTestDatabase(t *testing.T) *sqlx.DB {
+	db, err := sqlx.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	for _, table := range []string{
+		`create table yjs_document (id integer primary key autoincrement, reference_id blob, room_name varchar(500) unique,
+			document_state text, document_size int default 0, log_offset int default 0, version int default 1, permission int,
+			created_at timestamp default current_timestamp, updated_at timestamp)`,
+		`create table yjs_document_update (id integer primary key autoincrement, reference_id blob, room_name varchar(500),
+			update_data text, update_size int default 0, version int default 1, permission int,
+			created_at timestamp default current_timestamp, updated_at timestamp)`,
+		`create table yjs_document_snapshot (id integer primary key autoincrement, reference_id blob, room_name varchar(500),
+			snapshot_reason varchar(50), document_state text, document_size int default 0, version int default 1, permission int,
+			created_at timestamp default current_timestamp, updated_at timestamp)`,
+	} {
+		if _, err = db.Exec(table); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return db
+}
+
+func yjsTestRoomContents(t *testing.T, store ydb.Store, room ydb.YjsRoomName) []byte {
+	data, size, err := store.ReadFrom(room, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if int(size) != len(data) {
+		t.Fatalf("size %d does not match %d bytes read", size, len(data))
+	}
+	return data
+}
+
+func TestYjsDatabaseStoreOffsets(t *testing.T) {
+	db := yjsTestDatabase(t)
+	room := ydb.YjsRoomName("document.11111111-1111-1111-1111-111111111111.content")
+	providerCalls := 0
+	store := NewYjsDatabaseStore(db, func(roomName string) []byte {
+		providerCalls++
+		return []byte("init")
+	})
+
+	size, err := store.Size(room)
+	if err != nil || size != 4 {
+		t.Fatalf("expected the initial content to be loaded, got %d %v", size, err)
+	}
+	offset, err := store.Append(room, []byte("ab"))
+	if err != nil || offset != 6 {
+		t.Fatalf("expected offset 6 after append, got %d %v", offset, err)
+	}
+	data, size, err := store.ReadFrom(room, 4)
+	if err != nil || size != 6 || string(data) != "ab" {
+		t.Fatalf("expected to read the appended update, got %q %d %v", data, size, err)
+	}
+	if data, size, _ = store.ReadFrom(room, 6); len(data) != 0 || size != 6 {
+		t.Fatalf("expected nothing past the end, got %q %d", data, size)
+	}
+
+	// a second node sharing the database sees the same room and does not reload the initial content
+	otherNode := NewYjsDatabaseStore(db, func(roomName string) []byte {
+		providerCalls++
+		return []byte("other")
+	})
+	if contents := yjsTestRoomContents(t, otherNode, room); string(contents) != "initab" {
+		t.Fatalf("second node read %q", contents)
+	}
+	if providerCalls != 1 {
+		t.Fatalf("initial content provider called %d times", providerCalls)
+	}
+
+	if err = otherNode.SetInitialContent(room, []byte("replaced")); err != nil {
+		t.Fatal(err)
+	}
+	if contents := yjsTestRoomContents(t, store, room); string(contents) != "replaced" {
+		t.Fatalf("expected the room to be replaced, got %q", contents)
+	}
+}
+
+func TestYjsDatabaseStoreCheckpointAndRestore(t *testing.T) {
+	db := yjsTestDatabase(t)
+	room := ydb.YjsRoomName("notes")
+	store := NewYjsDatabaseStore(db, nil)
+
+	for _, update := range []string{"a", "b", "c"} {
+		if _, err := store.Append(room, []byte(update)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkpointed, err := store.Checkpoint(room, 2)
+	if err != nil || !checkpointed {
+		t.Fatalf("expected the room to be checkpointed, got %v %v", checkpointed, err)
+	}
+	if contents := yjsTestRoomContents(t, store, room); string(contents) != "abc" {
+		t.Fatalf("checkpoint changed the room to %q", contents)
+	}
+	var pending int
+	if err = db.Get(&pending, "select count(*) from yjs_document_update"); err != nil || pending != 0 {
+		t.Fatalf("expected merged updates to be removed, %d left %v", pending, err)
+	}
+	if checkpointed, _ = store.Checkpoint(room, 2); checkpointed {
+		t.Fatal("checkpointed a room without pending updates")
+	}
+
+	for _, update := range []string{"d", "e"} {
+		if _, err = store.Append(room, []byte(update)); err != nil {
+			t.Fatal(err)
+		}
+		if err = store.CheckpointRooms(2); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var snapshots []string
+	if err = db.Select(&snapshots, "select document_state from yjs_document_snapshot order by id"); err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshots) != 2 {
+		t.Fatalf("expected snapshot history to be trimmed to 2, got %d", len(snapshots))
+	}
+	if contents := yjsTestRoomContents(t, store, room); string(contents) != "abcde" {
+		t.Fatalf("room is %q after checkpoints", contents)
+	}
+
+	var snapshotReferenceId []byte
+	if err = db.Get(&snapshotReferenceId, "select reference_id from yjs_document_snapshot order by id limit 1"); err != nil {
+		t.Fatal(err)
+	}
+	var snapshotId [16]byte
+	copy(snapshotId[:], snapshotReferenceId)
+
+	transaction, err := db.Beginx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	snapshotRoom, state, err := store.Snapshot(snapshotId, transaction)
+	if err != nil || snapshotRoom != room || string(state) != "abcd" {
+		t.Fatalf("unexpected snapshot %v %q %v", snapshotRoom, state, err)
+	}
+	if err = store.RestoreSnapshot(room, state, transaction); err != nil {
+		t.Fatal(err)
+	}
+	if err = transaction.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	if contents := yjsTestRoomContents(t, store, room); string(contents) != "abcd" {
+		t.Fatalf("expected the room to be restored, got %q", contents)
+	}
+	var beforeRestore string
+	if err = db.Get(&beforeRestore, "select snapshot_reason from yjs_document_snapshot order by id desc limit 1"); err != nil || beforeRestore != YjsSnapshotReasonBeforeRestore {
+		t.Fatalf("expected the state before the restore to be kept, got %q %v", beforeRestore, err)
+	}
+}
+
+func TestYjsDatabaseStoreCompactsOnCheckpoint(t *testing.T) {
+	db := yjsTestDatabase(t)
+	room := ydb.YjsRoomName("notes")
+	store := NewYjsDatabaseStore(db, nil)
+
+	firstUpdateEnd, err := store.Append(room, yjsTestLogEntry(yjsMessageSyncStep2, yjsTestUpdateAB))
+	if err != nil {
+		t.Fatal(err)
+	}
+	logEnd, err := store.Append(room, yjsTestLogEntry(yjsMessageSyncStep1, []byte{0}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if checkpointed, err := store.Checkpoint(room, 2); err != nil || !checkpointed {
+		t.Fatalf("expected the room to be checkpointed, got %v %v", checkpointed, err)
+	}
+
+	compacted := yjsTestLogEntry(yjsMessageUpdate, yjsTestUpdateAB)
+	if size, _ := store.Size(room); size != uint32(len(compacted)) {
+		t.Fatalf("expected the compacted room to take %d bytes, got %d", len(compacted), size)
+	}
+	data, size, err := store.ReadFrom(room, 0)
+	if err != nil || size != logEnd || !bytes.Equal(data, compacted) {
+		t.Fatalf("unexpected compacted room %v %d %v", data, size, err)
+	}
+
+	// offsets keep counting from where the log ended before the checkpoint
+	updateC := yjsTestLogEntry(yjsMessageUpdate, yjsTestUpdateC)
+	offset, err := store.Append(room, updateC)
+	if err != nil || offset != logEnd+uint32(len(updateC)) {
+		t.Fatalf("expected offset %d after append, got %d %v", logEnd+uint32(len(updateC)), offset, err)
+	}
+	if data, _, _ = store.ReadFrom(room, logEnd); !bytes.Equal(data, updateC) {
+		t.Fatalf("expected to read the update appended after the checkpoint, got %v", data)
+	}
+	// an offset inside the compacted state gets the whole room
+	if data, _, _ = store.ReadFrom(room, firstUpdateEnd); !bytes.Equal(data, append(compacted, updateC...)) {
+		t.Fatalf("expected the whole room, got %v", data)
+	}
+
+	if _, err = store.Checkpoint(room, 2); err != nil {
+		t.Fatal(err)
+	}
+	data, size, _ = store.ReadFrom(room, 0)
+	if size != offset || !bytes.Equal(data, yjsTestLogEntry(yjsMessageUpdate, yjsTestMergedABC)) {
+		t.Fatalf("expected the updates to be merged, got %v %d", data, size)
+	}
+}
+
+type yjsTestPublisher struct {
+	payloads []string
+}
+
+func (p *yjsTestPublisher) Publish(ctx context.Context, channel string, message interface{}) (int64, error) {
+	p.payloads = append(p.payloads, message.(string))
+	return 1, nil
+}
+
+func TestYjsClusterBroadcasterRelaysBetweenNodes(t *testing.T) {
+	room := ydb.YjsRoomName("notes")
+	publisher := &yjsTestPublisher{}
+	nodeA := NewYjsClusterBroadcaster(ydb.NewLocalBroadcaster(4), publisher, "node-a")
+	nodeB := NewYjsClusterBroadcaster(ydb.NewLocalBroadcaster(4), publisher, "node-b")
+
+	localSession, _ := nodeA.Subscribe(room, 1)
+	remoteSession, _ := nodeB.Subscribe(room, 1)
+
+	nodeA.Publish(room, 2, []byte("update"))
+	if len(publisher.payloads) != 1 {
+		t.Fatalf("expected the update to be relayed once, got %d", len(publisher.payloads))
+	}
+	for _, payload := range publisher.payloads {
+		nodeA.handleRelayMessage(payload)
+		nodeB.handleRelayMessage(payload)
+	}
+
+	receive := func(channel <-chan []byte) []byte {
+		select {
+		case message := <-channel:
+			return message
+		case <-time.After(time.Second):
+			return nil
+		}
+	}
+	if message := receive(localSession); !bytes.Equal(message, []byte("update")) {
+		t.Fatalf("local session got %q", message)
+	}
+	if message := receive(remoteSession); !bytes.Equal(message, []byte("update")) {
+		t.Fatalf("session on the other node got %q", message)
+	}
+	select {
+	case message := <-localSession:
+		t.Fatalf("own relay message delivered again: %q", message)
+	default:
+	}
+}
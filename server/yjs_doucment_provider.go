@@ -9,7 +9,9 @@ import (
 	"github.com/jmoiron/sqlx"
 	"github.com/sirupsen/logrus"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 )
 
 // PathExistsAndIsFolder checks if a path exists and is a folder
@@ -24,8 +26,50 @@ func PathExistsAndIsFolder(path string) bool {
 	return info.IsDir() // Check if it's a directory
 }
 
+// CreateYjsStore returns the store for yjs rooms. yjs.storage.backend selects between "disk", one file
+// per room under the local storage path, and "database", which shares rooms between the nodes of a
+// cluster and keeps a version history of every document.
 func CreateYjsStore(configStore *resource.ConfigStore, transaction *sqlx.Tx, localStoragePath string, cruds map[string]*resource.DbResource) ydb.Store {
 	logrus.Infof("YJS endpoint is enabled in config")
+
+	storageBackend, err := configStore.GetConfigValueFor("yjs.storage.backend", "backend", transaction)
+	if err != nil || storageBackend == "" {
+		storageBackend = "disk"
+		err = configStore.SetConfigValueFor("yjs.storage.backend", storageBackend, "backend", transaction)
+		resource.CheckErr(err, "Failed to store default value for yjs.storage.backend")
+	}
+
+	if storageBackend == "database" {
+		checkpointInterval, err := configStore.GetConfigValueFor("yjs.checkpoint.interval", "backend", transaction)
+		if err != nil || checkpointInterval == "" {
+			checkpointInterval = "10m"
+			err = configStore.SetConfigValueFor("yjs.checkpoint.interval", checkpointInterval, "backend", transaction)
+			resource.CheckErr(err, "Failed to store default value for yjs.checkpoint.interval")
+		}
+		interval, err := time.ParseDuration(checkpointInterval)
+		if err != nil || interval <= 0 {
+			logrus.Warnf("Invalid yjs.checkpoint.interval [%v], using 10m", checkpointInterval)
+			interval = 10 * time.Minute
+		}
+
+		snapshotHistory, err := configStore.GetConfigValueFor("yjs.snapshot.history_size", "backend", transaction)
+		if err != nil || snapshotHistory == "" {
+			snapshotHistory = "20"
+			err = configStore.SetConfigValueFor("yjs.snapshot.history_size", snapshotHistory, "backend", transaction)
+			resource.CheckErr(err, "Failed to store default value for yjs.snapshot.history_size")
+		}
+		historySize, err := strconv.Atoi(snapshotHistory)
+		if err != nil {
+			logrus.Warnf("Invalid yjs.snapshot.history_size [%v], using 20", snapshotHistory)
+			historySize = 20
+		}
+
+		logrus.Infof("YJS documents are stored in the database, checkpointed every %v", interval)
+		store := NewYjsDatabaseStore(cruds["world"].Connection(), yjsInitialContentProvider(cruds))
+		go store.CheckpointPeriodically(interval, historySize)
+		return store
+	}
+
 	yjsDir := localStoragePath + "/yjs-documents"
 	configStore.SetConfigValueFor("yjs.storage.path", yjsDir, "backend", transaction)
 
@@ -38,87 +82,92 @@ func CreateYjsStore(configStore *resource.ConfigStore, transaction *sqlx.Tx, loc
 
 	return ydb.NewDiskStore(yjsDir,
 		ydb.WithMaxRoomSize(50*1024*1024),
-		ydb.WithInitialContentProvider(func(documentPath string) []byte {
-			logrus.Debugf("Get initial content for document: %v", documentPath)
-			pathParts := strings.Split(documentPath, ".")
-			if len(pathParts) != 3 {
-				logrus.Debugf("document path %v does not follow typename.referenceId.columnName format, returning empty content", documentPath)
-				return []byte{}
-			}
-			typeName := pathParts[0]
-			referenceId := pathParts[1]
-			columnName := pathParts[2]
-
-			parsedId, parseErr := uuid.Parse(referenceId)
-			if parseErr != nil {
-				logrus.Warnf("failed to parse reference_id as UUID: %v", referenceId)
-				return []byte{}
-			}
+		ydb.WithInitialContentProvider(yjsInitialContentProvider(cruds)),
+	)
+}
 
-			crud, ok := cruds[typeName]
-			if !ok || crud == nil {
-				logrus.Warnf("no crud for type %v in document provider", typeName)
-				return []byte{}
-			}
+// yjsInitialContentProvider loads a room bound to a file column from the x-crdt/yjs file stored in the row
+func yjsInitialContentProvider(cruds map[string]*resource.DbResource) func(documentPath string) []byte {
+	return func(documentPath string) []byte {
+		logrus.Debugf("Get initial content for document: %v", documentPath)
+		pathParts := strings.Split(documentPath, ".")
+		if len(pathParts) != 3 {
+			logrus.Debugf("document path %v does not follow typename.referenceId.columnName format, returning empty content", documentPath)
+			return []byte{}
+		}
+		typeName := pathParts[0]
+		referenceId := pathParts[1]
+		columnName := pathParts[2]
 
-			columnInfo, ok := crud.TableInfo().GetColumnByName(columnName)
-			if !ok || !BeginsWithCheck(columnInfo.ColumnType, "file.") {
-				logrus.Warnf("column %v is not a file column on type %v", columnName, typeName)
-				return []byte{}
-			}
-			columnName = columnInfo.ColumnName
+		parsedId, parseErr := uuid.Parse(referenceId)
+		if parseErr != nil {
+			logrus.Warnf("failed to parse reference_id as UUID: %v", referenceId)
+			return []byte{}
+		}
 
-			tx, txErr := crud.Connection().Beginx()
-			if txErr != nil {
-				return nil
-			}
-			defer tx.Rollback()
-
-			object, _, getErr := crud.GetSingleRowByReferenceIdWithTransaction(typeName,
-				daptinid.DaptinReferenceId(parsedId), map[string]bool{
-					columnName: true,
-				}, tx)
-			logrus.Tracef("Completed NewDiskStore GetSingleRowByReferenceIdWithTransaction")
-			if getErr != nil {
-				logrus.Warnf("failed to get row in document provider: %v", getErr)
-				return []byte{}
-			}
-			if object == nil {
-				return []byte{}
-			}
+		crud, ok := cruds[typeName]
+		if !ok || crud == nil {
+			logrus.Warnf("no crud for type %v in document provider", typeName)
+			return []byte{}
+		}
+
+		columnInfo, ok := crud.TableInfo().GetColumnByName(columnName)
+		if !ok || !BeginsWithCheck(columnInfo.ColumnType, "file.") {
+			logrus.Warnf("column %v is not a file column on type %v", columnName, typeName)
+			return []byte{}
+		}
+		columnName = columnInfo.ColumnName
+
+		tx, txErr := crud.Connection().Beginx()
+		if txErr != nil {
+			return nil
+		}
+		defer tx.Rollback()
+
+		object, _, getErr := crud.GetSingleRowByReferenceIdWithTransaction(typeName,
+			daptinid.DaptinReferenceId(parsedId), map[string]bool{
+				columnName: true,
+			}, tx)
+		logrus.Tracef("Completed NewDiskStore GetSingleRowByReferenceIdWithTransaction")
+		if getErr != nil {
+			logrus.Warnf("failed to get row in document provider: %v", getErr)
+			return []byte{}
+		}
+		if object == nil {
+			return []byte{}
+		}
 
-			originalFile := object[columnName]
-			if originalFile == nil {
-				return []byte{}
+		originalFile := object[columnName]
+		if originalFile == nil {
+			return []byte{}
+		}
+		columnValueArray, ok := originalFile.([]map[string]interface{})
+		if !ok {
+			logrus.Warnf("column value is not []map[string]interface{}: %v", originalFile)
+			return []byte{}
+		}
+
+		fileContentsJson := []byte{}
+		for _, file := range columnValueArray {
+			if file["type"] != "x-crdt/yjs" {
+				continue
 			}
-			columnValueArray, ok := originalFile.([]map[string]interface{})
+
+			contentsStr, ok := file["contents"].(string)
 			if !ok {
-				logrus.Warnf("column value is not []map[string]interface{}: %v", originalFile)
-				return []byte{}
+				logrus.Warnf("file contents is not a string: %v", file["contents"])
+				continue
 			}
-
-			fileContentsJson := []byte{}
-			for _, file := range columnValueArray {
-				if file["type"] != "x-crdt/yjs" {
-					continue
-				}
-
-				contentsStr, ok := file["contents"].(string)
-				if !ok {
-					logrus.Warnf("file contents is not a string: %v", file["contents"])
-					continue
-				}
-				decoded, decodeErr := base64.StdEncoding.DecodeString(contentsStr)
-				if decodeErr != nil {
-					logrus.Warnf("failed to base64 decode file contents: %v", decodeErr)
-					continue
-				}
-				fileContentsJson = decoded
-
+			decoded, decodeErr := base64.StdEncoding.DecodeString(contentsStr)
+			if decodeErr != nil {
+				logrus.Warnf("failed to base64 decode file contents: %v", decodeErr)
+				continue
 			}
+			fileContentsJson = decoded
 
-			logrus.Debugf("Completed get initial content for document: %v", documentPath)
-			return fileContentsJson
-		}),
-	)
+		}
+
+		logrus.Debugf("Completed get initial content for document: %v", documentPath)
+		return fileContentsJson
+	}
 }
@@ -0,0 +1,136 @@
+package server
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+
+	"github.com/artpar/api2go/v2"
+	"github.com/daptin/daptin/server/actionresponse"
+	"github.com/daptin/daptin/server/auth"
+	daptinid "github.com/daptin/daptin/server/id"
+	"github.com/daptin/daptin/server/resource"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/sirupsen/logrus"
+)
+
+// yjsSnapshotRestorePerformer rolls a yjs document back to one of its snapshots. The user needs
+// update permission on the row the document belongs to, rooms not bound to a row are restored by
+// administrators only.
+type yjsSnapshotRestorePerformer struct {
+	cruds map[string]*resource.DbResource
+	store *YjsDatabaseStore
+}
+
+func (d *yjsSnapshotRestorePerformer) Name() string {
+	return "yjs.snapshot.restore"
+}
+
+func (d *yjsSnapshotRestorePerformer) DoAction(request actionresponse.Outcome, inFields map[string]interface{}, transaction *sqlx.Tx) (api2go.Responder, []actionresponse.ActionResponse, []error) {
+
+	sessionUser, ok := inFields["sessionUser"].(*auth.SessionUser)
+	if !ok || sessionUser == nil {
+		return nil, nil, []error{errors.New("unauthorized")}
+	}
+
+	snapshotId := daptinid.InterfaceToDIR(inFields["snapshot_id"])
+	if snapshotId == daptinid.NullReferenceId {
+		return nil, nil, []error{errors.New("invalid snapshot_id")}
+	}
+
+	room, state, err := d.store.Snapshot(snapshotId, transaction)
+	if err != nil {
+		return nil, nil, []error{errors.New("snapshot not found")}
+	}
+
+	// rooms which are not bound to a row have no owner to check against, only administrators restore them
+	parts, isCanonicalRoom := canonicalYjsRoomParts(string(room))
+	if !isCanonicalRoom {
+		if !resource.IsAdminWithTransaction(sessionUser, transaction) {
+			return nil, nil, []error{errors.New("permission denied")}
+		}
+	} else {
+		_, readOnly, status, authorizeErr := authorizeYjsRoom(d.cruds, sessionUser, parts[0], parts[1], parts[2])
+		if authorizeErr != nil || status != 0 || readOnly {
+			if authorizeErr != nil {
+				logrus.Errorf("failed to authorize YJS snapshot restore: %v", authorizeErr)
+			}
+			return nil, nil, []error{errors.New("permission denied")}
+		}
+	}
+
+	err = d.store.RestoreSnapshot(room, state, transaction)
+	if err != nil {
+		return nil, nil, []error{err}
+	}
+	if isCanonicalRoom {
+		err = d.restoreFileColumn(parts, state, sessionUser, transaction)
+		if err != nil {
+			return nil, nil, []error{err}
+		}
+	}
+	logrus.Infof("Restored yjs room [%v] to snapshot [%v]", room, snapshotId)
+
+	return nil, []actionresponse.ActionResponse{
+		resource.NewActionResponse("client.notify", map[string]interface{}{
+			"type":    "success",
+			"title":   "Success",
+			"message": "Document restored, editors need to reload it to see the restored version",
+		}),
+	}, nil
+}
+
+// restoreFileColumn writes the restored state into the x-crdt/yjs file of the file column the room is
+// bound to, so the row does not keep serving the state from before the restore
+func (d *yjsSnapshotRestorePerformer) restoreFileColumn(parts []string, state []byte, sessionUser *auth.SessionUser, transaction *sqlx.Tx) error {
+	typeName, columnName := parts[0], parts[2]
+	crud := d.cruds[typeName]
+	referenceId := daptinid.DaptinReferenceId(uuid.MustParse(parts[1]))
+
+	object, _, err := crud.GetSingleRowByReferenceIdWithTransaction(typeName, referenceId, map[string]bool{
+		"x-crdt/yjs": true,
+	}, transaction)
+	if err != nil {
+		return err
+	}
+	files, _ := object[columnName].([]map[string]interface{})
+
+	restoredFiles := make([]interface{}, 0, 1)
+	for _, file := range files {
+		if file["type"] != "x-crdt/yjs" {
+			continue
+		}
+		restoredFiles = append(restoredFiles, map[string]interface{}{
+			"name":     file["name"],
+			"path":     file["path"],
+			"type":     "x-crdt/yjs",
+			"contents": "x-crdt/yjs," + base64.StdEncoding.EncodeToString(state),
+		})
+	}
+	if len(restoredFiles) == 0 {
+		// the document was never saved to the row, the room is the only copy
+		return nil
+	}
+
+	model := api2go.NewApi2GoModelWithData(typeName, nil, 0, nil, object)
+	model.SetAttributes(map[string]interface{}{
+		columnName: restoredFiles,
+	})
+	pr := &http.Request{
+		Method: "PATCH",
+		URL:    &url.URL{Path: "/api/" + typeName + "/" + referenceId.String()},
+	}
+	pr = pr.WithContext(context.WithValue(context.Background(), "user", sessionUser))
+	_, err = crud.UpdateWithoutFilters(model, api2go.Request{PlainRequest: pr}, transaction)
+	return err
+}
+
+func NewYjsSnapshotRestorePerformer(cruds map[string]*resource.DbResource, store *YjsDatabaseStore) (actionresponse.ActionPerformerInterface, error) {
+	return &yjsSnapshotRestorePerformer{
+		cruds: cruds,
+		store: store,
+	}, nil
+}
@@ -0,0 +1,711 @@
+package server
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"unicode/utf16"
+)
+
+// message types of the ydb room log, every entry is a framed sync message
+const (
+	yjsMessageSync      = 0
+	yjsMessageSyncStep1 = 0
+	yjsMessageSyncStep2 = 1
+	yjsMessageUpdate    = 2
+)
+
+// struct and content references of the yjs v1 update encoding
+const (
+	yjsRefGC      = 0
+	yjsRefDeleted = 1
+	yjsRefJSON    = 2
+	yjsRefString  = 4
+	yjsRefAny     = 8
+	yjsRefSkip    = 10
+
+	yjsTypeRefXmlElement = 3
+	yjsTypeRefXmlHook    = 5
+
+	yjsInfoOrigin      = 0x80
+	yjsInfoRightOrigin = 0x40
+	yjsInfoParentSub   = 0x20
+	yjsInfoRef         = 0x1f
+)
+
+type yjsID struct {
+	client uint64
+	clock  uint64
+}
+
+// yjsStruct is a GC, Skip or Item struct of an update. Item contents are kept encoded, strings and
+// lists are split into their elements so an item can be sliced where two updates overlap.
+type yjsStruct struct {
+	ref         byte
+	id          yjsID
+	length      uint64
+	origin      *yjsID
+	rightOrigin *yjsID
+	parent      []byte
+	parentSub   []byte
+	elements    [][]byte
+	text        []uint16
+	content     []byte
+}
+
+func (s *yjsStruct) end() uint64 {
+	return s.id.clock + s.length
+}
+
+func (s *yjsStruct) isItem() bool {
+	return s.ref != yjsRefGC && s.ref != yjsRefSkip
+}
+
+// slice returns the part of the struct starting diff clocks after its first clock, the same way yjs
+// splits an item: the left neighbour becomes the origin of the right part
+func (s *yjsStruct) slice(diff uint64) *yjsStruct {
+	right := *s
+	right.id = yjsID{client: s.id.client, clock: s.id.clock + diff}
+	right.length = s.length - diff
+	if !s.isItem() {
+		return &right
+	}
+	right.origin = &yjsID{client: s.id.client, clock: s.id.clock + diff - 1}
+	switch s.ref {
+	case yjsRefJSON, yjsRefAny:
+		right.elements = s.elements[diff:]
+	case yjsRefString:
+		right.content = nil
+		right.text = append([]uint16{}, s.text[diff:]...)
+		// a surrogate pair cut in half is replaced, as yjs does
+		if utf16.IsSurrogate(rune(s.text[diff-1])) && s.text[diff-1] < 0xdc00 {
+			right.text[0] = 0xfffd
+		}
+	}
+	return &right
+}
+
+// mergeWith extends a GC or Skip by the one following it. Items are never merged, the result is a
+// valid update either way.
+func (s *yjsStruct) mergeWith(right *yjsStruct) bool {
+	if s.isItem() || s.ref != right.ref {
+		return false
+	}
+	s.length += right.length
+	return true
+}
+
+func (s *yjsStruct) write(buf *bytes.Buffer) {
+	if !s.isItem() {
+		buf.WriteByte(s.ref)
+		writeYjsUvarint(buf, s.length)
+		return
+	}
+
+	info := s.ref
+	if s.origin != nil {
+		info |= yjsInfoOrigin
+	}
+	if s.rightOrigin != nil {
+		info |= yjsInfoRightOrigin
+	}
+	writeParent := s.origin == nil && s.rightOrigin == nil
+	if writeParent && s.parentSub != nil {
+		info |= yjsInfoParentSub
+	}
+	buf.WriteByte(info)
+	if s.origin != nil {
+		writeYjsUvarint(buf, s.origin.client)
+		writeYjsUvarint(buf, s.origin.clock)
+	}
+	if s.rightOrigin != nil {
+		writeYjsUvarint(buf, s.rightOrigin.client)
+		writeYjsUvarint(buf, s.rightOrigin.clock)
+	}
+	if writeParent {
+		buf.Write(s.parent)
+		buf.Write(s.parentSub)
+	}
+
+	switch s.ref {
+	case yjsRefDeleted:
+		writeYjsUvarint(buf, s.length)
+	case yjsRefJSON, yjsRefAny:
+		writeYjsUvarint(buf, uint64(len(s.elements)))
+		for _, element := range s.elements {
+			buf.Write(element)
+		}
+	case yjsRefString:
+		if s.content != nil {
+			buf.Write(s.content)
+			return
+		}
+		text := []byte(string(utf16.Decode(s.text)))
+		writeYjsUvarint(buf, uint64(len(text)))
+		buf.Write(text)
+	default:
+		buf.Write(s.content)
+	}
+}
+
+type yjsDeleteRange struct {
+	clock  uint64
+	length uint64
+}
+
+type yjsDecoder struct {
+	data     []byte
+	position int
+}
+
+func (d *yjsDecoder) readUvarint() (uint64, error) {
+	value, n := binary.Uvarint(d.data[d.position:])
+	if n <= 0 {
+		return 0, errors.New("invalid yjs update: bad varint")
+	}
+	d.position += n
+	return value, nil
+}
+
+func (d *yjsDecoder) readByte() (byte, error) {
+	if d.position >= len(d.data) {
+		return 0, errors.New("invalid yjs update: unexpected end")
+	}
+	d.position++
+	return d.data[d.position-1], nil
+}
+
+func (d *yjsDecoder) skip(n uint64) error {
+	if n > uint64(len(d.data)-d.position) {
+		return errors.New("invalid yjs update: unexpected end")
+	}
+	d.position += int(n)
+	return nil
+}
+
+// readBuffer reads a length prefixed string or byte array
+func (d *yjsDecoder) readBuffer() ([]byte, error) {
+	length, err := d.readUvarint()
+	if err != nil {
+		return nil, err
+	}
+	start := d.position
+	err = d.skip(length)
+	return d.data[start:d.position], err
+}
+
+// since returns the bytes read after start, the encoded form of what was read
+func (d *yjsDecoder) since(start int) []byte {
+	return d.data[start:d.position]
+}
+
+func (d *yjsDecoder) skipBuffer() error {
+	_, err := d.readBuffer()
+	return err
+}
+
+// skipAny skips a value of the lib0 any encoding
+func (d *yjsDecoder) skipAny() error {
+	valueType, err := d.readByte()
+	if err != nil {
+		return err
+	}
+	switch valueType {
+	case 127, 126, 121, 120:
+		return nil
+	case 125:
+		_, err = d.readUvarint()
+		return err
+	case 124:
+		return d.skip(4)
+	case 123, 122:
+		return d.skip(8)
+	case 119, 116:
+		return d.skipBuffer()
+	case 118:
+		count, err := d.readUvarint()
+		if err != nil {
+			return err
+		}
+		for i := uint64(0); i < count; i++ {
+			if err = d.skipBuffer(); err != nil {
+				return err
+			}
+			if err = d.skipAny(); err != nil {
+				return err
+			}
+		}
+		return nil
+	case 117:
+		count, err := d.readUvarint()
+		if err != nil {
+			return err
+		}
+		for i := uint64(0); i < count; i++ {
+			if err = d.skipAny(); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("invalid yjs update: unknown value type %d", valueType)
+}
+
+func (d *yjsDecoder) readID() (*yjsID, error) {
+	client, err := d.readUvarint()
+	if err != nil {
+		return nil, err
+	}
+	clock, err := d.readUvarint()
+	return &yjsID{client: client, clock: clock}, err
+}
+
+func (d *yjsDecoder) readItem(id yjsID, info byte) (*yjsStruct, error) {
+	item := &yjsStruct{ref: info & yjsInfoRef, id: id}
+	var err error
+	if info&yjsInfoOrigin != 0 {
+		if item.origin, err = d.readID(); err != nil {
+			return nil, err
+		}
+	}
+	if info&yjsInfoRightOrigin != 0 {
+		if item.rightOrigin, err = d.readID(); err != nil {
+			return nil, err
+		}
+	}
+	if info&(yjsInfoOrigin|yjsInfoRightOrigin) == 0 {
+		start := d.position
+		parentIsKey, err := d.readUvarint()
+		if err != nil {
+			return nil, err
+		}
+		if parentIsKey == 1 {
+			err = d.skipBuffer()
+		} else {
+			_, err = d.readID()
+		}
+		if err != nil {
+			return nil, err
+		}
+		item.parent = d.since(start)
+		if info&yjsInfoParentSub != 0 {
+			start = d.position
+			if err = d.skipBuffer(); err != nil {
+				return nil, err
+			}
+			item.parentSub = d.since(start)
+		}
+	}
+
+	start := d.position
+	switch item.ref {
+	case yjsRefDeleted:
+		item.length, err = d.readUvarint()
+		return item, err
+	case yjsRefJSON, yjsRefAny:
+		count, err := d.readUvarint()
+		if err != nil {
+			return nil, err
+		}
+		for i := uint64(0); i < count; i++ {
+			elementStart := d.position
+			if item.ref == yjsRefJSON {
+				err = d.skipBuffer()
+			} else {
+				err = d.skipAny()
+			}
+			if err != nil {
+				return nil, err
+			}
+			item.elements = append(item.elements, d.since(elementStart))
+		}
+		item.length = count
+		return item, nil
+	case yjsRefString:
+		text, err := d.readBuffer()
+		if err != nil {
+			return nil, err
+		}
+		item.content = d.since(start)
+		item.text = utf16.Encode([]rune(string(text)))
+		item.length = uint64(len(item.text))
+		if item.length == 0 {
+			return nil, errors.New("invalid yjs update: empty string item")
+		}
+		return item, nil
+	case 3, 5:
+		err = d.skipBuffer()
+	case 6:
+		if err = d.skipBuffer(); err == nil {
+			err = d.skipBuffer()
+		}
+	case 7:
+		typeRef, err := d.readUvarint()
+		if err != nil {
+			return nil, err
+		}
+		if typeRef == yjsTypeRefXmlElement || typeRef == yjsTypeRefXmlHook {
+			err = d.skipBuffer()
+		}
+		if err != nil {
+			return nil, err
+		}
+	case 9:
+		if err = d.skipBuffer(); err == nil {
+			err = d.skipAny()
+		}
+	default:
+		return nil, fmt.Errorf("invalid yjs update: unknown content %d", item.ref)
+	}
+	if err != nil {
+		return nil, err
+	}
+	item.content = d.since(start)
+	item.length = 1
+	return item, nil
+}
+
+// readStructs reads the structs of an update in order, skips are left out
+func (d *yjsDecoder) readStructs() ([]*yjsStruct, error) {
+	structs := make([]*yjsStruct, 0)
+	clientCount, err := d.readUvarint()
+	if err != nil {
+		return nil, err
+	}
+	for i := uint64(0); i < clientCount; i++ {
+		structCount, err := d.readUvarint()
+		if err != nil {
+			return nil, err
+		}
+		client, err := d.readUvarint()
+		if err != nil {
+			return nil, err
+		}
+		clock, err := d.readUvarint()
+		if err != nil {
+			return nil, err
+		}
+		for j := uint64(0); j < structCount; j++ {
+			info, err := d.readByte()
+			if err != nil {
+				return nil, err
+			}
+			id := yjsID{client: client, clock: clock}
+			var current *yjsStruct
+			if info == yjsRefSkip || info&yjsInfoRef == yjsRefGC {
+				length, err := d.readUvarint()
+				if err != nil {
+					return nil, err
+				}
+				current = &yjsStruct{ref: yjsRefGC, id: id, length: length}
+				if info == yjsRefSkip {
+					current.ref = yjsRefSkip
+				}
+			} else if current, err = d.readItem(id, info); err != nil {
+				return nil, err
+			}
+			clock += current.length
+			if current.ref != yjsRefSkip {
+				structs = append(structs, current)
+			}
+		}
+	}
+	return structs, nil
+}
+
+func (d *yjsDecoder) readDeleteSet(deleteSet map[uint64][]yjsDeleteRange) error {
+	clientCount, err := d.readUvarint()
+	if err != nil {
+		return err
+	}
+	for i := uint64(0); i < clientCount; i++ {
+		client, err := d.readUvarint()
+		if err != nil {
+			return err
+		}
+		rangeCount, err := d.readUvarint()
+		if err != nil {
+			return err
+		}
+		for j := uint64(0); j < rangeCount; j++ {
+			clock, err := d.readUvarint()
+			if err != nil {
+				return err
+			}
+			length, err := d.readUvarint()
+			if err != nil {
+				return err
+			}
+			deleteSet[client] = append(deleteSet[client], yjsDeleteRange{clock: clock, length: length})
+		}
+	}
+	return nil
+}
+
+type yjsStructReader struct {
+	structs []*yjsStruct
+}
+
+func (r *yjsStructReader) curr() *yjsStruct {
+	if len(r.structs) == 0 {
+		return nil
+	}
+	return r.structs[0]
+}
+
+func (r *yjsStructReader) next() *yjsStruct {
+	if len(r.structs) > 0 {
+		r.structs = r.structs[1:]
+	}
+	return r.curr()
+}
+
+// yjsStructWriter groups the written structs by client, a new group starts whenever the client changes
+type yjsStructWriter struct {
+	groups  [][]byte
+	counts  []uint64
+	current bytes.Buffer
+	client  uint64
+	written uint64
+}
+
+func (w *yjsStructWriter) flush() {
+	if w.written == 0 {
+		return
+	}
+	w.groups = append(w.groups, append([]byte{}, w.current.Bytes()...))
+	w.counts = append(w.counts, w.written)
+	w.current.Reset()
+	w.written = 0
+}
+
+func (w *yjsStructWriter) write(s *yjsStruct) {
+	if w.written > 0 && w.client != s.id.client {
+		w.flush()
+	}
+	if w.written == 0 {
+		w.client = s.id.client
+		writeYjsUvarint(&w.current, s.id.client)
+		writeYjsUvarint(&w.current, s.id.clock)
+	}
+	s.write(&w.current)
+	w.written++
+}
+
+func (w *yjsStructWriter) finish(buf *bytes.Buffer) {
+	w.flush()
+	writeYjsUvarint(buf, uint64(len(w.groups)))
+	for i, group := range w.groups {
+		writeYjsUvarint(buf, w.counts[i])
+		buf.Write(group)
+	}
+}
+
+// mergeYjsUpdates merges yjs v1 updates into a single update, following Y.mergeUpdates: structs are
+// written once per client and clock, overlapping structs are sliced and the delete sets are joined
+func mergeYjsUpdates(updates [][]byte) ([]byte, error) {
+	readers := make([]*yjsStructReader, 0, len(updates))
+	deleteSet := make(map[uint64][]yjsDeleteRange)
+	for _, update := range updates {
+		decoder := &yjsDecoder{data: update}
+		structs, err := decoder.readStructs()
+		if err != nil {
+			return nil, err
+		}
+		if err = decoder.readDeleteSet(deleteSet); err != nil {
+			return nil, err
+		}
+		readers = append(readers, &yjsStructReader{structs: structs})
+	}
+
+	writer := &yjsStructWriter{}
+	var currWrite *yjsStruct
+	for {
+		// higher clients are written first, each client in clock order
+		active := readers[:0]
+		for _, reader := range readers {
+			if reader.curr() != nil {
+				active = append(active, reader)
+			}
+		}
+		readers = active
+		if len(readers) == 0 {
+			break
+		}
+		sort.SliceStable(readers, func(i, j int) bool {
+			left, right := readers[i].curr(), readers[j].curr()
+			if left.id.client != right.id.client {
+				return left.id.client > right.id.client
+			}
+			return left.id.clock < right.id.clock
+		})
+
+		currReader := readers[0]
+		firstClient := currReader.curr().id.client
+
+		if currWrite != nil {
+			curr := currReader.curr()
+			iterated := false
+			// skip what was written already
+			for curr != nil && curr.end() <= currWrite.end() && curr.id.client >= currWrite.id.client {
+				curr = currReader.next()
+				iterated = true
+			}
+			if curr == nil || curr.id.client != firstClient || (iterated && curr.id.clock > currWrite.end()) {
+				continue
+			}
+
+			if firstClient != currWrite.id.client {
+				writer.write(currWrite)
+				currWrite = curr
+				currReader.next()
+			} else if currWrite.end() < curr.id.clock {
+				// nothing is known about the clocks in between
+				if currWrite.ref == yjsRefSkip {
+					currWrite.length = curr.id.clock - currWrite.id.clock
+				} else {
+					writer.write(currWrite)
+					currWrite = &yjsStruct{ref: yjsRefSkip, id: yjsID{client: firstClient, clock: currWrite.end()}, length: curr.id.clock - currWrite.end()}
+				}
+			} else {
+				if diff := currWrite.end() - curr.id.clock; diff > 0 {
+					if currWrite.ref == yjsRefSkip {
+						currWrite.length -= diff
+					} else {
+						curr = curr.slice(diff)
+					}
+				}
+				if !currWrite.mergeWith(curr) {
+					writer.write(currWrite)
+					currWrite = curr
+					currReader.next()
+				}
+			}
+		} else {
+			currWrite = currReader.curr()
+			currReader.next()
+		}
+
+		for next := currReader.curr(); next != nil && next.id.client == firstClient && next.id.clock == currWrite.end(); next = currReader.next() {
+			writer.write(currWrite)
+			currWrite = next
+		}
+	}
+	if currWrite != nil {
+		writer.write(currWrite)
+	}
+
+	merged := &bytes.Buffer{}
+	writer.finish(merged)
+	writeYjsDeleteSet(merged, deleteSet)
+	return merged.Bytes(), nil
+}
+
+func writeYjsDeleteSet(buf *bytes.Buffer, deleteSet map[uint64][]yjsDeleteRange) {
+	clients := make([]uint64, 0, len(deleteSet))
+	for client := range deleteSet {
+		clients = append(clients, client)
+	}
+	sort.Slice(clients, func(i, j int) bool {
+		return clients[i] > clients[j]
+	})
+
+	writeYjsUvarint(buf, uint64(len(clients)))
+	for _, client := range clients {
+		ranges := deleteSet[client]
+		sort.Slice(ranges, func(i, j int) bool {
+			return ranges[i].clock < ranges[j].clock
+		})
+		merged := ranges[:1]
+		for _, deleted := range ranges[1:] {
+			last := &merged[len(merged)-1]
+			if last.clock+last.length >= deleted.clock {
+				if deleted.clock+deleted.length > last.clock+last.length {
+					last.length = deleted.clock + deleted.length - last.clock
+				}
+			} else {
+				merged = append(merged, deleted)
+			}
+		}
+
+		writeYjsUvarint(buf, client)
+		writeYjsUvarint(buf, uint64(len(merged)))
+		for _, deleted := range merged {
+			writeYjsUvarint(buf, deleted.clock)
+			writeYjsUvarint(buf, deleted.length)
+		}
+	}
+}
+
+func writeYjsUvarint(buf *bytes.Buffer, value uint64) {
+	var encoded [binary.MaxVarintLen64]byte
+	buf.Write(encoded[:binary.PutUvarint(encoded[:], value)])
+}
+
+func readYjsFrame(reader *bytes.Reader) ([]byte, error) {
+	length, err := binary.ReadUvarint(reader)
+	if err != nil {
+		return nil, err
+	}
+	if length > uint64(reader.Len()) {
+		return nil, errors.New("yjs log entry is longer than the log")
+	}
+	frame := make([]byte, length)
+	_, err = reader.Read(frame)
+	return frame, err
+}
+
+// compactYjsLog rewrites a room log as a single update message holding all the updates of the log
+// merged. Sync step 1 messages only carry a state vector and are dropped. Logs holding anything but
+// sync messages are refused.
+func compactYjsLog(log []byte) ([]byte, error) {
+	updates := make([][]byte, 0)
+	reader := bytes.NewReader(log)
+	for reader.Len() > 0 {
+		entry, err := readYjsFrame(reader)
+		if err != nil {
+			return nil, err
+		}
+		message := bytes.NewReader(entry)
+		messageType, err := binary.ReadUvarint(message)
+		if err != nil || messageType != yjsMessageSync {
+			return nil, errors.New("yjs log entry is not a sync message")
+		}
+		syncType, err := binary.ReadUvarint(message)
+		if err != nil {
+			return nil, err
+		}
+		switch syncType {
+		case yjsMessageSyncStep1:
+			continue
+		case yjsMessageSyncStep2, yjsMessageUpdate:
+			update, err := readYjsFrame(message)
+			if err != nil {
+				return nil, err
+			}
+			updates = append(updates, update)
+		default:
+			return nil, fmt.Errorf("unknown yjs sync message %d", syncType)
+		}
+	}
+	if len(updates) == 0 {
+		return []byte{}, nil
+	}
+
+	merged, err := mergeYjsUpdates(updates)
+	if err != nil {
+		return nil, err
+	}
+	message := &bytes.Buffer{}
+	writeYjsUvarint(message, yjsMessageSync)
+	writeYjsUvarint(message, yjsMessageUpdate)
+	writeYjsUvarint(message, uint64(len(merged)))
+	message.Write(merged)
+
+	compacted := &bytes.Buffer{}
+	writeYjsUvarint(compacted, uint64(message.Len()))
+	compacted.Write(message.Bytes())
+	return compacted.Bytes(), nil
+}
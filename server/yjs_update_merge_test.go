@@ -0,0 +1,93 @@
+package server
+
+import (
+	"bytes"
+	"testing"
+)
+
+// updates written by hand in the yjs v1 encoding, client 1 types "ab" and then "c" into the text "t"
+var (
+	yjsTestUpdateAB  = []byte{1, 1, 1, 0, 4, 1, 1, 't', 2, 'a', 'b', 0}
+	yjsTestUpdateC   = []byte{1, 1, 1, 2, 0x84, 1, 1, 1, 'c', 0}
+	yjsTestUpdateABC = []byte{1, 1, 1, 0, 4, 1, 1, 't', 3, 'a', 'b', 'c', 0}
+	yjsTestDeleteA   = []byte{0, 1, 1, 1, 0, 1}
+	yjsTestMergedABC = []byte{1, 2, 1, 0, 4, 1, 1, 't', 2, 'a', 'b', 0x84, 1, 1, 1, 'c', 0}
+)
+
+func yjsTestLogEntry(syncType uint64, payload []byte) []byte {
+	message := &bytes.Buffer{}
+	writeYjsUvarint(message, yjsMessageSync)
+	writeYjsUvarint(message, syncType)
+	writeYjsUvarint(message, uint64(len(payload)))
+	message.Write(payload)
+
+	entry := &bytes.Buffer{}
+	writeYjsUvarint(entry, uint64(message.Len()))
+	entry.Write(message.Bytes())
+	return entry.Bytes()
+}
+
+func TestMergeYjsUpdates(t *testing.T) {
+	tests := []struct {
+		name     string
+		updates  [][]byte
+		expected []byte
+	}{
+		{
+			name:     "consecutive updates",
+			updates:  [][]byte{yjsTestUpdateAB, yjsTestUpdateC},
+			expected: yjsTestMergedABC,
+		},
+		{
+			name:     "an update sent twice and a delete",
+			updates:  [][]byte{yjsTestUpdateAB, yjsTestUpdateC, yjsTestUpdateAB, yjsTestDeleteA},
+			expected: append(append([]byte{}, yjsTestMergedABC[:len(yjsTestMergedABC)-1]...), 1, 1, 1, 0, 1),
+		},
+		{
+			name:     "overlapping items are sliced",
+			updates:  [][]byte{yjsTestUpdateAB, yjsTestUpdateABC},
+			expected: yjsTestMergedABC,
+		},
+		{
+			name:     "higher clients first",
+			updates:  [][]byte{yjsTestUpdateAB, {1, 1, 2, 0, 0, 3, 0}},
+			expected: []byte{2, 1, 2, 0, 0, 3, 1, 1, 0, 4, 1, 1, 't', 2, 'a', 'b', 0},
+		},
+		{
+			name:     "map entries are kept as they are",
+			updates:  [][]byte{{1, 1, 3, 0, 0x28, 1, 1, 'm', 1, 'k', 1, 119, 1, 'v', 0}},
+			expected: []byte{1, 1, 3, 0, 0x28, 1, 1, 'm', 1, 'k', 1, 119, 1, 'v', 0},
+		},
+	}
+
+	for _, test := range tests {
+		merged, err := mergeYjsUpdates(test.updates)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if !bytes.Equal(merged, test.expected) {
+			t.Errorf("%s: merged into %v, expected %v", test.name, merged, test.expected)
+		}
+	}
+
+	if _, err := mergeYjsUpdates([][]byte{[]byte("not an update")}); err == nil {
+		t.Error("expected an invalid update to be refused")
+	}
+}
+
+func TestCompactYjsLog(t *testing.T) {
+	log := append(yjsTestLogEntry(yjsMessageSyncStep1, []byte{0}), yjsTestLogEntry(yjsMessageSyncStep2, yjsTestUpdateAB)...)
+	log = append(log, yjsTestLogEntry(yjsMessageUpdate, yjsTestUpdateC)...)
+
+	compacted, err := compactYjsLog(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := yjsTestLogEntry(yjsMessageUpdate, yjsTestMergedABC); !bytes.Equal(compacted, expected) {
+		t.Fatalf("compacted into %v, expected %v", compacted, expected)
+	}
+
+	if _, err = compactYjsLog([]byte("abc")); err == nil {
+		t.Fatal("expected a log which is not made of sync messages to be refused")
+	}
+}
@@ -242,6 +242,7 @@ func createServer() (hostswitch.HostSwitch, *guerrilla.Daemon, task_scheduler.Ta
 	var certManager *resource.CertificateManager
 	//var imapServer *server2.Server
 	var ftpServer *server2.FtpServer
+	var sftpServer *server.DaptinSftpServer
 	var imapServer *ImapServer.Server
 	var olricDb *olric.EmbeddedClient
 
@@ -277,7 +278,7 @@ func createServer() (hostswitch.HostSwitch, *guerrilla.Daemon, task_scheduler.Ta
 	configStore.SetConfigValueFor("limit.rate", `{"version":"1","limits":{"/live":5000}}`, "backend", transaction)
 	transaction.Commit()
 
-	hostSwitch, mailDaemon, taskScheduler, configStore, certManager, ftpServer, imapServer, olricDb = server.Main(boxRoot, db, tempDir, olricDb, "")
+	hostSwitch, mailDaemon, taskScheduler, configStore, certManager, ftpServer, sftpServer, imapServer, olricDb = server.Main(boxRoot, db, tempDir, olricDb, "")
 
 	rhs := TestRestartHandlerServer{
 		HostSwitch: &hostSwitch,
@@ -290,6 +291,9 @@ func createServer() (hostswitch.HostSwitch, *guerrilla.Daemon, task_scheduler.Ta
 
 		mailDaemon.Shutdown()
 		ftpServer.Stop()
+		if sftpServer != nil {
+			sftpServer.Stop()
+		}
 		imapServer.Close()
 		//err = db.Close()
 		//if err != nil {
@@ -298,7 +302,7 @@ func createServer() (hostswitch.HostSwitch, *guerrilla.Daemon, task_scheduler.Ta
 
 		//db, err = server.GetDbConnection(*dbType, *connectionString)
 
-		hostSwitch, mailDaemon, taskScheduler, configStore, certManager, ftpServer, imapServer, olricDb = server.Main(boxRoot, db, tempDir, olricDb, "")
+		hostSwitch, mailDaemon, taskScheduler, configStore, certManager, ftpServer, sftpServer, imapServer, olricDb = server.Main(boxRoot, db, tempDir, olricDb, "")
 		rhs.HostSwitch = &hostSwitch
 	})
 